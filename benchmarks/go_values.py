@@ -0,0 +1,48 @@
+"""Benchmark the Go runtime's Value representation.
+
+Runs the Go benchmarks in value_bench_test.go (numeric loops over int,
+float and bool Values) against the current coreil_runtime.go and prints
+`go test -bench` output, including allocations per op.
+
+Usage:
+    python benchmarks/go_values.py [-bench REGEXP] [-count N]
+"""
+
+from __future__ import annotations
+
+import argparse
+import shutil
+import subprocess
+import sys
+import tempfile
+from pathlib import Path
+
+from english_compiler.coreil.emit_go import get_runtime_path
+
+BENCH_FILE = Path(__file__).parent / "value_bench_test.go"
+
+
+def main() -> int:
+    parser = argparse.ArgumentParser(description=__doc__.splitlines()[0])
+    parser.add_argument("-bench", default=".", help="benchmarks to run (default: all)")
+    parser.add_argument("-count", type=int, default=1, help="runs of each benchmark")
+    args = parser.parse_args()
+    if shutil.which("go") is None:
+        print("go is not installed", file=sys.stderr)
+        return 1
+    with tempfile.TemporaryDirectory() as tmpdir:
+        tmppath = Path(tmpdir)
+        shutil.copy(get_runtime_path(), tmppath / "coreil_runtime.go")
+        shutil.copy(BENCH_FILE, tmppath / BENCH_FILE.name)
+        # The runtime is package main and has no main of its own
+        (tmppath / "main.go").write_text("package main\n\nfunc main() {}\n", encoding="utf-8")
+        subprocess.run(["go", "mod", "init", "coreil_bench"], cwd=tmppath, capture_output=True, check=True)
+        result = subprocess.run(
+            ["go", "test", "-run", "^$", "-bench", args.bench, "-benchmem", "-count", str(args.count)],
+            cwd=tmppath,
+        )
+    return result.returncode
+
+
+if __name__ == "__main__":
+    sys.exit(main())
@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+// Numeric loops through the same runtime calls generated code makes. Ints,
+// floats and bools are stored inline in Value, so none of them allocate.
+
+var benchSink Value
+
+func BenchmarkIntLoop(b *testing.B) {
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		sum := ValueInt(0)
+		for i := int64(0); i < 10000; i++ {
+			v := ValueInt(i)
+			sum = valueAdd(sum, valueMultiply(v, ValueInt(3)))
+			if valueLessThan(sum, v) {
+				sum = ValueInt(0)
+			}
+		}
+		benchSink = sum
+	}
+}
+
+func BenchmarkFloatLoop(b *testing.B) {
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		sum := ValueFloat(0)
+		for i := int64(0); i < 10000; i++ {
+			v := valueDivide(ValueInt(i), ValueFloat(2))
+			sum = valueAdd(sum, valueMultiply(v, ValueFloat(1.5)))
+			if valueLessThan(sum, v) {
+				sum = ValueFloat(0)
+			}
+		}
+		benchSink = sum
+	}
+}
+
+func BenchmarkBoolLoop(b *testing.B) {
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		flag := ValueBool(false)
+		for i := int64(0); i < 10000; i++ {
+			flag = logicalNot(flag)
+			if valueEqual(flag, ValueBool(i%2 == 0)) {
+				flag = ValueBool(true)
+			}
+		}
+		benchSink = flag
+	}
+}
@@ -5,6 +5,7 @@ from __future__ import annotations
 import argparse
 import datetime
 import json
+import sys
 from pathlib import Path
 
 from english_compiler import __version__
@@ -12,6 +13,9 @@ from english_compiler.cli.config_flow import config_command as _config_command
 from english_compiler.cli.emit_helpers import (
     emit_target_code as _emit_target_code,
 )
+from english_compiler.cli.emit_helpers import (
+    link_imports as _link_imports,
+)
 from english_compiler.cli.emit_helpers import (
     is_tier2_unsupported_error as _is_tier2_unsupported_error,
 )
@@ -66,6 +70,19 @@ from english_compiler.cli.run_targets import (
 from english_compiler.cli.run_targets import (
     run_rust_file as _run_rust_file,
 )
+from english_compiler.cli.build_flow import (
+    build_command as _build_command,
+)
+from english_compiler.cli.fmt_flow import (
+    fmt_command as _fmt_command,
+)
+from english_compiler.cli.profile_flow import (
+    profile_command as _profile_command,
+)
+from english_compiler.cli.test_flow import (
+    test_command as _test_command,
+)
+from english_compiler.coreil.constants import CAPABILITIES
 from english_compiler.settings import load_settings
 
 # Built-in exit commands (instant, no API call)
@@ -97,10 +114,31 @@ def _process_compiled_doc(
 ) -> int:
     """Run optimize/lint/emit/execute flow for a compiled Core IL document."""
     if getattr(args, "optimize", False):
-        from english_compiler.coreil.optimize import optimize
+        from english_compiler.coreil.dead_code import describe_removals
+        from english_compiler.coreil.pass_manager import (
+            PassContext,
+            PassError,
+            PassManager,
+            default_passes,
+        )
 
-        doc = optimize(doc)
-        print("Applied optimization pass")
+        context = PassContext()
+        try:
+            optimized = PassManager(default_passes()).run(doc, context)
+        except PassError as exc:
+            print(f"Skipped optimization: {exc}")
+        else:
+            removals = context.reports.get("dead-code", [])
+            if removals:
+                try:
+                    source_text = source_path.read_text(encoding="utf-8")
+                except OSError:
+                    source_text = None
+                print(f"Removed {len(removals)} dead statement(s):")
+                for line in describe_removals(removals, doc, source_text):
+                    print(f"  {line}")
+            doc = optimized
+            print("Applied optimization pass")
 
     if getattr(args, "lint", False):
         lint_rc = _run_lint_on_doc(doc)
@@ -108,12 +146,17 @@ def _process_compiled_doc(
             return lint_rc
 
     target = getattr(args, "target", "coreil")
+    if not _check_target_builtins(doc, target):
+        return 1
     if not _emit_target_code(
         doc,
         source_path,
         coreil_path,
         target,
         check_freshness=check_freshness,
+        debug=getattr(args, "debug", False),
+        watch=getattr(args, "watch_var", None),
+        grants=getattr(args, "grant", None),
     ):
         return 1
 
@@ -138,6 +181,27 @@ def _process_compiled_doc(
         raise
 
 
+def _check_target_builtins(doc: dict, target: str | None) -> bool:
+    """Report the builtins doc uses that target lacks; True if there are none.
+
+    Catches the program before code generation rather than in the target's
+    compiler. Returns False after printing the violations.
+    """
+    from english_compiler.coreil.builtins import check_builtins
+    from english_compiler.coreil.verify import format_violation
+
+    if target in (None, "coreil"):
+        return True
+    violations = check_builtins(doc, target)
+    if not violations:
+        return True
+    print(f"Error: the program uses builtins the {target} target does not support:")
+    for violation in violations:
+        print(f"  {format_violation(violation)}")
+    print(f"Rerun with --regen to regenerate it for the {target} target.")
+    return False
+
+
 def _load_json_doc(path: Path) -> tuple[object | None, bool]:
     """Load a JSON file with consistent error reporting."""
     try:
@@ -279,9 +343,88 @@ def _handle_watch_mode(args: argparse.Namespace) -> int:
     return watch_and_compile(source_path, compile_single_file)
 
 
+def _watch_command(args: argparse.Namespace) -> int:
+    """Handle the watch subcommand.
+
+    Recompiles and reruns the English file on every save, then shows how
+    the output changed since the previous run. The Core IL is regenerated
+    only when the source changed, and with --go the binary is rebuilt only
+    when the Core IL changed.
+    """
+    import io
+    import time
+    from contextlib import redirect_stdout
+
+    from english_compiler.coreil.interp import run_coreil
+    from english_compiler.watch import diff_output, is_watchfiles_available, watch_and_compile
+
+    if not is_watchfiles_available():
+        print("Error: watchfiles is not installed.")
+        print("Install it with: pip install english-compiler[watch]")
+        return 1
+
+    settings = load_settings()
+    if args.frontend is None:
+        args.frontend = settings.frontend
+    args.regen = args.freeze = False
+    previous_outputs: dict[Path, str] = {}
+
+    def rebuild_and_run(file_path: Path) -> int:
+        try:
+            source_text = file_path.read_text(encoding="utf-8")
+        except OSError as exc:
+            print(f"{file_path}: {exc}")
+            return 1
+        started = time.monotonic()
+        doc, _ = _load_or_generate_coreil(args, file_path, source_text)
+        if doc is None or not _compile_imported_modules(args, file_path, doc):
+            return 1
+        doc = _link_imports(doc, _get_output_path(file_path, "coreil", ".coreil.json").parent)
+        if doc is None:
+            return 1
+        compiled = time.monotonic()
+
+        if args.go:
+            rc, output = _run_go_captured(doc, source_text)
+        else:
+            buf = io.StringIO()
+            with redirect_stdout(buf):
+                try:
+                    rc = run_coreil(doc)
+                except ValueError as exc:
+                    # Tier 2 operations, which run_coreil leaves to the caller
+                    print(exc)
+                    rc = 1
+            output = buf.getvalue()
+        print(output, end="")
+        print(f"(compiled in {compiled - started:.2f}s, ran in {time.monotonic() - compiled:.2f}s)")
+
+        for line in diff_output(previous_outputs.get(file_path), output):
+            print(line)
+        previous_outputs[file_path] = output
+        return rc
+
+    return watch_and_compile(Path(args.file), rebuild_and_run)
+
+
+def _run_go_captured(doc: dict, source_text: str) -> tuple[int, str]:
+    """Run doc as a cached Go binary, returning its exit code and stdout."""
+    import subprocess
+
+    from english_compiler.coreil.go_cache import GoBuildCache
+
+    build = GoBuildCache().build(doc, source_text=source_text)
+    if not build.success:
+        print(f"Go compilation failed:\n{build.error}")
+        return 1, ""
+    result = subprocess.run([str(build.binary_path)], capture_output=True, text=True)
+    if result.stderr:
+        print(result.stderr, end="", file=sys.stderr)
+    return result.returncode, result.stdout
+
+
 def _compile_command(args: argparse.Namespace) -> int:
     from english_compiler.coreil.interp import run_coreil
-    from english_compiler.coreil.validate import validate_coreil
     from english_compiler.frontend import get_frontend
 
     # Handle watch mode first
@@ -323,7 +466,6 @@ def _compile_command(args: argparse.Namespace) -> int:
 
     source_path = Path(args.file)
     coreil_path = _get_output_path(source_path, "coreil", ".coreil.json")
-    lock_path = _get_output_path(source_path, "coreil", ".lock.json")
 
     try:
         source_text = source_path.read_text(encoding="utf-8")
@@ -331,8 +473,6 @@ def _compile_command(args: argparse.Namespace) -> int:
         print(f"{source_path}: {exc}")
         return 1
 
-    source_sha256 = _sha256_bytes(source_text.encode("utf-8"))
-
     # Set up error callback if requested
     error_callback = None
     explain_frontend = None
@@ -344,6 +484,55 @@ def _compile_command(args: argparse.Namespace) -> int:
             print(str(exc))
             return 1
 
+    doc, reused = _load_or_generate_coreil(args, source_path, source_text, explain_frontend)
+    if doc is None:
+        return 1
+    if not _compile_imported_modules(args, source_path, doc):
+        return 1
+    return _process_compiled_doc(
+        args,
+        doc,
+        source_path,
+        coreil_path,
+        run_coreil,
+        error_callback,
+        check_freshness=reused,
+    )
+
+
+def _load_or_generate_coreil(
+    args: argparse.Namespace,
+    source_path: Path,
+    source_text: str,
+    frontend=None,
+    *,
+    coreil_path: Path | None = None,
+) -> tuple[dict | None, bool]:
+    """Return the Core IL for source_text and whether it came from the cache.
+
+    The cached Core IL is reused while the lock file says it was generated
+    from this exact source (unless args.regen). Otherwise it comes from the
+    frontend (or the one named by args.frontend), by way of the CoreILCache
+    for LLM frontends (not read with args.regen), and the lock file is
+    updated. The Core IL is kept in output/coreil/ next to the source
+    unless coreil_path says where. Generation is constrained to the
+    builtins args.target supports, when it names a compiled target. Returns (None, False) after printing the
+    problem on failure.
+    """
+    from english_compiler.coreil.canonical import canonicalize
+    from english_compiler.coreil.validate import validate_coreil
+    from english_compiler.frontend import get_frontend
+    from english_compiler.frontend.base import BaseFrontend
+    from english_compiler.frontend.cache import CoreILCache
+
+    if coreil_path is None:
+        coreil_path = _get_output_path(source_path, "coreil", ".coreil.json")
+        lock_path = _get_output_path(source_path, "coreil", ".lock.json")
+    else:
+        coreil_path.parent.mkdir(parents=True, exist_ok=True)
+        lock_path = coreil_path.with_name(coreil_path.name.removesuffix(".coreil.json") + ".lock.json")
+    source_sha256 = _sha256_bytes(source_text.encode("utf-8"))
+
     lock_doc = _load_json(lock_path)
     reuse_cache = False
     if not args.regen and lock_doc is not None:
@@ -364,46 +553,52 @@ def _compile_command(args: argparse.Namespace) -> int:
         doc = _load_json(coreil_path)
         if doc is None:
             print(f"{coreil_path}: invalid json")
-            return 1
-        return _process_compiled_doc(
-            args,
-            doc,
-            source_path,
-            coreil_path,
-            run_coreil,
-            error_callback,
-            check_freshness=True,
-        )
+        return doc, True
 
     if args.freeze:
         print(f"freeze enabled: regeneration required for {source_path}")
-        return 1
+        return None, False
 
     # Get frontend (auto-detect if not specified, reuse if already created for explain_errors)
-    if explain_frontend is not None:
-        frontend = explain_frontend
-    else:
-        frontend_name = args.frontend
+    if frontend is None:
         try:
-            frontend = get_frontend(frontend_name)
+            frontend = get_frontend(args.frontend)
         except RuntimeError as exc:
             print(str(exc))
-            return 1
+            return None, False
 
-    print(f"Regenerating Core IL for {source_path} using {frontend.get_model_name()}")
-    try:
-        doc = frontend.generate_coreil_from_text(source_text)
-    except RuntimeError as exc:
-        print(f"Frontend error: {exc}")
-        return 1
     model_name = frontend.get_model_name()
-    errors = validate_coreil(doc)
-    if errors:
-        _print_validation_errors(errors)
-        return 1
+    target = getattr(args, "target", None)
+    if target == "coreil":
+        target = None
+    # Only LLM frontends are worth caching; the mock one is deterministic
+    cache = CoreILCache.from_env() if isinstance(frontend, BaseFrontend) else None
+    cache_key = cache.key(source_text, model_name, target) if cache else None
+    hit = cache.get(cache_key) if cache and not args.regen else None
+    if hit is not None:
+        print(f"Using cached Core IL for {source_path} from the {hit.origin} cache")
+        doc = hit.coreil
+    else:
+        print(f"Regenerating Core IL for {source_path} using {model_name}")
+        try:
+            doc = frontend.generate_coreil_from_text(
+                source_text, max_retries=getattr(args, "repair_attempts", 3), target=target
+            )
+        except RuntimeError as exc:
+            print(f"Frontend error: {exc}")
+            return None, False
+        errors = validate_coreil(doc)
+        if errors:
+            _print_validation_errors(errors)
+            return None, False
+        doc = canonicalize(doc)
+        if cache:
+            cache.put(cache_key, doc)
+    if cache and cache.remote_error:
+        print(f"Remote cache unavailable: {cache.remote_error}")
 
     if not _write_json(coreil_path, doc):
-        return 1
+        return None, False
 
     lock_doc = {
         "source_sha256": source_sha256,
@@ -413,17 +608,54 @@ def _compile_command(args: argparse.Namespace) -> int:
         "created_at": datetime.datetime.now(datetime.timezone.utc).isoformat(),
     }
     if not _write_json(lock_path, lock_doc):
-        return 1
+        return None, False
+    return doc, False
 
-    return _process_compiled_doc(
-        args,
-        doc,
-        source_path,
-        coreil_path,
-        run_coreil,
-        error_callback,
-        check_freshness=False,
-    )
+
+def _compile_imported_modules(
+    args: argparse.Namespace,
+    source_path: Path,
+    doc: dict,
+    coreil_dir: Path | None = None,
+    active: frozenset[Path] = frozenset(),
+) -> bool:
+    """Compile the English modules doc imports, each on its own.
+
+    An Import of "lib.shapes" from main.txt names the module lib/shapes.txt
+    next to it. Its Core IL goes where resolve_imports() looks for it, under
+    the importer's output/coreil/ (here output/coreil/lib/shapes.coreil.json),
+    and is reused while the module's source is unchanged, so editing one
+    file recompiles only that file. Modules without an English source must
+    already be compiled, and standard library modules (std.*) ship with the
+    package. Returns False after printing the problem on failure.
+    """
+    from english_compiler.coreil.stdlib import is_stdlib_import
+
+    if coreil_dir is None:
+        coreil_dir = _get_output_path(source_path, "coreil", ".coreil.json").parent
+    active = active | {source_path.resolve()}
+    for stmt in doc.get("body", []):
+        if not isinstance(stmt, dict) or stmt.get("type") != "Import" or not isinstance(stmt.get("path"), str):
+            continue
+        if is_stdlib_import(stmt["path"]):
+            continue
+        relative = stmt["path"].replace(".", "/")
+        module_source = source_path.parent / f"{relative}.txt"
+        # Cycles are left for resolve_imports() to report
+        if not module_source.is_file() or module_source.resolve() in active:
+            continue
+        try:
+            module_text = module_source.read_text(encoding="utf-8")
+        except OSError as exc:
+            print(f"{module_source}: {exc}")
+            return False
+        module_coreil = coreil_dir / f"{relative}.coreil.json"
+        module_doc, _ = _load_or_generate_coreil(args, module_source, module_text, coreil_path=module_coreil)
+        if module_doc is None:
+            return False
+        if not _compile_imported_modules(args, module_source, module_doc, module_coreil.parent, active):
+            return False
+    return True
 
 
 def _make_error_callback(frontend, source_text: str | None = None):
@@ -446,6 +678,14 @@ def _make_error_callback(frontend, source_text: str | None = None):
 
 
 def _run_command(args: argparse.Namespace) -> int:
+    """Handle the run subcommand.
+
+    Runs the Core IL file (or stdin, given -) in the interpreter, or with
+    --go as a cached Go binary. Arguments after the file are returned by
+    the program's argv(). Exits 1 on an uncaught error and 3 when --limit
+    is exceeded. With --cross-check it runs both and exits 1 if they
+    diverge.
+    """
     from english_compiler.coreil.interp import run_coreil
 
     # Load settings and apply defaults
@@ -453,10 +693,38 @@ def _run_command(args: argparse.Namespace) -> int:
     frontend_name = args.frontend if args.frontend is not None else settings.frontend
     explain_errors = args.explain_errors or settings.explain_errors
 
-    path = Path(args.file)
-    doc, ok = _load_json_doc(path)
+    if args.file == "-":
+        try:
+            doc, ok = json.load(sys.stdin), True
+        except json.JSONDecodeError as exc:
+            print(f"<stdin>: invalid json: {exc}")
+            return 1
+        base_dir = Path.cwd()
+    else:
+        path = Path(args.file)
+        doc, ok = _load_json_doc(path)
+        base_dir = path.parent
     if not ok:
         return 1
+    if isinstance(doc, dict):
+        # Every way of running sees one program, with the modules inlined
+        doc = _link_imports(doc, base_dir)
+        if doc is None:
+            return 1
+
+    if args.cross_check:
+        return _cross_check_command(args, doc, base_dir)
+    if args.go:
+        return _run_go_command(args, doc)
+    if args.seed is not None:
+        print("--seed requires --go: the interpreter has no random.* builtins")
+        return 1
+    if args.deterministic:
+        print("--deterministic requires --go: the interpreter has no random.* or time builtins")
+        return 1
+    if args.grant:
+        print("--grant requires --go or --cross-check: the interpreter has no sandbox")
+        return 1
 
     error_callback = None
     if explain_errors:
@@ -469,7 +737,89 @@ def _run_command(args: argparse.Namespace) -> int:
             return 1
         error_callback = _make_error_callback(frontend)
 
-    return run_coreil(doc, error_callback=error_callback, base_dir=path.parent)
+    step_callback = None
+    if args.trace:
+        def step_callback(stmt, index, local_env, global_env, functions, call_depth):
+            name = stmt.get("name") if isinstance(stmt, dict) else None
+            label = f"{stmt.get('type')} {name}" if isinstance(name, str) else stmt.get("type")
+            print(f"trace: {'  ' * call_depth}{label}", file=sys.stderr)
+
+    try:
+        return run_coreil(
+            doc,
+            error_callback=error_callback,
+            step_callback=step_callback,
+            base_dir=base_dir,
+            tail_calls=not args.no_tail_calls,
+            argv=args.args,
+            max_steps=args.limit,
+        )
+    except ValueError as exc:
+        # Tier 2 operations, which run_coreil leaves to the caller; the Go
+        # runtime implements ExternalCall
+        hint = " Run it with --go." if "ExternalCall" in str(exc) else ""
+        print(f"{exc}{hint}")
+        return 1
+
+
+def _run_go_command(args: argparse.Namespace, doc: dict) -> int:
+    """Run doc as a cached Go binary, passing the run options via COREIL_*."""
+    from english_compiler.cli.run_targets import run_go_binary
+    from english_compiler.coreil.diagnostics import format_diagnostics
+    from english_compiler.coreil.verify import verify_coreil
+
+    violations = verify_coreil(doc, target="go")
+    if violations:
+        print("invalid program:")
+        for line in format_diagnostics(doc, violations):
+            print(f"  {line}")
+        return 1
+    env = {}
+    if args.trace:
+        env["COREIL_TRACE"] = "1"
+    if args.limit is not None:
+        env["COREIL_MAX_STEPS"] = str(args.limit)
+    if args.seed is not None:
+        env["COREIL_SEED"] = str(args.seed)
+    return run_go_binary(
+        doc,
+        args.args,
+        env=env,
+        tail_calls=not args.no_tail_calls,
+        grants=args.grant,
+        deterministic=args.deterministic,
+    )
+
+
+def _cross_check_command(args: argparse.Namespace, doc: dict, base_dir: Path) -> int:
+    """Run doc on the interpreter and in Go, reporting the first divergent statement."""
+    from english_compiler.coreil.cross_check import cross_check, format_result
+    from english_compiler.coreil.diagnostics import format_diagnostics
+    from english_compiler.coreil.verify import verify_coreil
+
+    source_text = None
+    if args.source:
+        try:
+            source_text = Path(args.source).read_text(encoding="utf-8")
+        except OSError as exc:
+            print(f"{args.source}: {exc}")
+            return 1
+    violations = verify_coreil(doc, target="go")
+    if violations:
+        print("invalid program:")
+        for line in format_diagnostics(doc, violations, source_text=source_text):
+            print(f"  {line}")
+        return 1
+    result = cross_check(
+        doc,
+        argv=args.args,
+        max_steps=args.limit,
+        tail_calls=not args.no_tail_calls,
+        base_dir=base_dir,
+        grants=args.grant,
+    )
+    print(format_result(result, doc, source_text=source_text))
+    return 0 if result.ok else 1
 
 
 def _debug_command(args: argparse.Namespace) -> int:
@@ -591,6 +941,110 @@ def _explain_command(args: argparse.Namespace) -> int:
     return 0
 
 
+def _lsp_command(args: argparse.Namespace) -> int:
+    """Handle the lsp subcommand: serve the Language Server Protocol on stdio."""
+    from english_compiler.frontend import get_frontend
+    from english_compiler.lsp import serve
+
+    frontend_name = args.frontend if args.frontend is not None else load_settings().frontend
+    return serve(lambda: get_frontend(frontend_name))
+
+
+def _kernel_command(args: argparse.Namespace) -> int:
+    """Handle the kernel subcommand: install or run the Jupyter kernel."""
+    from english_compiler.frontend import get_frontend
+    from english_compiler.kernel import install_kernel_spec, is_zmq_available, serve
+
+    if args.install:
+        spec_dir = install_kernel_spec(args.frontend, prefix=args.prefix, grants=args.grant)
+        print(f"Installed the English kernel in {spec_dir}")
+        if not is_zmq_available():
+            print("The kernel needs pyzmq: pip install english-compiler[jupyter]")
+        return 0
+    if args.connection_file is None:
+        print("kernel: -f CONNECTION_FILE is required (Jupyter passes it when it starts the kernel)")
+        return 1
+    if not is_zmq_available():
+        print("Error: pyzmq is not installed.")
+        print("Install it with: pip install english-compiler[jupyter]")
+        return 1
+    frontend_name = args.frontend if args.frontend is not None else load_settings().frontend
+    try:
+        frontend = get_frontend(frontend_name)
+    except RuntimeError as exc:
+        print(str(exc))
+        return 1
+    return serve(Path(args.connection_file), frontend, grants=args.grant)
+
+
+def _serve_command(args: argparse.Namespace) -> int:
+    """Handle the serve subcommand: serve the evaluation service over gRPC."""
+    from english_compiler.frontend import get_frontend
+    from english_compiler.service import Evaluator, ServerLimits, is_grpc_available, serve
+
+    if not is_grpc_available():
+        print("Error: grpcio is not installed.")
+        print("Install it with: pip install english-compiler[grpc]")
+        return 1
+    frontend_name = args.frontend if args.frontend is not None else load_settings().frontend
+    limits = ServerLimits(
+        max_steps=args.max_steps,
+        max_memory_bytes=args.max_memory,
+        max_collection_size=args.max_collection_size,
+        timeout_seconds=args.max_timeout,
+        max_output_bytes=args.max_output,
+    )
+    evaluator = Evaluator(lambda: get_frontend(frontend_name), limits, grants=args.grant)
+    return serve(args.grpc, evaluator, workers=args.workers)
+
+
+def _stdlib_command(args: argparse.Namespace) -> int:
+    """Handle the stdlib subcommand: print the standard library reference."""
+    from english_compiler.coreil.stdlib import (
+        STDLIB_VERSION,
+        load_stdlib_module,
+        stdlib_modules,
+        stdlib_reference,
+    )
+
+    if args.module is None:
+        print(stdlib_reference(), end="")
+        return 0
+    name = args.module.removeprefix("std.")
+    try:
+        module = load_stdlib_module(name)
+    except KeyError:
+        print(f"No standard library module '{args.module}' (available: {', '.join(stdlib_modules())})")
+        return 1
+    print(f"{module.import_path} (std {STDLIB_VERSION}): {module.doc}")
+    for export in module.exports:
+        print(f"  {export.signature(name)}")
+        print(f"      {export.doc}")
+    return 0
+
+
+def _builtins_command(args: argparse.Namespace) -> int:
+    """Handle the builtins subcommand: list the runtime builtins."""
+    from english_compiler.coreil.builtins import BUILTINS, TARGETS, builtins_for, registry_json
+    from english_compiler.coreil.versions import COREIL_VERSION
+
+    version = args.coreil_version or COREIL_VERSION
+    if args.json:
+        print(json.dumps(registry_json(args.target, version), indent=2))
+        return 0
+    builtins = BUILTINS if args.target is None else builtins_for(args.target, version)
+    for builtin in builtins:
+        notes = [builtin.kind, "pure" if builtin.pure else "effects", f"since {builtin.since}"]
+        if builtin.until:
+            notes.append(f"until {builtin.until}")
+        if builtin.capability:
+            notes.append(f"needs {builtin.capability}")
+        if args.target is None:
+            notes.append(f"targets: {', '.join(t for t in TARGETS if t in builtin.targets)}")
+        print(f"{builtin.signature()}  [{'; '.join(notes)}]")
+    return 0
+
+
 def main(argv: list[str] | None = None) -> int:
     parser = argparse.ArgumentParser(prog="english-compiler")
     parser.add_argument(
@@ -623,6 +1077,13 @@ def main(argv: list[str] | None = None) -> int:
         action="store_true",
         help="Fail if regeneration would be required",
     )
+    compile_parser.add_argument(
+        "--repair-attempts",
+        type=int,
+        default=3,
+        metavar="N",
+        help="Times to send Core IL that fails verification back to the frontend for repair (default: 3)",
+    )
     compile_parser.add_argument(
         "--experimental",
         action="store_true",
@@ -649,10 +1110,34 @@ def main(argv: list[str] | None = None) -> int:
         action="store_true",
         help="Run optimization pass on Core IL before codegen",
     )
+    compile_parser.add_argument(
+        "--debug",
+        action="store_true",
+        help="Build Go output for the step debugger (set COREIL_DAP=host:port to attach a DAP client)",
+    )
+    compile_parser.add_argument(
+        "--watch-var",
+        action="append",
+        metavar="NAME",
+        help="Trace assignments to a variable and mutations of the container it holds (Go target; repeatable)",
+    )
+    compile_parser.add_argument(
+        "--grant",
+        action="append",
+        choices=sorted(CAPABILITIES),
+        metavar="CAPABILITY",
+        help="Let the program use a capability: io.read, io.write, net, exec, env or notify "
+        "(Go target; repeatable). Side effects not granted raise a PermissionError",
+    )
     compile_parser.set_defaults(func=_compile_command)
 
     run_parser = subparsers.add_parser("run", help="Run a Core IL file")
-    run_parser.add_argument("file", help="Path to the Core IL JSON file")
+    run_parser.add_argument("file", help="Path to the Core IL JSON file, or - to read it from stdin")
+    run_parser.add_argument(
+        "args",
+        nargs=argparse.REMAINDER,
+        help="Arguments for the program, returned by argv()",
+    )
     run_parser.add_argument(
         "--explain-errors",
         action="store_true",
@@ -664,8 +1149,78 @@ def main(argv: list[str] | None = None) -> int:
         default=None,
         help="Frontend to use for error explanation (default: auto-detect)",
     )
+    run_parser.add_argument(
+        "--no-tail-calls",
+        action="store_true",
+        help="Keep a frame for every call instead of reusing it for tail calls (for debugging)",
+    )
+    run_parser.add_argument(
+        "--go",
+        action="store_true",
+        help="Run with the Go backend; the binary is cached, so later runs skip the build",
+    )
+    run_parser.add_argument(
+        "--trace",
+        action="store_true",
+        help="Print each statement as it runs (with --go: each function call and ExternalCall) to stderr",
+    )
+    run_parser.add_argument(
+        "--limit",
+        type=int,
+        default=None,
+        metavar="STEPS",
+        help="Stop with exit code 3 after this many loop iterations plus function calls",
+    )
+    run_parser.add_argument(
+        "--seed",
+        type=int,
+        default=None,
+        help="Seed the random.* builtins (requires --go)",
+    )
+    run_parser.add_argument(
+        "--deterministic",
+        action="store_true",
+        help="Freeze the clock, seed the random.* builtins and refuse host-dependent builtins (requires --go)",
+    )
+    run_parser.add_argument(
+        "--grant",
+        action="append",
+        choices=sorted(CAPABILITIES),
+        metavar="CAPABILITY",
+        help="Let the program use a capability: io.read, io.write, net, exec, env or notify "
+        "(requires --go or --cross-check; repeatable)",
+    )
+    run_parser.add_argument(
+        "--cross-check",
+        action="store_true",
+        help="Run on both the interpreter and Go, comparing output statement by statement, and report the first divergent statement",
+    )
+    run_parser.add_argument(
+        "--source",
+        default=None,
+        help="English source file, to name the divergent statement's sentence (with --cross-check)",
+    )
     run_parser.set_defaults(func=_run_command)
 
+    # Watch subcommand
+    watch_parser = subparsers.add_parser(
+        "watch",
+        help="Recompile and rerun an English source file on every save, showing how the output changed",
+    )
+    watch_parser.add_argument("file", help="Path to the source text file, or a directory of them")
+    watch_parser.add_argument(
+        "--frontend",
+        choices=["mock", "claude", "openai", "gemini", "qwen"],
+        default=None,
+        help="Frontend to use (default: auto-detect based on available API keys)",
+    )
+    watch_parser.add_argument(
+        "--go",
+        action="store_true",
+        help="Run with the Go backend; the binary is rebuilt only when the Core IL changes",
+    )
+    watch_parser.set_defaults(func=_watch_command)
+
     # Config subcommand
     config_parser = subparsers.add_parser(
         "config", help="Manage configuration settings"
@@ -732,6 +1287,20 @@ def main(argv: list[str] | None = None) -> int:
     )
     explain_parser.set_defaults(func=_explain_command)
 
+    # Fmt subcommand
+    fmt_parser = subparsers.add_parser(
+        "fmt", help="Rewrite Core IL files in canonical form"
+    )
+    fmt_parser.add_argument(
+        "files", nargs="+", help="Core IL JSON files, or - to format stdin to stdout"
+    )
+    fmt_parser.add_argument(
+        "--check",
+        action="store_true",
+        help="List files that are not in canonical form instead of rewriting them (exit 1 if any)",
+    )
+    fmt_parser.set_defaults(func=_fmt_command)
+
     # Lint subcommand
     lint_parser = subparsers.add_parser(
         "lint", help="Run static analysis on a Core IL file"
@@ -751,6 +1320,205 @@ def main(argv: list[str] | None = None) -> int:
     debug_parser.add_argument("file", help="Path to the Core IL JSON file")
     debug_parser.set_defaults(func=_debug_command)
 
+    # Test subcommand
+    test_parser = subparsers.add_parser(
+        "test", help="Run test_* functions in a Core IL file (Go backend)"
+    )
+    test_parser.add_argument("file", help="Path to the Core IL JSON file")
+    test_parser.add_argument(
+        "--coverage",
+        action="store_true",
+        help="Report which statements the tests executed (writes .coverage.json and .coverage.html)",
+    )
+    test_parser.add_argument(
+        "--source",
+        default=None,
+        help="English source file, to map coverage back to sentences",
+    )
+    test_parser.set_defaults(func=_test_command)
+
+    # Profile subcommand
+    profile_parser = subparsers.add_parser(
+        "profile",
+        help="Run a Core IL file with CPU and allocation profiling (Go backend)",
+    )
+    profile_parser.add_argument("file", help="Path to the Core IL JSON file")
+    profile_parser.set_defaults(func=_profile_command)
+
+    # Build subcommand
+    build_parser = subparsers.add_parser(
+        "build",
+        help="Package a Core IL file as a self-contained executable (Go backend)",
+    )
+    build_parser.add_argument("file", help="Path to the Core IL JSON file")
+    build_parser.add_argument(
+        "--output",
+        "-o",
+        default=None,
+        help="Executable path (default: next to the Core IL file, without its extension)",
+    )
+    build_parser.add_argument(
+        "--source",
+        default=None,
+        help="English source file, so runtime errors name the sentence",
+    )
+    build_parser.add_argument(
+        "--os",
+        default=None,
+        help="Target operating system, as a GOOS value (e.g. linux, darwin, windows)",
+    )
+    build_parser.add_argument(
+        "--arch",
+        default=None,
+        help="Target architecture, as a GOARCH value (e.g. amd64, arm64)",
+    )
+    build_parser.set_defaults(func=_build_command)
+
+    # LSP subcommand
+    lsp_parser = subparsers.add_parser(
+        "lsp",
+        help="Run a language server for English source files over stdio",
+    )
+    lsp_parser.add_argument(
+        "--frontend",
+        choices=["mock", "claude", "openai", "gemini", "qwen"],
+        default=None,
+        help="Frontend to use (default: auto-detect based on available API keys)",
+    )
+    lsp_parser.set_defaults(func=_lsp_command)
+
+    # Kernel subcommand
+    kernel_parser = subparsers.add_parser(
+        "kernel",
+        help="Run the Jupyter kernel for English notebooks, or install it with --install",
+    )
+    kernel_parser.add_argument(
+        "-f",
+        dest="connection_file",
+        default=None,
+        help="Jupyter connection file (passed by Jupyter)",
+    )
+    kernel_parser.add_argument(
+        "--install",
+        action="store_true",
+        help="Register the kernel with Jupyter for the current user",
+    )
+    kernel_parser.add_argument(
+        "--prefix",
+        default=None,
+        help="Install the kernel under this prefix instead (e.g. a virtual environment's sys.prefix)",
+    )
+    kernel_parser.add_argument(
+        "--frontend",
+        choices=["mock", "claude", "openai", "gemini", "qwen"],
+        default=None,
+        help="Frontend to use (default: auto-detect based on available API keys)",
+    )
+    kernel_parser.add_argument(
+        "--grant",
+        action="append",
+        choices=sorted(CAPABILITIES),
+        metavar="CAPABILITY",
+        help="Let cells use a capability: io.read, io.write, net, exec, env or notify (repeatable)",
+    )
+    kernel_parser.set_defaults(func=_kernel_command)
+
+    # Serve subcommand
+    serve_parser = subparsers.add_parser(
+        "serve",
+        help="Serve CompileAndRun and Eval RPCs for running programs remotely",
+    )
+    serve_parser.add_argument(
+        "--grpc",
+        required=True,
+        metavar="ADDRESS",
+        help="Address to serve gRPC on (e.g. [::]:50051)",
+    )
+    serve_parser.add_argument(
+        "--frontend",
+        choices=["mock", "claude", "openai", "gemini", "qwen"],
+        default=None,
+        help="Frontend for CompileAndRun (default: auto-detect based on available API keys)",
+    )
+    serve_parser.add_argument(
+        "--workers",
+        type=int,
+        default=8,
+        help="Requests to run at once (default: 8)",
+    )
+    serve_parser.add_argument(
+        "--max-steps",
+        type=int,
+        default=0,
+        help="Most loop iterations and calls a request may use (default: no limit)",
+    )
+    serve_parser.add_argument(
+        "--max-memory",
+        type=int,
+        default=256 * 1024 * 1024,
+        help="Most bytes a request may allocate (default: 256 MiB; 0 for no limit)",
+    )
+    serve_parser.add_argument(
+        "--max-collection-size",
+        type=int,
+        default=0,
+        help="Most items a request may put in one collection (default: no limit)",
+    )
+    serve_parser.add_argument(
+        "--max-timeout",
+        type=float,
+        default=30.0,
+        help="Most seconds a request may run (default: 30; 0 for no limit)",
+    )
+    serve_parser.add_argument(
+        "--max-output",
+        type=int,
+        default=1024 * 1024,
+        help="Most bytes of output a request may print (default: 1 MiB; 0 for no limit)",
+    )
+    serve_parser.add_argument(
+        "--grant",
+        action="append",
+        choices=sorted(CAPABILITIES),
+        metavar="CAPABILITY",
+        help="Let every request use a capability: io.read, io.write, net, exec, env or notify "
+        "(repeatable; by default requests get none)",
+    )
+    serve_parser.set_defaults(func=_serve_command)
+
+    # Stdlib subcommand
+    stdlib_parser = subparsers.add_parser(
+        "stdlib",
+        help="Show the standard library modules programs can import",
+    )
+    stdlib_parser.add_argument(
+        "module",
+        nargs="?",
+        help="Module to describe (e.g. strings or std.strings; default: all of them)",
+    )
+    stdlib_parser.set_defaults(func=_stdlib_command)
+
+    # Builtins subcommand
+    builtins_parser = subparsers.add_parser(
+        "builtins",
+        help="List the builtins the runtimes provide, and which targets support them",
+    )
+    builtins_parser.add_argument(
+        "--target",
+        choices=["coreil", "python", "javascript", "cpp", "rust", "go", "wasm"],
+        default=None,
+        help="Only list the builtins this target supports (default: all of them)",
+    )
+    builtins_parser.add_argument(
+        "--coreil-version",
+        default=None,
+        help="With --target, the Core IL version of the program (default: the current one)",
+    )
+    builtins_parser.add_argument(
+        "--json", action="store_true", help="Print the machine-readable registry"
+    )
+    builtins_parser.set_defaults(func=_builtins_command)
+
     args = parser.parse_args(argv)
     return args.func(args)
 
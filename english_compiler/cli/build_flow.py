@@ -0,0 +1,59 @@
+"""CLI build subcommand handlers."""
+
+from __future__ import annotations
+
+import argparse
+import json
+import sys
+from pathlib import Path
+
+
+def build_command(args: argparse.Namespace) -> int:
+    """Handle the build subcommand.
+
+    Compiles the Core IL file with the Go backend into a single
+    self-contained executable, with the Core IL embedded, that runs without
+    the Go toolchain. It is written next to the Core IL file unless
+    --output says otherwise; --os and --arch cross-compile.
+    """
+    from english_compiler.coreil.go_package import build_go_executable
+
+    path = Path(args.file)
+    try:
+        with path.open("r", encoding="utf-8") as handle:
+            doc = json.load(handle)
+    except OSError as exc:
+        print(f"{path}: {exc}")
+        return 1
+    except json.JSONDecodeError as exc:
+        print(f"{path}: invalid json: {exc}")
+        return 1
+
+    source_text = None
+    if getattr(args, "source", None):
+        try:
+            source_text = Path(args.source).read_text(encoding="utf-8")
+        except OSError as exc:
+            print(f"{args.source}: {exc}")
+            return 1
+
+    goos = getattr(args, "os", None)
+    if args.output:
+        output_path = Path(args.output)
+    else:
+        stem = path.name.removesuffix(".json").removesuffix(".coreil")
+        windows = goos == "windows" or (goos is None and sys.platform == "win32")
+        output_path = path.with_name(stem + (".exe" if windows else ""))
+
+    result = build_go_executable(
+        doc,
+        output_path,
+        goos=goos,
+        goarch=getattr(args, "arch", None),
+        source_text=source_text,
+    )
+    if not result.success:
+        print(f"{path}: {result.error}")
+        return 1
+    print(f"Built {result.executable_path}")
+    return 0
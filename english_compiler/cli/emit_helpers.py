@@ -42,17 +42,49 @@ def run_tier2_fallback(
     return runner(output_path)
 
 
+def link_imports(doc: dict, base_dir: Path) -> dict | None:
+    """Resolve doc's imports against the modules in base_dir.
+
+    Returns the flattened document (doc itself if it imports nothing), or
+    None after printing why the modules could not be linked.
+    """
+    from english_compiler.coreil.module import (
+        CircularImportError,
+        ModuleNotFoundError,
+        resolve_imports,
+    )
+
+    try:
+        return resolve_imports(doc, base_dir=base_dir)
+    except (CircularImportError, ModuleNotFoundError, ValueError) as exc:
+        print(f"Import error: {exc}")
+        return None
+
+
 def emit_target_code(
     doc: dict,
     source_path: Path,
     coreil_path: Path,
     target: str,
     check_freshness: bool = False,
+    debug: bool = False,
+    watch: list[str] | None = None,
+    grants: list[str] | None = None,
 ) -> bool:
-    """Emit code for the specified target."""
+    """Emit code for the specified target.
+
+    With debug=True, Go output is built for the step debugger, watch names
+    variables whose writes it traces, and grants names the capabilities the
+    program may use (see emit_go); other targets ignore all three.
+    """
     if target in ("coreil", ""):
         return True
 
+    # Targets other than Core IL get one program with the modules inlined
+    doc = link_imports(doc, coreil_path.parent)
+    if doc is None:
+        return False
+
     import shutil
 
     from english_compiler.coreil.emit import emit_python
@@ -100,7 +132,18 @@ def emit_target_code(
         def copy_go_runtime(runtime_dir: Path) -> None:
             shutil.copy(get_go_runtime_path(), runtime_dir / "coreil_runtime.go")
 
-        target_specs["go"] = ("go", ".go", "Go", emit_go, copy_go_runtime)
+        # Runtime errors name the English sentence being executed
+        source_text = None
+        if not source_path.name.endswith(".json"):
+            try:
+                source_text = source_path.read_text(encoding="utf-8")
+            except OSError:
+                pass
+
+        def emit_go_with_source(doc: dict) -> tuple[str, dict[int, list[int]]]:
+            return emit_go(doc, source_text=source_text, debug=debug, watch=watch, grants=grants)
+
+        target_specs["go"] = ("go", ".go", "Go", emit_go_with_source, copy_go_runtime)
 
     target_spec = target_specs.get(target)
     if target_spec is None:
@@ -0,0 +1,67 @@
+"""CLI fmt subcommand handlers."""
+
+from __future__ import annotations
+
+import argparse
+import json
+import sys
+from pathlib import Path
+
+
+def fmt_command(args: argparse.Namespace) -> int:
+    """Handle the fmt subcommand.
+
+    Rewrites each Core IL file in canonical form (see coreil/canonical.py),
+    or with --check only lists the files that are not, exiting 1 if any.
+    A file named - is read from stdin and written to stdout.
+    """
+    from english_compiler.coreil.canonical import format_coreil
+
+    rc = 0
+    for name in args.files:
+        if name == "-":
+            try:
+                doc = json.load(sys.stdin)
+            except json.JSONDecodeError as exc:
+                print(f"<stdin>: invalid json: {exc}", file=sys.stderr)
+                rc = 1
+                continue
+            if not isinstance(doc, dict):
+                print("<stdin>: Core IL must be a JSON object", file=sys.stderr)
+                rc = 1
+                continue
+            sys.stdout.write(format_coreil(doc))
+            continue
+
+        path = Path(name)
+        try:
+            text = path.read_text(encoding="utf-8")
+            doc = json.loads(text)
+        except OSError as exc:
+            print(f"{path}: {exc}")
+            rc = 1
+            continue
+        except json.JSONDecodeError as exc:
+            print(f"{path}: invalid json: {exc}")
+            rc = 1
+            continue
+        if not isinstance(doc, dict):
+            print(f"{path}: Core IL must be a JSON object")
+            rc = 1
+            continue
+
+        formatted = format_coreil(doc)
+        if formatted == text:
+            continue
+        if args.check:
+            print(f"{path}: not canonical")
+            rc = 1
+            continue
+        try:
+            path.write_text(formatted, encoding="utf-8")
+        except OSError as exc:
+            print(f"{path}: {exc}")
+            rc = 1
+            continue
+        print(f"Formatted {path}")
+    return rc
@@ -0,0 +1,64 @@
+"""CLI profile subcommand handlers."""
+
+from __future__ import annotations
+
+import argparse
+import json
+import shutil
+import tempfile
+from pathlib import Path
+
+
+def profile_command(args: argparse.Namespace) -> int:
+    """Handle the profile subcommand.
+
+    Compiles the Core IL file to Go with profiling enabled, runs it, and
+    prints where CPU time and allocations went, by IL function and by
+    builtin operation category. The pprof profiles are written next to the
+    Core IL file as <name>.cpu.pprof and <name>.allocs.pprof for use with
+    go tool pprof.
+    """
+    from english_compiler.cli.run_targets import run_go_file
+    from english_compiler.coreil.emit_go import emit_go
+    from english_compiler.coreil.profile import build_report, format_summary
+
+    path = Path(args.file)
+    try:
+        with path.open("r", encoding="utf-8") as handle:
+            doc = json.load(handle)
+    except OSError as exc:
+        print(f"{path}: {exc}")
+        return 1
+    except json.JSONDecodeError as exc:
+        print(f"{path}: invalid json: {exc}")
+        return 1
+
+    try:
+        code, _ = emit_go(doc, profile=True)
+    except Exception as exc:
+        print(f"{path}: Go codegen failed: {exc}")
+        return 1
+
+    stem = path.name.removesuffix(".json").removesuffix(".coreil")
+    cpu_path = path.with_name(stem + ".cpu.pprof")
+    allocs_path = path.with_name(stem + ".allocs.pprof")
+    with tempfile.TemporaryDirectory() as tmp_dir:
+        tmp = Path(tmp_dir)
+        go_path = tmp / "main.go"
+        go_path.write_text(code, encoding="utf-8")
+        profile_dir = tmp / "profile"
+        profile_dir.mkdir()
+        rc = run_go_file(go_path, env={"COREIL_PROFILE": str(profile_dir)})
+        if not (profile_dir / "cpu.pprof").exists():
+            return rc
+        try:
+            shutil.copy(profile_dir / "cpu.pprof", cpu_path)
+            shutil.copy(profile_dir / "allocs.pprof", allocs_path)
+        except OSError as exc:
+            print(f"{path.parent}: {exc}")
+            return 1
+
+    print()
+    print(format_summary(build_report(doc, cpu_path, allocs_path)))
+    print(f"Profiles written to {cpu_path} and {allocs_path}")
+    return rc
@@ -115,3 +115,66 @@ def run_cpp_file(cpp_path: Path) -> int:
     finally:
         Path(exe_path).unlink(missing_ok=True)
 
+
+
+def run_go_file(go_path: Path, env: dict[str, str] | None = None) -> int:
+    """Run a Go file together with the Go runtime and return exit code.
+
+    The binary comes from the Go build cache (see go_cache), so running
+    the same generated code again skips go build. env, if given, adds
+    variables to the program's environment.
+    """
+    import os
+    import shutil
+    import subprocess
+
+    if shutil.which("go") is None:
+        print("Error: go not found")
+        return 1
+
+    from english_compiler.coreil.go_cache import GoBuildCache
+
+    build = GoBuildCache().build_source(go_path.read_text(encoding="utf-8"))
+    if not build.success:
+        print(f"Go compilation failed:\n{build.error}")
+        return 1
+
+    try:
+        result = subprocess.run(
+            [str(build.binary_path)],
+            capture_output=False,
+            timeout=120,
+            env={**os.environ, **env} if env else None,
+        )
+        return result.returncode
+    except subprocess.TimeoutExpired:
+        print("Go execution timeout")
+        return 1
+
+
+def run_go_binary(
+    doc: dict,
+    argv: list[str],
+    env: dict[str, str] | None = None,
+    **emit_options: object,
+) -> int:
+    """Run a Core IL document as a Go binary from the build cache.
+
+    argv is passed to the program, and env, if given, adds variables to
+    its environment. Returns the program's exit code.
+    """
+    import os
+    import subprocess
+
+    from english_compiler.coreil.go_cache import GoBuildCache
+
+    build = GoBuildCache().build(doc, **emit_options)
+    if not build.success:
+        print(f"Go compilation failed:\n{build.error}")
+        return 1
+    result = subprocess.run(
+        [str(build.binary_path), *argv],
+        capture_output=False,
+        env={**os.environ, **env} if env else None,
+    )
+    return result.returncode
@@ -0,0 +1,83 @@
+"""CLI test subcommand handlers."""
+
+from __future__ import annotations
+
+import argparse
+import json
+import tempfile
+from pathlib import Path
+
+
+def test_command(args: argparse.Namespace) -> int:
+    """Handle the test subcommand.
+
+    Compiles the Core IL file to Go in test mode and runs every
+    zero-argument function named test_*. A test fails when it raises an
+    error (Throw or a failed assertion). Exits 1 if any test failed.
+
+    With --coverage, also reports which statements the tests executed,
+    grouped by English sentence when --source is given, and writes the
+    report next to the Core IL file as <name>.coverage.json and .html.
+    """
+    from english_compiler.cli.run_targets import run_go_file
+    from english_compiler.coreil.coverage import load_counts
+    from english_compiler.coreil.emit_go import emit_go
+
+    path = Path(args.file)
+    try:
+        with path.open("r", encoding="utf-8") as handle:
+            doc = json.load(handle)
+    except OSError as exc:
+        print(f"{path}: {exc}")
+        return 1
+    except json.JSONDecodeError as exc:
+        print(f"{path}: invalid json: {exc}")
+        return 1
+
+    coverage = getattr(args, "coverage", False)
+    source_text = None
+    if getattr(args, "source", None):
+        try:
+            source_text = Path(args.source).read_text(encoding="utf-8")
+        except OSError as exc:
+            print(f"{args.source}: {exc}")
+            return 1
+
+    try:
+        code, _ = emit_go(doc, test_mode=True, coverage=coverage, source_text=source_text)
+    except Exception as exc:
+        print(f"{path}: Go codegen failed: {exc}")
+        return 1
+
+    with tempfile.TemporaryDirectory() as tmp_dir:
+        go_path = Path(tmp_dir) / "main.go"
+        go_path.write_text(code, encoding="utf-8")
+        if not coverage:
+            return run_go_file(go_path)
+        counts_path = Path(tmp_dir) / "coverage.json"
+        rc = run_go_file(go_path, env={"COREIL_COVERAGE": str(counts_path)})
+        if not counts_path.exists():
+            return rc
+        _write_coverage_report(path, doc, load_counts(counts_path), source_text)
+        return rc
+
+
+def _write_coverage_report(
+    path: Path, doc: dict, counts: dict[str, int], source_text: str | None
+) -> None:
+    from english_compiler.cli.io_utils import write_json
+    from english_compiler.coreil.coverage import build_report, format_summary, render_html
+
+    report = build_report(doc, counts, source_text=source_text, test_mode=True)
+    stem = path.name.removesuffix(".json").removesuffix(".coreil")
+    json_path = path.with_name(stem + ".coverage.json")
+    html_path = path.with_name(stem + ".coverage.html")
+    print()
+    print(format_summary(report))
+    if write_json(json_path, report):
+        print(f"Coverage report written to {json_path}")
+    try:
+        html_path.write_text(render_html(report, stem), encoding="utf-8")
+        print(f"HTML coverage report written to {html_path}")
+    except OSError as exc:
+        print(f"{html_path}: {exc}")
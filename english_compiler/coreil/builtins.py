@@ -0,0 +1,561 @@
+"""Registry of the builtins Core IL runtimes provide.
+
+A builtin is anything a program uses that the runtime implements rather
+than the program itself:
+
+- call: a Call of a reserved name, such as print or argv
+- operation: a library node type, such as StringSplit or HeapPush, whose
+  fields are its parameters
+- external: an ExternalCall (Tier 2), such as time.time or fs.readFile
+
+Each Builtin records its parameters and their types, its result type, its
+purity, the Core IL version that introduced it (and, for the sealed v0.4
+helpers, the last one that allows it) and the targets that implement it.
+builtins_for() gives the builtins a target supports at a Core IL version,
+which the frontend puts in its prompt, and check_builtins() reports the
+builtins a program uses that its target lacks, so compilation fails before
+code generation rather than in the target's compiler.
+
+The registry itself is versioned by REGISTRY_VERSION, which changes when
+the JSON layout of registry_json() does.
+
+Usage:
+    from english_compiler.coreil.builtins import check_builtins
+
+    for violation in check_builtins(doc, "go"):
+        print(violation["path"], violation["message"])
+"""
+
+from __future__ import annotations
+
+import re
+from dataclasses import dataclass, field
+from typing import Any
+
+from .node_nav import iter_node_paths
+from .purity import EFFECT, EXPR_PURITY
+from .versions import COREIL_VERSION
+
+REGISTRY_VERSION = 1
+
+# Compilation targets, as named by `compile --target`; "coreil" is the
+# interpreter
+TARGETS = ("coreil", "python", "javascript", "cpp", "rust", "go", "wasm")
+
+# Targets whose ExternalCall passes any module.function through to the
+# host language, so only the registered ones are checked elsewhere
+OPEN_EXTERNAL_TARGETS = frozenset({"python", "javascript"})
+
+# Targets that honor a document's "division": "truncate"; the others only
+# implement floored division
+TRUNCATED_DIVISION_TARGETS = frozenset({"coreil", "go"})
+
+
+@dataclass(frozen=True)
+class Param:
+    """A builtin's parameter: a Call argument or an operation's field."""
+
+    name: str
+    type: str  # any, bool, int, number, string, array, map, set, tuple, record, deque, heap
+    optional: bool = False
+    variadic: bool = False
+
+
+@dataclass(frozen=True)
+class Builtin:
+    """A builtin of the Core IL runtimes."""
+
+    name: str
+    kind: str  # call, operation or external
+    params: tuple[Param, ...]
+    returns: str | None  # None for statements
+    pure: bool
+    since: str
+    until: str | None = None
+    capability: str | None = None  # sandbox capability (externals and file-reading calls)
+    targets: frozenset[str] = field(default_factory=lambda: frozenset(TARGETS))
+
+    @property
+    def arity(self) -> tuple[int, int | None]:
+        """(fewest, most) arguments; most is None for a variadic builtin."""
+        low = sum(1 for p in self.params if not p.optional and not p.variadic)
+        if any(p.variadic for p in self.params):
+            return low, None
+        return low, len(self.params)
+
+    def available(self, version: str = COREIL_VERSION) -> bool:
+        """True if programs of the Core IL version may use the builtin."""
+        key = _version_key(version)
+        if key < _version_key(self.since):
+            return False
+        return self.until is None or key <= _version_key(self.until)
+
+    def signature(self) -> str:
+        """E.g. 'RegexMatch(string: string, pattern: string, flags?: string) -> bool'."""
+        params = ", ".join(
+            f"{p.name}{'...' if p.variadic else '?' if p.optional else ''}: {p.type}" for p in self.params
+        )
+        return f"{self.name}({params}) -> {self.returns or 'none'}"
+
+    def to_json(self) -> dict:
+        return {
+            "name": self.name,
+            "kind": self.kind,
+            "params": [
+                {"name": p.name, "type": p.type, "optional": p.optional, "variadic": p.variadic}
+                for p in self.params
+            ],
+            "arity": list(self.arity),
+            "returns": self.returns,
+            "pure": self.pure,
+            "since": self.since,
+            "until": self.until,
+            "capability": self.capability,
+            "targets": [target for target in TARGETS if target in self.targets],
+        }
+
+
+def _version_key(version: str) -> tuple[int, ...]:
+    return tuple(int(part) for part in re.findall(r"\d+", version))
+
+
+def _p(spec: str) -> Param:
+    """Parse 'name:type', with a trailing ? for optional and ... for variadic."""
+    name, kind = spec.split(":")
+    if kind.endswith("..."):
+        return Param(name, kind[:-3], variadic=True)
+    if kind.endswith("?"):
+        return Param(name, kind[:-1], optional=True)
+    return Param(name, kind)
+
+
+def _all_but(*targets: str) -> tuple[str, ...]:
+    return tuple(target for target in TARGETS if target not in targets)
+
+
+def _call(name, params, returns, pure, since, until=None, targets=TARGETS, capability=None) -> Builtin:
+    return Builtin(name, "call", tuple(_p(p) for p in params), returns, pure, since, until,
+                   capability=capability, targets=frozenset(targets))
+
+
+def _op(name, params, returns, since, targets=TARGETS) -> Builtin:
+    # Statements mutate or bind; expressions are pure unless marked EFFECT
+    pure = returns is not None and EXPR_PURITY.get(name) != EFFECT
+    return Builtin(name, "operation", tuple(_p(p) for p in params), returns, pure, since,
+                   targets=frozenset(targets))
+
+
+def _ext(name, params, returns, capability=None, pure=False) -> Builtin:
+    return Builtin(name, "external", tuple(_p(p) for p in params), returns, pure, "coreil-1.0",
+                   capability=capability, targets=frozenset({"go"}) | OPEN_EXTERNAL_TARGETS)
+
+
+BUILTINS: tuple[Builtin, ...] = (
+    # Calls of reserved names
+    _call("print", ["values:any..."], None, False, "coreil-0.1", targets=("coreil", "python", "wasm")),
+    _call("input", ["prompt:string?"], "string", False, "coreil-0.1", targets=("coreil", "python")),
+    _call("argv", [], "array", True, "coreil-1.11", targets=("coreil", "go")),
+    _call("forAll", ["generator:any", "property:string", "trials:int?"], "bool", False, "coreil-1.11",
+          targets=("coreil", "go")),
+    _call("readLines", ["path:string"], "iterator", False, "coreil-1.11", targets=("coreil", "go"),
+          capability="io.read"),
+    _call("readChunks", ["reader:string", "size:int"], "iterator", False, "coreil-1.11", targets=("coreil", "go"),
+          capability="io.read"),
+    _call("intDiv", ["a:number", "b:number"], "number", True, "coreil-1.11", targets=("coreil", "go")),
+    _call("heapFromArray", ["items:array"], "heap", True, "coreil-1.11", targets=("coreil", "go")),
+    _call("heapPushPop", ["heap:heap", "priority:number", "value:any"], "any", False, "coreil-1.11",
+          targets=("coreil", "go")),
+    _call("heapReplace", ["heap:heap", "priority:number", "value:any"], "any", False, "coreil-1.11",
+          targets=("coreil", "go")),
+    _call("heapToSortedArray", ["heap:heap"], "array", True, "coreil-1.11", targets=("coreil", "go")),
+    _call("dequeExtend", ["deque:deque", "items:array"], None, False, "coreil-1.11", targets=("coreil", "go")),
+    _call("dequeExtendLeft", ["deque:deque", "items:array"], None, False, "coreil-1.11", targets=("coreil", "go")),
+    _call("mathAtan2", ["y:number", "x:number"], "float", True, "coreil-1.11", targets=("coreil", "go")),
+    _call("mathHypot", ["x:number", "y:number"], "float", True, "coreil-1.11", targets=("coreil", "go")),
+    _call("mathIsNaN", ["value:number"], "bool", True, "coreil-1.11", targets=("coreil", "go")),
+    _call("mathIsInf", ["value:number"], "bool", True, "coreil-1.11", targets=("coreil", "go")),
+    _call("mathIsFinite", ["value:number"], "bool", True, "coreil-1.11", targets=("coreil", "go")),
+    # Runtime helpers only the Go backend provides
+    _call("errorKind", ["error:string"], "string", True, "coreil-1.11", targets=("go",)),
+    _call("errorCode", ["error:string"], "string", True, "coreil-1.11", targets=("go",)),
+    _call("tryCall", ["fn:any", "args:any..."], "any", False, "coreil-1.11", targets=("go",)),
+    _call("coreilAssert", ["condition:any", "message:any", "actual:any", "expected:any"], None, False,
+          "coreil-1.11", targets=("go",)),
+    _call("coreilAssertEqual", ["actual:any", "expected:any", "message:any"], None, False, "coreil-1.11",
+          targets=("go",)),
+    _call("logDebug", ["message:string", "fields:map"], None, False, "coreil-1.11", targets=("go",)),
+    _call("logInfo", ["message:string", "fields:map"], None, False, "coreil-1.11", targets=("go",)),
+    _call("logWarn", ["message:string", "fields:map"], None, False, "coreil-1.11", targets=("go",)),
+    _call("logError", ["message:string", "fields:map"], None, False, "coreil-1.11", targets=("go",)),
+    _call("coreilMin", ["values:any..."], "any", False, "coreil-1.11", targets=("go",)),
+    _call("coreilMax", ["values:any..."], "any", False, "coreil-1.11", targets=("go",)),
+    _call("coreilMinBy", ["key:any", "values:any..."], "any", False, "coreil-1.11", targets=("go",)),
+    _call("coreilMaxBy", ["key:any", "values:any..."], "any", False, "coreil-1.11", targets=("go",)),
+    _call("valueNegate", ["value:number"], "number", True, "coreil-1.11", targets=("go",)),
+    _call("valuePositive", ["value:number"], "number", True, "coreil-1.11", targets=("go",)),
+    _call("valueContains", ["container:any", "item:any"], "bool", True, "coreil-1.11", targets=("go",)),
+    _call("valueCopy", ["value:any"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("valueDeepCopy", ["value:any"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("freeze", ["value:any"], "any", False, "coreil-1.11", targets=("go",)),
+    _call("isFrozen", ["value:any"], "bool", True, "coreil-1.11", targets=("go",)),
+    _call("recordToMap", ["record:record"], "map", True, "coreil-1.11", targets=("go",)),
+    _call("mapToRecord", ["map:map"], "record", True, "coreil-1.11", targets=("go",)),
+    _call("recordFields", ["record:record"], "array", True, "coreil-1.11", targets=("go",)),
+    _call("recordHas", ["record:record", "name:string"], "bool", True, "coreil-1.11", targets=("go",)),
+    _call("recordDelete", ["record:record", "name:string"], None, False, "coreil-1.11", targets=("go",)),
+    _call("callMethod", ["object:record", "name:string", "args:any..."], "any", False, "coreil-1.11",
+          targets=("go",)),
+    _call("bindMethod", ["object:record", "name:string"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("isInstance", ["object:any", "class:any"], "bool", True, "coreil-1.11", targets=("go",)),
+    _call("callValue", ["fn:any", "args:any..."], "any", False, "coreil-1.11", targets=("go",)),
+    _call("partial", ["fn:any", "bound:any..."], "any", True, "coreil-1.11", targets=("go",)),
+    _call("compose", ["fns:any..."], "any", True, "coreil-1.11", targets=("go",)),
+    _call("memoize", ["fn:any", "max_size:int"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("memoStats", ["fn:any"], "map", False, "coreil-1.11", targets=("go",)),
+    _call("memoClear", ["fn:any"], None, False, "coreil-1.11", targets=("go",)),
+    # Variants, Option and Result
+    _call("variantTag", ["variant:any"], "string", True, "coreil-1.11", targets=("go",)),
+    _call("variantIs", ["variant:any", "tag:string"], "bool", True, "coreil-1.11", targets=("go",)),
+    _call("variantPayload", ["variant:any"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("optionSome", ["value:any"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("optionNoneOf", [], "any", True, "coreil-1.11", targets=("go",)),
+    _call("resultOk", ["value:any"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("resultErr", ["error:any"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("isSome", ["option:any"], "bool", True, "coreil-1.11", targets=("go",)),
+    _call("isOk", ["result:any"], "bool", True, "coreil-1.11", targets=("go",)),
+    _call("unwrap", ["value:any"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("unwrapOr", ["value:any", "default:any"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("optionMap", ["value:any", "fn:any"], "any", False, "coreil-1.11", targets=("go",)),
+    _call("arrayIndexOption", ["array:array", "index:int"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("mapGetOption", ["map:map", "key:any"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("parseIntResult", ["text:string"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("parseFloatResult", ["text:string"], "any", True, "coreil-1.11", targets=("go",)),
+    # Pattern matching
+    _call("matchValue", ["subject:any", "cases:any"], "tuple", False, "coreil-1.11", targets=("go",)),
+    _call("patAny", [], "any", True, "coreil-1.11", targets=("go",)),
+    _call("patBind", ["name:string", "sub:any?"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("patOr", ["alternatives:any..."], "any", True, "coreil-1.11", targets=("go",)),
+    _call("patRest", ["name:string"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("patType", ["type:any", "sub:any?"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("patVariant", ["tag:string", "sub:any?"], "any", True, "coreil-1.11", targets=("go",)),
+    # Tasks, channels, futures, pools, locks, actors, events and timers
+    _call("spawn", ["fn:any", "args:any..."], "any", False, "coreil-1.11", targets=("go",)),
+    _call("channelNew", ["capacity:int"], "any", False, "coreil-1.11", targets=("go",)),
+    _call("channelSend", ["channel:any", "value:any"], None, False, "coreil-1.11", targets=("go",)),
+    _call("channelReceive", ["channel:any"], "any", False, "coreil-1.11", targets=("go",)),
+    _call("channelClose", ["channel:any"], None, False, "coreil-1.11", targets=("go",)),
+    _call("selectValue", ["cases:tuple", "timeout:number"], "tuple", False, "coreil-1.11", targets=("go",)),
+    _call("taskStart", ["fn:any", "args:any..."], "any", False, "coreil-1.11", targets=("go",)),
+    _call("await", ["future:any"], "any", False, "coreil-1.11", targets=("go",)),
+    _call("awaitAll", ["futures:array"], "array", False, "coreil-1.11", targets=("go",)),
+    _call("awaitAny", ["futures:array"], "tuple", False, "coreil-1.11", targets=("go",)),
+    _call("poolNew", ["workers:int", "queue:int", "timeout:number"], "any", False, "coreil-1.11",
+          targets=("go",)),
+    _call("poolSubmit", ["pool:any", "fn:any", "args:any..."], "any", False, "coreil-1.11", targets=("go",)),
+    _call("poolWaitAll", ["pool:any"], None, False, "coreil-1.11", targets=("go",)),
+    _call("poolResults", ["pool:any"], "array", False, "coreil-1.11", targets=("go",)),
+    _call("lockNew", [], "any", False, "coreil-1.11", targets=("go",)),
+    _call("lockAcquire", ["lock:any"], None, False, "coreil-1.11", targets=("go",)),
+    _call("lockRelease", ["lock:any"], None, False, "coreil-1.11", targets=("go",)),
+    _call("withLock", ["lock:any", "fn:any", "args:any..."], "any", False, "coreil-1.11", targets=("go",)),
+    _call("counterNew", ["initial:int"], "any", False, "coreil-1.11", targets=("go",)),
+    _call("counterAdd", ["counter:any", "delta:int"], "int", False, "coreil-1.11", targets=("go",)),
+    _call("counterGet", ["counter:any"], "int", False, "coreil-1.11", targets=("go",)),
+    _call("counterSet", ["counter:any", "value:int"], None, False, "coreil-1.11", targets=("go",)),
+    _call("spawnActor", ["handler:any"], "any", False, "coreil-1.11", targets=("go",)),
+    _call("actorSend", ["actor:any", "message:any"], None, False, "coreil-1.11", targets=("go",)),
+    _call("actorAsk", ["actor:any", "message:any", "timeout:number"], "any", False, "coreil-1.11",
+          targets=("go",)),
+    _call("eventBus", [], "any", False, "coreil-1.11", targets=("go",)),
+    _call("eventBusNew", [], "any", False, "coreil-1.11", targets=("go",)),
+    _call("eventOn", ["bus:any", "event:string", "handler:any"], None, False, "coreil-1.11", targets=("go",)),
+    _call("eventOff", ["bus:any", "event:string", "handler:any"], None, False, "coreil-1.11", targets=("go",)),
+    _call("eventEmit", ["bus:any", "event:string", "payload:any"], "int", False, "coreil-1.11", targets=("go",)),
+    _call("eventDispatch", ["bus:any", "timeout:number"], "int", False, "coreil-1.11", targets=("go",)),
+    _call("setTimeout", ["fn:any", "seconds:number", "args:any..."], "any", False, "coreil-1.11",
+          targets=("go",)),
+    _call("setInterval", ["fn:any", "seconds:number", "args:any..."], "any", False, "coreil-1.11",
+          targets=("go",)),
+    _call("scheduleEvery", ["schedule:string", "fn:any", "args:any..."], "any", False, "coreil-1.11",
+          targets=("go",)),
+    _call("timerCancel", ["timer:any"], None, False, "coreil-1.11", targets=("go",)),
+    _call("timerWait", ["timer:any"], None, False, "coreil-1.11", targets=("go",)),
+    _call("generator", ["fn:any", "args:any..."], "any", False, "coreil-1.11", targets=("go",)),
+    _call("generatorNext", ["generator:any"], "any", False, "coreil-1.11", targets=("go",)),
+    _call("generatorClose", ["generator:any"], None, False, "coreil-1.11", targets=("go",)),
+    # Tables
+    _call("tableNew", ["columns:map"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("tableFromRows", ["rows:array"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("tableFromCSV", ["text:string"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("tableFromJSON", ["text:string"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("tableToCSV", ["table:any"], "string", True, "coreil-1.11", targets=("go",)),
+    _call("tableToJSON", ["table:any"], "string", True, "coreil-1.11", targets=("go",)),
+    _call("tableRows", ["table:any"], "array", True, "coreil-1.11", targets=("go",)),
+    _call("tableSize", ["table:any"], "int", True, "coreil-1.11", targets=("go",)),
+    _call("tableColumn", ["table:any", "name:string"], "array", True, "coreil-1.11", targets=("go",)),
+    _call("selectColumns", ["table:any", "names:array"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("filterRows", ["table:any", "predicate:any"], "any", False, "coreil-1.11", targets=("go",)),
+    _call("sortBy", ["table:any", "by:array", "descending:bool?"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("groupBy", ["table:any", "keys:array"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("aggregate", ["table:any", "specs:map"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("joinTables", ["left:any", "right:any", "on:array", "how:string?"], "any", True, "coreil-1.11",
+          targets=("go",)),
+    _call("tableToArrow", ["table:any"], "string", True, "coreil-1.11", targets=("go",)),
+    _call("tableFromArrow", ["data:string"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("arrayToArrow", ["items:array"], "string", True, "coreil-1.11", targets=("go",)),
+    _call("arrayFromArrow", ["data:string"], "array", True, "coreil-1.11", targets=("go",)),
+    # Identifiers, URLs, compression, documents and secrets
+    _call("uuid4", [], "string", False, "coreil-1.11", targets=("go",)),
+    _call("uuid7", [], "string", False, "coreil-1.11", targets=("go",)),
+    _call("randomToken", ["n_bytes:int", "encoding:string?"], "string", False, "coreil-1.11", targets=("go",)),
+    _call("urlParse", ["url:string"], "record", True, "coreil-1.11", targets=("go",)),
+    _call("urlBuild", ["parts:any"], "string", True, "coreil-1.11", targets=("go",)),
+    _call("queryEncode", ["params:map"], "string", True, "coreil-1.11", targets=("go",)),
+    _call("queryDecode", ["query:string"], "map", True, "coreil-1.11", targets=("go",)),
+    _call("gzipCompress", ["data:string"], "string", True, "coreil-1.11", targets=("go",)),
+    _call("gzipDecompress", ["data:string"], "string", True, "coreil-1.11", targets=("go",)),
+    _call("zlibCompress", ["data:string"], "string", True, "coreil-1.11", targets=("go",)),
+    _call("zlibDecompress", ["data:string"], "string", True, "coreil-1.11", targets=("go",)),
+    _call("zipWrite", ["entries:map"], "string", True, "coreil-1.11", targets=("go",)),
+    _call("zipRead", ["archive:string", "name:string?"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("zipList", ["archive:string"], "array", True, "coreil-1.11", targets=("go",)),
+    _call("htmlParse", ["text:string"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("xmlParse", ["text:string"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("xpath", ["node:any", "expression:string"], "array", True, "coreil-1.11", targets=("go",)),
+    _call("querySelector", ["node:any", "selector:string"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("querySelectorAll", ["node:any", "selector:string"], "array", True, "coreil-1.11", targets=("go",)),
+    _call("nodeTag", ["node:any"], "string", True, "coreil-1.11", targets=("go",)),
+    _call("nodeText", ["node:any"], "string", True, "coreil-1.11", targets=("go",)),
+    _call("nodeAttr", ["node:any", "name:string"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("nodeAttrs", ["node:any"], "map", True, "coreil-1.11", targets=("go",)),
+    _call("nodeChildren", ["node:any"], "array", True, "coreil-1.11", targets=("go",)),
+    _call("nodeParent", ["node:any"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("secret", ["value:string"], "any", True, "coreil-1.11", targets=("go",)),
+    _call("secretFromEnv", ["name:string"], "any", False, "coreil-1.11", targets=("go",), capability="env"),
+    _call("get_or_default", ["map:map", "key:any", "default:any"], "any", True, "coreil-0.4", "coreil-0.4",
+          targets=("coreil",)),
+    _call("entries", ["map:map"], "array", True, "coreil-0.4", "coreil-0.4", targets=("coreil",)),
+    _call("append", ["array:array", "value:any"], None, False, "coreil-0.4", "coreil-0.4", targets=("coreil",)),
+    # Arrays, maps and tuples
+    _op("Length", ["base:array"], "int", "coreil-0.2"),
+    _op("Index", ["base:array", "index:int"], "any", "coreil-0.2"),
+    _op("SetIndex", ["base:array", "index:int", "value:any"], None, "coreil-0.2"),
+    _op("Push", ["base:array", "value:any"], None, "coreil-0.5"),
+    _op("Slice", ["base:array", "start:int", "end:int"], "array", "coreil-1.5"),
+    _op("Get", ["base:map", "key:any"], "any", "coreil-0.4"),
+    _op("GetDefault", ["base:map", "key:any", "default:any"], "any", "coreil-0.5"),
+    _op("Set", ["base:map", "key:any", "value:any"], None, "coreil-0.4"),
+    _op("Keys", ["base:map"], "array", "coreil-0.5"),
+    # Records
+    _op("GetField", ["base:record", "name:string"], "any", "coreil-1.1"),
+    _op("SetField", ["base:record", "name:string", "value:any"], None, "coreil-1.1"),
+    # Sets
+    _op("SetHas", ["base:set", "value:any"], "bool", "coreil-1.1"),
+    _op("SetSize", ["base:set"], "int", "coreil-1.1"),
+    _op("SetAdd", ["base:set", "value:any"], None, "coreil-1.1"),
+    _op("SetRemove", ["base:set", "value:any"], None, "coreil-1.1"),
+    # Deques and heaps
+    _op("DequeNew", ["maxlen:int?"], "deque", "coreil-1.1"),
+    _op("DequeSize", ["base:deque"], "int", "coreil-1.1"),
+    _op("PushBack", ["base:deque", "value:any"], None, "coreil-1.1"),
+    _op("PushFront", ["base:deque", "value:any"], None, "coreil-1.1"),
+    _op("PopFront", ["base:deque", "target:string"], None, "coreil-1.1"),
+    _op("PopBack", ["base:deque", "target:string"], None, "coreil-1.1"),
+    _op("HeapNew", [], "heap", "coreil-1.1"),
+    _op("HeapSize", ["base:heap"], "int", "coreil-1.1"),
+    _op("HeapPeek", ["base:heap"], "any", "coreil-1.1"),
+    _op("HeapPush", ["base:heap", "priority:number", "value:any"], None, "coreil-1.1"),
+    _op("HeapPop", ["base:heap", "target:string"], None, "coreil-1.1"),
+    # Strings
+    _op("StringLength", ["base:string"], "int", "coreil-1.1"),
+    _op("Substring", ["base:string", "start:int", "end:int"], "string", "coreil-1.1"),
+    _op("CharAt", ["base:string", "index:int"], "string", "coreil-1.1"),
+    _op("Join", ["sep:string", "items:array"], "string", "coreil-1.1"),
+    _op("StringSplit", ["base:string", "delimiter:string"], "array", "coreil-1.4"),
+    _op("StringTrim", ["base:string"], "string", "coreil-1.4"),
+    _op("StringUpper", ["base:string"], "string", "coreil-1.4"),
+    _op("StringLower", ["base:string"], "string", "coreil-1.4"),
+    _op("StringStartsWith", ["base:string", "prefix:string"], "bool", "coreil-1.4"),
+    _op("StringEndsWith", ["base:string", "suffix:string"], "bool", "coreil-1.4"),
+    _op("StringContains", ["base:string", "substring:string"], "bool", "coreil-1.4"),
+    _op("StringReplace", ["base:string", "old:string", "new:string"], "string", "coreil-1.4",
+        targets=_all_but("rust")),
+    _op("StringFormat", ["parts:any..."], "string", "coreil-1.11", targets=_all_but("cpp", "rust")),
+    # Math
+    _op("Math", ["op:string", "arg:number"], "number", "coreil-1.2"),
+    _op("MathPow", ["base:number", "exponent:number"], "float", "coreil-1.2"),
+    _op("MathConst", ["name:string"], "float", "coreil-1.2"),
+    # JSON and regular expressions
+    _op("JsonParse", ["source:string"], "any", "coreil-1.3", targets=_all_but("cpp")),
+    _op("JsonStringify", ["value:any", "pretty:bool?"], "string", "coreil-1.3", targets=_all_but("cpp")),
+    _op("RegexMatch", ["string:string", "pattern:string", "flags:string?"], "bool", "coreil-1.3"),
+    _op("RegexFindAll", ["string:string", "pattern:string", "flags:string?"], "array", "coreil-1.3"),
+    _op("RegexReplace", ["string:string", "pattern:string", "replacement:string", "flags:string?"], "string",
+        "coreil-1.3"),
+    _op("RegexSplit", ["string:string", "pattern:string", "flags:string?", "maxsplit:int?"], "array",
+        "coreil-1.3"),
+    # Conversions
+    _op("ToInt", ["value:any"], "int", "coreil-1.9"),
+    _op("ToFloat", ["value:any"], "float", "coreil-1.9"),
+    _op("ToString", ["value:any"], "string", "coreil-1.9"),
+    # Tier 2 host objects
+    _op("MethodCall", ["object:any", "method:string", "args:any..."], "any", "coreil-1.6",
+        targets=("python", "javascript")),
+    _op("PropertyGet", ["object:any", "property:string"], "any", "coreil-1.6",
+        targets=("python", "javascript")),
+    # ExternalCalls the Go runtime implements
+    _ext("time.time", [], "float"),
+    _ext("time.now", [], "float"),
+    _ext("time.sleep", ["seconds:number"], None),
+    _ext("os.getenv", ["name:string"], "string", "env"),
+    _ext("os.env", ["name:string"], "string", "env"),
+    _ext("os.getcwd", [], "string", "io.read"),
+    _ext("os.cwd", [], "string", "io.read"),
+    _ext("os.system", ["command:string"], "int", "exec"),
+    _ext("os.exit", ["code:int"], None),
+    _ext("fs.readFile", ["path:string"], "string", "io.read"),
+    _ext("fs.writeFile", ["path:string", "text:string"], None, "io.write"),
+    _ext("fs.exists", ["path:string"], "bool", "io.read"),
+    _ext("http.get", ["url:string"], "string", "net"),
+    _ext("http.getAuth", ["url:string", "authorization:string"], "string", "net"),
+    _ext("email.send", ["to:any", "subject:string", "body:string"], None, "notify"),
+    _ext("notify.webhook", ["url:string", "payload:any"], "int", "notify"),
+    _ext("random.random", [], "float"),
+    _ext("random.randint", ["low:int", "high:int"], "int"),
+    _ext("random.choice", ["items:array"], "any"),
+    _ext("random.seed", ["seed:int"], None),
+    _ext("crypto.hash", ["text:string"], "string", pure=True),
+)
+
+_BY_NAME = {builtin.name: builtin for builtin in BUILTINS}
+
+
+def get_builtin(name: str) -> Builtin | None:
+    """The builtin called name, or None."""
+    return _BY_NAME.get(name)
+
+
+def call_builtins() -> dict[str, Builtin]:
+    """The builtins a Call can name, by name."""
+    return {b.name: b for b in BUILTINS if b.kind == "call"}
+
+
+def property_literal(node: Any) -> dict | None:
+    """The string Literal naming the function a forAll Call tests, if any.
+
+    Passes that rename functions rename it too: the property is a function
+    reference, not data.
+    """
+    if not isinstance(node, dict) or node.get("type") != "Call" or node.get("name") != "forAll":
+        return None
+    args = node.get("args")
+    if not isinstance(args, list) or len(args) < 2:
+        return None
+    literal = args[1]
+    if isinstance(literal, dict) and literal.get("type") == "Literal" and isinstance(literal.get("value"), str):
+        return literal
+    return None
+
+
+def builtins_for(target: str, version: str = COREIL_VERSION) -> list[Builtin]:
+    """The builtins target supports for programs of the Core IL version.
+
+    Raises:
+        ValueError: If target is not one of TARGETS.
+    """
+    if target not in TARGETS:
+        raise ValueError(f"unknown target '{target}' (expected one of: {', '.join(TARGETS)})")
+    return [b for b in BUILTINS if target in b.targets and b.available(version)]
+
+
+def registry_json(target: str | None = None, version: str = COREIL_VERSION) -> dict:
+    """The registry as JSON: every builtin, or those target supports at version."""
+    builtins = BUILTINS if target is None else builtins_for(target, version)
+    return {
+        "registry_version": REGISTRY_VERSION,
+        "coreil_version": version,
+        "target": target,
+        "targets": list(TARGETS),
+        "builtins": [b.to_json() for b in builtins],
+    }
+
+
+def unsupported_builtins(target: str, version: str = COREIL_VERSION) -> list[Builtin]:
+    """The calls and operations of the Core IL version that target lacks.
+
+    Calls the interpreter lacks are one runtime's own helpers rather than
+    part of Core IL, so other targets do not list them.
+    """
+    supported = {b.name for b in builtins_for(target, version)}
+    return [
+        b for b in BUILTINS
+        if b.kind != "external" and b.available(version) and b.name not in supported
+        and (b.kind != "call" or "coreil" in b.targets)
+    ]
+
+
+def target_constraints(target: str, version: str = COREIL_VERSION) -> str:
+    """A note for the frontend naming what target cannot run, or ""."""
+    # Calls read as print(), so they are not mistaken for the Print statement
+    missing = [f"{b.name}()" if b.kind == "call" else b.name for b in unsupported_builtins(target, version)]
+    externals = [b.name for b in builtins_for(target, version) if b.kind == "external"]
+    if target in OPEN_EXTERNAL_TARGETS or not missing and not externals:
+        note = ""
+    else:
+        note = (
+            f"ExternalCall may only use: {', '.join(externals)}."
+            if externals else "ExternalCall is not available."
+        )
+    if missing:
+        note = f"Do not use: {', '.join(missing)}. {note}".strip()
+    return f"The program will run on the {target} target. {note}" if note else ""
+
+
+def check_builtins(doc: Any, target: str) -> list[dict]:
+    """The uses of builtins target does not support, as verifier violations.
+
+    Each violation is a dict with a message and the JSON path of the node.
+    Calls of functions the program defines are not builtins; names that are
+    neither are left to verify_coreil(). The program's version is not
+    checked: the runtimes accept every builtin at every version. Truncated
+    division is also reported on targets that only floor.
+
+    Raises:
+        ValueError: If target is not one of TARGETS.
+    """
+    if target not in TARGETS:
+        raise ValueError(f"unknown target '{target}' (expected one of: {', '.join(TARGETS)})")
+    if not isinstance(doc, dict) or not isinstance(doc.get("body"), list):
+        return []
+    violations = []
+    if doc.get("division") == "truncate" and target not in TRUNCATED_DIVISION_TARGETS:
+        violations.append({
+            "message": f"division 'truncate' is not supported by the {target} target",
+            "path": "$.division",
+        })
+    supported = {b.name for b in BUILTINS if target in b.targets}
+    defined = {
+        node.get("name") for _, node in iter_node_paths(doc["body"]) if node["type"] == "FuncDef"
+    }
+    for path, node in iter_node_paths(doc["body"]):
+        kind = node["type"]
+        if kind == "Call":
+            name = node.get("name")
+            if name in defined or get_builtin(name) is None or get_builtin(name).kind != "call":
+                continue
+        elif kind == "ExternalCall":
+            if target in OPEN_EXTERNAL_TARGETS:
+                continue
+            name = f"{node.get('module')}.{node.get('function')}"
+        elif kind in _BY_NAME:
+            name = kind
+        else:
+            continue
+        if name not in supported:
+            violations.append({
+                "message": f"{'ExternalCall ' if kind == 'ExternalCall' else ''}{name} is not supported "
+                           f"by the {target} target",
+                "path": path,
+            })
+    return violations
+
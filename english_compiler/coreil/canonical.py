@@ -0,0 +1,78 @@
+"""Canonical form of Core IL documents.
+
+canonicalize() rewrites a document into the one normalized form every
+equivalent document shares, and format_coreil() renders that form as text:
+
+- object keys are sorted and indented by two spaces, as the compiler writes
+  its artifacts
+- numbers and strings are re-emitted, so 1.50, 15e-1 and 1.5 all become
+  1.5 and escapes are written one way
+- fields that only restate their default are dropped: an empty else,
+  finally_body or Switch default, Range inclusive=false and an empty
+  ambiguities list
+- source_map index lists are sorted and deduplicated
+
+Two documents with the same canonical form run identically on every
+backend, so diffs of canonical IL show only real changes, and hashes of it
+(e.g. GoBuildCache keys) hit across frontends and compiler versions that
+spell the same program differently.
+
+Usage:
+    from english_compiler.coreil.canonical import canonicalize, format_coreil
+
+    text = format_coreil(doc)
+"""
+
+from __future__ import annotations
+
+import json
+from typing import Any
+
+from .node_nav import is_coreil_node
+
+# Node type -> {field: default} for fields dropped when they hold the default
+_DEFAULT_FIELDS: dict[str, dict[str, Any]] = {
+    "If": {"else": []},
+    "TryCatch": {"finally_body": []},
+    "Switch": {"default": []},
+    "Range": {"inclusive": False},
+}
+
+
+def canonicalize(doc: dict) -> dict:
+    """Return the canonical form of doc, leaving doc itself unchanged."""
+    result = {key: _canonical_value(value) for key, value in doc.items()}
+    if result.get("ambiguities") == []:
+        del result["ambiguities"]
+    source_map = result.get("source_map")
+    if isinstance(source_map, dict):
+        result["source_map"] = {
+            line: sorted(set(indices)) if _is_index_list(indices) else indices
+            for line, indices in source_map.items()
+        }
+    return result
+
+
+def format_coreil(doc: dict) -> str:
+    """Render doc's canonical form as text, ending with a newline."""
+    return json.dumps(canonicalize(doc), indent=2, sort_keys=True) + "\n"
+
+
+def _canonical_value(value: Any) -> Any:
+    if isinstance(value, list):
+        return [_canonical_value(item) for item in value]
+    if not isinstance(value, dict):
+        return value
+    result = {key: _canonical_value(child) for key, child in value.items()}
+    if is_coreil_node(result):
+        for field, default in _DEFAULT_FIELDS.get(result["type"], {}).items():
+            # bool is an int, so compare types too: inclusive=0 is kept
+            if field in result and type(result[field]) is type(default) and result[field] == default:
+                del result[field]
+    return result
+
+
+def _is_index_list(value: Any) -> bool:
+    return isinstance(value, list) and all(
+        isinstance(index, int) and not isinstance(index, bool) for index in value
+    )
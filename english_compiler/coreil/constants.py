@@ -24,6 +24,24 @@ BINARY_OPS = frozenset({
     "or",
 })
 
+# Division semantics a document may declare in its "division" field: how %
+# and intDiv round when the operands have different signs
+DIVISION_MODES = frozenset({
+    "floor",
+    "truncate",
+})
+
+# Capabilities a Go program may be granted (see emit_go's grants); a
+# sandboxed program is denied every side effect it was not granted
+CAPABILITIES = frozenset({
+    "io.read",
+    "io.write",
+    "net",
+    "exec",
+    "env",
+    "notify",
+})
+
 # Math operations supported in Core IL v1.2+
 MATH_OPS = frozenset({
     "sin",
@@ -35,10 +53,38 @@ MATH_OPS = frozenset({
     "abs",
     "log",
     "exp",
+    "trunc",
+    "round",
+    "asin",
+    "acos",
+    "atan",
+    "log2",
+    "log10",
+    "sinh",
+    "cosh",
+    "tanh",
+})
+
+# Math operations only the interpreter and the Python and Go backends
+# implement; the other backends reject them
+EXTENDED_MATH_OPS = frozenset({
+    "trunc",
+    "round",
+    "asin",
+    "acos",
+    "atan",
+    "log2",
+    "log10",
+    "sinh",
+    "cosh",
+    "tanh",
 })
 
 # Math constants supported in Core IL v1.2+
-MATH_CONSTANTS = frozenset({"pi", "e"})
+MATH_CONSTANTS = frozenset({"pi", "e", "inf", "nan"})
+
+# Math constants only the interpreter and the Python and Go backends implement
+EXTENDED_MATH_CONSTANTS = frozenset({"inf", "nan"})
 
 # Maximum call depth for recursion
 MAX_CALL_DEPTH = 1000
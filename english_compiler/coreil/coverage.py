@@ -0,0 +1,162 @@
+"""Statement coverage reports for compiled Core IL programs.
+
+A Go program built with emit_go(doc, coverage=True) counts how often each IL
+statement runs and writes the counts to the file named by COREIL_COVERAGE
+when it exits. build_report() maps those counts back to the English
+sentences the statements were compiled from, so users can see which parts
+of their described logic were never exercised; the report is plain JSON and
+render_html() and format_summary() present it.
+
+Usage:
+    report = build_report(doc, load_counts(path), source_text=text)
+    Path("coverage.html").write_text(render_html(report, "prog"))
+"""
+
+from __future__ import annotations
+
+import html
+import json
+from pathlib import Path
+
+from english_compiler.coreil.node_nav import iter_statements
+from english_compiler.coreil.source_map import statement_sentences
+
+
+def load_counts(path: Path) -> dict[str, int]:
+    """Read the counts a coverage build wrote, keyed by statement path."""
+    data = json.loads(path.read_text(encoding="utf-8"))
+    return {entry["il"]: entry["count"] for entry in data.get("statements", [])}
+
+
+def build_report(
+    doc: dict,
+    counts: dict[str, int],
+    *,
+    source_text: str | None = None,
+    test_mode: bool = False,
+) -> dict:
+    """Build a coverage report from per-statement execution counts.
+
+    Every executable statement is listed with its count; FuncDef statements
+    themselves are not, since only their bodies run. In test mode the
+    top-level program body never runs, so only function bodies are
+    counted. When source_text and the document's source_map are available,
+    statements are grouped by the English sentence they came from.
+    """
+    body = doc.get("body", [])
+    sentences = {}
+    source_map = doc.get("source_map")
+    if source_text and source_map:
+        sentences = statement_sentences(source_text, source_map)
+
+    statements: list[dict] = []
+    by_sentence: dict[int, dict] = {}
+    for path, stmt in iter_statements(body):
+        top = int(path[len("$.body["):path.index("]")])
+        if stmt["type"] == "FuncDef":
+            continue
+        if test_mode and body[top].get("type") != "FuncDef":
+            continue
+        count = counts.get(path, 0)
+        sentence = sentences.get(top)
+        statements.append({
+            "path": path,
+            "type": stmt["type"],
+            "count": count,
+            "sentence": sentence.sentence_id if sentence else None,
+        })
+        if sentence is None:
+            continue
+        entry = by_sentence.setdefault(sentence.sentence_id, {
+            "id": sentence.sentence_id,
+            "line": sentence.line,
+            "column": sentence.column,
+            "text": sentence.text,
+            "statements": 0,
+            "covered": 0,
+        })
+        entry["statements"] += 1
+        if count:
+            entry["covered"] += 1
+
+    covered = sum(1 for s in statements if s["count"])
+    total = len(statements)
+    return {
+        "summary": {
+            "statements": total,
+            "covered": covered,
+            "percent": round(100 * covered / total, 1) if total else 100.0,
+        },
+        "statements": statements,
+        "sentences": [by_sentence[k] for k in sorted(by_sentence)],
+    }
+
+
+def format_summary(report: dict) -> str:
+    """Describe a report in a few lines, listing what never ran."""
+    summary = report["summary"]
+    lines = [
+        f"Coverage: {summary['covered']}/{summary['statements']} statements ({summary['percent']}%)"
+    ]
+    missed_sentences = [s for s in report["sentences"] if s["covered"] < s["statements"]]
+    if missed_sentences:
+        lines.append("Not fully exercised:")
+        for s in missed_sentences:
+            lines.append(
+                f"  sentence {s['id']} (line {s['line']}): {s['text']!r} "
+                f"- {s['covered']}/{s['statements']} statements ran"
+            )
+        return "\n".join(lines)
+    missed = [s for s in report["statements"] if not s["count"]]
+    if missed:
+        lines.append("Never executed:")
+        for s in missed:
+            lines.append(f"  {s['path']} ({s['type']})")
+    return "\n".join(lines)
+
+
+def _row_class(covered: int, total: int) -> str:
+    if covered == total:
+        return "full"
+    return "partial" if covered else "none"
+
+
+def render_html(report: dict, title: str) -> str:
+    """Render a report as a standalone HTML page."""
+    summary = report["summary"]
+    parts = [
+        "<!DOCTYPE html>",
+        "<html><head><meta charset=\"utf-8\">",
+        f"<title>Coverage: {html.escape(title)}</title>",
+        "<style>",
+        "body { font-family: sans-serif; margin: 2em; }",
+        "table { border-collapse: collapse; margin-bottom: 2em; }",
+        "td, th { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }",
+        ".full { background: #dfd; } .partial { background: #ffd; } .none { background: #fdd; }",
+        "</style></head><body>",
+        f"<h1>Coverage: {html.escape(title)}</h1>",
+        f"<p>{summary['covered']} of {summary['statements']} statements executed "
+        f"({summary['percent']}%).</p>",
+    ]
+    if report["sentences"]:
+        parts.append("<h2>Sentences</h2>")
+        parts.append("<table><tr><th>#</th><th>Line</th><th>Sentence</th><th>Statements run</th></tr>")
+        for s in report["sentences"]:
+            parts.append(
+                f"<tr class=\"{_row_class(s['covered'], s['statements'])}\">"
+                f"<td>{s['id']}</td><td>{s['line']}</td><td>{html.escape(s['text'])}</td>"
+                f"<td>{s['covered']}/{s['statements']}</td></tr>"
+            )
+        parts.append("</table>")
+    parts.append("<h2>Statements</h2>")
+    parts.append("<table><tr><th>IL path</th><th>Type</th><th>Sentence</th><th>Count</th></tr>")
+    for s in report["statements"]:
+        sentence = "" if s["sentence"] is None else str(s["sentence"])
+        parts.append(
+            f"<tr class=\"{_row_class(1 if s['count'] else 0, 1)}\">"
+            f"<td>{html.escape(s['path'])}</td><td>{s['type']}</td>"
+            f"<td>{sentence}</td><td>{s['count']}</td></tr>"
+        )
+    parts.append("</table>")
+    parts.append("</body></html>")
+    return "\n".join(parts) + "\n"
@@ -0,0 +1,282 @@
+"""Differential execution of a program on the Go runtime and the interpreter.
+
+The interpreter is the reference semantics of Core IL; a program that
+behaves differently when compiled to Go points at a backend bug. A
+cross-check runs the program both ways and records, as each statement
+starts, its path and the size and a checksum of everything printed so
+far. The Go side is a coverage build run with COREIL_STEP_TRACE, so the
+two traces use the same statement paths (see node_nav.iter_statements).
+
+Comparing the traces finds the first divergent statement: the last one
+both runtimes started before they printed something different, went on
+to a different statement, or one of them stopped. That is usually the
+statement whose translation is wrong, long before a difference shows up
+at the end of the output.
+
+Usage:
+    result = cross_check(doc, argv=["a"])
+    if not result.ok:
+        print(format_result(result, doc, source_text=text))
+"""
+
+from __future__ import annotations
+
+import hashlib
+import io
+import os
+import subprocess
+import tempfile
+from contextlib import redirect_stdout
+from dataclasses import dataclass, field
+from pathlib import Path
+
+from .go_cache import GoBuildCache
+from .interp import run_coreil
+from .node_nav import iter_statements
+from .source_map import document_sentences
+
+
+@dataclass(frozen=True)
+class Step:
+    """A statement starting, with the output printed before it."""
+    path: str
+    offset: int  # bytes of output so far
+    checksum: str  # first 8 bytes of the SHA-256 of that output, in hex
+
+
+@dataclass
+class RuntimeRun:
+    """What one runtime did with the program."""
+    exit_code: int
+    output: bytes
+    steps: list[Step] = field(default_factory=list)
+    error: str = ""  # the uncaught error, if the run failed
+
+
+@dataclass
+class Divergence:
+    """Where two runs first differ."""
+    path: str | None  # the divergent statement; None before the first one
+    reason: str
+    interp_next: str | None = None  # the statement each runtime ran next
+    go_next: str | None = None
+
+
+@dataclass
+class CrossCheckResult:
+    """Result of cross_check; skipped is set when the interpreter cannot run the program."""
+    ok: bool
+    interp: RuntimeRun | None = None
+    go: RuntimeRun | None = None
+    matched: int = 0  # statements both runtimes ran identically
+    divergence: Divergence | None = None
+    skipped: str | None = None
+    error: str | None = None
+
+
+class _TracingOutput(io.TextIOBase):
+    """stdout replacement recording the interpreter's output and its checksum."""
+
+    def __init__(self) -> None:
+        self.data = bytearray()
+        self.sum = hashlib.sha256()
+
+    def writable(self) -> bool:
+        return True
+
+    def write(self, text: str) -> int:
+        encoded = text.encode("utf-8")
+        self.data += encoded
+        self.sum.update(encoded)
+        return len(text)
+
+    def step(self, path: str) -> Step:
+        return Step(path, len(self.data), self.sum.digest()[:8].hex())
+
+
+def trace_interpreter(
+    doc: dict,
+    *,
+    argv: list[str] | None = None,
+    max_steps: int | None = None,
+    tail_calls: bool = True,
+) -> RuntimeRun:
+    """Run doc in the interpreter, tracing each statement it starts.
+
+    Raises ValueError for Tier 2 programs, which the interpreter cannot run.
+    """
+    paths = {id(stmt): path for path, stmt in iter_statements(doc.get("body", []))}
+    out = _TracingOutput()
+    steps: list[Step] = []
+    errors: list[str] = []
+
+    def step_callback(stmt, index, local_env, global_env, functions, call_depth):
+        # FuncDef only declares a function, so Go has no statement to mark
+        if stmt.get("type") != "FuncDef":
+            steps.append(out.step(paths.get(id(stmt), "?")))
+
+    with redirect_stdout(out):
+        rc = run_coreil(
+            doc,
+            error_callback=errors.append,
+            step_callback=step_callback,
+            tail_calls=tail_calls,
+            argv=argv,
+            max_steps=max_steps,
+        )
+    return RuntimeRun(exit_code=rc, output=bytes(out.data), steps=steps, error="\n".join(errors))
+
+
+def trace_go(
+    doc: dict,
+    *,
+    argv: list[str] | None = None,
+    max_steps: int | None = None,
+    tail_calls: bool = True,
+    grants: list[str] | None = None,
+) -> RuntimeRun:
+    """Run doc as a Go coverage build, tracing each statement it starts.
+
+    grants names the capabilities the program may use (see emit_go).
+    Raises RuntimeError if the program does not build.
+    """
+    build = GoBuildCache().build(doc, coverage=True, tail_calls=tail_calls, grants=grants)
+    if not build.success:
+        raise RuntimeError(f"Go compilation failed:\n{build.error}")
+    with tempfile.TemporaryDirectory() as tmp_dir:
+        trace_path = Path(tmp_dir) / "steps.txt"
+        env = {**os.environ, "COREIL_STEP_TRACE": str(trace_path)}
+        env.pop("COREIL_MAX_STEPS", None)
+        if max_steps is not None:
+            env["COREIL_MAX_STEPS"] = str(max_steps)
+        result = subprocess.run(
+            [str(build.binary_path), *(argv or [])],
+            capture_output=True,
+            env=env,
+            timeout=300,
+        )
+        lines = trace_path.read_text(encoding="utf-8").splitlines() if trace_path.exists() else []
+    steps = []
+    for line in lines:
+        path, offset, checksum = line.rsplit(" ", 2)
+        steps.append(Step(path, int(offset), checksum))
+    return RuntimeRun(
+        exit_code=result.returncode,
+        output=result.stdout,
+        steps=steps,
+        error=result.stderr.decode("utf-8", errors="replace").strip(),
+    )
+
+
+def compare_runs(interp: RuntimeRun, go: RuntimeRun) -> tuple[int, Divergence | None]:
+    """Return how many steps match and where the runs first differ, if anywhere."""
+    a, b = interp.steps, go.steps
+    k = 0
+    while k < len(a) and k < len(b) and a[k] == b[k]:
+        k += 1
+    last = a[k - 1].path if k else None
+
+    if k < len(a) and k < len(b):
+        if a[k].path == b[k].path:
+            return k, Divergence(last, "printed different output")
+        return k, Divergence(last, "went on to a different statement", a[k].path, b[k].path)
+    if k < len(a) or k < len(b):
+        stopped = "Go" if k < len(a) else "the interpreter"
+        return k, Divergence(
+            last,
+            f"{stopped} stopped here",
+            a[k].path if k < len(a) else None,
+            b[k].path if k < len(b) else None,
+        )
+    if interp.output != go.output:
+        return k, Divergence(last, "printed different output")
+    if interp.exit_code != go.exit_code:
+        return k, Divergence(last, "exited differently")
+    return k, None
+
+
+def cross_check(
+    doc: dict,
+    *,
+    argv: list[str] | None = None,
+    max_steps: int | None = None,
+    tail_calls: bool = True,
+    base_dir: Path | None = None,
+    grants: list[str] | None = None,
+) -> CrossCheckResult:
+    """Run doc on both runtimes and compare their outputs and statement traces."""
+    body = doc.get("body", [])
+    if any(isinstance(s, dict) and s.get("type") == "Import" for s in body):
+        from .module import resolve_imports
+
+        doc = resolve_imports(doc, base_dir=base_dir)
+    options = {"argv": argv, "max_steps": max_steps, "tail_calls": tail_calls}
+    try:
+        interp = trace_interpreter(doc, **options)
+    except ValueError as exc:
+        # Tier 2 operations: there is no reference behavior to compare with
+        return CrossCheckResult(ok=True, skipped=f"the interpreter cannot run this program: {exc}")
+    try:
+        go = trace_go(doc, grants=grants, **options)
+    except (RuntimeError, OSError, subprocess.TimeoutExpired) as exc:
+        return CrossCheckResult(ok=False, interp=interp, error=str(exc))
+    matched, divergence = compare_runs(interp, go)
+    return CrossCheckResult(
+        ok=divergence is None,
+        interp=interp,
+        go=go,
+        matched=matched,
+        divergence=divergence,
+    )
+
+
+def format_result(result: CrossCheckResult, doc: dict, *, source_text: str | None = None) -> str:
+    """Describe a cross-check result, naming the divergent statement's English sentence when known."""
+    if result.skipped:
+        return f"cross-check skipped: {result.skipped}"
+    if result.error:
+        return f"cross-check failed: {result.error}"
+    interp, go = result.interp, result.go
+    if result.ok:
+        return (
+            f"cross-check passed: {result.matched} statements ran identically "
+            f"(exit code {interp.exit_code}, {len(interp.output)} bytes of output)"
+        )
+
+    div = result.divergence
+    sentences = document_sentences(doc, source_text)
+    statements = dict(iter_statements(doc.get("body", [])))
+
+    def describe(path: str | None) -> str:
+        if path is None:
+            return "(none)"
+        stmt = statements.get(path)
+        text = f"{path} {stmt['type']}" if stmt else path
+        sentence = sentences.get(int(path[len("$.body["):path.index("]")]))
+        if sentence is not None:
+            text += f' (line {sentence.line}: "{sentence.text}")'
+        return text
+
+    lines = [f"cross-check failed after {result.matched} matching statements"]
+    if div.path is None:
+        lines.append(f"runs diverged before the first statement: {div.reason}")
+    else:
+        lines.append(f"first divergent statement: {describe(div.path)}")
+        lines.append(f"  {div.reason}")
+    if div.interp_next is not None or div.go_next is not None:
+        lines.append(f"  next statement in the interpreter: {describe(div.interp_next)}")
+        lines.append(f"  next statement in Go:              {describe(div.go_next)}")
+
+    # The output of the divergent statement, from where the runs agreed up
+    # to each runtime's next step (or the end of its output)
+    start = interp.steps[result.matched - 1].offset if result.matched else 0
+    for name, run in (("interpreter", interp), ("Go", go)):
+        end = run.steps[result.matched].offset if result.matched < len(run.steps) else len(run.output)
+        printed = run.output[start:end].decode("utf-8", errors="replace")
+        lines.append(f"  {name} printed: {printed!r}")
+    for name, run in (("interpreter", interp), ("Go", go)):
+        status = f"  {name} exit code: {run.exit_code}"
+        if run.error:
+            status += f" ({run.error.splitlines()[-1]})"
+        lines.append(status)
+    return "\n".join(lines)
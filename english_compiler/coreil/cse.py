@@ -0,0 +1,444 @@
+"""Common subexpression elimination and loop-invariant code motion for Core IL.
+
+LLM-generated programs often recompute the same length or map lookup
+several times in a row, or on every iteration of a loop. These passes
+evaluate such expressions once and reuse the result:
+
+- hoist_loop_invariants(): an expression in a While test, or at the start
+  of a loop body, whose operands the loop never changes is computed once
+  before the loop. When it comes from the body, the loop is wrapped in an
+  If on its entry condition so loops that run zero times still never
+  evaluate it.
+- eliminate_common_subexpressions(): an expression repeated across a run of
+  statements is computed once into a temporary, or replaced by the variable
+  a Let/Assign already stored it in.
+
+Only expressions without side effects are reused (see purity.py), never
+ones that allocate a new container, and only when nothing evaluated before
+them could raise, so output and errors are unchanged. Cheap arithmetic on
+variables is left alone.
+
+Usage:
+    from english_compiler.coreil.cse import (
+        eliminate_common_subexpressions,
+        hoist_loop_invariants,
+    )
+
+    doc = eliminate_common_subexpressions(hoist_loop_invariants(doc))
+"""
+
+from __future__ import annotations
+
+import json
+from collections.abc import Callable, Iterator
+from copy import deepcopy
+from itertools import count
+from typing import Any
+
+from .node_nav import (
+    BLOCK_KEYS,
+    assigned_names,
+    expression_keys,
+    is_coreil_node,
+    iter_nodes,
+    referenced_names,
+)
+from .purity import FRESH, cannot_fail, expr_purity, has_effects, reads_containers
+from .source_map import remap_replaced_statements
+
+# Nodes that only make an expression worth reusing when they wrap something
+# more expensive
+_CHEAP_TYPES = frozenset({"Literal", "Var", "MathConst", "Not", "Binary", "Ternary"})
+
+# Builtins that always evaluate to an int
+_INT_VALUED_TYPES = frozenset({"Length", "StringLength", "SetSize", "DequeSize", "HeapSize"})
+
+
+def hoist_loop_invariants(doc: dict) -> dict:
+    """Move loop-invariant expressions out of loops.
+
+    Returns a new document (the input is not mutated).
+    """
+    result = deepcopy(doc)
+    body = result.get("body")
+    if not isinstance(body, list):
+        return result
+    hoister = _Hoister(_temp_names(result, "__licm_"))
+    groups = [hoister.statement(stmt) for stmt in body]
+    result["body"] = [stmt for group in groups for stmt in group]
+    if isinstance(result.get("source_map"), dict):
+        result["source_map"] = remap_replaced_statements(
+            result["source_map"], [len(group) for group in groups]
+        )
+    return result
+
+
+def eliminate_common_subexpressions(doc: dict) -> dict:
+    """Evaluate repeated expressions once.
+
+    Returns a new document (the input is not mutated).
+    """
+    result = deepcopy(doc)
+    body = result.get("body")
+    if not isinstance(body, list):
+        return result
+    groups = _Reuser(_temp_names(result, "__cse_")).block(body)
+    result["body"] = [stmt for group in groups for stmt in group]
+    if isinstance(result.get("source_map"), dict):
+        result["source_map"] = remap_replaced_statements(
+            result["source_map"], [len(group) for group in groups]
+        )
+    return result
+
+
+# ---------------------------------------------------------------------------
+# Loop-invariant code motion
+# ---------------------------------------------------------------------------
+
+
+class _Hoister:
+    def __init__(self, names: Iterator[str]):
+        self.names = names
+
+    def block(self, stmts: list) -> list:
+        return [new for stmt in stmts for new in self.statement(stmt)]
+
+    def statement(self, stmt: Any) -> list:
+        """Hoist out of stmt and its nested loops; returns its replacement."""
+        if not is_coreil_node(stmt):
+            return [stmt]
+        # Inner loops first, so what they hoist can move further out
+        for key in BLOCK_KEYS:
+            if isinstance(stmt.get(key), list):
+                stmt[key] = self.block(stmt[key])
+        for case in stmt.get("cases") or []:
+            if isinstance(case, dict) and isinstance(case.get("body"), list):
+                case["body"] = self.block(case["body"])
+
+        if stmt["type"] == "While":
+            test = stmt.get("test")
+            if has_effects(test):
+                return [stmt]
+            invariant = _invariant_in(stmt)
+            from_test = list(_leading_candidates([test], invariant))
+            from_body = list(_leading_candidates(_body_prefix(stmt.get("body")), invariant))
+            return self.hoist(stmt, from_test, from_body)
+
+        if stmt["type"] == "For":
+            iter_expr = stmt.get("iter")
+            if not (
+                is_coreil_node(iter_expr)
+                and iter_expr["type"] == "Range"
+                and _is_int_valued(iter_expr.get("from"))
+                and _is_int_valued(iter_expr.get("to"))
+                and not has_effects(iter_expr)
+            ):
+                return [stmt]
+            from_body = list(_leading_candidates(_body_prefix(stmt.get("body")), _invariant_in(stmt)))
+            guard = {
+                "type": "Binary",
+                "op": "<=" if iter_expr.get("inclusive") else "<",
+                "left": iter_expr["from"],
+                "right": iter_expr["to"],
+            }
+            return self.hoist(stmt, [], from_body, guard)
+
+        return [stmt]
+
+    def hoist(self, loop: dict, from_test: list, from_body: list, guard: Any = None) -> list:
+        """Compute the expressions into temporaries before the loop.
+
+        Expressions from the test are evaluated first thing by the loop
+        anyway, so they go right before it. Expressions from the body are
+        only evaluated once the loop would run its first iteration, so they
+        go inside an If on guard (by default, the loop's test).
+        """
+        test_keys = {_key(expr) for expr in from_test}
+        from_body = [expr for expr in from_body if _key(expr) not in test_keys]
+        hoisted: list[tuple[str, str]] = []
+        before = [self.hoist_one(loop, expr, hoisted) for expr in from_test]
+        if not from_body:
+            return before + [loop]
+        guard = deepcopy(guard if guard is not None else loop.get("test"))
+        inside = [self.hoist_one(loop, expr, hoisted) for expr in from_body]
+        return before + [{"type": "If", "test": guard, "then": inside + [loop]}]
+
+    def hoist_one(self, loop: dict, expr: dict, hoisted: list[tuple[str, str]]) -> dict:
+        """Replace expr in loop with a new variable; returns the Let computing it."""
+        value = deepcopy(expr)
+        for key, name in hoisted:
+            value = _substitute(value, key, name)
+        name = next(self.names)
+        key = _key(value)
+        _substitute(loop, key, name)
+        hoisted.append((key, name))
+        return {"type": "Let", "name": name, "value": value}
+
+
+def _invariant_in(loop: dict) -> Callable[[dict], bool]:
+    """Predicate for expressions whose value the loop never changes."""
+    written = assigned_names(loop)
+    mutating = has_effects(loop.get("body"))
+
+    def invariant(expr: dict) -> bool:
+        if referenced_names(expr) & written:
+            return False
+        return not (mutating and reads_containers(expr))
+
+    return invariant
+
+
+def _body_prefix(body: Any) -> list:
+    """Expressions evaluated at the start of every iteration, in order.
+
+    Covers a leading run of Let/Assign statements and the expressions of the
+    statement after them.
+    """
+    exprs: list = []
+    for stmt in body if isinstance(body, list) else []:
+        if not is_coreil_node(stmt) or stmt["type"] == "FuncDef":
+            break
+        exprs.extend(_expressions(stmt))
+        if stmt["type"] not in ("Let", "Assign"):
+            break
+    return exprs
+
+
+def _is_int_valued(expr: Any) -> bool:
+    if not is_coreil_node(expr):
+        return False
+    if expr["type"] == "Literal":
+        return isinstance(expr.get("value"), int) and not isinstance(expr.get("value"), bool)
+    if expr["type"] == "Binary" and expr.get("op") in ("+", "-", "*"):
+        return _is_int_valued(expr.get("left")) and _is_int_valued(expr.get("right"))
+    return expr["type"] in _INT_VALUED_TYPES
+
+
+# ---------------------------------------------------------------------------
+# Common subexpression elimination
+# ---------------------------------------------------------------------------
+
+
+class _Reuser:
+    def __init__(self, names: Iterator[str]):
+        self.names = names
+
+    def block(self, stmts: list) -> list[list]:
+        """Reuse expressions across stmts; returns each statement's replacement."""
+        self.reuse_variables(stmts)
+        groups: list[list] = []
+        for i, stmt in enumerate(stmts):
+            group: list = []
+            if is_coreil_node(stmt) and stmt["type"] not in ("While", "FuncDef"):
+                rest = stmts[i + 1:]
+                while True:
+                    found = self.repeated(stmt, rest)
+                    if found is None:
+                        break
+                    expr, targets = found
+                    name = next(self.names)
+                    group.append({"type": "Let", "name": name, "value": deepcopy(expr)})
+                    _apply(targets, _key(expr), name)
+            group.append(stmt)
+            groups.append(group)
+
+        # Then the nested blocks, which see the outer reuse already applied
+        for stmt in stmts:
+            if not is_coreil_node(stmt):
+                continue
+            for key in BLOCK_KEYS:
+                if isinstance(stmt.get(key), list):
+                    stmt[key] = [s for group in self.block(stmt[key]) for s in group]
+            for case in stmt.get("cases") or []:
+                if isinstance(case, dict) and isinstance(case.get("body"), list):
+                    case["body"] = [s for group in self.block(case["body"]) for s in group]
+        return groups
+
+    def reuse_variables(self, stmts: list) -> None:
+        """Replace later copies of a Let/Assign value with the variable."""
+        for i, stmt in enumerate(stmts):
+            if not is_coreil_node(stmt) or stmt["type"] not in ("Let", "Assign"):
+                continue
+            value, name = stmt.get("value"), stmt.get("name")
+            if not _is_candidate(value) or name in referenced_names(value):
+                continue
+            targets = _reuse_targets(
+                stmts[i + 1:], referenced_names(value) | {name}, reads_containers(value)
+            )
+            _apply(targets, _key(value), name)
+
+    def repeated(self, stmt: dict, rest: list) -> tuple[dict, list] | None:
+        """The first expression stmt evaluates that is worth computing once."""
+        header = _expressions(stmt)
+        found: list = []
+
+        def accept(expr: dict) -> bool:
+            names, reads = referenced_names(expr), reads_containers(expr)
+            if reads and has_effects(header):
+                return False
+            if _clobbers(stmt, names, reads):
+                targets = [(stmt, False)]
+            else:
+                targets = [(stmt, True)] + _reuse_targets(rest, names, reads)
+            if _count_targets(targets, _key(expr)) < 2:
+                return False
+            found.append((expr, targets))
+            return True
+
+        next(_leading_candidates(header, accept), None)
+        return found[0] if found else None
+
+
+def _reuse_targets(stmts: list, names: set[str], reads: bool) -> list[tuple[dict, bool]]:
+    """Statements in which an expression still has the value it had before them.
+
+    Returns (statement, whole) pairs: whole means the whole statement,
+    otherwise only the expressions it evaluates before its nested blocks.
+    """
+    targets: list[tuple[dict, bool]] = []
+    for stmt in stmts:
+        if not is_coreil_node(stmt) or stmt["type"] == "FuncDef":
+            continue
+        if reads and has_effects(_expressions(stmt)):
+            break
+        whole = not _clobbers(stmt, names, reads)
+        # A While test is evaluated again after its body
+        if whole or stmt["type"] != "While":
+            targets.append((stmt, whole))
+        if not whole:
+            break
+    return targets
+
+
+def _apply(targets: list[tuple[dict, bool]], key: str, name: str) -> None:
+    for stmt, whole in targets:
+        for field in stmt if whole else expression_keys(stmt):
+            if field != "type":
+                stmt[field] = _substitute(stmt[field], key, name)
+
+
+def _count_targets(targets: list[tuple[dict, bool]], key: str) -> int:
+    total = 0
+    for stmt, whole in targets:
+        fields = expression_keys(stmt) if not whole else [k for k in stmt if k != "type"]
+        total += sum(_count(stmt[field], key) for field in fields)
+    return total
+
+
+def _clobbers(stmt: dict, names: set[str], reads: bool) -> bool:
+    """Whether running stmt can change an expression over names."""
+    return bool(assigned_names(stmt) & names) or (reads and has_effects(stmt))
+
+
+# ---------------------------------------------------------------------------
+# Shared helpers
+# ---------------------------------------------------------------------------
+
+
+def _leading_candidates(exprs: list, accept: Callable[[dict], bool]) -> Iterator[dict]:
+    """Yield accepted candidates that are evaluated before anything else can fail.
+
+    exprs are walked in evaluation order. A candidate is yielded only if it
+    is certainly evaluated (not inside the right of and/or or a Ternary
+    branch) and everything evaluated before it either cannot raise or is
+    itself an earlier candidate, so evaluating the candidates up front
+    leaves the first error of the original unchanged. The largest accepted
+    expression is preferred over its parts.
+    """
+    keys: set[str] = set()
+    blocked = False
+
+    def visit(value: Any, conditional: bool) -> Iterator[dict]:
+        nonlocal blocked
+        if blocked:
+            return
+        if isinstance(value, list):
+            for item in value:
+                yield from visit(item, conditional)
+            return
+        if not isinstance(value, dict):
+            return
+        if not is_coreil_node(value):
+            for child in value.values():
+                yield from visit(child, conditional)
+            return
+        if _is_candidate(value):
+            key = _key(value)
+            if key in keys:
+                return
+            if not conditional and accept(value):
+                keys.add(key)
+                yield value
+                return
+        cond_keys = _conditional_keys(value)
+        for field, child in value.items():
+            yield from visit(child, conditional or field in cond_keys)
+        if not cannot_fail(value):
+            blocked = True
+
+    for expr in exprs:
+        yield from visit(expr, False)
+
+
+def _conditional_keys(node: dict) -> tuple[str, ...]:
+    if node["type"] == "Binary" and node.get("op") in ("and", "or"):
+        return ("right",)
+    if node["type"] == "Ternary":
+        return ("consequent", "alternate")
+    return ()
+
+
+def _is_candidate(expr: Any) -> bool:
+    """Whether expr may be computed once and reused."""
+    if not is_coreil_node(expr) or cannot_fail(expr):
+        return False
+    if expr_purity(expr) == FRESH or has_effects(expr):
+        return False
+    nodes = list(iter_nodes(expr))
+    return (
+        any(node["type"] == "Var" for node in nodes)
+        and any(node["type"] not in _CHEAP_TYPES for node in nodes)
+    )
+
+
+def _expressions(stmt: dict) -> list:
+    return [stmt[key] for key in expression_keys(stmt)]
+
+
+def _substitute(value: Any, key: str, name: str) -> Any:
+    """Replace each expression matching key with Var name, in place where possible."""
+    if isinstance(value, list):
+        return [_substitute(item, key, name) for item in value]
+    if not isinstance(value, dict):
+        return value
+    if is_coreil_node(value):
+        if value["type"] == "FuncDef":
+            return value
+        if _key(value) == key:
+            return {"type": "Var", "name": name}
+    for field in list(value):
+        value[field] = _substitute(value[field], key, name)
+    return value
+
+
+def _count(value: Any, key: str) -> int:
+    if isinstance(value, list):
+        return sum(_count(item, key) for item in value)
+    if not isinstance(value, dict):
+        return 0
+    if is_coreil_node(value):
+        if value["type"] == "FuncDef":
+            return 0
+        if _key(value) == key:
+            return 1
+    return sum(_count(child, key) for child in value.values())
+
+
+def _key(expr: Any) -> str:
+    return json.dumps(expr, sort_keys=True)
+
+
+def _temp_names(doc: dict, prefix: str) -> Iterator[str]:
+    """Fresh variable names starting with prefix."""
+    used = {node.get("name") for node in iter_nodes(doc.get("body"))}
+    return (f"{prefix}{i}" for i in count() if f"{prefix}{i}" not in used)
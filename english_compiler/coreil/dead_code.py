@@ -0,0 +1,309 @@
+"""Dead code and unused variable elimination for Core IL.
+
+eliminate_dead_code() removes statements that cannot affect what a program
+prints or raises, and reports each removal so the compiler can tell users
+which parts of their English description were redundant
+(describe_removals() names the sentence each one came from).
+
+Removals:
+- unreachable: statements after Return/Break/Continue/Throw in the same block
+- dead-branch: the branch of an If whose test is a literal, and While loops
+  whose test is a false literal
+- unused-variable: Let of a variable that nothing else mentions, when its
+  value can neither fail nor have side effects
+- no-effect: If with empty branches, For/ForEach with an empty body over a
+  literal range or array, TryCatch with an empty body, and self-assignment
+
+Usage:
+    from english_compiler.coreil.dead_code import eliminate_dead_code
+
+    doc, removals = eliminate_dead_code(doc)
+"""
+
+from __future__ import annotations
+
+from collections import Counter
+from copy import deepcopy
+from typing import Any
+
+from .node_nav import iter_nodes, iter_statements
+from .source_map import remap_replaced_statements, statement_sentences
+
+_TERMINATOR_TYPES = {"Return", "Break", "Continue", "Throw"}
+
+# Node keys that hold a variable name (or, for Call, a function name, which
+# is counted too so that a variable sharing it is kept).
+_NAME_KEYS = ("name", "var", "target", "catch_var")
+
+
+def eliminate_dead_code(doc: dict) -> tuple[dict, list[dict]]:
+    """Remove statements that cannot affect the program.
+
+    Returns a new document (the input is not mutated) and the removals, each
+    a dict with keys kind, message and path (the JSON path of the removed
+    statement or branch in the input document). The document's source_map,
+    if any, is updated for the statements that remain.
+    """
+    result = deepcopy(doc)
+    body = result.get("body")
+    if not isinstance(body, list):
+        return result, []
+
+    paths = {id(stmt): path for path, stmt in iter_statements(body)}
+    original_body = list(body)
+    removals: list[dict] = []
+    # Removing one variable can leave another unused, so repeat until stable
+    while True:
+        cleaner = _Cleaner(paths, _count_mentions(body))
+        body = cleaner.block(body)
+        removals.extend(cleaner.removals)
+        if not cleaner.removals:
+            break
+    result["body"] = body
+
+    if isinstance(result.get("source_map"), dict):
+        kept = {id(stmt) for stmt in body}
+        result["source_map"] = remap_replaced_statements(
+            result["source_map"], [int(id(stmt) in kept) for stmt in original_body]
+        )
+
+    removals.sort(key=lambda r: _path_key(r["path"]))
+    return result, removals
+
+
+def describe_removals(
+    removals: list[dict],
+    doc: dict,
+    source_text: str | None = None,
+) -> list[str]:
+    """Describe removals, naming the English sentence each came from.
+
+    doc is the document the removals were computed from; its source_map and
+    source_text attribute statements to sentences. Without them the Core IL
+    path is shown instead.
+    """
+    sentences = {}
+    source_map = doc.get("source_map")
+    if source_text and isinstance(source_map, dict):
+        sentences = statement_sentences(source_text, source_map)
+    lines = []
+    for removal in removals:
+        path = removal["path"]
+        sentence = sentences.get(_path_key(path)[0])
+        if sentence is not None:
+            lines.append(f'line {sentence.line}: "{sentence.text}" - {removal["message"]}')
+        else:
+            lines.append(f"{path}: {removal['message']}")
+    return lines
+
+
+def _path_key(path: str) -> tuple[int, ...]:
+    """Sort key for a path: its indices in order ("$.body[2].then[0]" -> (2, 0))."""
+    key = []
+    for part in path.split("[")[1:]:
+        digits = part.split("]")[0]
+        if digits.isdigit():
+            key.append(int(digits))
+    return tuple(key)
+
+
+def _count_mentions(body: list) -> Counter:
+    """Count every mention of each name, declarations included."""
+    mentions: Counter = Counter()
+    for node in iter_nodes(body):
+        for key in _NAME_KEYS:
+            if isinstance(node.get(key), str):
+                mentions[node[key]] += 1
+        for param in node.get("params") or []:
+            if isinstance(param, str):
+                mentions[param] += 1
+    return mentions
+
+
+def _is_safe(expr: Any) -> bool:
+    """Whether evaluating expr can neither fail nor have side effects."""
+    if not isinstance(expr, dict):
+        return False
+    node_type = expr.get("type")
+    if node_type in ("Literal", "Var", "MathConst"):
+        return True
+    if node_type in ("Array", "Tuple"):
+        return all(_is_safe(item) for item in expr.get("items", []))
+    if node_type == "Set":
+        return all(_is_hashable_literal(item) for item in expr.get("items", []))
+    if node_type == "Map":
+        return all(
+            isinstance(item, dict)
+            and _is_hashable_literal(item.get("key"))
+            and _is_safe(item.get("value"))
+            for item in expr.get("items", [])
+        )
+    if node_type == "Record":
+        return all(isinstance(f, dict) and _is_safe(f.get("value")) for f in expr.get("fields", []))
+    if node_type == "Not":
+        return _is_safe(expr.get("arg"))
+    if node_type == "Binary" and expr.get("op") in ("and", "or"):
+        return _is_safe(expr.get("left")) and _is_safe(expr.get("right"))
+    if node_type == "Ternary":
+        return all(_is_safe(expr.get(k)) for k in ("test", "consequent", "alternate"))
+    if node_type == "Range":
+        return _is_int_literal(expr.get("from")) and _is_int_literal(expr.get("to"))
+    return False
+
+
+def _is_hashable_literal(node: Any) -> bool:
+    return (
+        isinstance(node, dict)
+        and node.get("type") == "Literal"
+        and isinstance(node.get("value"), (str, int, float, bool))
+    )
+
+
+def _is_int_literal(node: Any) -> bool:
+    return (
+        isinstance(node, dict)
+        and node.get("type") == "Literal"
+        and isinstance(node.get("value"), int)
+        and not isinstance(node.get("value"), bool)
+    )
+
+
+def _is_literal(node: Any) -> bool:
+    return isinstance(node, dict) and node.get("type") == "Literal"
+
+
+class _Cleaner:
+    """One elimination sweep over a program body."""
+
+    def __init__(self, paths: dict[int, str], mentions: Counter):
+        self.paths = paths
+        self.mentions = mentions
+        self.removals: list[dict] = []
+
+    def remove(self, stmt: dict, kind: str, message: str, suffix: str = "") -> None:
+        path = self.paths.get(id(stmt))
+        if path is not None:
+            self.removals.append({"kind": kind, "message": message, "path": path + suffix})
+
+    def block(self, stmts: list) -> list:
+        kept: list = []
+        for i, stmt in enumerate(stmts):
+            if not isinstance(stmt, dict):
+                kept.append(stmt)
+                continue
+            cleaned = self.statement(stmt)
+            if cleaned is None:
+                continue
+            kept.append(cleaned)
+            if cleaned.get("type") in _TERMINATOR_TYPES:
+                for dead in stmts[i + 1:]:
+                    if isinstance(dead, dict):
+                        self.remove(
+                            dead, "unreachable",
+                            f"this can never run because it follows a {cleaned['type']}",
+                        )
+                break
+        return kept
+
+    def statement(self, stmt: dict) -> dict | None:
+        """Clean a statement's blocks; returns None to drop the statement."""
+        node_type = stmt.get("type")
+
+        if node_type == "Let":
+            name = stmt.get("name")
+            if self.mentions[name] == 1 and _is_safe(stmt.get("value")):
+                self.remove(stmt, "unused-variable", f"the variable '{name}' is never used")
+                return None
+            return stmt
+
+        if node_type == "Assign":
+            value = stmt.get("value")
+            if isinstance(value, dict) and value.get("type") == "Var" and value.get("name") == stmt.get("name"):
+                self.remove(stmt, "no-effect", f"assigning '{stmt.get('name')}' to itself has no effect")
+                return None
+            return stmt
+
+        if node_type == "If":
+            return self.if_statement(stmt)
+
+        if node_type == "While":
+            test = stmt.get("test")
+            if _is_literal(test) and not test.get("value"):
+                self.remove(stmt, "dead-branch", "the loop never runs because its condition is always false")
+                return None
+            stmt["body"] = self.block(stmt.get("body", []))
+            return stmt
+
+        if node_type in ("For", "ForEach"):
+            stmt["body"] = self.block(stmt.get("body", []))
+            iter_expr = stmt.get("iter")
+            literal_iter = isinstance(iter_expr, dict) and iter_expr.get("type") in ("Range", "Array")
+            if (
+                not stmt["body"]
+                and literal_iter
+                and _is_safe(iter_expr)
+                and self.mentions[stmt.get("var")] == 1
+            ):
+                self.remove(stmt, "no-effect", "the loop does nothing")
+                return None
+            return stmt
+
+        if node_type == "TryCatch":
+            stmt["body"] = self.block(stmt.get("body", []))
+            stmt["catch_body"] = self.block(stmt.get("catch_body", []))
+            if stmt.get("finally_body") is not None:
+                stmt["finally_body"] = self.block(stmt["finally_body"])
+            if not stmt["body"] and not stmt.get("finally_body"):
+                self.remove(stmt, "no-effect", "there is nothing to try")
+                return None
+            return stmt
+
+        if node_type == "Switch":
+            for case in stmt.get("cases") or []:
+                if isinstance(case, dict) and isinstance(case.get("body"), list):
+                    case["body"] = self.block(case["body"])
+            if isinstance(stmt.get("default"), list):
+                stmt["default"] = self.block(stmt["default"])
+            return stmt
+
+        if node_type == "FuncDef":
+            stmt["body"] = self.block(stmt.get("body", []))
+            return stmt
+
+        return stmt
+
+    def if_statement(self, stmt: dict) -> dict | None:
+        then_body = self.block(stmt.get("then", []))
+        else_body = stmt.get("else")
+        if else_body is not None:
+            else_body = self.block(else_body)
+        test = stmt.get("test")
+
+        if _is_literal(test):
+            # Rewrite in place so the statement keeps its identity (and path)
+            if not test.get("value"):
+                if then_body:
+                    self.remove(
+                        stmt, "dead-branch",
+                        "this branch never runs because the condition is always false",
+                        ".then",
+                    )
+                stmt["test"] = {"type": "Literal", "value": True}
+                then_body, else_body = else_body or [], None
+            elif else_body:
+                self.remove(
+                    stmt, "dead-branch",
+                    "the otherwise branch never runs because the condition is always true",
+                    ".else",
+                )
+            stmt.pop("else", None)
+            stmt["then"] = then_body
+            return stmt if then_body else None
+
+        if not then_body and not else_body and _is_safe(test):
+            self.remove(stmt, "no-effect", "the condition has no branches that do anything")
+            return None
+        stmt["then"] = then_body
+        if else_body is not None:
+            stmt["else"] = else_body
+        return stmt
@@ -0,0 +1,214 @@
+"""English-level diagnostics for verifier violations.
+
+verify_coreil() reports problems at Core IL paths ("$.body[4].args[0]:
+operator '-' cannot be applied to str and int"), which mean little to
+someone who wrote the program in English. explain_violations() restates
+them in terms of the source, naming the words and sentences involved:
+
+    the word 'total' is used as both text and a number in sentence 2 and sentence 5
+
+Statements are attributed to sentences through the document's source_map
+and the sentence spans frontends store next to it (see
+source_map.sentence_spans), or the English source when the caller has it.
+Violations without an English form, or in documents with no sentence
+information, are left as they are.
+
+Usage:
+    from english_compiler.coreil.diagnostics import format_diagnostics
+
+    for line in format_diagnostics(doc, verify_coreil(doc)):
+        print(line)
+"""
+
+from __future__ import annotations
+
+import re
+from dataclasses import dataclass
+from typing import Any
+
+from .node_nav import is_coreil_node, iter_statements
+from .source_map import SentenceLocation, document_sentences
+from .static_types import BOOL, FLOAT, INT, STR, expr_type, infer_types
+from .verify import format_violation
+
+# How a type is named in a diagnostic; ints and floats are both numbers to
+# the English author
+_TYPE_NOUNS = {INT: "a number", FLOAT: "a number", STR: "text", BOOL: "a true/false value"}
+
+_OPERAND = re.compile(r"^operator '(.+)' cannot be applied to (\w+) and (\w+)$")
+_UNDEFINED_VAR = re.compile(r"^variable '(.+)' used before definition$")
+_UNDEFINED_FUNC = re.compile(r"^call to undefined function '(.+)'$")
+_ARITY = re.compile(r"^function '(.+)' takes (.+) argument\(s\), got (\d+)$")
+_PATH_STEP = re.compile(r"\.(\w+)|\[(\d+)\]")
+_BODY_INDEX = re.compile(r"^\$\.body\[(\d+)\]")
+
+
+@dataclass
+class Diagnostic:
+    """A violation restated in terms of the English source."""
+    message: str
+    sentences: list[SentenceLocation]  # the sentences involved, in source order
+
+
+def explain_violations(
+    doc: dict,
+    violations: list[dict],
+    *,
+    source_text: str | None = None,
+) -> list[Diagnostic | None]:
+    """The English form of each violation, or None where there is none."""
+    context = _Context(doc, document_sentences(doc, source_text) if isinstance(doc, dict) else {})
+    return [context.explain(violation) for violation in violations]
+
+
+def format_diagnostics(
+    doc: dict,
+    violations: list[dict],
+    *,
+    source_text: str | None = None,
+) -> list[str]:
+    """Render violations one per line, each followed by its English form and sentences."""
+    lines = []
+    for violation, diagnostic in zip(
+        violations, explain_violations(doc, violations, source_text=source_text)
+    ):
+        lines.append(format_violation(violation))
+        if diagnostic is None:
+            continue
+        lines.append(f"  {diagnostic.message}")
+        for sentence in diagnostic.sentences:
+            lines.append(f"    sentence {sentence.sentence_id} (line {sentence.line}): {sentence.text}")
+    return lines
+
+
+class _Context:
+    """A document, its statements' sentences and, computed on demand, its types."""
+
+    def __init__(self, doc: Any, sentences: dict[int, SentenceLocation]):
+        self.doc = doc
+        self.sentences = sentences
+        self._types: dict[str | None, dict[str, str]] | None = None
+
+    def explain(self, violation: dict) -> Diagnostic | None:
+        path = violation.get("path", "")
+        match = _BODY_INDEX.match(path)
+        if not match or int(match.group(1)) not in self.sentences:
+            return None
+        top = int(match.group(1))
+        message = violation.get("message", "")
+        node = self._node_at(path)
+
+        if (m := _OPERAND.match(message)) and node is not None and node["type"] == "Binary":
+            return self._operand(node, top, m.group(1))
+        if m := _UNDEFINED_VAR.match(message):
+            return self._undefined_variable(m.group(1), top)
+        if m := _UNDEFINED_FUNC.match(message):
+            return self._diagnostic(f"sentence {self._id(top)} uses '{m.group(1)}', but no sentence defines it", top)
+        if m := _ARITY.match(message):
+            return self._arity(m.group(1), m.group(2), int(m.group(3)), top)
+        return None
+
+    def _operand(self, node: dict, top: int, op: str) -> Diagnostic | None:
+        var_types = self._var_types(top)
+        left, right = node.get("left"), node.get("right")
+        types = [expr_type(side, lambda var: var_types.get(var.get("name"))) for side in (left, right)]
+        nouns = [_TYPE_NOUNS.get(t) for t in types]
+        if None in nouns:
+            return None
+        for side, noun, other in ((left, nouns[0], nouns[1]), (right, nouns[1], nouns[0])):
+            if not (is_coreil_node(side) and side["type"] == "Var") or noun == other:
+                continue
+            name = side.get("name")
+            assigned = self._assignments(name, top)
+            if not assigned or assigned[0] not in self.sentences:
+                continue
+            where = f"sentence {self._id(top)}"
+            if self._id(assigned[0]) != self._id(top):
+                where = f"sentence {self._id(assigned[0])} and {where}"
+            return self._diagnostic(
+                f"the word '{name}' is used as both {noun} and {other} in {where}",
+                assigned[0],
+                top,
+            )
+        return self._diagnostic(
+            f"sentence {self._id(top)} applies '{op}' to {nouns[0]} and {nouns[1]}, which always fails",
+            top,
+        )
+
+    def _undefined_variable(self, name: str, top: int) -> Diagnostic:
+        later = [i for i in self._assignments(name, top) if i > top and i in self.sentences]
+        if later:
+            setter = f"sentence {self._id(later[0])}"
+            if self._id(later[0]) == self._id(top):
+                setter = "that sentence"
+            return self._diagnostic(
+                f"the word '{name}' is used in sentence {self._id(top)} before {setter} gives it a value",
+                top,
+                later[0],
+            )
+        return self._diagnostic(
+            f"the word '{name}' is used in sentence {self._id(top)}, but no sentence gives it a value",
+            top,
+        )
+
+    def _arity(self, name: str, expected: str, got: int, top: int) -> Diagnostic:
+        values = f"{got} value" if got == 1 else f"{got} values"
+        body = self.doc.get("body", [])
+        defs = [
+            int(_BODY_INDEX.match(path).group(1))
+            for path, stmt in iter_statements(body)
+            if stmt["type"] == "FuncDef" and stmt.get("name") == name
+        ]
+        if defs and defs[0] in self.sentences and defs[0] != top:
+            return self._diagnostic(
+                f"sentence {self._id(top)} gives '{name}' {values}, "
+                f"but sentence {self._id(defs[0])} defines it with {expected}",
+                top,
+                defs[0],
+            )
+        return self._diagnostic(f"sentence {self._id(top)} gives '{name}' {values}, but it takes {expected}", top)
+
+    def _id(self, top: int) -> int:
+        return self.sentences[top].sentence_id
+
+    def _diagnostic(self, message: str, *tops: int) -> Diagnostic:
+        """A diagnostic about the sentences of the given top-level statements."""
+        by_id = {self.sentences[top].sentence_id: self.sentences[top] for top in tops}
+        return Diagnostic(message, [by_id[k] for k in sorted(by_id)])
+
+    def _node_at(self, path: str) -> dict | None:
+        """The innermost Core IL node on path."""
+        value: Any = self.doc
+        found = None
+        for key, index in _PATH_STEP.findall(path[1:]):
+            try:
+                value = value[int(index)] if index else value[key]
+            except (KeyError, IndexError, TypeError):
+                break
+            if is_coreil_node(value):
+                found = value
+        return found
+
+    def _assignments(self, name: str, top: int) -> list[int]:
+        """Top-level indices of the statements assigning name in the scope of body[top]."""
+        body = self.doc.get("body", [])
+        in_function = is_coreil_node(body[top]) and body[top]["type"] == "FuncDef"
+        result = []
+        for path, stmt in iter_statements(body):
+            index = int(_BODY_INDEX.match(path).group(1))
+            # A function's variables are its own; the top level's are the
+            # ones outside every function
+            if (index != top) if in_function else body[index]["type"] == "FuncDef":
+                continue
+            target = stmt.get("var") if stmt["type"] in ("For", "ForEach") else stmt.get("name")
+            if stmt["type"] in ("Let", "Assign", "For", "ForEach") and target == name:
+                result.append(index)
+        return result
+
+    def _var_types(self, top: int) -> dict[str, str]:
+        if self._types is None:
+            self._types = infer_types(self.doc)
+        body = self.doc.get("body", [])
+        if body[top]["type"] == "FuncDef":
+            return self._types.get(body[top].get("name"), {})
+        return self._types.get(None, {})
@@ -0,0 +1,65 @@
+"""Values printed by Go programs run with COREIL_DISPLAY.
+
+With COREIL_DISPLAY naming a file, the Go runtime writes a line to it for
+every print of a single value to stdout: "<start> <end> <json>", where
+start and end count the bytes of output before and after the print and
+json encodes the value (see displayLog in coreil_runtime.go):
+
+    {"type": "array", "length": 2, "items": [{"type": "int", "text": "1"}, ...]}
+
+Tools that show a program's result, like the Jupyter kernel and the
+evaluation service, take it to be the value its last statement printed.
+"""
+
+from __future__ import annotations
+
+import json
+from dataclasses import dataclass
+from pathlib import Path
+from typing import Any
+
+
+@dataclass(frozen=True)
+class DisplayRecord:
+    """One single-value print: where its output is, and the value."""
+    start: int
+    end: int
+    value: dict
+
+
+def read_display(path: Path) -> list[DisplayRecord]:
+    """The records of a COREIL_DISPLAY file, or none if it was not written."""
+    try:
+        lines = Path(path).read_text(encoding="utf-8").splitlines()
+    except OSError:
+        return []
+    records = []
+    for line in lines:
+        start, end, value = line.split(" ", 2)
+        records.append(DisplayRecord(int(start), int(end), json.loads(value)))
+    return records
+
+
+def result_record(
+    doc: dict,
+    records: list[DisplayRecord],
+    output_size: int,
+    *,
+    after: int = 0,
+) -> DisplayRecord | None:
+    """The print that produced doc's result, if its last statement printed one value.
+
+    That print must also be the last output, and start at or after the
+    byte offset after. Callers should only ask for programs that succeeded.
+    """
+    body = doc.get("body") or []
+    if not records or not body or not is_single_print(body[-1]):
+        return None
+    last = records[-1]
+    if last.end != output_size or last.start < after:
+        return None
+    return last
+
+
+def is_single_print(stmt: Any) -> bool:
+    return isinstance(stmt, dict) and stmt.get("type") == "Print" and len(stmt.get("args") or []) == 1
@@ -403,6 +403,8 @@ class PythonEmitter(BaseEmitter):
 
     def _emit_deque_new(self, node: dict) -> str:
         self.uses_deque = True
+        if node.get("maxlen") is not None:
+            return f"deque(maxlen={self.emit_expr(node['maxlen'])})"
         return "deque()"
 
     def _emit_deque_size(self, node: dict) -> str:
@@ -427,6 +429,11 @@ class PythonEmitter(BaseEmitter):
         arg = self.emit_expr(node.get("arg"))
         if op == "abs":
             return f"abs({arg})"  # abs is a Python builtin
+        if op == "round":
+            # round is a Python builtin too, with an optional ndigits
+            if node.get("ndigits") is not None:
+                return f"round({arg}, {self.emit_expr(node['ndigits'])})"
+            return f"round({arg})"
         return f"math.{op}({arg})"
 
     def _emit_math_pow(self, node: dict) -> str:
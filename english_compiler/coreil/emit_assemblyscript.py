@@ -34,6 +34,7 @@ from __future__ import annotations
 
 from pathlib import Path
 
+from english_compiler.coreil.constants import EXTENDED_MATH_CONSTANTS, EXTENDED_MATH_OPS
 from english_compiler.coreil.emit_base import BaseEmitter
 
 
@@ -367,6 +368,8 @@ class AssemblyScriptEmitter(BaseEmitter):
         return f"Value.fromInt(({base}).asSet().size())"
 
     def _emit_deque_new(self, node: dict) -> str:
+        if node.get("maxlen") is not None:
+            raise ValueError("DequeNew maxlen is not supported by the WebAssembly backend")
         self.uses_deque = True
         return "Value.fromDeque(new Deque())"
 
@@ -389,6 +392,8 @@ class AssemblyScriptEmitter(BaseEmitter):
     def _emit_math(self, node: dict) -> str:
         self.uses_math = True
         op = node.get("op")
+        if op in EXTENDED_MATH_OPS:
+            raise ValueError(f"Math op '{op}' is not supported by the WebAssembly backend")
         arg = self.emit_expr(node.get("arg"))
         op_map = {
             "sin": "mathSin",
@@ -414,6 +419,8 @@ class AssemblyScriptEmitter(BaseEmitter):
     def _emit_math_const(self, node: dict) -> str:
         self.uses_math = True
         name = node.get("name")
+        if name in EXTENDED_MATH_CONSTANTS:
+            raise ValueError(f"MathConst '{name}' is not supported by the WebAssembly backend")
         if name == "pi":
             return "mathPi()"
         elif name == "e":
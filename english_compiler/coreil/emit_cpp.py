@@ -33,6 +33,7 @@ from __future__ import annotations
 
 from pathlib import Path
 
+from english_compiler.coreil.constants import EXTENDED_MATH_CONSTANTS, EXTENDED_MATH_OPS
 from english_compiler.coreil.emit_base import BaseEmitter
 
 
@@ -341,6 +342,8 @@ class CppEmitter(BaseEmitter):
         return f"coreil::set_size({base})"
 
     def _emit_deque_new(self, node: dict) -> str:
+        if node.get("maxlen") is not None:
+            raise ValueError("DequeNew maxlen is not supported by the C++ backend")
         return "coreil::deque_new()"
 
     def _emit_deque_size(self, node: dict) -> str:
@@ -360,6 +363,8 @@ class CppEmitter(BaseEmitter):
 
     def _emit_math(self, node: dict) -> str:
         op = node.get("op")
+        if op in EXTENDED_MATH_OPS:
+            raise ValueError(f"Math op '{op}' is not supported by the C++ backend")
         arg = self.emit_expr(node.get("arg"))
         math_funcs = {
             "sin": "math_sin",
@@ -383,6 +388,8 @@ class CppEmitter(BaseEmitter):
 
     def _emit_math_const(self, node: dict) -> str:
         name = node.get("name")
+        if name in EXTENDED_MATH_CONSTANTS:
+            raise ValueError(f"MathConst '{name}' is not supported by the C++ backend")
         if name == "pi":
             return "coreil::math_pi()"
         elif name == "e":
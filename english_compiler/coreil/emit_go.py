@@ -5,7 +5,9 @@ This file implements Core IL v1.10 to Go transpilation.
 The generated Go code:
 - Matches interpreter semantics exactly
 - Uses coreil_runtime.go for runtime support (copied alongside output)
-- All values are of type Value (dynamically typed struct)
+- Values are of type Value (dynamically typed struct), except variables
+  proven to hold one primitive type (see static_types.py), which are
+  native int64, float64, string or bool variables
 - Implements short-circuit evaluation
 - Supports all Core IL v1.10 features including JSON and Regex operations
 
@@ -18,9 +20,58 @@ Backward compatibility: Accepts v0.1 through v1.10 programs.
 
 from __future__ import annotations
 
+import hashlib
+import json
+import math
 from pathlib import Path
 
+from english_compiler.coreil.builtins import get_builtin
+from english_compiler.coreil.constants import CAPABILITIES, DIVISION_MODES
 from english_compiler.coreil.emit_base import BaseEmitter
+from english_compiler.coreil.node_nav import (
+    assigned_names,
+    expression_keys,
+    iter_nodes,
+    iter_statements,
+    iter_tail_calls,
+    referenced_names,
+)
+from english_compiler.coreil.source_map import statement_sentences
+from english_compiler.coreil.static_types import (
+    BOOL,
+    COMPARISONS,
+    FLOAT,
+    INT,
+    NUMERIC,
+    STR,
+    binary_type,
+    expr_type,
+    infer_types,
+)
+
+# Native type -> boxing into a Value, and unboxing a Value known to have it
+_BOX = {INT: "ValueInt({})", FLOAT: "ValueFloat({})", STR: "ValueStr({})", BOOL: "ValueBool({})"}
+_UNBOX = {INT: "{}.intData()", FLOAT: "{}.floatData()", STR: "asString({})", BOOL: "{}.boolData()"}
+_GO_TYPES = {INT: "int64", FLOAT: "float64", STR: "string", BOOL: "bool"}
+
+# Overflow-checked native int arithmetic, used when compiling with checked=True
+_CHECKED_OPS = {"+": "checkedAdd", "-": "checkedSub", "*": "checkedMul"}
+
+# Comparisons of Values, as Go bools
+_VALUE_COMPARISONS = {
+    "==": "valueEqual({}, {})",
+    "!=": "!valueEqual({}, {})",
+    "<": "valueLessThan({}, {})",
+    "<=": "valueLessThanOrEqual({}, {})",
+    ">": "valueGreaterThan({}, {})",
+    ">=": "valueGreaterThanOrEqual({}, {})",
+}
+
+
+def program_hash(doc: dict) -> str:
+    """Return the SHA-256 of a Core IL document's canonical JSON."""
+    canonical = json.dumps(doc, sort_keys=True, separators=(",", ":"), ensure_ascii=False)
+    return hashlib.sha256(canonical.encode("utf-8")).hexdigest()
 
 
 class GoEmitter(BaseEmitter):
@@ -30,10 +81,246 @@ class GoEmitter(BaseEmitter):
     def indent_str(self) -> str:
         return "\t"
 
+    def __init__(
+        self,
+        doc: dict,
+        *,
+        test_mode: bool = False,
+        deterministic: bool = False,
+        strict: bool = False,
+        checked: bool = False,
+        division: str | None = None,
+        grants: list[str] | None = None,
+        isolated: bool = False,
+        tail_calls: bool = True,
+        source_text: str | None = None,
+        debug: bool = False,
+        watch: list[str] | None = None,
+        coverage: bool = False,
+        profile: bool = False,
+        memoize: list[str] | dict[str, int] | None = None,
+        typed: bool = True,
+        shared: bool = False,
+    ):
+        self.test_mode = test_mode
+        self.deterministic = deterministic
+        self.strict = strict
+        self.checked = checked
+        self.division = division or doc.get("division", "floor")
+        if self.division not in DIVISION_MODES:
+            raise ValueError(f"unknown division mode: {self.division!r}")
+        self.grants = sorted(set(grants or []))
+        for grant in self.grants:
+            if grant not in CAPABILITIES:
+                raise ValueError(f"unknown capability: {grant!r}")
+        self.isolated = isolated
+        self.tail_calls = tail_calls
+        self.source_text = source_text
+        self.debug = debug
+        self.watch = list(watch or [])
+        self.coverage = coverage
+        self.profile = profile
+        # Memoized function name -> cache size (0 for unbounded)
+        if isinstance(memoize, dict):
+            self.memoize = dict(memoize)
+        else:
+            self.memoize = dict.fromkeys(memoize or [], 0)
+        self.typed = typed
+        self.shared = shared
+        super().__init__(doc)
+
     def _setup_state(self) -> None:
         """Initialize Go-specific state."""
         self._sc_counter = 0
         self._func_names: set[str] = set()
+        # Every FuncDef name; their Go functions get a fn_ prefix so they
+        # cannot collide with runtime helpers such as compose or spawn
+        self._user_funcs: set[str] = {
+            stmt.get("name") for _, stmt in iter_statements(self.doc.get("body", []))
+            if stmt.get("type") == "FuncDef"
+        }
+        # Top-level FuncDef name -> body index, for coreilLoc
+        self._func_indices: dict[str, int] = {
+            stmt.get("name"): i
+            for i, stmt in enumerate(self.doc.get("body", []))
+            if stmt.get("type") == "FuncDef"
+        }
+        # Location table entries: top-level statements first, then every
+        # nested statement (in debug and coverage builds) or the nested
+        # statements that make audited calls (otherwise), each with its
+        # top-level index
+        body = self.doc.get("body", [])
+        self._locations: list[tuple[str, int]] = [(f"$.body[{i}]", i) for i in range(len(body))]
+        # id(statement) -> location index, for per-statement markers
+        self._stmt_locs: dict[int, int] = {}
+        # id(statement) -> location index that its audited calls report,
+        # and that of the statement being emitted; see _emit_audited
+        self._audit_locs: dict[int, int] = {}
+        self._audit_loc: int | None = None
+        for path, stmt in iter_statements(body):
+            top = int(path[len("$.body["):path.index("]")])
+            if path == f"$.body[{top}]":
+                if self.debug or self.coverage:
+                    self._stmt_locs[id(stmt)] = top
+            elif self.debug or self.coverage:
+                self._stmt_locs[id(stmt)] = len(self._locations)
+                self._locations.append((path, top))
+            elif self._makes_audited_call(stmt):
+                self._audit_locs[id(stmt)] = len(self._locations)
+                self._locations.append((path, top))
+        # id(Let node) -> Range of the loop that fills its empty container
+        self._capacity_hints: dict[int, dict] = {}
+        self._collect_capacity_hints(self.doc.get("body", []))
+        # Tail-recursive function groups and the Return nodes that jump
+        # within them; see _collect_tail_calls
+        self._func_defs: dict[str, dict] = {}
+        self._tail_groups: dict[str, list[str]] = {}
+        self._tail_returns: dict[int, str] = {}
+        self._tail_group: list[str] | None = None
+        if self.tail_calls:
+            self._collect_tail_calls(self.doc.get("body", []))
+        # Variables read in the Go function being emitted; Go rejects a
+        # local that is declared and never read
+        self._read_names: set[str] = set()
+        # Function name (None for main) -> variable -> native type, and the
+        # variables of the Go function being emitted
+        self._types = infer_types(self.doc) if self.typed else {}
+        self._var_types: dict[str, str] = {}
+        # Top-level variables that functions read (module constants, for
+        # one): package-level Go vars, which main's Let assigns
+        self._globals = self._collect_globals(self.doc.get("body", []))
+        self._in_func = False
+        # Variables of the Go function being emitted; a Var naming a program
+        # function that none of them shadows is that function as a value
+        self._scope: set[str] = set()
+        # Program functions used as values, each one package-level Value
+        self._func_values: set[str] = set()
+
+    def _collect_globals(self, body: list) -> set[str]:
+        top_level = {stmt.get("name") for stmt in body if stmt.get("type") == "Let"}
+        read: set[str] = set()
+        for stmt in body:
+            if stmt.get("type") == "FuncDef":
+                local = set(stmt.get("params", [])) | assigned_names(stmt.get("body", []))
+                read |= referenced_names(stmt.get("body", [])) - local
+        return top_level & read
+
+    def _collect_tail_calls(self, body: list) -> None:
+        """Find functions whose tail calls form a cycle.
+
+        Each strongly connected component of the tail-call graph becomes a
+        group: a lone self-recursive function is emitted as a loop, and
+        mutually recursive functions share one dispatch loop, so neither
+        grows the Go stack or the IL call stack.
+        """
+        for stmt in body:
+            if stmt.get("type") == "FuncDef":
+                self._func_defs[stmt.get("name")] = stmt
+        edges: dict[str, list[tuple[dict, str]]] = {}
+        for name, func in self._func_defs.items():
+            edges[name] = []
+            if name in self.memoize:
+                continue
+            for ret in iter_tail_calls(func.get("body", [])):
+                callee = self._func_defs.get(ret["value"].get("name"))
+                # Calls to a memoized function must go through its cache
+                if callee is not None and callee.get("name") not in self.memoize and len(
+                    ret["value"].get("args", [])
+                ) == len(callee.get("params", [])):
+                    edges[name].append((ret, callee.get("name")))
+
+        # Tarjan's strongly connected components
+        index: dict[str, int] = {}
+        low: dict[str, int] = {}
+        stack: list[str] = []
+        on_stack: set[str] = set()
+
+        def visit(name: str) -> None:
+            index[name] = low[name] = len(index)
+            stack.append(name)
+            on_stack.add(name)
+            for _, callee in edges[name]:
+                if callee not in index:
+                    visit(callee)
+                    low[name] = min(low[name], low[callee])
+                elif callee in on_stack:
+                    low[name] = min(low[name], index[callee])
+            if low[name] == index[name]:
+                component: list[str] = []
+                while True:
+                    member = stack.pop()
+                    on_stack.discard(member)
+                    component.append(member)
+                    if member == name:
+                        break
+                members = set(component)
+                jumps = [(ret, c) for m in component for ret, c in edges[m] if c in members]
+                if not jumps:
+                    return
+                group = [n for n in self._func_defs if n in members]
+                for member in group:
+                    self._tail_groups[member] = group
+                for ret, callee in jumps:
+                    self._tail_returns[id(ret)] = callee
+
+        for name in self._func_defs:
+            if name not in index:
+                visit(name)
+
+    def _collect_capacity_hints(self, stmts: list) -> None:
+        """Find empty arrays/maps filled by the counted loop right after them.
+
+        `Let xs = []` followed by `For i in Range(a, b)` whose body pushes to
+        xs (or sets keys of a map) gets a capacity hint of the range length,
+        so construction loops such as "a list of 10,000 zeros" allocate once.
+        """
+        for i, stmt in enumerate(stmts):
+            if not isinstance(stmt, dict):
+                continue
+            for value in stmt.values():
+                if isinstance(value, list) and any(isinstance(s, dict) and "type" in s for s in value):
+                    self._collect_capacity_hints(value)
+                elif isinstance(value, list):
+                    for case in value:
+                        if isinstance(case, dict) and isinstance(case.get("body"), list):
+                            self._collect_capacity_hints(case["body"])
+            if i + 1 < len(stmts):
+                hint = self._capacity_hint(stmt, stmts[i + 1])
+                if hint is not None:
+                    self._capacity_hints[id(stmt)] = hint
+
+    def _capacity_hint(self, let: dict, loop: dict) -> dict | None:
+        """Return the Range whose length sizes a Let/For pair, if any."""
+        if let.get("type") != "Let" or not isinstance(loop, dict) or loop.get("type") != "For":
+            return None
+        value = let.get("value") or {}
+        if value.get("type") not in ("Array", "Map") or value.get("items"):
+            return None
+        rng = loop.get("iter") or {}
+        if rng.get("type") != "Range":
+            return None
+        # Only side-effect-free bounds can be evaluated ahead of the loop
+        for bound in (rng.get("from") or {}, rng.get("to") or {}):
+            if bound.get("type") == "Var":
+                continue
+            if bound.get("type") != "Literal" or type(bound.get("value")) is not int:
+                return None
+        fill = "Push" if value["type"] == "Array" else "Set"
+        target = {"type": "Var", "name": let.get("name")}
+        if not any(
+            isinstance(s, dict) and s.get("type") == fill and s.get("base") == target
+            for s in loop.get("body", [])
+        ):
+            return None
+        return rng
+
+    def _emit_capacity(self, rng: dict) -> str:
+        """Emit the length of a Range as a Go int64 expression."""
+        lo, hi = rng["from"], rng["to"]
+        extra = 1 if rng.get("inclusive") else 0
+        if lo["type"] == "Literal" and hi["type"] == "Literal":
+            return str(max(hi["value"] - lo["value"] + extra, 0))
+        return f"{self._emit_int(hi)}-{self._emit_int(lo)}+{extra}"
 
     def _next_sc_var(self) -> str:
         """Generate unique variable name for short-circuit evaluation."""
@@ -53,27 +340,229 @@ class GoEmitter(BaseEmitter):
         for i in func_def_indices:
             self._func_names.add(body[i].get("name", ""))
 
+        for name in sorted(self._globals):
+            self.emit_line(f"var {name} Value")
+        if self._globals:
+            self.emit_line("")
+
         # Generate function definitions
+        self._in_func = True
         for i in func_def_indices:
             start = len(self.lines)
             self.emit_stmt(body[i])
             self.emit_line("")
             end = len(self.lines)
             self.coreil_line_map[i] = list(range(start, end))
+        self._in_func = False
+
+        if self.test_mode:
+            self._emit_test_main(body, func_def_indices)
+            return self._build_output()
+        if self.shared:
+            self._emit_shared_program(body, func_def_indices, main_indices)
+            return self._build_output()
 
         # Generate main function
+        self._read_names = referenced_names([body[i] for i in main_indices])
+        self._scope = assigned_names([body[i] for i in main_indices])
+        self._var_types = self._main_types()
         self.emit_line("func main() {")
         self.indent_level = 1
+        self.emit_line("defer coreilFlush()")
+        self._emit_engine_setup()
+        if self.isolated:
+            self.emit_line("coreilRunIsolated(func() {")
+            self.indent_level += 1
         for i in main_indices:
             start = len(self.lines)
+            if not self._stmt_locs:
+                self.emit_line(f"coreilLoc({i})")
             self.emit_stmt(body[i])
             end = len(self.lines)
             self.coreil_line_map[i] = list(range(start, end))
+        if self.isolated:
+            self.indent_level -= 1
+            self.emit_line("})")
         self.indent_level = 0
         self.emit_line("}")
 
         return self._build_output()
 
+    def _emit_test_main(self, body: list[dict], func_def_indices: list[int]) -> None:
+        """Emit a main that runs every zero-argument test_* function.
+
+        Top-level statements are not executed in test mode.
+        """
+        tests = [
+            body[i].get("name", "")
+            for i in func_def_indices
+            if body[i].get("name", "").startswith("test_") and not body[i].get("params")
+        ]
+        self.emit_line("func main() {")
+        self.indent_level = 1
+        self._emit_engine_setup()
+        self.emit_line("coreilRunTests([]TestCase{")
+        self.indent_level += 1
+        for name in tests:
+            self.emit_line(f'{{"{name}", {_go_func(name)}}},')
+        self.indent_level -= 1
+        self.emit_line("})")
+        self.indent_level = 0
+        self.emit_line("}")
+
+    def _emit_shared_program(
+        self, body: list[dict], func_def_indices: list[int], main_indices: list[int]
+    ) -> None:
+        """Emit the program for a c-shared library (see coreil_cshared.go).
+
+        The top-level statements become coreilProgram, which engine_create
+        runs, and the top-level functions are listed in coreilExports for
+        engine_eval. main is empty: the host drives the program.
+        """
+        self.emit_line("var coreilExports = map[string]coreilExport{")
+        self.indent_level += 1
+        for i in func_def_indices:
+            name = body[i].get("name", "")
+            params = body[i].get("params", [])
+            args = ", ".join(f"args[{j}]" for j in range(len(params)))
+            self.emit_line(f'"{name}": {{{len(params)}, func(args []Value) Value {{ return {_go_func(name)}({args}) }}}},')
+        self.indent_level -= 1
+        self.emit_line("}")
+        self.emit_line("")
+        self._read_names = referenced_names([body[i] for i in main_indices])
+        self._scope = assigned_names([body[i] for i in main_indices])
+        self._var_types = self._main_types()
+        self.emit_line("func coreilProgram() {")
+        self.indent_level = 1
+        self._emit_engine_setup()
+        for i in main_indices:
+            start = len(self.lines)
+            if not self._stmt_locs:
+                self.emit_line(f"coreilLoc({i})")
+            self.emit_stmt(body[i])
+            end = len(self.lines)
+            self.coreil_line_map[i] = list(range(start, end))
+        self.indent_level = 0
+        self.emit_line("}")
+        self.emit_line("")
+        self.emit_line("func main() {}")
+
+    def _main_types(self) -> dict[str, str]:
+        # Globals are declared as Value
+        main_types = self._types.get(None, {})
+        return {name: t for name, t in main_types.items() if name not in self._globals}
+
+    def _emit_engine_setup(self) -> None:
+        """Emit DefaultEngine configuration at the top of main."""
+        self._emit_source_locations()
+        self.emit_line(f'DefaultEngine.SetProgramHash("{program_hash(self.doc)}")')
+        if self.coverage:
+            self.emit_line("DefaultEngine.EnableCoverage()")
+        if self.deterministic:
+            self.emit_line("DefaultEngine.SetDeterministic(&DeterministicConfig{})")
+        if self.strict:
+            self.emit_line("DefaultEngine.SetStrict(true)")
+        if self.checked:
+            self.emit_line("DefaultEngine.SetCheckedArithmetic(true)")
+        if self.division == "truncate":
+            self.emit_line("DefaultEngine.SetDivision(DivisionTruncate)")
+        if self.grants:
+            caps = ", ".join(f"Capability({json.dumps(grant)})" for grant in self.grants)
+            self.emit_line(f"DefaultEngine.SetSandbox({caps})")
+        for name in self.watch:
+            self.emit_line(f'DefaultEngine.WatchVariable("{name}", nil)')
+        if self._globals:
+            refs = ", ".join(f'"{name}": &{name}' for name in sorted(self._globals))
+            self.emit_line(f"coreilGlobals(map[string]*Value{{{refs}}})")
+        if self.shared:
+            # The host configures the engine, and Engine.Run begins the run
+            return
+        if self.debug and not self.test_mode:
+            self.emit_line("coreilServeDAP()")
+            self.emit_line("coreilSnapshotOnError()")
+        self.emit_line("coreilConfigure()")
+        self.emit_line("coreilTrace()")
+        self.emit_line("coreilCheckpoint()")
+        if self.profile and not self.test_mode:
+            self.emit_line("coreilStartProfile()")
+        if not self.test_mode:
+            self.emit_line("coreilStartRun()")
+
+    def emit_stmt(self, node: dict) -> None:
+        """Emit a statement, preceded by a marker in debug and coverage builds.
+
+        coreilDebug is where the debugger checks breakpoints and steps, and
+        coreilCover counts the statement; Let and Assign also report the
+        new value for variable inspection and watchpoints.
+        """
+        index = self._stmt_locs.get(id(node))
+        if index is not None and node.get("type") != "FuncDef":
+            marker = "coreilDebug" if self.debug else "coreilCover"
+            self.emit_line(f"{marker}({index})")
+        outer_audit_loc, self._audit_loc = self._audit_loc, self._audit_locs.get(id(node))
+        super().emit_stmt(node)
+        self._audit_loc = outer_audit_loc
+        if node.get("type") in ("Let", "Assign"):
+            self._emit_debug_var(node.get("name"))
+
+    @staticmethod
+    def _makes_audited_call(stmt: dict) -> bool:
+        """True if the statement's own expressions make a call the audit sink sees.
+
+        Those are ExternalCalls and the builtins that need a capability,
+        such as readLines.
+        """
+        for key in expression_keys(stmt):
+            for node in iter_nodes(stmt[key]):
+                if node.get("type") == "ExternalCall":
+                    return True
+                if node.get("type") == "Call":
+                    builtin = get_builtin(node.get("name"))
+                    if builtin is not None and builtin.capability:
+                        return True
+        return False
+
+    def _emit_audited(self, call: str) -> str:
+        """Report a nested statement's location to the audit sink for call.
+
+        Without per-statement markers the engine only knows the top-level
+        statement; coreilAudited points it at the nested one for the call.
+        """
+        if self._audit_loc is None:
+            return call
+        return f"coreilAudited({self._audit_loc}, func() Value {{ return {call} }})"
+
+    def _emit_debug_var(self, name: str) -> None:
+        """Report a variable's value to the debugger or its watchpoint."""
+        if self.debug or name in self.watch:
+            self.emit_line(f'coreilDebugVar("{name}", {self._emit_var({"type": "Var", "name": name})})')
+
+    def _emit_source_locations(self) -> None:
+        """Emit the table of statement locations that coreilLoc indexes.
+
+        When the English source is available, each entry also names the
+        sentence the statement was compiled from (see statement_sentences),
+        so runtime errors can point back to it. Nested statements share
+        their top-level statement's sentence.
+        """
+        sentences = {}
+        source_map = self.doc.get("source_map")
+        if self.source_text and source_map:
+            sentences = statement_sentences(self.source_text, source_map)
+        self.emit_line("DefaultEngine.SetSourceLocations([]SourceLocation{")
+        self.indent_level += 1
+        for path, top in self._locations:
+            sentence = sentences.get(top)
+            if sentence is None:
+                self.emit_line(f'{{IL: "{path}"}},')
+                continue
+            self.emit_line(
+                f'{{IL: "{path}", Sentence: "{self.escape_string(sentence.text)}", '
+                f"SentenceID: {sentence.sentence_id}, Line: {sentence.line}, Column: {sentence.column}}},"
+            )
+        self.indent_level -= 1
+        self.emit_line("})")
+
     def _build_output(self) -> str:
         """Build final output with headers."""
         header_lines = [
@@ -92,7 +581,7 @@ class GoEmitter(BaseEmitter):
             for k, v in self.coreil_line_map.items()
         }
 
-        return "\n".join(header_lines + self.lines) + "\n"
+        return "\n".join(header_lines + self.lines + self._function_value_decls()) + "\n"
 
     # ========== Expression Handlers ==========
 
@@ -108,36 +597,63 @@ class GoEmitter(BaseEmitter):
         elif isinstance(value, int):
             return f"ValueInt({value})"
         elif isinstance(value, float):
-            return f"ValueFloat({value})"
+            # Go constant expressions cannot spell -0.0, inf or nan
+            if math.isnan(value):
+                return "mathNaN()"
+            if math.isinf(value):
+                return "mathInf()" if value > 0 else "valueNegate(mathInf())"
+            if value == 0.0 and math.copysign(1.0, value) < 0:
+                return "valueNegate(ValueFloat(0.0))"
+            return f"ValueFloat({value!r})"
         raise ValueError(f"unsupported literal type: {type(value)}")
 
-    def _emit_short_circuit(self, node: dict, *, eval_right_on_truthy: bool) -> str:
-        """Emit short-circuit and/or using Go's func literal for lazy eval."""
-        left = self.emit_expr(node.get("left"))
-        right = self.emit_expr(node.get("right"))
-        if eval_right_on_truthy:
-            # and: if left truthy, evaluate right; else false
-            return (
-                f"func() Value {{ __l := {left}; "
-                f"if isTruthy(__l) {{ __r := {right}; return ValueBool(isTruthy(__r)) }} "
-                f"else {{ return ValueBool(false) }} }}()"
-            )
-        else:
-            # or: if left truthy, true; else evaluate right
-            return (
-                f"func() Value {{ __l := {left}; "
-                f"if isTruthy(__l) {{ return ValueBool(true) }} "
-                f"else {{ __r := {right}; return ValueBool(isTruthy(__r)) }} }}()"
+    def _emit_var(self, node: dict) -> str:
+        name = node.get("name", "")
+        native = self._var_types.get(name)
+        if native is not None:
+            return _BOX[native].format(name)
+        if name in self._user_funcs and name not in self._scope:
+            return self._function_value(name)
+        return name
+
+    def _function_value(self, name: str) -> str:
+        """The program function name as a function Value.
+
+        Every use shares one Value, so the function is equal to itself and
+        can be passed to eventOff after eventOn.
+        """
+        self._func_values.add(name)
+        return f"__func_{name}"
+
+    def _function_value_decls(self) -> list[str]:
+        """Declare the function Values _function_value handed out.
+
+        They are assigned in init: a function that refers to its own
+        Value would otherwise be an initialization cycle.
+        """
+        if not self._func_values:
+            return []
+        names = sorted(self._func_values)
+        lines = ["", *(f"var __func_{name} Value" for name in names), "", "func init() {"]
+        for name in names:
+            index = self._func_indices.get(name)
+            params = self.doc["body"][index].get("params", []) if index is not None else []
+            args = ", ".join(f"__args[{i}]" for i in range(len(params)))
+            lines.append(
+                f'\t__func_{name} = ValueFunc("{name}", func(__args []Value) Value {{ '
+                f"return {_go_func(name)}({args}) }})"
             )
+        lines.append("}")
+        return lines
 
     def _emit_binary(self, node: dict) -> str:
-        op = node.get("op")
-
-        if op == "and":
-            return self._emit_short_circuit(node, eval_right_on_truthy=True)
-        elif op == "or":
-            return self._emit_short_circuit(node, eval_right_on_truthy=False)
+        native_type = self._type_of(node)
+        if native_type is not None:
+            native = self._native_binary(node, native_type)
+            if native is not None:
+                return _BOX[native_type].format(native)
 
+        op = node.get("op")
         left = self.emit_expr(node.get("left"))
         right = self.emit_expr(node.get("right"))
 
@@ -151,20 +667,7 @@ class GoEmitter(BaseEmitter):
 
         if op in op_map:
             return f"{op_map[op]}({left}, {right})"
-        elif op == "==":
-            return f"ValueBool(valueEqual({left}, {right}))"
-        elif op == "!=":
-            return f"ValueBool(!valueEqual({left}, {right}))"
-        elif op == "<":
-            return f"ValueBool(valueLessThan({left}, {right}))"
-        elif op == "<=":
-            return f"ValueBool(valueLessThanOrEqual({left}, {right}))"
-        elif op == ">":
-            return f"ValueBool(valueGreaterThan({left}, {right}))"
-        elif op == ">=":
-            return f"ValueBool(valueGreaterThanOrEqual({left}, {right}))"
-        else:
-            raise ValueError(f"unknown binary operator: {op}")
+        raise ValueError(f"unknown binary operator: {op}")
 
     def _emit_array(self, node: dict) -> str:
         items = node.get("items", [])
@@ -185,6 +688,8 @@ class GoEmitter(BaseEmitter):
         return f"arraySlice({base}, {start}, {end})"
 
     def _emit_not(self, node: dict) -> str:
+        if self._type_of(node.get("arg")) is not None:
+            return f"ValueBool(!{self._emit_truth(node.get('arg'))})"
         arg = self.emit_expr(node.get("arg"))
         return f"logicalNot({arg})"
 
@@ -195,8 +700,34 @@ class GoEmitter(BaseEmitter):
     def _emit_call_expr(self, node: dict) -> str:
         name = node.get("name")
         args = node.get("args", [])
+        if name == "forAll":
+            return self._emit_for_all(args)
         arg_strs = [self.emit_expr(arg) for arg in args]
-        return f"{name}({', '.join(arg_strs)})"
+        call = f"{self._callee(name)}({', '.join(arg_strs)})"
+        builtin = get_builtin(name)
+        if builtin is not None and builtin.capability:
+            return self._emit_audited(call)
+        return call
+
+    def _callee(self, name: str) -> str:
+        """The Go function a Call of name runs.
+
+        As in the interpreter, a builtin it implements wins over a function
+        the program defines with the same name.
+        """
+        builtin = get_builtin(name)
+        if name not in self._user_funcs:
+            return name
+        if builtin is not None and builtin.kind == "call" and "coreil" in builtin.targets:
+            return name
+        return _go_func(name)
+
+    def _emit_for_all(self, args: list) -> str:
+        # The property is a function name; pass the function itself so the
+        # runtime can call it with generated arguments
+        func = self._function_value(args[1].get("value"))
+        arg_strs = [self.emit_expr(args[0]), func] + [self.emit_expr(arg) for arg in args[2:]]
+        return f"forAll({', '.join(arg_strs)})"
 
     def _emit_map(self, node: dict) -> str:
         items = node.get("items", [])
@@ -320,6 +851,8 @@ class GoEmitter(BaseEmitter):
         return f"setSize({base})"
 
     def _emit_deque_new(self, node: dict) -> str:
+        if node.get("maxlen") is not None:
+            return f"ValueDequeNewBounded({self.emit_expr(node['maxlen'])})"
         return "ValueDequeNew()"
 
     def _emit_deque_size(self, node: dict) -> str:
@@ -344,7 +877,14 @@ class GoEmitter(BaseEmitter):
             "sin": "mathSin", "cos": "mathCos", "tan": "mathTan",
             "sqrt": "mathSqrt", "floor": "mathFloor", "ceil": "mathCeil",
             "abs": "mathAbs", "log": "mathLog", "exp": "mathExp",
+            "trunc": "mathTrunc", "asin": "mathAsin", "acos": "mathAcos",
+            "atan": "mathAtan", "log2": "mathLog2", "log10": "mathLog10",
+            "sinh": "mathSinh", "cosh": "mathCosh", "tanh": "mathTanh",
         }
+        if op == "round":
+            ndigits = node.get("ndigits")
+            ndigits_str = "ValueNone" if ndigits is None else self.emit_expr(ndigits)
+            return f"mathRound({arg}, {ndigits_str})"
         if op not in math_funcs:
             raise ValueError(f"unknown math operation: {op}")
         return f"{math_funcs[op]}({arg})"
@@ -360,6 +900,10 @@ class GoEmitter(BaseEmitter):
             return "mathPi()"
         elif name == "e":
             return "mathE()"
+        elif name == "inf":
+            return "mathInf()"
+        elif name == "nan":
+            return "mathNaN()"
         raise ValueError(f"unknown math constant: {name}")
 
     def _emit_json_parse(self, node: dict) -> str:
@@ -414,9 +958,10 @@ class GoEmitter(BaseEmitter):
     def _emit_external_call(self, node: dict) -> str:
         module = node.get("module")
         function = node.get("function")
-        raise ValueError(
-            f"ExternalCall to {module}.{function} is not supported in Go backend."
-        )
+        args = [self.emit_expr(arg) for arg in node.get("args", [])]
+        names = [f'"{self.escape_string(module)}"', f'"{self.escape_string(function)}"']
+        call_args = ", ".join(names + args)
+        return self._emit_audited(f"externalCall({call_args})")
 
     def _emit_method_call(self, node: dict) -> str:
         obj = self.emit_expr(node.get("object"))
@@ -443,11 +988,11 @@ class GoEmitter(BaseEmitter):
         return f"valueToStringConvert({value})"
 
     def _emit_ternary(self, node: dict) -> str:
-        test = self.emit_expr(node.get("test"))
+        test = self._emit_truth(node.get("test"))
         consequent = self.emit_expr(node.get("consequent"))
         alternate = self.emit_expr(node.get("alternate"))
         # Use IIFE for short-circuit: only the chosen branch is evaluated
-        return (f"func() Value {{ if isTruthy({test}) {{ return {consequent} }} "
+        return (f"func() Value {{ if {test} {{ return {consequent} }} "
                 f"else {{ return {alternate} }} }}()")
 
     def _emit_string_format(self, node: dict) -> str:
@@ -457,21 +1002,163 @@ class GoEmitter(BaseEmitter):
             return 'ValueStr("")'
         return f"stringFormat({', '.join(part_strs)})"
 
+    # ========== Typed Values ==========
+
+    def _type_of(self, node: dict) -> str | None:
+        """The native type of an expression's value, or None if dynamic."""
+        return expr_type(node, lambda var: self._var_types.get(var.get("name")))
+
+    def _emit_typed(self, node: dict, native_type: str) -> str:
+        """Emit an expression of the given type as a native Go value.
+
+        Where the expression's own type differs, the code is unreachable
+        (the analysis skips it), so unboxing it only needs to compile.
+        """
+        if self._type_of(node) == native_type:
+            native = self._native_expr(node, native_type)
+            if native is not None:
+                return native
+        return _UNBOX[native_type].format(self.emit_expr(node))
+
+    def _native_expr(self, node: dict, native_type: str) -> str | None:
+        """Go code computing node natively, or None to unbox its Value."""
+        node_type = node.get("type")
+        if node_type == "Literal":
+            return self._native_literal(node.get("value"))
+        if node_type == "Var":
+            return node.get("name") if self._var_types.get(node.get("name")) == native_type else None
+        if node_type == "Binary":
+            return self._native_binary(node, native_type)
+        if node_type == "Not":
+            return f"!{self._emit_truth(node.get('arg'))}"
+        if node_type == "Ternary" and all(
+            self._type_of(node.get(key)) == native_type for key in ("consequent", "alternate")
+        ):
+            test = self._emit_truth(node.get("test"))
+            consequent = self._emit_typed(node.get("consequent"), native_type)
+            alternate = self._emit_typed(node.get("alternate"), native_type)
+            return (f"func() {_GO_TYPES[native_type]} {{ if {test} {{ return {consequent} }} "
+                    f"else {{ return {alternate} }} }}()")
+        return None
+
+    def _native_literal(self, value) -> str | None:
+        """A literal as a Go constant of its native type, if it has one."""
+        if isinstance(value, bool):
+            return "true" if value else "false"
+        if isinstance(value, int):
+            return f"int64({value})"
+        if isinstance(value, float):
+            # Go constants cannot spell -0.0, inf or nan
+            if math.isnan(value) or math.isinf(value) or (value == 0.0 and math.copysign(1.0, value) < 0):
+                return None
+            return repr(value)
+        if isinstance(value, str):
+            return f'"{self.escape_string(value)}"'
+        return None
+
+    def _native_binary(self, node: dict, native_type: str) -> str | None:
+        op = node.get("op")
+        left, right = node.get("left"), node.get("right")
+        if op in ("and", "or"):
+            go_op = "&&" if op == "and" else "||"
+            return f"({self._emit_truth(left)} {go_op} {self._emit_truth(right)})"
+        left_type, right_type = self._type_of(left), self._type_of(right)
+        if op in COMPARISONS:
+            return self._native_comparison(op, left, right, left_type, right_type)
+        # Go evaluates arithmetic on constants at compile time, where
+        # overflow and division by zero are errors rather than runtime ones
+        if binary_type(op, left_type, right_type) != native_type or (
+            _is_constant(left) and _is_constant(right)
+        ):
+            return None
+        if native_type == STR:
+            return f"concatStrings({self._emit_typed(left, STR)}, {self._emit_typed(right, STR)})"
+        if native_type == INT:
+            left_code, right_code = self._emit_typed(left, INT), self._emit_typed(right, INT)
+            if op == "%":
+                return f"intModulo({left_code}, {right_code})"
+            if self.checked and op in _CHECKED_OPS:
+                return f"{_CHECKED_OPS[op]}({left_code}, {right_code})"
+            return f"({left_code} {op} {right_code})"
+        left_code, right_code = self._emit_float(left, left_type), self._emit_float(right, right_type)
+        if op == "/":
+            return f"floatDivide({left_code}, {right_code})"
+        if op == "%":
+            return f"floatModulo({left_code}, {right_code})"
+        if op == "*":
+            # The conversion stops Go fusing the product into a following
+            # addition, which would round differently from the Value path
+            return f"float64({left_code} * {right_code})"
+        return f"({left_code} {op} {right_code})"
+
+    def _native_comparison(
+        self, op: str, left: dict, right: dict, left_type: str | None, right_type: str | None
+    ) -> str:
+        if left_type == right_type and left_type in (INT, STR) or (
+            left_type == right_type == BOOL and op in ("==", "!=")
+        ):
+            return f"({self._emit_typed(left, left_type)} {op} {self._emit_typed(right, right_type)})"
+        if left_type in NUMERIC and right_type in NUMERIC:
+            left_code, right_code = self._emit_float(left, left_type), self._emit_float(right, right_type)
+            # valueGreaterThan is !(a <= b), which differs from a > b for NaN
+            if op == ">":
+                return f"!({left_code} <= {right_code})"
+            if op == ">=":
+                return f"!({left_code} < {right_code})"
+            return f"({left_code} {op} {right_code})"
+        return _VALUE_COMPARISONS[op].format(self.emit_expr(left), self.emit_expr(right))
+
+    def _emit_float(self, node: dict, native_type: str) -> str:
+        """Emit a numeric expression as a float64, as asFloat converts it."""
+        code = self._emit_typed(node, native_type)
+        return code if native_type == FLOAT else f"float64({code})"
+
+    def _emit_int(self, node: dict) -> str:
+        """Emit an expression as an int64, as asInt converts it."""
+        if self._type_of(node) == INT:
+            return self._emit_typed(node, INT)
+        return f"asInt({self.emit_expr(node)})"
+
+    def _emit_truth(self, node: dict) -> str:
+        """Emit whether an expression's value is truthy, as a Go bool."""
+        native_type = self._type_of(node)
+        if native_type is None:
+            return f"isTruthy({self.emit_expr(node)})"
+        code = self._emit_typed(node, native_type)
+        if native_type in NUMERIC:
+            return f"({code} != 0)"
+        if native_type == STR:
+            return f'({code} != "")'
+        return code
+
     # ========== Statement Handlers ==========
 
     def _emit_let(self, node: dict) -> None:
         name = node.get("name")
-        value = self.emit_expr(node.get("value"))
-        self.emit_line(f"{name} := {value}")
+        op = "=" if name in self._globals and not self._in_func else ":="
+        rng = self._capacity_hints.get(id(node))
+        if rng is not None:
+            ctor = "ValueArrayWithCapacity" if node["value"]["type"] == "Array" else "ValueMapWithCapacity"
+            self.emit_line(f"{name} {op} {ctor}({self._emit_capacity(rng)})")
+        else:
+            self.emit_line(f"{name} {op} {self._emit_value(name, node.get('value'))}")
+        if op == ":=" and name not in self._read_names:
+            self.emit_line(f"_ = {name}")
 
     def _emit_assign(self, node: dict) -> None:
         name = node.get("name")
-        value = self.emit_expr(node.get("value"))
-        self.emit_line(f"{name} = {value}")
+        self.emit_line(f"{name} = {self._emit_value(name, node.get('value'))}")
+
+    def _emit_value(self, name: str, value: dict) -> str:
+        """Emit the value assigned to a variable, natively if it is typed."""
+        native_type = self._var_types.get(name)
+        if native_type is not None:
+            return self._emit_typed(value, native_type)
+        return self.emit_expr(value)
 
     def _emit_if(self, node: dict) -> None:
-        test = self.emit_expr(node.get("test"))
-        self.emit_line(f"if isTruthy({test}) {{")
+        test = self._emit_truth(node.get("test"))
+        self.emit_line(f"if {test} {{")
         self.indent_level += 1
         then_body = node.get("then", [])
         if not then_body:
@@ -491,11 +1178,11 @@ class GoEmitter(BaseEmitter):
         self.emit_line("}")
 
     def _emit_while(self, node: dict) -> None:
-        test_expr = node.get("test")
-        test = self.emit_expr(test_expr)
+        test = self._emit_truth(node.get("test"))
         self.emit_line("for {")
         self.indent_level += 1
-        self.emit_line(f"if !isTruthy({test}) {{ break }}")
+        self.emit_line("coreilStep()")
+        self.emit_line(f"if !{test} {{ break }}")
         body = node.get("body", [])
         for stmt in body:
             self.emit_stmt(stmt)
@@ -575,20 +1262,142 @@ class GoEmitter(BaseEmitter):
         name = node.get("name")
         params = node.get("params", [])
         param_strs = [f"{p} Value" for p in params]
-        self.emit_line(f"func {name}({', '.join(param_strs)}) Value {{")
+        group = self._tail_groups.get(name)
+        if group is not None and len(group) > 1:
+            # Mutually tail-recursive: enter the group's shared dispatch loop
+            if name == group[0]:
+                self._emit_tail_dispatch(group)
+            self.emit_line(f"func {_go_func(name)}({', '.join(param_strs)}) Value {{")
+            self.indent_level += 1
+            self.emit_line(f'coreilEnter("{name}")')
+            self.emit_line("defer coreilLeave()")
+            dispatch = _tail_dispatch_name(group)
+            self.emit_line(f"return {dispatch}({group.index(name)}, []Value{{{', '.join(params)}}})")
+            self.indent_level -= 1
+            self.emit_line("}")
+            return
+
+        go_name = _go_func(name)
+        if name in self.memoize:
+            # Calls, recursive ones included, go through the cache
+            go_name = f"__uncached_{name}"
+            self.emit_line(f'var __memo_{name} = newMemo("{name}", {self.memoize[name]})')
+            self.emit_line("")
+            self.emit_line(f"func {_go_func(name)}({', '.join(param_strs)}) Value {{")
+            self.indent_level += 1
+            self.emit_line(
+                f"return __memo_{name}.call([]Value{{{', '.join(params)}}}, "
+                f"func() Value {{ return {go_name}({', '.join(params)}) }})"
+            )
+            self.indent_level -= 1
+            self.emit_line("}")
+            self.emit_line("")
+
+        self.emit_line(f"func {go_name}({', '.join(param_strs)}) Value {{")
         self.indent_level += 1
-        body = node.get("body", [])
+        if group is None:
+            self.emit_line("coreilStep()")
+        self.emit_line(f'coreilEnter("{name}")')
+        self.emit_line("defer coreilLeave()")
+        self._emit_func_loc(name)
+        if group is None:
+            for param in params:
+                self._emit_debug_var(param)
+            self._emit_func_body(name, node.get("body", []))
+        else:
+            # Self tail calls reassign the parameters and loop
+            self._tail_group = group
+            self._emit_tail_loop_start()
+            for param in params:
+                self._emit_debug_var(param)
+            self._emit_func_body(name, node.get("body", []))
+            self._emit_tail_loop_end()
+            self._tail_group = None
+        self.indent_level -= 1
+        self.emit_line("}")
+
+    def _emit_func_loc(self, name: str) -> None:
+        """Point the current location at a top-level function's definition."""
+        index = self._func_indices.get(name)
+        if index is not None:
+            self.emit_line(f"coreilLoc({index})")
+
+    def _emit_func_body(self, name: str, body: list) -> None:
         if not body:
             self.emit_line("return ValueNone")
-        else:
-            for stmt in body:
-                self.emit_stmt(stmt)
-            if body and body[-1].get("type") != "Return":
-                self.emit_line("return ValueNone")
+            return
+        outer_reads, outer_types, outer_scope = self._read_names, self._var_types, self._scope
+        self._read_names = referenced_names(body)
+        self._var_types = self._types.get(name, {})
+        index = self._func_indices.get(name)
+        params = self.doc["body"][index].get("params", []) if index is not None else []
+        self._scope = set(params) | assigned_names(body) | self._globals
+        for stmt in body:
+            self.emit_stmt(stmt)
+        self._read_names, self._var_types, self._scope = outer_reads, outer_types, outer_scope
+        if body[-1].get("type") != "Return":
+            self.emit_line("return ValueNone")
+
+    def _emit_tail_dispatch(self, group: list[str]) -> None:
+        """Emit the loop shared by a group of mutually tail-recursive functions.
+
+        __fn selects the member to run and __args holds its arguments; a tail
+        call within the group sets both and continues the loop.
+        """
+        self.emit_line(f"func {_tail_dispatch_name(group)}(__fn int, __args []Value) Value {{")
+        self.indent_level += 1
+        self._tail_group = group
+        self._emit_tail_loop_start()
+        self.emit_line("switch __fn {")
+        for i, member in enumerate(group):
+            self.emit_line(f"case {i}:")
+            self.indent_level += 1
+            self.emit_line(f'coreilTail("{member}")')
+            self._emit_func_loc(member)
+            params = self._func_defs[member].get("params", [])
+            if params:
+                args = ", ".join(f"__args[{j}]" for j in range(len(params)))
+                self.emit_line(f"{', '.join(params)} := {args}")
+                self.emit_line(f"{', '.join('_' for _ in params)} = {', '.join(params)}")
+            for param in params:
+                self._emit_debug_var(param)
+            self._emit_func_body(member, self._func_defs[member].get("body", []))
+            self.indent_level -= 1
+        self.emit_line("}")
+        self._emit_tail_loop_end()
+        self._tail_group = None
+        self.indent_level -= 1
+        self.emit_line("}")
+        self.emit_line("")
+
+    def _emit_tail_loop_start(self) -> None:
+        self.indent_level -= 1
+        self.emit_line("__tail:")
+        self.indent_level += 1
+        self.emit_line("for {")
+        self.indent_level += 1
+        self.emit_line("coreilStep()")
+
+    def _emit_tail_loop_end(self) -> None:
         self.indent_level -= 1
         self.emit_line("}")
 
+    def _emit_tail_call(self, callee: str, args: list) -> None:
+        arg_strs = [self.emit_expr(arg) for arg in args]
+        group = self._tail_group
+        if len(group) == 1:
+            params = self._func_defs[callee].get("params", [])
+            if params:
+                self.emit_line(f"{', '.join(params)} = {', '.join(arg_strs)}")
+        else:
+            self.emit_line(f"__fn, __args = {group.index(callee)}, []Value{{{', '.join(arg_strs)}}}")
+        self.emit_line("continue __tail")
+
     def _emit_return(self, node: dict) -> None:
+        callee = self._tail_returns.get(id(node))
+        if callee is not None and self._tail_group is not None:
+            self._emit_tail_call(callee, node["value"].get("args", []))
+            return
         value = node.get("value")
         if value is None:
             self.emit_line("return ValueNone")
@@ -598,8 +1407,11 @@ class GoEmitter(BaseEmitter):
     def _emit_call_stmt(self, node: dict) -> None:
         name = node.get("name")
         args = node.get("args", [])
+        if name == "forAll":
+            self.emit_line(self._emit_for_all(args))
+            return
         arg_strs = [self.emit_expr(arg) for arg in args]
-        self.emit_line(f"{name}({', '.join(arg_strs)})")
+        self.emit_line(f"{self._callee(name)}({', '.join(arg_strs)})")
 
     def _emit_break(self, node: dict) -> None:
         self.emit_line("break")
@@ -613,20 +1425,27 @@ class GoEmitter(BaseEmitter):
         body = node.get("body", [])
 
         if isinstance(iter_expr, dict) and iter_expr.get("type") == "Range":
-            from_val = self.emit_expr(iter_expr.get("from"))
-            to_val = self.emit_expr(iter_expr.get("to"))
+            from_val = self._emit_int(iter_expr.get("from"))
+            to_val = self._emit_int(iter_expr.get("to"))
             inclusive = iter_expr.get("inclusive", False)
             cmp_op = "<=" if inclusive else "<"
             self.emit_line("{")
             self.indent_level += 1
-            self.emit_line(f"__from := asInt({from_val})")
-            self.emit_line(f"__to := asInt({to_val})")
+            self.emit_line(f"__from := {from_val}")
+            self.emit_line(f"__to := {to_val}")
             # Use 3-part for so __from++ runs even on continue
             self.emit_line(f"for __i := __from; __i {cmp_op} __to; __i++ {{")
             self.indent_level += 1
-            self.emit_line(f"{var} := ValueInt(__i)")
+            self.emit_line("coreilStep()")
+            native_type = self._var_types.get(var)
+            value = "__i" if native_type == INT else "ValueInt(__i)"
+            if native_type not in (None, INT):
+                # Another type only where the loop is unreachable
+                value = _UNBOX[native_type].format(value)
+            self.emit_line(f"{var} := {value}")
             # Suppress unused variable warning
             self.emit_line(f"_ = {var}")
+            self._emit_debug_var(var)
             for stmt in body:
                 self.emit_stmt(stmt)
             self.indent_level -= 1
@@ -634,33 +1453,23 @@ class GoEmitter(BaseEmitter):
             self.indent_level -= 1
             self.emit_line("}")
         else:
-            iter_code = self.emit_expr(iter_expr)
-            self.emit_line("{")
-            self.indent_level += 1
-            self.emit_line(f"__iter := asArray({iter_code})")
-            self.emit_line("for _, __item := range *__iter {")
-            self.indent_level += 1
-            self.emit_line(f"{var} := __item")
-            self.emit_line(f"_ = {var}")
-            for stmt in body:
-                self.emit_stmt(stmt)
-            self.indent_level -= 1
-            self.emit_line("}")
-            self.indent_level -= 1
-            self.emit_line("}")
+            self._emit_item_loop(var, self.emit_expr(iter_expr), body)
 
     def _emit_for_each(self, node: dict) -> None:
-        var = node.get("var")
-        iter_code = self.emit_expr(node.get("iter"))
-        body = node.get("body", [])
+        self._emit_item_loop(node.get("var"), self.emit_expr(node.get("iter")), node.get("body", []))
 
+    def _emit_item_loop(self, var: str, iter_code: str, body: list) -> None:
+        # forEachItems reads an array as it was when the loop started, and
+        # a generator (such as readLines) one item per iteration
         self.emit_line("{")
         self.indent_level += 1
-        self.emit_line(f"__iter := asArray({iter_code})")
-        self.emit_line("for _, __item := range *__iter {")
+        self.emit_line(f"__next := forEachItems({iter_code})")
+        self.emit_line("for __item, __ok := __next(); __ok; __item, __ok = __next() {")
         self.indent_level += 1
+        self.emit_line("coreilStep()")
         self.emit_line(f"{var} := __item")
         self.emit_line(f"_ = {var}")
+        self._emit_debug_var(var)
         for stmt in body:
             self.emit_stmt(stmt)
         self.indent_level -= 1
@@ -698,8 +1507,10 @@ class GoEmitter(BaseEmitter):
         self.indent_level += 1
         self.emit_line("if __r := recover(); __r != nil {")
         self.indent_level += 1
-        self.emit_line(f'{catch_var} := ValueStr(fmt.Sprintf("%v", __r))')
+        self.emit_line("rethrowLimit(__r)")
+        self.emit_line(f"{catch_var} := caughtError(__r)")
         self.emit_line(f"_ = {catch_var}")
+        self._emit_debug_var(catch_var)
         for stmt in catch_body:
             self.emit_stmt(stmt)
         self.indent_level -= 1
@@ -740,13 +1551,116 @@ class GoEmitter(BaseEmitter):
         self.emit_line("}")
 
 
-def emit_go(doc: dict) -> tuple[str, dict[int, list[int]]]:
+def _go_func(name: str) -> str:
+    """The Go name of the program function name."""
+    return f"fn_{name}"
+
+
+def _tail_dispatch_name(group: list[str]) -> str:
+    return "__tail_" + "_".join(group)
+
+
+def _is_constant(node: dict) -> bool:
+    """Whether Go would evaluate node's native form at compile time."""
+    return all(n["type"] in ("Literal", "Binary") for n in iter_nodes(node))
+
+
+def emit_go(
+    doc: dict,
+    *,
+    test_mode: bool = False,
+    deterministic: bool = False,
+    strict: bool = False,
+    checked: bool = False,
+    division: str | None = None,
+    grants: list[str] | None = None,
+    isolated: bool = False,
+    tail_calls: bool = True,
+    source_text: str | None = None,
+    debug: bool = False,
+    watch: list[str] | None = None,
+    coverage: bool = False,
+    profile: bool = False,
+    memoize: list[str] | dict[str, int] | None = None,
+    typed: bool = True,
+    shared: bool = False,
+) -> tuple[str, dict[int, list[int]]]:
     """Generate Go code from Core IL document.
 
     Returns a tuple of (Go source code, coreil_line_map).
     The coreil_line_map maps Core IL body statement indices to output line numbers.
+
+    With test_mode=True the generated main runs each zero-argument function
+    named test_* through the runtime test runner instead of the program body.
+    With deterministic=True the program seeds its PRNG, freezes the clock and
+    refuses host-dependent builtins (see DefaultEngine.SetDeterministic).
+    With strict=True a float or bool used where an int is needed raises a
+    TypeError instead of being truncated or counted (see
+    DefaultEngine.SetStrict). With checked=True int addition, subtraction
+    and multiplication raise OverflowError instead of wrapping around (see
+    DefaultEngine.SetCheckedArithmetic). division ("floor" or "truncate")
+    overrides the document's division field, which chooses how % and
+    intDiv round (see DefaultEngine.SetDivision). The program may only
+    perform the side effects named in grants (capabilities such as
+    "io.read" or "net"); file, network, subprocess, environment and
+    notification builtins needing any other capability raise a
+    PermissionError (see DefaultEngine.SetSandbox).
+    With isolated=True the program body runs in a child process that forwards
+    ExternalCalls to the parent (see DefaultEngine.RunIsolated); it has no
+    effect in test mode. With tail_calls=False, self- and mutually recursive
+    tail calls are emitted as plain calls instead of loops, keeping a frame
+    per call for debugging. With source_text (the English program the
+    document's source_map refers to), runtime errors name the sentence
+    being executed. With debug=True every statement is a debugger stop
+    point and variables can be inspected; the program waits for a Debug
+    Adapter Protocol client when COREIL_DAP names a listen address, and
+    writes a heap snapshot (see DefaultEngine.Snapshot) to the file named by
+    COREIL_SNAPSHOT if it ends with an uncaught error. Each variable named
+    in watch gets a watchpoint tracing its assignments and container
+    mutations to stderr (see DefaultEngine.WatchVariable). With
+    coverage=True the program counts how often each statement runs and
+    writes the counts to the file named by COREIL_COVERAGE on exit (see
+    english_compiler.coreil.coverage). With profile=True the program
+    writes CPU and allocation profiles in pprof format to the directory
+    named by COREIL_PROFILE (see english_compiler.coreil.profile); it has
+    no effect in test mode. Every program records its ExternalCalls to the
+    file named by COREIL_RECORD, or replays them from the one named by
+    COREIL_REPLAY (see DefaultEngine.Record and Replay), resumes from and
+    checkpoints to the file named by COREIL_CHECKPOINT (see
+    DefaultEngine.Checkpoint and Restore), and reports
+    spans to a tracer its host configured (see DefaultEngine.SetTracing),
+    identifying itself by program_hash(doc). Each function named in memoize
+    (a list of names, or a mapping from name to cache size) caches its
+    results by argument value like functools.lru_cache, so recursive
+    definitions such as Fibonacci run in linear time (see memoStats).
+    Variables that always hold an int, float, string or bool (see
+    static_types.infer_types) are native Go variables instead of Values,
+    so arithmetic on them does not box; with typed=False every variable is
+    a Value. With shared=True the program is meant for a c-shared library
+    built with coreil_cshared.go (see build_go_shared): C hosts run its
+    top-level statements with engine_create and call its top-level
+    functions with engine_eval. Test mode takes precedence over it, and
+    isolated and profile have no effect.
     """
-    emitter = GoEmitter(doc)
+    emitter = GoEmitter(
+        doc,
+        test_mode=test_mode,
+        deterministic=deterministic,
+        strict=strict,
+        checked=checked,
+        division=division,
+        grants=grants,
+        isolated=isolated,
+        tail_calls=tail_calls,
+        source_text=source_text,
+        debug=debug,
+        watch=watch,
+        coverage=coverage,
+        profile=profile,
+        memoize=memoize,
+        typed=typed,
+        shared=shared,
+    )
     code = emitter.emit()
     return code, emitter.coreil_line_map
 
@@ -754,3 +1668,22 @@ def emit_go(doc: dict) -> tuple[str, dict[int, list[int]]]:
 def get_runtime_path() -> Path:
     """Return the path to the coreil_runtime.go runtime file."""
     return Path(__file__).parent / "go_runtime" / "coreil_runtime.go"
+
+
+def get_cshared_path() -> Path:
+    """Return the path to coreil_cshared.go, the C ABI of a shared library."""
+    return Path(__file__).parent / "go_runtime" / "coreil_cshared.go"
+
+
+def get_embed_path() -> Path:
+    """Return the path to coreil_embed.go, which embeds a program's Core IL."""
+    return Path(__file__).parent / "go_runtime" / "coreil_embed.go"
+
+
+def get_frontend_path() -> Path:
+    """Return the path to coreil_frontend.go, the Go LLM frontends.
+
+    It embeds prompt.txt, which must be copied next to it from
+    english_compiler.frontend.base.get_prompt_path().
+    """
+    return Path(__file__).parent / "go_runtime" / "coreil_frontend.go"
@@ -32,6 +32,7 @@ Backward compatibility: Accepts v0.1 through v1.8 programs.
 
 from __future__ import annotations
 
+from english_compiler.coreil.constants import EXTENDED_MATH_CONSTANTS, EXTENDED_MATH_OPS
 from english_compiler.coreil.emit_base import BaseEmitter
 
 # Map Core IL external module names to Node.js imports
@@ -588,6 +589,8 @@ class JavaScriptEmitter(BaseEmitter):
         return f"{base}.size"
 
     def _emit_deque_new(self, node: dict) -> str:
+        if node.get("maxlen") is not None:
+            raise ValueError("DequeNew maxlen is not supported by the JavaScript backend")
         return "[]"
 
     def _emit_deque_size(self, node: dict) -> str:
@@ -609,6 +612,8 @@ class JavaScriptEmitter(BaseEmitter):
     def _emit_math(self, node: dict) -> str:
         self.uses_float = True
         op = node.get("op")
+        if op in EXTENDED_MATH_OPS:
+            raise ValueError(f"Math op '{op}' is not supported by the JavaScript backend")
         arg = self.emit_expr(node.get("arg"))
         if op in ("floor", "ceil", "abs"):
             return f"Math.{op}({arg})"
@@ -622,6 +627,8 @@ class JavaScriptEmitter(BaseEmitter):
 
     def _emit_math_const(self, node: dict) -> str:
         name = node.get("name")
+        if name in EXTENDED_MATH_CONSTANTS:
+            raise ValueError(f"MathConst '{name}' is not supported by the JavaScript backend")
         if name == "pi":
             return "Math.PI"
         elif name == "e":
@@ -31,6 +31,7 @@ from __future__ import annotations
 
 from pathlib import Path
 
+from english_compiler.coreil.constants import EXTENDED_MATH_CONSTANTS, EXTENDED_MATH_OPS
 from english_compiler.coreil.emit_base import BaseEmitter
 
 
@@ -367,6 +368,8 @@ class RustEmitter(BaseEmitter):
         return f"set_size(&{base})"
 
     def _emit_deque_new(self, node: dict) -> str:
+        if node.get("maxlen") is not None:
+            raise ValueError("DequeNew maxlen is not supported by the Rust backend")
         return "deque_new()"
 
     def _emit_deque_size(self, node: dict) -> str:
@@ -386,6 +389,8 @@ class RustEmitter(BaseEmitter):
 
     def _emit_math(self, node: dict) -> str:
         op = node.get("op")
+        if op in EXTENDED_MATH_OPS:
+            raise ValueError(f"Math op '{op}' is not supported by the Rust backend")
         arg = self.emit_expr(node.get("arg"))
         math_funcs = {
             "sin": "math_sin",
@@ -409,6 +414,8 @@ class RustEmitter(BaseEmitter):
 
     def _emit_math_const(self, node: dict) -> str:
         name = node.get("name")
+        if name in EXTENDED_MATH_CONSTANTS:
+            raise ValueError(f"MathConst '{name}' is not supported by the Rust backend")
         if name == "pi":
             return "math_pi()"
         elif name == "e":
@@ -0,0 +1,200 @@
+"""Property-based testing: the forAll builtin.
+
+forAll(generator, property, trials) calls the function named property
+with generated arguments, trials times (100 by default), and returns true
+if it always returned a truthy value:
+
+    {"type": "Call", "name": "forAll", "args": [
+        {"type": "Literal", "value": "array<int>"},
+        {"type": "Literal", "value": "sort_keeps_length"},
+        {"type": "Literal", "value": 200}]}
+
+A generator is one of "int", "bool", "string" or "array<G>" for a
+generator G, or an array of them for a property of several arguments.
+Generated values grow with the trial number. When the property returns a
+falsy value or raises an error, the arguments are shrunk (numbers toward
+0, strings and arrays toward shorter ones with smaller elements) to the
+smallest ones that still fail, and forAll throws an error naming them, so
+a failing property fails its test.
+
+Generation is deterministic: every forAll draws from a splitmix64 stream
+with the same seed, and the Go runtime's forAll (coreil_runtime.go)
+implements the same generators and shrinking, so both find the same
+counterexample.
+"""
+
+from __future__ import annotations
+
+import copy
+from collections.abc import Callable, Iterator
+from typing import Any
+
+DEFAULT_TRIALS = 100
+
+# Values grow with the trial number up to MAX_SIZE; strings and arrays are
+# at most MAX_LENGTH long
+MAX_SIZE = 100
+MAX_LENGTH = 20
+
+# Property calls spent looking for a smaller counterexample
+MAX_SHRINK_CALLS = 1000
+
+ALPHABET = "abcdefghijklmnopqrstuvwxyz0123456789 "
+
+_MASK = (1 << 64) - 1
+
+
+class PropertyFailed(ValueError):
+    """A property did not hold; the message names the counterexample."""
+
+
+class _SplitMix64:
+    """The splitmix64 generator, as in the Go runtime."""
+
+    def __init__(self, seed: int = 0) -> None:
+        self.state = seed & _MASK
+
+    def next(self) -> int:
+        self.state = (self.state + 0x9E3779B97F4A7C15) & _MASK
+        z = self.state
+        z = ((z ^ (z >> 30)) * 0xBF58476D1CE4E5B9) & _MASK
+        z = ((z ^ (z >> 27)) * 0x94D049BB133111EB) & _MASK
+        return z ^ (z >> 31)
+
+    def below(self, n: int) -> int:
+        return self.next() % n
+
+
+def parse_generators(spec: Any) -> list[str]:
+    """The generator of each property argument.
+
+    Raises:
+        ValueError: If spec is not a generator or an array of them.
+    """
+    specs = spec if isinstance(spec, list) else [spec]
+    generators = []
+    for item in specs:
+        generator = item.replace(" ", "") if isinstance(item, str) else None
+        if generator is None or not _valid(generator):
+            raise ValueError(f"forAll: unknown generator {item!r}")
+        generators.append(generator)
+    return generators
+
+
+def _valid(generator: str) -> bool:
+    if generator.startswith("array<") and generator.endswith(">"):
+        return _valid(generator[6:-1])
+    return generator in ("int", "bool", "string")
+
+
+def generate(generator: str, rng: _SplitMix64, size: int) -> Any:
+    """A value of generator at size (>= 1)."""
+    if generator == "int":
+        return rng.below(2 * size + 1) - size
+    if generator == "bool":
+        return rng.below(2) == 1
+    length = rng.below(min(size, MAX_LENGTH) + 1)
+    if generator == "string":
+        return "".join(ALPHABET[rng.below(len(ALPHABET))] for _ in range(length))
+    return [generate(generator[6:-1], rng, size) for _ in range(length)]
+
+
+def shrink(generator: str, value: Any) -> Iterator[Any]:
+    """Smaller values of generator than value, most promising first."""
+    if generator == "int":
+        if value == 0:
+            return
+        sign = 1 if value > 0 else -1
+        half = abs(value) // 2 * sign
+        yield 0
+        if half != 0:
+            yield half
+        if value - sign not in (0, half):
+            yield value - sign
+    elif generator == "bool":
+        if value:
+            yield False
+    elif generator == "string":
+        if value == "":
+            return
+        yield ""
+        if len(value) > 1:
+            yield value[: len(value) // 2]
+            yield value[1:]
+            yield value[:-1]
+        for i, char in enumerate(value):
+            if char != "a":
+                yield value[:i] + "a" + value[i + 1:]
+    else:
+        if not value:
+            return
+        yield []
+        if len(value) > 1:
+            yield value[: len(value) // 2]
+            for i in range(len(value)):
+                yield value[:i] + value[i + 1:]
+        element = generator[6:-1]
+        for i, item in enumerate(value):
+            for smaller in shrink(element, item):
+                yield value[:i] + [smaller] + value[i + 1:]
+
+
+def _shrink_args(generators: list[str], args: list[Any]) -> Iterator[list[Any]]:
+    for i, generator in enumerate(generators):
+        for smaller in shrink(generator, args[i]):
+            yield args[:i] + [smaller] + args[i + 1:]
+
+
+def _show(args: list[Any]) -> str:
+    if len(args) == 1:
+        return repr(args[0])
+    return "(" + ", ".join(repr(arg) for arg in args) + ")"
+
+
+def for_all(
+    spec: Any,
+    name: str,
+    trials: Any,
+    check: Callable[[list[Any]], str | None],
+) -> bool:
+    """Test the property name on trials generated arguments.
+
+    check calls the property with a list of arguments and returns None if
+    it holds, or why not: "" for a falsy result, or the error it raised. It
+    is given copies, so a property that mutates its arguments cannot change
+    the counterexample.
+
+    Raises:
+        ValueError: If spec or trials is invalid, or if the property fails;
+            the message names the shrunk counterexample.
+    """
+    generators = parse_generators(spec)
+    if isinstance(trials, bool) or not isinstance(trials, int) or trials < 0:
+        raise ValueError(f"forAll: trials must be a non-negative integer, got {trials!r}")
+    rng = _SplitMix64()
+    for trial in range(trials):
+        size = min(trial + 1, MAX_SIZE)
+        args = [generate(generator, rng, size) for generator in generators]
+        reason = check(copy.deepcopy(args))
+        if reason is None:
+            continue
+        original, shrinks, calls = args, 0, 0
+        improved = True
+        while improved and calls < MAX_SHRINK_CALLS:
+            improved = False
+            for candidate in _shrink_args(generators, args):
+                if calls >= MAX_SHRINK_CALLS:
+                    break
+                calls += 1
+                candidate_reason = check(copy.deepcopy(candidate))
+                if candidate_reason is not None:
+                    args, reason, improved = candidate, candidate_reason, True
+                    shrinks += 1
+                    break
+        message = f"forAll: property '{name}' failed after {trial + 1} trial(s) for {_show(args)}"
+        if shrinks:
+            message += f", shrunk from {_show(original)}"
+        if reason:
+            message += f": {reason}"
+        raise PropertyFailed(message)
+    return True
@@ -0,0 +1,234 @@
+"""Content-addressed cache of compiled Go programs.
+
+Building a Go binary takes seconds even when nothing changed; the cache
+lets repeated runs of the same program (watch mode, a server handling the
+same request, `english-compiler test` in a loop) skip code generation and
+go build entirely.
+
+Binaries are keyed by a hash of the canonical Core IL (see canonical.py)
+and the emit options, or by a hash of the Go source for callers that
+already have it.
+Every key also covers a runtime fingerprint: the package version, the
+contents of coreil_runtime.go, emit_go.py and every package module emit_go.py
+imports (directly or not), and the Go toolchain version.
+A runtime or compiler change therefore misses instead of reusing a stale
+binary, and the first build after one deletes the entries it obsoleted.
+
+Layout: <cache dir>/<fingerprint>/<key[:2]>/<key>. The cache dir is
+$COREIL_CACHE_DIR/go if set, otherwise go in the user cache directory;
+entries are written atomically, so concurrent builds of the same program
+are safe.
+
+Usage:
+    from english_compiler.coreil.go_cache import GoBuildCache
+
+    result = GoBuildCache().build(doc, deterministic=True)
+    if result.success:
+        subprocess.run([str(result.binary_path)])
+"""
+
+from __future__ import annotations
+
+import ast
+import functools
+import hashlib
+import json
+import os
+import shutil
+import subprocess
+import sys
+import tempfile
+from dataclasses import dataclass
+from pathlib import Path
+from typing import Any
+
+from .canonical import canonicalize
+from .emit_go import emit_go, get_runtime_path, program_hash
+from .versions import PACKAGE_VERSION
+
+GO_AVAILABLE = shutil.which("go") is not None
+
+
+def default_cache_dir(name: str = "go") -> Path:
+    """The directory of the named cache under $COREIL_CACHE_DIR, or the user cache dir."""
+    override = os.environ.get("COREIL_CACHE_DIR")
+    if override:
+        return Path(override) / name
+    try:
+        import platformdirs
+
+        return Path(platformdirs.user_cache_dir("english-compiler")) / name
+    except ImportError:
+        if sys.platform == "win32":
+            return Path.home() / "english-compiler" / "cache" / name
+        return Path.home() / ".cache" / "english-compiler" / name
+
+
+@functools.lru_cache(maxsize=None)
+def _go_version() -> str:
+    try:
+        result = subprocess.run(
+            ["go", "env", "GOVERSION"], capture_output=True, text=True, timeout=30
+        )
+    except (OSError, subprocess.TimeoutExpired):
+        return ""
+    return result.stdout.strip()
+
+
+_PACKAGE_ROOT = Path(__file__).resolve().parent.parent
+
+
+def _module_path(name: str) -> Path | None:
+    """The source file of a module in this package, or None if there is none."""
+    parts = name.split(".")
+    if parts[0] != _PACKAGE_ROOT.name:
+        return None
+    base = _PACKAGE_ROOT.joinpath(*parts[1:])
+    for path in (base.with_suffix(".py"), base / "__init__.py"):
+        if path.is_file():
+            return path
+    return None
+
+
+def _imported_modules(path: Path, module: str) -> list[str]:
+    """The package modules that the source at path imports."""
+    package = module.rsplit(".", 1)[0]
+    names = []
+    for node in ast.walk(ast.parse(path.read_text(encoding="utf-8"))):
+        if isinstance(node, ast.Import):
+            names.extend(alias.name for alias in node.names)
+        elif isinstance(node, ast.ImportFrom):
+            base = node.module or ""
+            if node.level:
+                anchor = package.split(".")
+                anchor = anchor[: len(anchor) - node.level + 1]
+                base = ".".join(anchor + ([base] if base else []))
+            names.append(base)
+            names.extend(f"{base}.{alias.name}" for alias in node.names)
+    return names
+
+
+@functools.lru_cache(maxsize=None)
+def emitter_sources() -> tuple[Path, ...]:
+    """emit_go.py and every package module it imports, directly or not."""
+    start = f"{__package__}.emit_go"
+    seen: dict[str, Path] = {}
+    pending = [start]
+    while pending:
+        name = pending.pop()
+        path = _module_path(name)
+        if path is None or name in seen:
+            continue
+        seen[name] = path
+        if path.stem == "__init__":
+            name = f"{name}.__init__"
+        pending.extend(_imported_modules(path, name))
+    return tuple(sorted(set(seen.values())))
+
+
+def runtime_fingerprint() -> str:
+    """Hash of everything besides the program that a binary depends on."""
+    digest = hashlib.sha256()
+    digest.update(PACKAGE_VERSION.encode("utf-8"))
+    for path in (get_runtime_path(), *emitter_sources()):
+        digest.update(b"\0")
+        digest.update(path.read_bytes())
+    digest.update(b"\0")
+    digest.update(_go_version().encode("utf-8"))
+    return digest.hexdigest()[:16]
+
+
+@dataclass
+class CacheResult:
+    """Result from a cached build."""
+    success: bool
+    binary_path: Path | None = None
+    hit: bool = False
+    error: str | None = None
+
+
+class GoBuildCache:
+    """Compiled Go binaries, addressed by program and runtime."""
+
+    def __init__(self, root: Path | None = None):
+        self.root = Path(root) if root is not None else default_cache_dir()
+
+    def key(self, doc: dict, **emit_options: Any) -> str:
+        """The key of doc compiled with emit_options.
+
+        Documents with the same canonical form share a key.
+        """
+        options = json.dumps(emit_options, sort_keys=True, default=str)
+        return _sha256(f"coreil\0{program_hash(canonicalize(doc))}\0{options}")
+
+    def source_key(self, code: str) -> str:
+        """The key of generated Go source."""
+        return _sha256(f"go\0{code}")
+
+    def path(self, key: str) -> Path:
+        """Where the binary for key is (or would be) stored."""
+        return self.root / runtime_fingerprint() / key[:2] / key
+
+    def build(self, doc: dict, **emit_options: Any) -> CacheResult:
+        """Return the binary for doc, compiling it with emit_go only on a miss."""
+        binary = self.path(self.key(doc, **emit_options))
+        if binary.exists():
+            return CacheResult(success=True, binary_path=binary, hit=True)
+        try:
+            code, _ = emit_go(doc, **emit_options)
+        except Exception as exc:
+            return CacheResult(success=False, error=f"Go codegen failed: {exc}")
+        return self._compile(code, binary)
+
+    def build_source(self, code: str) -> CacheResult:
+        """Return the binary for a generated main.go, compiling it only on a miss."""
+        binary = self.path(self.source_key(code))
+        if binary.exists():
+            return CacheResult(success=True, binary_path=binary, hit=True)
+        return self._compile(code, binary)
+
+    def clear(self) -> int:
+        """Delete every cached binary, returning how many there were."""
+        if not self.root.is_dir():
+            return 0
+        count = sum(1 for entry in self.root.glob("*/*/*") if entry.is_file())
+        shutil.rmtree(self.root, ignore_errors=True)
+        return count
+
+    def _compile(self, code: str, binary: Path) -> CacheResult:
+        if not GO_AVAILABLE:
+            return CacheResult(success=False, error="go not found")
+        binary.parent.mkdir(parents=True, exist_ok=True)
+        with tempfile.TemporaryDirectory() as tmp_dir:
+            tmp_path = Path(tmp_dir)
+            (tmp_path / "main.go").write_text(code, encoding="utf-8")
+            shutil.copy(get_runtime_path(), tmp_path / "coreil_runtime.go")
+            built = tmp_path / "program"
+            try:
+                result = subprocess.run(
+                    ["go", "build", "-o", str(built), "main.go", "coreil_runtime.go"],
+                    capture_output=True,
+                    text=True,
+                    timeout=300,
+                    cwd=tmp_dir,
+                )
+            except subprocess.TimeoutExpired:
+                return CacheResult(success=False, error="Compilation timeout (>300s)")
+            if result.returncode != 0:
+                return CacheResult(success=False, error=f"go build failed:\n{result.stderr}")
+            # Builds of the same key race harmlessly: the last rename wins
+            staged = binary.with_name(f".{binary.name}.{os.getpid()}")
+            shutil.move(str(built), staged)
+            os.replace(staged, binary)
+        self._prune(binary.parent.parent.name)
+        return CacheResult(success=True, binary_path=binary)
+
+    def _prune(self, fingerprint: str) -> None:
+        """Delete entries built against any other runtime."""
+        for entry in self.root.iterdir():
+            if entry.is_dir() and entry.name != fingerprint:
+                shutil.rmtree(entry, ignore_errors=True)
+
+
+def _sha256(text: str) -> str:
+    return hashlib.sha256(text.encode("utf-8")).hexdigest()
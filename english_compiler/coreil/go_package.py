@@ -0,0 +1,95 @@
+"""Single-file executables of Go programs.
+
+build_go_executable() compiles a Core IL program with the Go runtime into
+one statically linked executable (CGO_ENABLED=0) that runs on machines
+without the Go toolchain. The Core IL is embedded in it with go:embed by
+go_runtime/coreil_embed.go; running the executable with COREIL_PRINT_IL=1
+prints it instead of running the program.
+
+goos and goarch cross-compile, e.g. for Windows from Linux.
+
+Usage:
+    from english_compiler.coreil.go_package import build_go_executable
+
+    result = build_go_executable(doc, Path("scores"), source_text=english)
+    if result.success:
+        print(f"Built {result.executable_path}")
+"""
+
+from __future__ import annotations
+
+import json
+import os
+import shutil
+import subprocess
+import tempfile
+from dataclasses import dataclass
+from pathlib import Path
+from typing import Any
+
+from .emit_go import emit_go, get_embed_path, get_runtime_path
+
+GO_AVAILABLE = shutil.which("go") is not None
+
+
+@dataclass
+class ExecutableBuildResult:
+    """Result from an executable build."""
+    success: bool
+    executable_path: Path | None = None
+    error: str | None = None
+
+
+def build_go_executable(
+    doc: dict,
+    output_path: Path,
+    *,
+    goos: str | None = None,
+    goarch: str | None = None,
+    **emit_options: Any,
+) -> ExecutableBuildResult:
+    """Compile doc into a self-contained executable at output_path.
+
+    emit_options are passed to emit_go (e.g. source_text=... so runtime
+    errors name the English sentence).
+    """
+    if not GO_AVAILABLE:
+        return ExecutableBuildResult(success=False, error="go not found")
+
+    try:
+        code, _ = emit_go(doc, **emit_options)
+    except Exception as exc:
+        return ExecutableBuildResult(success=False, error=f"Go codegen failed: {exc}")
+
+    output_path = Path(output_path).resolve()
+    output_path.parent.mkdir(parents=True, exist_ok=True)
+    env = {**os.environ, "CGO_ENABLED": "0"}
+    if goos:
+        env["GOOS"] = goos
+    if goarch:
+        env["GOARCH"] = goarch
+
+    with tempfile.TemporaryDirectory() as tmp_dir:
+        tmp_path = Path(tmp_dir)
+        (tmp_path / "main.go").write_text(code, encoding="utf-8")
+        (tmp_path / "program.coreil.json").write_text(
+            json.dumps(doc, indent=2, ensure_ascii=False) + "\n", encoding="utf-8"
+        )
+        shutil.copy(get_runtime_path(), tmp_path / "coreil_runtime.go")
+        shutil.copy(get_embed_path(), tmp_path / "coreil_embed.go")
+        try:
+            result = subprocess.run(
+                ["go", "build", "-trimpath", "-ldflags=-s -w", "-o", str(output_path),
+                 "main.go", "coreil_runtime.go", "coreil_embed.go"],
+                capture_output=True,
+                text=True,
+                timeout=300,
+                cwd=tmp_dir,
+                env=env,
+            )
+        except subprocess.TimeoutExpired:
+            return ExecutableBuildResult(success=False, error="Compilation timeout (>300s)")
+
+    if result.returncode != 0:
+        return ExecutableBuildResult(success=False, error=f"go build failed:\n{result.stderr}")
+    return ExecutableBuildResult(success=True, executable_path=output_path)
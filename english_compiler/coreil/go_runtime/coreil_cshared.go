@@ -0,0 +1,371 @@
+package main
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"bytes"
+	"fmt"
+	"runtime/cgo"
+	"sync"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// ============================================================================
+// C ABI
+// ============================================================================
+
+// Built with -buildmode=c-shared next to coreil_runtime.go and a program
+// emitted with emit_go(doc, shared=True), this file exports the program to
+// C hosts (Python via ctypes, Rust, Node via ffi) without a child process:
+//
+//	uintptr_t e = engine_create();            // runs the top-level statements
+//	uintptr_t v = engine_eval(e, "add", "[1, 2]");
+//	if (v == 0) { char *msg = engine_error(e); ...; coreil_free(msg); }
+//	int64_t n = value_int(v);
+//	value_free(v);
+//	engine_destroy(e);
+//
+// Engines and values are opaque non-zero handles; 0 means failure. Passing 0
+// or a freed handle is not a crash: accessors return 0, NULL or -1 as
+// documented on each, and engine_eval fails. Strings
+// returned to the host are allocated with malloc and released with
+// coreil_free. value_type returns the ValueType constants, which only ever
+// gain new members at the end. coreil_abi_version changes when an exported
+// signature does.
+//
+// Calls are serialized: DefaultEngine is the calling engine for the
+// duration of each one. Each engine has its own output, limits and error,
+// but the program's functions are shared, and a task a call spawns should
+// finish before the call returns. engine_destroy waits for a call in
+// progress; a call that starts after it fails as for a freed handle.
+
+const coreilABIVersion = 1
+
+// coreilExport is a top-level function engine_eval can call; shared-mode
+// codegen lists them in coreilExports.
+type coreilExport struct {
+	params int
+	fn     func(args []Value) Value
+}
+
+// sharedEngine is what an engine handle refers to.
+type sharedEngine struct {
+	engine *Engine
+	output bytes.Buffer
+	err    string
+}
+
+var sharedMu sync.Mutex
+
+// run runs fn on the engine, recording the error that stops it, if any.
+func (s *sharedEngine) run(fn func()) (ok bool) {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	saved := DefaultEngine
+	DefaultEngine = s.engine
+	defer func() { DefaultEngine = saved }()
+	s.err = ""
+	// The host, not a statement, is making this call
+	s.engine.loc = SourceLocation{}
+	defer func() {
+		if r := recover(); r != nil {
+			s.err = s.engine.errorReport(r)
+			ok = false
+		}
+	}()
+	if err := s.engine.Run(fn); err != nil {
+		s.err = err.Error()
+		return false
+	}
+	return true
+}
+
+// handleValue returns what h refers to, or nil for 0 or a freed handle,
+// which cgo reports by panicking.
+func handleValue(h C.uintptr_t) (x interface{}) {
+	if h == 0 {
+		return nil
+	}
+	defer func() {
+		if recover() != nil {
+			x = nil
+		}
+	}()
+	return cgo.Handle(h).Value()
+}
+
+func sharedEngineOf(h C.uintptr_t) (*sharedEngine, bool) {
+	s, ok := handleValue(h).(*sharedEngine)
+	return s, ok
+}
+
+func valueOf(h C.uintptr_t) (Value, bool) {
+	v, ok := handleValue(h).(Value)
+	return v, ok
+}
+
+// deleteHandle frees h unless it is 0 or already freed.
+func deleteHandle(h C.uintptr_t) {
+	if handleValue(h) != nil {
+		cgo.Handle(h).Delete()
+	}
+}
+
+func valueHandle(v Value) C.uintptr_t {
+	return C.uintptr_t(cgo.NewHandle(v))
+}
+
+//export coreil_abi_version
+func coreil_abi_version() C.int {
+	return coreilABIVersion
+}
+
+//export coreil_free
+func coreil_free(p unsafe.Pointer) {
+	C.free(p)
+}
+
+// engine_create runs the program's top-level statements on a new engine.
+// If they raise, the engine is still returned and engine_error reports it.
+//
+//export engine_create
+func engine_create() C.uintptr_t {
+	s := &sharedEngine{engine: NewEngine()}
+	s.engine.SetOutput(&s.output)
+	s.run(coreilProgram)
+	return C.uintptr_t(cgo.NewHandle(s))
+}
+
+// engine_destroy frees an engine once any call in progress has returned.
+//
+//export engine_destroy
+func engine_destroy(engine C.uintptr_t) {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if _, ok := sharedEngineOf(engine); ok {
+		deleteHandle(engine)
+	}
+}
+
+// engine_eval calls the top-level function name with the arguments in
+// args, a JSON array (NULL for none), and returns its result, or 0 if the
+// call fails.
+//
+//export engine_eval
+func engine_eval(engine C.uintptr_t, name *C.char, args *C.char) C.uintptr_t {
+	s, ok := sharedEngineOf(engine)
+	if !ok {
+		return 0
+	}
+	fnName := C.GoString(name)
+	export, found := coreilExports[fnName]
+	if !found {
+		sharedMu.Lock()
+		s.err = fmt.Sprintf("runtime error: unknown function '%s'", fnName)
+		sharedMu.Unlock()
+		return 0
+	}
+	argsJSON := "[]"
+	if args != nil {
+		argsJSON = C.GoString(args)
+	}
+	var result Value
+	ok = s.run(func() {
+		parsed := jsonParse(ValueStr(argsJSON))
+		if parsed.Type != TypeArray {
+			panic(runtimeError(KindTypeError, "arguments must be a JSON array, got %s", typeName(parsed)))
+		}
+		argv := *asArray(parsed)
+		if len(argv) != export.params {
+			panic(runtimeError(KindTypeError, "function '%s' takes %d argument(s), got %d", fnName, export.params, len(argv)))
+		}
+		result = export.fn(argv)
+	})
+	if !ok {
+		return 0
+	}
+	return valueHandle(result)
+}
+
+// engine_error returns the error that stopped the engine's last call, or
+// NULL if it succeeded or the handle is invalid.
+//
+//export engine_error
+func engine_error(engine C.uintptr_t) *C.char {
+	s, ok := sharedEngineOf(engine)
+	if !ok {
+		return nil
+	}
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if s.err == "" {
+		return nil
+	}
+	return C.CString(s.err)
+}
+
+// engine_output returns the output the program has printed since the last
+// call to engine_output, or NULL if the handle is invalid.
+//
+//export engine_output
+func engine_output(engine C.uintptr_t) *C.char {
+	s, ok := sharedEngineOf(engine)
+	if !ok {
+		return nil
+	}
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	out := C.CString(s.output.String())
+	s.output.Reset()
+	return out
+}
+
+//export value_free
+func value_free(value C.uintptr_t) {
+	if _, ok := valueOf(value); ok {
+		deleteHandle(value)
+	}
+}
+
+// value_type returns the value's ValueType, or -1 if the handle is invalid.
+//
+//export value_type
+func value_type(value C.uintptr_t) C.int {
+	v, ok := valueOf(value)
+	if !ok {
+		return -1
+	}
+	return C.int(v.Type)
+}
+
+// value_int returns an int or bool value as an integer, and 0 otherwise.
+//
+//export value_int
+func value_int(value C.uintptr_t) C.int64_t {
+	v, _ := valueOf(value)
+	switch v.Type {
+	case TypeInt:
+		return C.int64_t(v.intData())
+	case TypeBool:
+		if v.boolData() {
+			return 1
+		}
+	}
+	return 0
+}
+
+// value_float returns a float or int value as a double, and 0 otherwise.
+//
+//export value_float
+func value_float(value C.uintptr_t) C.double {
+	v, _ := valueOf(value)
+	switch v.Type {
+	case TypeFloat:
+		return C.double(v.floatData())
+	case TypeInt:
+		return C.double(v.intData())
+	}
+	return 0
+}
+
+// value_bool returns the value's truthiness as 0 or 1.
+//
+//export value_bool
+func value_bool(value C.uintptr_t) C.int {
+	if v, ok := valueOf(value); ok && isTruthy(v) {
+		return 1
+	}
+	return 0
+}
+
+// value_string returns a string's text, or for any other value the text
+// Print shows for it, or NULL if the handle is invalid.
+//
+//export value_string
+func value_string(value C.uintptr_t) *C.char {
+	v, ok := valueOf(value)
+	if !ok {
+		return nil
+	}
+	if v.Type == TypeStr {
+		return C.CString(v.data.(string))
+	}
+	return C.CString(formatValue(v))
+}
+
+// value_json returns the value as JSON text, or NULL if it has no JSON
+// form (e.g. a NaN) or the handle is invalid.
+//
+//export value_json
+func value_json(value C.uintptr_t) (out *C.char) {
+	defer func() {
+		if recover() != nil {
+			out = nil
+		}
+	}()
+	v, ok := valueOf(value)
+	if !ok {
+		return nil
+	}
+	return C.CString(asString(jsonStringify(v, ValueBool(false))))
+}
+
+// value_len returns the number of characters of a string or items of an
+// array, tuple, map or set, and -1 for other values.
+//
+//export value_len
+func value_len(value C.uintptr_t) C.int64_t {
+	v, _ := valueOf(value)
+	switch v.Type {
+	case TypeStr:
+		return C.int64_t(utf8.RuneCountInString(v.data.(string)))
+	case TypeArray:
+		return C.int64_t(len(v.data.(*Array).items))
+	case TypeTuple:
+		return C.int64_t(len(v.data.([]Value)))
+	case TypeMap:
+		return C.int64_t(len(v.data.(*OrderedMap).keys))
+	case TypeSet:
+		return C.int64_t(len(v.data.(*ValueSet).items))
+	}
+	return -1
+}
+
+// value_index returns item i of an array or tuple, or 0 if there is none.
+//
+//export value_index
+func value_index(value C.uintptr_t, i C.int64_t) C.uintptr_t {
+	v, _ := valueOf(value)
+	var items []Value
+	switch v.Type {
+	case TypeArray:
+		items = v.data.(*Array).items
+	case TypeTuple:
+		items = v.data.([]Value)
+	}
+	if i < 0 || int64(i) >= int64(len(items)) {
+		return 0
+	}
+	return valueHandle(items[i])
+}
+
+// value_get returns the entry of a map under a string key, or 0 if there
+// is none.
+//
+//export value_get
+func value_get(value C.uintptr_t, key *C.char) C.uintptr_t {
+	v, _ := valueOf(value)
+	if v.Type != TypeMap {
+		return 0
+	}
+	item, found := v.data.(*OrderedMap).GetValue(ValueStr(C.GoString(key)))
+	if !found {
+		return 0
+	}
+	return valueHandle(item)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	_ "embed"
+	"os"
+)
+
+// ============================================================================
+// Embedded Core IL
+// ============================================================================
+
+// Built next to coreil_runtime.go by build_go_executable, this file carries
+// the Core IL the executable was compiled from, so a shipped binary can
+// always be traced back to its program:
+//
+//	COREIL_PRINT_IL=1 ./scores > scores.coreil.json
+//
+// prints it and exits without running the program.
+
+//go:embed program.coreil.json
+var coreilEmbeddedIL []byte
+
+func init() {
+	if os.Getenv("COREIL_PRINT_IL") == "1" {
+		os.Stdout.Write(coreilEmbeddedIL)
+		os.Exit(0)
+	}
+}
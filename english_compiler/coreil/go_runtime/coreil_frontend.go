@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// LLM frontends
+// ============================================================================
+
+// Built next to coreil_runtime.go and english_compiler/frontend/prompt.txt,
+// this file lets a Go host translate English to Core IL itself, with the
+// system prompt and defaults of the Python frontends:
+//
+//	f, err := NewFrontend(FrontendConfig{Provider: "anthropic"})
+//	DefaultEngine.SetFrontend(f)
+//	prog, err := DefaultEngine.Translate("Print the sum of 2 and 3.")
+//
+// Providers and the environment variables they read when a FrontendConfig
+// field is empty:
+//
+//   - "anthropic": ANTHROPIC_API_KEY, ANTHROPIC_MODEL, ANTHROPIC_MAX_TOKENS,
+//     ANTHROPIC_BASE_URL
+//   - "openai": OPENAI_API_KEY, OPENAI_MODEL, OPENAI_MAX_TOKENS,
+//     OPENAI_BASE_URL
+//   - "llamacpp": a local server speaking the OpenAI chat completions API,
+//     such as llama.cpp's llama-server or Ollama; LLAMACPP_BASE_URL (default
+//     http://localhost:8080/v1), LLAMACPP_MODEL, LLAMACPP_MAX_TOKENS and an
+//     optional LLAMACPP_API_KEY
+//
+// An empty Provider picks the first of anthropic and openai whose API key
+// is set, like the CLI's auto-detection, and llamacpp otherwise.
+
+//go:embed prompt.txt
+var coreilFrontendPrompt string
+
+// FrontendConfig selects and configures the frontend NewFrontend returns.
+type FrontendConfig struct {
+	Provider  string
+	Model     string
+	APIKey    string
+	BaseURL   string // API root, e.g. https://api.openai.com/v1
+	MaxTokens int
+	// SystemPrompt replaces the embedded prompt.txt.
+	SystemPrompt string
+	// Timeout bounds each request; 0 means 5 minutes.
+	Timeout time.Duration
+}
+
+// frontendProvider holds a provider's display name, environment variable
+// prefix and defaults.
+type frontendProvider struct {
+	name      string
+	env       string
+	model     string
+	baseURL   string
+	keyNeeded bool
+}
+
+var frontendProviders = map[string]frontendProvider{
+	"anthropic": {"Anthropic", "ANTHROPIC", "claude-haiku-4-5-20251001", "https://api.anthropic.com", true},
+	"openai":    {"OpenAI", "OPENAI", "gpt-4o", "https://api.openai.com/v1", true},
+	"llamacpp":  {"llama.cpp", "LLAMACPP", "", "http://localhost:8080/v1", false},
+}
+
+// llmFrontend is a Frontend calling a provider's HTTP API.
+type llmFrontend struct {
+	provider string
+	info     frontendProvider
+	cfg      FrontendConfig
+	client   *http.Client
+}
+
+// NewFrontend returns the frontend cfg describes, filling empty fields
+// from the environment.
+func NewFrontend(cfg FrontendConfig) (Frontend, error) {
+	provider := cfg.Provider
+	if provider == "" {
+		provider = "llamacpp"
+		for _, name := range []string{"anthropic", "openai"} {
+			if os.Getenv(frontendProviders[name].env+"_API_KEY") != "" {
+				provider = name
+				break
+			}
+		}
+	}
+	info, ok := frontendProviders[provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown frontend: %s", provider)
+	}
+	if cfg.APIKey == "" {
+		cfg.APIKey = os.Getenv(info.env + "_API_KEY")
+	}
+	if cfg.APIKey == "" && info.keyNeeded {
+		return nil, fmt.Errorf("%s_API_KEY is not set", info.env)
+	}
+	if cfg.Model == "" {
+		cfg.Model = os.Getenv(info.env + "_MODEL")
+	}
+	if cfg.Model == "" {
+		cfg.Model = info.model
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = os.Getenv(info.env + "_BASE_URL")
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = info.baseURL
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	if cfg.MaxTokens == 0 {
+		cfg.MaxTokens = 4096
+		if raw := os.Getenv(info.env + "_MAX_TOKENS"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("%s_MAX_TOKENS must be a positive integer, got: %q", info.env, raw)
+			}
+			cfg.MaxTokens = n
+		}
+	}
+	if cfg.SystemPrompt == "" {
+		cfg.SystemPrompt = coreilFrontendPrompt
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Minute
+	}
+	return &llmFrontend{
+		provider: provider,
+		info:     info,
+		cfg:      cfg,
+		client:   &http.Client{Timeout: cfg.Timeout},
+	}, nil
+}
+
+// Translate asks the model for the Core IL of english.
+func (f *llmFrontend) Translate(english string) (ILProgram, error) {
+	var text string
+	var err error
+	if f.provider == "anthropic" {
+		text, err = f.anthropicMessage(english)
+	} else {
+		text, err = f.chatCompletion(english)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f.parseProgram(text)
+}
+
+// anthropicMessage calls the Anthropic Messages API.
+func (f *llmFrontend) anthropicMessage(english string) (string, error) {
+	var resp struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	err := f.post("/v1/messages", map[string]interface{}{
+		"model":       f.cfg.Model,
+		"max_tokens":  f.cfg.MaxTokens,
+		"temperature": 0,
+		"system":      f.cfg.SystemPrompt,
+		"messages":    []map[string]string{{"role": "user", "content": english}},
+	}, map[string]string{
+		"x-api-key":         f.cfg.APIKey,
+		"anthropic-version": "2023-06-01",
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+	var text strings.Builder
+	for _, block := range resp.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	return text.String(), nil
+}
+
+// chatCompletion calls an OpenAI-compatible chat completions API in JSON
+// mode.
+func (f *llmFrontend) chatCompletion(english string) (string, error) {
+	var resp struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	body := map[string]interface{}{
+		"max_tokens":  f.cfg.MaxTokens,
+		"temperature": 0,
+		"messages": []map[string]string{
+			{"role": "system", "content": f.cfg.SystemPrompt},
+			{"role": "user", "content": english},
+		},
+		"response_format": map[string]string{"type": "json_object"},
+	}
+	if f.cfg.Model != "" {
+		body["model"] = f.cfg.Model
+	}
+	headers := map[string]string{}
+	if f.cfg.APIKey != "" {
+		headers["Authorization"] = "Bearer " + f.cfg.APIKey
+	}
+	if err := f.post("/chat/completions", body, headers, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", nil
+	}
+	return resp.Choices[0].Message.Content, nil
+}
+
+// post sends body as JSON to path under the base URL and decodes the
+// response into out.
+func (f *llmFrontend) post(path string, body interface{}, headers map[string]string, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", f.cfg.BaseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s API request failed: %w", f.info.name, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%s API request failed: %w", f.info.name, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s API error: %s: %s", f.info.name, resp.Status, responseSnippet(string(data)))
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("%s API returned an unexpected response: %s", f.info.name, responseSnippet(string(data)))
+	}
+	return nil
+}
+
+// parseProgram decodes the model's reply, which may be wrapped in a
+// markdown code block.
+func (f *llmFrontend) parseProgram(raw string) (ILProgram, error) {
+	text := strings.TrimSpace(raw)
+	if strings.HasPrefix(text, "```") {
+		if nl := strings.IndexByte(text, '\n'); nl != -1 {
+			text = text[nl+1:]
+		}
+		text = strings.TrimSpace(strings.TrimSuffix(text, "```"))
+	}
+	if text == "" {
+		return nil, fmt.Errorf("%s returned an empty response", f.info.name)
+	}
+	var data interface{}
+	if err := json.Unmarshal([]byte(text), &data); err != nil {
+		return nil, fmt.Errorf("%s returned invalid JSON. Response snippet: %s", f.info.name, responseSnippet(raw))
+	}
+	prog, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, errors.New(f.info.name + " returned JSON that is not an object")
+	}
+	return ILProgram(prog), nil
+}
+
+// responseSnippet shortens s for an error message.
+func responseSnippet(s string) string {
+	if len(s) > 400 {
+		return s[:400]
+	}
+	return s
+}
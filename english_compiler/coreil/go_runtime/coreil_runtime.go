@@ -1,13 +1,51 @@
 package main
 
 import (
+	stdzip "archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"container/list"
+	"context"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	stdxml "encoding/xml"
+	"errors"
 	"fmt"
+	"hash"
+	stdhtml "html" // named apart from program variables called html
+	"io"
+	"log/slog"
 	"math"
+	"math/big"
+	"math/rand"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	netmail "net/mail"
+	"net/smtp"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"runtime"
+	"runtime/pprof"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 )
 
 // ============================================================================
@@ -30,11 +68,31 @@ const (
 	TypeSet
 	TypeDeque
 	TypeHeap
+	TypeFunc
+	TypeClass
+	TypeVariant
+	TypeChannel
+	TypeFuture
+	TypePool
+	TypeLock
+	TypeCounter
+	TypeActor
+	TypeEventBus
+	TypeTimer
+	TypeGenerator
+	TypePattern
+	TypeTable
+	TypeNode
+	TypeSecret
 )
 
 // Value is the universal value type for Core IL.
+//
+// Ints, floats and bools are stored unboxed in num so numeric code does not
+// allocate; strings and reference types live in data.
 type Value struct {
 	Type ValueType
+	num  uint64
 	data interface{}
 }
 
@@ -42,15 +100,63 @@ type Value struct {
 
 var ValueNone = Value{Type: TypeNone}
 
-func ValueInt(v int64) Value    { return Value{Type: TypeInt, data: v} }
-func ValueFloat(v float64) Value { return Value{Type: TypeFloat, data: v} }
-func ValueBool(v bool) Value    { return Value{Type: TypeBool, data: v} }
-func ValueStr(v string) Value   { return Value{Type: TypeStr, data: v} }
+func ValueInt(v int64) Value     { return Value{Type: TypeInt, num: uint64(v)} }
+func ValueFloat(v float64) Value { return Value{Type: TypeFloat, num: math.Float64bits(v)} }
+
+// ValueStr returns interned Values for the empty string and single-byte
+// strings, so character-by-character code does not box a new string each
+// time. Ints need no interning since they are stored unboxed.
+func ValueStr(v string) Value {
+	switch len(v) {
+	case 0:
+		return emptyStr
+	case 1:
+		return byteStrs[v[0]]
+	}
+	return Value{Type: TypeStr, data: v}
+}
+
+var (
+	emptyStr = Value{Type: TypeStr, data: ""}
+	byteStrs = func() (t [256]Value) {
+		for i := range t {
+			t[i] = Value{Type: TypeStr, data: string([]byte{byte(i)})}
+		}
+		return t
+	}()
+)
+
+func ValueBool(v bool) Value {
+	if v {
+		return Value{Type: TypeBool, num: 1}
+	}
+	return Value{Type: TypeBool}
+}
+
+// Unboxed payload accessors; callers must have checked v.Type.
+func (v Value) intData() int64     { return int64(v.num) }
+func (v Value) floatData() float64 { return math.Float64frombits(v.num) }
+func (v Value) boolData() bool     { return v.num != 0 }
+
+// Array is the backing store of an array Value. Slices share their parent's
+// items copy-on-write: both sides are marked shared, and the first in-place
+// write to a shared array copies its items.
+type Array struct {
+	items  []Value
+	shared bool
+}
 
 func ValueArray(items []Value) Value {
+	trackGrowth(len(items), int64(len(items))*valueBytes)
 	arr := make([]Value, len(items))
 	copy(arr, items)
-	return Value{Type: TypeArray, data: &arr}
+	return Value{Type: TypeArray, data: &Array{items: arr}}
+}
+
+// ValueArrayWithCapacity returns an empty array with room for n items, for
+// construction loops whose final size is known up front.
+func ValueArrayWithCapacity(n int64) Value {
+	return Value{Type: TypeArray, data: &Array{items: make([]Value, 0, capacityHint(n))}}
 }
 
 func ValueTupleNew(items []Value) Value {
@@ -59,10 +165,14 @@ func ValueTupleNew(items []Value) Value {
 	return Value{Type: TypeTuple, data: t}
 }
 
-// OrderedMap maintains insertion order.
+// OrderedMap maintains insertion order. Entries are indexed by hashKey();
+// for keys that are not plain strings the original key Value is kept in
+// keyVals so it can be returned by Keys and printed.
 type OrderedMap struct {
-	keys   []string
-	values map[string]Value
+	keys    []string
+	values  map[string]Value
+	keyVals map[string]Value
+	frozen  bool
 }
 
 func NewOrderedMap() *OrderedMap {
@@ -70,12 +180,41 @@ func NewOrderedMap() *OrderedMap {
 }
 
 func (m *OrderedMap) Set(key string, val Value) {
+	if m.frozen {
+		panic(runtimeError(KindTypeError, "cannot modify frozen map"))
+	}
 	if _, exists := m.values[key]; !exists {
 		m.keys = append(m.keys, key)
 	}
 	m.values[key] = val
 }
 
+// SetValue stores val under an arbitrary hashable key.
+func (m *OrderedMap) SetValue(key, val Value) {
+	k := hashKey(key)
+	if key.Type != TypeStr {
+		if m.keyVals == nil {
+			m.keyVals = make(map[string]Value)
+		}
+		if _, exists := m.values[k]; !exists {
+			m.keyVals[k] = key
+		}
+	}
+	m.Set(k, val)
+}
+
+func (m *OrderedMap) GetValue(key Value) (Value, bool) {
+	return m.Get(hashKey(key))
+}
+
+// keyValue returns the original key Value for an internal key.
+func (m *OrderedMap) keyValue(k string) Value {
+	if kv, ok := m.keyVals[k]; ok {
+		return kv
+	}
+	return ValueStr(k)
+}
+
 func (m *OrderedMap) Get(key string) (Value, bool) {
 	v, ok := m.values[key]
 	return v, ok
@@ -90,7 +229,7 @@ func (m *OrderedMap) Keys() []string {
 func ValueMapNew(pairs []struct{ K, V Value }) Value {
 	om := NewOrderedMap()
 	for _, p := range pairs {
-		om.Set(asString(p.K), p.V)
+		om.SetValue(p.K, p.V)
 	}
 	return Value{Type: TypeMap, data: om}
 }
@@ -99,10 +238,89 @@ func ValueMapEmpty() Value {
 	return Value{Type: TypeMap, data: NewOrderedMap()}
 }
 
+// maxCapacityHint bounds capacity hints so a bad estimate cannot allocate
+// unbounded memory up front; growth past it falls back to append.
+const maxCapacityHint = 1 << 20
+
+func capacityHint(n int64) int {
+	if n < 0 {
+		return 0
+	}
+	if n > maxCapacityHint {
+		return maxCapacityHint
+	}
+	return int(n)
+}
+
+// ValueMapWithCapacity returns an empty map with room for n entries.
+func ValueMapWithCapacity(n int64) Value {
+	c := capacityHint(n)
+	return Value{Type: TypeMap, data: &OrderedMap{keys: make([]string, 0, c), values: make(map[string]Value, c)}}
+}
+
 // Record
 type Record struct {
 	fields map[string]Value
 	order  []string
+	schema *RecordSchema
+	class  *Class
+	frozen bool
+}
+
+// SchemaField declares one record field. Type is a typeName() such as "int"
+// or "str", or "any" to accept every value. Optional fields may be absent or
+// None; an int is accepted where a float is declared.
+type SchemaField struct {
+	Name     string
+	Type     string
+	Required bool
+}
+
+// RecordSchema describes the expected shape of a record.
+type RecordSchema struct {
+	name   string
+	fields map[string]SchemaField
+	order  []string
+}
+
+func NewRecordSchema(name string, fields []SchemaField) *RecordSchema {
+	rs := &RecordSchema{name: name, fields: make(map[string]SchemaField)}
+	for _, f := range fields {
+		if _, dup := rs.fields[f.Name]; dup {
+			panic(runtimeError(KindValueError, "%s: duplicate schema field '%s'", name, f.Name))
+		}
+		rs.fields[f.Name] = f
+		rs.order = append(rs.order, f.Name)
+	}
+	return rs
+}
+
+// checkField validates a single field assignment against the schema.
+func (rs *RecordSchema) checkField(name string, val Value) {
+	f, ok := rs.fields[name]
+	if !ok {
+		panic(runtimeError(KindTypeError, "%s: unexpected field '%s'", rs.name, name))
+	}
+	if f.Type == "any" || (val.Type == TypeNone && !f.Required) {
+		return
+	}
+	actual := typeName(val)
+	if actual == f.Type || (f.Type == "float" && val.Type == TypeInt) {
+		return
+	}
+	panic(runtimeError(KindTypeError, "%s: field '%s' expected %s, got %s", rs.name, name, f.Type, actual))
+}
+
+// validate checks every field of r and that all required fields are present.
+func (rs *RecordSchema) validate(r *Record) {
+	for _, name := range r.order {
+		rs.checkField(name, r.fields[name])
+	}
+	for _, name := range rs.order {
+		if _, ok := r.fields[name]; !ok && rs.fields[name].Required {
+			panic(runtimeError(KindTypeError, "%s: missing required field '%s'", rs.name, name))
+		}
+	}
 }
 
 func NewRecord(pairs []struct{ Name string; Val Value }) *Record {
@@ -118,19 +336,36 @@ func ValueRecordNew(pairs []struct{ Name string; Val Value }) Value {
 	return Value{Type: TypeRecord, data: NewRecord(pairs)}
 }
 
-// Set (uses map[string]Value for dedup by formatted value)
+// ValueRecordNewWithSchema builds a record and validates it against schema.
+func ValueRecordNewWithSchema(schema *RecordSchema, pairs []struct{ Name string; Val Value }) Value {
+	r := NewRecord(pairs)
+	r.schema = schema
+	schema.validate(r)
+	return Value{Type: TypeRecord, data: r}
+}
+
+// Set (uses map[string]Value for dedup by hashKey)
 type ValueSet struct {
-	items map[string]Value
+	items  map[string]Value
+	frozen bool
 }
 
 func NewValueSet() *ValueSet {
 	return &ValueSet{items: make(map[string]Value)}
 }
 
+// add inserts item unless an equal member exists (the first one is kept).
+func (s *ValueSet) add(item Value) {
+	k := hashKey(item)
+	if _, exists := s.items[k]; !exists {
+		s.items[k] = item
+	}
+}
+
 func ValueSetNew(items []Value) Value {
 	s := NewValueSet()
 	for _, item := range items {
-		s.items[formatValue(item)] = item
+		s.add(item)
 	}
 	return Value{Type: TypeSet, data: s}
 }
@@ -138,6 +373,10 @@ func ValueSetNew(items []Value) Value {
 // Deque
 type Deque struct {
 	items []Value
+	// A bounded deque holds at most maxlen items; pushing onto a full one
+	// evicts an item from the far end, like Python's collections.deque.
+	bounded bool
+	maxlen  int
 }
 
 func NewDeque() *Deque {
@@ -148,6 +387,21 @@ func ValueDequeNew() Value {
 	return Value{Type: TypeDeque, data: NewDeque()}
 }
 
+// full reports whether a push must evict an item; a bounded deque does
+// not grow, so such pushes are not counted against the memory limit.
+func (d *Deque) full() bool {
+	return d.bounded && len(d.items) >= d.maxlen
+}
+
+// ValueDequeNewBounded makes the deque of a DequeNew with a maxlen.
+func ValueDequeNewBounded(maxlen Value) Value {
+	n := asInt(maxlen)
+	if n < 0 {
+		panic(runtimeError(KindValueError, "deque maxlen must be non-negative, got %d", n))
+	}
+	return Value{Type: TypeDeque, data: &Deque{bounded: true, maxlen: int(n)}}
+}
+
 // Heap (min-heap by priority)
 type HeapItem struct {
 	priority float64
@@ -171,7 +425,7 @@ func (h *MinHeap) Push(x HeapItem) {
 }
 func (h *MinHeap) Pop() HeapItem {
 	if len(h.items) == 0 {
-		panic("runtime error: heap is empty")
+		panic(runtimeError(KindIndexError, "heap is empty"))
 	}
 	top := h.items[0]
 	n := len(h.items) - 1
@@ -184,7 +438,7 @@ func (h *MinHeap) Pop() HeapItem {
 }
 func (h *MinHeap) Peek() Value {
 	if len(h.items) == 0 {
-		panic("runtime error: heap is empty")
+		panic(runtimeError(KindIndexError, "heap is empty"))
 	}
 	return h.items[0].value
 }
@@ -217,10 +471,94 @@ func (h *MinHeap) siftDown(i int) {
 	}
 }
 
+// heapify restores the heap order of arbitrary items in O(n), sifting
+// down every parent from the last one up.
+func (h *MinHeap) heapify() {
+	for i := len(h.items)/2 - 1; i >= 0; i-- {
+		h.siftDown(i)
+	}
+}
+
 func ValueHeapNew() Value {
 	return Value{Type: TypeHeap, data: NewMinHeap()}
 }
 
+// Function (first-class function value wrapping a Go func)
+type Function struct {
+	name string
+	fn   func(args []Value) Value
+	memo *Memo // the cache of a function returned by memoize
+}
+
+func ValueFunc(name string, fn func(args []Value) Value) Value {
+	return Value{Type: TypeFunc, data: &Function{name: name, fn: fn}}
+}
+
+// Class is a method table shared by its instances. Instances are records
+// whose class pointer is set; methods receive the instance as first argument.
+type Class struct {
+	name    string
+	parent  *Class
+	methods map[string]Value
+}
+
+func NewClass(name string, parent *Class, methods []struct{ Name string; Val Value }) *Class {
+	c := &Class{name: name, parent: parent, methods: make(map[string]Value)}
+	for _, m := range methods {
+		asFunc(m.Val)
+		c.methods[m.Name] = m.Val
+	}
+	return c
+}
+
+func ValueClass(c *Class) Value {
+	return Value{Type: TypeClass, data: c}
+}
+
+// Enum declares the tags of a tagged union; Variant is one value of it.
+type Enum struct {
+	name string
+	tags []string
+	bare bool // print tags without the enum name (Option/Result)
+}
+
+type Variant struct {
+	enum    *Enum
+	tag     string
+	payload Value
+}
+
+func NewEnum(name string, tags []string) *Enum {
+	return &Enum{name: name, tags: append([]string(nil), tags...)}
+}
+
+func (e *Enum) hasTag(tag string) bool {
+	for _, t := range e.tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ValueVariant constructs a variant, rejecting tags the enum does not declare.
+func ValueVariant(e *Enum, tag string, payload Value) Value {
+	if !e.hasTag(tag) {
+		panic(runtimeError(KindValueError, "%s has no variant '%s'", e.name, tag))
+	}
+	return Value{Type: TypeVariant, data: &Variant{enum: e, tag: tag, payload: payload}}
+}
+
+// lookupMethod searches the class and then its ancestors.
+func (c *Class) lookupMethod(name string) (Value, bool) {
+	for k := c; k != nil; k = k.parent {
+		if m, ok := k.methods[name]; ok {
+			return m, true
+		}
+	}
+	return ValueNone, false
+}
+
 // ============================================================================
 // Value accessors
 // ============================================================================
@@ -228,27 +566,33 @@ func ValueHeapNew() Value {
 func asInt(v Value) int64 {
 	switch v.Type {
 	case TypeInt:
-		return v.data.(int64)
+		return v.intData()
 	case TypeFloat:
-		return int64(v.data.(float64))
+		if DefaultEngine.strict {
+			panic(runtimeError(KindTypeError, "expected int, got float %s (strict mode does not truncate floats)", formatValue(v)))
+		}
+		return int64(v.floatData())
 	case TypeBool:
-		if v.data.(bool) {
+		if DefaultEngine.strict {
+			panic(runtimeError(KindTypeError, "expected int, got bool (strict mode does not count bools as ints)"))
+		}
+		if v.boolData() {
 			return 1
 		}
 		return 0
 	default:
-		panic(fmt.Sprintf("runtime error: expected int, got %s", typeName(v)))
+		panic(runtimeError(KindTypeError, "expected int, got %s", typeName(v)))
 	}
 }
 
 func asFloat(v Value) float64 {
 	switch v.Type {
 	case TypeInt:
-		return float64(v.data.(int64))
+		return float64(v.intData())
 	case TypeFloat:
-		return v.data.(float64)
+		return v.floatData()
 	default:
-		panic(fmt.Sprintf("runtime error: expected number, got %s", typeName(v)))
+		panic(runtimeError(KindTypeError, "expected number, got %s", typeName(v)))
 	}
 }
 
@@ -256,56 +600,154 @@ func asString(v Value) string {
 	if v.Type == TypeStr {
 		return v.data.(string)
 	}
-	panic(fmt.Sprintf("runtime error: expected string, got %s", typeName(v)))
+	panic(runtimeError(KindTypeError, "expected string, got %s", typeName(v)))
 }
 
 func asBool(v Value) bool {
 	if v.Type == TypeBool {
-		return v.data.(bool)
+		return v.boolData()
 	}
-	panic(fmt.Sprintf("runtime error: expected bool, got %s", typeName(v)))
+	panic(runtimeError(KindTypeError, "expected bool, got %s", typeName(v)))
 }
 
+// asArray returns the items of an array for reading. Use mutableArray
+// before writing elements in place.
 func asArray(v Value) *[]Value {
 	if v.Type == TypeArray {
-		return v.data.(*[]Value)
+		return &v.data.(*Array).items
+	}
+	panic(runtimeError(KindTypeError, "expected array, got %s", typeName(v)))
+}
+
+// mutableArray returns the items of an array for in-place writes, first
+// copying them if they are shared with a slice.
+func mutableArray(v Value) *[]Value {
+	arr := asArray(v)
+	a := v.data.(*Array)
+	if a.shared {
+		a.items = append([]Value(nil), a.items...)
+		a.shared = false
 	}
-	panic(fmt.Sprintf("runtime error: expected array, got %s", typeName(v)))
+	return arr
 }
 
 func asMap(v Value) *OrderedMap {
 	if v.Type == TypeMap {
 		return v.data.(*OrderedMap)
 	}
-	panic(fmt.Sprintf("runtime error: expected map, got %s", typeName(v)))
+	panic(runtimeError(KindTypeError, "expected map, got %s", typeName(v)))
 }
 
 func asRecord(v Value) *Record {
 	if v.Type == TypeRecord {
 		return v.data.(*Record)
 	}
-	panic(fmt.Sprintf("runtime error: expected record, got %s", typeName(v)))
+	panic(runtimeError(KindTypeError, "expected record, got %s", typeName(v)))
 }
 
 func asSet(v Value) *ValueSet {
 	if v.Type == TypeSet {
 		return v.data.(*ValueSet)
 	}
-	panic(fmt.Sprintf("runtime error: expected set, got %s", typeName(v)))
+	panic(runtimeError(KindTypeError, "expected set, got %s", typeName(v)))
 }
 
 func asDeque(v Value) *Deque {
 	if v.Type == TypeDeque {
 		return v.data.(*Deque)
 	}
-	panic(fmt.Sprintf("runtime error: expected deque, got %s", typeName(v)))
+	panic(runtimeError(KindTypeError, "expected deque, got %s", typeName(v)))
 }
 
 func asHeap(v Value) *MinHeap {
 	if v.Type == TypeHeap {
 		return v.data.(*MinHeap)
 	}
-	panic(fmt.Sprintf("runtime error: expected heap, got %s", typeName(v)))
+	panic(runtimeError(KindTypeError, "expected heap, got %s", typeName(v)))
+}
+
+func asFunc(v Value) *Function {
+	if v.Type == TypeFunc {
+		return v.data.(*Function)
+	}
+	panic(runtimeError(KindTypeError, "expected function, got %s", typeName(v)))
+}
+
+func asClass(v Value) *Class {
+	if v.Type == TypeClass {
+		return v.data.(*Class)
+	}
+	panic(runtimeError(KindTypeError, "expected class, got %s", typeName(v)))
+}
+
+func asVariant(v Value) *Variant {
+	if v.Type == TypeVariant {
+		return v.data.(*Variant)
+	}
+	panic(runtimeError(KindTypeError, "expected variant, got %s", typeName(v)))
+}
+
+func asChannel(v Value) *Channel {
+	if v.Type == TypeChannel {
+		return v.data.(*Channel)
+	}
+	panic(runtimeError(KindTypeError, "expected channel, got %s", typeName(v)))
+}
+
+func asFuture(v Value) *Future {
+	if v.Type == TypeFuture {
+		return v.data.(*Future)
+	}
+	panic(runtimeError(KindTypeError, "expected future, got %s", typeName(v)))
+}
+
+func asPool(v Value) *Pool {
+	if v.Type == TypePool {
+		return v.data.(*Pool)
+	}
+	panic(runtimeError(KindTypeError, "expected pool, got %s", typeName(v)))
+}
+
+func asLock(v Value) *Lock {
+	if v.Type == TypeLock {
+		return v.data.(*Lock)
+	}
+	panic(runtimeError(KindTypeError, "expected lock, got %s", typeName(v)))
+}
+
+func asCounter(v Value) *Counter {
+	if v.Type == TypeCounter {
+		return v.data.(*Counter)
+	}
+	panic(runtimeError(KindTypeError, "expected counter, got %s", typeName(v)))
+}
+
+func asActor(v Value) *Actor {
+	if v.Type == TypeActor {
+		return v.data.(*Actor)
+	}
+	panic(runtimeError(KindTypeError, "expected actor, got %s", typeName(v)))
+}
+
+func asEventBus(v Value) *EventBus {
+	if v.Type == TypeEventBus {
+		return v.data.(*EventBus)
+	}
+	panic(runtimeError(KindTypeError, "expected event bus, got %s", typeName(v)))
+}
+
+func asTimer(v Value) *Timer {
+	if v.Type == TypeTimer {
+		return v.data.(*Timer)
+	}
+	panic(runtimeError(KindTypeError, "expected timer, got %s", typeName(v)))
+}
+
+func asGenerator(v Value) *Generator {
+	if v.Type == TypeGenerator {
+		return v.data.(*Generator)
+	}
+	panic(runtimeError(KindTypeError, "expected generator, got %s", typeName(v)))
 }
 
 func typeName(v Value) string {
@@ -334,858 +776,10976 @@ func typeName(v Value) string {
 		return "deque"
 	case TypeHeap:
 		return "heap"
+	case TypeFunc:
+		return "function"
+	case TypeClass:
+		return "class"
+	case TypeVariant:
+		return "variant"
+	case TypeChannel:
+		return "channel"
+	case TypeFuture:
+		return "future"
+	case TypePool:
+		return "pool"
+	case TypeLock:
+		return "lock"
+	case TypeCounter:
+		return "counter"
+	case TypeActor:
+		return "actor"
+	case TypeEventBus:
+		return "event bus"
+	case TypeTimer:
+		return "timer"
+	case TypeGenerator:
+		return "generator"
+	case TypePattern:
+		return "pattern"
+	case TypeTable:
+		return "table"
+	case TypeNode:
+		return "node"
+	case TypeSecret:
+		return "secret"
 	default:
 		return "unknown"
 	}
 }
 
 // ============================================================================
-// Truthiness
+// Errors
 // ============================================================================
 
-func isTruthy(v Value) bool {
-	switch v.Type {
-	case TypeNone:
-		return false
-	case TypeBool:
-		return v.data.(bool)
-	case TypeInt:
-		return v.data.(int64) != 0
-	case TypeFloat:
-		return v.data.(float64) != 0
-	case TypeStr:
-		return v.data.(string) != ""
-	case TypeArray:
-		return len(*v.data.(*[]Value)) > 0
-	case TypeMap:
-		return len(v.data.(*OrderedMap).keys) > 0
-	default:
-		return true
+// ErrorKind classifies the errors a program can raise. Kinds and their codes
+// are stable, so IL catch blocks (via errorKind and errorCode) and host Go
+// code (via ErrorKindOf) can branch on them instead of on messages.
+type ErrorKind string
+
+const (
+	// KindError is raised by Throw; its message is the thrown value.
+	KindError             ErrorKind = "Error"
+	KindRuntimeError      ErrorKind = "RuntimeError"
+	KindTypeError         ErrorKind = "TypeError"
+	KindValueError        ErrorKind = "ValueError"
+	KindIndexError        ErrorKind = "IndexError"
+	KindKeyError          ErrorKind = "KeyError"
+	KindAttributeError    ErrorKind = "AttributeError"
+	KindZeroDivisionError ErrorKind = "ZeroDivisionError"
+	KindOverflowError     ErrorKind = "OverflowError"
+	KindRecursionError    ErrorKind = "RecursionError"
+	KindTimeoutError      ErrorKind = "TimeoutError"
+	KindIOError           ErrorKind = "IOError"
+	KindPermissionError   ErrorKind = "PermissionError"
+	KindAssertionError    ErrorKind = "AssertionError"
+	// KindLimitError and KindCancelledError are LimitExceeded and
+	// Cancelled, which programs cannot catch.
+	KindLimitError     ErrorKind = "LimitError"
+	KindCancelledError ErrorKind = "CancelledError"
+)
+
+var errorCodes = map[ErrorKind]string{
+	KindError:             "E000",
+	KindRuntimeError:      "E001",
+	KindTypeError:         "E100",
+	KindValueError:        "E101",
+	KindIndexError:        "E102",
+	KindKeyError:          "E103",
+	KindAttributeError:    "E104",
+	KindZeroDivisionError: "E110",
+	KindOverflowError:     "E111",
+	KindRecursionError:    "E120",
+	KindTimeoutError:      "E121",
+	KindIOError:           "E200",
+	KindPermissionError:   "E210",
+	KindAssertionError:    "E300",
+	KindLimitError:        "E400",
+	KindCancelledError:    "E401",
+}
+
+// Code returns the kind's stable error code, e.g. "E102" for IndexError.
+func (k ErrorKind) Code() string {
+	if code, ok := errorCodes[k]; ok {
+		return code
 	}
+	return errorCodes[KindError]
 }
 
-// ============================================================================
-// Formatting / Printing
-// ============================================================================
+// CoreILError is the panic value of every error the runtime raises on the
+// program's behalf. Message is the full text, e.g. "runtime error: index 3
+// out of range for array of length 2".
+type CoreILError struct {
+	Kind    ErrorKind
+	Message string
+}
 
-func formatValue(v Value) string {
-	switch v.Type {
-	case TypeNone:
-		return "None"
-	case TypeInt:
-		return strconv.FormatInt(v.data.(int64), 10)
-	case TypeFloat:
-		f := v.data.(float64)
-		s := strconv.FormatFloat(f, 'f', -1, 64)
-		if !strings.Contains(s, ".") {
-			s += ".0"
-		}
-		return s
-	case TypeBool:
-		if v.data.(bool) {
-			return "True"
-		}
-		return "False"
-	case TypeStr:
-		return v.data.(string)
-	case TypeArray:
-		arr := *v.data.(*[]Value)
-		parts := make([]string, len(arr))
-		for i, item := range arr {
-			parts[i] = reprValue(item)
-		}
-		return "[" + strings.Join(parts, ", ") + "]"
-	case TypeTuple:
-		items := v.data.([]Value)
-		parts := make([]string, len(items))
-		for i, item := range items {
-			parts[i] = reprValue(item)
-		}
-		return "(" + strings.Join(parts, ", ") + ")"
-	case TypeMap:
-		om := v.data.(*OrderedMap)
-		parts := make([]string, len(om.keys))
-		for i, k := range om.keys {
-			parts[i] = fmt.Sprintf("'%s': %s", k, reprValue(om.values[k]))
-		}
-		return "{" + strings.Join(parts, ", ") + "}"
-	case TypeRecord:
-		r := v.data.(*Record)
-		parts := make([]string, len(r.order))
-		for i, name := range r.order {
-			parts[i] = fmt.Sprintf("%s=%s", name, reprValue(r.fields[name]))
-		}
-		return "Record(" + strings.Join(parts, ", ") + ")"
-	case TypeSet:
-		s := v.data.(*ValueSet)
-		keys := make([]string, 0, len(s.items))
-		for k := range s.items {
-			keys = append(keys, k)
-		}
-		sort.Strings(keys)
-		parts := make([]string, len(keys))
-		for i, k := range keys {
-			parts[i] = reprValue(s.items[k])
+func (e *CoreILError) Error() string { return e.Message }
+
+// Code returns the error's stable code.
+func (e *CoreILError) Code() string { return e.Kind.Code() }
+
+// runtimeError builds a *CoreILError whose message is "runtime error: "
+// followed by the formatted text.
+func runtimeError(kind ErrorKind, format string, args ...interface{}) *CoreILError {
+	return &CoreILError{Kind: kind, Message: "runtime error: " + fmt.Sprintf(format, args...)}
+}
+
+// ErrorKindOf classifies a value recovered from a panicking program or an
+// error returned by the engine: a *CoreILError by its kind, LimitExceeded
+// and Cancelled as LimitError and CancelledError, and anything else
+// (including Throw's message) as Error.
+func ErrorKindOf(r interface{}) ErrorKind {
+	switch r := r.(type) {
+	case *CoreILError:
+		return r.Kind
+	case *LimitExceeded:
+		return KindLimitError
+	case *Cancelled:
+		return KindCancelledError
+	case error:
+		var ce *CoreILError
+		if errors.As(r, &ce) {
+			return ce.Kind
 		}
-		return "{" + strings.Join(parts, ", ") + "}"
-	default:
-		return fmt.Sprintf("<%s>", typeName(v))
 	}
+	return KindError
 }
 
-func reprValue(v Value) string {
-	if v.Type == TypeStr {
-		return fmt.Sprintf("'%s'", v.data.(string))
+// caughtKinds numbers the error kinds a caught message can carry. The index
+// is kept in the otherwise unused num slot of the message's string Value, so
+// the kind travels with the message; plain strings have index 0, KindError.
+var caughtKinds = []ErrorKind{
+	KindError, KindRuntimeError, KindTypeError, KindValueError, KindIndexError, KindKeyError,
+	KindAttributeError, KindZeroDivisionError, KindOverflowError, KindRecursionError,
+	KindTimeoutError, KindIOError, KindPermissionError, KindAssertionError,
+	KindLimitError, KindCancelledError,
+}
+
+// caughtError converts an error recovered by TryCatch or tryCall into the
+// message string IL code sees, tagged with its kind for errorKind.
+func caughtError(r interface{}) Value {
+	v := Value{Type: TypeStr, data: fmt.Sprint(r)}
+	kind := ErrorKindOf(r)
+	for i, k := range caughtKinds {
+		if k == kind {
+			v.num = uint64(i)
+		}
 	}
-	return formatValue(v)
+	return v
 }
 
-func coreilPrint(args []Value) {
-	parts := make([]string, len(args))
-	for i, arg := range args {
-		parts[i] = formatValue(arg)
+// errorKind returns the kind name (e.g. "KeyError") of a caught error
+// message, or "Error" for a thrown value.
+func errorKind(err Value) Value {
+	return ValueStr(string(caughtKind(err)))
+}
+
+// errorCode returns the stable code (e.g. "E103") of a caught error message.
+func errorCode(err Value) Value {
+	return ValueStr(caughtKind(err).Code())
+}
+
+func caughtKind(err Value) ErrorKind {
+	if err.Type == TypeStr && err.num < uint64(len(caughtKinds)) {
+		return caughtKinds[err.num]
 	}
-	fmt.Println(strings.Join(parts, " "))
+	return KindError
 }
 
 // ============================================================================
-// Arithmetic / Comparison
+// Engine
 // ============================================================================
 
-func valueAdd(a, b Value) Value {
-	if a.Type == TypeStr && b.Type == TypeStr {
-		return ValueStr(a.data.(string) + b.data.(string))
-	}
-	if a.Type == TypeInt && b.Type == TypeInt {
-		return ValueInt(a.data.(int64) + b.data.(int64))
-	}
-	if (a.Type == TypeInt || a.Type == TypeFloat) && (b.Type == TypeInt || b.Type == TypeFloat) {
-		return ValueFloat(asFloat(a) + asFloat(b))
-	}
-	panic(fmt.Sprintf("runtime error: cannot add %s and %s", typeName(a), typeName(b)))
+// Engine holds the runtime configuration shared by all builtins. Generated
+// programs use DefaultEngine; embedders configure it before running code.
+type Engine struct {
+	out      *bufio.Writer
+	dest     io.Writer
+	meter    *outputMeter // wraps dest
+	errOut   io.Writer
+	captures []*bytes.Buffer
+	logger   *slog.Logger
+	loc      SourceLocation
+	pooling  bool
+
+	// Execution limits; limited is set when any of them is active, or
+	// metrics need the step count, or tasks have timeouts (see
+	// updateLimited), so the unlimited fast path costs a single branch.
+	limits  Limits
+	ctx     context.Context
+	limited bool
+	steps   int64
+	memory  int64
+
+	// Strings masked in output; see Redact.
+	redacted []string
+	redactor *strings.Replacer
+
+	// Capability sandbox; see SetSandbox.
+	sandboxed bool
+	granted   map[Capability]bool
+
+	// Outgoing mail; see SetMail.
+	mail *MailConfig
+
+	// Strict coercions and checked arithmetic; see SetStrict and
+	// SetCheckedArithmetic.
+	strict  bool
+	checked bool
+
+	// Integer division and modulo rounding; see SetDivision.
+	division DivisionMode
+
+	// Deterministic mode; see SetDeterministic.
+	deterministic *DeterministicConfig
+	rng           *rand.Rand
+
+	// Audit hook; see SetAuditSink.
+	audit func(AuditEvent)
+
+	// Connection to the host when running as an isolated child; see
+	// RunIsolated.
+	remote *isolatedConn
+
+	// IL call stack; see coreilEnter. callers[i] is the location to
+	// restore when calls[i] returns.
+	calls    []string
+	callers  []SourceLocation
+	maxDepth int
+
+	// Locations that coreilLoc markers index; see SetSourceLocations.
+	locations []SourceLocation
+
+	// Where the error being unwound was raised, recorded by the innermost
+	// coreilLeave so the uncaught error report can name it; see
+	// errorReport.
+	unwinding  bool
+	errLoc     SourceLocation
+	errCalls   []string
+	errCallers []SourceLocation
+
+	// Attached debugger and DAP client; see SetDebugger and ServeDAP.
+	debugger *Debugger
+	dap      *dapSession
+
+	// Watchpoints; see WatchVariable and WatchValue. watching is set when
+	// any exist so unwatched writes cost a single branch.
+	watching     bool
+	varWatches   map[string]func(WatchEvent)
+	valueWatches map[interface{}]valueWatch // keyed by container data pointer
+	varValues    map[watchSlot]Value        // last value per frame depth
+
+	// Execution count per location; see EnableCoverage.
+	coverage []int64
+	// Statement trace written for COREIL_STEP_TRACE; see stepTrace.
+	stepTrace *stepTrace
+	// Printed values written for COREIL_DISPLAY; see displayLog.
+	display *displayLog
+
+	// Profiling; see StartProfile. profileLabels caches the pprof label
+	// context of each IL function and is non-nil while profiling.
+	profileLabels map[string]context.Context
+	profileAllocs io.Writer
+	profileFiles  []*os.File
+
+	// Post-mortem heap snapshot; see coreilSnapshotOnError.
+	snapshotPath string
+	postMortem   *HeapSnapshot
+
+	// Record and replay of ExternalCalls; see Record and Replay.
+	recorder  *json.Encoder
+	recordOut bytes.Buffer // os.system output of the call being recorded
+	replay    []traceEntry
+	replayed  int
+	replaying bool
+
+	// The seed of the random.* PRNG when a trace or checkpoint records it;
+	// see runSeed.
+	seed   int64
+	seeded bool
+
+	// Checkpoints; see SetCheckpointing and Restore. journal holds every
+	// recorded ExternalCall of the run, and restore the checkpoint being
+	// restored until the run reaches its step, with output discarded
+	// meanwhile (restoreOut is where it goes afterwards).
+	checkpoints       *CheckpointConfig
+	journal           []traceEntry
+	globals           map[string]*Value // see coreilGlobals
+	generators        []*Generator      // created since checkpoints were enabled
+	restore           *checkpointState
+	restoreOut        io.Writer
+	checkpointing     bool // taking one, so IL code it runs does not take another
+	checkpointWanted  atomic.Bool
+	checkpointMu      sync.Mutex
+	checkpointWaiters []chan checkpointReply
+	checkpointPath    string // see coreilCheckpoint
+
+	// Tracing; see SetTracing. callStarts[i] is when calls[i] was entered.
+	tracing     *TraceConfig
+	programHash string
+	runCtx      context.Context
+	runSpan     Span
+	callStarts  []time.Time
+
+	// Concurrency; see spawn. Once a task has been spawned, IL code only
+	// runs on the goroutine holding gil, whose task state the engine holds.
+	gil        sync.Mutex
+	concurrent bool
+	aborted    interface{}     // limit or cancellation raised by a task
+	taskCtx    context.Context // deadline of the running pool task
+	timedTasks bool            // set once a pool with a timeout exists
+	events     *EventBus       // the bus Publish feeds
+
+	// Caches of memoized function definitions, by name; see newMemo.
+	memos map[string]*Memo
+
+	// Metrics; see SetMetrics. runStart is when the current run began.
+	metrics      MetricsSink
+	metricLabels map[string]string
+	runStart     time.Time
+
+	// English frontend; see SetFrontend.
+	frontend Frontend
 }
 
-func valueSubtract(a, b Value) Value {
-	if a.Type == TypeInt && b.Type == TypeInt {
-		return ValueInt(a.data.(int64) - b.data.(int64))
-	}
-	if (a.Type == TypeInt || a.Type == TypeFloat) && (b.Type == TypeInt || b.Type == TypeFloat) {
-		return ValueFloat(asFloat(a) - asFloat(b))
+// SourceLocation identifies the IL statement being executed and, when the
+// frontend provided it, the English sentence it was compiled from: its text,
+// 1-based position among the program's sentences, and the line and column
+// it starts at.
+type SourceLocation struct {
+	IL         string
+	Sentence   string
+	SentenceID int
+	Line       int
+	Column     int
+}
+
+func (l SourceLocation) String() string {
+	switch {
+	case l.IL == "" && l.Sentence == "":
+		return ""
+	case l.Sentence == "":
+		return l.IL
+	case l.SentenceID == 0 && l.Line == 0:
+		return fmt.Sprintf("%s (%s)", l.IL, reprString(l.Sentence))
+	default:
+		return fmt.Sprintf("%s (%s, %s)", l.IL, l.position(), reprString(l.Sentence))
 	}
-	panic(fmt.Sprintf("runtime error: cannot subtract %s and %s", typeName(a), typeName(b)))
 }
 
-func valueMultiply(a, b Value) Value {
-	if a.Type == TypeInt && b.Type == TypeInt {
-		return ValueInt(a.data.(int64) * b.data.(int64))
+// position describes where the sentence is in the English source, e.g.
+// "sentence 12, line 7:3".
+func (l SourceLocation) position() string {
+	var parts []string
+	if l.SentenceID > 0 {
+		parts = append(parts, fmt.Sprintf("sentence %d", l.SentenceID))
 	}
-	if (a.Type == TypeInt || a.Type == TypeFloat) && (b.Type == TypeInt || b.Type == TypeFloat) {
-		return ValueFloat(asFloat(a) * asFloat(b))
+	if l.Line > 0 {
+		parts = append(parts, fmt.Sprintf("line %d:%d", l.Line, l.Column))
 	}
-	panic(fmt.Sprintf("runtime error: cannot multiply %s and %s", typeName(a), typeName(b)))
+	return strings.Join(parts, ", ")
 }
 
-func valueDivide(a, b Value) Value {
-	if a.Type == TypeInt && b.Type == TypeInt {
-		bv := b.data.(int64)
-		if bv == 0 {
-			panic("runtime error: division by zero")
-		}
-		return ValueInt(a.data.(int64) / bv)
+// describe renders the location for error reports, leading with the
+// English sentence when there is one.
+func (l SourceLocation) describe() string {
+	if l.Sentence == "" {
+		return "at " + l.IL
 	}
-	if (a.Type == TypeInt || a.Type == TypeFloat) && (b.Type == TypeInt || b.Type == TypeFloat) {
-		bv := asFloat(b)
-		if bv == 0 {
-			panic("runtime error: division by zero")
-		}
-		return ValueFloat(asFloat(a) / bv)
+	msg := "while running " + reprString(l.Sentence)
+	if pos := l.position(); pos != "" {
+		msg += " (" + pos + ")"
 	}
-	panic(fmt.Sprintf("runtime error: cannot divide %s by %s", typeName(a), typeName(b)))
+	return msg
 }
 
-func valueModulo(a, b Value) Value {
-	if a.Type == TypeInt && b.Type == TypeInt {
-		bv := b.data.(int64)
-		if bv == 0 {
-			panic("runtime error: modulo by zero")
-		}
-		result := a.data.(int64) % bv
-		// Python-style modulo (result has same sign as divisor)
-		if result != 0 && (result < 0) != (bv < 0) {
-			result += bv
-		}
-		return ValueInt(result)
+// NewEngine returns an engine writing buffered output to os.Stdout and
+// unbuffered error output to os.Stderr.
+func NewEngine() *Engine {
+	meter := &outputMeter{w: os.Stdout}
+	return &Engine{
+		out:    bufio.NewWriter(meter),
+		dest:   os.Stdout,
+		meter:  meter,
+		errOut: os.Stderr,
+		logger: slog.New(slog.NewTextHandler(os.Stderr, nil)),
+		rng:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		// Matches the reference interpreter's recursion limit.
+		maxDepth: 1000,
+		events:   newEventBus(),
+		// Every capability is denied until granted; see SetSandbox.
+		sandboxed: true,
 	}
-	if (a.Type == TypeInt || a.Type == TypeFloat) && (b.Type == TypeInt || b.Type == TypeFloat) {
-		bv := asFloat(b)
-		if bv == 0 {
-			panic("runtime error: modulo by zero")
-		}
-		return ValueFloat(math.Mod(asFloat(a), bv))
-	}
-	panic(fmt.Sprintf("runtime error: cannot modulo %s and %s", typeName(a), typeName(b)))
 }
 
-func valueEqual(a, b Value) bool {
-	if a.Type != b.Type {
-		// Allow int/float comparison
-		if (a.Type == TypeInt || a.Type == TypeFloat) && (b.Type == TypeInt || b.Type == TypeFloat) {
-			return asFloat(a) == asFloat(b)
-		}
-		return false
-	}
-	switch a.Type {
-	case TypeNone:
-		return true
-	case TypeInt:
-		return a.data.(int64) == b.data.(int64)
-	case TypeFloat:
-		return a.data.(float64) == b.data.(float64)
-	case TypeBool:
-		return a.data.(bool) == b.data.(bool)
-	case TypeStr:
-		return a.data.(string) == b.data.(string)
-	case TypeArray:
-		aa, ba := *a.data.(*[]Value), *b.data.(*[]Value)
-		if len(aa) != len(ba) {
-			return false
-		}
-		for i := range aa {
-			if !valueEqual(aa[i], ba[i]) {
-				return false
-			}
-		}
-		return true
-	default:
-		return false
-	}
+var DefaultEngine = NewEngine()
+
+// SetOutput flushes pending output and redirects program output to w.
+func (e *Engine) SetOutput(w io.Writer) {
+	e.Flush()
+	e.dest = w
+	e.meter = &outputMeter{w: w}
+	e.out = bufio.NewWriter(e.meter)
 }
 
-func valueLessThan(a, b Value) bool {
-	if a.Type == TypeInt && b.Type == TypeInt {
-		return a.data.(int64) < b.data.(int64)
-	}
-	if (a.Type == TypeInt || a.Type == TypeFloat) && (b.Type == TypeInt || b.Type == TypeFloat) {
-		return asFloat(a) < asFloat(b)
-	}
-	if a.Type == TypeStr && b.Type == TypeStr {
-		return a.data.(string) < b.data.(string)
-	}
-	panic(fmt.Sprintf("runtime error: cannot compare %s and %s", typeName(a), typeName(b)))
+// SetErrorOutput redirects output printed to the stderr target.
+func (e *Engine) SetErrorOutput(w io.Writer) {
+	e.errOut = w
 }
 
-func valueLessThanOrEqual(a, b Value) bool {
-	return valueLessThan(a, b) || valueEqual(a, b)
+// SetLogger replaces the logger used by the log* builtins.
+func (e *Engine) SetLogger(l *slog.Logger) {
+	e.logger = l
 }
 
-func valueGreaterThan(a, b Value) bool {
-	return !valueLessThanOrEqual(a, b)
+// Output returns the writer program output is currently sent to.
+func (e *Engine) Output() io.Writer {
+	return e.dest
 }
 
-func valueGreaterThanOrEqual(a, b Value) bool {
-	return !valueLessThan(a, b)
+// SetBufferPooling enables reuse of temporary []Value and []string buffers
+// (formatting and hashing parts) through sync.Pool. It trades a little
+// bookkeeping for less GC work in allocation-heavy programs. Buffers that
+// reach program code, such as function arguments, are never pooled. Set it
+// before running code.
+func (e *Engine) SetBufferPooling(on bool) {
+	e.pooling = on
 }
 
-func logicalNot(v Value) Value {
-	return ValueBool(!isTruthy(v))
+// Limits bounds the resources a program may use. Zero fields are unlimited.
+type Limits struct {
+	// MaxSteps bounds loop iterations plus function calls.
+	MaxSteps int64
+	// MaxMemory bounds the approximate bytes allocated for container items
+	// and string concatenation over the whole run.
+	MaxMemory int64
+	// MaxCollectionSize bounds the items in any array, map, set, deque or heap.
+	MaxCollectionSize int
 }
 
-// ============================================================================
-// Array operations
-// ============================================================================
+// LimitExceeded is raised when a program hits an execution limit. Unlike
+// runtime errors it cannot be caught by TryCatch or tryCall.
+type LimitExceeded struct {
+	Limit string // "steps", "memory", "collection size" or "timeout"
+	Max   int64
+	Loc   SourceLocation
+	Err   error // context error for timeouts
+}
 
-func arrayIndex(base, index Value) Value {
-	arr := asArray(base)
-	idx := asInt(index)
-	length := int64(len(*arr))
-	if idx < 0 {
-		idx += length
+func (l *LimitExceeded) Error() string {
+	msg := fmt.Sprintf("limit exceeded: %s (max %d)", l.Limit, l.Max)
+	if l.Err != nil {
+		msg = fmt.Sprintf("limit exceeded: %s (%s)", l.Limit, l.Err)
 	}
-	if idx < 0 || idx >= length {
-		panic(fmt.Sprintf("runtime error: index %d out of range for array of length %d", idx, length))
+	if loc := l.Loc.String(); loc != "" {
+		msg += " at " + loc
 	}
-	return (*arr)[idx]
+	return msg
 }
 
-func arraySetIndex(base, index, value Value) {
-	arr := asArray(base)
-	idx := asInt(index)
-	length := int64(len(*arr))
-	if idx < 0 {
-		idx += length
-	}
-	if idx < 0 || idx >= length {
-		panic(fmt.Sprintf("runtime error: index %d out of range for array of length %d", idx, length))
+// Cancelled is raised when the engine's context is cancelled. Like
+// LimitExceeded it cannot be caught by TryCatch or tryCall.
+type Cancelled struct {
+	Err    error
+	Loc    SourceLocation
+	Output OutputSummary // what the program had written when it stopped
+}
+
+func (c *Cancelled) Error() string {
+	msg := fmt.Sprintf("cancelled (%s) after %s", c.Err, c.Output)
+	if loc := c.Loc.String(); loc != "" {
+		msg += " at " + loc
 	}
-	(*arr)[idx] = value
+	return msg
 }
 
-func arrayLength(base Value) Value {
-	arr := asArray(base)
-	return ValueInt(int64(len(*arr)))
+// OutputSummary describes the program output written during a run.
+type OutputSummary struct {
+	Bytes int64
+	Lines int64
+	Tail  string // up to the last outputTailSize bytes
 }
 
-func arrayPush(base, value Value) {
-	arr := asArray(base)
-	*arr = append(*arr, value)
+func (o OutputSummary) String() string {
+	msg := fmt.Sprintf("%d bytes (%d lines) of output", o.Bytes, o.Lines)
+	if o.Tail != "" {
+		msg += ", ending " + reprString(o.Tail)
+	}
+	return msg
 }
 
-func arraySlice(base, start, end Value) Value {
-	arr := asArray(base)
-	length := int64(len(*arr))
-	s := asInt(start)
-	e := asInt(end)
+const outputTailSize = 256
 
-	// Handle negative indices
-	if s < 0 {
-		s += length
-	}
-	if e < 0 {
-		e += length
-	}
+// outputMeter counts the bytes and lines written to the engine's output and
+// keeps the most recent ones for OutputSummary.
+type outputMeter struct {
+	w     io.Writer
+	bytes int64
+	lines int64
+	tail  []byte
+}
 
-	// Clamp to bounds
-	if s < 0 {
-		s = 0
-	}
-	if e > length {
-		e = length
-	}
-	if s > length {
-		s = length
-	}
-	if e < s {
-		return ValueArray(nil)
+func (m *outputMeter) Write(p []byte) (int, error) {
+	n, err := m.w.Write(p)
+	m.bytes += int64(n)
+	m.lines += int64(bytes.Count(p[:n], []byte{'\n'}))
+	m.tail = append(m.tail, p[:n]...)
+	if len(m.tail) > outputTailSize {
+		m.tail = append(m.tail[:0], m.tail[len(m.tail)-outputTailSize:]...)
 	}
+	return n, err
+}
 
-	result := make([]Value, e-s)
-	copy(result, (*arr)[s:e])
-	return ValueArray(result)
+func (m *outputMeter) reset() {
+	m.bytes, m.lines, m.tail = 0, 0, nil
 }
 
-// ============================================================================
-// Map operations
-// ============================================================================
+func (m *outputMeter) summary() OutputSummary {
+	return OutputSummary{Bytes: m.bytes, Lines: m.lines, Tail: string(m.tail)}
+}
 
-func mapGet(base, key Value) Value {
-	m := asMap(base)
-	k := asString(key)
-	v, ok := m.Get(k)
-	if !ok {
-		panic(fmt.Sprintf("runtime error: key '%s' not found", k))
-	}
-	return v
+// SetLimits configures execution limits for subsequent runs.
+func (e *Engine) SetLimits(l Limits) {
+	e.limits = l
+	e.updateLimited()
 }
 
-func mapGetDefault(base, key, defaultVal Value) Value {
-	m := asMap(base)
-	k := asString(key)
-	v, ok := m.Get(k)
-	if !ok {
-		return defaultVal
-	}
-	return v
+// updateLimited recomputes whether coreilStep has work to do.
+func (e *Engine) updateLimited() {
+	e.limited = e.limits != (Limits{}) || e.ctx != nil || e.metrics != nil || e.timedTasks || e.checkpoints != nil
 }
 
-func mapSet(base, key, value Value) {
-	m := asMap(base)
-	k := asString(key)
-	m.Set(k, value)
+// SetContext stops execution once ctx is done: with a "timeout"
+// LimitExceeded when its deadline passes, or Cancelled when it is cancelled.
+// The context is checked at loop back-edges and function entry, and by
+// ExternalCalls, which also abort a sleep, HTTP request or subprocess in
+// flight. A nil ctx removes the check.
+func (e *Engine) SetContext(ctx context.Context) {
+	e.ctx = ctx
+	e.updateLimited()
 }
 
-func mapKeys(base Value) Value {
-	m := asMap(base)
-	keys := m.Keys()
-	result := make([]Value, len(keys))
-	for i, k := range keys {
-		result[i] = ValueStr(k)
+// Run executes fn (typically a compiled program's body) under the engine's
+// limits, resetting the step, memory and output counters first. Output is
+// flushed, and a limit violation or cancellation is returned as a
+// *LimitExceeded or *Cancelled; other panics propagate.
+func (e *Engine) Run(fn func()) (err error) {
+	e.steps, e.memory = 0, 0
+	e.calls, e.callers = e.calls[:0], e.callers[:0]
+	e.callStarts = e.callStarts[:0]
+	e.unwinding = false
+	if e.debugger != nil {
+		e.debugger.frames = e.debugger.frames[:1]
 	}
-	return ValueArray(result)
+	e.meter.reset()
+	e.beginRun()
+	defer func() {
+		r := recover()
+		e.Flush()
+		e.endRun(r)
+		switch r := r.(type) {
+		case nil:
+		case *LimitExceeded:
+			err = r
+		case *Cancelled:
+			err = r
+		default:
+			panic(r)
+		}
+	}()
+	fn()
+	return nil
 }
 
-// ============================================================================
-// Record operations
-// ============================================================================
-
-func recordGetField(base Value, name string) Value {
-	r := asRecord(base)
-	v, ok := r.fields[name]
-	if !ok {
-		panic(fmt.Sprintf("runtime error: field '%s' not found", name))
+// beginRun starts the span and clock of a run; see SetTracing and
+// SetMetrics.
+func (e *Engine) beginRun() {
+	e.runStart = time.Now()
+	e.startRunSpan()
+	if e.restore != nil && e.restoreOut == nil {
+		// Output up to the checkpoint was written by the run that took it
+		e.Flush()
+		e.restoreOut, e.meter.w = e.meter.w, io.Discard
 	}
-	return v
 }
 
-func recordSetField(base Value, name string, value Value) {
-	r := asRecord(base)
-	if _, ok := r.fields[name]; !ok {
-		r.order = append(r.order, name)
+// endRun ends the run begun by beginRun, which ended with r (nil on
+// success).
+func (e *Engine) endRun(r interface{}) {
+	e.answerCheckpoints(nil, errors.New("the program ended before its next step"))
+	e.finishRestore()
+	e.endRunSpan(r)
+	if e.metrics != nil && !e.runStart.IsZero() {
+		e.reportRun(r)
 	}
-	r.fields[name] = value
+	e.runStart = time.Time{}
 }
 
-// ============================================================================
-// String operations
-// ============================================================================
-
-func stringLength(base Value) Value {
-	s := asString(base)
-	return ValueInt(int64(len(s)))
+func (e *Engine) exceed(limit string, max int64) {
+	panic(&LimitExceeded{Limit: limit, Max: max, Loc: e.loc})
 }
 
-func stringSubstring(base, start, end Value) Value {
-	s := asString(base)
-	si := int(asInt(start))
-	ei := int(asInt(end))
-	if si < 0 {
-		si = 0
+// checkContext stops execution if the engine's context is done, and
+// raises a catchable timeout error in a pool task that has run too long.
+func (e *Engine) checkContext() {
+	if e.taskCtx != nil && e.taskCtx.Err() != nil && (e.ctx == nil || e.ctx.Err() == nil) {
+		panic(runtimeError(KindTimeoutError, "task timed out"))
 	}
-	if ei > len(s) {
-		ei = len(s)
+	if e.ctx == nil {
+		return
 	}
-	if si > ei {
-		return ValueStr("")
+	if err := e.ctx.Err(); err != nil {
+		panic(e.contextError(err))
 	}
-	return ValueStr(s[si:ei])
 }
 
-func stringCharAt(base, index Value) Value {
-	s := asString(base)
-	idx := int(asInt(index))
-	if idx < 0 || idx >= len(s) {
-		panic(fmt.Sprintf("runtime error: string index %d out of range", idx))
+// contextError converts a context error into a *LimitExceeded for a
+// deadline or a *Cancelled otherwise.
+func (e *Engine) contextError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &LimitExceeded{Limit: "timeout", Loc: e.loc, Err: err}
 	}
-	return ValueStr(string(s[idx]))
+	e.Flush()
+	return &Cancelled{Err: err, Loc: e.loc, Output: e.meter.summary()}
 }
 
-func stringJoin(sep, items Value) Value {
-	s := asString(sep)
-	arr := asArray(items)
-	parts := make([]string, len(*arr))
-	for i, item := range *arr {
-		parts[i] = formatValue(item)
+// context returns the running pool task's context, the engine's context,
+// or a background context if none was set.
+func (e *Engine) context() context.Context {
+	if e.taskCtx != nil {
+		return e.taskCtx
 	}
-	return ValueStr(strings.Join(parts, s))
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
 }
 
-func stringSplit(base, delimiter Value) Value {
-	s := asString(base)
-	d := asString(delimiter)
-	parts := strings.Split(s, d)
-	result := make([]Value, len(parts))
-	for i, p := range parts {
-		result[i] = ValueStr(p)
+// Flush writes any buffered program output.
+func (e *Engine) Flush() {
+	if err := e.out.Flush(); err != nil {
+		panic(runtimeError(KindIOError, "cannot write output: %s", err))
 	}
-	return ValueArray(result)
 }
 
-func stringTrim(base Value) Value {
-	return ValueStr(strings.TrimSpace(asString(base)))
+// StartCapture collects subsequent output in memory until StopCapture.
+// Captures nest: each StopCapture returns the output since its StartCapture.
+func (e *Engine) StartCapture() {
+	e.Flush()
+	buf := &bytes.Buffer{}
+	e.captures = append(e.captures, buf)
+	e.out = bufio.NewWriter(buf)
 }
 
-func stringUpper(base Value) Value {
-	return ValueStr(strings.ToUpper(asString(base)))
+// StopCapture ends the innermost capture and returns what it collected.
+func (e *Engine) StopCapture() string {
+	if len(e.captures) == 0 {
+		panic(runtimeError(KindRuntimeError, "StopCapture without StartCapture"))
+	}
+	e.Flush()
+	buf := e.captures[len(e.captures)-1]
+	e.captures = e.captures[:len(e.captures)-1]
+	if len(e.captures) > 0 {
+		e.out = bufio.NewWriter(e.captures[len(e.captures)-1])
+	} else {
+		e.out = bufio.NewWriter(e.meter)
+	}
+	return buf.String()
 }
 
-func stringLower(base Value) Value {
-	return ValueStr(strings.ToLower(asString(base)))
+// coreilAt records the current IL location; codegen emits it before
+// statements whose failures should be reported with source context.
+func coreilAt(il, sentence string) {
+	DefaultEngine.loc = SourceLocation{IL: il, Sentence: sentence}
 }
 
-func stringStartsWith(base, prefix Value) Value {
-	return ValueBool(strings.HasPrefix(asString(base), asString(prefix)))
+// SetSourceLocations registers the table of locations that coreilLoc
+// markers refer to. Generated programs call it at the start of main with one
+// entry per top-level IL statement, followed by the nested statements that
+// markers (or coreilAudited) point at.
+func (e *Engine) SetSourceLocations(locs []SourceLocation) {
+	e.locations = locs
 }
 
-func stringEndsWith(base, suffix Value) Value {
-	return ValueBool(strings.HasSuffix(asString(base), asString(suffix)))
+// coreilLoc records that execution has reached the statement described by
+// the i'th registered location; codegen emits it before each top-level
+// statement and at the top of each function body.
+func coreilLoc(i int) {
+	e := DefaultEngine
+	if i < len(e.locations) {
+		e.loc = e.locations[i]
+	}
 }
 
-func stringContains(base, substring Value) Value {
-	return ValueBool(strings.Contains(asString(base), asString(substring)))
+// coreilAudited makes an audited call, fn, with the location set to entry i,
+// the nested statement that makes it, and then restores the location.
+// Builds without per-statement markers use it so audit events name the
+// statement rather than its top-level ancestor.
+func coreilAudited(i int, fn func() Value) Value {
+	e := DefaultEngine
+	loc := e.loc
+	defer func() { e.loc = loc }()
+	coreilLoc(i)
+	return fn()
 }
 
-func stringReplaceFn(base, old, new_ Value) Value {
-	return ValueStr(strings.ReplaceAll(asString(base), asString(old), asString(new_)))
+// SetMaxCallDepth bounds how many IL function calls may be active at once
+// (1000 by default, as in the reference interpreter). Exceeding it raises a
+// catchable "maximum recursion depth exceeded" runtime error naming the
+// function. 0 removes the bound, so runaway recursion ends in a fatal Go
+// stack overflow.
+func (e *Engine) SetMaxCallDepth(n int) {
+	e.maxDepth = n
 }
 
-// ============================================================================
-// Set operations
-// ============================================================================
+// CallStack returns the names of the active IL functions, outermost first.
+func (e *Engine) CallStack() []string {
+	return append([]string(nil), e.calls...)
+}
 
-func setHas(base, value Value) Value {
-	s := asSet(base)
-	_, ok := s.items[formatValue(value)]
-	return ValueBool(ok)
+// coreilEnter pushes an IL function onto the call stack; codegen emits it,
+// followed by a deferred coreilLeave, at the top of every function body.
+func coreilEnter(name string) {
+	e := DefaultEngine
+	if e.maxDepth > 0 && len(e.calls) >= e.maxDepth {
+		panic(runtimeError(KindRecursionError, "maximum recursion depth exceeded in %s (depth %d)", name, e.maxDepth))
+	}
+	e.calls = append(e.calls, name)
+	e.callers = append(e.callers, e.loc)
+	if e.debugger != nil {
+		e.debugger.enter(e)
+	}
+	if e.watching {
+		e.forgetVariables()
+	}
+	if e.profileLabels != nil {
+		e.profileFunction(name)
+	}
+	if e.tracing != nil {
+		e.callStarts = append(e.callStarts, time.Now())
+	}
 }
 
-func setAdd(base, value Value) {
-	s := asSet(base)
-	s.items[formatValue(value)] = value
+// coreilLeave pops the innermost frame and restores the caller's location.
+// When the frame is being unwound by a panic, the first coreilLeave to see
+// it records where the error was raised before passing it on.
+func coreilLeave() {
+	e := DefaultEngine
+	r := recover()
+	if r != nil && !e.unwinding {
+		e.unwinding = true
+		e.errLoc = e.loc
+		e.errCalls = append(e.errCalls[:0], e.calls...)
+		e.errCallers = append(e.errCallers[:0], e.callers...)
+		if e.snapshotPath != "" {
+			e.postMortem = e.Snapshot()
+		}
+	}
+	n := len(e.calls) - 1
+	if e.tracing != nil && n < len(e.callStarts) {
+		e.traceCall(e.calls[n], n, e.callStarts[n], r)
+		e.callStarts = e.callStarts[:n]
+	}
+	e.loc = e.callers[n]
+	e.calls, e.callers = e.calls[:n], e.callers[:n]
+	if e.debugger != nil {
+		e.debugger.leave(e)
+	}
+	if e.profileLabels != nil {
+		e.profileFunction(e.innermostFunction())
+	}
+	if r != nil {
+		panic(r)
+	}
 }
 
-func setRemove(base, value Value) {
-	s := asSet(base)
-	delete(s.items, formatValue(value))
+// coreilTail renames the innermost frame when a tail call within a group of
+// mutually recursive functions reuses it.
+func coreilTail(name string) {
+	e := DefaultEngine
+	e.calls[len(e.calls)-1] = name
+	if e.debugger != nil {
+		e.debugger.tail(e)
+	}
+	if e.profileLabels != nil {
+		e.profileFunction(name)
+	}
 }
 
-func setSize(base Value) Value {
-	s := asSet(base)
-	return ValueInt(int64(len(s.items)))
+// coreilStep counts one unit of execution fuel; codegen emits it at the top
+// of every loop iteration and function body. The context is polled every
+// 256 steps.
+func coreilStep() {
+	e := DefaultEngine
+	if !e.limited {
+		return
+	}
+	e.steps++
+	if e.limits.MaxSteps > 0 && e.steps > e.limits.MaxSteps {
+		e.exceed("steps", e.limits.MaxSteps)
+	}
+	if e.steps&255 == 0 {
+		e.checkContext()
+	}
+	if e.checkpoints != nil {
+		e.checkpointStep()
+	}
 }
 
-// ============================================================================
-// Deque operations
-// ============================================================================
+// valueBytes approximates the memory held by one Value slot.
+const valueBytes = 32
 
-func dequeSize(base Value) Value {
-	d := asDeque(base)
-	return ValueInt(int64(len(d.items)))
+// trackGrowth charges bytes against the memory limit and checks that a
+// collection now holding size items is within the collection size limit.
+func trackGrowth(size int, bytes int64) {
+	e := DefaultEngine
+	if !e.limited {
+		return
+	}
+	e.memory += bytes
+	if e.limits.MaxMemory > 0 && e.memory > e.limits.MaxMemory {
+		e.exceed("memory", e.limits.MaxMemory)
+	}
+	if e.limits.MaxCollectionSize > 0 && size > e.limits.MaxCollectionSize {
+		e.exceed("collection size", int64(e.limits.MaxCollectionSize))
+	}
 }
 
-func dequePushBack(base, value Value) {
-	d := asDeque(base)
-	d.items = append(d.items, value)
+// rethrowLimit re-raises execution limit and cancellation errors from
+// recover sites that would otherwise turn them into catchable runtime
+// errors. Any other error is handled by the caller, so it no longer has a
+// location to report.
+func rethrowLimit(r interface{}) {
+	switch r.(type) {
+	case *LimitExceeded, *Cancelled:
+		panic(r)
+	}
+	DefaultEngine.unwinding = false
+	if DefaultEngine.metrics != nil {
+		DefaultEngine.countError(r, true)
+	}
 }
 
-func dequePushFront(base, value Value) {
-	d := asDeque(base)
-	d.items = append([]Value{value}, d.items...)
+// coreilFlush is deferred by generated main functions so buffered output is
+// written even when the program ends with an uncaught error, which is then
+// reported on stderr (see errorReport) with exit status 1, or 3 if it was
+// an execution limit.
+func coreilFlush() {
+	e := DefaultEngine
+	r := recover()
+	e.Flush()
+	e.endRun(r)
+	if r == nil {
+		e.finishReplay()
+		e.removeCheckpoint()
+		e.saveCoverage()
+		e.saveProfile()
+		if e.dap != nil {
+			e.dap.finish(0)
+		}
+		return
+	}
+	fmt.Fprintln(e.errOut, e.redact(e.errorReport(r)))
+	e.finishReplay()
+	e.saveCoverage()
+	e.saveProfile()
+	e.savePostMortem()
+	if e.dap != nil {
+		e.dap.finish(1)
+	}
+	if _, limit := r.(*LimitExceeded); limit {
+		os.Exit(3)
+	}
+	os.Exit(1)
 }
 
-func dequePopFront(base Value) Value {
-	d := asDeque(base)
-	if len(d.items) == 0 {
-		panic("runtime error: deque is empty")
+// errorReport describes an uncaught error: its message, the statement (and
+// English sentence) being executed when it was raised, and the IL functions
+// that were active, innermost first, with the statements that called them.
+//
+//	runtime error: division by zero — while running 'Print the average.' (sentence 4, line 3:1)
+//	  in average, called while running 'Print the average of the scores.' (sentence 7, line 5:1)
+func (e *Engine) errorReport(r interface{}) string {
+	switch r := r.(type) {
+	case *LimitExceeded:
+		return r.Error()
+	case *Cancelled:
+		return r.Error()
 	}
-	v := d.items[0]
-	d.items = d.items[1:]
-	return v
+	loc, calls, callers := e.loc, e.calls, e.callers
+	if e.unwinding {
+		loc, calls, callers = e.errLoc, e.errCalls, e.errCallers
+	}
+	var b strings.Builder
+	b.WriteString(fmt.Sprint(r))
+	if loc.IL != "" {
+		b.WriteString(" — " + loc.describe())
+	}
+	for i := len(calls) - 1; i >= 0; i-- {
+		b.WriteString("\n  in " + calls[i])
+		if callers[i].IL != "" {
+			b.WriteString(", called " + callers[i].describe())
+		}
+	}
+	return b.String()
 }
 
-func dequePopBack(base Value) Value {
-	d := asDeque(base)
-	if len(d.items) == 0 {
-		panic("runtime error: deque is empty")
+// ============================================================================
+// Truthiness
+// ============================================================================
+
+func isTruthy(v Value) bool {
+	switch v.Type {
+	case TypeNone:
+		return false
+	case TypeBool:
+		return v.boolData()
+	case TypeInt:
+		return v.intData() != 0
+	case TypeFloat:
+		return v.floatData() != 0
+	case TypeStr:
+		return v.data.(string) != ""
+	case TypeArray:
+		return len(v.data.(*Array).items) > 0
+	case TypeMap:
+		return len(v.data.(*OrderedMap).keys) > 0
+	case TypeTuple:
+		return len(v.data.([]Value)) > 0
+	case TypeSet:
+		return len(v.data.(*ValueSet).items) > 0
+	case TypeDeque:
+		return len(v.data.(*Deque).items) > 0
+	case TypeHeap:
+		return v.data.(*MinHeap).Len() > 0
+	case TypeTable:
+		return v.data.(*Table).rows > 0
+	case TypeRecord:
+		// Plain records behave like the interpreter's dicts; class instances
+		// are truthy unless they define __bool__.
+		r := v.data.(*Record)
+		if r.class == nil {
+			return len(r.order) > 0
+		}
+		if hook, ok := classHook(v, "__bool__"); ok {
+			return isTruthy(callValue(hook, v))
+		}
+		return true
+	default:
+		return true
 	}
-	v := d.items[len(d.items)-1]
-	d.items = d.items[:len(d.items)-1]
-	return v
 }
 
 // ============================================================================
-// Heap operations
+// Formatting / Printing
 // ============================================================================
 
-func heapSize(base Value) Value {
-	h := asHeap(base)
-	return ValueInt(int64(h.Len()))
+func formatValue(v Value) string {
+	switch v.Type {
+	case TypeNone:
+		return "None"
+	case TypeInt:
+		return strconv.FormatInt(v.intData(), 10)
+	case TypeFloat:
+		return formatFloat(v.floatData())
+	case TypeBool:
+		if v.boolData() {
+			return "True"
+		}
+		return "False"
+	case TypeStr:
+		return v.data.(string)
+	case TypeArray:
+		return "[" + joinValues(v.data.(*Array).items, reprValue, ", ") + "]"
+	case TypeTuple:
+		items := v.data.([]Value)
+		if len(items) == 1 {
+			return "(" + reprValue(items[0]) + ",)"
+		}
+		return "(" + joinValues(items, reprValue, ", ") + ")"
+	case TypeMap:
+		om := v.data.(*OrderedMap)
+		parts := make([]string, len(om.keys))
+		for i, k := range om.keys {
+			parts[i] = reprValue(om.keyValue(k)) + ": " + reprValue(om.values[k])
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	case TypeRecord:
+		r := v.data.(*Record)
+		if r.class != nil {
+			if str, ok := callStringHook(v, "__str__"); ok {
+				return str
+			}
+			if str, ok := callStringHook(v, "__repr__"); ok {
+				return str
+			}
+			return fmt.Sprintf("<%s object>", r.class.name)
+		}
+		parts := make([]string, len(r.order))
+		for i, name := range r.order {
+			parts[i] = fmt.Sprintf("%s=%s", name, reprValue(r.fields[name]))
+		}
+		return "Record(" + strings.Join(parts, ", ") + ")"
+	case TypeSet:
+		s := v.data.(*ValueSet)
+		keys := make([]string, 0, len(s.items))
+		for k := range s.items {
+			keys = append(keys, k)
+		}
+		if len(keys) == 0 {
+			return "set()"
+		}
+		sort.Strings(keys)
+		parts := make([]string, len(keys))
+		for i, k := range keys {
+			parts[i] = reprValue(s.items[k])
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	case TypeDeque:
+		// Python's deque repr, e.g. deque([3, 4, 5], maxlen=3)
+		d := v.data.(*Deque)
+		if d.bounded {
+			return fmt.Sprintf("deque([%s], maxlen=%d)", joinValues(d.items, reprValue, ", "), d.maxlen)
+		}
+		return "deque([" + joinValues(d.items, reprValue, ", ") + "])"
+	case TypeFunc:
+		return fmt.Sprintf("<function %s>", v.data.(*Function).name)
+	case TypeClass:
+		return fmt.Sprintf("<class '%s'>", v.data.(*Class).name)
+	case TypeVariant:
+		vr := v.data.(*Variant)
+		name := vr.enum.name + "." + vr.tag
+		if vr.enum.bare {
+			name = vr.tag
+		}
+		if vr.payload.Type == TypeNone {
+			return name
+		}
+		return fmt.Sprintf("%s(%s)", name, reprValue(vr.payload))
+	case TypeTable:
+		return formatTable(v.data.(*Table))
+	case TypeNode:
+		var buf strings.Builder
+		formatNode(v.data.(*Node), &buf)
+		return buf.String()
+	case TypeSecret:
+		return redactedText
+	default:
+		return fmt.Sprintf("<%s>", typeName(v))
+	}
 }
 
-func heapPeek(base Value) Value {
-	h := asHeap(base)
-	return h.Peek()
+// formatFloat matches Python's float repr: the shortest string that round-trips,
+// in positional notation for decimal exponents in [-4, 16) and scientific
+// notation (e.g. 1e+16, 1.5e-05) otherwise. Special values print as inf,
+// -inf and nan, and negative zero keeps its sign.
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "inf"
+	}
+	if math.IsInf(f, -1) {
+		return "-inf"
+	}
+	if math.IsNaN(f) {
+		return "nan"
+	}
+	sci := strconv.FormatFloat(f, 'e', -1, 64)
+	mantissa, expStr, _ := strings.Cut(sci, "e")
+	exp, _ := strconv.Atoi(expStr)
+	if exp < -4 || exp >= 16 {
+		return mantissa + "e" + expStr
+	}
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
 }
 
-func heapPush(base, priority, value Value) {
-	h := asHeap(base)
-	p := asFloat(priority)
-	h.Push(HeapItem{priority: p, value: value})
+func reprValue(v Value) string {
+	if v.Type == TypeStr {
+		return reprString(v.data.(string))
+	}
+	if v.Type == TypeRecord && v.data.(*Record).class != nil {
+		if str, ok := callStringHook(v, "__repr__"); ok {
+			return str
+		}
+		return fmt.Sprintf("<%s object>", v.data.(*Record).class.name)
+	}
+	return formatValue(v)
 }
 
-func heapPop(base Value) Value {
-	h := asHeap(base)
-	item := h.Pop()
-	return item.value
+// reprString quotes s the way Python's repr() does: single quotes unless the
+// string contains a single quote and no double quote, with backslash escapes
+// for the quote character, backslashes and non-printable characters.
+func reprString(s string) string {
+	quote := byte('\'')
+	if strings.ContainsRune(s, '\'') && !strings.ContainsRune(s, '"') {
+		quote = '"'
+	}
+	var buf strings.Builder
+	buf.WriteByte(quote)
+	for i, r := range s {
+		switch {
+		case r == utf8.RuneError && !strings.HasPrefix(s[i:], "\uFFFD"):
+			// Invalid UTF-8 byte
+			fmt.Fprintf(&buf, "\\x%02x", s[i])
+		case r == rune(quote) || r == '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case r == '\n':
+			buf.WriteString("\\n")
+		case r == '\r':
+			buf.WriteString("\\r")
+		case r == '\t':
+			buf.WriteString("\\t")
+		case strconv.IsPrint(r):
+			buf.WriteRune(r)
+		case r < 0x100:
+			fmt.Fprintf(&buf, "\\x%02x", r)
+		case r < 0x10000:
+			fmt.Fprintf(&buf, "\\u%04x", r)
+		default:
+			fmt.Fprintf(&buf, "\\U%08x", r)
+		}
+	}
+	buf.WriteByte(quote)
+	return buf.String()
+}
+
+// classHook returns the named special method of a class instance, if any.
+func classHook(v Value, name string) (Value, bool) {
+	if v.Type != TypeRecord || v.data.(*Record).class == nil {
+		return ValueNone, false
+	}
+	return v.data.(*Record).class.lookupMethod(name)
+}
+
+// callStringHook runs a __str__/__repr__ style hook, which must return a str.
+func callStringHook(v Value, name string) (string, bool) {
+	hook, ok := classHook(v, name)
+	if !ok {
+		return "", false
+	}
+	result := callValue(hook, v)
+	if result.Type != TypeStr {
+		panic(runtimeError(KindTypeError, "%s returned non-string (type %s)", name, typeName(result)))
+	}
+	return result.data.(string), true
+}
+
+func coreilPrint(args []Value) {
+	coreilPrintOpts(args, ValueNone, ValueNone, ValueNone)
+}
+
+// coreilPrintOpts mirrors Python's print(*args, sep=, end=, file=). sep and
+// end default to " " and "\n" when None; stream is None or "stdout" for
+// program output, or "stderr" for the engine's error output.
+func coreilPrintOpts(args []Value, sep, end, stream Value) {
+	sepStr := printOption("sep", sep, " ")
+	endStr := printOption("end", end, "\n")
+	text := DefaultEngine.redact(joinValues(args, formatValue, sepStr) + endStr)
+	target := "stdout"
+	if stream.Type != TypeNone {
+		target = asString(stream)
+	}
+	switch target {
+	case "stdout":
+		if DefaultEngine.display != nil && len(args) == 1 {
+			DefaultEngine.display.print(DefaultEngine, args[0], text)
+			return
+		}
+		DefaultEngine.out.WriteString(text)
+	case "stderr":
+		// Flush first so interleaved stdout/stderr output keeps its order.
+		DefaultEngine.Flush()
+		io.WriteString(DefaultEngine.errOut, text)
+	default:
+		panic(runtimeError(KindValueError, "unknown print target '%s'", target))
+	}
+}
+
+func printOption(name string, v Value, defaultVal string) string {
+	if v.Type == TypeNone {
+		return defaultVal
+	}
+	if v.Type != TypeStr {
+		panic(runtimeError(KindTypeError, "%s must be None or a string, not %s", name, typeName(v)))
+	}
+	return v.data.(string)
 }
 
 // ============================================================================
-// Math operations
+// Assertions
 // ============================================================================
 
-func mathSin(v Value) Value   { return ValueFloat(math.Sin(asFloat(v))) }
-func mathCos(v Value) Value   { return ValueFloat(math.Cos(asFloat(v))) }
-func mathTan(v Value) Value   { return ValueFloat(math.Tan(asFloat(v))) }
-func mathSqrt(v Value) Value  { return ValueFloat(math.Sqrt(asFloat(v))) }
-func mathFloor(v Value) Value { return ValueFloat(math.Floor(asFloat(v))) }
-func mathCeil(v Value) Value  { return ValueFloat(math.Ceil(asFloat(v))) }
-func mathLog(v Value) Value   { return ValueFloat(math.Log(asFloat(v))) }
-func mathExp(v Value) Value   { return ValueFloat(math.Exp(asFloat(v))) }
-func mathPow(base, exp Value) Value {
-	return ValueFloat(math.Pow(asFloat(base), asFloat(exp)))
+// coreilAssert raises an AssertionError when cond is falsy. The report shows
+// message, the actual and expected values (via reprValue) and the current
+// IL location and English sentence. Pass ValueNone for both actual and
+// expected to omit the value lines.
+func coreilAssert(cond, message, actual, expected Value) {
+	if isTruthy(cond) {
+		return
+	}
+	panic(assertionReport(message, actual, expected, actual.Type != TypeNone || expected.Type != TypeNone))
 }
 
-func mathAbs(v Value) Value {
+// coreilAssertEqual asserts valueEqual(actual, expected).
+func coreilAssertEqual(actual, expected, message Value) {
+	if valueEqual(actual, expected) {
+		return
+	}
+	panic(assertionReport(message, actual, expected, true))
+}
+
+func assertionReport(message, actual, expected Value, showValues bool) *CoreILError {
+	var b strings.Builder
+	b.WriteString("AssertionError")
+	if message.Type != TypeNone {
+		b.WriteString(": " + formatValue(message))
+	}
+	if showValues {
+		b.WriteString("\n  actual:   " + reprValue(actual))
+		b.WriteString("\n  expected: " + reprValue(expected))
+	}
+	if loc := DefaultEngine.loc.String(); loc != "" {
+		b.WriteString("\n  at " + loc)
+	}
+	return &CoreILError{Kind: KindAssertionError, Message: b.String()}
+}
+
+// ============================================================================
+// Test runner
+// ============================================================================
+
+// TestCase is an IL test function (a zero-argument function named test_*).
+type TestCase struct {
+	Name string
+	Fn   func() Value
+}
+
+// TestResult reports one test. Failure holds the error (for assertions, the
+// message with actual/expected values); Output is what the test printed.
+type TestResult struct {
+	Name    string
+	Passed  bool
+	Failure string
+	Output  string
+}
+
+// RunTests runs each case in order with its output captured, so a failing
+// test cannot stop the others.
+func RunTests(cases []TestCase) []TestResult {
+	results := make([]TestResult, 0, len(cases))
+	for _, tc := range cases {
+		results = append(results, runTest(tc))
+	}
+	return results
+}
+
+func runTest(tc TestCase) (result TestResult) {
+	result = TestResult{Name: tc.Name, Passed: true}
+	DefaultEngine.StartCapture()
+	defer func() {
+		if r := recover(); r != nil {
+			result.Passed = false
+			result.Failure = fmt.Sprintf("%v", r)
+			DefaultEngine.unwinding = false
+		}
+		result.Output = DefaultEngine.StopCapture()
+	}()
+	tc.Fn()
+	return result
+}
+
+// coreilRunTests is the entry point of programs compiled in test mode: it
+// prints a PASS/FAIL report and exits with status 1 if any test failed.
+func coreilRunTests(cases []TestCase) {
+	results := RunTests(cases)
+	w := DefaultEngine.out
+	failed := 0
+	for _, r := range results {
+		if r.Passed {
+			fmt.Fprintf(w, "PASS %s\n", r.Name)
+			continue
+		}
+		failed++
+		fmt.Fprintf(w, "FAIL %s\n", r.Name)
+		for _, line := range strings.Split(r.Failure, "\n") {
+			fmt.Fprintf(w, "    %s\n", line)
+		}
+		if r.Output != "" {
+			fmt.Fprintf(w, "    output:\n")
+			for _, line := range strings.Split(strings.TrimSuffix(r.Output, "\n"), "\n") {
+				fmt.Fprintf(w, "      %s\n", line)
+			}
+		}
+	}
+	fmt.Fprintf(w, "\n%d passed, %d failed\n", len(results)-failed, failed)
+	DefaultEngine.Flush()
+	DefaultEngine.saveCoverage()
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// ============================================================================
+// Logging
+// ============================================================================
+
+// Log records go to the engine's slog.Logger (text on stderr by default),
+// never to program output. fields is None or a map of structured attributes.
+func logDebug(msg, fields Value) { coreilLog(slog.LevelDebug, msg, fields) }
+func logInfo(msg, fields Value)  { coreilLog(slog.LevelInfo, msg, fields) }
+func logWarn(msg, fields Value)  { coreilLog(slog.LevelWarn, msg, fields) }
+func logError(msg, fields Value) { coreilLog(slog.LevelError, msg, fields) }
+
+func coreilLog(level slog.Level, msg, fields Value) {
+	var attrs []slog.Attr
+	if fields.Type != TypeNone {
+		om := asMap(fields)
+		for _, k := range om.keys {
+			attrs = append(attrs, logAttr(formatValue(om.keyValue(k)), om.values[k]))
+		}
+	}
+	DefaultEngine.logger.LogAttrs(context.Background(), level, DefaultEngine.redact(formatValue(msg)), attrs...)
+}
+
+func logAttr(key string, v Value) slog.Attr {
 	switch v.Type {
 	case TypeInt:
-		n := v.data.(int64)
-		if n < 0 {
-			return ValueInt(-n)
-		}
-		return v
+		return slog.Int64(key, v.intData())
 	case TypeFloat:
-		return ValueFloat(math.Abs(v.data.(float64)))
+		return slog.Float64(key, v.floatData())
+	case TypeBool:
+		return slog.Bool(key, v.boolData())
 	default:
-		panic(fmt.Sprintf("runtime error: abs requires a number, got %s", typeName(v)))
+		return slog.String(key, DefaultEngine.redact(formatValue(v)))
+	}
+}
+
+// ============================================================================
+// Arithmetic / Comparison
+// ============================================================================
+
+func valueAdd(a, b Value) Value {
+	if a.Type == TypeStr && b.Type == TypeStr {
+		return stringConcat(a.data.(string), b.data.(string))
+	}
+	if a.Type == TypeInt && b.Type == TypeInt {
+		if DefaultEngine.checked {
+			return ValueInt(checkedAdd(a.intData(), b.intData()))
+		}
+		return ValueInt(a.intData() + b.intData())
+	}
+	if (a.Type == TypeInt || a.Type == TypeFloat) && (b.Type == TypeInt || b.Type == TypeFloat) {
+		return ValueFloat(asFloat(a) + asFloat(b))
+	}
+	panic(runtimeError(KindTypeError, "cannot add %s and %s", typeName(a), typeName(b)))
+}
+
+// stringConcat is split out of valueAdd so profiles can tell string
+// concatenation apart from arithmetic.
+func stringConcat(a, b string) Value {
+	return ValueStr(concatStrings(a, b))
+}
+
+// concatStrings concatenates strings held unboxed in typed variables.
+func concatStrings(a, b string) string {
+	trackGrowth(0, int64(len(a)+len(b)))
+	return a + b
+}
+
+func valueSubtract(a, b Value) Value {
+	if a.Type == TypeInt && b.Type == TypeInt {
+		if DefaultEngine.checked {
+			return ValueInt(checkedSub(a.intData(), b.intData()))
+		}
+		return ValueInt(a.intData() - b.intData())
+	}
+	if (a.Type == TypeInt || a.Type == TypeFloat) && (b.Type == TypeInt || b.Type == TypeFloat) {
+		return ValueFloat(asFloat(a) - asFloat(b))
+	}
+	panic(runtimeError(KindTypeError, "cannot subtract %s and %s", typeName(a), typeName(b)))
+}
+
+func valueMultiply(a, b Value) Value {
+	if a.Type == TypeInt && b.Type == TypeInt {
+		if DefaultEngine.checked {
+			return ValueInt(checkedMul(a.intData(), b.intData()))
+		}
+		return ValueInt(a.intData() * b.intData())
+	}
+	if (a.Type == TypeInt || a.Type == TypeFloat) && (b.Type == TypeInt || b.Type == TypeFloat) {
+		return ValueFloat(asFloat(a) * asFloat(b))
+	}
+	panic(runtimeError(KindTypeError, "cannot multiply %s and %s", typeName(a), typeName(b)))
+}
+
+// valueDivide is true division: like Python 3, it always returns a float.
+func valueDivide(a, b Value) Value {
+	if (a.Type == TypeInt || a.Type == TypeFloat) && (b.Type == TypeInt || b.Type == TypeFloat) {
+		return ValueFloat(floatDivide(asFloat(a), asFloat(b)))
+	}
+	panic(runtimeError(KindTypeError, "cannot divide %s by %s", typeName(a), typeName(b)))
+}
+
+// valueModulo rounds as the program's DivisionMode says; see SetDivision.
+func valueModulo(a, b Value) Value {
+	if a.Type == TypeInt && b.Type == TypeInt {
+		return ValueInt(intModulo(a.intData(), b.intData()))
+	}
+	if (a.Type == TypeInt || a.Type == TypeFloat) && (b.Type == TypeInt || b.Type == TypeFloat) {
+		return ValueFloat(floatModulo(asFloat(a), asFloat(b)))
+	}
+	panic(runtimeError(KindTypeError, "cannot modulo %s and %s", typeName(a), typeName(b)))
+}
+
+// checkedAdd, checkedSub and checkedMul are int64 arithmetic that raises
+// OverflowError instead of wrapping around. Besides checked Values, they
+// serve unboxed int variables in programs compiled with checked=True.
+func checkedAdd(a, b int64) int64 {
+	c := a + b
+	if (a^c)&(b^c) < 0 {
+		panic(runtimeError(KindOverflowError, "integer overflow: %d + %d does not fit in 64 bits", a, b))
+	}
+	return c
+}
+
+func checkedSub(a, b int64) int64 {
+	c := a - b
+	if (a^b)&(a^c) < 0 {
+		panic(runtimeError(KindOverflowError, "integer overflow: %d - %d does not fit in 64 bits", a, b))
+	}
+	return c
+}
+
+func checkedMul(a, b int64) int64 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	c := a * b
+	if c/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		panic(runtimeError(KindOverflowError, "integer overflow: %d * %d does not fit in 64 bits", a, b))
+	}
+	return c
+}
+
+// floatDivide, intModulo and floatModulo also serve variables the compiler
+// proved to hold ints or floats, which it stores unboxed.
+func floatDivide(a, b float64) float64 {
+	if b == 0 {
+		panic(runtimeError(KindZeroDivisionError, "division by zero"))
+	}
+	return a / b
+}
+
+func intModulo(a, b int64) int64 {
+	if b == 0 {
+		panic(runtimeError(KindZeroDivisionError, "modulo by zero"))
+	}
+	result := a % b
+	if DefaultEngine.division == DivisionTruncate {
+		return result
+	}
+	// Python-style modulo (result has same sign as divisor)
+	if result != 0 && (result < 0) != (b < 0) {
+		result += b
+	}
+	return result
+}
+
+func floatModulo(a, b float64) float64 {
+	if b == 0 {
+		panic(runtimeError(KindZeroDivisionError, "modulo by zero"))
+	}
+	result := math.Mod(a, b)
+	if DefaultEngine.division == DivisionTruncate {
+		return result
+	}
+	// Python-style float modulo: floored, zero takes the divisor's sign
+	if result != 0 && (result < 0) != (b < 0) {
+		result += b
+	} else if result == 0 {
+		result = math.Copysign(0, b)
+	}
+	return result
+}
+
+// intDiv implements the intDiv(a, b) builtin, Python's a // b: the quotient
+// rounded as the program's DivisionMode says, an int for two ints and a
+// float otherwise. It pairs with %, so intDiv(a, b)*b + a%b == a.
+func intDiv(a, b Value) Value {
+	if a.Type == TypeInt && b.Type == TypeInt {
+		x, y := a.intData(), b.intData()
+		if y == 0 {
+			panic(runtimeError(KindZeroDivisionError, "integer division by zero"))
+		}
+		if DefaultEngine.checked && x == math.MinInt64 && y == -1 {
+			panic(runtimeError(KindOverflowError, "integer overflow: intDiv(%d, %d) does not fit in 64 bits", x, y))
+		}
+		q := x / y
+		if DefaultEngine.division != DivisionTruncate && x%y != 0 && (x < 0) != (y < 0) {
+			q--
+		}
+		return ValueInt(q)
+	}
+	if (a.Type == TypeInt || a.Type == TypeFloat) && (b.Type == TypeInt || b.Type == TypeFloat) {
+		q := floatDivide(asFloat(a), asFloat(b))
+		if DefaultEngine.division == DivisionTruncate {
+			return ValueFloat(math.Trunc(q))
+		}
+		return ValueFloat(math.Floor(q))
+	}
+	panic(runtimeError(KindTypeError, "cannot divide %s by %s", typeName(a), typeName(b)))
+}
+
+// valueNegate implements unary minus. Unlike `0 - x` it preserves the sign of
+// float zero (-0.0) and treats bools as ints, as Python does.
+func valueNegate(v Value) Value {
+	switch v.Type {
+	case TypeInt:
+		n := v.intData()
+		if n == math.MinInt64 {
+			panic(runtimeError(KindOverflowError, "integer overflow in negation"))
+		}
+		return ValueInt(-n)
+	case TypeFloat:
+		return ValueFloat(-v.floatData())
+	case TypeBool:
+		return ValueInt(-asInt(v))
+	default:
+		panic(runtimeError(KindTypeError, "bad operand type for unary -: '%s'", typeName(v)))
+	}
+}
+
+// valuePositive implements unary plus.
+func valuePositive(v Value) Value {
+	switch v.Type {
+	case TypeInt, TypeFloat:
+		return v
+	case TypeBool:
+		return ValueInt(asInt(v))
+	default:
+		panic(runtimeError(KindTypeError, "bad operand type for unary +: '%s'", typeName(v)))
+	}
+}
+
+func valueEqual(a, b Value) bool {
+	if hook, ok := classHook(a, "__eq__"); ok {
+		return isTruthy(callValue(hook, a, b))
+	}
+	if hook, ok := classHook(b, "__eq__"); ok {
+		return isTruthy(callValue(hook, b, a))
+	}
+	// A bool equals the number 0 or 1, as in Python (True == 1 == 1.0)
+	if a.Type == TypeBool && (b.Type == TypeInt || b.Type == TypeFloat) {
+		a = ValueInt(int64(a.num))
+	} else if b.Type == TypeBool && (a.Type == TypeInt || a.Type == TypeFloat) {
+		b = ValueInt(int64(b.num))
+	}
+	if a.Type != b.Type {
+		// Allow int/float comparison
+		if (a.Type == TypeInt || a.Type == TypeFloat) && (b.Type == TypeInt || b.Type == TypeFloat) {
+			return asFloat(a) == asFloat(b)
+		}
+		return false
+	}
+	switch a.Type {
+	case TypeNone:
+		return true
+	case TypeInt:
+		return a.intData() == b.intData()
+	case TypeFloat:
+		return a.floatData() == b.floatData()
+	case TypeBool:
+		return a.boolData() == b.boolData()
+	case TypeStr:
+		return a.data.(string) == b.data.(string)
+	case TypeArray, TypeTuple, TypeDeque:
+		aa, ba := iterItems(a), iterItems(b)
+		if len(aa) != len(ba) {
+			return false
+		}
+		for i := range aa {
+			if !valueEqual(aa[i], ba[i]) {
+				return false
+			}
+		}
+		return true
+	case TypeMap:
+		// Equal maps have the same keys and values, in any order
+		ma, mb := a.data.(*OrderedMap), b.data.(*OrderedMap)
+		if len(ma.keys) != len(mb.keys) {
+			return false
+		}
+		for k, v := range ma.values {
+			if w, ok := mb.values[k]; !ok || !valueEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case TypeSet:
+		sa, sb := a.data.(*ValueSet).items, b.data.(*ValueSet).items
+		if len(sa) != len(sb) {
+			return false
+		}
+		for k := range sa {
+			if _, ok := sb[k]; !ok {
+				return false
+			}
+		}
+		return true
+	case TypeVariant:
+		va, vb := a.data.(*Variant), b.data.(*Variant)
+		return va.enum == vb.enum && va.tag == vb.tag && valueEqual(va.payload, vb.payload)
+	case TypeTable:
+		return tablesEqual(a.data.(*Table), b.data.(*Table))
+	case TypeFunc, TypeClass, TypeChannel, TypeFuture, TypePool, TypeLock, TypeCounter, TypeActor, TypeEventBus, TypeTimer, TypeGenerator, TypePattern, TypeNode, TypeSecret:
+		return a.data == b.data
+	case TypeRecord:
+		// Class instances without __eq__ compare by identity.
+		ra := a.data.(*Record)
+		return ra.class != nil && ra == b.data.(*Record)
+	default:
+		return false
+	}
+}
+
+func valueLessThan(a, b Value) bool {
+	if hook, ok := classHook(a, "__lt__"); ok {
+		return isTruthy(callValue(hook, a, b))
+	}
+	if a.Type == TypeInt && b.Type == TypeInt {
+		return a.intData() < b.intData()
+	}
+	if (a.Type == TypeInt || a.Type == TypeFloat) && (b.Type == TypeInt || b.Type == TypeFloat) {
+		return asFloat(a) < asFloat(b)
+	}
+	if a.Type == TypeStr && b.Type == TypeStr {
+		return a.data.(string) < b.data.(string)
+	}
+	panic(runtimeError(KindTypeError, "cannot compare %s and %s", typeName(a), typeName(b)))
+}
+
+func valueLessThanOrEqual(a, b Value) bool {
+	return valueLessThan(a, b) || valueEqual(a, b)
+}
+
+func valueGreaterThan(a, b Value) bool {
+	return !valueLessThanOrEqual(a, b)
+}
+
+func valueGreaterThanOrEqual(a, b Value) bool {
+	return !valueLessThan(a, b)
+}
+
+func logicalNot(v Value) Value {
+	return ValueBool(!isTruthy(v))
+}
+
+// valueAnd and valueOr follow Python's `and`/`or`: they return the operand
+// that decided the result rather than a bool, e.g. `name or "anonymous"`.
+// Both operands are already evaluated; use the Lazy forms to short-circuit.
+func valueAnd(a, b Value) Value {
+	if !isTruthy(a) {
+		return a
+	}
+	return b
+}
+
+func valueOr(a, b Value) Value {
+	if isTruthy(a) {
+		return a
+	}
+	return b
+}
+
+func valueAndLazy(a Value, b func() Value) Value {
+	if !isTruthy(a) {
+		return a
+	}
+	return b()
+}
+
+func valueOrLazy(a Value, b func() Value) Value {
+	if isTruthy(a) {
+		return a
+	}
+	return b()
+}
+
+// ============================================================================
+// Hashing / freezing
+// ============================================================================
+
+// hashKey returns the identity used for map keys and set members. Strings
+// map to themselves; other hashable values get a type-prefixed encoding in
+// which numerically equal ints, floats and bools coincide, as in Python.
+// Mutable containers are unhashable unless frozen.
+func hashKey(v Value) string {
+	switch v.Type {
+	case TypeStr:
+		return v.data.(string)
+	case TypeNone:
+		return "\x00None"
+	case TypeInt, TypeBool:
+		return "\x00n:" + strconv.FormatInt(asInt(v), 10)
+	case TypeFloat:
+		f := v.floatData()
+		if f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+			return "\x00n:" + strconv.FormatInt(int64(f), 10)
+		}
+		return "\x00f:" + formatFloat(f)
+	case TypeTuple:
+		return "\x00t:(" + joinValues(v.data.([]Value), hashKey, "\x01") + ")"
+	case TypeSet:
+		s := v.data.(*ValueSet)
+		if !s.frozen {
+			break
+		}
+		keys := make([]string, 0, len(s.items))
+		for k := range s.items {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return "\x00s:{" + strings.Join(keys, "\x01") + "}"
+	case TypeMap:
+		om := v.data.(*OrderedMap)
+		if !om.frozen {
+			break
+		}
+		keys := append([]string(nil), om.keys...)
+		sort.Strings(keys)
+		parts := make([]string, len(keys))
+		for i, k := range keys {
+			parts[i] = k + "\x02" + hashKey(om.values[k])
+		}
+		return "\x00m:{" + strings.Join(parts, "\x01") + "}"
+	case TypeRecord:
+		r := v.data.(*Record)
+		if r.class != nil {
+			return fmt.Sprintf("\x00o:%p", r)
+		}
+		if !r.frozen {
+			break
+		}
+		names := append([]string(nil), r.order...)
+		sort.Strings(names)
+		parts := make([]string, len(names))
+		for i, n := range names {
+			parts[i] = n + "\x02" + hashKey(r.fields[n])
+		}
+		return "\x00r:(" + strings.Join(parts, "\x01") + ")"
+	case TypeVariant:
+		vr := v.data.(*Variant)
+		return fmt.Sprintf("\x00v:%p:%s:%s", vr.enum, vr.tag, hashKey(vr.payload))
+	case TypeFunc, TypeClass, TypeChannel, TypeFuture, TypePool, TypeLock, TypeCounter, TypeActor, TypeEventBus, TypeTimer, TypeGenerator, TypePattern, TypeNode, TypeSecret:
+		return fmt.Sprintf("\x00p:%p", v.data)
+	}
+	panic(runtimeError(KindTypeError, "unhashable type: '%s'", typeName(v)))
+}
+
+// freeze makes a value deeply immutable and returns the frozen value. Maps,
+// records and sets are frozen in place; arrays become tuples, the immutable
+// sequence type. Frozen values are hashable, so they can be set members and
+// map keys.
+func freeze(v Value) Value {
+	switch v.Type {
+	case TypeArray:
+		src := v.data.(*Array).items
+		items := make([]Value, len(src))
+		for i, item := range src {
+			items[i] = freeze(item)
+		}
+		return Value{Type: TypeTuple, data: items}
+	case TypeTuple:
+		src := v.data.([]Value)
+		items := make([]Value, len(src))
+		for i, item := range src {
+			items[i] = freeze(item)
+		}
+		return Value{Type: TypeTuple, data: items}
+	case TypeMap:
+		om := v.data.(*OrderedMap)
+		if !om.frozen {
+			for _, k := range om.keys {
+				om.values[k] = freeze(om.values[k])
+			}
+			om.frozen = true
+		}
+		return v
+	case TypeRecord:
+		r := v.data.(*Record)
+		if !r.frozen {
+			for name, fv := range r.fields {
+				r.fields[name] = freeze(fv)
+			}
+			r.frozen = true
+		}
+		return v
+	case TypeSet:
+		v.data.(*ValueSet).frozen = true
+		return v
+	case TypeVariant:
+		vr := v.data.(*Variant)
+		return Value{Type: TypeVariant, data: &Variant{enum: vr.enum, tag: vr.tag, payload: freeze(vr.payload)}}
+	case TypeDeque, TypeHeap:
+		panic(runtimeError(KindTypeError, "cannot freeze %s", typeName(v)))
+	default:
+		return v
+	}
+}
+
+func isFrozen(v Value) Value {
+	switch v.Type {
+	case TypeArray, TypeDeque, TypeHeap:
+		return ValueBool(false)
+	case TypeMap:
+		return ValueBool(v.data.(*OrderedMap).frozen)
+	case TypeRecord:
+		return ValueBool(v.data.(*Record).frozen)
+	case TypeSet:
+		return ValueBool(v.data.(*ValueSet).frozen)
+	default:
+		return ValueBool(true)
+	}
+}
+
+// ============================================================================
+// Copying
+// ============================================================================
+
+// valueCopy returns a shallow copy: a new container holding the same element
+// Values. Scalars and immutable values are returned unchanged.
+func valueCopy(v Value) Value {
+	switch v.Type {
+	case TypeArray:
+		return ValueArray(v.data.(*Array).items)
+	case TypeMap:
+		om := v.data.(*OrderedMap)
+		cp := NewOrderedMap()
+		for _, k := range om.keys {
+			cp.SetValue(om.keyValue(k), om.values[k])
+		}
+		return Value{Type: TypeMap, data: cp}
+	case TypeRecord:
+		r := v.data.(*Record)
+		cp := &Record{fields: make(map[string]Value, len(r.fields)), order: append([]string(nil), r.order...), schema: r.schema, class: r.class}
+		for k, fv := range r.fields {
+			cp.fields[k] = fv
+		}
+		return Value{Type: TypeRecord, data: cp}
+	case TypeSet:
+		cp := NewValueSet()
+		for k, item := range v.data.(*ValueSet).items {
+			cp.items[k] = item
+		}
+		return Value{Type: TypeSet, data: cp}
+	case TypeDeque:
+		d := v.data.(*Deque)
+		return Value{Type: TypeDeque, data: &Deque{items: append([]Value(nil), d.items...), bounded: d.bounded, maxlen: d.maxlen}}
+	case TypeHeap:
+		return Value{Type: TypeHeap, data: &MinHeap{items: append([]HeapItem(nil), v.data.(*MinHeap).items...)}}
+	default:
+		return v
+	}
+}
+
+// valueDeepCopy recursively copies containers. Shared and cyclic references
+// are preserved: each container is copied once and reused where it recurs.
+func valueDeepCopy(v Value) Value {
+	return deepCopy(v, make(map[interface{}]Value))
+}
+
+func deepCopy(v Value, memo map[interface{}]Value) Value {
+	switch v.Type {
+	case TypeArray, TypeMap, TypeRecord, TypeSet, TypeDeque, TypeHeap:
+		if cp, ok := memo[v.data]; ok {
+			return cp
+		}
+	}
+	switch v.Type {
+	case TypeArray:
+		src := v.data.(*Array).items
+		items := make([]Value, len(src))
+		cp := Value{Type: TypeArray, data: &Array{items: items}}
+		memo[v.data] = cp
+		for i, item := range src {
+			items[i] = deepCopy(item, memo)
+		}
+		return cp
+	case TypeTuple:
+		src := v.data.([]Value)
+		items := make([]Value, len(src))
+		for i, item := range src {
+			items[i] = deepCopy(item, memo)
+		}
+		return Value{Type: TypeTuple, data: items}
+	case TypeMap:
+		om := v.data.(*OrderedMap)
+		m := NewOrderedMap()
+		cp := Value{Type: TypeMap, data: m}
+		memo[v.data] = cp
+		for _, k := range om.keys {
+			m.SetValue(om.keyValue(k), deepCopy(om.values[k], memo))
+		}
+		return cp
+	case TypeRecord:
+		r := v.data.(*Record)
+		nr := &Record{fields: make(map[string]Value, len(r.fields)), order: append([]string(nil), r.order...), schema: r.schema, class: r.class}
+		cp := Value{Type: TypeRecord, data: nr}
+		memo[v.data] = cp
+		for k, fv := range r.fields {
+			nr.fields[k] = deepCopy(fv, memo)
+		}
+		return cp
+	case TypeSet:
+		ns := NewValueSet()
+		cp := Value{Type: TypeSet, data: ns}
+		memo[v.data] = cp
+		for k, item := range v.data.(*ValueSet).items {
+			ns.items[k] = deepCopy(item, memo)
+		}
+		return cp
+	case TypeDeque:
+		d := v.data.(*Deque)
+		nd := &Deque{items: make([]Value, len(d.items)), bounded: d.bounded, maxlen: d.maxlen}
+		cp := Value{Type: TypeDeque, data: nd}
+		memo[v.data] = cp
+		for i, item := range v.data.(*Deque).items {
+			nd.items[i] = deepCopy(item, memo)
+		}
+		return cp
+	case TypeHeap:
+		nh := &MinHeap{items: make([]HeapItem, len(v.data.(*MinHeap).items))}
+		cp := Value{Type: TypeHeap, data: nh}
+		memo[v.data] = cp
+		for i, item := range v.data.(*MinHeap).items {
+			nh.items[i] = HeapItem{priority: item.priority, value: deepCopy(item.value, memo)}
+		}
+		return cp
+	case TypeVariant:
+		vr := v.data.(*Variant)
+		return Value{Type: TypeVariant, data: &Variant{enum: vr.enum, tag: vr.tag, payload: deepCopy(vr.payload, memo)}}
+	default:
+		return v
+	}
+}
+
+// ============================================================================
+// Function calls
+// ============================================================================
+
+func callValue(f Value, args ...Value) Value {
+	return asFunc(f).fn(args)
+}
+
+// classNew creates an instance of a class and runs its __init__ method (if
+// any) with the instance and args.
+func classNew(cls Value, args ...Value) Value {
+	c := asClass(cls)
+	obj := Value{Type: TypeRecord, data: &Record{fields: make(map[string]Value), class: c}}
+	if init, ok := c.lookupMethod("__init__"); ok {
+		withReceiver(init, obj, args)
+	} else if len(args) > 0 {
+		panic(runtimeError(KindTypeError, "%s() takes no arguments", c.name))
+	}
+	return obj
+}
+
+// callMethod dispatches name on obj. As in Python, a function value stored
+// in an instance field shadows the class method of the same name; class
+// methods get obj bound as the first argument.
+func callMethod(obj, name Value, args ...Value) Value {
+	r, method := asRecord(obj), asString(name)
+	if f, ok := r.fields[method]; ok && f.Type == TypeFunc {
+		return callValue(f, args...)
+	}
+	if r.class != nil {
+		if m, ok := r.class.lookupMethod(method); ok {
+			return withReceiver(m, obj, args)
+		}
+	}
+	panic(noMethodError(r, method))
+}
+
+func noMethodError(r *Record, name string) *CoreILError {
+	owner := "record"
+	if r.class != nil {
+		owner = r.class.name
+	}
+	return runtimeError(KindAttributeError, "'%s' object has no method '%s'", owner, name)
+}
+
+// partial returns fn with bound as its leading arguments.
+func partial(fn Value, bound ...Value) Value {
+	f := asFunc(fn)
+	return ValueFunc(f.name, func(args []Value) Value {
+		return f.fn(append(append([]Value(nil), bound...), args...))
+	})
+}
+
+// compose returns the composition of fns: compose(f, g)(x) is f(g(x)). The
+// last function receives all of the arguments; the others receive the
+// previous result.
+func compose(fns ...Value) Value {
+	if len(fns) == 0 {
+		panic(runtimeError(KindTypeError, "compose() needs at least one function"))
+	}
+	chain := make([]*Function, len(fns))
+	for i, fn := range fns {
+		chain[i] = asFunc(fn)
+	}
+	return ValueFunc(chain[0].name, func(args []Value) Value {
+		v := chain[len(chain)-1].fn(args)
+		for i := len(chain) - 2; i >= 0; i-- {
+			v = chain[i].fn([]Value{v})
+		}
+		return v
+	})
+}
+
+// bindMethod returns obj's method name as a function value with obj bound,
+// resolved like callMethod.
+func bindMethod(obj, name Value) Value {
+	r, method := asRecord(obj), asString(name)
+	if f, ok := r.fields[method]; ok && f.Type == TypeFunc {
+		return f
+	}
+	if r.class != nil {
+		if m, ok := r.class.lookupMethod(method); ok {
+			return ValueFunc(method, func(args []Value) Value {
+				return withReceiver(m, obj, args)
+			})
+		}
+	}
+	panic(noMethodError(r, method))
+}
+
+// isInstance reports whether obj is an instance of cls or one of its subclasses.
+func isInstance(obj, cls Value) Value {
+	c := asClass(cls)
+	if obj.Type != TypeRecord {
+		return ValueBool(false)
+	}
+	for k := obj.data.(*Record).class; k != nil; k = k.parent {
+		if k == c {
+			return ValueBool(true)
+		}
+	}
+	return ValueBool(false)
+}
+
+// ============================================================================
+// Variant operations
+// ============================================================================
+
+func variantTag(v Value) Value     { return ValueStr(asVariant(v).tag) }
+func variantPayload(v Value) Value { return asVariant(v).payload }
+
+func variantIs(v, tag Value) Value {
+	return ValueBool(asVariant(v).tag == asString(tag))
+}
+
+// matchVariant calls the handler registered for the variant's tag with its
+// payload. Without a default (ValueNone) the cases must cover every tag of
+// the enum; unknown case tags are rejected either way.
+func matchVariant(v Value, cases []struct{ Name string; Val Value }, defaultFn Value) Value {
+	vr := asVariant(v)
+	covered := make(map[string]bool, len(cases))
+	for _, c := range cases {
+		if !vr.enum.hasTag(c.Name) {
+			panic(runtimeError(KindValueError, "%s has no variant '%s'", vr.enum.name, c.Name))
+		}
+		covered[c.Name] = true
+	}
+	if defaultFn.Type == TypeNone {
+		var missing []string
+		for _, t := range vr.enum.tags {
+			if !covered[t] {
+				missing = append(missing, t)
+			}
+		}
+		if len(missing) > 0 {
+			panic(runtimeError(KindValueError, "non-exhaustive match on %s: missing %s", vr.enum.name, strings.Join(missing, ", ")))
+		}
+	}
+	for _, c := range cases {
+		if c.Name == vr.tag {
+			return callValue(c.Val, vr.payload)
+		}
+	}
+	return callValue(defaultFn, v)
+}
+
+// ============================================================================
+// Option / Result
+// ============================================================================
+
+var (
+	optionEnum = &Enum{name: "Option", tags: []string{"Some", "None"}, bare: true}
+	resultEnum = &Enum{name: "Result", tags: []string{"Ok", "Err"}, bare: true}
+)
+
+func optionSome(v Value) Value  { return ValueVariant(optionEnum, "Some", v) }
+func optionNoneOf() Value       { return ValueVariant(optionEnum, "None", ValueNone) }
+func resultOk(v Value) Value    { return ValueVariant(resultEnum, "Ok", v) }
+func resultErr(err Value) Value { return ValueVariant(resultEnum, "Err", err) }
+func isSome(v Value) Value      { return ValueBool(asOptional(v).tag == "Some") }
+func isOk(v Value) Value        { return ValueBool(asOptional(v).tag == "Ok") }
+
+// asOptional accepts either an Option or a Result variant.
+func asOptional(v Value) *Variant {
+	if v.Type == TypeVariant {
+		vr := v.data.(*Variant)
+		if vr.enum == optionEnum || vr.enum == resultEnum {
+			return vr
+		}
+	}
+	panic(runtimeError(KindTypeError, "expected Option or Result, got %s", typeName(v)))
+}
+
+func hasValue(vr *Variant) bool {
+	return vr.tag == "Some" || vr.tag == "Ok"
+}
+
+func unwrap(v Value) Value {
+	vr := asOptional(v)
+	if hasValue(vr) {
+		return vr.payload
+	}
+	if vr.tag == "Err" {
+		panic(runtimeError(KindValueError, "called unwrap on Err: %s", formatValue(vr.payload)))
+	}
+	panic(runtimeError(KindValueError, "called unwrap on None"))
+}
+
+func unwrapOr(v, defaultVal Value) Value {
+	vr := asOptional(v)
+	if hasValue(vr) {
+		return vr.payload
+	}
+	return defaultVal
+}
+
+// optionMap applies fn to the wrapped value of Some/Ok, passing None/Err through.
+func optionMap(v, fn Value) Value {
+	vr := asOptional(v)
+	if !hasValue(vr) {
+		return v
+	}
+	return ValueVariant(vr.enum, vr.tag, callValue(fn, vr.payload))
+}
+
+// tryCall runs fn and captures a runtime panic as Err(message).
+func tryCall(fn Value, args ...Value) (result Value) {
+	defer func() {
+		if r := recover(); r != nil {
+			rethrowLimit(r)
+			result = resultErr(caughtError(r))
+		}
+	}()
+	return resultOk(callValue(fn, args...))
+}
+
+// Non-panicking variants of fallible builtins.
+
+func mapGetOption(base, key Value) Value {
+	v, ok := asMap(base).GetValue(key)
+	if !ok {
+		return optionNoneOf()
+	}
+	return optionSome(v)
+}
+
+func arrayIndexOption(base, index Value) Value {
+	arr := *asArray(base)
+	idx := asInt(index)
+	if idx < 0 {
+		idx += int64(len(arr))
+	}
+	if idx < 0 || idx >= int64(len(arr)) {
+		return optionNoneOf()
+	}
+	return optionSome(arr[idx])
+}
+
+func parseIntResult(v Value) Value {
+	n, err := strconv.ParseInt(strings.TrimSpace(asString(v)), 10, 64)
+	if err != nil {
+		return resultErr(ValueStr(fmt.Sprintf("invalid literal for int() with base 10: %s", reprValue(v))))
+	}
+	return resultOk(ValueInt(n))
+}
+
+func parseFloatResult(v Value) Value {
+	f, err := strconv.ParseFloat(strings.TrimSpace(asString(v)), 64)
+	if err != nil {
+		return resultErr(ValueStr(fmt.Sprintf("could not convert string to float: %s", reprValue(v))))
+	}
+	return resultOk(ValueFloat(f))
+}
+
+// ============================================================================
+// Iteration / min / max
+// ============================================================================
+
+// iterItems returns the elements of any iterable value in iteration order.
+// Strings iterate by character, maps by key and sets in formatted order.
+func iterItems(v Value) []Value {
+	switch v.Type {
+	case TypeArray:
+		return v.data.(*Array).items
+	case TypeTuple:
+		return v.data.([]Value)
+	case TypeDeque:
+		return v.data.(*Deque).items
+	case TypeStr:
+		s := v.data.(string)
+		items := make([]Value, 0, utf8.RuneCountInString(s))
+		for _, r := range s {
+			items = append(items, ValueStr(string(r)))
+		}
+		return items
+	case TypeMap:
+		om := v.data.(*OrderedMap)
+		items := make([]Value, len(om.keys))
+		for i, k := range om.keys {
+			items[i] = om.keyValue(k)
+		}
+		return items
+	case TypeSet:
+		s := v.data.(*ValueSet)
+		keys := make([]string, 0, len(s.items))
+		for k := range s.items {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		items := make([]Value, len(keys))
+		for i, k := range keys {
+			items[i] = s.items[k]
+		}
+		return items
+	case TypeGenerator:
+		return v.data.(*Generator).drain()
+	case TypeTable:
+		return *asArray(tableRows(v))
+	default:
+		panic(runtimeError(KindTypeError, "'%s' object is not iterable", typeName(v)))
+	}
+}
+
+// forEachItems returns the items For and ForEach loop over, one per call
+// with false after the last. An array is read as it was when the loop
+// started; a generator is advanced one item per iteration, so a loop over
+// readLines holds one line at a time. A table gives its rows as maps.
+func forEachItems(v Value) func() (Value, bool) {
+	switch v.Type {
+	case TypeGenerator:
+		return func() (Value, bool) {
+			next := asOptional(generatorNext(v))
+			return next.payload, next.tag == "Some"
+		}
+	case TypeTable:
+		t, i := v.data.(*Table), 0
+		return func() (Value, bool) {
+			if i == t.rows {
+				return ValueNone, false
+			}
+			i++
+			return t.row(i - 1), true
+		}
+	}
+	items := *asArray(v)
+	i := 0
+	return func() (Value, bool) {
+		if i == len(items) {
+			return ValueNone, false
+		}
+		i++
+		return items[i-1], true
+	}
+}
+
+// valueContains implements the `in` operator: substring search for strings,
+// element scan for sequences, key presence for maps and membership for sets.
+func valueContains(container, item Value) Value {
+	switch container.Type {
+	case TypeStr:
+		if item.Type != TypeStr {
+			panic(runtimeError(KindTypeError, "'in <string>' requires string as left operand, not %s", typeName(item)))
+		}
+		return ValueBool(strings.Contains(container.data.(string), item.data.(string)))
+	case TypeMap:
+		_, ok := container.data.(*OrderedMap).GetValue(item)
+		return ValueBool(ok)
+	case TypeSet:
+		_, ok := container.data.(*ValueSet).items[hashKey(item)]
+		return ValueBool(ok)
+	case TypeRecord:
+		if item.Type != TypeStr {
+			return ValueBool(false)
+		}
+		_, ok := container.data.(*Record).fields[item.data.(string)]
+		return ValueBool(ok)
+	case TypeArray, TypeTuple, TypeDeque:
+		for _, v := range iterItems(container) {
+			if valueEqual(v, item) {
+				return ValueBool(true)
+			}
+		}
+		return ValueBool(false)
+	default:
+		panic(runtimeError(KindTypeError, "argument of type '%s' is not iterable", typeName(container)))
+	}
+}
+
+// minMaxSelect implements Python's min()/max(): a single argument is treated
+// as an iterable, otherwise the arguments themselves are compared. key may be
+// ValueNone or a function Value; ties keep the first candidate.
+func minMaxSelect(name string, args []Value, key Value, wantMax bool) Value {
+	if len(args) == 0 {
+		panic(runtimeError(KindTypeError, "%s expected at least 1 argument, got 0", name))
+	}
+	candidates := args
+	if len(args) == 1 {
+		candidates = iterItems(args[0])
+	}
+	if len(candidates) == 0 {
+		panic(runtimeError(KindValueError, "%s() arg is an empty sequence", name))
+	}
+	keyOf := func(v Value) Value {
+		if key.Type == TypeNone {
+			return v
+		}
+		return callValue(key, v)
+	}
+	best := candidates[0]
+	bestKey := keyOf(best)
+	for _, c := range candidates[1:] {
+		k := keyOf(c)
+		if (wantMax && valueLessThan(bestKey, k)) || (!wantMax && valueLessThan(k, bestKey)) {
+			best, bestKey = c, k
+		}
+	}
+	return best
+}
+
+func coreilMin(args ...Value) Value {
+	return minMaxSelect("min", args, ValueNone, false)
+}
+
+func coreilMax(args ...Value) Value {
+	return minMaxSelect("max", args, ValueNone, true)
+}
+
+// coreilMinBy and coreilMaxBy are min(..., key=key) and max(..., key=key).
+func coreilMinBy(key Value, args ...Value) Value {
+	return minMaxSelect("min", args, key, false)
+}
+
+func coreilMaxBy(key Value, args ...Value) Value {
+	return minMaxSelect("max", args, key, true)
+}
+
+// ============================================================================
+// Buffer pooling
+// ============================================================================
+
+// maxPooledBuf caps the capacity of buffers returned to the pools so one huge
+// container does not pin a large buffer forever.
+const maxPooledBuf = 1024
+
+var (
+	valueBufPool  = sync.Pool{New: func() any { return new([]Value) }}
+	stringBufPool = sync.Pool{New: func() any { return new([]string) }}
+)
+
+// getValueBuf returns a buffer of length n, pooled when the engine enables
+// buffer pooling. Release it with putValueBuf once it is no longer used.
+func getValueBuf(n int) *[]Value {
+	if !DefaultEngine.pooling {
+		b := make([]Value, n)
+		return &b
+	}
+	b := valueBufPool.Get().(*[]Value)
+	if cap(*b) < n {
+		*b = make([]Value, n)
+	}
+	*b = (*b)[:n]
+	return b
+}
+
+func putValueBuf(b *[]Value) {
+	if !DefaultEngine.pooling || cap(*b) > maxPooledBuf {
+		return
+	}
+	clear(*b)
+	valueBufPool.Put(b)
+}
+
+func getStringBuf(n int) *[]string {
+	if !DefaultEngine.pooling {
+		b := make([]string, n)
+		return &b
+	}
+	b := stringBufPool.Get().(*[]string)
+	if cap(*b) < n {
+		*b = make([]string, n)
+	}
+	*b = (*b)[:n]
+	return b
+}
+
+func putStringBuf(b *[]string) {
+	if !DefaultEngine.pooling || cap(*b) > maxPooledBuf {
+		return
+	}
+	clear(*b)
+	stringBufPool.Put(b)
+}
+
+// joinValues formats items with f and joins them with sep, using a pooled
+// buffer for the parts.
+func joinValues(items []Value, f func(Value) string, sep string) string {
+	buf := getStringBuf(len(items))
+	parts := *buf
+	for i, item := range items {
+		parts[i] = f(item)
+	}
+	joined := strings.Join(parts, sep)
+	putStringBuf(buf)
+	return joined
+}
+
+// withReceiver calls f with obj prepended to args. The combined argument
+// list is not pooled: the callee owns it and may keep it, e.g. in a partial
+// or a generator.
+func withReceiver(f Value, obj Value, args []Value) Value {
+	return callValue(f, append([]Value{obj}, args...)...)
+}
+
+// ============================================================================
+// Array operations
+// ============================================================================
+
+// arrayIndex also serves Index on a deque.
+func arrayIndex(base, index Value) Value {
+	if base.Type == TypeDeque {
+		return dequeIndex(base, index)
+	}
+	arr := asArray(base)
+	idx := asInt(index)
+	length := int64(len(*arr))
+	if idx < 0 {
+		idx += length
+	}
+	if idx < 0 || idx >= length {
+		panic(runtimeError(KindIndexError, "index %d out of range for array of length %d", idx, length))
+	}
+	return (*arr)[idx]
+}
+
+func arraySetIndex(base, index, value Value) {
+	arr := mutableArray(base)
+	idx := asInt(index)
+	length := int64(len(*arr))
+	if idx < 0 {
+		idx += length
+	}
+	if idx < 0 || idx >= length {
+		panic(runtimeError(KindIndexError, "index %d out of range for array of length %d", idx, length))
+	}
+	if DefaultEngine.watching {
+		watchMutation(base, "SetIndex", ValueInt(idx), (*arr)[idx], value)
+	}
+	(*arr)[idx] = value
+}
+
+func arrayLength(base Value) Value {
+	arr := asArray(base)
+	return ValueInt(int64(len(*arr)))
+}
+
+// arrayPush needs no copy when shared: slices have their capacity clipped,
+// so appending to either side never writes into the other's elements.
+func arrayPush(base, value Value) {
+	arr := asArray(base)
+	trackGrowth(len(*arr)+1, valueBytes)
+	if DefaultEngine.watching {
+		watchMutation(base, "Push", ValueInt(int64(len(*arr))), ValueNone, value)
+	}
+	*arr = append(*arr, value)
+}
+
+func arraySlice(base, start, end Value) Value {
+	arr := asArray(base)
+	length := int64(len(*arr))
+	s := asInt(start)
+	e := asInt(end)
+
+	// Handle negative indices
+	if s < 0 {
+		s += length
+	}
+	if e < 0 {
+		e += length
+	}
+
+	// Clamp to bounds
+	if s < 0 {
+		s = 0
+	}
+	if e > length {
+		e = length
+	}
+	if s > length {
+		s = length
+	}
+	if e < s {
+		return ValueArray(nil)
+	}
+
+	// Share the parent's items, unless the slice is a small window of a
+	// large array that it would otherwise keep alive.
+	if length >= sliceShareMinLen && (e-s)*4 < length {
+		result := make([]Value, e-s)
+		copy(result, (*arr)[s:e])
+		return ValueArray(result)
+	}
+	base.data.(*Array).shared = true
+	return Value{Type: TypeArray, data: &Array{items: (*arr)[s:e:e], shared: true}}
+}
+
+// sliceShareMinLen is the parent length from which slices covering less than
+// a quarter of the parent are copied rather than shared.
+const sliceShareMinLen = 1024
+
+// ============================================================================
+// Map operations
+// ============================================================================
+
+func mapGet(base, key Value) Value {
+	m := asMap(base)
+	v, ok := m.GetValue(key)
+	if !ok {
+		panic(runtimeError(KindKeyError, "key %s not found", reprValue(key)))
+	}
+	return v
+}
+
+func mapGetDefault(base, key, defaultVal Value) Value {
+	m := asMap(base)
+	v, ok := m.GetValue(key)
+	if !ok {
+		return defaultVal
+	}
+	return v
+}
+
+func mapSet(base, key, value Value) {
+	m := asMap(base)
+	trackGrowth(len(m.keys)+1, 2*valueBytes)
+	if DefaultEngine.watching {
+		old, _ := m.GetValue(key)
+		watchMutation(base, "Set", key, old, value)
+	}
+	m.SetValue(key, value)
+}
+
+func mapKeys(base Value) Value {
+	m := asMap(base)
+	keys := m.Keys()
+	result := make([]Value, len(keys))
+	for i, k := range keys {
+		result[i] = m.keyValue(k)
+	}
+	return ValueArray(result)
+}
+
+// ============================================================================
+// Record operations
+// ============================================================================
+
+func recordGetField(base Value, name string) Value {
+	r := asRecord(base)
+	v, ok := r.fields[name]
+	if !ok {
+		panic(runtimeError(KindAttributeError, "field '%s' not found", name))
+	}
+	return v
+}
+
+func recordSetField(base Value, name string, value Value) {
+	r := asRecord(base)
+	if r.frozen {
+		panic(runtimeError(KindTypeError, "cannot modify frozen record"))
+	}
+	if r.schema != nil {
+		r.schema.checkField(name, value)
+	}
+	if DefaultEngine.watching {
+		watchMutation(base, "SetField", ValueStr(name), r.fields[name], value)
+	}
+	if _, ok := r.fields[name]; !ok {
+		r.order = append(r.order, name)
+	}
+	r.fields[name] = value
+}
+
+// recordFields returns the field names in definition order.
+func recordFields(base Value) Value {
+	r := asRecord(base)
+	names := make([]Value, len(r.order))
+	for i, name := range r.order {
+		names[i] = ValueStr(name)
+	}
+	return ValueArray(names)
+}
+
+func recordHas(base, name Value) Value {
+	r := asRecord(base)
+	_, ok := r.fields[asString(name)]
+	return ValueBool(ok)
+}
+
+func recordDelete(base, name Value) {
+	r := asRecord(base)
+	if r.frozen {
+		panic(runtimeError(KindTypeError, "cannot modify frozen record"))
+	}
+	n := asString(name)
+	if _, ok := r.fields[n]; !ok {
+		panic(runtimeError(KindAttributeError, "field '%s' not found", n))
+	}
+	if r.schema != nil {
+		if f, ok := r.schema.fields[n]; ok && f.Required {
+			panic(runtimeError(KindTypeError, "%s: cannot delete required field '%s'", r.schema.name, n))
+		}
+	}
+	delete(r.fields, n)
+	for i, o := range r.order {
+		if o == n {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// recordToMap copies the fields of a record into a new map, preserving order.
+func recordToMap(base Value) Value {
+	r := asRecord(base)
+	om := NewOrderedMap()
+	for _, name := range r.order {
+		om.Set(name, r.fields[name])
+	}
+	return Value{Type: TypeMap, data: om}
+}
+
+// mapToRecord builds a record from a map with string keys, preserving order.
+func mapToRecord(base Value) Value {
+	m := asMap(base)
+	r := &Record{fields: make(map[string]Value)}
+	for _, k := range m.keys {
+		name := asString(m.keyValue(k))
+		r.fields[name] = m.values[k]
+		r.order = append(r.order, name)
+	}
+	return Value{Type: TypeRecord, data: r}
+}
+
+// ============================================================================
+// String operations
+// ============================================================================
+
+func stringLength(base Value) Value {
+	s := asString(base)
+	return ValueInt(int64(utf8.RuneCountInString(s)))
+}
+
+// runeCache remembers the decoding of the last long string indexed by
+// character, so CharAt/Substring loops over one string stay linear.
+type runeCache struct {
+	s     string
+	runes []rune
+}
+
+var lastRunes atomic.Pointer[runeCache]
+
+// stringRunes returns the runes of s, or nil if s is ASCII and can be
+// indexed by byte.
+func stringRunes(s string) []rune {
+	if len(s) > 32 {
+		if c := lastRunes.Load(); c != nil && c.s == s {
+			return c.runes
+		}
+	}
+	var runes []rune
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			runes = []rune(s)
+			break
+		}
+	}
+	if len(s) > 32 {
+		lastRunes.Store(&runeCache{s: s, runes: runes})
+	}
+	return runes
+}
+
+func stringSubstring(base, start, end Value) Value {
+	s := asString(base)
+	runes := stringRunes(s)
+	n := len(s)
+	if runes != nil {
+		n = len(runes)
+	}
+	si := int(asInt(start))
+	ei := int(asInt(end))
+	if si < 0 {
+		si = 0
+	}
+	if ei > n {
+		ei = n
+	}
+	if si > ei {
+		return ValueStr("")
+	}
+	if runes != nil {
+		return ValueStr(string(runes[si:ei]))
+	}
+	return ValueStr(s[si:ei])
+}
+
+func stringCharAt(base, index Value) Value {
+	s := asString(base)
+	runes := stringRunes(s)
+	n := len(s)
+	if runes != nil {
+		n = len(runes)
+	}
+	idx := int(asInt(index))
+	if idx < 0 || idx >= n {
+		panic(runtimeError(KindIndexError, "string index %d out of range", idx))
+	}
+	if runes != nil {
+		return ValueStr(string(runes[idx]))
+	}
+	return ValueStr(s[idx : idx+1])
+}
+
+func stringJoin(sep, items Value) Value {
+	s := asString(sep)
+	arr := asArray(items)
+	parts := make([]string, len(*arr))
+	for i, item := range *arr {
+		parts[i] = formatValue(item)
+	}
+	return ValueStr(strings.Join(parts, s))
+}
+
+func stringSplit(base, delimiter Value) Value {
+	s := asString(base)
+	d := asString(delimiter)
+	parts := strings.Split(s, d)
+	result := make([]Value, len(parts))
+	for i, p := range parts {
+		result[i] = ValueStr(p)
+	}
+	return ValueArray(result)
+}
+
+func stringTrim(base Value) Value {
+	return ValueStr(strings.TrimSpace(asString(base)))
+}
+
+func stringUpper(base Value) Value {
+	return ValueStr(strings.ToUpper(asString(base)))
+}
+
+func stringLower(base Value) Value {
+	return ValueStr(strings.ToLower(asString(base)))
+}
+
+func stringStartsWith(base, prefix Value) Value {
+	return ValueBool(strings.HasPrefix(asString(base), asString(prefix)))
+}
+
+func stringEndsWith(base, suffix Value) Value {
+	return ValueBool(strings.HasSuffix(asString(base), asString(suffix)))
+}
+
+func stringContains(base, substring Value) Value {
+	return ValueBool(strings.Contains(asString(base), asString(substring)))
+}
+
+func stringReplaceFn(base, old, new_ Value) Value {
+	return ValueStr(strings.ReplaceAll(asString(base), asString(old), asString(new_)))
+}
+
+// ============================================================================
+// Set operations
+// ============================================================================
+
+func setHas(base, value Value) Value {
+	s := asSet(base)
+	_, ok := s.items[hashKey(value)]
+	return ValueBool(ok)
+}
+
+func setAdd(base, value Value) {
+	s := asSet(base)
+	if s.frozen {
+		panic(runtimeError(KindTypeError, "cannot modify frozen set"))
+	}
+	trackGrowth(len(s.items)+1, valueBytes)
+	if DefaultEngine.watching {
+		watchMutation(base, "SetAdd", ValueNone, ValueNone, value)
+	}
+	s.add(value)
+}
+
+func setRemove(base, value Value) {
+	s := asSet(base)
+	if s.frozen {
+		panic(runtimeError(KindTypeError, "cannot modify frozen set"))
+	}
+	if DefaultEngine.watching {
+		watchMutation(base, "SetRemove", ValueNone, value, ValueNone)
+	}
+	delete(s.items, hashKey(value))
+}
+
+func setSize(base Value) Value {
+	s := asSet(base)
+	return ValueInt(int64(len(s.items)))
+}
+
+// ============================================================================
+// Deque operations
+// ============================================================================
+
+func dequeSize(base Value) Value {
+	d := asDeque(base)
+	return ValueInt(int64(len(d.items)))
+}
+
+func dequePushBack(base, value Value) {
+	d := asDeque(base)
+	if !d.full() {
+		trackGrowth(len(d.items)+1, valueBytes)
+	}
+	if DefaultEngine.watching {
+		watchMutation(base, "PushBack", ValueNone, ValueNone, value)
+	}
+	d.items = append(d.items, value)
+	if d.bounded && len(d.items) > d.maxlen {
+		d.items = d.items[len(d.items)-d.maxlen:]
+	}
+}
+
+func dequePushFront(base, value Value) {
+	d := asDeque(base)
+	if !d.full() {
+		trackGrowth(len(d.items)+1, valueBytes)
+	}
+	if DefaultEngine.watching {
+		watchMutation(base, "PushFront", ValueNone, ValueNone, value)
+	}
+	d.items = append([]Value{value}, d.items...)
+	if d.bounded && len(d.items) > d.maxlen {
+		d.items = d.items[:d.maxlen]
+	}
+}
+
+// dequeExtend implements the dequeExtend(deque, items) builtin: PushBack
+// of each item in order, so a bounded deque keeps the last maxlen of them.
+func dequeExtend(base, items Value) {
+	for _, item := range append([]Value(nil), iterItems(items)...) {
+		dequePushBack(base, item)
+	}
+}
+
+// dequeExtendLeft implements the dequeExtendLeft(deque, items) builtin:
+// PushFront of each item in order, which leaves them reversed at the front.
+func dequeExtendLeft(base, items Value) {
+	for _, item := range append([]Value(nil), iterItems(items)...) {
+		dequePushFront(base, item)
+	}
+}
+
+func dequeIndex(base, index Value) Value {
+	d := asDeque(base)
+	idx := asInt(index)
+	length := int64(len(d.items))
+	if idx < 0 {
+		idx += length
+	}
+	if idx < 0 || idx >= length {
+		panic(runtimeError(KindIndexError, "index %d out of range for deque of length %d", idx, length))
+	}
+	return d.items[idx]
+}
+
+func dequePopFront(base Value) Value {
+	d := asDeque(base)
+	if len(d.items) == 0 {
+		panic(runtimeError(KindIndexError, "deque is empty"))
+	}
+	v := d.items[0]
+	if DefaultEngine.watching {
+		watchMutation(base, "PopFront", ValueNone, v, ValueNone)
+	}
+	d.items = d.items[1:]
+	return v
+}
+
+func dequePopBack(base Value) Value {
+	d := asDeque(base)
+	if len(d.items) == 0 {
+		panic(runtimeError(KindIndexError, "deque is empty"))
+	}
+	v := d.items[len(d.items)-1]
+	if DefaultEngine.watching {
+		watchMutation(base, "PopBack", ValueNone, v, ValueNone)
+	}
+	d.items = d.items[:len(d.items)-1]
+	return v
+}
+
+// ============================================================================
+// Heap operations
+// ============================================================================
+
+func heapSize(base Value) Value {
+	h := asHeap(base)
+	return ValueInt(int64(h.Len()))
+}
+
+func heapPeek(base Value) Value {
+	h := asHeap(base)
+	return h.Peek()
+}
+
+func heapPush(base, priority, value Value) {
+	h := asHeap(base)
+	trackGrowth(h.Len()+1, 2*valueBytes)
+	p := asFloat(priority)
+	if DefaultEngine.watching {
+		watchMutation(base, "HeapPush", priority, ValueNone, value)
+	}
+	h.Push(HeapItem{priority: p, value: value})
+}
+
+func heapPop(base Value) Value {
+	h := asHeap(base)
+	item := h.Pop()
+	if DefaultEngine.watching {
+		watchMutation(base, "HeapPop", ValueFloat(item.priority), item.value, ValueNone)
+	}
+	return item.value
+}
+
+// heapFromArray implements the heapFromArray(items) builtin: a new heap of
+// items, each a [priority, value] pair or a number that is its own
+// priority. Heapifying takes O(n), where n HeapPushes take O(n log n).
+func heapFromArray(items Value) Value {
+	src := *asArray(items)
+	trackGrowth(len(src), int64(len(src))*2*valueBytes)
+	h := &MinHeap{items: make([]HeapItem, len(src))}
+	for i, item := range src {
+		h.items[i] = heapItemOf(item, i)
+	}
+	h.heapify()
+	return Value{Type: TypeHeap, data: h}
+}
+
+func heapItemOf(item Value, i int) HeapItem {
+	switch item.Type {
+	case TypeInt, TypeFloat:
+		return HeapItem{priority: asFloat(item), value: item}
+	case TypeArray, TypeTuple:
+		if pair := iterItems(item); len(pair) == 2 {
+			return HeapItem{priority: asFloat(pair[0]), value: pair[1]}
+		}
+	}
+	panic(runtimeError(KindTypeError, "heapFromArray item %d must be a number or a [priority, value] pair, got %s", i, typeName(item)))
+}
+
+// heapPushPop implements the heapPushPop(heap, priority, value) builtin:
+// HeapPush then HeapPop in one sift. A value that would pop straight back
+// out is returned without touching the heap.
+func heapPushPop(base, priority, value Value) Value {
+	h := asHeap(base)
+	p := asFloat(priority)
+	if h.Len() == 0 || p < h.items[0].priority {
+		return value
+	}
+	return heapReplaceTop(base, h, "heapPushPop", HeapItem{priority: p, value: value})
+}
+
+// heapReplace implements the heapReplace(heap, priority, value) builtin:
+// HeapPop then HeapPush in one sift, so the heap never shrinks. The popped
+// value may be smaller than the pushed one.
+func heapReplace(base, priority, value Value) Value {
+	h := asHeap(base)
+	p := asFloat(priority)
+	if h.Len() == 0 {
+		panic(runtimeError(KindIndexError, "heap is empty"))
+	}
+	return heapReplaceTop(base, h, "heapReplace", HeapItem{priority: p, value: value})
+}
+
+func heapReplaceTop(base Value, h *MinHeap, op string, item HeapItem) Value {
+	top := h.items[0]
+	if DefaultEngine.watching {
+		watchMutation(base, op, ValueFloat(item.priority), top.value, item.value)
+	}
+	h.items[0] = item
+	h.siftDown(0)
+	return top.value
+}
+
+// heapToSortedArray implements the heapToSortedArray(heap) builtin: the
+// heap's values from lowest to highest priority. The heap is unchanged.
+func heapToSortedArray(base Value) Value {
+	h := asHeap(base)
+	trackGrowth(h.Len(), int64(h.Len())*valueBytes)
+	items := append([]HeapItem(nil), h.items...)
+	sort.SliceStable(items, func(i, j int) bool { return items[i].priority < items[j].priority })
+	values := make([]Value, len(items))
+	for i, item := range items {
+		values[i] = item.value
+	}
+	return ValueArray(values)
+}
+
+// ============================================================================
+// Math operations
+// ============================================================================
+
+func mathSin(v Value) Value { return ValueFloat(math.Sin(asFloat(v))) }
+func mathCos(v Value) Value { return ValueFloat(math.Cos(asFloat(v))) }
+func mathTan(v Value) Value { return ValueFloat(math.Tan(asFloat(v))) }
+func mathSqrt(v Value) Value {
+	x := asFloat(v)
+	mathDomainCheck("sqrt", !(x < 0))
+	return ValueFloat(math.Sqrt(x))
+}
+
+func mathLog(v Value) Value {
+	x := asFloat(v)
+	mathDomainCheck("log", math.IsNaN(x) || x > 0)
+	return ValueFloat(math.Log(x))
+}
+
+func mathExp(v Value) Value {
+	x := asFloat(v)
+	return ValueFloat(mathRangeCheck("exp", math.Exp(x), x))
+}
+
+// mathPow uses exact big.Float arithmetic for integral exponents so the
+// result is correctly rounded like C's pow; math.Pow can be off by an ulp.
+// Like Python's math.pow, 0 to a negative power and a negative base to a
+// fractional power are domain errors, and overflow is a range error.
+func mathPow(base, exp Value) Value {
+	b, e := asFloat(base), asFloat(exp)
+	finite := !math.IsInf(e, 0) && !math.IsNaN(e)
+	mathDomainCheck("pow", !finite || !(b == 0 && e < 0) && !(b < 0 && !math.IsInf(b, 0) && e != math.Trunc(e)))
+	if e != math.Trunc(e) || math.Abs(e) > 64 || b == 0 || math.IsInf(b, 0) || math.IsNaN(b) {
+		return ValueFloat(mathRangeCheck("pow", math.Pow(b, e), b, e))
+	}
+	n := int64(math.Abs(e))
+	result := new(big.Float).SetPrec(uint(53*n + 64)).SetInt64(1)
+	x := new(big.Float).SetPrec(result.Prec()).SetFloat64(b)
+	for ; n > 0; n >>= 1 {
+		if n&1 == 1 {
+			result.Mul(result, x)
+		}
+		x.Mul(x, x)
+	}
+	if e < 0 {
+		result.Quo(new(big.Float).SetPrec(result.Prec()).SetInt64(1), result)
+	}
+	f, _ := result.Float64()
+	return ValueFloat(mathRangeCheck("pow", f, b, e))
+}
+
+func mathAbs(v Value) Value {
+	switch v.Type {
+	case TypeInt:
+		n := v.intData()
+		if n < 0 {
+			return ValueInt(-n)
+		}
+		return v
+	case TypeFloat:
+		return ValueFloat(math.Abs(v.floatData()))
+	default:
+		panic(runtimeError(KindTypeError, "abs requires a number, got %s", typeName(v)))
+	}
+}
+
+// floatToIntValue converts an integral float to an int Value, rejecting
+// NaN and infinities the way Python's int() does. Values outside int64 raise
+// OverflowError; Python would return a big int, but wrapping is never right.
+func floatToIntValue(f float64, op string) Value {
+	if math.IsNaN(f) {
+		panic(runtimeError(KindValueError, "cannot convert float NaN to integer in %s", op))
+	}
+	if math.IsInf(f, 0) {
+		panic(runtimeError(KindOverflowError, "cannot convert float infinity to integer in %s", op))
+	}
+	// -2^63 is exact as a float64; 2^63 is the first value that does not fit
+	if f < -9223372036854775808.0 || f >= 9223372036854775808.0 {
+		panic(runtimeError(KindOverflowError, "integer overflow: %s result %s does not fit in 64 bits", op, formatValue(ValueFloat(f))))
+	}
+	return ValueInt(int64(f))
+}
+
+// Floor, ceil and trunc return ints like Python's math.floor/ceil/trunc.
+func mathFloor(v Value) Value {
+	if v.Type == TypeInt {
+		return v
+	}
+	return floatToIntValue(math.Floor(asFloat(v)), "floor")
+}
+
+func mathCeil(v Value) Value {
+	if v.Type == TypeInt {
+		return v
+	}
+	return floatToIntValue(math.Ceil(asFloat(v)), "ceil")
+}
+
+func mathTrunc(v Value) Value {
+	if v.Type == TypeInt {
+		return v
+	}
+	return floatToIntValue(math.Trunc(asFloat(v)), "trunc")
+}
+
+// mathRound matches Python's round(): half-to-even ("banker's") rounding.
+// With ndigits None the result is an int; otherwise the result keeps the
+// argument's type, rounded to ndigits decimal places (which may be negative).
+func mathRound(v, ndigits Value) Value {
+	if ndigits.Type == TypeNone {
+		if v.Type == TypeInt {
+			return v
+		}
+		return floatToIntValue(math.RoundToEven(asFloat(v)), "round")
+	}
+	n := asInt(ndigits)
+	if v.Type == TypeInt {
+		if n >= 0 {
+			return v
+		}
+		return ValueInt(roundIntHalfEven(v.intData(), -n))
+	}
+	f := asFloat(v)
+	if math.IsNaN(f) || math.IsInf(f, 0) || f == 0 {
+		return ValueFloat(f)
+	}
+	if n >= 0 {
+		if n > 330 {
+			return ValueFloat(f)
+		}
+		// FormatFloat rounds the exact binary value half-to-even, which is
+		// what CPython's correctly-rounded round() does as well.
+		r, _ := strconv.ParseFloat(strconv.FormatFloat(f, 'f', int(n), 64), 64)
+		return ValueFloat(math.Copysign(r, f))
+	}
+	if n < -308 {
+		return ValueFloat(math.Copysign(0, f))
+	}
+	scale := math.Pow(10, float64(-n))
+	return ValueFloat(math.Copysign(math.RoundToEven(f/scale)*scale, f))
+}
+
+// roundIntHalfEven rounds n to a multiple of 10^digits, ties to even.
+func roundIntHalfEven(n int64, digits int64) int64 {
+	if digits > 18 {
+		return 0
+	}
+	scale := int64(1)
+	for i := int64(0); i < digits; i++ {
+		scale *= 10
+	}
+	q, r := n/scale, n%scale
+	if r < 0 {
+		q--
+		r += scale
+	}
+	if 2*r > scale || (2*r == scale && q%2 != 0) {
+		q++
+	}
+	return q * scale
+}
+
+func mathPi() Value  { return ValueFloat(math.Pi) }
+func mathE() Value   { return ValueFloat(math.E) }
+func mathInf() Value { return ValueFloat(math.Inf(1)) }
+func mathNaN() Value { return ValueFloat(math.NaN()) }
+
+// mathDomainCheck raises Python's "math domain error" when ok is false.
+func mathDomainCheck(name string, ok bool) {
+	if !ok {
+		panic(runtimeError(KindValueError, "math domain error in %s", name))
+	}
+}
+
+// mathRangeCheck returns result, raising Python's "math range error" if it
+// overflowed to infinity from finite args.
+func mathRangeCheck(name string, result float64, args ...float64) float64 {
+	if !math.IsInf(result, 0) {
+		return result
+	}
+	for _, a := range args {
+		if math.IsInf(a, 0) || math.IsNaN(a) {
+			return result
+		}
+	}
+	panic(runtimeError(KindOverflowError, "math range error in %s", name))
+}
+
+func mathAsin(v Value) Value {
+	x := asFloat(v)
+	mathDomainCheck("asin", math.IsNaN(x) || (x >= -1 && x <= 1))
+	return ValueFloat(math.Asin(x))
+}
+
+func mathAcos(v Value) Value {
+	x := asFloat(v)
+	mathDomainCheck("acos", math.IsNaN(x) || (x >= -1 && x <= 1))
+	return ValueFloat(math.Acos(x))
+}
+
+func mathAtan(v Value) Value { return ValueFloat(math.Atan(asFloat(v))) }
+
+func mathAtan2(y, x Value) Value {
+	return ValueFloat(math.Atan2(asFloat(y), asFloat(x)))
+}
+
+func mathHypot(x, y Value) Value {
+	return ValueFloat(math.Hypot(asFloat(x), asFloat(y)))
+}
+
+func mathLog2(v Value) Value {
+	x := asFloat(v)
+	mathDomainCheck("log2", math.IsNaN(x) || x > 0)
+	return ValueFloat(math.Log2(x))
+}
+
+func mathLog10(v Value) Value {
+	x := asFloat(v)
+	mathDomainCheck("log10", math.IsNaN(x) || x > 0)
+	return ValueFloat(math.Log10(x))
+}
+
+func mathSinh(v Value) Value {
+	x := asFloat(v)
+	return ValueFloat(mathRangeCheck("sinh", math.Sinh(x), x))
+}
+
+func mathCosh(v Value) Value {
+	x := asFloat(v)
+	return ValueFloat(mathRangeCheck("cosh", math.Cosh(x), x))
+}
+
+func mathTanh(v Value) Value { return ValueFloat(math.Tanh(asFloat(v))) }
+
+// Predicates accept ints too; ints are always finite.
+func mathIsNaN(v Value) Value { return ValueBool(math.IsNaN(asFloat(v))) }
+func mathIsInf(v Value) Value { return ValueBool(math.IsInf(asFloat(v), 0)) }
+func mathIsFinite(v Value) Value {
+	f := asFloat(v)
+	return ValueBool(!math.IsNaN(f) && !math.IsInf(f, 0))
+}
+
+// ============================================================================
+// Type conversions
+// ============================================================================
+
+func valueToInt(v Value) Value {
+	switch v.Type {
+	case TypeInt:
+		return v
+	case TypeFloat:
+		return floatToIntValue(math.Trunc(v.floatData()), "int")
+	case TypeStr:
+		n, err := strconv.ParseInt(v.data.(string), 10, 64)
+		if err != nil {
+			panic(runtimeError(KindValueError, "cannot convert string '%s' to int", v.data.(string)))
+		}
+		return ValueInt(n)
+	default:
+		panic(runtimeError(KindTypeError, "cannot convert %s to int", typeName(v)))
+	}
+}
+
+func valueToFloat(v Value) Value {
+	switch v.Type {
+	case TypeFloat:
+		return v
+	case TypeInt:
+		return ValueFloat(float64(v.intData()))
+	case TypeStr:
+		f, err := strconv.ParseFloat(v.data.(string), 64)
+		if err != nil {
+			panic(runtimeError(KindValueError, "cannot convert string '%s' to float", v.data.(string)))
+		}
+		return ValueFloat(f)
+	default:
+		panic(runtimeError(KindTypeError, "cannot convert %s to float", typeName(v)))
+	}
+}
+
+func valueToStringConvert(v Value) Value {
+	return ValueStr(formatValue(v))
+}
+
+func stringFormat(parts ...Value) Value {
+	var sb strings.Builder
+	for _, part := range parts {
+		sb.WriteString(formatValue(part))
+	}
+	return ValueStr(sb.String())
+}
+
+// ============================================================================
+// JSON operations
+// ============================================================================
+
+func jsonConvertGoToValue(v interface{}) Value {
+	if v == nil {
+		return ValueNone
+	}
+	switch val := v.(type) {
+	case json.Number:
+		if n, err := val.Int64(); err == nil {
+			return ValueInt(n)
+		}
+		if f, err := val.Float64(); err == nil {
+			return ValueFloat(f)
+		}
+		return ValueStr(val.String())
+	case bool:
+		return ValueBool(val)
+	case string:
+		return ValueStr(val)
+	case []interface{}:
+		items := make([]Value, len(val))
+		for i, item := range val {
+			items[i] = jsonConvertGoToValue(item)
+		}
+		return ValueArray(items)
+	case map[string]interface{}:
+		om := NewOrderedMap()
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			om.Set(k, jsonConvertGoToValue(val[k]))
+		}
+		return Value{Type: TypeMap, data: om}
+	default:
+		return ValueStr(fmt.Sprintf("%v", v))
+	}
+}
+
+func jsonParse(source Value) Value {
+	s := asString(source)
+	decoder := json.NewDecoder(strings.NewReader(s))
+	decoder.UseNumber()
+	var result interface{}
+	if err := decoder.Decode(&result); err != nil {
+		panic(runtimeError(KindValueError, "invalid JSON: %s", err))
+	}
+	return jsonConvertGoToValue(result)
+}
+
+func jsonConvertValueToGo(v Value) interface{} {
+	switch v.Type {
+	case TypeNone:
+		return nil
+	case TypeBool:
+		return v.boolData()
+	case TypeInt:
+		return v.intData()
+	case TypeFloat:
+		return v.floatData()
+	case TypeStr:
+		return v.data.(string)
+	case TypeArray:
+		arr := v.data.(*Array).items
+		result := make([]interface{}, len(arr))
+		for i, item := range arr {
+			result[i] = jsonConvertValueToGo(item)
+		}
+		return result
+	case TypeMap:
+		om := v.data.(*OrderedMap)
+		result := make(map[string]interface{})
+		for _, k := range om.keys {
+			result[formatValue(om.keyValue(k))] = jsonConvertValueToGo(om.values[k])
+		}
+		return result
+	default:
+		return formatValue(v)
+	}
+}
+
+func jsonStringify(value Value, pretty Value) Value {
+	goVal := jsonConvertValueToGo(value)
+	var result []byte
+	var err error
+	if isTruthy(pretty) {
+		result, err = json.MarshalIndent(goVal, "", "  ")
+	} else {
+		result, err = json.Marshal(goVal)
+	}
+	if err != nil {
+		panic(runtimeError(KindTypeError, "cannot stringify to JSON: %s", err))
+	}
+	// Match Python's json.dumps default separators (", " and ": ")
+	if !isTruthy(pretty) {
+		s := string(result)
+		s = jsonAddPythonSpacing(s)
+		return ValueStr(s)
+	}
+	return ValueStr(string(result))
+}
+
+// jsonAddPythonSpacing adds spaces after : and , in JSON to match Python's
+// json.dumps default separators (", " and ": "). It respects string literals.
+func jsonAddPythonSpacing(s string) string {
+	var buf strings.Builder
+	inString := false
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if inString {
+			buf.WriteByte(ch)
+			if ch == '\\' && i+1 < len(s) {
+				i++
+				buf.WriteByte(s[i])
+			} else if ch == '"' {
+				inString = false
+			}
+		} else {
+			switch ch {
+			case '"':
+				inString = true
+				buf.WriteByte(ch)
+			case ',':
+				buf.WriteString(", ")
+			case ':':
+				buf.WriteString(": ")
+			default:
+				buf.WriteByte(ch)
+			}
+		}
+	}
+	return buf.String()
+}
+
+// ============================================================================
+// Regex operations
+// ============================================================================
+
+func regexCompileWithFlags(pattern, flags string) *regexp.Regexp {
+	p := pattern
+	if strings.Contains(flags, "i") {
+		p = "(?i)" + p
+	}
+	re, err := regexp.Compile(p)
+	if err != nil {
+		panic(runtimeError(KindValueError, "invalid regex pattern: %s", err))
+	}
+	return re
+}
+
+func regexMatch(str, pattern, flags Value) Value {
+	s := asString(str)
+	p := asString(pattern)
+	f := ""
+	if flags.Type != TypeNone {
+		f = asString(flags)
+	}
+	re := regexCompileWithFlags(p, f)
+	return ValueBool(re.MatchString(s))
+}
+
+func regexFindAll(str, pattern, flags Value) Value {
+	s := asString(str)
+	p := asString(pattern)
+	f := ""
+	if flags.Type != TypeNone {
+		f = asString(flags)
+	}
+	re := regexCompileWithFlags(p, f)
+	matches := re.FindAllString(s, -1)
+	if matches == nil {
+		return ValueArray(nil)
+	}
+	items := make([]Value, len(matches))
+	for i, m := range matches {
+		items[i] = ValueStr(m)
+	}
+	return ValueArray(items)
+}
+
+func regexReplace(str, pattern, replacement, flags Value) Value {
+	s := asString(str)
+	p := asString(pattern)
+	r := asString(replacement)
+	f := ""
+	if flags.Type != TypeNone {
+		f = asString(flags)
+	}
+	re := regexCompileWithFlags(p, f)
+	return ValueStr(re.ReplaceAllString(s, r))
+}
+
+func regexSplit(str, pattern, flags Value) Value {
+	s := asString(str)
+	p := asString(pattern)
+	f := ""
+	if flags.Type != TypeNone {
+		f = asString(flags)
+	}
+	re := regexCompileWithFlags(p, f)
+	parts := re.Split(s, -1)
+	items := make([]Value, len(parts))
+	for i, part := range parts {
+		items[i] = ValueStr(part)
+	}
+	return ValueArray(items)
+}
+
+// Ensure all imports are used
+var _ = sort.Strings
+var _ = regexp.Compile
+var _ = json.NewDecoder
+
+// ============================================================================
+// External calls and sandboxing
+// ============================================================================
+
+// Capability names a class of side effect a program may perform.
+type Capability string
+
+const (
+	CapIORead  Capability = "io.read"
+	CapIOWrite Capability = "io.write"
+	CapNet     Capability = "net"
+	CapExec    Capability = "exec"
+	CapEnv     Capability = "env"
+	CapNotify  Capability = "notify" // sending email and webhook messages
+)
+
+// SetSandbox restricts side-effecting builtins to the granted capabilities;
+// anything else fails with a runtime error. With no arguments every side
+// effect except program output is denied, which is how a new engine starts:
+// a program only gets the capabilities it was compiled with (see emit_go's
+// grants) or that its host grants.
+func (e *Engine) SetSandbox(granted ...Capability) {
+	e.sandboxed = true
+	e.granted = make(map[Capability]bool, len(granted))
+	for _, c := range granted {
+		e.granted[c] = true
+	}
+}
+
+// DisableSandbox lifts all capability checks, for hosts that trust the
+// program with every side effect.
+func (e *Engine) DisableSandbox() {
+	e.sandboxed = false
+	e.granted = nil
+}
+
+// DeterministicConfig pins the sources of nondeterminism the runtime
+// controls.
+type DeterministicConfig struct {
+	Seed  int64     // seed for the random.* builtins
+	Clock time.Time // time reported by time.time/now; zero means the Unix epoch
+	// SeededIDs draws uuid4, uuid7 and randomToken from the seeded PRNG
+	// and dates uuid7 by Clock. By default they stay truly random, since
+	// repeating identifiers across runs is rarely what a program wants.
+	SeededIDs bool
+}
+
+// SetDeterministic makes two runs of a program on the same inputs produce
+// byte-identical output: the PRNG is seeded from cfg, the clock is frozen,
+// and builtins whose results depend on the host (environment, working
+// directory, network, subprocesses) are refused. Iteration order needs no
+// change: maps keep insertion order and sets are always sorted. uuid4,
+// uuid7 and randomToken stay random unless cfg.SeededIDs is set. A nil cfg
+// turns the mode off and reseeds the PRNG from the clock.
+func (e *Engine) SetDeterministic(cfg *DeterministicConfig) {
+	e.deterministic = cfg
+	seed := time.Now().UnixNano()
+	if cfg != nil {
+		seed = cfg.Seed
+	}
+	e.rng = rand.New(rand.NewSource(seed))
+}
+
+// SetStrict chooses how values of the wrong type are used where an int is
+// needed, as an index, count, size or range bound. Lenient mode, the
+// default, truncates floats toward zero and counts True and False as 1 and
+// 0. Strict mode raises a TypeError instead, so a computed 2.7 cannot
+// quietly become 2; convert explicitly with int(), floor() or round().
+func (e *Engine) SetStrict(strict bool) {
+	e.strict = strict
+}
+
+// SetCheckedArithmetic makes int addition, subtraction and multiplication
+// raise OverflowError when the result does not fit in 64 bits, instead of
+// silently wrapping around as Go does; the interpreter's Python ints never
+// overflow, so a wrapped result is always wrong. Variables the compiler
+// stores as unboxed ints are checked only in programs compiled with
+// checked=True, which also turns this on.
+func (e *Engine) SetCheckedArithmetic(checked bool) {
+	e.checked = checked
+}
+
+// DivisionMode is how the % operator and the intDiv builtin round a
+// quotient whose operands have different signs.
+type DivisionMode string
+
+const (
+	// DivisionFloor rounds toward negative infinity, as Python does:
+	// intDiv(-7, 2) is -4 and -7 % 2 is 1, taking the divisor's sign.
+	DivisionFloor DivisionMode = "floor"
+	// DivisionTruncate rounds toward zero, as C, Java and Go do:
+	// intDiv(-7, 2) is -3 and -7 % 2 is -1, taking the dividend's sign.
+	DivisionTruncate DivisionMode = "truncate"
+)
+
+// SetDivision chooses the division semantics of the program, declared by
+// the "division" field of its Core IL document. In both modes
+// intDiv(a, b)*b + a%b == a; true division with / is unaffected. The empty
+// mode is DivisionFloor.
+func (e *Engine) SetDivision(mode DivisionMode) {
+	switch mode {
+	case "", DivisionFloor, DivisionTruncate:
+		e.division = mode
+	default:
+		panic(runtimeError(KindValueError, "unknown division mode %s (use 'floor' or 'truncate')", reprString(string(mode))))
+	}
+}
+
+// AuditEvent describes one side-effecting operation a program performed.
+type AuditEvent struct {
+	Op         string // builtin name, e.g. "fs.writeFile"
+	Capability Capability
+	Args       []string // repr of each argument
+	Loc        SourceLocation
+}
+
+// SetAuditSink makes the engine report every file access, network call,
+// subprocess and environment read to sink just before it happens, so
+// operators can review what a program actually did. Operations refused by
+// the sandbox or deterministic mode never run and are not reported. A nil
+// sink disables auditing.
+func (e *Engine) SetAuditSink(sink func(AuditEvent)) {
+	e.audit = sink
+}
+
+// Allowed reports whether the engine permits side effects of kind c.
+func (e *Engine) Allowed(c Capability) bool {
+	return !e.sandboxed || e.granted[c]
+}
+
+func requireCapability(c Capability, op string) {
+	if !DefaultEngine.Allowed(c) {
+		panic(runtimeError(KindPermissionError, "sandbox denies %s for %s", c, op))
+	}
+}
+
+// externalFunc is an ExternalCall builtin and the capability it requires
+// ("" for none).
+type externalFunc struct {
+	cap   Capability
+	arity int
+	fn    func(args []Value) Value
+}
+
+func externalNow(args []Value) Value {
+	now := time.Now()
+	if cfg := DefaultEngine.deterministic; cfg != nil {
+		now = cfg.Clock
+		if now.IsZero() {
+			now = time.Unix(0, 0)
+		}
+	}
+	return ValueFloat(float64(now.UnixNano()) / 1e9)
+}
+
+// hostDependent lists builtins whose results depend on the host rather than
+// on the program and its inputs; deterministic mode refuses them.
+var hostDependent = map[string]bool{
+	"os.getenv":      true,
+	"os.env":         true,
+	"os.getcwd":      true,
+	"os.cwd":         true,
+	"os.system":      true,
+	"http.get":       true,
+	"http.getAuth":   true,
+	"email.send":     true,
+	"notify.webhook": true,
+}
+
+func externalGetenv(args []Value) Value {
+	if v, ok := os.LookupEnv(asString(args[0])); ok {
+		return ValueStr(v)
+	}
+	return ValueNone
+}
+
+func externalGetcwd(args []Value) Value {
+	dir, err := os.Getwd()
+	if err != nil {
+		panic(runtimeError(KindIOError, "%s", err))
+	}
+	return ValueStr(dir)
+}
+
+// httpGet implements http.get and http.getAuth. The URL and the
+// authorization may be secrets. An authorization without a scheme, such as
+// a bare API token, is sent as a bearer token.
+func httpGet(url, authorization Value) Value {
+	e := DefaultEngine
+	req, err := http.NewRequestWithContext(e.context(), "GET", secretText(url, "http.get"), nil)
+	if err != nil {
+		panic(runtimeError(KindIOError, "%s", e.redact(err.Error())))
+	}
+	if authorization.Type != TypeNone {
+		auth := secretText(authorization, "http.getAuth")
+		if !strings.Contains(auth, " ") {
+			auth = "Bearer " + auth
+		}
+		req.Header.Set("Authorization", auth)
+	}
+	var body []byte
+	e.blocking(func() {
+		var resp *http.Response
+		if resp, err = http.DefaultClient.Do(req); err == nil {
+			body, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+	})
+	if err != nil {
+		e.checkContext()
+		panic(runtimeError(KindIOError, "%s", e.redact(err.Error())))
+	}
+	return ValueStr(string(body))
+}
+
+var externalFuncs = map[string]externalFunc{
+	"time.time": {"", 0, externalNow},
+	"time.now":  {"", 0, externalNow},
+	"time.sleep": {"", 1, func(args []Value) Value {
+		t := time.NewTimer(time.Duration(asFloat(args[0]) * float64(time.Second)))
+		defer t.Stop()
+		done := DefaultEngine.context().Done()
+		DefaultEngine.blocking(func() {
+			select {
+			case <-t.C:
+			case <-done:
+			}
+		})
+		DefaultEngine.checkContext()
+		return ValueNone
+	}},
+	"os.getenv": {CapEnv, 1, externalGetenv},
+	"os.env":    {CapEnv, 1, externalGetenv},
+	"os.getcwd": {CapIORead, 0, externalGetcwd},
+	"os.cwd":    {CapIORead, 0, externalGetcwd},
+	"os.system": {CapExec, 1, func(args []Value) Value {
+		DefaultEngine.Flush()
+		cmd := exec.CommandContext(DefaultEngine.context(), "sh", "-c", asString(args[0]))
+		cmd.Stdout, cmd.Stderr = DefaultEngine.meter, DefaultEngine.errOut
+		if DefaultEngine.recorder != nil || DefaultEngine.checkpoints != nil {
+			cmd.Stdout = io.MultiWriter(DefaultEngine.meter, &DefaultEngine.recordOut)
+		}
+		err := cmd.Run()
+		DefaultEngine.checkContext()
+		if err != nil {
+			if exit, ok := err.(*exec.ExitError); ok {
+				return ValueInt(int64(exit.ExitCode()))
+			}
+			panic(runtimeError(KindIOError, "%s", err))
+		}
+		return ValueInt(0)
+	}},
+	"os.exit": {"", 1, func(args []Value) Value {
+		DefaultEngine.Flush()
+		DefaultEngine.endRun(nil)
+		os.Exit(int(asInt(args[0])))
+		return ValueNone
+	}},
+	"fs.readFile": {CapIORead, 1, func(args []Value) Value {
+		data, err := os.ReadFile(asString(args[0]))
+		if err != nil {
+			panic(runtimeError(KindIOError, "%s", err))
+		}
+		return ValueStr(string(data))
+	}},
+	"fs.writeFile": {CapIOWrite, 2, func(args []Value) Value {
+		if err := os.WriteFile(asString(args[0]), []byte(asString(args[1])), 0o644); err != nil {
+			panic(runtimeError(KindIOError, "%s", err))
+		}
+		return ValueNone
+	}},
+	"fs.exists": {CapIORead, 1, func(args []Value) Value {
+		_, err := os.Stat(asString(args[0]))
+		return ValueBool(err == nil)
+	}},
+	"http.get": {CapNet, 1, func(args []Value) Value {
+		return httpGet(args[0], ValueNone)
+	}},
+	"http.getAuth": {CapNet, 2, func(args []Value) Value {
+		return httpGet(args[0], args[1])
+	}},
+	"email.send":     {CapNotify, 3, externalSendEmail},
+	"notify.webhook": {CapNotify, 2, externalWebhook},
+	"random.random": {"", 0, func(args []Value) Value {
+		return ValueFloat(DefaultEngine.rng.Float64())
+	}},
+	"random.randint": {"", 2, func(args []Value) Value {
+		lo, hi := asInt(args[0]), asInt(args[1])
+		if hi < lo {
+			panic(runtimeError(KindValueError, "empty range for randint(%d, %d)", lo, hi))
+		}
+		return ValueInt(randInt(DefaultEngine.rng, lo, hi))
+	}},
+	"random.choice": {"", 1, func(args []Value) Value {
+		items := *asArray(args[0])
+		if len(items) == 0 {
+			panic(runtimeError(KindIndexError, "cannot choose from an empty array"))
+		}
+		return items[DefaultEngine.rng.Intn(len(items))]
+	}},
+	"random.seed": {"", 1, func(args []Value) Value {
+		DefaultEngine.rng = rand.New(rand.NewSource(asInt(args[0])))
+		return ValueNone
+	}},
+	"crypto.hash": {"", 1, func(args []Value) Value {
+		sum := sha256.Sum256([]byte(asString(args[0])))
+		return ValueStr(hex.EncodeToString(sum[:]))
+	}},
+}
+
+// randInt returns a uniform int in [lo, hi]. The span is computed in uint64
+// so ranges wider than MaxInt64, up to the full int64 range, do not overflow.
+func randInt(rng *rand.Rand, lo, hi int64) int64 {
+	span := uint64(hi) - uint64(lo) + 1
+	switch {
+	case span == 0:
+		// [MinInt64, MaxInt64]: every 64-bit pattern is a valid result
+		return int64(rng.Uint64())
+	case span <= math.MaxInt64:
+		return lo + rng.Int63n(int64(span))
+	}
+	// More than half of all values are in range, so rejection ends quickly
+	for {
+		if v := rng.Uint64(); v < span {
+			return int64(uint64(lo) + v)
+		}
+	}
+}
+
+// externalCall implements ExternalCall: it checks the builtin's capability
+// against the engine sandbox before running it.
+func externalCall(module, function string, args ...Value) Value {
+	name := module + "." + function
+	if c := DefaultEngine.remote; c != nil && name != "os.exit" {
+		return c.call(name, args)
+	}
+	DefaultEngine.checkContext()
+	f, ok := externalFuncs[name]
+	if !ok {
+		panic(runtimeError(KindRuntimeError, "ExternalCall to %s is not supported in Go backend", name))
+	}
+	if len(args) != f.arity {
+		panic(runtimeError(KindTypeError, "%s expects %d arguments, got %d", name, f.arity, len(args)))
+	}
+	if f.cap != "" {
+		requireCapability(f.cap, name)
+	}
+	if DefaultEngine.deterministic != nil && hostDependent[name] {
+		panic(runtimeError(KindPermissionError, "deterministic mode refuses %s", name))
+	}
+	if e := DefaultEngine; e.audit != nil && f.cap != "" {
+		reprs := make([]string, len(args))
+		for i, a := range args {
+			reprs[i] = e.redact(reprValue(a))
+		}
+		e.audit(AuditEvent{Op: name, Capability: f.cap, Args: reprs, Loc: e.loc})
+	}
+	if e := DefaultEngine; e.metrics != nil {
+		e.count("coreil.builtin.calls", 1, "builtin", name)
+	}
+	if e := DefaultEngine; e.tracing != nil && f.cap != "" {
+		return e.traceExternal(name, f, args)
+	}
+	return performExternal(name, f, args)
+}
+
+// performExternal runs a builtin, or answers it from the record/replay
+// trace.
+func performExternal(name string, f externalFunc, args []Value) Value {
+	if e := DefaultEngine; !unrecorded[name] {
+		if e.replaying {
+			return e.replayCall(name, args)
+		}
+		if e.recorder != nil || e.checkpoints != nil {
+			return e.recordCall(name, f, args)
+		}
+	}
+	return f.fn(args)
+}
+
+// ============================================================================
+// Out-of-process isolation
+// ============================================================================
+
+// Isolation sets OS resource limits for RunIsolated. Zero fields leave the
+// corresponding limit unset.
+type Isolation struct {
+	CPUSeconds  int   // RLIMIT_CPU
+	MemoryBytes int64 // RLIMIT_AS; the Go runtime alone reserves a few hundred MB
+	OpenFiles   int   // RLIMIT_NOFILE; the child needs at least 5
+}
+
+// isolatedEnv marks the re-executed child and carries the engine's Limits.
+const isolatedEnv = "COREIL_ISOLATED_LIMITS"
+
+// RunIsolated is Run in a separate OS process. The program binary is
+// re-executed with iso's resource limits applied, and every ExternalCall
+// the child makes is sent back over a pipe and performed by this engine, so
+// the sandbox, audit sink and deterministic mode apply unchanged. Output
+// goes to the engine's writers, Limits and the context carry over, and a
+// *LimitExceeded or *Cancelled is returned as from Run; a child that
+// crashes or calls os.exit with a nonzero status yields an *exec.ExitError.
+//
+// The child runs the binary from the start, so call RunIsolated from main
+// before any other side effects. Limits are applied with ulimit and need a
+// POSIX sh; seccomp filtering is not provided.
+func (e *Engine) RunIsolated(fn func(), iso Isolation) error {
+	if spec, ok := os.LookupEnv(isolatedEnv); ok {
+		e.runIsolatedChild(fn, spec)
+	}
+	e.Flush()
+	e.meter.reset()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	script := `exec "$0" "$@"`
+	if iso.OpenFiles > 0 {
+		script = fmt.Sprintf("ulimit -n %d && %s", iso.OpenFiles, script)
+	}
+	if iso.MemoryBytes > 0 {
+		script = fmt.Sprintf("ulimit -v %d && %s", iso.MemoryBytes/1024, script)
+	}
+	if iso.CPUSeconds > 0 {
+		script = fmt.Sprintf("ulimit -t %d && %s", iso.CPUSeconds, script)
+	}
+	ctx := e.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	spec, _ := json.Marshal(e.limits)
+	cmd := exec.CommandContext(ctx, "sh", append([]string{"-c", script, exe}, os.Args[1:]...)...)
+	cmd.Env = append(os.Environ(), isolatedEnv+"="+string(spec))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, e.meter, e.errOut
+
+	// The child reads replies on fd 3 and writes calls on fd 4.
+	childIn, toChild, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	fromChild, childOut, err := os.Pipe()
+	if err != nil {
+		childIn.Close()
+		toChild.Close()
+		return err
+	}
+	cmd.ExtraFiles = []*os.File{childIn, childOut}
+	err = cmd.Start()
+	childIn.Close()
+	childOut.Close()
+	if err != nil {
+		toChild.Close()
+		fromChild.Close()
+		return err
+	}
+
+	conn := newIsolatedConn(fromChild, toChild)
+	var done *isolatedMsg
+	for {
+		var msg isolatedMsg
+		if conn.dec.Decode(&msg) != nil {
+			break
+		}
+		if msg.Call == "" {
+			done = &msg
+			break
+		}
+		conn.enc.Encode(e.serveIsolatedCall(msg))
+	}
+	toChild.Close()
+	fromChild.Close()
+	waitErr := cmd.Wait()
+
+	switch {
+	case ctx.Err() != nil:
+		return e.contextError(ctx.Err())
+	case done != nil && done.Limit != "":
+		return &LimitExceeded{Limit: done.Limit, Max: done.Max, Loc: done.Loc}
+	case done != nil && done.Cancelled != "":
+		// The child's output went through this engine's meter
+		cause := errors.New(done.Cancelled)
+		if done.Cancelled == context.Canceled.Error() {
+			cause = context.Canceled
+		}
+		return &Cancelled{Err: cause, Loc: done.Loc, Output: e.meter.summary()}
+	}
+	return waitErr
+}
+
+// runIsolatedChild runs fn on the child side of RunIsolated and exits.
+func (e *Engine) runIsolatedChild(fn func(), spec string) {
+	var limits Limits
+	json.Unmarshal([]byte(spec), &limits)
+	e.SetLimits(limits)
+	e.remote = newIsolatedConn(os.NewFile(3, "coreil-rpc-in"), os.NewFile(4, "coreil-rpc-out"))
+	var done isolatedMsg
+	switch err := e.Run(fn).(type) {
+	case *LimitExceeded:
+		done = isolatedMsg{Limit: err.Limit, Max: err.Max, Loc: err.Loc}
+	case *Cancelled:
+		done = isolatedMsg{Cancelled: err.Err.Error(), Loc: err.Loc}
+	}
+	e.remote.enc.Encode(done)
+	os.Exit(0)
+}
+
+// serveIsolatedCall performs a child's ExternalCall on the host, turning a
+// runtime error into an Error reply the child re-raises.
+func (e *Engine) serveIsolatedCall(msg isolatedMsg) (reply isolatedMsg) {
+	defer func() {
+		if r := recover(); r != nil {
+			reply = isolatedMsg{Error: fmt.Sprint(r), Kind: ErrorKindOf(r)}
+		}
+	}()
+	args := make([]Value, len(msg.Args))
+	for i, a := range msg.Args {
+		args[i] = fromWire(a)
+	}
+	e.loc = msg.Loc
+	module, function, _ := strings.Cut(msg.Call, ".")
+	return isolatedMsg{Result: toWire(externalCall(module, function, args...))}
+}
+
+// isolatedMsg is one JSON line of the RunIsolated protocol. The child sends
+// calls (Call, Args, Loc) and finally a done message with an empty Call and,
+// if it stopped on a limit, Limit/Max/Loc, or if its context was cancelled,
+// Cancelled (the context error) and Loc; the host answers each call with
+// Result or Error and its Kind.
+type isolatedMsg struct {
+	Call      string         `json:"call,omitempty"`
+	Args      []interface{}  `json:"args,omitempty"`
+	Result    interface{}    `json:"result,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	Kind      ErrorKind      `json:"kind,omitempty"`
+	Limit     string         `json:"limit,omitempty"`
+	Max       int64          `json:"max,omitempty"`
+	Cancelled string         `json:"cancelled,omitempty"`
+	Loc       SourceLocation `json:"loc"`
+}
+
+type isolatedConn struct {
+	dec *json.Decoder
+	enc *json.Encoder
+}
+
+func newIsolatedConn(r io.Reader, w io.Writer) *isolatedConn {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return &isolatedConn{dec: dec, enc: json.NewEncoder(w)}
+}
+
+// call forwards an ExternalCall from the child to the host.
+func (c *isolatedConn) call(name string, args []Value) Value {
+	wire := make([]interface{}, len(args))
+	for i, a := range args {
+		wire[i] = toWire(a)
+	}
+	var reply isolatedMsg
+	if err := c.enc.Encode(isolatedMsg{Call: name, Args: wire, Loc: DefaultEngine.loc}); err != nil {
+		panic(runtimeError(KindIOError, "%s: lost connection to host: %v", name, err))
+	}
+	if err := c.dec.Decode(&reply); err != nil {
+		panic(runtimeError(KindIOError, "%s: lost connection to host: %v", name, err))
+	}
+	if reply.Error != "" {
+		panic(&CoreILError{Kind: reply.Kind, Message: reply.Error})
+	}
+	return fromWire(reply.Result)
+}
+
+// toWire encodes a Value as JSON-compatible data. Strings, bools and None
+// map directly; other types are tagged objects so ints, floats, tuples,
+// sets and secrets survive the round trip and map order is kept.
+func toWire(v Value) interface{} {
+	switch v.Type {
+	case TypeNone:
+		return nil
+	case TypeBool:
+		return v.boolData()
+	case TypeStr:
+		return asString(v)
+	case TypeSecret:
+		return map[string]string{"secret": v.data.(*Secret).value}
+	case TypeInt:
+		return map[string]string{"int": strconv.FormatInt(v.intData(), 10)}
+	case TypeFloat:
+		return map[string]string{"float": strconv.FormatFloat(v.floatData(), 'g', -1, 64)}
+	case TypeArray, TypeTuple, TypeSet:
+		items := iterItems(v)
+		wire := make([]interface{}, len(items))
+		for i, item := range items {
+			wire[i] = toWire(item)
+		}
+		switch v.Type {
+		case TypeTuple:
+			return map[string]interface{}{"tuple": wire}
+		case TypeSet:
+			return map[string]interface{}{"set": wire}
+		}
+		return wire
+	case TypeMap:
+		m := asMap(v)
+		pairs := make([][2]interface{}, 0, len(m.keys))
+		for _, k := range m.keys {
+			pairs = append(pairs, [2]interface{}{toWire(m.keyValue(k)), toWire(m.values[k])})
+		}
+		return map[string]interface{}{"map": pairs}
+	}
+	panic(runtimeError(KindTypeError, "cannot pass %s across the isolation boundary", typeName(v)))
+}
+
+// fromWire decodes data produced by toWire.
+func fromWire(x interface{}) Value {
+	switch x := x.(type) {
+	case nil:
+		return ValueNone
+	case bool:
+		return ValueBool(x)
+	case string:
+		return ValueStr(x)
+	case []interface{}:
+		return ValueArray(fromWireItems(x))
+	case map[string]interface{}:
+		for tag, body := range x {
+			switch tag {
+			case "int":
+				n, _ := strconv.ParseInt(body.(string), 10, 64)
+				return ValueInt(n)
+			case "float":
+				f, _ := strconv.ParseFloat(body.(string), 64)
+				return ValueFloat(f)
+			case "tuple":
+				return ValueTupleNew(fromWireItems(body.([]interface{})))
+			case "set":
+				return ValueSetNew(fromWireItems(body.([]interface{})))
+			case "secret":
+				return newSecret(body.(string))
+			case "map":
+				m := ValueMapEmpty()
+				for _, pair := range body.([]interface{}) {
+					kv := pair.([]interface{})
+					asMap(m).SetValue(fromWire(kv[0]), fromWire(kv[1]))
+				}
+				return m
+			}
+		}
+	}
+	panic(runtimeError(KindIOError, "malformed isolation message: %v", x))
+}
+
+func fromWireItems(wire []interface{}) []Value {
+	items := make([]Value, len(wire))
+	for i, w := range wire {
+		items[i] = fromWire(w)
+	}
+	return items
+}
+
+// coreilRunIsolated runs a compiled program's body through
+// DefaultEngine.RunIsolated, exiting with the child's status if it fails.
+func coreilRunIsolated(body func()) {
+	err := DefaultEngine.RunIsolated(body, Isolation{})
+	if err == nil {
+		return
+	}
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		os.Exit(exit.ExitCode())
+	}
+	fmt.Fprintln(DefaultEngine.errOut, err)
+	os.Exit(1)
+}
+
+// ============================================================================
+// Debugger
+// ============================================================================
+
+// Breakpoint stops a program compiled with debug=True before a statement.
+// Set one field: Function stops at the first statement of each call to that
+// IL function, IL at each execution of the statement with that path (e.g.
+// "$.body[3].then[0]"), and Sentence or Line when execution reaches the
+// statements compiled from that English sentence, or from a sentence
+// starting on that English line.
+type Breakpoint struct {
+	Function string
+	IL       string
+	Sentence int
+	Line     int
+}
+
+// matches reports whether the breakpoint applies to the statement at loc,
+// given the previous statement run in the same frame and the function just
+// entered, if any. Sentence and line breakpoints fire on arrival only, not
+// for every statement of a sentence.
+func (b Breakpoint) matches(loc, last SourceLocation, entered string) bool {
+	switch {
+	case b.Function != "":
+		return b.Function == entered
+	case b.IL != "":
+		return b.IL == loc.IL
+	case b.Sentence > 0:
+		return loc.SentenceID == b.Sentence && last.SentenceID != b.Sentence
+	case b.Line > 0:
+		return loc.Line == b.Line && last.Line != b.Line
+	}
+	return false
+}
+
+// StepMode tells a stopped program how to resume.
+type StepMode int
+
+const (
+	StepContinue StepMode = iota // run to the next breakpoint
+	StepOver                     // stop at the next statement in this or a calling frame
+	StepInto                     // stop at the next statement, entering calls
+	StepOut                      // stop at the next statement in a calling frame
+)
+
+// Stop describes where a debugged program stopped. Reason is "breakpoint",
+// "step", "pause" or, for the first stop of a StopOnEntry debugger, "entry".
+type Stop struct {
+	Reason string
+	Frames []DebugFrame // innermost first; the last is the top level
+}
+
+// DebugFrame is one IL call frame of a stopped program.
+type DebugFrame struct {
+	Function string // "" for the top level
+	Loc      SourceLocation
+	Vars     []DebugVar // in order of first assignment
+}
+
+// DebugVar is a variable visible to the debugger; Value is live, so render
+// it (e.g. with reprValue) before resuming the program.
+type DebugVar struct {
+	Name  string
+	Value Value
+}
+
+// Debugger controls a program compiled with debug=True; attach it with
+// Engine.SetDebugger. OnStop is called on the program's goroutine each time
+// it stops, and the program resumes as the returned StepMode requests.
+// SetBreakpoints and Pause may be called from any goroutine.
+type Debugger struct {
+	OnStop      func(Stop) StepMode
+	StopOnEntry bool
+
+	mu          sync.Mutex
+	breakpoints []Breakpoint
+	pause       atomic.Bool
+
+	// Program goroutine only.
+	mode    StepMode
+	depth   int // call depth when the step began
+	stops   int
+	entered string
+	frames  []debugFrame // indexed by call depth
+}
+
+type debugFrame struct {
+	vars []DebugVar
+	last SourceLocation // previous statement run in this frame
+}
+
+// SetDebugger attaches d to the engine, or detaches the current debugger if
+// d is nil. Programs compiled without debug=True never stop.
+func (e *Engine) SetDebugger(d *Debugger) {
+	e.debugger = d
+	if d == nil {
+		return
+	}
+	d.frames = make([]debugFrame, len(e.calls)+1)
+	if d.StopOnEntry {
+		d.mode = StepInto
+	}
+}
+
+// SetBreakpoints replaces the debugger's breakpoints.
+func (d *Debugger) SetBreakpoints(bps []Breakpoint) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.breakpoints = append([]Breakpoint(nil), bps...)
+}
+
+// Pause stops the program at its next statement.
+func (d *Debugger) Pause() {
+	d.pause.Store(true)
+}
+
+func (d *Debugger) hit(loc, last SourceLocation, entered string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, b := range d.breakpoints {
+		if b.matches(loc, last, entered) {
+			return true
+		}
+	}
+	return false
+}
+
+// statement runs before each statement and stops if a pause, step or
+// breakpoint requires it.
+func (d *Debugger) statement(e *Engine) {
+	depth := len(e.calls)
+	frame := &d.frames[depth]
+	last := frame.last
+	frame.last = e.loc
+	entered := d.entered
+	d.entered = ""
+	reason := ""
+	switch {
+	case d.pause.CompareAndSwap(true, false):
+		reason = "pause"
+	case d.mode == StepInto,
+		d.mode == StepOver && depth <= d.depth,
+		d.mode == StepOut && depth < d.depth:
+		reason = "step"
+		if d.stops == 0 {
+			reason = "entry"
+		}
+	case d.hit(e.loc, last, entered):
+		reason = "breakpoint"
+	}
+	if reason == "" {
+		return
+	}
+	d.stops++
+	d.mode = d.OnStop(Stop{Reason: reason, Frames: d.stack(e)})
+	d.depth = depth
+}
+
+// stack snapshots the call frames, innermost first.
+func (d *Debugger) stack(e *Engine) []DebugFrame {
+	frames := make([]DebugFrame, 0, len(e.calls)+1)
+	for k := len(e.calls); k >= 0; k-- {
+		f := DebugFrame{Loc: e.loc, Vars: append([]DebugVar(nil), d.frames[k].vars...)}
+		if k < len(e.calls) {
+			f.Loc = e.callers[k]
+		}
+		if k > 0 {
+			f.Function = e.calls[k-1]
+		}
+		frames = append(frames, f)
+	}
+	return frames
+}
+
+func (d *Debugger) setVar(name string, v Value) {
+	frame := &d.frames[len(d.frames)-1]
+	for i := range frame.vars {
+		if frame.vars[i].Name == name {
+			frame.vars[i].Value = v
+			return
+		}
+	}
+	frame.vars = append(frame.vars, DebugVar{name, v})
+}
+
+// enter, leave and tail keep one variable frame per IL call frame.
+func (d *Debugger) enter(e *Engine) {
+	d.frames = append(d.frames[:len(e.calls)], debugFrame{})
+	d.entered = e.calls[len(e.calls)-1]
+}
+
+func (d *Debugger) leave(e *Engine) {
+	d.frames = d.frames[:len(e.calls)+1]
+}
+
+func (d *Debugger) tail(e *Engine) {
+	d.frames[len(e.calls)] = debugFrame{}
+	d.entered = e.calls[len(e.calls)-1]
+}
+
+// coreilDebug marks the start of a statement in programs compiled with
+// debug=True: it records the location like coreilCover and gives an
+// attached debugger the chance to stop.
+func coreilDebug(i int) {
+	e := DefaultEngine
+	coreilCover(i)
+	if e.debugger != nil {
+		e.debugger.statement(e)
+	}
+}
+
+// coreilDebugVar reports a variable's new value to an attached debugger and
+// to any watchpoint on the variable.
+func coreilDebugVar(name string, v Value) {
+	e := DefaultEngine
+	if e.debugger != nil {
+		e.debugger.setVar(name, v)
+	}
+	if e.watching {
+		e.watchVariable(name, v)
+	}
+}
+
+// ============================================================================
+// Debug Adapter Protocol server
+// ============================================================================
+
+// ServeDAP listens on addr (e.g. "127.0.0.1:4711"; port 0 picks one, and the
+// address is printed to stderr) for a Debug Adapter Protocol client such as
+// VS Code, attaches a debugger driven by it, and returns once the client has
+// sent configurationDone, so the program starts with its breakpoints set.
+//
+// Source breakpoints are English lines; function breakpoints name an IL
+// function, an IL statement path ("$.body[3]") or an English sentence
+// ("sentence 12"). The program is the single thread, and each frame has one
+// scope of variables rendered with reprValue.
+func (e *Engine) ServeDAP(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.errOut, "debugger: listening on %s\n", ln.Addr())
+	conn, err := ln.Accept()
+	ln.Close()
+	if err != nil {
+		return err
+	}
+	s := &dapSession{
+		e:          e,
+		conn:       conn,
+		configured: make(chan struct{}),
+		done:       make(chan struct{}),
+		resume:     make(chan StepMode),
+	}
+	s.d = &Debugger{OnStop: s.onStop}
+	e.dap = s
+	go s.serve()
+	<-s.configured
+	e.SetDebugger(s.d)
+	return nil
+}
+
+// dapSession serves one DAP client for ServeDAP.
+type dapSession struct {
+	e    *Engine
+	d    *Debugger
+	conn net.Conn
+
+	wmu sync.Mutex // guards writes to conn and seq
+	seq int
+
+	configured     chan struct{} // closed by configurationDone or disconnect
+	configuredOnce sync.Once
+	done           chan struct{} // closed when the client detaches
+	doneOnce       sync.Once
+	resume         chan StepMode
+
+	mu     sync.Mutex // guards the fields below
+	stop   *Stop      // nil while the program runs
+	source string     // English source path, echoed in stack frames
+	lines  []Breakpoint
+	funcs  []Breakpoint
+}
+
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+func (s *dapSession) serve() {
+	r := bufio.NewReader(s.conn)
+	for {
+		req, err := readDAPMessage(r)
+		if err != nil {
+			s.detach()
+			return
+		}
+		if !s.handle(req) {
+			return
+		}
+	}
+}
+
+// readDAPMessage reads one Content-Length framed message.
+func readDAPMessage(r *bufio.Reader) (dapRequest, error) {
+	var req dapRequest
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return req, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Content-Length") {
+			length, _ = strconv.Atoi(strings.TrimSpace(value))
+		}
+	}
+	if length < 0 {
+		return req, errors.New("DAP message without Content-Length")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return req, err
+	}
+	err := json.Unmarshal(body, &req)
+	return req, err
+}
+
+func (s *dapSession) send(msg map[string]interface{}) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.seq++
+	msg["seq"] = s.seq
+	body, _ := json.Marshal(msg)
+	fmt.Fprintf(s.conn, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (s *dapSession) respond(req dapRequest, body interface{}, err error) {
+	msg := map[string]interface{}{
+		"type":        "response",
+		"request_seq": req.Seq,
+		"command":     req.Command,
+		"success":     err == nil,
+	}
+	if err != nil {
+		msg["message"] = err.Error()
+	} else if body != nil {
+		msg["body"] = body
+	}
+	s.send(msg)
+}
+
+func (s *dapSession) event(name string, body interface{}) {
+	msg := map[string]interface{}{"type": "event", "event": name}
+	if body != nil {
+		msg["body"] = body
+	}
+	s.send(msg)
+}
+
+// handle answers one request, returning false once the client disconnects.
+func (s *dapSession) handle(req dapRequest) bool {
+	var args struct {
+		Source struct {
+			Path string `json:"path"`
+		} `json:"source"`
+		Breakpoints []struct {
+			Line int    `json:"line"`
+			Name string `json:"name"`
+		} `json:"breakpoints"`
+		StopOnEntry       bool   `json:"stopOnEntry"`
+		Program           string `json:"program"`
+		FrameID           int    `json:"frameId"`
+		VariablesRef      int    `json:"variablesReference"`
+		Expression        string `json:"expression"`
+		TerminateDebuggee bool   `json:"terminateDebuggee"`
+	}
+	if len(req.Arguments) > 0 {
+		json.Unmarshal(req.Arguments, &args)
+	}
+	switch req.Command {
+	case "initialize":
+		s.respond(req, map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsFunctionBreakpoints":      true,
+			"supportsEvaluateForHovers":        true,
+		}, nil)
+		s.event("initialized", nil)
+	case "launch", "attach":
+		s.d.StopOnEntry = args.StopOnEntry
+		if args.Program != "" {
+			s.mu.Lock()
+			s.source = args.Program
+			s.mu.Unlock()
+		}
+		s.respond(req, nil, nil)
+	case "setBreakpoints":
+		var bps []Breakpoint
+		var results []map[string]interface{}
+		for _, b := range args.Breakpoints {
+			bps = append(bps, Breakpoint{Line: b.Line})
+			results = append(results, map[string]interface{}{
+				"verified": s.hasLocation(func(l SourceLocation) bool { return l.Line == b.Line }),
+				"line":     b.Line,
+			})
+		}
+		s.mu.Lock()
+		if args.Source.Path != "" {
+			s.source = args.Source.Path
+		}
+		s.lines = bps
+		s.mu.Unlock()
+		s.updateBreakpoints()
+		s.respond(req, map[string]interface{}{"breakpoints": results}, nil)
+	case "setFunctionBreakpoints":
+		var bps []Breakpoint
+		var results []map[string]interface{}
+		for _, b := range args.Breakpoints {
+			bp, verified := s.parseFunctionBreakpoint(b.Name)
+			bps = append(bps, bp)
+			results = append(results, map[string]interface{}{"verified": verified})
+		}
+		s.mu.Lock()
+		s.funcs = bps
+		s.mu.Unlock()
+		s.updateBreakpoints()
+		s.respond(req, map[string]interface{}{"breakpoints": results}, nil)
+	case "configurationDone":
+		s.respond(req, nil, nil)
+		s.configuredOnce.Do(func() { close(s.configured) })
+	case "threads":
+		s.respond(req, map[string]interface{}{
+			"threads": []map[string]interface{}{{"id": 1, "name": "main"}},
+		}, nil)
+	case "stackTrace":
+		stop, source := s.stopped()
+		if stop == nil {
+			s.respond(req, nil, errors.New("program is running"))
+			break
+		}
+		frames := make([]map[string]interface{}, len(stop.Frames))
+		for i, f := range stop.Frames {
+			name := f.Function
+			if name == "" {
+				name = "main"
+			}
+			frame := map[string]interface{}{
+				"id":     i,
+				"name":   fmt.Sprintf("%s (%s)", name, f.Loc.IL),
+				"line":   f.Loc.Line,
+				"column": f.Loc.Column,
+			}
+			if source != "" {
+				frame["source"] = map[string]interface{}{"path": source}
+			}
+			frames[i] = frame
+		}
+		s.respond(req, map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil)
+	case "scopes":
+		s.respond(req, map[string]interface{}{
+			"scopes": []map[string]interface{}{
+				{"name": "Locals", "variablesReference": args.FrameID + 1, "expensive": false},
+			},
+		}, nil)
+	case "variables":
+		frame, err := s.frame(args.VariablesRef - 1)
+		if err != nil {
+			s.respond(req, nil, err)
+			break
+		}
+		vars := make([]map[string]interface{}, len(frame.Vars))
+		for i, v := range frame.Vars {
+			vars[i] = map[string]interface{}{
+				"name":               v.Name,
+				"value":              reprValue(v.Value),
+				"type":               typeName(v.Value),
+				"variablesReference": 0,
+			}
+		}
+		s.respond(req, map[string]interface{}{"variables": vars}, nil)
+	case "evaluate":
+		frame, err := s.frame(args.FrameID)
+		if err != nil {
+			s.respond(req, nil, err)
+			break
+		}
+		for _, v := range frame.Vars {
+			if v.Name == strings.TrimSpace(args.Expression) {
+				s.respond(req, map[string]interface{}{
+					"result":             reprValue(v.Value),
+					"type":               typeName(v.Value),
+					"variablesReference": 0,
+				}, nil)
+				return true
+			}
+		}
+		s.respond(req, nil, fmt.Errorf("unknown variable %s", args.Expression))
+	case "continue", "next", "stepIn", "stepOut":
+		mode := map[string]StepMode{
+			"continue": StepContinue,
+			"next":     StepOver,
+			"stepIn":   StepInto,
+			"stepOut":  StepOut,
+		}[req.Command]
+		s.mu.Lock()
+		stopped := s.stop != nil
+		s.stop = nil
+		s.mu.Unlock()
+		if !stopped {
+			s.respond(req, nil, errors.New("program is running"))
+			break
+		}
+		s.respond(req, map[string]interface{}{"allThreadsContinued": true}, nil)
+		s.resume <- mode
+	case "pause":
+		s.d.Pause()
+		s.respond(req, nil, nil)
+	case "disconnect":
+		s.respond(req, nil, nil)
+		s.detach()
+		if args.TerminateDebuggee {
+			os.Exit(1)
+		}
+		return false
+	default:
+		s.respond(req, nil, fmt.Errorf("unsupported request %s", req.Command))
+	}
+	return true
+}
+
+// parseFunctionBreakpoint interprets a function breakpoint name: an IL
+// statement path, "sentence N", or an IL function name.
+func (s *dapSession) parseFunctionBreakpoint(name string) (Breakpoint, bool) {
+	name = strings.TrimSpace(name)
+	if strings.HasPrefix(name, "$.") {
+		return Breakpoint{IL: name}, s.hasLocation(func(l SourceLocation) bool { return l.IL == name })
+	}
+	if rest, ok := strings.CutPrefix(name, "sentence "); ok {
+		if n, err := strconv.Atoi(strings.TrimSpace(rest)); err == nil {
+			return Breakpoint{Sentence: n}, s.hasLocation(func(l SourceLocation) bool { return l.SentenceID == n })
+		}
+	}
+	return Breakpoint{Function: name}, true
+}
+
+func (s *dapSession) hasLocation(match func(SourceLocation) bool) bool {
+	for _, l := range s.e.locations {
+		if match(l) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *dapSession) updateBreakpoints() {
+	s.mu.Lock()
+	bps := append(append([]Breakpoint(nil), s.lines...), s.funcs...)
+	s.mu.Unlock()
+	s.d.SetBreakpoints(bps)
+}
+
+func (s *dapSession) stopped() (*Stop, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stop, s.source
+}
+
+func (s *dapSession) frame(id int) (DebugFrame, error) {
+	stop, _ := s.stopped()
+	if stop == nil {
+		return DebugFrame{}, errors.New("program is running")
+	}
+	if id < 0 || id >= len(stop.Frames) {
+		return DebugFrame{}, fmt.Errorf("no frame %d", id)
+	}
+	return stop.Frames[id], nil
+}
+
+// onStop is the debugger's OnStop: it reports the stop to the client and
+// waits for a step request, or runs on once the client has detached.
+func (s *dapSession) onStop(stop Stop) StepMode {
+	select {
+	case <-s.done:
+		return StepContinue
+	default:
+	}
+	s.mu.Lock()
+	s.stop = &stop
+	s.mu.Unlock()
+	s.event("stopped", map[string]interface{}{
+		"reason":            stop.Reason,
+		"threadId":          1,
+		"allThreadsStopped": true,
+	})
+	select {
+	case mode := <-s.resume:
+		return mode
+	case <-s.done:
+		return StepContinue
+	}
+}
+
+// detach lets the program run to completion without the client.
+func (s *dapSession) detach() {
+	s.d.SetBreakpoints(nil)
+	s.configuredOnce.Do(func() { close(s.configured) })
+	s.doneOnce.Do(func() { close(s.done) })
+}
+
+// finish tells the client the program has exited.
+func (s *dapSession) finish(status int) {
+	select {
+	case <-s.done:
+	default:
+		s.event("exited", map[string]interface{}{"exitCode": status})
+		s.event("terminated", nil)
+	}
+	s.conn.Close()
+}
+
+// coreilServeDAP is called at the start of main in programs compiled with
+// debug=True. When COREIL_DAP names a listen address, it waits for a DAP
+// client to attach before the program runs.
+func coreilServeDAP() {
+	addr := os.Getenv("COREIL_DAP")
+	if addr == "" {
+		return
+	}
+	if err := DefaultEngine.ServeDAP(addr); err != nil {
+		fmt.Fprintln(DefaultEngine.errOut, "debugger:", err)
+		os.Exit(1)
+	}
+}
+
+// ============================================================================
+// Watchpoints
+// ============================================================================
+
+// WatchEvent describes a write seen by a watchpoint: an assignment to a
+// watched variable (Op "Assign"), or a mutation of a watched container, with
+// Op naming the IL statement ("Push", "SetIndex", "Set", "SetField",
+// "SetAdd", "SetRemove", "PushBack", "PushFront", "PopFront", "PopBack",
+// "HeapPush" or "HeapPop"). Key is the index, key, field name or heap
+// priority involved, and Old and New the element before and after; each is
+// None when it does not apply.
+type WatchEvent struct {
+	Variable string // watched variable holding the container; "" if watched by value
+	Op       string
+	Key      Value
+	Old, New Value
+	Loc      SourceLocation
+	Function string // innermost IL function, "" at the top level
+}
+
+func (w WatchEvent) String() string {
+	target := w.Variable
+	if target == "" {
+		target = typeName(w.New)
+		if w.New.Type == TypeNone {
+			target = typeName(w.Old)
+		}
+	}
+	if w.Key.Type != TypeNone {
+		target += "[" + reprValue(w.Key) + "]"
+	}
+	msg := fmt.Sprintf("watch: %s %s: %s -> %s", w.Op, target, reprValue(w.Old), reprValue(w.New))
+	if loc := w.Loc.String(); loc != "" {
+		msg += " at " + loc
+	}
+	if w.Function != "" {
+		msg += " in " + w.Function
+	}
+	return msg
+}
+
+type valueWatch struct {
+	variable string
+	fn       func(WatchEvent)
+}
+
+type watchSlot struct {
+	depth int
+	name  string
+}
+
+// WatchVariable calls fn each time an IL variable with this name is
+// assigned, in any frame, and each time a container it holds is mutated. A
+// nil fn writes each event to the error output instead. Assignments are
+// only visible in programs compiled with debug=True or a watch list.
+func (e *Engine) WatchVariable(name string, fn func(WatchEvent)) {
+	if e.varWatches == nil {
+		e.varWatches = map[string]func(WatchEvent){}
+		e.varValues = map[watchSlot]Value{}
+	}
+	e.varWatches[name] = fn
+	e.watching = true
+}
+
+// WatchValue calls fn (or, if nil, writes a trace line) each time the
+// container v is mutated, through any variable referring to it.
+func (e *Engine) WatchValue(v Value, fn func(WatchEvent)) {
+	if !isContainer(v) {
+		panic(runtimeError(KindTypeError, "cannot watch %s, only mutable containers", typeName(v)))
+	}
+	e.watchContainer(v, valueWatch{fn: fn})
+}
+
+// ClearWatches removes all watchpoints.
+func (e *Engine) ClearWatches() {
+	e.varWatches, e.valueWatches, e.varValues = nil, nil, nil
+	e.watching = false
+}
+
+func isContainer(v Value) bool {
+	switch v.Type {
+	case TypeArray, TypeMap, TypeRecord, TypeSet, TypeDeque, TypeHeap:
+		return true
+	}
+	return false
+}
+
+func (e *Engine) watchContainer(v Value, w valueWatch) {
+	if e.valueWatches == nil {
+		e.valueWatches = map[interface{}]valueWatch{}
+	}
+	e.valueWatches[v.data] = w
+	e.watching = true
+}
+
+func (e *Engine) watchVariable(name string, v Value) {
+	fn, ok := e.varWatches[name]
+	if !ok {
+		return
+	}
+	slot := watchSlot{len(e.calls), name}
+	old := e.varValues[slot]
+	e.varValues[slot] = v
+	if isContainer(v) {
+		e.watchContainer(v, valueWatch{variable: name, fn: fn})
+	}
+	e.notifyWatch(fn, WatchEvent{Variable: name, Op: "Assign", Old: old, New: v})
+}
+
+// forgetVariables drops the previous values recorded at the depth of a
+// newly entered frame, which belonged to an earlier call.
+func (e *Engine) forgetVariables() {
+	for name := range e.varWatches {
+		delete(e.varValues, watchSlot{len(e.calls), name})
+	}
+}
+
+// watchMutation reports a mutation of base to its watchpoint, if it has one;
+// builtins call it before applying the change.
+func watchMutation(base Value, op string, key, old, new Value) {
+	e := DefaultEngine
+	w, ok := e.valueWatches[base.data]
+	if !ok {
+		return
+	}
+	e.notifyWatch(w.fn, WatchEvent{Variable: w.variable, Op: op, Key: key, Old: old, New: new})
+}
+
+func (e *Engine) notifyWatch(fn func(WatchEvent), ev WatchEvent) {
+	ev.Loc = e.loc
+	if n := len(e.calls); n > 0 {
+		ev.Function = e.calls[n-1]
+	}
+	if fn != nil {
+		fn(ev)
+		return
+	}
+	// Keep the trace in order with the program's own output
+	e.Flush()
+	fmt.Fprintln(e.errOut, ev)
+}
+
+// ============================================================================
+// Coverage
+// ============================================================================
+
+// EnableCoverage starts counting how often each registered location runs in
+// programs compiled with coverage=True (or debug=True). Call it after
+// SetSourceLocations; generated coverage builds do so at the start of main.
+func (e *Engine) EnableCoverage() {
+	e.coverage = make([]int64, len(e.locations))
+}
+
+// StatementCoverage is the execution count of one IL statement.
+type StatementCoverage struct {
+	Loc   SourceLocation
+	Count int64
+}
+
+// Coverage returns the execution count of every registered location, in
+// table order, or nil if coverage is not enabled.
+func (e *Engine) Coverage() []StatementCoverage {
+	if e.coverage == nil {
+		return nil
+	}
+	result := make([]StatementCoverage, len(e.coverage))
+	for i, n := range e.coverage {
+		result[i] = StatementCoverage{Loc: e.locations[i], Count: n}
+	}
+	return result
+}
+
+// WriteCoverage writes the counts as JSON: {"statements": [{"il": path,
+// "count": n}, ...]}. The English compiler turns this into an HTML/JSON
+// report mapped back to sentences.
+func (e *Engine) WriteCoverage(w io.Writer) error {
+	type entry struct {
+		IL    string `json:"il"`
+		Count int64  `json:"count"`
+	}
+	statements := make([]entry, 0, len(e.coverage))
+	for _, c := range e.Coverage() {
+		statements = append(statements, entry{c.Loc.IL, c.Count})
+	}
+	return json.NewEncoder(w).Encode(map[string]interface{}{"statements": statements})
+}
+
+// saveCoverage writes the counts to the file named by COREIL_COVERAGE, if
+// coverage is enabled; generated programs call it on exit.
+func (e *Engine) saveCoverage() {
+	path := os.Getenv("COREIL_COVERAGE")
+	if path == "" || e.coverage == nil {
+		return
+	}
+	f, err := os.Create(path)
+	if err == nil {
+		err = e.WriteCoverage(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(e.errOut, "coverage:", err)
+	}
+}
+
+// coreilCover marks the start of a statement in programs compiled with
+// coverage=True, recording the location like coreilLoc and counting it.
+func coreilCover(i int) {
+	e := DefaultEngine
+	coreilLoc(i)
+	if e.coverage != nil {
+		e.coverage[i]++
+	}
+	if e.stepTrace != nil {
+		e.stepTrace.mark(e, i)
+	}
+}
+
+// stepTrace is the statement trace `english-compiler run --cross-check`
+// compares against the reference interpreter: coverage builds write one
+// line per statement they start, "<IL path> <bytes> <checksum>", where
+// bytes and checksum (the first 8 bytes of a SHA-256, in hex) cover all
+// output printed before the statement. It sits between the output buffer
+// and the destination, so it sees exactly the bytes the program printed.
+type stepTrace struct {
+	f   *os.File
+	sum hash.Hash
+	n   int64
+}
+
+func (t *stepTrace) Write(p []byte) (int, error) {
+	t.sum.Write(p)
+	t.n += int64(len(p))
+	return len(p), nil
+}
+
+// mark records the start of statement i. Lines are written unbuffered so
+// the trace is complete however the program exits.
+func (t *stepTrace) mark(e *Engine, i int) {
+	e.out.Flush()
+	fmt.Fprintf(t.f, "%s %d %x\n", e.locations[i].IL, t.n, t.sum.Sum(nil)[:8])
+}
+
+// displayLog is how `english-compiler kernel` shows a notebook cell's
+// result: each single-value print to stdout also writes a line to the
+// file, "<start> <end> <json>", where start and end count the bytes of
+// output before and after the print and json is displayValue(v). Like
+// stepTrace it sits between the output buffer and the destination.
+type displayLog struct {
+	f *os.File
+	n int64
+}
+
+func (d *displayLog) Write(p []byte) (int, error) {
+	d.n += int64(len(p))
+	return len(p), nil
+}
+
+// print writes text, the printed form of v, and records v. Lines are
+// written unbuffered so the log is complete however the program exits.
+func (d *displayLog) print(e *Engine, v Value, text string) {
+	e.out.Flush()
+	start := d.n
+	e.out.WriteString(text)
+	e.out.Flush()
+	data, err := json.Marshal(displayValue(v, 0))
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(d.f, "%d %d %s\n", start, d.n, data)
+}
+
+// displayValue limits: containers list at most displayLimit elements, and
+// values nested displayDepth deep are given by their printed form only.
+const (
+	displayLimit = 1000
+	displayDepth = 2
+)
+
+// displayValue encodes v for displayLog as {"type": typeName(v), ...}.
+// Arrays and tuples add "items", maps "keys" and "values", and plain
+// records "fields" and "values", with "length" counting every element;
+// other values add "text", their printed form.
+func displayValue(v Value, depth int) map[string]interface{} {
+	out := map[string]interface{}{"type": typeName(v)}
+	if depth >= displayDepth {
+		out["text"] = formatValue(v)
+		return out
+	}
+	encode := func(values []Value) []interface{} {
+		if len(values) > displayLimit {
+			values = values[:displayLimit]
+		}
+		encoded := make([]interface{}, len(values))
+		for i, item := range values {
+			encoded[i] = displayValue(item, depth+1)
+		}
+		return encoded
+	}
+	switch v.Type {
+	case TypeArray, TypeTuple:
+		items := iterItems(v)
+		out["items"] = encode(items)
+		out["length"] = len(items)
+	case TypeMap:
+		om := v.data.(*OrderedMap)
+		keys := make([]Value, len(om.keys))
+		values := make([]Value, len(om.keys))
+		for i, k := range om.keys {
+			keys[i] = om.keyValue(k)
+			values[i] = om.values[k]
+		}
+		out["keys"] = encode(keys)
+		out["values"] = encode(values)
+		out["length"] = len(keys)
+	case TypeRecord:
+		r := v.data.(*Record)
+		if r.class != nil {
+			out["text"] = formatValue(v)
+			break
+		}
+		values := make([]Value, len(r.order))
+		for i, name := range r.order {
+			values[i] = r.fields[name]
+		}
+		out["fields"] = r.order
+		out["values"] = encode(values)
+		out["length"] = len(values)
+	default:
+		out["text"] = formatValue(v)
+	}
+	return out
+}
+
+// ============================================================================
+// Profiling
+// ============================================================================
+
+// profileMemRate is the average number of bytes allocated between recorded
+// allocation samples while profiling; finer than Go's default of 512 KiB so
+// that short programs still produce a useful allocation profile.
+const profileMemRate = 4096
+
+// StartProfile begins sampling CPU time into cpu and, when allocs is not
+// nil, recording allocations to be written to it by StopProfile. Both are
+// written in pprof format. CPU samples carry an il_function label naming
+// the innermost IL function ("main" at top level), which stays accurate
+// across tail calls (go tool pprof -tagfocus=il_function=name); allocation
+// samples are attributed by stack.
+func (e *Engine) StartProfile(cpu, allocs io.Writer) error {
+	if allocs != nil {
+		runtime.MemProfileRate = profileMemRate
+	}
+	if err := pprof.StartCPUProfile(cpu); err != nil {
+		return err
+	}
+	e.profileAllocs = allocs
+	e.profileLabels = map[string]context.Context{}
+	e.profileFunction(e.innermostFunction())
+	return nil
+}
+
+// StopProfile stops a profile started by StartProfile and writes the
+// allocation profile, if one was requested.
+func (e *Engine) StopProfile() error {
+	if e.profileLabels == nil {
+		return nil
+	}
+	pprof.StopCPUProfile()
+	pprof.SetGoroutineLabels(context.Background())
+	e.profileLabels = nil
+	allocs := e.profileAllocs
+	e.profileAllocs = nil
+	if allocs == nil {
+		return nil
+	}
+	runtime.GC()
+	return pprof.Lookup("allocs").WriteTo(allocs, 0)
+}
+
+// innermostFunction names the active IL function, or "main" at top level.
+func (e *Engine) innermostFunction() string {
+	if len(e.calls) == 0 {
+		return "main"
+	}
+	return e.calls[len(e.calls)-1]
+}
+
+// profileFunction labels the running goroutine's CPU samples with an IL
+// function name.
+func (e *Engine) profileFunction(name string) {
+	ctx, ok := e.profileLabels[name]
+	if !ok {
+		ctx = pprof.WithLabels(context.Background(), pprof.Labels("il_function", name))
+		e.profileLabels[name] = ctx
+	}
+	pprof.SetGoroutineLabels(ctx)
+}
+
+// coreilStartProfile starts profiling when COREIL_PROFILE names a
+// directory, to which cpu.pprof and allocs.pprof are written on exit;
+// codegen emits it at the start of main in programs compiled with
+// profile=True.
+func coreilStartProfile() {
+	e := DefaultEngine
+	dir := os.Getenv("COREIL_PROFILE")
+	if dir == "" {
+		return
+	}
+	cpu, err := os.Create(filepath.Join(dir, "cpu.pprof"))
+	if err != nil {
+		fmt.Fprintln(e.errOut, "profile:", err)
+		return
+	}
+	allocs, err := os.Create(filepath.Join(dir, "allocs.pprof"))
+	if err == nil {
+		err = e.StartProfile(cpu, allocs)
+		if err != nil {
+			allocs.Close()
+		}
+	}
+	if err != nil {
+		cpu.Close()
+		fmt.Fprintln(e.errOut, "profile:", err)
+		return
+	}
+	e.profileFiles = []*os.File{cpu, allocs}
+}
+
+// saveProfile stops a profile started by coreilStartProfile and closes its
+// files; generated programs call it on exit.
+func (e *Engine) saveProfile() {
+	if e.profileFiles == nil {
+		return
+	}
+	err := e.StopProfile()
+	for _, f := range e.profileFiles {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	e.profileFiles = nil
+	if err != nil {
+		fmt.Fprintln(e.errOut, "profile:", err)
+	}
+}
+
+// ============================================================================
+// Heap snapshots
+// ============================================================================
+
+// HeapSnapshot summarizes the values reachable from a program's variables
+// at one moment, for finding out what is holding on to memory. Sizes are
+// estimates in the same units as the memory limit (see Limits.MaxMemory):
+// valueBytes per Value slot plus the length of each string.
+type HeapSnapshot struct {
+	Roots   []SnapshotRoot        `json:"roots"`
+	Values  int                   `json:"values"`
+	Bytes   int64                 `json:"bytes"`
+	Types   map[string]*TypeStats `json:"types"`
+	Largest []ContainerStats      `json:"largest"` // by length, longest first
+}
+
+// SnapshotRoot is a variable the walk started from. Values and Bytes count
+// what was first reached through it; values shared with an earlier root
+// are counted there.
+type SnapshotRoot struct {
+	Function string `json:"function"` // "" for the top level
+	Depth    int    `json:"depth"`    // call depth, 0 for the top level
+	Variable string `json:"variable"`
+	Type     string `json:"type"`
+	Values   int    `json:"values"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// TypeStats counts the reachable values of one type.
+type TypeStats struct {
+	Count int   `json:"count"`
+	Bytes int64 `json:"bytes"`
+}
+
+// ContainerStats describes one reachable container. Path is how the walk
+// first reached it, e.g. "rows[3].tags"; Bytes excludes what the container
+// holds.
+type ContainerStats struct {
+	Path  string `json:"path"`
+	Type  string `json:"type"`
+	Len   int    `json:"len"`
+	Bytes int64  `json:"bytes"`
+}
+
+// snapshotLargest is how many containers HeapSnapshot.Largest lists.
+const snapshotLargest = 10
+
+// Snapshot walks every value reachable from the variables of the top level
+// and of each active IL call. Variables are known only in programs compiled
+// with debug=True while a Debugger is attached (an idle &Debugger{} will
+// do); otherwise the snapshot has no roots. Call it on the program's
+// goroutine, e.g. from Debugger.OnStop.
+func (e *Engine) Snapshot() *HeapSnapshot {
+	w := &snapshotWalk{
+		s:    &HeapSnapshot{Roots: []SnapshotRoot{}, Types: map[string]*TypeStats{}},
+		seen: map[interface{}]bool{},
+	}
+	if d := e.debugger; d != nil {
+		for depth := 0; depth < len(d.frames) && depth <= len(e.calls); depth++ {
+			fn := ""
+			if depth > 0 {
+				fn = e.calls[depth-1]
+			}
+			for _, v := range d.frames[depth].vars {
+				values, bytes := w.s.Values, w.s.Bytes
+				path := v.Name
+				if fn != "" {
+					path = fn + ":" + v.Name
+				}
+				w.walk(v.Value, path)
+				w.s.Roots = append(w.s.Roots, SnapshotRoot{
+					Function: fn,
+					Depth:    depth,
+					Variable: v.Name,
+					Type:     typeName(v.Value),
+					Values:   w.s.Values - values,
+					Bytes:    w.s.Bytes - bytes,
+				})
+			}
+		}
+	}
+	sort.SliceStable(w.containers, func(i, j int) bool {
+		return w.containers[i].Len > w.containers[j].Len
+	})
+	if len(w.containers) > snapshotLargest {
+		w.containers = w.containers[:snapshotLargest]
+	}
+	w.s.Largest = append([]ContainerStats{}, w.containers...)
+	return w.s
+}
+
+// WriteJSON writes the snapshot as indented JSON.
+func (s *HeapSnapshot) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+type snapshotWalk struct {
+	s          *HeapSnapshot
+	seen       map[interface{}]bool // container data pointers already walked
+	containers []ContainerStats
+}
+
+// count records one value of v's type, with its own (shallow) size.
+func (w *snapshotWalk) count(v Value, bytes int64) {
+	t := typeName(v)
+	stats := w.s.Types[t]
+	if stats == nil {
+		stats = &TypeStats{}
+		w.s.Types[t] = stats
+	}
+	stats.Count++
+	stats.Bytes += bytes
+	w.s.Values++
+	w.s.Bytes += bytes
+}
+
+// container records a container reached at path, returning false if it was
+// already walked.
+func (w *snapshotWalk) container(v Value, path string, n int, bytes int64) bool {
+	if w.seen[v.data] {
+		return false
+	}
+	w.seen[v.data] = true
+	w.count(v, bytes)
+	w.containers = append(w.containers, ContainerStats{Path: path, Type: typeName(v), Len: n, Bytes: bytes})
+	return true
+}
+
+func (w *snapshotWalk) walk(v Value, path string) {
+	switch v.Type {
+	case TypeStr:
+		w.count(v, valueBytes+int64(len(v.data.(string))))
+	case TypeArray:
+		items := v.data.(*Array).items
+		if w.container(v, path, len(items), valueBytes*int64(len(items)+1)) {
+			for i, item := range items {
+				w.walk(item, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	case TypeTuple:
+		items := v.data.([]Value)
+		w.count(v, valueBytes*int64(len(items)+1))
+		for i, item := range items {
+			w.walk(item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	case TypeMap:
+		om := v.data.(*OrderedMap)
+		bytes := valueBytes * int64(2*len(om.keys)+1)
+		for _, k := range om.keys {
+			bytes += int64(len(k))
+		}
+		if w.container(v, path, len(om.keys), bytes) {
+			for _, k := range om.keys {
+				w.walk(om.values[k], fmt.Sprintf("%s[%s]", path, reprValue(om.keyValue(k))))
+			}
+		}
+	case TypeRecord:
+		r := v.data.(*Record)
+		bytes := valueBytes * int64(len(r.order)+1)
+		for _, name := range r.order {
+			bytes += int64(len(name))
+		}
+		if w.container(v, path, len(r.order), bytes) {
+			for _, name := range r.order {
+				w.walk(r.fields[name], path+"."+name)
+			}
+		}
+	case TypeSet:
+		items := v.data.(*ValueSet).items
+		keys := make([]string, 0, len(items))
+		bytes := valueBytes * int64(len(items)+1)
+		for k := range items {
+			keys = append(keys, k)
+			bytes += int64(len(k))
+		}
+		if w.container(v, path, len(items), bytes) {
+			sort.Strings(keys)
+			for _, k := range keys {
+				w.walk(items[k], path+"{}")
+			}
+		}
+	case TypeDeque:
+		items := v.data.(*Deque).items
+		if w.container(v, path, len(items), valueBytes*int64(len(items)+1)) {
+			for i, item := range items {
+				w.walk(item, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	case TypeHeap:
+		items := v.data.(*MinHeap).items
+		if w.container(v, path, len(items), (valueBytes+8)*int64(len(items))+valueBytes) {
+			for i, item := range items {
+				w.walk(item.value, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	case TypeVariant:
+		vr := v.data.(*Variant)
+		w.count(v, valueBytes)
+		w.walk(vr.payload, path+"."+vr.tag)
+	default:
+		w.count(v, valueBytes)
+	}
+}
+
+// coreilSnapshotOnError arranges for a heap snapshot to be written to the
+// file named by COREIL_SNAPSHOT if the program ends with an uncaught error;
+// codegen emits it at the start of main in programs compiled with
+// debug=True. The snapshot is taken where the error was raised, before the
+// call stack unwinds.
+func coreilSnapshotOnError() {
+	e := DefaultEngine
+	e.snapshotPath = os.Getenv("COREIL_SNAPSHOT")
+	if e.snapshotPath != "" && e.debugger == nil {
+		e.SetDebugger(&Debugger{})
+	}
+}
+
+// savePostMortem writes the snapshot for an uncaught error; errors raised at
+// the top level are snapshotted here, since there is no frame to unwind.
+func (e *Engine) savePostMortem() {
+	if e.snapshotPath == "" {
+		return
+	}
+	s := e.postMortem
+	if !e.unwinding || s == nil {
+		s = e.Snapshot()
+	}
+	f, err := os.Create(e.snapshotPath)
+	if err == nil {
+		err = s.WriteJSON(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(e.errOut, "snapshot:", err)
+	}
+}
+
+// ============================================================================
+// Record and replay
+// ============================================================================
+
+// traceVersion identifies the trace format written by Record.
+const traceVersion = 1
+
+// traceHeader is the first line of a trace.
+type traceHeader struct {
+	Trace int   `json:"trace"`
+	Seed  int64 `json:"seed"`
+}
+
+// traceEntry is one recorded ExternalCall: its arguments and result in the
+// isolation wire format (see toWire), or the runtime error it raised and
+// its kind, and for os.system the command's output.
+type traceEntry struct {
+	Call   string        `json:"call"`
+	Args   []interface{} `json:"args,omitempty"`
+	Result interface{}   `json:"result,omitempty"`
+	Error  string        `json:"error,omitempty"`
+	Kind   ErrorKind     `json:"kind,omitempty"`
+	Output string        `json:"output,omitempty"`
+}
+
+// unrecorded lists builtins a replay reproduces without the trace: random.*
+// draws from the PRNG, whose seed is in the trace header, crypto.hash is a
+// pure function, and os.exit ends the program.
+var unrecorded = map[string]bool{
+	"random.random":  true,
+	"random.randint": true,
+	"random.choice":  true,
+	"random.seed":    true,
+	"crypto.hash":    true,
+	"os.exit":        true,
+}
+
+// Record writes every ExternalCall that depends on the outside world
+// (clock, files, environment, network, subprocesses, sleeping) to w, one
+// JSON line each with its result or error, so that Replay can rerun the
+// program exactly. random.* builtins are reproduced by reseeding the PRNG
+// with a seed written at the start of the trace (the DeterministicConfig
+// seed in deterministic mode). Call it before the program runs.
+func (e *Engine) Record(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(traceHeader{Trace: traceVersion, Seed: e.runSeed()}); err != nil {
+		return err
+	}
+	e.recorder = enc
+	return nil
+}
+
+// runSeed reseeds the PRNG with a seed a trace or checkpoint can record
+// (the DeterministicConfig seed in deterministic mode), once per engine so
+// that both record the same one.
+func (e *Engine) runSeed() int64 {
+	if !e.seeded {
+		e.seed, e.seeded = time.Now().UnixNano(), true
+		if e.deterministic != nil {
+			e.seed = e.deterministic.Seed
+		}
+		e.rng = rand.New(rand.NewSource(e.seed))
+	}
+	return e.seed
+}
+
+// Replay answers ExternalCalls from a trace written by Record instead of
+// performing them: results and errors are returned as recorded, os.system
+// output is written again, and sleeps return at once. The program must make
+// the same calls with the same arguments in the same order; the first one
+// that differs raises a "replay diverged" runtime error. Call it before the
+// program runs.
+func (e *Engine) Replay(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var h traceHeader
+	if err := dec.Decode(&h); err != nil {
+		return fmt.Errorf("reading trace header: %w", err)
+	}
+	if h.Trace != traceVersion {
+		return fmt.Errorf("unsupported trace version %d", h.Trace)
+	}
+	var entries []traceEntry
+	for {
+		var t traceEntry
+		err := dec.Decode(&t)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading trace: %w", err)
+		}
+		entries = append(entries, t)
+	}
+	e.seed, e.seeded = h.Seed, true
+	e.rng = rand.New(rand.NewSource(h.Seed))
+	e.replay, e.replayed, e.replaying = entries, 0, true
+	return nil
+}
+
+// describeCall renders a call as name(arg, ...) with each argument's repr.
+func describeCall(name string, args []Value) string {
+	reprs := make([]string, len(args))
+	for i, a := range args {
+		reprs[i] = reprValue(a)
+	}
+	// Redacted as in the trace, so that recorded and live calls compare equal
+	return DefaultEngine.redact(name + "(" + strings.Join(reprs, ", ") + ")")
+}
+
+func (e *Engine) recordCall(name string, f externalFunc, args []Value) (result Value) {
+	entry := traceEntry{Call: name, Args: make([]interface{}, len(args))}
+	for i, a := range args {
+		entry.Args[i] = redactWire(toWire(a))
+	}
+	e.recordOut.Reset()
+	defer func() {
+		r := recover()
+		if r != nil {
+			err, ok := r.(*CoreILError)
+			if !ok {
+				// Limits and cancellation are not the outside world's doing
+				panic(r)
+			}
+			entry.Error, entry.Kind = err.Message, err.Kind
+		} else {
+			entry.Result = toWire(result)
+		}
+		entry.Output = e.recordOut.String()
+		if e.checkpoints != nil {
+			e.journal = append(e.journal, entry)
+		}
+		if e.recorder != nil {
+			// Traces are shared to reproduce failures, so they keep no
+			// secrets; replayed results have them masked
+			traced := entry
+			traced.Result, traced.Error, traced.Output = redactWire(entry.Result), e.redact(entry.Error), e.redact(entry.Output)
+			if err := e.recorder.Encode(traced); err != nil {
+				fmt.Fprintln(e.errOut, "record:", err)
+				e.recorder = nil
+			}
+		}
+		if r != nil {
+			panic(r)
+		}
+	}()
+	return f.fn(args)
+}
+
+func (e *Engine) replayCall(name string, args []Value) Value {
+	call := describeCall(name, args)
+	if e.replayed >= len(e.replay) {
+		panic(runtimeError(KindRuntimeError, "replay diverged: %s was not recorded (the trace has %d calls)", call, len(e.replay)))
+	}
+	t := e.replay[e.replayed]
+	if recorded := describeCall(t.Call, fromWireItems(t.Args)); recorded != call {
+		panic(runtimeError(KindRuntimeError, "replay diverged at call %d: the program called %s but the trace has %s", e.replayed+1, call, recorded))
+	}
+	e.replayed++
+	if t.Output != "" {
+		e.Flush()
+		io.WriteString(e.meter, t.Output)
+	}
+	if t.Error != "" {
+		panic(&CoreILError{Kind: t.Kind, Message: t.Error})
+	}
+	return fromWire(t.Result)
+}
+
+// finishReplay warns when a replayed program ended without making every
+// recorded call, a sign that it did not retrace the recorded run.
+func (e *Engine) finishReplay() {
+	if e.replaying && e.replayed < len(e.replay) {
+		fmt.Fprintf(e.errOut, "replay: %d of %d recorded calls were not made\n", len(e.replay)-e.replayed, len(e.replay))
+	}
+}
+
+// coreilTrace records to the file named by COREIL_RECORD, or replays the
+// one named by COREIL_REPLAY; codegen emits it at the start of main, after
+// the rest of the engine setup. A program that cannot create its trace
+// runs unrecorded; one that cannot read its trace exits with status 1.
+func coreilTrace() {
+	e := DefaultEngine
+	if _, child := os.LookupEnv(isolatedEnv); child {
+		// The host performs, and records, the child's calls
+		return
+	}
+	if path := os.Getenv("COREIL_REPLAY"); path != "" {
+		f, err := os.Open(path)
+		if err == nil {
+			err = e.Replay(f)
+			f.Close()
+		}
+		if err != nil {
+			fmt.Fprintln(e.errOut, "replay:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if path := os.Getenv("COREIL_RECORD"); path != "" {
+		f, err := os.Create(path)
+		if err == nil {
+			err = e.Record(f)
+		}
+		if err != nil {
+			fmt.Fprintln(e.errOut, "record:", err)
+		}
+	}
+}
+
+// ============================================================================
+// Checkpoint and restore
+// ============================================================================
+
+// checkpointVersion identifies the format written by Checkpoint.
+const checkpointVersion = 1
+
+// CheckpointConfig configures checkpoints; see SetCheckpointing.
+type CheckpointConfig struct {
+	// Every is how many steps (loop iterations and function calls) pass
+	// between the checkpoints handed to Save; 0 takes checkpoints only
+	// when Checkpoint asks for one.
+	Every int64
+	// Save receives each periodic checkpoint on the program's goroutine.
+	// If it fails, the error is reported on the error output and periodic
+	// checkpoints stop.
+	Save func(data []byte) error
+}
+
+// checkpointState is what a checkpoint holds. Seed, Calls and Steps are
+// enough to rebuild the run (see Restore); the stack, location, globals
+// and iterators are the machine state at Steps, which a restored run must
+// arrive at.
+type checkpointState struct {
+	Checkpoint int                  `json:"checkpoint"`
+	Program    string               `json:"program,omitempty"`
+	Seed       int64                `json:"seed"`
+	Steps      int64                `json:"steps"`
+	Output     int64                `json:"output"` // bytes written so far
+	Calls      []traceEntry         `json:"calls"`
+	Stack      []string             `json:"stack"`
+	Location   string               `json:"location,omitempty"`
+	Globals    map[string]string    `json:"globals"` // name -> repr
+	Iterators  []checkpointIterator `json:"iterators"`
+}
+
+// checkpointIterator is a generator that has not finished.
+type checkpointIterator struct {
+	Function string `json:"function"`
+	Yielded  int    `json:"yielded"`
+}
+
+type checkpointReply struct {
+	data []byte
+	err  error
+}
+
+// SetCheckpointing enables checkpoints of the runs that follow, or
+// disables them if cfg is nil. From then on every ExternalCall that reads
+// the outside world is journaled, as Record would write it, and the
+// random.* PRNG gets a seed the checkpoint can hold. Call it before the
+// program runs.
+func (e *Engine) SetCheckpointing(cfg *CheckpointConfig) {
+	e.checkpoints = nil
+	if cfg != nil {
+		c := *cfg
+		e.checkpoints = &c
+		e.runSeed()
+	}
+	e.updateLimited()
+}
+
+// Checkpoint serializes the state of the running program at its next step
+// (the top of a loop iteration or function body), so that Restore can
+// continue it in another process, on this host or another. It may be
+// called from any goroutine, and waits for that step; if the program ends
+// first, it returns an error. Checkpoints must be enabled with
+// SetCheckpointing, and a program that has spawned tasks cannot be
+// checkpointed, since the interleaving of its tasks is not reproducible.
+func (e *Engine) Checkpoint() ([]byte, error) {
+	if e.checkpoints == nil {
+		return nil, errors.New("checkpoints are not enabled; see SetCheckpointing")
+	}
+	reply := make(chan checkpointReply, 1)
+	e.checkpointMu.Lock()
+	e.checkpointWaiters = append(e.checkpointWaiters, reply)
+	e.checkpointMu.Unlock()
+	e.checkpointWanted.Store(true)
+	r := <-reply
+	return r.data, r.err
+}
+
+// Restore makes the next run continue from a checkpoint taken by a run of
+// the same program. The compiled code's stack cannot be written down, so
+// the run is rebuilt instead: the program runs again from the start, with
+// the checkpoint's seed, its ExternalCalls answered from the journal and
+// its output discarded, up to the step the checkpoint was taken at. There
+// the stack, location, globals, live generators and output written must
+// match the checkpoint, or the run stops with a "restore diverged" runtime
+// error; from then on it runs normally, and its checkpoints continue the
+// journal. Call it after SetCheckpointing and SetOutput, before the
+// program runs.
+func (e *Engine) Restore(data []byte) error {
+	if e.checkpoints == nil {
+		return errors.New("checkpoints are not enabled; see SetCheckpointing")
+	}
+	if e.replaying {
+		return errors.New("cannot restore a checkpoint while replaying a trace")
+	}
+	var s checkpointState
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&s); err != nil {
+		return fmt.Errorf("reading checkpoint: %w", err)
+	}
+	if s.Checkpoint != checkpointVersion {
+		return fmt.Errorf("unsupported checkpoint version %d", s.Checkpoint)
+	}
+	if s.Program != "" && e.programHash != "" && s.Program != e.programHash {
+		return fmt.Errorf("the checkpoint is of another program (%s, not %s)", s.Program, e.programHash)
+	}
+	e.seed, e.seeded = s.Seed, true
+	e.rng = rand.New(rand.NewSource(s.Seed))
+	e.replay, e.replayed, e.replaying = s.Calls, 0, true
+	e.journal = append([]traceEntry{}, s.Calls...)
+	e.restore, e.restoreOut = &s, nil
+	return nil
+}
+
+// checkpointStep is coreilStep's work when checkpoints are enabled.
+func (e *Engine) checkpointStep() {
+	if e.restore != nil {
+		if e.steps < e.restore.Steps {
+			return
+		}
+		e.restored()
+	}
+	if e.checkpointing {
+		return
+	}
+	periodic := e.checkpoints.Every > 0 && e.steps%e.checkpoints.Every == 0 && e.checkpoints.Save != nil
+	wanted := e.checkpointWanted.Load()
+	if !periodic && !wanted {
+		return
+	}
+	data, err := e.checkpoint()
+	if periodic {
+		if err == nil {
+			err = e.checkpoints.Save(data)
+		}
+		if err != nil {
+			fmt.Fprintln(e.errOut, "checkpoint:", err)
+			e.checkpoints.Every = 0
+		}
+	}
+	if wanted {
+		e.answerCheckpoints(data, err)
+	}
+}
+
+// answerCheckpoints replies to the pending Checkpoint calls.
+func (e *Engine) answerCheckpoints(data []byte, err error) {
+	if !e.checkpointWanted.Swap(false) {
+		return
+	}
+	e.checkpointMu.Lock()
+	waiters := e.checkpointWaiters
+	e.checkpointWaiters = nil
+	e.checkpointMu.Unlock()
+	for _, reply := range waiters {
+		reply <- checkpointReply{data, err}
+	}
+}
+
+// checkpoint serializes the current state.
+func (e *Engine) checkpoint() ([]byte, error) {
+	if e.concurrent {
+		return nil, errors.New("cannot checkpoint a program that has spawned tasks")
+	}
+	// Output written so far must reach its destination before the
+	// checkpoint does, since a restored run does not write it again
+	e.Flush()
+	s := e.machineState()
+	s.Checkpoint, s.Program, s.Seed = checkpointVersion, e.programHash, e.seed
+	s.Calls = e.journal
+	return json.Marshal(s)
+}
+
+// machineState captures the state a restored run must arrive at. Rendering
+// a class instance may run its __repr__, which takes steps but no
+// checkpoint.
+func (e *Engine) machineState() *checkpointState {
+	e.checkpointing = true
+	defer func() { e.checkpointing = false }()
+	s := &checkpointState{
+		Steps:     e.steps,
+		Output:    e.meter.bytes,
+		Stack:     append([]string{}, e.calls...),
+		Location:  e.loc.IL,
+		Globals:   make(map[string]string, len(e.globals)),
+		Iterators: []checkpointIterator{},
+	}
+	for name, v := range e.globals {
+		s.Globals[name] = reprValue(*v)
+	}
+	live := e.generators[:0]
+	for _, g := range e.generators {
+		if !g.finished {
+			live = append(live, g)
+			s.Iterators = append(s.Iterators, checkpointIterator{Function: asFunc(g.fn).name, Yielded: g.yielded})
+		}
+	}
+	e.generators = live
+	return s
+}
+
+// restored ends the restore when the run reaches the checkpoint's step:
+// the output goes to its destination again and ExternalCalls are made for
+// real, once the state is confirmed to be the checkpoint's.
+func (e *Engine) restored() {
+	want := e.restore
+	e.restore = nil
+	e.Flush()
+	e.meter.w, e.restoreOut = e.restoreOut, nil
+	unmade := len(e.replay) - e.replayed
+	e.replay, e.replayed, e.replaying = nil, 0, false
+	got := e.machineState()
+	var diffs []string
+	if unmade > 0 {
+		diffs = append(diffs, fmt.Sprintf("%d journaled calls were not made", unmade))
+	}
+	if got.Output != want.Output {
+		diffs = append(diffs, fmt.Sprintf("%d bytes of output were written, not %d", got.Output, want.Output))
+	}
+	if strings.Join(got.Stack, " > ") != strings.Join(want.Stack, " > ") {
+		diffs = append(diffs, fmt.Sprintf("the call stack is [%s], not [%s]", strings.Join(got.Stack, ", "), strings.Join(want.Stack, ", ")))
+	}
+	if got.Location != want.Location {
+		diffs = append(diffs, fmt.Sprintf("the program is at %s, not %s", got.Location, want.Location))
+	}
+	names := make([]string, 0, len(want.Globals))
+	for name := range want.Globals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if got.Globals[name] != want.Globals[name] {
+			diffs = append(diffs, fmt.Sprintf("%s is %s, not %s", name, got.Globals[name], want.Globals[name]))
+		}
+	}
+	if fmt.Sprint(got.Iterators) != fmt.Sprint(want.Iterators) {
+		diffs = append(diffs, fmt.Sprintf("the live generators are %v, not %v", got.Iterators, want.Iterators))
+	}
+	if len(diffs) > 0 {
+		panic(runtimeError(KindRuntimeError, "restore diverged at step %d: %s", want.Steps, strings.Join(diffs, "; ")))
+	}
+}
+
+// finishRestore warns when a run ended before reaching the checkpoint it
+// was restoring, and puts its output back. The COREIL_CHECKPOINT file is
+// kept, since the run did not continue it.
+func (e *Engine) finishRestore() {
+	if e.restore == nil {
+		return
+	}
+	fmt.Fprintf(e.errOut, "restore: the program ended at step %d, before the checkpoint at step %d\n", e.steps, e.restore.Steps)
+	e.restore, e.checkpointPath = nil, ""
+	e.replay, e.replayed, e.replaying = nil, 0, false
+	if e.restoreOut != nil {
+		e.meter.w, e.restoreOut = e.restoreOut, nil
+	}
+}
+
+// coreilGlobals registers the program's package-level variables, whose
+// values checkpoints record; codegen emits it at the start of main.
+func coreilGlobals(vars map[string]*Value) {
+	DefaultEngine.globals = vars
+}
+
+// coreilCheckpoint makes the program resumable through the file named by
+// COREIL_CHECKPOINT; codegen emits it at the start of main, after
+// coreilTrace. If the file exists the program continues from the
+// checkpoint in it (see Restore). While it runs, a checkpoint replaces the
+// file every COREIL_CHECKPOINT_STEPS steps (default 100000), and the file
+// is removed when the program ends without an error. A program that
+// cannot read its checkpoint exits with status 1.
+func coreilCheckpoint() {
+	e := DefaultEngine
+	path := os.Getenv("COREIL_CHECKPOINT")
+	if path == "" {
+		return
+	}
+	every := int64(100000)
+	if v := os.Getenv("COREIL_CHECKPOINT_STEPS"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			fmt.Fprintf(e.errOut, "checkpoint: COREIL_CHECKPOINT_STEPS must be a positive integer, got %q\n", v)
+			os.Exit(1)
+		}
+		every = n
+	}
+	e.checkpointPath = path
+	e.SetCheckpointing(&CheckpointConfig{Every: every, Save: func(data []byte) error {
+		// Replace the file in one step, so a crash leaves a whole checkpoint
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, data, 0o644); err != nil {
+			return err
+		}
+		return os.Rename(tmp, path)
+	}})
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err == nil {
+		err = e.Restore(data)
+	}
+	if err != nil {
+		fmt.Fprintln(e.errOut, "checkpoint:", err)
+		os.Exit(1)
+	}
+}
+
+// removeCheckpoint deletes the COREIL_CHECKPOINT file of a program that
+// ended without an error.
+func (e *Engine) removeCheckpoint() {
+	if e.checkpointPath == "" {
+		return
+	}
+	if err := os.Remove(e.checkpointPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintln(e.errOut, "checkpoint:", err)
+	}
+}
+
+// ============================================================================
+// Tracing
+// ============================================================================
+
+// SpanAttribute is a key/value pair attached to a span. Value is a string,
+// int64, float64 or bool.
+type SpanAttribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Tracer starts spans. It is the part of OpenTelemetry's trace.Tracer the
+// engine needs, with explicit timestamps so that calls can be reported
+// after the fact; a host adapts an OTel tracer by passing start and end
+// through trace.WithTimestamp, so the runtime itself needs no dependencies.
+type Tracer interface {
+	Start(ctx context.Context, name string, start time.Time, attrs []SpanAttribute) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	RecordError(err error)
+	End(end time.Time)
+}
+
+// TraceConfig configures tracing; see SetTracing.
+type TraceConfig struct {
+	Tracer Tracer
+	// MinCallDuration is how long an IL function call must take to get a
+	// span; 0 traces every call.
+	MinCallDuration time.Duration
+	// Entrypoint names what is being run, e.g. a script path or handler;
+	// "main" if empty.
+	Entrypoint string
+}
+
+// SetTracing reports execution to cfg.Tracer: a "coreil.run" span around
+// each Run (or a generated program's main), a "coreil.call NAME" span for
+// every IL function call lasting at least cfg.MinCallDuration, and a
+// "coreil.external NAME" span around every side-effecting ExternalCall
+// (file, network, subprocess and environment access). The run span carries
+// the program hash and entrypoint; the others are its children and carry
+// the IL location. Errors raised through a span are recorded on it. A nil
+// cfg turns tracing off. Set it before running code.
+func (e *Engine) SetTracing(cfg *TraceConfig) {
+	if cfg != nil && cfg.Tracer == nil {
+		cfg = nil
+	}
+	e.tracing = cfg
+}
+
+// SetProgramHash sets the coreil.program.hash attribute of run spans;
+// generated programs call it at the start of main with the SHA-256 of
+// their Core IL.
+func (e *Engine) SetProgramHash(hash string) {
+	e.programHash = hash
+}
+
+// traceParent returns the context spans are started in: the run span's,
+// or the engine's context outside a run.
+func (e *Engine) traceParent() context.Context {
+	if e.runCtx != nil {
+		return e.runCtx
+	}
+	return e.context()
+}
+
+func (e *Engine) startRunSpan() {
+	if e.tracing == nil || e.runSpan != nil {
+		return
+	}
+	entry := e.tracing.Entrypoint
+	if entry == "" {
+		entry = "main"
+	}
+	e.runCtx, e.runSpan = e.tracing.Tracer.Start(e.context(), "coreil.run", time.Now(), []SpanAttribute{
+		{"coreil.program.hash", e.programHash},
+		{"coreil.entrypoint", entry},
+	})
+}
+
+// endRunSpan ends the run span, recording r if the run ended with an error.
+func (e *Engine) endRunSpan(r interface{}) {
+	if e.runSpan == nil {
+		return
+	}
+	span := e.runSpan
+	e.runCtx, e.runSpan = nil, nil
+	if r != nil {
+		span.RecordError(spanError(r))
+	}
+	span.End(time.Now())
+}
+
+func spanError(r interface{}) error {
+	if err, ok := r.(error); ok {
+		return err
+	}
+	return errors.New(fmt.Sprint(r))
+}
+
+// traceCall reports an IL function call that started at start and is
+// returning now, or unwinding with r, if it ran long enough.
+func (e *Engine) traceCall(name string, depth int, start time.Time, r interface{}) {
+	now := time.Now()
+	if now.Sub(start) < e.tracing.MinCallDuration {
+		return
+	}
+	_, span := e.tracing.Tracer.Start(e.traceParent(), "coreil.call "+name, start, []SpanAttribute{
+		{"coreil.function", name},
+		{"coreil.call.depth", int64(depth + 1)},
+		{"coreil.location", e.callers[depth].IL},
+	})
+	if r != nil {
+		span.RecordError(spanError(r))
+	}
+	span.End(now)
+}
+
+// traceExternal runs a side-effecting builtin inside a span.
+func (e *Engine) traceExternal(name string, f externalFunc, args []Value) Value {
+	attrs := []SpanAttribute{
+		{"coreil.builtin", name},
+		{"coreil.capability", string(f.cap)},
+		{"coreil.location", e.loc.IL},
+	}
+	if name == "http.get" || name == "http.getAuth" {
+		attrs = append(attrs, SpanAttribute{"http.url", e.redact(formatValue(args[0]))})
+	}
+	_, span := e.tracing.Tracer.Start(e.traceParent(), "coreil.external "+name, time.Now(), attrs)
+	defer func() {
+		if r := recover(); r != nil {
+			span.RecordError(spanError(r))
+			span.End(time.Now())
+			panic(r)
+		}
+		span.End(time.Now())
+	}()
+	return performExternal(name, f, args)
+}
+
+// coreilStartRun begins the traced and metered run of a generated
+// program; codegen emits it at the end of the engine setup in main.
+// coreilFlush ends it.
+func coreilStartRun() {
+	DefaultEngine.beginRun()
+}
+
+// ============================================================================
+// Command line
+// ============================================================================
+
+// argv implements the argv() builtin: the arguments the program was run
+// with, after the executable name.
+func argv() Value {
+	args := os.Args[1:]
+	items := make([]Value, len(args))
+	for i, arg := range args {
+		items[i] = ValueStr(arg)
+	}
+	return ValueArray(items)
+}
+
+// ============================================================================
+// Streaming file reads
+// ============================================================================
+
+// openStream opens the file a streaming builtin reads, or standard input
+// for "-". Like the fs.readFile ExternalCall it needs the io.read
+// capability and is reported to the audit sink. The file is read again on
+// replay and restore, rather than recorded, so that its contents never
+// have to fit in memory.
+func openStream(op string, path Value) (io.Reader, func()) {
+	e := DefaultEngine
+	name := asString(path)
+	if e.remote != nil {
+		panic(runtimeError(KindPermissionError, "%s cannot stream files in an isolated run", op))
+	}
+	requireCapability(CapIORead, op)
+	if e.audit != nil {
+		e.audit(AuditEvent{Op: op, Capability: CapIORead, Args: []string{e.redact(reprValue(path))}, Loc: e.loc})
+	}
+	if e.metrics != nil {
+		e.count("coreil.builtin.calls", 1, "builtin", op)
+	}
+	if name == "-" {
+		return os.Stdin, func() {}
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		panic(runtimeError(KindIOError, "%s", err))
+	}
+	return f, func() { f.Close() }
+}
+
+// readLines implements the readLines(path) builtin: a generator of the
+// lines of a file, without their line endings. Lines are read as the
+// generator is advanced, and the file is closed at its end or by
+// generatorClose, so a log of any size is processed in constant memory.
+func readLines(path Value) Value {
+	r, done := openStream("readLines", path)
+	br := bufio.NewReaderSize(r, 64*1024)
+	return nativeGenerator("readLines", func() (Value, bool) {
+		line, err := br.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			done()
+			if err != io.EOF {
+				panic(runtimeError(KindIOError, "%s", err))
+			}
+			return ValueNone, false
+		}
+		line = strings.TrimSuffix(line, "\n")
+		return ValueStr(strings.TrimSuffix(line, "\r")), true
+	}, done)
+}
+
+// readChunks implements the readChunks(reader, size) builtin: a generator
+// of the contents of a file in strings of size bytes, the last of them
+// shorter. A chunk may end partway through a multi-byte character.
+func readChunks(reader, size Value) Value {
+	n := asInt(size)
+	if n <= 0 {
+		panic(runtimeError(KindValueError, "readChunks size must be positive, got %d", n))
+	}
+	r, done := openStream("readChunks", reader)
+	buf := make([]byte, n)
+	return nativeGenerator("readChunks", func() (Value, bool) {
+		read, err := io.ReadFull(r, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			done()
+			if err != io.EOF {
+				panic(runtimeError(KindIOError, "%s", err))
+			}
+			return ValueNone, false
+		}
+		return ValueStr(string(buf[:read])), true
+	}, done)
+}
+
+// ============================================================================
+// Property-based testing
+// ============================================================================
+
+// forAll, forAllGenerate and forAllShrink implement the same generators and
+// shrinking as the interpreter (english_compiler/coreil/forall.py), from the
+// same splitmix64 stream, so both backends report the same counterexample.
+const (
+	forAllDefaultTrials   = 100
+	forAllMaxSize         = 100
+	forAllMaxLength       = 20
+	forAllMaxShrinkCalls  = 1000
+	forAllAlphabet        = "abcdefghijklmnopqrstuvwxyz0123456789 "
+	forAllArrayPrefix     = "array<"
+	forAllArrayPrefixSize = len(forAllArrayPrefix)
+)
+
+type splitMix64 struct{ state uint64 }
+
+func (r *splitMix64) next() uint64 {
+	r.state += 0x9E3779B97F4A7C15
+	z := r.state
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	return z ^ (z >> 31)
+}
+
+func (r *splitMix64) below(n int) int {
+	return int(r.next() % uint64(n))
+}
+
+// forAll implements the forAll(generator, property, trials) builtin: it
+// calls property with generated arguments trials times (100 by default)
+// and returns true if it always returned a truthy value. Otherwise it
+// shrinks the arguments to the smallest that still fail and raises an
+// AssertionError naming them.
+func forAll(spec, property Value, trials ...Value) Value {
+	generators := forAllGenerators(spec)
+	count := int64(forAllDefaultTrials)
+	if len(trials) > 0 {
+		if trials[0].Type != TypeInt || asInt(trials[0]) < 0 {
+			panic(runtimeError(KindValueError, "forAll: trials must be a non-negative integer, got %s", reprValue(trials[0])))
+		}
+		count = asInt(trials[0])
+	}
+	rng := &splitMix64{}
+	for trial := int64(0); trial < count; trial++ {
+		size := int(trial + 1)
+		if trial+1 > forAllMaxSize {
+			size = forAllMaxSize
+		}
+		args := make([]Value, len(generators))
+		for i, g := range generators {
+			args[i] = forAllGenerate(g, rng, size)
+		}
+		reason, failed := forAllCheck(property, args)
+		if !failed {
+			continue
+		}
+		original, shrinks, calls := args, 0, 0
+		for improved := true; improved && calls < forAllMaxShrinkCalls; {
+			improved = false
+			for _, candidate := range forAllShrinkArgs(generators, args) {
+				if calls >= forAllMaxShrinkCalls {
+					break
+				}
+				calls++
+				if r, f := forAllCheck(property, candidate); f {
+					args, reason, improved = candidate, r, true
+					shrinks++
+					break
+				}
+			}
+		}
+		msg := fmt.Sprintf("forAll: property '%s' failed after %d trial(s) for %s", asFunc(property).name, trial+1, forAllShow(args))
+		if shrinks > 0 {
+			msg += ", shrunk from " + forAllShow(original)
+		}
+		if reason != "" {
+			msg += ": " + reason
+		}
+		panic(runtimeError(KindAssertionError, "%s", msg))
+	}
+	return ValueBool(true)
+}
+
+// forAllGenerators returns the generator of each property argument.
+func forAllGenerators(spec Value) []string {
+	specs := []Value{spec}
+	if spec.Type == TypeArray {
+		specs = *asArray(spec)
+	}
+	generators := make([]string, len(specs))
+	for i, item := range specs {
+		g := ""
+		if item.Type == TypeStr {
+			g = strings.ReplaceAll(asString(item), " ", "")
+		}
+		if !forAllValid(g) {
+			panic(runtimeError(KindValueError, "forAll: unknown generator %s", reprValue(item)))
+		}
+		generators[i] = g
+	}
+	return generators
+}
+
+func forAllValid(g string) bool {
+	if strings.HasPrefix(g, forAllArrayPrefix) && strings.HasSuffix(g, ">") && len(g) > forAllArrayPrefixSize {
+		return forAllValid(g[forAllArrayPrefixSize : len(g)-1])
+	}
+	return g == "int" || g == "bool" || g == "string"
+}
+
+// forAllGenerate returns a value of generator g at size (>= 1).
+func forAllGenerate(g string, rng *splitMix64, size int) Value {
+	switch g {
+	case "int":
+		return ValueInt(int64(rng.below(2*size+1) - size))
+	case "bool":
+		return ValueBool(rng.below(2) == 1)
+	}
+	maxLength := size
+	if maxLength > forAllMaxLength {
+		maxLength = forAllMaxLength
+	}
+	length := rng.below(maxLength + 1)
+	if g == "string" {
+		buf := make([]byte, length)
+		for i := range buf {
+			buf[i] = forAllAlphabet[rng.below(len(forAllAlphabet))]
+		}
+		return ValueStr(string(buf))
+	}
+	items := make([]Value, length)
+	for i := range items {
+		items[i] = forAllGenerate(g[forAllArrayPrefixSize:len(g)-1], rng, size)
+	}
+	return ValueArray(items)
+}
+
+// forAllShrink returns smaller values of generator g than v, most
+// promising first.
+func forAllShrink(g string, v Value) []Value {
+	var out []Value
+	switch g {
+	case "int":
+		n := asInt(v)
+		if n == 0 {
+			return nil
+		}
+		sign := int64(1)
+		if n < 0 {
+			sign = -1
+		}
+		half := n / 2
+		out = append(out, ValueInt(0))
+		if half != 0 {
+			out = append(out, ValueInt(half))
+		}
+		if n-sign != 0 && n-sign != half {
+			out = append(out, ValueInt(n-sign))
+		}
+	case "bool":
+		if isTruthy(v) {
+			out = append(out, ValueBool(false))
+		}
+	case "string":
+		s := asString(v)
+		if s == "" {
+			return nil
+		}
+		out = append(out, ValueStr(""))
+		if len(s) > 1 {
+			out = append(out, ValueStr(s[:len(s)/2]), ValueStr(s[1:]), ValueStr(s[:len(s)-1]))
+		}
+		for i := 0; i < len(s); i++ {
+			if s[i] != 'a' {
+				out = append(out, ValueStr(s[:i]+"a"+s[i+1:]))
+			}
+		}
+	default:
+		items := *asArray(v)
+		if len(items) == 0 {
+			return nil
+		}
+		out = append(out, ValueArray(nil))
+		if len(items) > 1 {
+			out = append(out, ValueArray(items[:len(items)/2]))
+			for i := range items {
+				out = append(out, ValueArray(append(append([]Value{}, items[:i]...), items[i+1:]...)))
+			}
+		}
+		element := g[forAllArrayPrefixSize : len(g)-1]
+		for i, item := range items {
+			for _, smaller := range forAllShrink(element, item) {
+				candidate := append([]Value{}, items...)
+				candidate[i] = smaller
+				out = append(out, ValueArray(candidate))
+			}
+		}
+	}
+	return out
+}
+
+// forAllShrinkArgs shrinks one argument at a time.
+func forAllShrinkArgs(generators []string, args []Value) [][]Value {
+	var out [][]Value
+	for i, g := range generators {
+		for _, smaller := range forAllShrink(g, args[i]) {
+			candidate := append([]Value{}, args...)
+			candidate[i] = smaller
+			out = append(out, candidate)
+		}
+	}
+	return out
+}
+
+// forAllCheck calls property with copies of args, so a property that
+// mutates its arguments cannot change the counterexample. It reports
+// whether the property failed and why: "" for a falsy result, or the
+// error it raised.
+func forAllCheck(property Value, args []Value) (reason string, failed bool) {
+	copies := make([]Value, len(args))
+	for i, arg := range args {
+		copies[i] = valueDeepCopy(arg)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			rethrowLimit(r)
+			reason, failed = strings.TrimPrefix(fmt.Sprint(r), "runtime error: "), true
+		}
+	}()
+	return "", !isTruthy(callValue(property, copies...))
+}
+
+func forAllShow(args []Value) string {
+	if len(args) == 1 {
+		return reprValue(args[0])
+	}
+	return "(" + joinValues(args, reprValue, ", ") + ")"
+}
+
+// coreilConfigure applies the run options `english-compiler run --go`
+// passes through the environment; codegen emits it at the start of main,
+// before coreilTrace so a recording captures the seed:
+//
+//   - COREIL_MAX_STEPS: Limits.MaxSteps
+//   - COREIL_MAX_MEMORY: Limits.MaxMemory, in bytes
+//   - COREIL_MAX_COLLECTION_SIZE: Limits.MaxCollectionSize
+//   - COREIL_TIMEOUT: seconds the program may run before a "timeout"
+//     LimitExceeded (see SetContext)
+//   - COREIL_SEED: seed for the random.* builtins
+//   - COREIL_TRACE=1: print a line to stderr as each IL function call and
+//     side-effecting ExternalCall ends
+//   - COREIL_STEP_TRACE: file to write a statement trace to in coverage
+//     builds (see stepTrace)
+//   - COREIL_DISPLAY: file to write the values of single-value prints to
+//     (see displayLog)
+//   - COREIL_SMTP_ADDR, COREIL_SMTP_USER, COREIL_SMTP_PASSWORD and
+//     COREIL_SMTP_FROM: the SMTP server for email.send, unless the host has
+//     called SetMail
+//
+// A malformed value exits with status 2.
+func coreilConfigure() {
+	e := DefaultEngine
+	limits := e.limits
+	if n, ok := configLimit("COREIL_MAX_STEPS", "step limit"); ok {
+		limits.MaxSteps = n
+	}
+	if n, ok := configLimit("COREIL_MAX_MEMORY", "memory limit"); ok {
+		limits.MaxMemory = n
+	}
+	if n, ok := configLimit("COREIL_MAX_COLLECTION_SIZE", "collection size limit"); ok {
+		limits.MaxCollectionSize = int(n)
+	}
+	if limits != e.limits {
+		e.SetLimits(limits)
+	}
+	if spec := os.Getenv("COREIL_TIMEOUT"); spec != "" {
+		seconds, err := strconv.ParseFloat(spec, 64)
+		if err != nil || !(seconds > 0) {
+			fmt.Fprintf(e.errOut, "COREIL_TIMEOUT: invalid timeout %q\n", spec)
+			os.Exit(2)
+		}
+		// The deadline lasts until the process exits, so there is nothing
+		// to cancel
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(seconds*float64(time.Second)))
+		_ = cancel
+		e.SetContext(ctx)
+	}
+	if spec := os.Getenv("COREIL_SEED"); spec != "" {
+		seed, err := strconv.ParseInt(spec, 10, 64)
+		if err != nil {
+			fmt.Fprintf(e.errOut, "COREIL_SEED: invalid seed %q\n", spec)
+			os.Exit(2)
+		}
+		if e.deterministic != nil {
+			e.deterministic.Seed = seed
+		}
+		e.rng = rand.New(rand.NewSource(seed))
+	}
+	if os.Getenv("COREIL_TRACE") == "1" && e.tracing == nil {
+		e.SetTracing(&TraceConfig{Tracer: textTracer{e.errOut}})
+	}
+	if path := os.Getenv("COREIL_STEP_TRACE"); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			fmt.Fprintf(e.errOut, "COREIL_STEP_TRACE: %v\n", err)
+			os.Exit(2)
+		}
+		e.stepTrace = &stepTrace{f: f, sum: sha256.New()}
+		e.SetOutput(io.MultiWriter(e.dest, e.stepTrace))
+	}
+	if path := os.Getenv("COREIL_DISPLAY"); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			fmt.Fprintf(e.errOut, "COREIL_DISPLAY: %v\n", err)
+			os.Exit(2)
+		}
+		e.display = &displayLog{f: f}
+		e.SetOutput(io.MultiWriter(e.dest, e.display))
+	}
+	if addr := os.Getenv("COREIL_SMTP_ADDR"); addr != "" && e.mail == nil {
+		e.SetMail(&MailConfig{
+			Addr:     addr,
+			Username: os.Getenv("COREIL_SMTP_USER"),
+			Password: os.Getenv("COREIL_SMTP_PASSWORD"),
+			From:     os.Getenv("COREIL_SMTP_FROM"),
+		})
+	}
+}
+
+// configLimit reads a positive integer limit from the environment variable
+// name, exiting with status 2 if it is malformed.
+func configLimit(name, what string) (int64, bool) {
+	spec := os.Getenv(name)
+	if spec == "" {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(spec, 10, 64)
+	if err != nil || n <= 0 {
+		fmt.Fprintf(DefaultEngine.errOut, "%s: invalid %s %q\n", name, what, spec)
+		os.Exit(2)
+	}
+	return n, true
+}
+
+// textTracer is the Tracer behind COREIL_TRACE: it writes one line per
+// function call or ExternalCall span, when the span ends.
+type textTracer struct {
+	w io.Writer
+}
+
+func (t textTracer) Start(ctx context.Context, name string, start time.Time, attrs []SpanAttribute) (context.Context, Span) {
+	return ctx, &textSpan{w: t.w, name: name, start: start, attrs: attrs}
+}
+
+type textSpan struct {
+	w     io.Writer
+	name  string
+	start time.Time
+	attrs []SpanAttribute
+	err   error
+}
+
+func (s *textSpan) RecordError(err error) {
+	s.err = err
+}
+
+func (s *textSpan) End(end time.Time) {
+	if s.name == "coreil.run" {
+		return
+	}
+	line := fmt.Sprintf("trace: %s (%s)", strings.TrimPrefix(s.name, "coreil."), end.Sub(s.start).Round(time.Microsecond))
+	for _, attr := range s.attrs {
+		if attr.Key == "coreil.location" && attr.Value != "" {
+			line += fmt.Sprintf(" at %v", attr.Value)
+		}
+	}
+	if s.err != nil {
+		line += " failed: " + s.err.Error()
+	}
+	fmt.Fprintln(s.w, line)
+}
+
+// ============================================================================
+// Frontend
+// ============================================================================
+
+// ILProgram is a Core IL document as decoded from JSON: "version", "body"
+// and any optional top-level fields.
+type ILProgram map[string]interface{}
+
+// Frontend translates English source text into a Core IL program.
+// coreil_frontend.go implements it for the Anthropic and OpenAI APIs and
+// for local llama.cpp-compatible servers; see NewFrontend.
+type Frontend interface {
+	Translate(english string) (ILProgram, error)
+}
+
+// SetFrontend sets the frontend Translate uses; nil removes it.
+func (e *Engine) SetFrontend(f Frontend) {
+	e.frontend = f
+}
+
+// Translate compiles English source text to Core IL with the engine's
+// frontend. It only checks the program's shape (a "coreil-" version and a
+// body list); the full validator is english-compiler's.
+func (e *Engine) Translate(english string) (ILProgram, error) {
+	if e.frontend == nil {
+		return nil, errors.New("no frontend configured; see SetFrontend")
+	}
+	prog, err := e.frontend.Translate(english)
+	if err != nil {
+		return nil, err
+	}
+	if version, _ := prog["version"].(string); !strings.HasPrefix(version, "coreil-") {
+		return nil, fmt.Errorf("frontend returned a program without a Core IL version: %v", prog["version"])
+	}
+	if _, ok := prog["body"].([]interface{}); !ok {
+		return nil, errors.New("frontend returned a program without a body list")
+	}
+	return prog, nil
+}
+
+// ============================================================================
+// Metrics
+// ============================================================================
+
+// MetricsSink receives the engine's counters and histogram observations.
+// Each carries the labels given to SetMetrics (e.g. a tenant ID) plus any
+// labels of its own.
+type MetricsSink interface {
+	AddCounter(name string, delta int64, labels map[string]string)
+	RecordHistogram(name string, value float64, labels map[string]string)
+}
+
+// SetMetrics reports to sink, with labels attached to every measurement:
+//
+//   - coreil.builtin.calls counts each ExternalCall, labelled builtin
+//   - coreil.errors counts errors, labelled kind (see ErrorKind, e.g.
+//     "KeyError") and caught ("true" when a TryCatch or tryCall handled
+//     it)
+//   - coreil.steps counts the loop iterations and function calls of a run
+//   - coreil.run.seconds and coreil.output.bytes observe the duration and
+//     output size of each run
+//
+// Run-level measurements are made when Run (or a generated program's
+// main) returns. A nil sink turns metrics off. Set it before running code.
+func (e *Engine) SetMetrics(sink MetricsSink, labels map[string]string) {
+	e.metrics = sink
+	e.metricLabels = labels
+	e.updateLimited()
+}
+
+// metricLabelsWith returns the engine's labels plus key=value.
+func (e *Engine) metricLabelsWith(key, value string) map[string]string {
+	labels := make(map[string]string, len(e.metricLabels)+2)
+	for k, v := range e.metricLabels {
+		labels[k] = v
+	}
+	if key != "" {
+		labels[key] = value
+	}
+	return labels
+}
+
+func (e *Engine) count(name string, delta int64, key, value string) {
+	e.metrics.AddCounter(name, delta, e.metricLabelsWith(key, value))
+}
+
+// countError counts a runtime error raised by the program.
+func (e *Engine) countError(r interface{}, caught bool) {
+	labels := e.metricLabelsWith("kind", string(ErrorKindOf(r)))
+	labels["caught"] = strconv.FormatBool(caught)
+	e.metrics.AddCounter("coreil.errors", 1, labels)
+}
+
+// reportRun records the measurements of a run that ended with r.
+func (e *Engine) reportRun(r interface{}) {
+	if r != nil {
+		e.countError(r, false)
+	}
+	e.count("coreil.steps", e.steps, "", "")
+	labels := e.metricLabelsWith("", "")
+	e.metrics.RecordHistogram("coreil.run.seconds", time.Since(e.runStart).Seconds(), labels)
+	e.metrics.RecordHistogram("coreil.output.bytes", float64(e.meter.bytes), labels)
+}
+
+// ============================================================================
+// Concurrency
+// ============================================================================
+
+// Channel carries Values between tasks. Receiving from a closed, drained
+// channel returns None.
+type Channel struct {
+	ch     chan Value
+	closed bool
+	// A spawned task's channel is sent to and closed only by the task; err
+	// is what receiving raises once it is drained, if the task failed.
+	task bool
+	err  interface{}
+}
+
+// channelNew returns a channel buffering up to capacity values; with 0,
+// each send waits for a receiver.
+func channelNew(capacity Value) Value {
+	n := asInt(capacity)
+	if n < 0 {
+		panic(runtimeError(KindValueError, "channel capacity must be non-negative, got %d", n))
+	}
+	return Value{Type: TypeChannel, data: &Channel{ch: make(chan Value, n)}}
+}
+
+// taskState is the part of the engine that belongs to the task running
+// on it: where it is and its IL call stack.
+type taskState struct {
+	loc        SourceLocation
+	calls      []string
+	callers    []SourceLocation
+	callStarts []time.Time
+	unwinding  bool
+	errLoc     SourceLocation
+	errCalls   []string
+	errCallers []SourceLocation
+	ctx        context.Context
+}
+
+// saveTask returns the running task's state.
+func (e *Engine) saveTask() *taskState {
+	return &taskState{e.loc, e.calls, e.callers, e.callStarts, e.unwinding, e.errLoc, e.errCalls, e.errCallers, e.taskCtx}
+}
+
+// restoreTask makes t the running task's state.
+func (e *Engine) restoreTask(t *taskState) {
+	e.loc, e.calls, e.callers, e.callStarts = t.loc, t.calls, t.callers, t.callStarts
+	e.unwinding, e.errLoc, e.errCalls, e.errCallers = t.unwinding, t.errLoc, t.errCalls, t.errCallers
+	e.taskCtx = t.ctx
+}
+
+// suspend saves the running task's state and releases the engine.
+func (e *Engine) suspend() *taskState {
+	t := e.saveTask()
+	e.gil.Unlock()
+	return t
+}
+
+// resume waits for the engine and restores a task's state. A limit or
+// cancellation raised by another task stops this one too.
+func (e *Engine) resume(t *taskState) {
+	e.gil.Lock()
+	e.restoreTask(t)
+	if e.aborted != nil {
+		panic(e.aborted)
+	}
+}
+
+// blocking runs wait, which must not touch the engine, with the engine
+// released so other tasks can run meanwhile.
+func (e *Engine) blocking(wait func()) {
+	if !e.concurrent {
+		wait()
+		return
+	}
+	t := e.suspend()
+	defer e.resume(t)
+	wait()
+}
+
+// spawn calls fn with args on a new goroutine and returns a channel that
+// receives the call's result and is then closed. If the call fails,
+// receiving from the channel raises its error instead, so the spawner can
+// catch it; a limit or cancellation stops every task.
+//
+// Tasks share the engine one at a time: a task runs IL code until it
+// blocks on a channel, a sleep or an HTTP request, and then another task
+// runs. Values sent between tasks are shared, not copied, which is safe
+// because of this.
+func spawn(fn Value, args ...Value) Value {
+	f := asFunc(fn)
+	done := &Channel{ch: make(chan Value, 1), task: true}
+	startTask(taskSpec{fn: f, args: args, finish: func(result Value, err interface{}) {
+		if err == nil {
+			done.ch <- result
+		}
+		done.err = err
+		done.closed = true
+		close(done.ch)
+	}})
+	return Value{Type: TypeChannel, data: done}
+}
+
+// taskSpec describes a call for startTask to run.
+type taskSpec struct {
+	fn   *Function
+	args []Value
+	// acquire, if set, waits for a worker slot before the call starts; it
+	// runs without the engine.
+	acquire func()
+	// timeout, if positive, bounds how long the call may run.
+	timeout time.Duration
+	// finish receives the call's result or the error it raised; it runs
+	// while the task still holds the engine.
+	finish func(result Value, err interface{})
+}
+
+// startTask runs a call on a new goroutine.
+func startTask(t taskSpec) {
+	e := DefaultEngine
+	if !e.concurrent {
+		e.gil.Lock()
+		e.concurrent = true
+	}
+	start := &taskState{loc: e.loc}
+	go func() {
+		if t.acquire != nil {
+			t.acquire()
+		}
+		result := ValueNone
+		defer func() {
+			r := recover()
+			switch r.(type) {
+			case *LimitExceeded, *Cancelled:
+				e.aborted = r
+			}
+			t.finish(result, r)
+			e.gil.Unlock()
+		}()
+		e.resume(start)
+		if t.timeout > 0 {
+			ctx, cancel := context.WithTimeout(e.context(), t.timeout)
+			defer cancel()
+			e.taskCtx = ctx
+		}
+		result = t.fn.fn(t.args)
+	}()
+}
+
+// channelSend sends v on ch, waiting while the channel is full.
+func channelSend(ch, v Value) Value {
+	c := asChannel(ch)
+	if c.task {
+		panic(runtimeError(KindValueError, "cannot send on a task's channel"))
+	}
+	if c.closed {
+		panic(runtimeError(KindValueError, "send on closed channel"))
+	}
+	e := DefaultEngine
+	done := e.context().Done()
+	sent, closed := false, false
+	e.blocking(func() {
+		// Another task may close the channel while this one waits
+		defer func() {
+			if recover() != nil {
+				closed = true
+			}
+		}()
+		select {
+		case c.ch <- v:
+			sent = true
+		case <-done:
+		}
+	})
+	if closed {
+		panic(runtimeError(KindValueError, "send on closed channel"))
+	}
+	if !sent {
+		e.checkContext()
+	}
+	return ValueNone
+}
+
+// channelReceive returns the next value sent on ch, waiting until there is
+// one. Once ch is closed and drained it returns None, or raises the error
+// of a failed spawned task.
+func channelReceive(ch Value) Value {
+	c := asChannel(ch)
+	e := DefaultEngine
+	done := e.context().Done()
+	var v Value
+	got, ok := false, false
+	e.blocking(func() {
+		select {
+		case v, ok = <-c.ch:
+			got = true
+		case <-done:
+		}
+	})
+	if !got {
+		e.checkContext()
+	}
+	if !ok {
+		if c.err != nil {
+			panic(c.err)
+		}
+		return ValueNone
+	}
+	return v
+}
+
+// channelClose closes ch; receivers get its remaining values, then None.
+func channelClose(ch Value) Value {
+	c := asChannel(ch)
+	if c.task {
+		panic(runtimeError(KindValueError, "cannot close a task's channel"))
+	}
+	if c.closed {
+		panic(runtimeError(KindValueError, "close of closed channel"))
+	}
+	c.closed = true
+	close(c.ch)
+	return ValueNone
+}
+
+// selectValue waits until one of cases (an array or tuple) can proceed and
+// performs it. A case is a channel to receive from, or a (channel, value)
+// tuple or array to send on. It returns an (index, value) tuple: the index
+// of the case that fired and the value it received (None for sends and
+// closed channels). With a timeout in seconds, it returns (-1, None) if no
+// case could proceed in time; with None it waits indefinitely. When several
+// cases are ready, one is chosen at random.
+func selectValue(cases, timeout Value) Value {
+	items := iterItems(cases)
+	chans := make([]*Channel, len(items))
+	ops := make([]reflect.SelectCase, 0, len(items)+2)
+	for i, item := range items {
+		if item.Type == TypeChannel {
+			chans[i] = item.data.(*Channel)
+			ops = append(ops, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(chans[i].ch)})
+			continue
+		}
+		var pair []Value
+		if item.Type == TypeTuple || item.Type == TypeArray {
+			pair = iterItems(item)
+		}
+		if len(pair) != 2 {
+			panic(runtimeError(KindTypeError, "select case %d must be a channel or a (channel, value) pair", i))
+		}
+		c := asChannel(pair[0])
+		if c.task {
+			panic(runtimeError(KindValueError, "cannot send on a task's channel"))
+		}
+		if c.closed {
+			panic(runtimeError(KindValueError, "send on closed channel"))
+		}
+		chans[i] = c
+		ops = append(ops, reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(c.ch), Send: reflect.ValueOf(pair[1])})
+	}
+	e := DefaultEngine
+	expired := -1
+	if timeout.Type != TypeNone {
+		t := time.NewTimer(time.Duration(asFloat(timeout) * float64(time.Second)))
+		defer t.Stop()
+		expired = len(ops)
+		ops = append(ops, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(t.C)})
+	}
+	cancelled := -1
+	if done := e.context().Done(); done != nil {
+		cancelled = len(ops)
+		ops = append(ops, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)})
+	}
+	var chosen int
+	var recv reflect.Value
+	var ok, closed bool
+	e.blocking(func() {
+		// Another task may close a channel this one is sending on
+		defer func() {
+			if recover() != nil {
+				closed = true
+			}
+		}()
+		chosen, recv, ok = reflect.Select(ops)
+	})
+	switch {
+	case closed:
+		panic(runtimeError(KindValueError, "send on closed channel"))
+	case chosen == cancelled:
+		e.checkContext()
+	case chosen == expired:
+		return ValueTupleNew([]Value{ValueInt(-1), ValueNone})
+	}
+	result := ValueNone
+	if items[chosen].Type == TypeChannel {
+		if ok {
+			result = recv.Interface().(Value)
+		} else if c := chans[chosen]; c.err != nil {
+			panic(c.err)
+		}
+	}
+	return ValueTupleNew([]Value{ValueInt(int64(chosen)), result})
+}
+
+// Future is the eventual result of a call started by taskStart.
+type Future struct {
+	done   chan struct{} // closed once the call has finished
+	result Value
+	err    interface{}
+}
+
+// taskStart calls fn with args on a new goroutine, like spawn, and returns
+// a Future for its result.
+func taskStart(fn Value, args ...Value) Value {
+	fut := newFuture()
+	startTask(taskSpec{fn: asFunc(fn), args: args, finish: fut.settle})
+	return Value{Type: TypeFuture, data: fut}
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+// settle records the call's outcome and wakes its waiters.
+func (f *Future) settle(result Value, err interface{}) {
+	f.result, f.err = result, err
+	close(f.done)
+}
+
+// value returns the result of a finished future, or raises its error.
+func (f *Future) value() Value {
+	if f.err != nil {
+		panic(f.err)
+	}
+	return f.result
+}
+
+// awaitFirst waits until one of futs has finished and returns its index.
+func awaitFirst(futs []*Future) int {
+	e := DefaultEngine
+	ops := make([]reflect.SelectCase, 0, len(futs)+1)
+	for _, f := range futs {
+		ops = append(ops, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(f.done)})
+	}
+	if done := e.context().Done(); done != nil {
+		ops = append(ops, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)})
+	}
+	var chosen int
+	e.blocking(func() {
+		chosen, _, _ = reflect.Select(ops)
+	})
+	if chosen == len(futs) {
+		e.checkContext()
+	}
+	return chosen
+}
+
+func asFutures(v Value) []*Future {
+	items := iterItems(v)
+	futs := make([]*Future, len(items))
+	for i, item := range items {
+		futs[i] = asFuture(item)
+	}
+	return futs
+}
+
+// await waits for future and returns its result, or raises the error its
+// call raised. A future can be awaited any number of times.
+func await(future Value) Value {
+	f := asFuture(future)
+	awaitFirst([]*Future{f})
+	return f.value()
+}
+
+// awaitAll waits for every future in futures (an array or tuple) and
+// returns an array of their results in order. If any call fails it raises
+// that error as soon as it happens, without waiting for the rest.
+func awaitAll(futures Value) Value {
+	pending := asFutures(futures)
+	results := make([]Value, len(pending))
+	index := make([]int, len(pending))
+	for i := range index {
+		index[i] = i
+	}
+	for len(pending) > 0 {
+		i := awaitFirst(pending)
+		results[index[i]] = pending[i].value()
+		pending = append(pending[:i], pending[i+1:]...)
+		index = append(index[:i], index[i+1:]...)
+	}
+	return ValueArray(results)
+}
+
+// awaitAny waits for the first of futures to finish and returns an (index,
+// result) tuple, or raises the error of that future's call.
+func awaitAny(futures Value) Value {
+	futs := asFutures(futures)
+	if len(futs) == 0 {
+		panic(runtimeError(KindValueError, "awaitAny() arg is an empty sequence"))
+	}
+	i := awaitFirst(futs)
+	return ValueTupleNew([]Value{ValueInt(int64(i)), futs[i].value()})
+}
+
+// Pool runs submitted calls on at most a fixed number of tasks at once.
+type Pool struct {
+	slots   chan struct{} // one token per running call
+	queued  chan struct{} // one token per unfinished call; nil if unbounded
+	timeout time.Duration
+	futures []*Future
+}
+
+// poolNew returns a pool that runs up to workers calls at once. If queue
+// is non-negative, submit waits while workers+queue calls are unfinished;
+// a negative queue never waits. If timeout is positive, a call still
+// running after that many seconds raises a TimeoutError.
+func poolNew(workers, queue, timeout Value) Value {
+	n := asInt(workers)
+	if n < 1 {
+		panic(runtimeError(KindValueError, "pool needs at least one worker, got %d", n))
+	}
+	p := &Pool{slots: make(chan struct{}, n)}
+	if q := asInt(queue); q >= 0 {
+		p.queued = make(chan struct{}, n+q)
+	}
+	if secs := asFloat(timeout); secs > 0 {
+		p.timeout = time.Duration(secs * float64(time.Second))
+		DefaultEngine.timedTasks = true
+		DefaultEngine.updateLimited()
+	}
+	return Value{Type: TypePool, data: p}
+}
+
+// poolSubmit queues a call of fn with args on pool and returns a Future
+// for its result, first waiting for room if the pool's queue is full.
+func poolSubmit(pool, fn Value, args ...Value) Value {
+	e := DefaultEngine
+	p := asPool(pool)
+	f := asFunc(fn)
+	if p.queued != nil {
+		select {
+		case p.queued <- struct{}{}:
+		default:
+			done := e.context().Done()
+			e.blocking(func() {
+				select {
+				case p.queued <- struct{}{}:
+				case <-done:
+				}
+			})
+			e.checkContext()
+		}
+	}
+	fut := newFuture()
+	p.futures = append(p.futures, fut)
+	startTask(taskSpec{
+		fn:      f,
+		args:    args,
+		acquire: func() { p.slots <- struct{}{} },
+		timeout: p.timeout,
+		finish: func(result Value, err interface{}) {
+			<-p.slots
+			if p.queued != nil {
+				<-p.queued
+			}
+			fut.settle(result, err)
+		},
+	})
+	return Value{Type: TypeFuture, data: fut}
+}
+
+// poolWaitAll waits until every call submitted to pool so far has
+// finished. Failed calls do not raise here; see poolResults.
+func poolWaitAll(pool Value) Value {
+	pending := append([]*Future(nil), asPool(pool).futures...)
+	for len(pending) > 0 {
+		i := awaitFirst(pending)
+		pending = append(pending[:i], pending[i+1:]...)
+	}
+	return ValueNone
+}
+
+// poolResults waits for pool's calls and returns their outcomes in
+// submission order, each Ok(result) or Err(message). A limit or
+// cancellation is raised rather than reported.
+func poolResults(pool Value) Value {
+	poolWaitAll(pool)
+	futs := asPool(pool).futures
+	results := make([]Value, len(futs))
+	for i, f := range futs {
+		switch f.err.(type) {
+		case nil:
+			results[i] = resultOk(f.result)
+		case *LimitExceeded, *Cancelled:
+			panic(f.err)
+		default:
+			results[i] = resultErr(caughtError(f.err))
+		}
+	}
+	return ValueArray(results)
+}
+
+// Lock is a mutex for IL tasks. Only one task runs IL code at a time, so
+// a lock matters when a task waits (on a channel, time.sleep, http.get)
+// partway through updating shared state.
+type Lock struct {
+	held chan struct{} // holds a token while the lock is taken
+}
+
+func lockNew() Value {
+	return Value{Type: TypeLock, data: &Lock{held: make(chan struct{}, 1)}}
+}
+
+// lockAcquire takes lock, waiting while another task holds it.
+func lockAcquire(lock Value) Value {
+	e := DefaultEngine
+	l := asLock(lock)
+	select {
+	case l.held <- struct{}{}:
+		return ValueNone
+	default:
+	}
+	done := e.context().Done()
+	acquired := false
+	e.blocking(func() {
+		select {
+		case l.held <- struct{}{}:
+			acquired = true
+		case <-done:
+		}
+	})
+	if !acquired {
+		e.checkContext()
+	}
+	return ValueNone
+}
+
+func lockRelease(lock Value) Value {
+	select {
+	case <-asLock(lock).held:
+	default:
+		panic(runtimeError(KindRuntimeError, "release of unlocked lock"))
+	}
+	return ValueNone
+}
+
+// withLock calls fn with args while holding lock, releasing it however
+// the call ends.
+func withLock(lock, fn Value, args ...Value) Value {
+	f := asFunc(fn)
+	lockAcquire(lock)
+	defer lockRelease(lock)
+	return f.fn(args)
+}
+
+// Counter is an integer that IL tasks and host goroutines can update
+// without a lock.
+type Counter struct {
+	n int64
+}
+
+func counterNew(initial Value) Value {
+	return Value{Type: TypeCounter, data: &Counter{n: asInt(initial)}}
+}
+
+// counterAdd adds delta to counter and returns the new value.
+func counterAdd(counter, delta Value) Value {
+	return ValueInt(atomic.AddInt64(&asCounter(counter).n, asInt(delta)))
+}
+
+func counterGet(counter Value) Value {
+	return ValueInt(atomic.LoadInt64(&asCounter(counter).n))
+}
+
+func counterSet(counter, v Value) Value {
+	atomic.StoreInt64(&asCounter(counter).n, asInt(v))
+	return ValueNone
+}
+
+// Actor handles the messages sent to it one at a time, in order, on its
+// own task, so its state needs no locks.
+type Actor struct {
+	mu      sync.Mutex
+	mailbox []actorMessage
+	wake    chan struct{} // signalled when mail arrives
+	stopped interface{}   // error that stopped the actor
+}
+
+type actorMessage struct {
+	msg   Value
+	reply *Future // nil for actorSend
+}
+
+// spawnActor starts an actor that calls handler with each message and
+// returns its address. An error raised while handling an actorAsk goes
+// back to the asker; one raised while handling an actorSend stops the
+// actor, and later messages to it raise that error.
+func spawnActor(handler Value) Value {
+	asFunc(handler)
+	a := &Actor{wake: make(chan struct{}, 1)}
+	loop := ValueFunc("actor", func(args []Value) Value {
+		for {
+			m := a.receive()
+			result, err := handleMessage(handler, m.msg)
+			if m.reply != nil {
+				m.reply.settle(result, err)
+			} else if err != nil {
+				a.stop(err)
+				return ValueNone
+			}
+		}
+	})
+	startTask(taskSpec{fn: asFunc(loop), finish: func(Value, interface{}) {}})
+	return Value{Type: TypeActor, data: a}
+}
+
+// handleMessage calls handler with msg, returning the error it raised
+// unless that error is a limit or cancellation.
+func handleMessage(handler, msg Value) (result Value, err interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			rethrowLimit(r)
+			err = r
+		}
+	}()
+	return callValue(handler, msg), nil
+}
+
+// receive returns the actor's next message, waiting for one to arrive.
+func (a *Actor) receive() actorMessage {
+	e := DefaultEngine
+	for {
+		a.mu.Lock()
+		if len(a.mailbox) > 0 {
+			m := a.mailbox[0]
+			a.mailbox = a.mailbox[1:]
+			a.mu.Unlock()
+			return m
+		}
+		a.mu.Unlock()
+		done := e.context().Done()
+		e.blocking(func() {
+			select {
+			case <-a.wake:
+			case <-done:
+			}
+		})
+		e.checkContext()
+	}
+}
+
+func (a *Actor) post(m actorMessage) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stopped != nil {
+		panic(a.stopped)
+	}
+	a.mailbox = append(a.mailbox, m)
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stop records err and fails the asks still waiting in the mailbox.
+func (a *Actor) stop(err interface{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stopped = err
+	for _, m := range a.mailbox {
+		if m.reply != nil {
+			m.reply.settle(ValueNone, err)
+		}
+	}
+	a.mailbox = nil
+}
+
+// actorSend queues msg for the actor at addr without waiting for it to
+// be handled.
+func actorSend(addr, msg Value) Value {
+	asActor(addr).post(actorMessage{msg: msg})
+	return ValueNone
+}
+
+// actorAsk sends msg to the actor at addr and returns the handler's
+// result, raising a TimeoutError if it takes longer than a positive
+// timeout in seconds.
+func actorAsk(addr, msg, timeout Value) Value {
+	e := DefaultEngine
+	reply := newFuture()
+	asActor(addr).post(actorMessage{msg: msg, reply: reply})
+	var expired <-chan time.Time
+	if secs := asFloat(timeout); secs > 0 {
+		t := time.NewTimer(time.Duration(secs * float64(time.Second)))
+		defer t.Stop()
+		expired = t.C
+	}
+	done := e.context().Done()
+	timedOut := false
+	e.blocking(func() {
+		select {
+		case <-reply.done:
+		case <-expired:
+			timedOut = true
+		case <-done:
+		}
+	})
+	select {
+	case <-reply.done:
+		return reply.value()
+	default:
+	}
+	e.checkContext()
+	if timedOut {
+		panic(runtimeError(KindTimeoutError, "ask timed out after %gs", asFloat(timeout)))
+	}
+	return reply.value()
+}
+
+// EventBus calls the handlers registered for an event when it is
+// emitted. Events published by the host wait in a queue until the
+// program dispatches them, so handlers only ever run on program tasks.
+type EventBus struct {
+	handlers map[string][]Value
+	mu       sync.Mutex
+	pending  []busEvent // published by the host, not yet dispatched
+	wake     chan struct{}
+}
+
+type busEvent struct {
+	name    string
+	payload Value
+}
+
+func newEventBus() *EventBus {
+	return &EventBus{handlers: map[string][]Value{}, wake: make(chan struct{}, 1)}
+}
+
+func eventBusNew() Value {
+	return Value{Type: TypeEventBus, data: newEventBus()}
+}
+
+// eventBus returns the engine's bus, which receives the events the host
+// publishes.
+func eventBus() Value {
+	return Value{Type: TypeEventBus, data: DefaultEngine.events}
+}
+
+// Publish queues an event on the engine's bus for the program to handle
+// at its next eventDispatch. It is safe to call from any goroutine while
+// the program runs.
+func (e *Engine) Publish(event string, payload Value) {
+	b := e.events
+	b.mu.Lock()
+	b.pending = append(b.pending, busEvent{event, payload})
+	b.mu.Unlock()
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// eventOn registers handler to be called with the payload of each event
+// emitted on bus.
+func eventOn(bus, event, handler Value) Value {
+	b := asEventBus(bus)
+	asFunc(handler)
+	name := asString(event)
+	b.handlers[name] = append(b.handlers[name], handler)
+	return ValueNone
+}
+
+// eventOff unregisters handler from event; handlers are compared by
+// identity.
+func eventOff(bus, event, handler Value) Value {
+	b := asEventBus(bus)
+	name := asString(event)
+	kept := b.handlers[name][:0]
+	for _, h := range b.handlers[name] {
+		if h.data != handler.data {
+			kept = append(kept, h)
+		}
+	}
+	b.handlers[name] = kept
+	return ValueNone
+}
+
+// eventEmit calls event's handlers in registration order with payload and
+// returns how many were called. A handler's error stops the emit and is
+// raised in the emitter.
+func eventEmit(bus, event, payload Value) Value {
+	b := asEventBus(bus)
+	handlers := append([]Value(nil), b.handlers[asString(event)]...)
+	for _, h := range handlers {
+		callValue(h, payload)
+	}
+	return ValueInt(int64(len(handlers)))
+}
+
+// eventDispatch emits the events published to bus by the host, waiting
+// up to timeout seconds for the first one (forever if timeout is
+// negative), and returns how many events it emitted.
+func eventDispatch(bus, timeout Value) Value {
+	e := DefaultEngine
+	b := asEventBus(bus)
+	events := b.take()
+	if len(events) == 0 && asFloat(timeout) != 0 {
+		var expired <-chan time.Time
+		if secs := asFloat(timeout); secs > 0 {
+			t := time.NewTimer(time.Duration(secs * float64(time.Second)))
+			defer t.Stop()
+			expired = t.C
+		}
+		done := e.context().Done()
+		for len(events) == 0 {
+			timedOut := false
+			e.blocking(func() {
+				select {
+				case <-b.wake:
+				case <-expired:
+					timedOut = true
+				case <-done:
+				}
+			})
+			e.checkContext()
+			events = b.take()
+			if timedOut {
+				break
+			}
+		}
+	}
+	for _, ev := range events {
+		eventEmit(bus, ValueStr(ev.name), ev.payload)
+	}
+	return ValueInt(int64(len(events)))
+}
+
+// take removes and returns the events waiting on the bus.
+func (b *EventBus) take() []busEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	events := b.pending
+	b.pending = nil
+	return events
+}
+
+// Timer calls a function at scheduled times on its own task until it is
+// cancelled, runs out of times, or the function raises.
+type Timer struct {
+	stop     chan struct{} // closed by timerCancel
+	stopOnce sync.Once
+	done     chan struct{} // closed once the timer's task has ended
+	err      interface{}
+}
+
+// startTimer calls fn with args at each time next returns, given the
+// previous one, until next reports there are no more.
+func startTimer(fn Value, args []Value, next func(prev time.Time) (time.Time, bool)) Value {
+	asFunc(fn)
+	t := &Timer{stop: make(chan struct{}), done: make(chan struct{})}
+	loop := ValueFunc("timer", func([]Value) Value {
+		at := time.Now()
+		for {
+			var ok bool
+			if at, ok = next(at); !ok || !t.sleepUntil(at) {
+				return ValueNone
+			}
+			callValue(fn, args...)
+		}
+	})
+	startTask(taskSpec{fn: asFunc(loop), finish: func(_ Value, err interface{}) {
+		t.err = err
+		close(t.done)
+	}})
+	return Value{Type: TypeTimer, data: t}
+}
+
+// sleepUntil waits until at, reporting false if the timer was cancelled
+// first.
+func (t *Timer) sleepUntil(at time.Time) bool {
+	e := DefaultEngine
+	wait := time.NewTimer(time.Until(at))
+	defer wait.Stop()
+	done := e.context().Done()
+	fired := false
+	e.blocking(func() {
+		select {
+		case <-wait.C:
+			fired = true
+		case <-t.stop:
+		case <-done:
+		}
+	})
+	e.checkContext()
+	return fired
+}
+
+func asSeconds(v Value) time.Duration {
+	secs := asFloat(v)
+	if secs < 0 {
+		panic(runtimeError(KindValueError, "delay must be non-negative, got %s", formatValue(v)))
+	}
+	return time.Duration(secs * float64(time.Second))
+}
+
+// setTimeout calls fn with args once, after seconds.
+func setTimeout(fn, seconds Value, args ...Value) Value {
+	d := asSeconds(seconds)
+	fired := false
+	return startTimer(fn, args, func(prev time.Time) (time.Time, bool) {
+		if fired {
+			return prev, false
+		}
+		fired = true
+		return prev.Add(d), true
+	})
+}
+
+// setInterval calls fn with args every seconds. Times are counted from
+// the start, so a slow call does not push later ones back.
+func setInterval(fn, seconds Value, args ...Value) Value {
+	d := asSeconds(seconds)
+	if d <= 0 {
+		panic(runtimeError(KindValueError, "interval must be positive, got %s", formatValue(seconds)))
+	}
+	return startTimer(fn, args, func(prev time.Time) (time.Time, bool) {
+		return prev.Add(d), true
+	})
+}
+
+// scheduleEvery calls fn with args on a schedule given either as a
+// duration ("90s", "5m", "1h30m") or as a five-field cron expression
+// ("*/10 * * * *") in local time.
+func scheduleEvery(spec, fn Value, args ...Value) Value {
+	text := strings.TrimSpace(asString(spec))
+	if !strings.Contains(text, " ") {
+		d, err := time.ParseDuration(text)
+		if err != nil || d <= 0 {
+			panic(runtimeError(KindValueError, "invalid schedule %q", text))
+		}
+		return setInterval(fn, ValueFloat(d.Seconds()), args...)
+	}
+	c := parseCron(text)
+	if _, ok := c.next(time.Now()); !ok {
+		panic(runtimeError(KindValueError, "cron schedule %q never fires", text))
+	}
+	return startTimer(fn, args, c.next)
+}
+
+// timerCancel stops timer; a call already running finishes first.
+func timerCancel(timer Value) Value {
+	t := asTimer(timer)
+	t.stopOnce.Do(func() { close(t.stop) })
+	return ValueNone
+}
+
+// timerWait waits until timer has stopped and raises the error that
+// stopped it, if any.
+func timerWait(timer Value) Value {
+	e := DefaultEngine
+	t := asTimer(timer)
+	done := e.context().Done()
+	e.blocking(func() {
+		select {
+		case <-t.done:
+		case <-done:
+		}
+	})
+	e.checkContext()
+	if t.err != nil {
+		panic(t.err)
+	}
+	return ValueNone
+}
+
+// cronSchedule is a parsed cron expression: the allowed minutes, hours,
+// days of the month, months and weekdays, as bit sets.
+type cronSchedule struct {
+	fields         [5]uint64
+	anyDom, anyDow bool
+}
+
+var cronRanges = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+func parseCron(text string) *cronSchedule {
+	parts := strings.Fields(text)
+	if len(parts) != 5 {
+		panic(runtimeError(KindValueError, "cron expression %q needs 5 fields, got %d", text, len(parts)))
+	}
+	c := &cronSchedule{anyDom: parts[2] == "*", anyDow: parts[4] == "*"}
+	for i, part := range parts {
+		lo, hi := cronRanges[i][0], cronRanges[i][1]
+		for _, item := range strings.Split(part, ",") {
+			start, end, step := lo, hi, 1
+			rng := item
+			if j := strings.Index(item, "/"); j >= 0 {
+				n, err := strconv.Atoi(item[j+1:])
+				if err != nil || n <= 0 {
+					panic(runtimeError(KindValueError, "invalid cron step in %q", item))
+				}
+				rng, step = item[:j], n
+			}
+			if rng != "*" {
+				var err error
+				if j := strings.Index(rng, "-"); j >= 0 {
+					start, err = strconv.Atoi(rng[:j])
+					if err == nil {
+						end, err = strconv.Atoi(rng[j+1:])
+					}
+				} else if start, err = strconv.Atoi(rng); err == nil && step == 1 {
+					end = start
+				}
+				if err != nil || start < lo || end > hi || start > end {
+					panic(runtimeError(KindValueError, "invalid cron field %q", item))
+				}
+			}
+			for v := start; v <= end; v += step {
+				c.fields[i] |= 1 << uint(v)
+			}
+		}
+	}
+	// Sunday is both 0 and 7.
+	if c.fields[4]&(1<<7) != 0 {
+		c.fields[4] |= 1
+	}
+	return c
+}
+
+func (c *cronSchedule) has(field, v int) bool {
+	return c.fields[field]&(1<<uint(v)) != 0
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom, dow := c.has(2, t.Day()), c.has(4, int(t.Weekday()))
+	if c.anyDom || c.anyDow {
+		return dom && dow
+	}
+	// As in cron, restricting both fields matches either.
+	return dom || dow
+}
+
+// next returns the first minute after prev that the schedule matches,
+// searching up to five years ahead.
+func (c *cronSchedule) next(prev time.Time) (time.Time, bool) {
+	t := prev.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !c.has(3, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !c.has(1, t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !c.has(0, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return t, false
+}
+
+// Generator runs a function lazily, one yielded value at a time. The
+// function runs on its own goroutine but never at the same time as its
+// consumer: control passes back and forth at each yield, together with
+// the engine.
+type Generator struct {
+	fn       Value
+	args     []Value
+	state    *taskState   // the generator's engine state while paused
+	resume   chan bool    // consumer to generator: continue, or stop if true
+	out      chan genStep // generator to consumer: a value, or the end
+	started  bool
+	finished bool
+	yielded  int
+	err      interface{}
+	// A native generator has no function or goroutine: next gives its
+	// values, and stop releases what it reads from if it is closed early.
+	next func() (Value, bool)
+	stop func()
+}
+
+type genStep struct {
+	value Value
+	done  bool
+}
+
+// generatorExit unwinds a generator that generatorClose stopped.
+type generatorExit struct{}
+
+// generator returns a generator that calls fn with a yield function
+// followed by args. Each generatorNext runs fn until it calls yield and
+// returns the yielded value.
+func generator(fn Value, args ...Value) Value {
+	asFunc(fn)
+	g := &Generator{
+		fn:     fn,
+		args:   args,
+		state:  &taskState{loc: DefaultEngine.loc},
+		resume: make(chan bool),
+		out:    make(chan genStep),
+	}
+	if e := DefaultEngine; e.checkpoints != nil {
+		e.generators = append(e.generators, g)
+	}
+	return Value{Type: TypeGenerator, data: g}
+}
+
+// nativeGenerator returns a generator of the values next gives until it
+// reports false, for runtime builtins that produce values lazily. name
+// identifies it in checkpoints, as a generator's function name does.
+func nativeGenerator(name string, next func() (Value, bool), stop func()) Value {
+	g := &Generator{fn: ValueFunc(name, nil), next: next, stop: stop, started: true}
+	if e := DefaultEngine; e.checkpoints != nil {
+		e.generators = append(e.generators, g)
+	}
+	return Value{Type: TypeGenerator, data: g}
+}
+
+func (g *Generator) run() {
+	defer func() {
+		r := recover()
+		if _, ok := r.(generatorExit); !ok {
+			g.err = r
+		}
+		g.out <- genStep{done: true}
+	}()
+	yield := ValueFunc("yield", func(args []Value) Value {
+		if len(args) != 1 {
+			panic(runtimeError(KindTypeError, "yield() takes 1 argument, got %d", len(args)))
+		}
+		g.out <- genStep{value: args[0]}
+		if <-g.resume {
+			panic(generatorExit{})
+		}
+		return ValueNone
+	})
+	callValue(g.fn, append([]Value{yield}, g.args...)...)
+}
+
+// step hands control to the generator until it yields or ends.
+func (g *Generator) step(stop bool) genStep {
+	if g.next != nil {
+		return g.stepNative(stop)
+	}
+	e := DefaultEngine
+	consumer := e.saveTask()
+	e.restoreTask(g.state)
+	if !g.started {
+		g.started = true
+		go g.run()
+	} else {
+		g.resume <- stop
+	}
+	s := <-g.out
+	g.state = e.saveTask()
+	e.restoreTask(consumer)
+	if s.done {
+		g.finished = true
+	} else {
+		g.yielded++
+	}
+	return s
+}
+
+// stepNative takes the next value of a native generator on the
+// consumer's own task. An error next raises is raised to the consumer.
+func (g *Generator) stepNative(stop bool) genStep {
+	if stop {
+		g.stop()
+		return genStep{done: true}
+	}
+	value, ok := g.next()
+	if !ok {
+		g.finished = true
+		return genStep{done: true}
+	}
+	g.yielded++
+	return genStep{value: value}
+}
+
+// generatorNext runs gen to its next yield and returns Some(value), or
+// None once the generator's function has returned. An error the function
+// raises is raised here.
+func generatorNext(gen Value) Value {
+	g := asGenerator(gen)
+	if g.finished {
+		return optionNoneOf()
+	}
+	s := g.step(false)
+	if !s.done {
+		return optionSome(s.value)
+	}
+	if g.err != nil {
+		panic(g.err)
+	}
+	return optionNoneOf()
+}
+
+// generatorClose stops a paused generator, unwinding its function from
+// the pending yield.
+func generatorClose(gen Value) Value {
+	g := asGenerator(gen)
+	if g.started && !g.finished {
+		g.step(true)
+	}
+	g.finished = true
+	return ValueNone
+}
+
+// drain returns the values the generator has left to yield.
+func (g *Generator) drain() []Value {
+	var items []Value
+	gen := Value{Type: TypeGenerator, data: g}
+	for {
+		next := asOptional(generatorNext(gen))
+		if next.tag != "Some" {
+			return items
+		}
+		items = append(items, next.payload)
+	}
+}
+
+// Memo caches a function's results by the value hash of its arguments,
+// like Python's functools.lru_cache. Beyond maxSize entries (if positive)
+// the least recently used result is evicted. Calls that raise are not
+// cached.
+type Memo struct {
+	maxSize      int
+	entries      map[string]*list.Element
+	order        *list.List // of *memoEntry, most recently used first
+	hits, misses int64
+}
+
+type memoEntry struct {
+	key   string
+	value Value
+}
+
+func makeMemo(maxSize int) *Memo {
+	return &Memo{maxSize: maxSize, entries: map[string]*list.Element{}, order: list.New()}
+}
+
+// newMemo returns the cache for the memoized function definition name,
+// which memoStats and memoClear also accept by name.
+func newMemo(name string, maxSize int) *Memo {
+	e := DefaultEngine
+	if e.memos == nil {
+		e.memos = map[string]*Memo{}
+	}
+	m := makeMemo(maxSize)
+	e.memos[name] = m
+	return m
+}
+
+// call returns the cached result for args, or computes and caches it.
+// Arguments must be hashable.
+func (m *Memo) call(args []Value, compute func() Value) Value {
+	// The key tuple is only hashed, never handed to program code, so its
+	// items can live in a pooled buffer.
+	buf := getValueBuf(len(args))
+	copy(*buf, args)
+	key := hashKey(Value{Type: TypeTuple, data: *buf})
+	putValueBuf(buf)
+	if el, ok := m.entries[key]; ok {
+		m.hits++
+		m.order.MoveToFront(el)
+		return el.Value.(*memoEntry).value
+	}
+	m.misses++
+	v := compute()
+	if el, ok := m.entries[key]; ok {
+		// A recursive call with the same arguments got there first.
+		el.Value.(*memoEntry).value = v
+		return v
+	}
+	m.entries[key] = m.order.PushFront(&memoEntry{key, v})
+	trackGrowth(m.order.Len(), 2*valueBytes)
+	if m.maxSize > 0 && m.order.Len() > m.maxSize {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoEntry).key)
+	}
+	return v
+}
+
+// memoize returns a function that caches fn's results, keeping at most
+// maxSize of them if maxSize is positive.
+func memoize(fn, maxSize Value) Value {
+	f := asFunc(fn)
+	m := makeMemo(int(asInt(maxSize)))
+	return Value{Type: TypeFunc, data: &Function{name: f.name, memo: m, fn: func(args []Value) Value {
+		return m.call(args, func() Value { return f.fn(args) })
+	}}}
+}
+
+// memoOf finds the cache of a memoized function value, or of a memoized
+// function definition given its name.
+func memoOf(fn Value, caller string) *Memo {
+	var m *Memo
+	if fn.Type == TypeStr {
+		m = DefaultEngine.memos[asString(fn)]
+	} else {
+		m = asFunc(fn).memo
+	}
+	if m == nil {
+		panic(runtimeError(KindTypeError, "%s() needs a memoized function, got %s", caller, formatValue(fn)))
+	}
+	return m
+}
+
+// memoStats returns a map of fn's cache hits, misses, size and maxsize.
+func memoStats(fn Value) Value {
+	m := memoOf(fn, "memoStats")
+	stats := ValueMapEmpty()
+	mapSet(stats, ValueStr("hits"), ValueInt(m.hits))
+	mapSet(stats, ValueStr("misses"), ValueInt(m.misses))
+	mapSet(stats, ValueStr("size"), ValueInt(int64(m.order.Len())))
+	mapSet(stats, ValueStr("maxsize"), ValueInt(int64(m.maxSize)))
+	return stats
+}
+
+// memoClear empties fn's cache and resets its statistics.
+func memoClear(fn Value) Value {
+	m := memoOf(fn, "memoClear")
+	m.entries = map[string]*list.Element{}
+	m.order.Init()
+	m.hits, m.misses = 0, 0
+	return ValueNone
+}
+
+// Pattern is a non-literal pattern for matchValue; see the pat*
+// constructors.
+type Pattern struct {
+	kind  string // "any", "bind", "type", "rest", "variant" or "or"
+	name  string // bound name, type name or variant tag
+	class *Class // class of a type pattern, if given one
+	sub   []Value
+}
+
+func newPattern(p *Pattern) Value {
+	return Value{Type: TypePattern, data: p}
+}
+
+// patAny matches anything without binding it.
+func patAny() Value { return newPattern(&Pattern{kind: "any"}) }
+
+// patBind matches what sub (if given) matches and binds it to name.
+func patBind(name Value, sub ...Value) Value {
+	return newPattern(&Pattern{kind: "bind", name: asString(name), sub: sub})
+}
+
+// patType matches values of a type, named as typeName reports it (or
+// "number" for int and float) or given as a class, whose value also
+// matches sub if given.
+func patType(typ Value, sub ...Value) Value {
+	p := &Pattern{kind: "type", sub: sub}
+	if typ.Type == TypeClass {
+		p.class = asClass(typ)
+	} else {
+		p.name = asString(typ)
+	}
+	return newPattern(p)
+}
+
+// patRest, inside a sequence pattern, matches the remaining items and
+// binds them as an array to name, unless name is empty.
+func patRest(name Value) Value {
+	return newPattern(&Pattern{kind: "rest", name: asString(name)})
+}
+
+// patVariant matches a variant with the given tag whose payload also
+// matches sub if given.
+func patVariant(tag Value, sub ...Value) Value {
+	return newPattern(&Pattern{kind: "variant", name: asString(tag), sub: sub})
+}
+
+// patOr matches what any of alts matches, trying them in order.
+func patOr(alts ...Value) Value {
+	return newPattern(&Pattern{kind: "or", sub: alts})
+}
+
+// matchValue tries each case against subject and returns (index,
+// bindings) for the first that matches, or (-1, None). A case is a
+// tuple or array holding a pattern and optionally a guard, a function
+// called with the bindings map that must return a truthy value.
+//
+// Arrays and tuples in a pattern match sequences of the same length item
+// by item (or at least that length, with a patRest); maps match maps that
+// have all of their keys, with matching values; pattern values match as
+// their constructors describe; anything else matches equal values.
+func matchValue(subject, cases Value) Value {
+	for i, c := range iterItems(cases) {
+		parts := iterItems(c)
+		if len(parts) < 1 || len(parts) > 2 {
+			panic(runtimeError(KindValueError, "match case must be (pattern) or (pattern, guard), got %d items", len(parts)))
+		}
+		binds := ValueMapEmpty()
+		if !matchPattern(parts[0], subject, binds) {
+			continue
+		}
+		if len(parts) == 2 && parts[1].Type != TypeNone && !isTruthy(callValue(parts[1], binds)) {
+			continue
+		}
+		return ValueTupleNew([]Value{ValueInt(int64(i)), binds})
+	}
+	return ValueTupleNew([]Value{ValueInt(-1), ValueNone})
+}
+
+func matchPattern(pattern, v, binds Value) bool {
+	switch pattern.Type {
+	case TypePattern:
+		return pattern.data.(*Pattern).match(v, binds)
+	case TypeArray, TypeTuple:
+		if v.Type != TypeArray && v.Type != TypeTuple {
+			return false
+		}
+		return matchSequence(iterItems(pattern), iterItems(v), binds)
+	case TypeMap:
+		if v.Type != TypeMap {
+			return false
+		}
+		pm, vm := pattern.data.(*OrderedMap), v.data.(*OrderedMap)
+		for _, k := range pm.keys {
+			item, ok := vm.Get(k)
+			if !ok || !matchPattern(pm.values[k], item, binds) {
+				return false
+			}
+		}
+		return true
+	default:
+		return valueEqual(pattern, v)
+	}
+}
+
+func matchSequence(pats, items []Value, binds Value) bool {
+	rest := -1
+	for i, p := range pats {
+		if p.Type == TypePattern && p.data.(*Pattern).kind == "rest" {
+			if rest >= 0 {
+				panic(runtimeError(KindValueError, "a sequence pattern can have only one patRest"))
+			}
+			rest = i
+		}
+	}
+	if rest < 0 {
+		if len(items) != len(pats) {
+			return false
+		}
+		for i, p := range pats {
+			if !matchPattern(p, items[i], binds) {
+				return false
+			}
+		}
+		return true
+	}
+	after := len(pats) - rest - 1
+	if len(items) < len(pats)-1 {
+		return false
+	}
+	for i := 0; i < rest; i++ {
+		if !matchPattern(pats[i], items[i], binds) {
+			return false
+		}
+	}
+	for i := 0; i < after; i++ {
+		if !matchPattern(pats[rest+1+i], items[len(items)-after+i], binds) {
+			return false
+		}
+	}
+	if name := pats[rest].data.(*Pattern).name; name != "" {
+		mapSet(binds, ValueStr(name), ValueArray(append([]Value(nil), items[rest:len(items)-after]...)))
+	}
+	return true
+}
+
+// matchSub matches v against the pattern's optional subpattern.
+func (p *Pattern) matchSub(v, binds Value) bool {
+	return len(p.sub) == 0 || matchPattern(p.sub[0], v, binds)
+}
+
+func (p *Pattern) match(v, binds Value) bool {
+	switch p.kind {
+	case "any":
+		return true
+	case "bind":
+		if !p.matchSub(v, binds) {
+			return false
+		}
+		mapSet(binds, ValueStr(p.name), v)
+		return true
+	case "type":
+		var ok bool
+		switch {
+		case p.class != nil:
+			ok = isTruthy(isInstance(v, Value{Type: TypeClass, data: p.class}))
+		case p.name == "number":
+			ok = v.Type == TypeInt || v.Type == TypeFloat
+		default:
+			ok = typeName(v) == p.name
+		}
+		return ok && p.matchSub(v, binds)
+	case "variant":
+		return v.Type == TypeVariant && v.data.(*Variant).tag == p.name && p.matchSub(v.data.(*Variant).payload, binds)
+	case "or":
+		// Each alternative binds into its own map, so a failed one leaves
+		// nothing behind.
+		for _, alt := range p.sub {
+			trial := ValueMapEmpty()
+			if matchPattern(alt, v, trial) {
+				tm := trial.data.(*OrderedMap)
+				for _, k := range tm.keys {
+					mapSet(binds, tm.keyValue(k), tm.values[k])
+				}
+				return true
+			}
+		}
+		return false
+	default: // "rest" outside a sequence
+		panic(runtimeError(KindValueError, "patRest is only allowed in a sequence pattern"))
+	}
+}
+
+// ============================================================================
+// Tables
+// ============================================================================
+
+// Table is a DataFrame-style value: rows of named columns, each column
+// stored as one typed slice. Operations return new tables and never
+// modify their input, so tables can be shared freely.
+type Table struct {
+	names   []string
+	columns []*column
+	rows    int
+	groups  []string // the key columns, for a table from groupBy
+}
+
+// column holds one column's cells. kind is TypeInt, TypeFloat, TypeStr or
+// TypeBool when every cell other than None has that type, with null
+// marking the None cells (nil if there are none). Otherwise kind is
+// TypeNone and the cells are kept as Values.
+type column struct {
+	kind   ValueType
+	ints   []int64
+	floats []float64
+	strs   []string
+	bools  []bool
+	values []Value
+	null   []bool
+}
+
+// newColumn stores cells in the narrowest kind that holds all of them.
+// Ints mixed with floats are stored as floats.
+func newColumn(cells []Value) *column {
+	kind, typed, nulls := TypeNone, true, false
+	for _, v := range cells {
+		switch {
+		case v.Type == TypeNone:
+			nulls = true
+		case v.Type != TypeInt && v.Type != TypeFloat && v.Type != TypeStr && v.Type != TypeBool:
+			typed = false
+		case kind == TypeNone || v.Type == kind:
+			kind = v.Type
+		case (v.Type == TypeInt || v.Type == TypeFloat) && (kind == TypeInt || kind == TypeFloat):
+			kind = TypeFloat
+		default:
+			typed = false
+		}
+	}
+	if !typed {
+		kind = TypeNone
+	}
+	c := &column{kind: kind}
+	if kind != TypeNone && nulls {
+		c.null = make([]bool, len(cells))
+		for i, v := range cells {
+			c.null[i] = v.Type == TypeNone
+		}
+	}
+	switch kind {
+	case TypeInt:
+		c.ints = make([]int64, len(cells))
+		for i, v := range cells {
+			c.ints[i] = v.intData()
+		}
+	case TypeFloat:
+		c.floats = make([]float64, len(cells))
+		for i, v := range cells {
+			if v.Type != TypeNone {
+				c.floats[i] = asFloat(v)
+			}
+		}
+	case TypeStr:
+		c.strs = make([]string, len(cells))
+		for i, v := range cells {
+			if v.Type != TypeNone {
+				c.strs[i] = v.data.(string)
+			}
+		}
+	case TypeBool:
+		c.bools = make([]bool, len(cells))
+		for i, v := range cells {
+			c.bools[i] = v.boolData()
+		}
+	default:
+		c.values = append([]Value(nil), cells...)
+	}
+	return c
+}
+
+func (c *column) get(i int) Value {
+	if c.null != nil && c.null[i] {
+		return ValueNone
+	}
+	switch c.kind {
+	case TypeInt:
+		return ValueInt(c.ints[i])
+	case TypeFloat:
+		return ValueFloat(c.floats[i])
+	case TypeStr:
+		return ValueStr(c.strs[i])
+	case TypeBool:
+		return ValueBool(c.bools[i])
+	default:
+		return c.values[i]
+	}
+}
+
+// take returns a column of the cells at rows, in that order.
+func (c *column) take(rows []int) *column {
+	t := &column{kind: c.kind}
+	if c.null != nil {
+		t.null = make([]bool, len(rows))
+		for i, r := range rows {
+			t.null[i] = c.null[r]
+		}
+	}
+	switch c.kind {
+	case TypeInt:
+		t.ints = make([]int64, len(rows))
+		for i, r := range rows {
+			t.ints[i] = c.ints[r]
+		}
+	case TypeFloat:
+		t.floats = make([]float64, len(rows))
+		for i, r := range rows {
+			t.floats[i] = c.floats[r]
+		}
+	case TypeStr:
+		t.strs = make([]string, len(rows))
+		for i, r := range rows {
+			t.strs[i] = c.strs[r]
+		}
+	case TypeBool:
+		t.bools = make([]bool, len(rows))
+		for i, r := range rows {
+			t.bools[i] = c.bools[r]
+		}
+	default:
+		t.values = make([]Value, len(rows))
+		for i, r := range rows {
+			t.values[i] = c.values[r]
+		}
+	}
+	return t
+}
+
+func asTable(v Value) *Table {
+	if v.Type == TypeTable {
+		return v.data.(*Table)
+	}
+	panic(runtimeError(KindTypeError, "expected table, got %s", typeName(v)))
+}
+
+// index returns the position of the named column.
+func (t *Table) index(name string) int {
+	for i, n := range t.names {
+		if n == name {
+			return i
+		}
+	}
+	panic(runtimeError(KindKeyError, "table has no column %s", reprString(name)))
+}
+
+// row returns row i as a map from column name to cell.
+func (t *Table) row(i int) Value {
+	m := NewOrderedMap()
+	for j, name := range t.names {
+		m.Set(name, t.columns[j].get(i))
+	}
+	return Value{Type: TypeMap, data: m}
+}
+
+// take returns a table of the given rows, in that order.
+func (t *Table) take(rows []int) *Table {
+	nt := &Table{names: t.names, columns: make([]*column, len(t.columns)), rows: len(rows)}
+	for j, c := range t.columns {
+		nt.columns[j] = c.take(rows)
+	}
+	return nt
+}
+
+// columnNames accepts one column name or an array or tuple of them.
+func columnNames(v Value) []string {
+	if v.Type == TypeStr {
+		return []string{v.data.(string)}
+	}
+	items := iterItems(v)
+	names := make([]string, len(items))
+	for i, item := range items {
+		names[i] = asString(item)
+	}
+	return names
+}
+
+func makeTable(names []string, cells [][]Value) Value {
+	t := &Table{names: names, columns: make([]*column, len(names))}
+	for j := range names {
+		t.columns[j] = newColumn(cells[j])
+	}
+	if len(cells) > 0 {
+		t.rows = len(cells[0])
+	}
+	return Value{Type: TypeTable, data: t}
+}
+
+// tableNew returns a table from a map of column name to an array of its
+// cells. The arrays must have the same length.
+func tableNew(columns Value) Value {
+	m := asMap(columns)
+	names := make([]string, len(m.keys))
+	cells := make([][]Value, len(m.keys))
+	for j, k := range m.keys {
+		names[j] = asString(m.keyValue(k))
+		cells[j] = iterItems(m.values[k])
+		if len(cells[j]) != len(cells[0]) {
+			panic(runtimeError(KindValueError, "column %s has %d values, but column %s has %d",
+				reprString(names[j]), len(cells[j]), reprString(names[0]), len(cells[0])))
+		}
+	}
+	return makeTable(names, cells)
+}
+
+// tableFromRows returns a table from an array of maps or records, one per
+// row. The columns are every field in order of first appearance; a row
+// without one has None there.
+func tableFromRows(rows Value) Value {
+	items := iterItems(rows)
+	var names []string
+	seen := map[string]int{}
+	var cells [][]Value
+	for i, item := range items {
+		var keys []string
+		var get func(string) Value
+		switch item.Type {
+		case TypeMap:
+			m := item.data.(*OrderedMap)
+			keys = make([]string, len(m.keys))
+			for j, k := range m.keys {
+				keys[j] = formatValue(m.keyValue(k))
+			}
+			get = func(name string) Value { v, _ := m.Get(name); return v }
+		case TypeRecord:
+			r := item.data.(*Record)
+			keys = r.order
+			get = func(name string) Value { return r.fields[name] }
+		default:
+			panic(runtimeError(KindTypeError, "table rows must be maps or records, got %s", typeName(item)))
+		}
+		for _, k := range keys {
+			j, ok := seen[k]
+			if !ok {
+				j = len(names)
+				seen[k] = j
+				names = append(names, k)
+				cells = append(cells, make([]Value, len(items)))
+			}
+			cells[j][i] = get(k)
+		}
+	}
+	return makeTable(names, cells)
+}
+
+// tableRows returns the rows of t as an array of maps.
+func tableRows(table Value) Value {
+	t := asTable(table)
+	rows := make([]Value, t.rows)
+	for i := range rows {
+		rows[i] = t.row(i)
+	}
+	return ValueArray(rows)
+}
+
+// tableColumn returns the cells of the named column as an array.
+func tableColumn(table, name Value) Value {
+	t := asTable(table)
+	c := t.columns[t.index(asString(name))]
+	cells := make([]Value, t.rows)
+	for i := range cells {
+		cells[i] = c.get(i)
+	}
+	return ValueArray(cells)
+}
+
+// tableSize returns the number of rows in t.
+func tableSize(table Value) Value {
+	return ValueInt(int64(asTable(table).rows))
+}
+
+// selectColumns returns a table of the named columns of t, in that order.
+func selectColumns(table, names Value) Value {
+	t := asTable(table)
+	cols := columnNames(names)
+	nt := &Table{names: cols, columns: make([]*column, len(cols)), rows: t.rows}
+	for j, name := range cols {
+		nt.columns[j] = t.columns[t.index(name)]
+	}
+	return Value{Type: TypeTable, data: nt}
+}
+
+// filterRows returns the rows of t for which predicate, called with the
+// row as a map, is truthy.
+func filterRows(table, predicate Value) Value {
+	t := asTable(table)
+	asFunc(predicate)
+	var keep []int
+	for i := 0; i < t.rows; i++ {
+		if isTruthy(callValue(predicate, t.row(i))) {
+			keep = append(keep, i)
+		}
+	}
+	return Value{Type: TypeTable, data: t.take(keep)}
+}
+
+// sortBy returns t with its rows sorted by the named columns, the first
+// deciding, in ascending order or descending if the optional argument is
+// true. The sort is stable, and None sorts last either way.
+func sortBy(table, by Value, descending ...Value) Value {
+	t := asTable(table)
+	keys := make([]*column, 0)
+	for _, name := range columnNames(by) {
+		keys = append(keys, t.columns[t.index(name)])
+	}
+	desc := len(descending) > 0 && isTruthy(descending[0])
+	order := make([]int, t.rows)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		for _, c := range keys {
+			x, y := c.get(order[a]), c.get(order[b])
+			switch {
+			case x.Type == TypeNone || y.Type == TypeNone:
+				if x.Type != y.Type {
+					return y.Type == TypeNone
+				}
+			case valueLessThan(x, y):
+				return !desc
+			case valueLessThan(y, x):
+				return desc
+			}
+		}
+		return false
+	})
+	return Value{Type: TypeTable, data: t.take(order)}
+}
+
+// groupRows splits the row numbers of t by the values of the key columns,
+// returning each group's key and rows in order of first appearance.
+func (t *Table) groupRows(keys []string) ([][]Value, [][]int) {
+	cols := make([]*column, len(keys))
+	for j, name := range keys {
+		cols[j] = t.columns[t.index(name)]
+	}
+	var groupKeys [][]Value
+	var groupRows [][]int
+	index := map[string]int{}
+	for i := 0; i < t.rows; i++ {
+		key := make([]Value, len(cols))
+		for j, c := range cols {
+			key[j] = c.get(i)
+		}
+		h := hashKey(Value{Type: TypeTuple, data: key})
+		g, ok := index[h]
+		if !ok {
+			g = len(groupRows)
+			index[h] = g
+			groupKeys = append(groupKeys, key)
+			groupRows = append(groupRows, nil)
+		}
+		groupRows[g] = append(groupRows[g], i)
+	}
+	return groupKeys, groupRows
+}
+
+// groupBy returns t grouped by the named key columns, for aggregate.
+func groupBy(table, keys Value) Value {
+	t := asTable(table)
+	names := columnNames(keys)
+	for _, name := range names {
+		t.index(name)
+	}
+	nt := *t
+	nt.groups = names
+	return Value{Type: TypeTable, data: &nt}
+}
+
+// aggregate returns one row per group of a table from groupBy, in order of
+// first appearance: the key columns, then a column for each entry of specs,
+// which maps a result column name to an [operation, column] pair. The
+// operations are count, sum, mean, min, max and first; count may omit the
+// column to count rows. None cells are skipped. An ungrouped table is one
+// group.
+func aggregate(table, specs Value) Value {
+	t := asTable(table)
+	groupKeys, groupRows := t.groupRows(t.groups)
+	if len(t.groups) == 0 && t.rows == 0 {
+		groupKeys, groupRows = [][]Value{{}}, [][]int{{}}
+	}
+	names := append([]string(nil), t.groups...)
+	cells := make([][]Value, len(names))
+	for j := range t.groups {
+		cells[j] = make([]Value, len(groupKeys))
+		for g, key := range groupKeys {
+			cells[j][g] = key[j]
+		}
+	}
+	m := asMap(specs)
+	for _, k := range m.keys {
+		spec := iterItems(m.values[k])
+		if len(spec) < 1 || len(spec) > 2 {
+			panic(runtimeError(KindValueError, "aggregate spec must be [operation, column], got %s", reprValue(m.values[k])))
+		}
+		op := asString(spec[0])
+		var c *column
+		if len(spec) == 2 {
+			c = t.columns[t.index(asString(spec[1]))]
+		} else if op != "count" {
+			panic(runtimeError(KindValueError, "aggregate %s needs a column", op))
+		}
+		results := make([]Value, len(groupRows))
+		for g, rows := range groupRows {
+			results[g] = aggregateRows(op, c, rows)
+		}
+		names = append(names, asString(m.keyValue(k)))
+		cells = append(cells, results)
+	}
+	return makeTable(names, cells)
+}
+
+// aggregateRows applies one aggregate operation to the cells of c at rows,
+// or counts the rows if c is nil.
+func aggregateRows(op string, c *column, rows []int) Value {
+	if c == nil {
+		return ValueInt(int64(len(rows)))
+	}
+	switch op {
+	case "sum", "mean":
+		if c.null != nil {
+			break
+		}
+		if c.kind == TypeInt && op == "sum" {
+			var sum int64
+			for _, r := range rows {
+				sum += c.ints[r]
+			}
+			return ValueInt(sum)
+		}
+		if c.kind == TypeFloat {
+			var sum float64
+			for _, r := range rows {
+				sum += c.floats[r]
+			}
+			if op == "mean" {
+				return meanOf(sum, len(rows))
+			}
+			return ValueFloat(sum)
+		}
+	}
+	var cells []Value
+	for _, r := range rows {
+		if v := c.get(r); v.Type != TypeNone {
+			cells = append(cells, v)
+		}
+	}
+	switch op {
+	case "count":
+		return ValueInt(int64(len(cells)))
+	case "sum":
+		sum := ValueInt(0)
+		for _, v := range cells {
+			sum = valueAdd(sum, v)
+		}
+		return sum
+	case "mean":
+		var sum float64
+		for _, v := range cells {
+			sum += asFloat(v)
+		}
+		return meanOf(sum, len(cells))
+	case "min", "max":
+		if len(cells) == 0 {
+			return ValueNone
+		}
+		best := cells[0]
+		for _, v := range cells[1:] {
+			if (op == "min" && valueLessThan(v, best)) || (op == "max" && valueLessThan(best, v)) {
+				best = v
+			}
+		}
+		return best
+	case "first":
+		if len(cells) == 0 {
+			return ValueNone
+		}
+		return cells[0]
+	default:
+		panic(runtimeError(KindValueError, "unknown aggregate operation %s", reprString(op)))
+	}
+}
+
+func meanOf(sum float64, n int) Value {
+	if n == 0 {
+		return ValueNone
+	}
+	return ValueFloat(sum / float64(n))
+}
+
+// joinTables returns the rows of left combined with the rows of right
+// that have equal values in the on columns, as an "inner" join, or a
+// "left" join (the optional argument) that keeps unmatched left rows with
+// None for right's columns. The result has left's columns, then right's
+// other columns; a name already in left gets the suffix "_right".
+func joinTables(left, right, on Value, how ...Value) Value {
+	l, r := asTable(left), asTable(right)
+	keys := columnNames(on)
+	kind := "inner"
+	if len(how) > 0 {
+		kind = asString(how[0])
+	}
+	if kind != "inner" && kind != "left" {
+		panic(runtimeError(KindValueError, "join must be 'inner' or 'left', got %s", reprString(kind)))
+	}
+	_, rightGroups := r.groupRows(keys)
+	matches := map[string][]int{}
+	for _, rows := range rightGroups {
+		matches[r.rowKey(keys, rows[0])] = rows
+	}
+	var leftRows, rightRows []int // -1 for no match
+	for i := 0; i < l.rows; i++ {
+		rows := matches[l.rowKey(keys, i)]
+		for _, j := range rows {
+			leftRows = append(leftRows, i)
+			rightRows = append(rightRows, j)
+		}
+		if len(rows) == 0 && kind == "left" {
+			leftRows = append(leftRows, i)
+			rightRows = append(rightRows, -1)
+		}
+	}
+	joined := l.take(leftRows)
+	joined.names = append([]string(nil), l.names...)
+	isKey := map[string]bool{}
+	for _, k := range keys {
+		isKey[k] = true
+	}
+	taken := map[string]bool{}
+	for _, name := range l.names {
+		taken[name] = true
+	}
+	for j, name := range r.names {
+		if isKey[name] {
+			continue
+		}
+		if taken[name] {
+			name += "_right"
+		}
+		cells := make([]Value, len(rightRows))
+		for i, row := range rightRows {
+			if row >= 0 {
+				cells[i] = r.columns[j].get(row)
+			}
+		}
+		joined.names = append(joined.names, name)
+		joined.columns = append(joined.columns, newColumn(cells))
+	}
+	return Value{Type: TypeTable, data: joined}
+}
+
+// rowKey returns the hash key of row i's values in the key columns.
+func (t *Table) rowKey(keys []string, i int) string {
+	key := make([]Value, len(keys))
+	for j, name := range keys {
+		key[j] = t.columns[t.index(name)].get(i)
+	}
+	return hashKey(Value{Type: TypeTuple, data: key})
+}
+
+// tableFromCSV parses CSV text whose first record names the columns.
+// Cells are ints if every cell of the column is one, floats if every cell
+// is a number, and strings otherwise; empty cells are None.
+func tableFromCSV(text Value) Value {
+	records, err := csv.NewReader(strings.NewReader(asString(text))).ReadAll()
+	if err != nil {
+		panic(runtimeError(KindValueError, "invalid CSV: %s", err))
+	}
+	if len(records) == 0 {
+		return makeTable(nil, nil)
+	}
+	names := records[0]
+	cells := make([][]Value, len(names))
+	for j := range names {
+		cells[j] = make([]Value, len(records)-1)
+		ints, floats := true, true
+		for i, record := range records[1:] {
+			s := record[j]
+			if s == "" {
+				continue
+			}
+			if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+				ints = false
+				if _, err := strconv.ParseFloat(s, 64); err != nil {
+					floats = false
+				}
+			}
+			cells[j][i] = ValueStr(s)
+		}
+		for i, v := range cells[j] {
+			switch {
+			case v.Type == TypeNone:
+			case ints:
+				n, _ := strconv.ParseInt(v.data.(string), 10, 64)
+				cells[j][i] = ValueInt(n)
+			case floats:
+				f, _ := strconv.ParseFloat(v.data.(string), 64)
+				cells[j][i] = ValueFloat(f)
+			}
+		}
+	}
+	return makeTable(names, cells)
+}
+
+// tableToCSV returns t as CSV text with a header record; None cells are
+// empty.
+func tableToCSV(table Value) Value {
+	t := asTable(table)
+	var buf strings.Builder
+	w := csv.NewWriter(&buf)
+	w.Write(t.names)
+	record := make([]string, len(t.names))
+	for i := 0; i < t.rows; i++ {
+		for j, c := range t.columns {
+			record[j] = ""
+			if v := c.get(i); v.Type != TypeNone {
+				record[j] = formatValue(v)
+			}
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return ValueStr(buf.String())
+}
+
+// tableFromJSON parses a JSON array of objects, one per row.
+func tableFromJSON(text Value) Value {
+	rows := jsonParse(text)
+	if rows.Type != TypeArray {
+		panic(runtimeError(KindValueError, "table JSON must be an array of objects, got %s", typeName(rows)))
+	}
+	return tableFromRows(rows)
+}
+
+// tableToJSON returns t as a JSON array of objects, one per row.
+func tableToJSON(table Value) Value {
+	return jsonStringify(tableRows(table), ValueBool(false))
+}
+
+// formatTable lays t out as text: a header of column names, then one line
+// per row, with numbers right-aligned.
+func formatTable(t *Table) string {
+	if len(t.names) == 0 {
+		return "<empty table>"
+	}
+	lines := make([][]string, t.rows+1)
+	lines[0] = append([]string(nil), t.names...)
+	widths := make([]int, len(t.names))
+	for j, name := range t.names {
+		widths[j] = utf8.RuneCountInString(name)
+	}
+	for i := 0; i < t.rows; i++ {
+		lines[i+1] = make([]string, len(t.names))
+		for j, c := range t.columns {
+			s := formatValue(c.get(i))
+			lines[i+1][j] = s
+			if n := utf8.RuneCountInString(s); n > widths[j] {
+				widths[j] = n
+			}
+		}
+	}
+	var buf strings.Builder
+	for i, line := range lines {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		for j, s := range line {
+			pad := strings.Repeat(" ", widths[j]-utf8.RuneCountInString(s))
+			numeric := t.columns[j].kind == TypeInt || t.columns[j].kind == TypeFloat
+			if j > 0 {
+				buf.WriteString("  ")
+			}
+			if numeric {
+				buf.WriteString(pad + s)
+			} else if j < len(line)-1 {
+				buf.WriteString(s + pad)
+			} else {
+				buf.WriteString(s)
+			}
+		}
+	}
+	return buf.String()
+}
+
+// tablesEqual reports whether two tables have the same column names and
+// cells.
+func tablesEqual(a, b *Table) bool {
+	if a.rows != b.rows || len(a.names) != len(b.names) {
+		return false
+	}
+	for j, name := range a.names {
+		if b.names[j] != name {
+			return false
+		}
+		for i := 0; i < a.rows; i++ {
+			if !valueEqual(a.columns[j].get(i), b.columns[j].get(i)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ============================================================================
+// Arrow IPC
+// ============================================================================
+
+// tableToArrow and tableFromArrow convert tables to and from the Arrow IPC
+// streaming format: a schema message, then record batches, each a
+// FlatBuffers header followed by the column buffers. Python reads the
+// bytes with pyarrow.ipc.open_stream(data).read_all() and DuckDB through
+// pyarrow. Int, float, string and bool columns map to Int64, Float64, Utf8
+// and Bool, so their buffers are written as the columns store them.
+//
+// The FlatBuffers tables are written and read by hand, to keep the runtime
+// free of dependencies.
+
+const (
+	arrowContinuation = 0xFFFFFFFF
+	arrowMetadataV5   = 4
+
+	arrowHeaderSchema      = 1
+	arrowHeaderRecordBatch = 3
+
+	arrowTypeNull          = 1
+	arrowTypeInt           = 2
+	arrowTypeFloatingPoint = 3
+	arrowTypeBinary        = 4
+	arrowTypeUtf8          = 5
+	arrowTypeBool          = 6
+	arrowTypeLargeBinary   = 19
+	arrowTypeLargeUtf8     = 20
+)
+
+// fbField is one field of a FlatBuffers table being built: an inline
+// scalar of size bytes, or, when child is set, an offset to an object that
+// child writes after the table and whose position it returns.
+type fbField struct {
+	size   int
+	scalar uint64
+	child  func(b *fbBuilder) int
+}
+
+// fbBuilder writes a FlatBuffer front to back: each table's vtable, then
+// the table, then the objects it points to, so every offset points forward
+// as the format requires.
+type fbBuilder struct {
+	buf []byte
+}
+
+func (b *fbBuilder) pad(align int) {
+	for len(b.buf)%align != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+func (b *fbBuilder) put(pos, size int, v uint64) {
+	for i := 0; i < size; i++ {
+		b.buf[pos+i] = byte(v >> (8 * i))
+	}
+}
+
+func (b *fbBuilder) grow(n int) int {
+	pos := len(b.buf)
+	b.buf = append(b.buf, make([]byte, n)...)
+	return pos
+}
+
+// table writes a table whose field i is fields[i] (absent if its size is
+// zero) and returns its position.
+func (b *fbBuilder) table(fields ...fbField) int {
+	// Lay out the fields largest first, so each is aligned to its size
+	// when the table starts on an 8-byte boundary
+	offsets := make([]int, len(fields))
+	size := 4
+	for _, width := range []int{8, 4, 2, 1} {
+		for i, f := range fields {
+			if f.size == width {
+				size = (size + width - 1) / width * width
+				offsets[i] = size
+				size += width
+			}
+		}
+	}
+	size = (size + 7) / 8 * 8
+	b.pad(2)
+	vtable := b.grow(4 + 2*len(fields))
+	b.put(vtable, 2, uint64(4+2*len(fields)))
+	b.put(vtable+2, 2, uint64(size))
+	for i, off := range offsets {
+		b.put(vtable+4+2*i, 2, uint64(off))
+	}
+	b.pad(8)
+	table := b.grow(size)
+	b.put(table, 4, uint64(table-vtable))
+	for i, f := range fields {
+		if f.size > 0 && f.child == nil {
+			b.put(table+offsets[i], f.size, f.scalar)
+		}
+	}
+	for i, f := range fields {
+		if f.child != nil {
+			b.put(table+offsets[i], 4, uint64(f.child(b)-(table+offsets[i])))
+		}
+	}
+	return table
+}
+
+func (b *fbBuilder) str(s string) int {
+	b.pad(4)
+	pos := b.grow(4 + len(s) + 1)
+	b.put(pos, 4, uint64(len(s)))
+	copy(b.buf[pos+4:], s)
+	return pos
+}
+
+// tables writes a vector of tables.
+func (b *fbBuilder) tables(items []func(b *fbBuilder) int) int {
+	b.pad(4)
+	pos := b.grow(4 + 4*len(items))
+	b.put(pos, 4, uint64(len(items)))
+	for i, item := range items {
+		slot := pos + 4 + 4*i
+		b.put(slot, 4, uint64(item(b)-slot))
+	}
+	return pos
+}
+
+// longPairs writes a vector of structs of two int64s, such as FieldNode
+// and Buffer.
+func (b *fbBuilder) longPairs(pairs [][2]int64) int {
+	for (len(b.buf)+4)%8 != 0 {
+		b.buf = append(b.buf, 0)
+	}
+	pos := b.grow(4 + 16*len(pairs))
+	b.put(pos, 4, uint64(len(pairs)))
+	for i, p := range pairs {
+		b.put(pos+4+16*i, 8, uint64(p[0]))
+		b.put(pos+12+16*i, 8, uint64(p[1]))
+	}
+	return pos
+}
+
+func fbScalar(size int, v uint64) fbField { return fbField{size: size, scalar: v} }
+
+func fbChild(child func(b *fbBuilder) int) fbField { return fbField{size: 4, child: child} }
+
+// arrowMessage returns a FlatBuffers Message with the given header.
+func arrowMessage(headerType int, header func(b *fbBuilder) int, bodyLength int) []byte {
+	b := &fbBuilder{buf: make([]byte, 8)}
+	root := b.table(
+		fbScalar(2, arrowMetadataV5),
+		fbScalar(1, uint64(headerType)),
+		fbChild(header),
+		fbScalar(8, uint64(bodyLength)),
+	)
+	b.put(0, 4, uint64(root))
+	return b.buf
+}
+
+// writeArrowMessage frames a message for the stream: the continuation
+// marker, the padded metadata length, the metadata and the body.
+func writeArrowMessage(out *bytes.Buffer, metadata, body []byte) {
+	padded := (len(metadata) + 7) / 8 * 8
+	var head [8]byte
+	binary.LittleEndian.PutUint32(head[:4], arrowContinuation)
+	binary.LittleEndian.PutUint32(head[4:], uint32(padded))
+	out.Write(head[:])
+	out.Write(metadata)
+	out.Write(make([]byte, padded-len(metadata)))
+	out.Write(body)
+}
+
+// arrowType returns the Type union tag and table for a column.
+func arrowType(name string, c *column, rows int) (int, func(b *fbBuilder) int) {
+	empty := func(b *fbBuilder) int { return b.table() }
+	switch c.kind {
+	case TypeInt:
+		return arrowTypeInt, func(b *fbBuilder) int { return b.table(fbScalar(4, 64), fbScalar(1, 1)) }
+	case TypeFloat:
+		return arrowTypeFloatingPoint, func(b *fbBuilder) int { return b.table(fbScalar(2, 2)) }
+	case TypeStr:
+		return arrowTypeUtf8, empty
+	case TypeBool:
+		return arrowTypeBool, empty
+	}
+	for i := 0; i < rows; i++ {
+		if c.values[i].Type != TypeNone {
+			panic(runtimeError(KindTypeError, "column %s mixes %s with other types; an Arrow column has one type",
+				reprString(name), typeName(c.values[i])))
+		}
+	}
+	return arrowTypeNull, empty
+}
+
+// arrowBitmap packs bits least significant first, as Arrow does.
+func arrowBitmap(n int, bit func(i int) bool) []byte {
+	bitmap := make([]byte, (n+7)/8)
+	for i := 0; i < n; i++ {
+		if bit(i) {
+			bitmap[i/8] |= 1 << (i % 8)
+		}
+	}
+	return bitmap
+}
+
+// arrowColumnBuffers returns a column's null count and its buffers: the
+// validity bitmap (empty if nothing is null), then the values, with
+// offsets first for strings. A Null column has no buffers.
+func arrowColumnBuffers(c *column, rows int) (int, [][]byte) {
+	nulls := 0
+	var validity []byte
+	if c.null != nil {
+		validity = arrowBitmap(rows, func(i int) bool { return !c.null[i] })
+		for _, null := range c.null {
+			if null {
+				nulls++
+			}
+		}
+	}
+	switch c.kind {
+	case TypeInt:
+		data := make([]byte, 8*rows)
+		for i, v := range c.ints {
+			binary.LittleEndian.PutUint64(data[8*i:], uint64(v))
+		}
+		return nulls, [][]byte{validity, data}
+	case TypeFloat:
+		data := make([]byte, 8*rows)
+		for i, v := range c.floats {
+			binary.LittleEndian.PutUint64(data[8*i:], math.Float64bits(v))
+		}
+		return nulls, [][]byte{validity, data}
+	case TypeStr:
+		offsets := make([]byte, 4*(rows+1))
+		var data []byte
+		for i, s := range c.strs {
+			data = append(data, s...)
+			if len(data) > math.MaxInt32 {
+				panic(runtimeError(KindValueError, "column strings exceed Arrow's 2 GiB limit"))
+			}
+			binary.LittleEndian.PutUint32(offsets[4*(i+1):], uint32(len(data)))
+		}
+		return nulls, [][]byte{validity, offsets, data}
+	case TypeBool:
+		return nulls, [][]byte{validity, arrowBitmap(rows, func(i int) bool { return c.bools[i] })}
+	default:
+		return rows, nil
+	}
+}
+
+// tableToArrow returns t as an Arrow IPC stream of one record batch.
+func tableToArrow(table Value) Value {
+	t := asTable(table)
+	fields := make([]func(b *fbBuilder) int, len(t.names))
+	nodes := make([][2]int64, len(t.names))
+	var buffers [][2]int64
+	var body []byte
+	for j, name := range t.names {
+		c := t.columns[j]
+		typeTag, typeTable := arrowType(name, c, t.rows)
+		name := name
+		fields[j] = func(b *fbBuilder) int {
+			return b.table(
+				fbChild(func(b *fbBuilder) int { return b.str(name) }),
+				fbScalar(1, 1),
+				fbScalar(1, uint64(typeTag)),
+				fbChild(typeTable),
+				fbField{},
+				fbChild(func(b *fbBuilder) int { return b.tables(nil) }),
+			)
+		}
+		nulls, bufs := arrowColumnBuffers(c, t.rows)
+		nodes[j] = [2]int64{int64(t.rows), int64(nulls)}
+		for _, buf := range bufs {
+			buffers = append(buffers, [2]int64{int64(len(body)), int64(len(buf))})
+			body = append(body, buf...)
+			body = append(body, make([]byte, (8-len(body)%8)%8)...)
+		}
+	}
+
+	var out bytes.Buffer
+	schema := arrowMessage(arrowHeaderSchema, func(b *fbBuilder) int {
+		return b.table(fbScalar(2, 0), fbChild(func(b *fbBuilder) int { return b.tables(fields) }))
+	}, 0)
+	writeArrowMessage(&out, schema, nil)
+	batch := arrowMessage(arrowHeaderRecordBatch, func(b *fbBuilder) int {
+		return b.table(
+			fbScalar(8, uint64(t.rows)),
+			fbChild(func(b *fbBuilder) int { return b.longPairs(nodes) }),
+			fbChild(func(b *fbBuilder) int { return b.longPairs(buffers) }),
+		)
+	}, len(body))
+	writeArrowMessage(&out, batch, body)
+	writeArrowMessage(&out, nil, nil) // end of stream
+	return ValueStr(out.String())
+}
+
+// arrayToArrow returns items as an Arrow IPC stream with one column,
+// "values".
+func arrayToArrow(items Value) Value {
+	cells := iterItems(items)
+	t := &Table{names: []string{"values"}, columns: []*column{newColumn(cells)}, rows: len(cells)}
+	return tableToArrow(Value{Type: TypeTable, data: t})
+}
+
+// fbTable reads a table of a FlatBuffer.
+type fbTable struct {
+	buf []byte
+	pos int
+}
+
+func fbRoot(buf []byte) fbTable {
+	return fbTable{buf, int(binary.LittleEndian.Uint32(buf))}
+}
+
+// field returns the position of field i, or 0 if it is absent.
+func (t fbTable) field(i int) int {
+	vtable := t.pos - int(int32(binary.LittleEndian.Uint32(t.buf[t.pos:])))
+	if 4+2*i >= int(binary.LittleEndian.Uint16(t.buf[vtable:])) {
+		return 0
+	}
+	if off := int(binary.LittleEndian.Uint16(t.buf[vtable+4+2*i:])); off != 0 {
+		return t.pos + off
+	}
+	return 0
+}
+
+func (t fbTable) uint(i, size int, def uint64) uint64 {
+	pos := t.field(i)
+	if pos == 0 {
+		return def
+	}
+	var v uint64
+	for k := size - 1; k >= 0; k-- {
+		v = v<<8 | uint64(t.buf[pos+k])
+	}
+	return v
+}
+
+// ref returns the position an offset field points to, or 0.
+func (t fbTable) ref(i int) int {
+	pos := t.field(i)
+	if pos == 0 {
+		return 0
+	}
+	return pos + int(binary.LittleEndian.Uint32(t.buf[pos:]))
+}
+
+func (t fbTable) child(i int) fbTable {
+	pos := t.ref(i)
+	if pos == 0 {
+		panic(runtimeError(KindValueError, "invalid Arrow data: missing table"))
+	}
+	return fbTable{t.buf, pos}
+}
+
+// vector returns the position of a vector field's first element and its
+// length.
+func (t fbTable) vector(i int) (int, int) {
+	pos := t.ref(i)
+	if pos == 0 {
+		return 0, 0
+	}
+	return pos + 4, int(binary.LittleEndian.Uint32(t.buf[pos:]))
+}
+
+func (t fbTable) str(i int) string {
+	start, n := t.vector(i)
+	return string(t.buf[start : start+n])
+}
+
+// arrowField is a schema field: its name and Type union.
+type arrowField struct {
+	name     string
+	typeTag  int
+	typeInfo fbTable
+}
+
+// readArrowMessage reads one framed message from data, returning its
+// metadata, its body and the rest of data; the metadata is nil at the end
+// of the stream.
+func readArrowMessage(data []byte) (fbTable, []byte, []byte, bool) {
+	if len(data) < 4 {
+		return fbTable{}, nil, nil, false
+	}
+	size := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+	if size == arrowContinuation {
+		size = binary.LittleEndian.Uint32(data)
+		data = data[4:]
+	}
+	if size == 0 {
+		return fbTable{}, nil, nil, false
+	}
+	message := fbRoot(data[:size])
+	bodyLength := int(message.uint(3, 8, 0))
+	body := data[size : int(size)+bodyLength]
+	return message, body, data[int(size)+bodyLength:], true
+}
+
+// tableFromArrow reads an Arrow IPC stream, or an Arrow file, into a
+// table; the record batches are concatenated. Integer and floating point
+// columns of any width become ints and floats, Utf8 and Binary become
+// strings. Dictionary-encoded, nested and compressed data is refused.
+func tableFromArrow(data Value) Value {
+	defer func() {
+		if r := recover(); r != nil {
+			if err, ok := r.(runtime.Error); ok {
+				panic(runtimeError(KindValueError, "invalid Arrow data: %s", err))
+			}
+			panic(r)
+		}
+	}()
+	buf := []byte(asString(data))
+	if bytes.HasPrefix(buf, []byte("ARROW1")) {
+		buf = buf[8:] // an Arrow file holds a stream after its magic
+	}
+	var fields []arrowField
+	var cells [][]Value
+	for {
+		message, body, rest, ok := readArrowMessage(buf)
+		if !ok {
+			break
+		}
+		buf = rest
+		switch message.uint(1, 1, 0) {
+		case arrowHeaderSchema:
+			schema := message.child(2)
+			start, n := schema.vector(1)
+			for i := 0; i < n; i++ {
+				slot := start + 4*i
+				f := fbTable{schema.buf, slot + int(binary.LittleEndian.Uint32(schema.buf[slot:]))}
+				if f.ref(4) != 0 {
+					panic(runtimeError(KindValueError, "dictionary-encoded Arrow columns are not supported"))
+				}
+				fields = append(fields, arrowField{f.str(0), int(f.uint(2, 1, 0)), f.child(3)})
+			}
+			cells = make([][]Value, len(fields))
+		case arrowHeaderRecordBatch:
+			batch := message.child(2)
+			if batch.ref(3) != 0 {
+				panic(runtimeError(KindValueError, "compressed Arrow record batches are not supported"))
+			}
+			length := int(batch.uint(0, 8, 0))
+			nodes, _ := batch.vector(1)
+			buffers, nbuf := batch.vector(2)
+			next := 0
+			buffer := func() []byte {
+				if next >= nbuf {
+					panic(runtimeError(KindValueError, "invalid Arrow data: too few buffers"))
+				}
+				pos := buffers + 16*next
+				next++
+				off := int(binary.LittleEndian.Uint64(message.buf[pos:]))
+				n := int(binary.LittleEndian.Uint64(message.buf[pos+8:]))
+				return body[off : off+n]
+			}
+			for j, f := range fields {
+				nulls := int(binary.LittleEndian.Uint64(message.buf[nodes+16*j+8:]))
+				cells[j] = append(cells[j], readArrowColumn(f, length, nulls, buffer)...)
+			}
+		default:
+			panic(runtimeError(KindValueError, "unsupported Arrow message type %d", message.uint(1, 1, 0)))
+		}
+	}
+	names := make([]string, len(fields))
+	for j, f := range fields {
+		names[j] = f.name
+	}
+	return makeTable(names, cells)
+}
+
+// readArrowColumn decodes one column of a record batch, taking its
+// buffers in order from buffer.
+func readArrowColumn(f arrowField, length, nulls int, buffer func() []byte) []Value {
+	cells := make([]Value, length)
+	if f.typeTag == arrowTypeNull {
+		return cells
+	}
+	validity := buffer()
+	valid := func(i int) bool {
+		return nulls == 0 || len(validity) == 0 || validity[i/8]&(1<<(i%8)) != 0
+	}
+	switch f.typeTag {
+	case arrowTypeInt:
+		width := int(f.typeInfo.uint(0, 4, 0)) / 8
+		signed := f.typeInfo.uint(1, 1, 0) != 0
+		data := buffer()
+		for i := range cells {
+			if !valid(i) {
+				continue
+			}
+			var v uint64
+			for k := width - 1; k >= 0; k-- {
+				v = v<<8 | uint64(data[width*i+k])
+			}
+			if signed && width < 8 && v&(1<<(8*width-1)) != 0 {
+				v |= ^uint64(0) << (8 * width)
+			}
+			cells[i] = ValueInt(int64(v))
+		}
+	case arrowTypeFloatingPoint:
+		precision := f.typeInfo.uint(0, 2, 0)
+		data := buffer()
+		for i := range cells {
+			if !valid(i) {
+				continue
+			}
+			switch precision {
+			case 1:
+				cells[i] = ValueFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))))
+			case 2:
+				cells[i] = ValueFloat(math.Float64frombits(binary.LittleEndian.Uint64(data[8*i:])))
+			default:
+				panic(runtimeError(KindValueError, "half-precision Arrow column %s is not supported", reprString(f.name)))
+			}
+		}
+	case arrowTypeUtf8, arrowTypeBinary, arrowTypeLargeUtf8, arrowTypeLargeBinary:
+		wide := f.typeTag == arrowTypeLargeUtf8 || f.typeTag == arrowTypeLargeBinary
+		offsets, data := buffer(), buffer()
+		offset := func(i int) int {
+			if wide {
+				return int(binary.LittleEndian.Uint64(offsets[8*i:]))
+			}
+			return int(binary.LittleEndian.Uint32(offsets[4*i:]))
+		}
+		for i := range cells {
+			if valid(i) {
+				cells[i] = ValueStr(string(data[offset(i):offset(i+1)]))
+			}
+		}
+	case arrowTypeBool:
+		data := buffer()
+		for i := range cells {
+			if valid(i) {
+				cells[i] = ValueBool(data[i/8]&(1<<(i%8)) != 0)
+			}
+		}
+	default:
+		panic(runtimeError(KindValueError, "Arrow column %s has an unsupported type (%d)", reprString(f.name), f.typeTag))
+	}
+	return cells
+}
+
+// arrayFromArrow reads the first column of an Arrow IPC stream as an
+// array.
+func arrayFromArrow(data Value) Value {
+	t := asTable(tableFromArrow(data))
+	if len(t.names) == 0 {
+		return ValueArray(nil)
+	}
+	return tableColumn(Value{Type: TypeTable, data: t}, ValueStr(t.names[0]))
+}
+
+// ============================================================================
+// HTML and XML documents
+// ============================================================================
+
+// Node is an element, a text node or the document of a page parsed by
+// htmlParse or xmlParse.
+type Node struct {
+	tag      string // "#document" for the document, "" for text
+	attrs    []markupAttr
+	children []*Node
+	parent   *Node
+	text     string // of a text node
+	html     bool
+}
+
+type markupAttr struct {
+	name, value string
+}
+
+func asNode(v Value) *Node {
+	if v.Type == TypeNode {
+		return v.data.(*Node)
+	}
+	panic(runtimeError(KindTypeError, "expected node, got %s", typeName(v)))
+}
+
+func (n *Node) append(child *Node) {
+	child.parent = n
+	n.children = append(n.children, child)
+}
+
+func (n *Node) attr(name string) (string, bool) {
+	for _, a := range n.attrs {
+		if a.name == name {
+			return a.value, true
+		}
+	}
+	return "", false
+}
+
+func (n *Node) isElement() bool {
+	return n.tag != "" && n.tag != "#document"
+}
+
+// elements calls visit on each element below n in document order.
+func (n *Node) elements(visit func(*Node)) {
+	for _, c := range n.children {
+		if c.isElement() {
+			visit(c)
+			c.elements(visit)
+		}
+	}
+}
+
+func (n *Node) textContent(buf *strings.Builder) {
+	if n.tag == "" {
+		buf.WriteString(n.text)
+	}
+	for _, c := range n.children {
+		c.textContent(buf)
+	}
+}
+
+// htmlVoid lists the elements that never have content or an end tag, and
+// htmlRaw those whose content is text up to their end tag.
+var (
+	htmlVoid = map[string]bool{
+		"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+		"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+	}
+	htmlRaw = map[string]bool{"script": true, "style": true, "textarea": true, "title": true}
+)
+
+// htmlImpliedEnd maps a start tag to the open elements it closes, so that
+// "<li>one<li>two" gives two list items.
+var htmlImpliedEnd = func() map[string]map[string]bool {
+	closes := map[string]map[string]bool{
+		"li":     {"li": true},
+		"dt":     {"dt": true, "dd": true},
+		"dd":     {"dt": true, "dd": true},
+		"tr":     {"tr": true, "td": true, "th": true},
+		"td":     {"td": true, "th": true},
+		"th":     {"td": true, "th": true},
+		"option": {"option": true},
+	}
+	for _, block := range strings.Fields("p div ul ol dl table form h1 h2 h3 h4 h5 h6 pre section article header footer nav aside blockquote hr") {
+		if closes[block] == nil {
+			closes[block] = map[string]bool{}
+		}
+		closes[block]["p"] = true
+	}
+	return closes
+}()
+
+// htmlParse implements the htmlParse(text) builtin. It parses leniently,
+// as browsers do: tag and attribute names are lowercased, character
+// references are decoded, void elements need no end tag, some end tags are
+// implied, and stray end tags are ignored. It returns the document node.
+func htmlParse(text Value) Value {
+	src := asString(text)
+	doc := &Node{tag: "#document", html: true}
+	cur := doc
+	addText := func(s string) {
+		if s != "" {
+			cur.append(&Node{text: stdhtml.UnescapeString(s), html: true})
+		}
+	}
+	isName := func(c byte) bool {
+		return c > ' ' && c != '>' && c != '/' && c != '=' && c != '"' && c != '\'' && c != '<'
+	}
+	i := 0
+	for i < len(src) {
+		lt := strings.IndexByte(src[i:], '<')
+		if lt < 0 {
+			addText(src[i:])
+			break
+		}
+		addText(src[i : i+lt])
+		i += lt
+		rest := src[i:]
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			end := strings.Index(rest[4:], "-->")
+			if end < 0 {
+				return Value{Type: TypeNode, data: doc}
+			}
+			i += 4 + end + 3
+		case strings.HasPrefix(rest, "<!") || strings.HasPrefix(rest, "<?"):
+			end := strings.IndexByte(rest, '>')
+			if end < 0 {
+				return Value{Type: TypeNode, data: doc}
+			}
+			i += end + 1
+		case strings.HasPrefix(rest, "</"):
+			end := strings.IndexByte(rest, '>')
+			if end < 0 {
+				addText(rest)
+				return Value{Type: TypeNode, data: doc}
+			}
+			name := strings.ToLower(strings.TrimSpace(rest[2:end]))
+			for n := cur; n != doc; n = n.parent {
+				if n.tag == name {
+					cur = n.parent
+					break
+				}
+			}
+			i += end + 1
+		case len(rest) > 1 && (rest[1] >= 'a' && rest[1] <= 'z' || rest[1] >= 'A' && rest[1] <= 'Z'):
+			j := 1
+			for j < len(rest) && isName(rest[j]) {
+				j++
+			}
+			el := &Node{tag: strings.ToLower(rest[1:j]), html: true}
+			selfClosing := false
+			for j < len(rest) && rest[j] != '>' {
+				switch c := rest[j]; {
+				case c == '/':
+					selfClosing = true
+					j++
+				case !isName(c):
+					j++
+				default:
+					k := j
+					for k < len(rest) && isName(rest[k]) {
+						k++
+					}
+					a := markupAttr{name: strings.ToLower(rest[j:k])}
+					for k < len(rest) && rest[k] == ' ' {
+						k++
+					}
+					if k < len(rest) && rest[k] == '=' {
+						k++
+						for k < len(rest) && rest[k] == ' ' {
+							k++
+						}
+						if k < len(rest) && (rest[k] == '"' || rest[k] == '\'') {
+							end := strings.IndexByte(rest[k+1:], rest[k])
+							if end < 0 {
+								end = len(rest) - k - 1
+							}
+							a.value = rest[k+1 : k+1+end]
+							k += end + 2
+						} else {
+							v := k
+							for k < len(rest) && rest[k] > ' ' && rest[k] != '>' {
+								k++
+							}
+							a.value = rest[v:k]
+						}
+						a.value = stdhtml.UnescapeString(a.value)
+					}
+					if _, dup := el.attr(a.name); !dup {
+						el.attrs = append(el.attrs, a)
+					}
+					selfClosing = false
+					j = k
+				}
+			}
+			if j < len(rest) {
+				j++
+			}
+			i += j
+			for implied := htmlImpliedEnd[el.tag]; cur != doc && implied[cur.tag]; {
+				cur = cur.parent
+			}
+			cur.append(el)
+			switch {
+			case htmlRaw[el.tag]:
+				end := strings.Index(strings.ToLower(src[i:]), "</"+el.tag)
+				if end < 0 {
+					end = len(src) - i
+				}
+				content := src[i : i+end]
+				if el.tag == "textarea" || el.tag == "title" {
+					content = stdhtml.UnescapeString(content)
+				}
+				if content != "" {
+					el.append(&Node{text: content, html: true})
+				}
+				i += end
+				if gt := strings.IndexByte(src[i:], '>'); gt >= 0 {
+					i += gt + 1
+				}
+			case !htmlVoid[el.tag] && !selfClosing:
+				cur = el
+			}
+		default:
+			addText("<")
+			i++
+		}
+	}
+	return Value{Type: TypeNode, data: doc}
+}
+
+// xmlParse implements the xmlParse(text) builtin: it parses well-formed
+// XML and returns the document node. Elements and attributes are named by
+// their local names, without namespace prefixes; comments and processing
+// instructions are dropped.
+func xmlParse(text Value) Value {
+	dec := stdxml.NewDecoder(strings.NewReader(asString(text)))
+	doc := &Node{tag: "#document"}
+	cur := doc
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			panic(runtimeError(KindValueError, "invalid XML: %s", err))
+		}
+		switch t := tok.(type) {
+		case stdxml.StartElement:
+			el := &Node{tag: t.Name.Local}
+			for _, a := range t.Attr {
+				name := a.Name.Local
+				if a.Name.Space == "xmlns" {
+					name = "xmlns:" + name
+				}
+				el.attrs = append(el.attrs, markupAttr{name, a.Value})
+			}
+			cur.append(el)
+			cur = el
+		case stdxml.EndElement:
+			cur = cur.parent
+		case stdxml.CharData:
+			if cur != doc {
+				cur.append(&Node{text: string(t)})
+			}
+		}
+	}
+	return Value{Type: TypeNode, data: doc}
+}
+
+// nodeTag returns the element's tag name, "#text" for a text node or
+// "#document".
+func nodeTag(node Value) Value {
+	if n := asNode(node); n.tag != "" {
+		return ValueStr(n.tag)
+	}
+	return ValueStr("#text")
+}
+
+// nodeAttr returns the named attribute of an element, or None.
+func nodeAttr(node, name Value) Value {
+	if v, ok := asNode(node).attr(asString(name)); ok {
+		return ValueStr(v)
+	}
+	return ValueNone
+}
+
+// nodeAttrs returns an element's attributes as a map, in source order.
+func nodeAttrs(node Value) Value {
+	m := NewOrderedMap()
+	for _, a := range asNode(node).attrs {
+		m.Set(a.name, ValueStr(a.value))
+	}
+	return Value{Type: TypeMap, data: m}
+}
+
+// nodeText returns the text of a node and everything below it.
+func nodeText(node Value) Value {
+	var buf strings.Builder
+	asNode(node).textContent(&buf)
+	return ValueStr(buf.String())
+}
+
+// nodeChildren returns the element children of a node.
+func nodeChildren(node Value) Value {
+	var items []Value
+	for _, c := range asNode(node).children {
+		if c.isElement() {
+			items = append(items, Value{Type: TypeNode, data: c})
+		}
+	}
+	return ValueArray(items)
+}
+
+// nodeParent returns a node's parent, or None for the document.
+func nodeParent(node Value) Value {
+	if p := asNode(node).parent; p != nil {
+		return Value{Type: TypeNode, data: p}
+	}
+	return ValueNone
+}
+
+// cssCompound is one compound selector, such as a.external[href], with
+// the combinator that joins it to the compound before it (' ' or '>').
+type cssCompound struct {
+	combinator byte
+	tag        string // "" for any
+	id         string
+	classes    []string
+	attrs      []cssAttr
+}
+
+// cssAttr is an attribute selector: [name], or [name op value] where op is
+// one of = ^= $= *= ~=.
+type cssAttr struct {
+	name, op, value string
+}
+
+// parseSelector parses a selector group into its selectors, each a list
+// of compounds. It supports type, universal, #id, .class and attribute
+// selectors with the descendant and child combinators.
+func parseSelector(selector string) [][]cssCompound {
+	bad := func() {
+		panic(runtimeError(KindValueError, "unsupported CSS selector %s", reprString(selector)))
+	}
+	var group [][]cssCompound
+	for _, part := range strings.Split(selector, ",") {
+		var seq []cssCompound
+		s := strings.TrimSpace(part)
+		combinator := byte(' ')
+		for s != "" {
+			if s[0] == '>' {
+				if len(seq) == 0 || combinator == '>' {
+					bad()
+				}
+				combinator = '>'
+				s = strings.TrimSpace(s[1:])
+				continue
+			}
+			c := cssCompound{combinator: combinator}
+			end := strings.IndexAny(s, " \t\n>")
+			if end < 0 {
+				end = len(s)
+			}
+			// An attribute value may contain spaces
+			if open := strings.IndexByte(s, '['); open >= 0 && open < end {
+				if close := strings.IndexByte(s[open:], ']'); close >= 0 {
+					if e := strings.IndexAny(s[open+close:], " \t\n>"); e >= 0 {
+						end = open + close + e
+					} else {
+						end = len(s)
+					}
+				}
+			}
+			token := s[:end]
+			s = strings.TrimSpace(s[end:])
+			name := func(t string) (string, string) {
+				k := 0
+				for k < len(t) && (t[k] == '-' || t[k] == '_' || t[k] == ':' || t[k] >= '0' && t[k] <= '9' ||
+					t[k] >= 'a' && t[k] <= 'z' || t[k] >= 'A' && t[k] <= 'Z') {
+					k++
+				}
+				return t[:k], t[k:]
+			}
+			if token[0] == '*' {
+				token = token[1:]
+			} else {
+				c.tag, token = name(token)
+			}
+			for token != "" {
+				var n string
+				switch token[0] {
+				case '#':
+					n, token = name(token[1:])
+					c.id = n
+				case '.':
+					n, token = name(token[1:])
+					c.classes = append(c.classes, n)
+				case '[':
+					close := strings.IndexByte(token, ']')
+					if close < 0 {
+						bad()
+					}
+					inner := token[1:close]
+					token = token[close+1:]
+					a := cssAttr{}
+					if eq := strings.IndexByte(inner, '='); eq >= 0 {
+						a.name, a.op = strings.TrimSpace(inner[:eq]), "="
+						if eq > 0 && strings.ContainsRune("^$*~", rune(inner[eq-1])) {
+							a.name, a.op = strings.TrimSpace(inner[:eq-1]), inner[eq-1:eq+1]
+						}
+						a.value = strings.Trim(strings.TrimSpace(inner[eq+1:]), "\"'")
+					} else {
+						a.name = strings.TrimSpace(inner)
+					}
+					c.attrs = append(c.attrs, a)
+					n = a.name
+				default:
+					bad()
+				}
+				if n == "" {
+					bad()
+				}
+			}
+			seq = append(seq, c)
+			combinator = ' '
+		}
+		if len(seq) == 0 || combinator == '>' {
+			bad()
+		}
+		group = append(group, seq)
+	}
+	return group
+}
+
+func (c *cssCompound) matches(n *Node) bool {
+	if !n.isElement() {
+		return false
+	}
+	if c.tag != "" && !(n.tag == c.tag || n.html && strings.EqualFold(n.tag, c.tag)) {
+		return false
+	}
+	if c.id != "" {
+		if id, _ := n.attr("id"); id != c.id {
+			return false
+		}
+	}
+	class, _ := n.attr("class")
+	classes := strings.Fields(class)
+	for _, want := range c.classes {
+		if !containsString(classes, want) {
+			return false
+		}
+	}
+	for _, a := range c.attrs {
+		v, ok := n.attr(a.name)
+		switch {
+		case !ok:
+			return false
+		case a.op == "=" && v != a.value,
+			a.op == "^=" && !strings.HasPrefix(v, a.value),
+			a.op == "$=" && !strings.HasSuffix(v, a.value),
+			a.op == "*=" && !strings.Contains(v, a.value),
+			a.op == "~=" && !containsString(strings.Fields(v), a.value):
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(items []string, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// selectorMatches reports whether n matches seq[:i+1], checking from the
+// right: each compound against n or, through its combinator, an ancestor.
+func selectorMatches(seq []cssCompound, i int, n *Node) bool {
+	if !seq[i].matches(n) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	for p := n.parent; p != nil; p = p.parent {
+		if selectorMatches(seq, i-1, p) {
+			return true
+		}
+		if seq[i].combinator == '>' {
+			return false
+		}
+	}
+	return false
+}
+
+// querySelectorAll returns the elements below node that match the CSS
+// selector, in document order.
+func querySelectorAll(node, selector Value) Value {
+	group := parseSelector(asString(selector))
+	var items []Value
+	asNode(node).elements(func(el *Node) {
+		for _, seq := range group {
+			if selectorMatches(seq, len(seq)-1, el) {
+				items = append(items, Value{Type: TypeNode, data: el})
+				return
+			}
+		}
+	})
+	return ValueArray(items)
+}
+
+// querySelector returns the first element below node that matches the CSS
+// selector, or None.
+func querySelector(node, selector Value) Value {
+	if items := *asArray(querySelectorAll(node, selector)); len(items) > 0 {
+		return items[0]
+	}
+	return ValueNone
+}
+
+// xpath implements a subset of XPath 1.0 location paths: steps separated
+// by / or //, each a name, *, . or .., with predicates [n], [@a],
+// [@a='v'] and [text()='v']; the last step may be @a, @* or text(). It
+// returns the nodes, or the attribute values or texts, in document order.
+func xpath(node, expr Value) Value {
+	path := asString(expr)
+	bad := func(why string) {
+		panic(runtimeError(KindValueError, "unsupported XPath %s: %s", reprString(path), why))
+	}
+	context := []*Node{asNode(node)}
+	rest := path
+	if strings.HasPrefix(rest, "/") {
+		root := context[0]
+		for root.parent != nil {
+			root = root.parent
+		}
+		context = []*Node{root}
+	}
+	if rest == "/" {
+		return ValueArray([]Value{{Type: TypeNode, data: context[0]}})
+	}
+	for rest != "" {
+		descendants := false
+		switch {
+		case strings.HasPrefix(rest, "//"):
+			descendants, rest = true, rest[2:]
+		case strings.HasPrefix(rest, "/"):
+			rest = rest[1:]
+		}
+		end := 0
+		for depth := 0; end < len(rest) && (depth > 0 || rest[end] != '/'); end++ {
+			switch rest[end] {
+			case '[':
+				depth++
+			case ']':
+				depth--
+			}
+		}
+		step := rest[:end]
+		rest = rest[end:]
+		test, predicates := step, ""
+		if open := strings.IndexByte(step, '['); open >= 0 {
+			test, predicates = step[:open], step[open:]
+		}
+		if test == "" {
+			bad("empty step")
+		}
+		last := rest == ""
+
+		if test == "text()" || strings.HasPrefix(test, "@") {
+			if !last || predicates != "" {
+				bad(test + " must be the last step")
+			}
+			var items []Value
+			for _, n := range context {
+				candidates := []*Node{n}
+				if descendants {
+					n.elements(func(el *Node) { candidates = append(candidates, el) })
+				}
+				for _, c := range candidates {
+					if test == "text()" {
+						for _, t := range c.children {
+							if t.tag == "" {
+								items = append(items, ValueStr(t.text))
+							}
+						}
+						continue
+					}
+					for _, a := range c.attrs {
+						if test == "@*" || a.name == test[1:] {
+							items = append(items, ValueStr(a.value))
+						}
+					}
+				}
+			}
+			return ValueArray(items)
+		}
+
+		var next []*Node
+		seen := map[*Node]bool{}
+		for _, n := range context {
+			var candidates []*Node
+			switch {
+			case test == ".":
+				candidates = []*Node{n}
+			case test == "..":
+				if n.parent != nil {
+					candidates = []*Node{n.parent}
+				}
+			case descendants:
+				n.elements(func(el *Node) {
+					if test == "*" || el.tag == test {
+						candidates = append(candidates, el)
+					}
+				})
+			default:
+				for _, c := range n.children {
+					if c.isElement() && (test == "*" || c.tag == test) {
+						candidates = append(candidates, c)
+					}
+				}
+			}
+			candidates = xpathFilter(candidates, predicates, bad)
+			for _, c := range candidates {
+				if !seen[c] {
+					seen[c] = true
+					next = append(next, c)
+				}
+			}
+		}
+		context = next
+	}
+	items := make([]Value, len(context))
+	for i, n := range context {
+		items[i] = Value{Type: TypeNode, data: n}
+	}
+	return ValueArray(items)
+}
+
+// xpathFilter applies a step's predicates, such as "[@class='a'][2]", in
+// turn; a position counts from 1 among the nodes left so far.
+func xpathFilter(nodes []*Node, predicates string, bad func(string)) []*Node {
+	for predicates != "" {
+		close := strings.IndexByte(predicates, ']')
+		if predicates[0] != '[' || close < 0 {
+			bad("malformed predicate")
+		}
+		p := strings.TrimSpace(predicates[1:close])
+		predicates = predicates[close+1:]
+		if pos, err := strconv.Atoi(p); err == nil {
+			if pos >= 1 && pos <= len(nodes) {
+				nodes = []*Node{nodes[pos-1]}
+			} else {
+				nodes = nil
+			}
+			continue
+		}
+		lhs, value, compare := p, "", false
+		if eq := strings.IndexByte(p, '='); eq >= 0 {
+			lhs, value, compare = strings.TrimSpace(p[:eq]), strings.TrimSpace(p[eq+1:]), true
+			if len(value) < 2 || value[0] != value[len(value)-1] || (value[0] != '\'' && value[0] != '"') {
+				bad("predicate values must be quoted")
+			}
+			value = value[1 : len(value)-1]
+		}
+		var kept []*Node
+		for _, n := range nodes {
+			var v string
+			var ok bool
+			switch {
+			case strings.HasPrefix(lhs, "@"):
+				v, ok = n.attr(lhs[1:])
+			case lhs == "text()":
+				var buf strings.Builder
+				n.textContent(&buf)
+				v, ok = buf.String(), true
+			default:
+				bad("unsupported predicate [" + p + "]")
+			}
+			if ok && (!compare || v == value) {
+				kept = append(kept, n)
+			}
+		}
+		nodes = kept
+	}
+	return nodes
+}
+
+// formatNode writes n back out as markup.
+func formatNode(n *Node, buf *strings.Builder) {
+	switch {
+	case n.tag == "":
+		if n.html && n.parent != nil && htmlRaw[n.parent.tag] {
+			buf.WriteString(n.text)
+		} else {
+			buf.WriteString(stdhtml.EscapeString(n.text))
+		}
+		return
+	case n.tag == "#document":
+		for _, c := range n.children {
+			formatNode(c, buf)
+		}
+		return
+	}
+	buf.WriteString("<" + n.tag)
+	for _, a := range n.attrs {
+		buf.WriteString(" " + a.name + "=\"" + stdhtml.EscapeString(a.value) + "\"")
+	}
+	if n.html && htmlVoid[n.tag] {
+		buf.WriteString(">")
+		return
+	}
+	if !n.html && len(n.children) == 0 {
+		buf.WriteString("/>")
+		return
+	}
+	buf.WriteString(">")
+	for _, c := range n.children {
+		formatNode(c, buf)
+	}
+	buf.WriteString("</" + n.tag + ">")
+}
+
+// ============================================================================
+// URLs
+// ============================================================================
+
+// urlParse implements the urlParse(url) builtin. It returns a record with
+// the scheme, user, host, port (an int, or None), path, query (a map from
+// queryDecode) and fragment of an absolute or relative URL.
+func urlParse(rawURL Value) Value {
+	u, err := neturl.Parse(asString(rawURL))
+	if err != nil {
+		panic(runtimeError(KindValueError, "invalid URL: %s", err.(*neturl.Error).Err))
+	}
+	user, port := ValueNone, ValueNone
+	if u.User != nil {
+		user = ValueStr(u.User.Username())
+	}
+	if p := u.Port(); p != "" {
+		n, err := strconv.ParseInt(p, 10, 64)
+		if err != nil {
+			panic(runtimeError(KindValueError, "invalid URL: bad port %s", reprString(p)))
+		}
+		port = ValueInt(n)
+	}
+	return ValueRecordNew([]struct{ Name string; Val Value }{
+		{"scheme", ValueStr(u.Scheme)},
+		{"user", user},
+		{"host", ValueStr(u.Hostname())},
+		{"port", port},
+		{"path", ValueStr(u.Path)},
+		{"query", queryDecode(ValueStr(u.RawQuery))},
+		{"fragment", ValueStr(u.Fragment)},
+	})
+}
+
+// urlBuild implements the urlBuild(parts) builtin, the inverse of urlParse.
+// parts is a record or map with any of urlParse's fields; the query may be
+// a map, encoded as by queryEncode, or an already encoded string.
+func urlBuild(parts Value) Value {
+	text := func(name string) string {
+		v := urlPart(parts, name)
+		if v.Type == TypeNone {
+			return ""
+		}
+		return asString(v)
+	}
+	u := &neturl.URL{Scheme: text("scheme"), Host: text("host"), Path: text("path"), Fragment: text("fragment")}
+	if user := text("user"); user != "" {
+		u.User = neturl.User(user)
+	}
+	if port := urlPart(parts, "port"); port.Type != TypeNone {
+		u.Host = net.JoinHostPort(u.Host, formatValue(port))
+	} else if strings.Contains(u.Host, ":") {
+		u.Host = "[" + u.Host + "]"
+	}
+	if u.Host != "" && u.Path != "" && !strings.HasPrefix(u.Path, "/") {
+		u.Path = "/" + u.Path
+	}
+	switch query := urlPart(parts, "query"); query.Type {
+	case TypeNone:
+	case TypeStr:
+		u.RawQuery = strings.TrimPrefix(asString(query), "?")
+	default:
+		u.RawQuery = asString(queryEncode(query))
+	}
+	return ValueStr(u.String())
+}
+
+// urlPart returns a field of urlBuild's parts, or None if it is absent.
+func urlPart(parts Value, name string) Value {
+	switch parts.Type {
+	case TypeRecord:
+		if v, ok := parts.data.(*Record).fields[name]; ok {
+			return v
+		}
+	case TypeMap:
+		if v, ok := asMap(parts).GetValue(ValueStr(name)); ok {
+			return v
+		}
+	default:
+		panic(runtimeError(KindTypeError, "urlBuild expects a record or map, got %s", typeName(parts)))
+	}
+	return ValueNone
+}
+
+// queryEncode implements the queryEncode(params) builtin. Pairs are written
+// in the map's order; an array value repeats its key, None is left out,
+// and other values are formatted as by print.
+func queryEncode(params Value) Value {
+	m := asMap(params)
+	var buf strings.Builder
+	add := func(key string, v Value) {
+		if v.Type == TypeNone {
+			return
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('&')
+		}
+		buf.WriteString(neturl.QueryEscape(key) + "=" + neturl.QueryEscape(formatValue(v)))
+	}
+	for _, k := range m.keys {
+		key := formatValue(m.keyValue(k))
+		if v := m.values[k]; v.Type == TypeArray {
+			for _, item := range *asArray(v) {
+				add(key, item)
+			}
+		} else {
+			add(key, v)
+		}
+	}
+	return ValueStr(buf.String())
+}
+
+// queryDecode implements the queryDecode(query) builtin. It returns a map
+// from each key to its value, or to an array of values when the key
+// repeats, in order of first appearance. A leading "?" is ignored.
+func queryDecode(query Value) Value {
+	m := NewOrderedMap()
+	for _, pair := range strings.Split(strings.TrimPrefix(asString(query), "?"), "&") {
+		if pair == "" {
+			continue
+		}
+		rawKey, rawValue, _ := strings.Cut(pair, "=")
+		key, err := neturl.QueryUnescape(rawKey)
+		if err == nil {
+			var value string
+			if value, err = neturl.QueryUnescape(rawValue); err == nil {
+				switch old, ok := m.Get(key); {
+				case !ok:
+					m.Set(key, ValueStr(value))
+				case old.Type == TypeArray:
+					arr := asArray(old)
+					*arr = append(*arr, ValueStr(value))
+				default:
+					m.Set(key, ValueArray([]Value{old, ValueStr(value)}))
+				}
+				continue
+			}
+		}
+		panic(runtimeError(KindValueError, "invalid query string: %s", err))
+	}
+	return Value{Type: TypeMap, data: m}
+}
+
+// ============================================================================
+// Compression
+// ============================================================================
+
+// Compressed data is held in strings, as byte strings, like the results of
+// fs.readFile and tableToArrow.
+
+// gzipCompress implements the gzipCompress(data) builtin.
+func gzipCompress(data Value) Value {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(asString(data)))
+	w.Close()
+	return ValueStr(buf.String())
+}
+
+// gzipDecompress implements the gzipDecompress(data) builtin. It reads
+// every member of a multi-member file, as gunzip does.
+func gzipDecompress(data Value) Value {
+	r, err := gzip.NewReader(strings.NewReader(asString(data)))
+	if err != nil {
+		panic(runtimeError(KindValueError, "invalid gzip data: %s", err))
+	}
+	return ValueStr(inflate(r, "gzip"))
+}
+
+// zlibCompress implements the zlibCompress(data) builtin.
+func zlibCompress(data Value) Value {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write([]byte(asString(data)))
+	w.Close()
+	return ValueStr(buf.String())
+}
+
+// zlibDecompress implements the zlibDecompress(data) builtin.
+func zlibDecompress(data Value) Value {
+	r, err := zlib.NewReader(strings.NewReader(asString(data)))
+	if err != nil {
+		panic(runtimeError(KindValueError, "invalid zlib data: %s", err))
+	}
+	return ValueStr(inflate(r, "zlib"))
+}
+
+// inflate reads decompressed data, counting it against the memory limit.
+// Under a limit it stops reading just past what is left, so a small file
+// that expands enormously fails fast instead of exhausting memory.
+func inflate(r io.Reader, format string) string {
+	if e := DefaultEngine; e.limited && e.limits.MaxMemory > 0 {
+		left := e.limits.MaxMemory - e.memory
+		if left < 0 {
+			left = 0
+		}
+		r = io.LimitReader(r, left+1)
 	}
+	data, err := io.ReadAll(r)
+	trackGrowth(0, int64(len(data)))
+	if err != nil {
+		panic(runtimeError(KindValueError, "invalid %s data: %s", format, err))
+	}
+	return string(data)
 }
 
-func mathPi() Value { return ValueFloat(math.Pi) }
-func mathE() Value  { return ValueFloat(math.E) }
+func openZip(archive Value) *stdzip.Reader {
+	s := asString(archive)
+	zr, err := stdzip.NewReader(strings.NewReader(s), int64(len(s)))
+	if err != nil {
+		panic(runtimeError(KindValueError, "invalid zip archive: %s", err))
+	}
+	return zr
+}
 
-// ============================================================================
-// Type conversions
-// ============================================================================
+// zipList implements the zipList(archive) builtin. It returns a record for
+// each entry, in archive order, with the entry's name, size, compressed
+// size, modification time (an ISO 8601 string) and whether it is a
+// directory.
+func zipList(archive Value) Value {
+	var items []Value
+	for _, f := range openZip(archive).File {
+		items = append(items, ValueRecordNew([]struct{ Name string; Val Value }{
+			{"name", ValueStr(f.Name)},
+			{"size", ValueInt(int64(f.UncompressedSize64))},
+			{"compressed", ValueInt(int64(f.CompressedSize64))},
+			{"modified", ValueStr(f.Modified.Format(time.RFC3339))},
+			{"dir", ValueBool(f.FileInfo().IsDir())},
+		}))
+	}
+	return ValueArray(items)
+}
 
-func valueToInt(v Value) Value {
-	switch v.Type {
-	case TypeInt:
-		return v
-	case TypeFloat:
-		return ValueInt(int64(v.data.(float64)))
-	case TypeStr:
-		n, err := strconv.ParseInt(v.data.(string), 10, 64)
+// zipRead implements the zipRead(archive, name?) builtin. With a name it
+// returns that entry's contents, raising KeyError if there is no such entry;
+// without one it returns a map from each file's name to its contents.
+func zipRead(archive Value, name ...Value) Value {
+	zr := openZip(archive)
+	extract := func(f *stdzip.File) Value {
+		r, err := f.Open()
 		if err != nil {
-			panic(fmt.Sprintf("runtime error: cannot convert string '%s' to int", v.data.(string)))
+			panic(runtimeError(KindValueError, "cannot read zip entry %s: %s", reprString(f.Name), err))
+		}
+		defer r.Close()
+		return ValueStr(inflate(r, "zip entry"))
+	}
+	if len(name) > 0 {
+		want := asString(name[0])
+		for _, f := range zr.File {
+			if f.Name == want {
+				return extract(f)
+			}
+		}
+		panic(runtimeError(KindKeyError, "no entry %s in zip archive", reprString(want)))
+	}
+	m := NewOrderedMap()
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() {
+			m.Set(f.Name, extract(f))
 		}
-		return ValueInt(n)
-	default:
-		panic(fmt.Sprintf("runtime error: cannot convert %s to int", typeName(v)))
 	}
+	return Value{Type: TypeMap, data: m}
 }
 
-func valueToFloat(v Value) Value {
-	switch v.Type {
-	case TypeFloat:
-		return v
-	case TypeInt:
-		return ValueFloat(float64(v.data.(int64)))
-	case TypeStr:
-		f, err := strconv.ParseFloat(v.data.(string), 64)
+// zipWrite implements the zipWrite(entries) builtin: it returns a zip
+// archive holding each name and contents of the map, in its order,
+// deflated. Names ending in "/" are directories and must be empty. Entries
+// carry no timestamp, so equal inputs give identical archives.
+func zipWrite(entries Value) Value {
+	m := asMap(entries)
+	var buf bytes.Buffer
+	zw := stdzip.NewWriter(&buf)
+	for _, k := range m.keys {
+		name, contents := asString(m.keyValue(k)), asString(m.values[k])
+		method := stdzip.Deflate
+		if strings.HasSuffix(name, "/") {
+			if contents != "" {
+				panic(runtimeError(KindValueError, "zip directory %s cannot have contents", reprString(name)))
+			}
+			method = stdzip.Store
+		}
+		w, err := zw.CreateHeader(&stdzip.FileHeader{Name: name, Method: method})
+		if err == nil {
+			_, err = w.Write([]byte(contents))
+		}
 		if err != nil {
-			panic(fmt.Sprintf("runtime error: cannot convert string '%s' to float", v.data.(string)))
+			panic(runtimeError(KindValueError, "cannot write zip entry %s: %s", reprString(name), err))
 		}
-		return ValueFloat(f)
-	default:
-		panic(fmt.Sprintf("runtime error: cannot convert %s to float", typeName(v)))
 	}
-}
-
-func valueToStringConvert(v Value) Value {
-	return ValueStr(formatValue(v))
+	if err := zw.Close(); err != nil {
+		panic(runtimeError(KindValueError, "cannot write zip archive: %s", err))
+	}
+	return ValueStr(buf.String())
 }
 
 // ============================================================================
-// JSON operations
+// Identifiers and tokens
 // ============================================================================
 
-func jsonConvertGoToValue(v interface{}) Value {
-	if v == nil {
-		return ValueNone
+// uuid4, uuid7 and randomToken read crypto/rand, so identifiers stay
+// unpredictable even in deterministic mode unless DeterministicConfig
+// SeededIDs says otherwise. They are recorded like ExternalCalls, so
+// Replay reproduces them.
+
+// randomBytes returns n random bytes for an identifier.
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if cfg := DefaultEngine.deterministic; cfg != nil && cfg.SeededIDs {
+		DefaultEngine.rng.Read(b)
+	} else if _, err := cryptorand.Read(b); err != nil {
+		panic(runtimeError(KindRuntimeError, "cannot read random bytes: %s", err))
 	}
-	switch val := v.(type) {
-	case json.Number:
-		if n, err := val.Int64(); err == nil {
-			return ValueInt(n)
-		}
-		if f, err := val.Float64(); err == nil {
-			return ValueFloat(f)
-		}
-		return ValueStr(val.String())
-	case bool:
-		return ValueBool(val)
-	case string:
-		return ValueStr(val)
-	case []interface{}:
-		items := make([]Value, len(val))
-		for i, item := range val {
-			items[i] = jsonConvertGoToValue(item)
-		}
-		return ValueArray(items)
-	case map[string]interface{}:
-		om := NewOrderedMap()
-		keys := make([]string, 0, len(val))
-		for k := range val {
-			keys = append(keys, k)
+	return b
+}
+
+func formatUUID(b []byte) string {
+	h := hex.EncodeToString(b)
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// uuid4 implements the uuid4() builtin: a random (version 4) UUID.
+func uuid4() Value {
+	return performExternal("uuid4", externalFunc{fn: func([]Value) Value {
+		b := randomBytes(16)
+		b[6] = b[6]&0x0f | 0x40
+		b[8] = b[8]&0x3f | 0x80
+		return ValueStr(formatUUID(b))
+	}}, nil)
+}
+
+// uuid7 implements the uuid7() builtin: a time-ordered (version 7) UUID,
+// whose first 48 bits are the Unix time in milliseconds, so later ids sort
+// after earlier ones. With SeededIDs the time is the frozen clock.
+func uuid7() Value {
+	return performExternal("uuid7", externalFunc{fn: func([]Value) Value {
+		ms := time.Now().UnixMilli()
+		if cfg := DefaultEngine.deterministic; cfg != nil && cfg.SeededIDs {
+			ms = int64(asFloat(externalNow(nil)) * 1000)
 		}
-		sort.Strings(keys)
-		for _, k := range keys {
-			om.Set(k, jsonConvertGoToValue(val[k]))
+		b := randomBytes(16)
+		for i := 0; i < 6; i++ {
+			b[i] = byte(ms >> (40 - 8*i))
 		}
-		return Value{Type: TypeMap, data: om}
+		b[6] = b[6]&0x0f | 0x70
+		b[8] = b[8]&0x3f | 0x80
+		return ValueStr(formatUUID(b))
+	}}, nil)
+}
+
+// maxTokenBytes bounds randomToken so a typo cannot allocate gigabytes.
+const maxTokenBytes = 1 << 16
+
+// randomToken implements the randomToken(nBytes, encoding?) builtin: nBytes
+// random bytes encoded as "hex" (the default), "base64" or "base64url"
+// (unpadded, safe in URLs and file names).
+func randomToken(nBytes Value, encoding ...Value) Value {
+	n := asInt(nBytes)
+	if n < 1 || n > maxTokenBytes {
+		panic(runtimeError(KindValueError, "randomToken size must be between 1 and %d bytes, got %d", maxTokenBytes, n))
+	}
+	enc := "hex"
+	if len(encoding) > 0 {
+		enc = asString(encoding[0])
+	}
+	var encode func([]byte) string
+	switch enc {
+	case "hex":
+		encode = hex.EncodeToString
+	case "base64":
+		encode = base64.StdEncoding.EncodeToString
+	case "base64url":
+		encode = base64.RawURLEncoding.EncodeToString
 	default:
-		return ValueStr(fmt.Sprintf("%v", v))
+		panic(runtimeError(KindValueError, "unknown token encoding %s (use 'hex', 'base64' or 'base64url')", reprString(enc)))
 	}
+	return performExternal("randomToken", externalFunc{fn: func([]Value) Value {
+		return ValueStr(encode(randomBytes(int(n))))
+	}}, []Value{ValueInt(n), ValueStr(enc)})
 }
 
-func jsonParse(source Value) Value {
-	s := asString(source)
-	decoder := json.NewDecoder(strings.NewReader(s))
-	decoder.UseNumber()
-	var result interface{}
-	if err := decoder.Decode(&result); err != nil {
-		panic(fmt.Sprintf("runtime error: invalid JSON: %s", err))
-	}
-	return jsonConvertGoToValue(result)
+// ============================================================================
+// Email and notifications
+// ============================================================================
+
+// MailConfig is the SMTP server email.send delivers through.
+type MailConfig struct {
+	Addr     string // host:port of the server
+	Username string // for PLAIN auth, which is only sent over TLS or to localhost; empty for none
+	Password string
+	From     string // sender address, e.g. "Reports <reports@example.com>"
 }
 
-func jsonConvertValueToGo(v Value) interface{} {
-	switch v.Type {
-	case TypeNone:
-		return nil
-	case TypeBool:
-		return v.data.(bool)
-	case TypeInt:
-		return v.data.(int64)
-	case TypeFloat:
-		return v.data.(float64)
-	case TypeStr:
-		return v.data.(string)
-	case TypeArray:
-		arr := *v.data.(*[]Value)
-		result := make([]interface{}, len(arr))
-		for i, item := range arr {
-			result[i] = jsonConvertValueToGo(item)
-		}
-		return result
-	case TypeMap:
-		om := v.data.(*OrderedMap)
-		result := make(map[string]interface{})
-		for _, k := range om.keys {
-			result[k] = jsonConvertValueToGo(om.values[k])
+// SetMail configures the SMTP server for email.send. Without one, or with
+// a nil cfg, email.send fails with an IOError.
+func (e *Engine) SetMail(cfg *MailConfig) {
+	e.mail = cfg
+}
+
+// parseAddresses parses email.send's recipients, a string or an array of
+// strings, into the addresses for the envelope and the To header.
+func parseAddresses(to Value) (envelope, header []string) {
+	items := []Value{to}
+	if to.Type == TypeArray {
+		items = *asArray(to)
+	}
+	for _, item := range items {
+		addr, err := netmail.ParseAddress(asString(item))
+		if err != nil {
+			panic(runtimeError(KindValueError, "invalid email address %s", reprValue(item)))
 		}
-		return result
-	default:
-		return formatValue(v)
+		envelope = append(envelope, addr.Address)
+		header = append(header, addr.String())
 	}
+	if len(envelope) == 0 {
+		panic(runtimeError(KindValueError, "email.send needs at least one recipient"))
+	}
+	return envelope, header
 }
 
-func jsonStringify(value Value, pretty Value) Value {
-	goVal := jsonConvertValueToGo(value)
-	var result []byte
-	var err error
-	if isTruthy(pretty) {
-		result, err = json.MarshalIndent(goVal, "", "  ")
-	} else {
-		result, err = json.Marshal(goVal)
+// externalSendEmail implements email.send(to, subject, body): it sends a
+// plain-text UTF-8 message, upgrading to TLS when the server offers it.
+func externalSendEmail(args []Value) Value {
+	cfg := DefaultEngine.mail
+	if cfg == nil || cfg.Addr == "" {
+		panic(runtimeError(KindIOError, "email.send: no SMTP server configured (set COREIL_SMTP_ADDR)"))
 	}
+	from, err := netmail.ParseAddress(cfg.From)
 	if err != nil {
-		panic(fmt.Sprintf("runtime error: cannot stringify to JSON: %s", err))
+		panic(runtimeError(KindIOError, "email.send: invalid sender address %s", reprString(cfg.From)))
 	}
-	// Match Python's json.dumps default separators (", " and ": ")
-	if !isTruthy(pretty) {
-		s := string(result)
-		s = jsonAddPythonSpacing(s)
-		return ValueStr(s)
+	envelope, to := parseAddresses(args[0])
+	subject := asString(args[1])
+	if strings.ContainsAny(subject, "\r\n") {
+		panic(runtimeError(KindValueError, "email subject cannot contain line breaks"))
 	}
-	return ValueStr(string(result))
+
+	var msg bytes.Buffer
+	for _, h := range [][2]string{
+		{"From", from.String()},
+		{"To", strings.Join(to, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=utf-8"},
+		{"Content-Transfer-Encoding", "quoted-printable"},
+	} {
+		msg.WriteString(h[0] + ": " + h[1] + "\r\n")
+	}
+	msg.WriteString("\r\n")
+	qp := quotedprintable.NewWriter(&msg)
+	qp.Write([]byte(strings.ReplaceAll(asString(args[2]), "\r\n", "\n")))
+	qp.Close()
+
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		host, _, _ := net.SplitHostPort(cfg.Addr)
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+	DefaultEngine.blocking(func() {
+		err = smtp.SendMail(cfg.Addr, auth, from.Address, envelope, msg.Bytes())
+	})
+	if err != nil {
+		panic(runtimeError(KindIOError, "email.send: %s", err))
+	}
+	return ValueNone
 }
 
-// jsonAddPythonSpacing adds spaces after : and , in JSON to match Python's
-// json.dumps default separators (", " and ": "). It respects string literals.
-func jsonAddPythonSpacing(s string) string {
-	var buf strings.Builder
-	inString := false
-	for i := 0; i < len(s); i++ {
-		ch := s[i]
-		if inString {
-			buf.WriteByte(ch)
-			if ch == '\\' && i+1 < len(s) {
-				i++
-				buf.WriteByte(s[i])
-			} else if ch == '"' {
-				inString = false
-			}
-		} else {
-			switch ch {
-			case '"':
-				inString = true
-				buf.WriteByte(ch)
-			case ',':
-				buf.WriteString(", ")
-			case ':':
-				buf.WriteString(": ")
-			default:
-				buf.WriteByte(ch)
-			}
+// externalWebhook implements notify.webhook(url, payload): it POSTs the
+// payload as JSON and returns the response status. A string payload is
+// sent as {"text": payload}, which Slack, Mattermost and Discord-compatible
+// hooks accept; other values are sent as they are. A status outside 2xx
+// raises an IOError.
+func externalWebhook(args []Value) Value {
+	payload := args[1]
+	if payload.Type == TypeStr {
+		m := NewOrderedMap()
+		m.Set("text", payload)
+		payload = Value{Type: TypeMap, data: m}
+	}
+	body := asString(jsonStringify(payload, ValueBool(false)))
+	req, err := http.NewRequestWithContext(DefaultEngine.context(), "POST", secretText(args[0], "notify.webhook"), strings.NewReader(body))
+	if err != nil {
+		panic(runtimeError(KindIOError, "notify.webhook: %s", DefaultEngine.redact(err.Error())))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var resp *http.Response
+	DefaultEngine.blocking(func() {
+		if resp, err = http.DefaultClient.Do(req); err == nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+			resp.Body.Close()
 		}
+	})
+	if err != nil {
+		DefaultEngine.checkContext()
+		panic(runtimeError(KindIOError, "notify.webhook: %s", DefaultEngine.redact(err.Error())))
 	}
-	return buf.String()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		panic(runtimeError(KindIOError, "notify.webhook: %s returned %s", DefaultEngine.redact(req.URL.Redacted()), resp.Status))
+	}
+	return ValueInt(int64(resp.StatusCode))
 }
 
 // ============================================================================
-// Regex operations
+// Secrets
 // ============================================================================
 
-func regexCompileWithFlags(pattern, flags string) *regexp.Regexp {
-	p := pattern
-	if strings.Contains(flags, "i") {
-		p = "(?i)" + p
-	}
-	re, err := regexp.Compile(p)
-	if err != nil {
-		panic(fmt.Sprintf("runtime error: invalid regex pattern: %s", err))
-	}
-	return re
+// redactedText is how a secret, or a string passed to Redact, appears in
+// output.
+const redactedText = "****"
+
+// Secret is a string, such as an API key, that the program can pass to the
+// builtins that need it but never print: it formats as "****" everywhere,
+// cannot be used where a string is expected, and compares by identity.
+// The builtins that accept one are http.get, http.getAuth and
+// notify.webhook.
+type Secret struct {
+	value string
 }
 
-func regexMatch(str, pattern, flags Value) Value {
-	s := asString(str)
-	p := asString(pattern)
-	f := ""
-	if flags.Type != TypeNone {
-		f = asString(flags)
+// minAutoRedact is the shortest secret newSecret registers for redaction;
+// masking every "a" in the output would protect nothing.
+const minAutoRedact = 4
+
+// newSecret wraps value, registering it with the engine so that it is
+// redacted even if it comes back in a server's reply or an error message.
+func newSecret(value string) Value {
+	if len(value) >= minAutoRedact {
+		DefaultEngine.Redact(value)
 	}
-	re := regexCompileWithFlags(p, f)
-	return ValueBool(re.MatchString(s))
+	return Value{Type: TypeSecret, data: &Secret{value}}
 }
 
-func regexFindAll(str, pattern, flags Value) Value {
-	s := asString(str)
-	p := asString(pattern)
-	f := ""
-	if flags.Type != TypeNone {
-		f = asString(flags)
+// secret implements the secret(value) builtin.
+func secret(value Value) Value {
+	return newSecret(asString(value))
+}
+
+// secretFromEnv implements the secretFromEnv(name) builtin: the environment
+// variable as a secret, or None if it is unset. Like os.getenv it needs the
+// env capability and is refused in deterministic mode; unlike it, the value
+// is not written to record/replay traces, so replays read it again.
+func secretFromEnv(name Value) Value {
+	e := DefaultEngine
+	if e.remote != nil {
+		panic(runtimeError(KindPermissionError, "secretFromEnv cannot read the environment in an isolated run"))
 	}
-	re := regexCompileWithFlags(p, f)
-	matches := re.FindAllString(s, -1)
-	if matches == nil {
-		return ValueArray(nil)
+	requireCapability(CapEnv, "secretFromEnv")
+	if e.deterministic != nil {
+		panic(runtimeError(KindPermissionError, "deterministic mode refuses secretFromEnv"))
 	}
-	items := make([]Value, len(matches))
-	for i, m := range matches {
-		items[i] = ValueStr(m)
+	if e.audit != nil {
+		e.audit(AuditEvent{Op: "secretFromEnv", Capability: CapEnv, Args: []string{reprValue(name)}, Loc: e.loc})
 	}
-	return ValueArray(items)
+	if e.metrics != nil {
+		e.count("coreil.builtin.calls", 1, "builtin", "secretFromEnv")
+	}
+	if v, ok := os.LookupEnv(asString(name)); ok {
+		return newSecret(v)
+	}
+	return ValueNone
 }
 
-func regexReplace(str, pattern, replacement, flags Value) Value {
-	s := asString(str)
-	p := asString(pattern)
-	r := asString(replacement)
-	f := ""
-	if flags.Type != TypeNone {
-		f = asString(flags)
+// secretText returns the contents of a string or secret argument of op.
+func secretText(v Value, op string) string {
+	switch v.Type {
+	case TypeStr:
+		return v.data.(string)
+	case TypeSecret:
+		return v.data.(*Secret).value
 	}
-	re := regexCompileWithFlags(p, f)
-	return ValueStr(re.ReplaceAllString(s, r))
+	panic(runtimeError(KindTypeError, "%s expects a string or secret, got %s", op, typeName(v)))
 }
 
-func regexSplit(str, pattern, flags Value) Value {
-	s := asString(str)
-	p := asString(pattern)
-	f := ""
-	if flags.Type != TypeNone {
-		f = asString(flags)
+// Redact masks each of values as "****" wherever the program's output,
+// errors, log records, audit events and trace attributes would show it,
+// for credentials the host hands the program as plain strings. Secrets of
+// at least minAutoRedact bytes are registered automatically. Empty strings
+// are ignored.
+func (e *Engine) Redact(values ...string) {
+	for _, v := range values {
+		if v != "" && v != redactedText && !containsString(e.redacted, v) {
+			e.redacted = append(e.redacted, v)
+		}
 	}
-	re := regexCompileWithFlags(p, f)
-	parts := re.Split(s, -1)
-	items := make([]Value, len(parts))
-	for i, part := range parts {
-		items[i] = ValueStr(part)
+	// Longest first, so a secret that contains another is masked whole
+	sorted := append([]string(nil), e.redacted...)
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	pairs := make([]string, 0, 2*len(sorted))
+	for _, v := range sorted {
+		pairs = append(pairs, v, redactedText)
 	}
-	return ValueArray(items)
+	e.redactor = strings.NewReplacer(pairs...)
 }
 
-// Ensure all imports are used
-var _ = sort.Strings
-var _ = regexp.Compile
-var _ = json.NewDecoder
+func (e *Engine) redact(s string) string {
+	if e.redactor == nil {
+		return s
+	}
+	return e.redactor.Replace(s)
+}
+
+// redactWire masks the secrets in toWire data bound for a trace or
+// checkpoint journal. Replays compare arguments by their repr, in which
+// every secret is "****" anyway.
+func redactWire(x interface{}) interface{} {
+	switch x := x.(type) {
+	case map[string]string:
+		if _, ok := x["secret"]; ok {
+			return map[string]string{"secret": redactedText}
+		}
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, item := range x {
+			out[i] = redactWire(item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(x))
+		for tag, body := range x {
+			out[tag] = redactWire(body)
+		}
+		return out
+	case [][2]interface{}:
+		out := make([][2]interface{}, len(x))
+		for i, pair := range x {
+			out[i] = [2]interface{}{redactWire(pair[0]), redactWire(pair[1])}
+		}
+		return out
+	case string:
+		return DefaultEngine.redact(x)
+	}
+	return x
+}
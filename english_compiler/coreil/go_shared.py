@@ -0,0 +1,202 @@
+"""C-shared library builds of Go programs.
+
+build_go_shared() compiles a Core IL program with the Go runtime into a
+shared library (go build -buildmode=c-shared) that exports the C ABI in
+go_runtime/coreil_cshared.go, plus the header go generates for it. Hosts
+embed the program in-process instead of spawning it:
+
+- engine_create / engine_destroy: run the top-level statements on a new
+  engine, and free it
+- engine_eval(engine, name, args_json): call a top-level function
+- engine_error / engine_output: the last error, and the output printed
+- value_type, value_int, value_float, value_bool, value_string,
+  value_json, value_len, value_index, value_get, value_free: inspect results
+- coreil_free: release a string the library returned
+
+SharedLibrary is the Python host, using ctypes.
+
+Usage:
+    from english_compiler.coreil.go_shared import SharedLibrary, build_go_shared
+
+    result = build_go_shared(doc, Path("build"), name="scores")
+    if result.success:
+        lib = SharedLibrary(result.library_path)
+        engine = lib.create()
+        print(engine.eval("average", [90, 85]))
+"""
+
+from __future__ import annotations
+
+import ctypes
+import json
+import shutil
+import subprocess
+import sys
+import tempfile
+from dataclasses import dataclass
+from pathlib import Path
+from typing import Any
+
+from .emit_go import emit_go, get_cshared_path, get_runtime_path
+
+GO_AVAILABLE = shutil.which("go") is not None
+
+
+def library_suffix() -> str:
+    """The platform's shared library extension."""
+    if sys.platform == "darwin":
+        return ".dylib"
+    if sys.platform == "win32":
+        return ".dll"
+    return ".so"
+
+
+@dataclass
+class SharedBuildResult:
+    """Result from a c-shared build."""
+    success: bool
+    library_path: Path | None = None
+    header_path: Path | None = None
+    error: str | None = None
+
+
+def build_go_shared(
+    doc: dict,
+    output_dir: Path,
+    name: str = "program",
+    **emit_options: Any,
+) -> SharedBuildResult:
+    """Compile doc into lib<name> shared library and header in output_dir.
+
+    emit_options are passed to emit_go (e.g. deterministic=True).
+    """
+    if not GO_AVAILABLE:
+        return SharedBuildResult(success=False, error="go not found")
+
+    output_dir = Path(output_dir)
+    output_dir.mkdir(parents=True, exist_ok=True)
+    library_path = output_dir / f"lib{name}{library_suffix()}"
+    code, _ = emit_go(doc, shared=True, **emit_options)
+
+    with tempfile.TemporaryDirectory() as tmp_dir:
+        tmp_path = Path(tmp_dir)
+        (tmp_path / "main.go").write_text(code, encoding="utf-8")
+        shutil.copy(get_runtime_path(), tmp_path / "coreil_runtime.go")
+        shutil.copy(get_cshared_path(), tmp_path / "coreil_cshared.go")
+        try:
+            result = subprocess.run(
+                ["go", "build", "-buildmode=c-shared", "-o", str(library_path.resolve()),
+                 "main.go", "coreil_runtime.go", "coreil_cshared.go"],
+                capture_output=True,
+                text=True,
+                timeout=300,
+                cwd=tmp_dir,
+            )
+        except subprocess.TimeoutExpired:
+            return SharedBuildResult(success=False, error="Compilation timeout (>300s)")
+
+    if result.returncode != 0:
+        return SharedBuildResult(success=False, error=f"go build failed:\n{result.stderr}")
+    return SharedBuildResult(
+        success=True,
+        library_path=library_path,
+        header_path=library_path.with_suffix(".h"),
+    )
+
+
+# ---------------------------------------------------------------------------
+# Python host
+# ---------------------------------------------------------------------------
+
+class SharedError(RuntimeError):
+    """An error raised by a program running in a shared library."""
+
+
+class SharedLibrary:
+    """A program built by build_go_shared, loaded into this process."""
+
+    ABI_VERSION = 1
+
+    def __init__(self, path: Path):
+        lib = ctypes.CDLL(str(path))
+        handle, text = ctypes.c_size_t, ctypes.c_void_p
+        signatures = {
+            "coreil_abi_version": ([], ctypes.c_int),
+            "coreil_free": ([text], None),
+            "engine_create": ([], handle),
+            "engine_destroy": ([handle], None),
+            "engine_eval": ([handle, ctypes.c_char_p, ctypes.c_char_p], handle),
+            "engine_error": ([handle], text),
+            "engine_output": ([handle], text),
+            "value_free": ([handle], None),
+            "value_json": ([handle], text),
+            "value_string": ([handle], text),
+        }
+        for func_name, (argtypes, restype) in signatures.items():
+            func = getattr(lib, func_name)
+            func.argtypes = argtypes
+            func.restype = restype
+        version = lib.coreil_abi_version()
+        if version != self.ABI_VERSION:
+            raise SharedError(f"{path} has C ABI version {version}, expected {self.ABI_VERSION}")
+        self._lib = lib
+
+    def create(self) -> SharedEngine:
+        """Run the program's top-level statements on a new engine."""
+        return SharedEngine(self._lib, self._lib.engine_create())
+
+
+class SharedEngine:
+    """An engine of a loaded shared library."""
+
+    def __init__(self, lib: ctypes.CDLL, handle: int):
+        self._lib = lib
+        self._handle = handle
+
+    def error(self) -> str | None:
+        """The error that stopped the last call, or None."""
+        return _take_string(self._lib, self._lib.engine_error(self._handle))
+
+    def output(self) -> str:
+        """The output printed since the last call to output()."""
+        return _take_string(self._lib, self._lib.engine_output(self._handle)) or ""
+
+    def eval(self, name: str, args: list[Any] | tuple = ()) -> Any:
+        """Call a top-level function, converting its result from JSON.
+
+        A result with no JSON form (e.g. NaN) comes back as the text Print
+        shows for it. Raises SharedError if the call fails.
+        """
+        value = self._lib.engine_eval(
+            self._handle, name.encode("utf-8"), json.dumps(list(args)).encode("utf-8")
+        )
+        if not value:
+            raise SharedError(self.error())
+        try:
+            text = _take_string(self._lib, self._lib.value_json(value))
+            if text is None:
+                return _take_string(self._lib, self._lib.value_string(value))
+            return json.loads(text)
+        finally:
+            self._lib.value_free(value)
+
+    def close(self) -> None:
+        if self._handle:
+            self._lib.engine_destroy(self._handle)
+            self._handle = 0
+
+    def __enter__(self) -> SharedEngine:
+        return self
+
+    def __exit__(self, *exc: object) -> None:
+        self.close()
+
+
+def _take_string(lib: ctypes.CDLL, ptr: int | None) -> str | None:
+    """Decode and free a string the library returned."""
+    if not ptr:
+        return None
+    try:
+        return ctypes.string_at(ptr).decode("utf-8")
+    finally:
+        lib.coreil_free(ptr)
@@ -0,0 +1,445 @@
+"""Function inlining for Core IL.
+
+inline_functions() replaces calls to small, non-recursive functions with
+their bodies, removing the call overhead that dominates the tiny helper
+functions the frontend likes to generate.
+
+Which calls are inlined:
+- calls to functions of at most always_size nodes, everywhere
+- calls to functions of at most max_size nodes that are hot (inside a loop)
+  or are the function's only call
+- never calls to recursive functions, directly or through other functions,
+  to functions with a Return that cannot be restructured (one inside a
+  loop, TryCatch or Switch), or to functions named in keep
+
+How a call is replaced:
+- A function whose body is a single Return has the call replaced by the
+  returned expression when every argument is a literal or a variable.
+- Otherwise the body is spliced in before the statement making the call,
+  provided nothing that statement evaluates before the call can fail.
+  Arguments are bound to temporaries in order, the function's variables
+  are renamed apart, and Returns assign a result variable that stands in
+  for the call.
+
+Usage:
+    from english_compiler.coreil.inline import inline_functions
+
+    doc = inline_functions(doc)
+"""
+
+from __future__ import annotations
+
+from collections import Counter
+from collections.abc import Iterable, Iterator
+from copy import deepcopy
+from dataclasses import dataclass
+from itertools import count
+from typing import Any
+
+from .node_nav import (
+    BLOCK_KEYS,
+    assigned_names,
+    expression_keys,
+    is_coreil_node,
+    iter_nodes,
+    referenced_names,
+)
+from .purity import cannot_fail
+from .source_map import remap_replaced_statements
+
+_LOOP_TYPES = ("While", "For", "ForEach")
+
+# Conditionally evaluated operands, which a spliced call must not come from
+_CONDITIONAL_KEYS = {"Binary": ("right",), "Ternary": ("consequent", "alternate")}
+
+
+
+@dataclass
+class _Function:
+    name: str
+    params: list[str]
+    body: list  # restructured so its Returns end each path (see _tail_form)
+    size: int
+    local_names: set[str]  # params and every variable the body assigns
+    free_names: set[str]  # variables the body reads from the global scope
+
+
+def inline_functions(
+    doc: dict,
+    *,
+    always_size: int = 12,
+    max_size: int = 40,
+    keep: Iterable[str] = (),
+) -> dict:
+    """Inline calls to small functions.
+
+    Sizes count Core IL nodes in the function body. Functions named in keep
+    (such as ones the Go backend memoizes) are never inlined. Returns a new
+    document (the input is not mutated).
+    """
+    result = deepcopy(doc)
+    body = result.get("body")
+    if not isinstance(body, list):
+        return result
+
+    defs = [stmt for stmt in body if is_coreil_node(stmt) and stmt["type"] == "FuncDef"]
+    defined = Counter(d.get("name") for d in defs)
+    by_name = {
+        d["name"]: d for d in defs
+        if defined[d.get("name")] == 1
+        and isinstance(d.get("params"), list)
+        and isinstance(d.get("body"), list)
+    }
+    graph = {
+        name: {n["name"] for n in iter_nodes(d["body"]) if n["type"] == "Call"} & by_name.keys()
+        for name, d in by_name.items()
+    }
+    calls = Counter(n.get("name") for n in iter_nodes(body) if n["type"] == "Call")
+    inliner = _Inliner(always_size, max_size, calls, _temp_prefixes(result))
+    keep = set(keep)
+
+    # Callees before callers, so a function's size includes what was
+    # inlined into it
+    for name in _callees_first(graph):
+        func_def = by_name[name]
+        scope = set(func_def["params"]) | assigned_names(func_def["body"])
+        func_def["body"] = inliner.block(func_def["body"], scope, hot=False)
+        if name not in keep and not _reaches(graph, name, name):
+            fn = _prepare(func_def)
+            if fn is not None:
+                inliner.functions[name] = fn
+
+    groups = [inliner.statement(stmt, None, hot=False) for stmt in body]
+    result["body"] = [stmt for group in groups for stmt in group]
+    if isinstance(result.get("source_map"), dict):
+        result["source_map"] = remap_replaced_statements(
+            result["source_map"], [len(group) for group in groups]
+        )
+    return result
+
+
+class _Inliner:
+    def __init__(self, always_size: int, max_size: int, calls: Counter, prefixes: Iterator[str]):
+        self.always_size = always_size
+        self.max_size = max_size
+        self.calls = calls
+        self.prefixes = prefixes
+        self.functions: dict[str, _Function] = {}
+        self.temps: set[str] = set()  # variables holding arguments and results
+
+    def inlinable(self, call: Any, scope: set[str] | None, hot: bool) -> _Function | None:
+        """The function a call may be inlined from, if any.
+
+        scope is the caller's local variables (None at the top level); the
+        function's globals must not be shadowed by them.
+        """
+        if not is_coreil_node(call) or call["type"] != "Call":
+            return None
+        fn = self.functions.get(call.get("name"))
+        if fn is None or len(call.get("args") or []) != len(fn.params):
+            return None
+        if scope is not None and fn.free_names & scope:
+            return None
+        if fn.size <= self.always_size:
+            return fn
+        if fn.size <= self.max_size and (hot or self.calls[fn.name] == 1):
+            return fn
+        return None
+
+    def block(self, stmts: list, scope: set[str] | None, hot: bool) -> list:
+        return [new for stmt in stmts for new in self.statement(stmt, scope, hot)]
+
+    def statement(self, stmt: Any, scope: set[str] | None, hot: bool) -> list:
+        """Inline the calls in stmt; returns what replaces it."""
+        if not is_coreil_node(stmt) or stmt["type"] == "FuncDef":
+            return [stmt]
+        for key in expression_keys(stmt):
+            stmt[key] = self.substitute(stmt[key], scope, hot)
+        inner_hot = hot or stmt["type"] in _LOOP_TYPES
+        for key in BLOCK_KEYS:
+            if isinstance(stmt.get(key), list):
+                stmt[key] = self.block(stmt[key], scope, inner_hot)
+        for case in stmt.get("cases") or []:
+            if isinstance(case, dict) and isinstance(case.get("body"), list):
+                case["body"] = self.block(case["body"], scope, inner_hot)
+        # A While test runs again each iteration, so nothing can go before it
+        if stmt["type"] == "While":
+            return [stmt]
+
+        if stmt["type"] == "Call":
+            fn = self.inlinable(stmt, scope, hot)
+            expansion = self.expand(fn, stmt, discard=True) if fn else None
+            return [stmt] if expansion is None else expansion[0]
+
+        spliced: list = []
+        while True:
+            call = _first_call(stmt, lambda c: self.inlinable(c, scope, hot) is not None)
+            if call is None:
+                break
+            expansion = self.expand(self.inlinable(call, scope, hot), call, discard=False)
+            if expansion is None:
+                break
+            prefix, value = expansion
+            spliced.extend(prefix)
+            _replace_node(stmt, call, value)
+        return spliced + [stmt]
+
+    def substitute(self, value: Any, scope: set[str] | None, hot: bool) -> Any:
+        """Replace calls to single-Return functions that have simple arguments."""
+        if isinstance(value, list):
+            return [self.substitute(item, scope, hot) for item in value]
+        if not isinstance(value, dict):
+            return value
+        for key in list(value):
+            value[key] = self.substitute(value[key], scope, hot)
+        fn = self.inlinable(value, scope, hot)
+        if fn is None or len(fn.body) != 1 or fn.body[0]["type"] != "Return":
+            return value
+        args = value.get("args") or []
+        if not all(cannot_fail(arg) for arg in args):
+            return value
+        returned = fn.body[0].get("value") or _none()
+        return _rename(returned, {}, dict(zip(fn.params, args)))
+
+    def expand(self, fn: _Function, call: dict, *, discard: bool) -> tuple[list, Any] | None:
+        """Statements computing call, and the expression left in its place.
+
+        With discard the call is a statement of its own and there is nothing
+        to leave; returned values are dropped, which is only possible when
+        evaluating them has no effect or is itself a call.
+        """
+        body = fn.body
+        if discard:
+            body = _rewrite_returns(body, self.discard)
+            if body is None:
+                return None
+        prefix = next(self.prefixes)
+        rename = {name: prefix + name for name in fn.local_names}
+        self.temps.update(rename[name] for name in fn.local_names & self.temps)
+        assigned = assigned_names(body)
+        used = referenced_names(body)
+        lets: list[dict] = []
+        bindings: dict[str, Any] = {}
+        for param, arg in zip(fn.params, call.get("args") or []):
+            if param not in used and param not in assigned:
+                # The argument must still be evaluated, but nothing would
+                # read a temporary holding it (or, for one of ours, the
+                # temporary it already is)
+                if not cannot_fail(arg) or arg.get("name") in self.temps:
+                    return None
+            elif cannot_fail(arg) and param not in assigned:
+                bindings[param] = arg
+            else:
+                lets.append({"type": "Let", "name": rename[param], "value": arg})
+                self.temps.add(rename[param])
+        body = _rename(body, rename, bindings)
+
+        if discard:
+            return lets + body, None
+        if not any(_contains_return(stmt) for stmt in body[:-1]) and body[-1]["type"] == "Return":
+            return lets + body[:-1], body[-1].get("value") or _none()
+        result = prefix.rstrip("_")
+        self.temps.add(result)
+        body = _rewrite_returns(
+            body, lambda value: [{"type": "Assign", "name": result, "value": value}]
+        )
+        let_result = {"type": "Let", "name": result, "value": _none()}
+        return lets + [let_result] + body, {"type": "Var", "name": result}
+
+    def discard(self, value: Any) -> list | None:
+        """Statements evaluating value for its effects alone, if possible."""
+        if is_coreil_node(value) and value["type"] == "Call":
+            return [value]
+        # Dropping the only read of one of our temporaries would leave it unused
+        if cannot_fail(value) and value.get("name") not in self.temps:
+            return []
+        return None
+
+
+def _prepare(func_def: dict) -> _Function | None:
+    body = _tail_form(deepcopy(func_def["body"]))
+    if body is None:
+        return None
+    # Dropping code after a Return can leave a variable never read, which
+    # some backends reject
+    if _unread(body) - _unread(func_def["body"]):
+        return None
+    params = list(func_def["params"])
+    local_names = set(params) | assigned_names(body)
+    return _Function(
+        name=func_def["name"],
+        params=params,
+        body=body,
+        size=sum(1 for _ in iter_nodes(body)),
+        local_names=local_names,
+        free_names=referenced_names(body) - local_names,
+    )
+
+
+def _unread(body: list) -> set[str]:
+    return assigned_names(body) - referenced_names(body)
+
+
+def _tail_form(body: list) -> list | None:
+    """Restructure body so every path through it ends in a Return.
+
+    Statements after an If that returns on some path move into its
+    branches, and a Return of None is added where the body falls off its
+    end. Returns None if a Return is inside a loop, TryCatch or Switch.
+    """
+    for i, stmt in enumerate(body):
+        if not is_coreil_node(stmt):
+            return None
+        if stmt["type"] == "Return":
+            return body[:i + 1]
+        if not _contains_return(stmt):
+            continue
+        if stmt["type"] != "If":
+            return None
+        rest = body[i + 1:]
+        then_body = _tail_form(stmt.get("then", []) + rest)
+        else_body = _tail_form((stmt.get("else") or []) + deepcopy(rest))
+        if then_body is None or else_body is None:
+            return None
+        return body[:i] + [{**stmt, "then": then_body, "else": else_body}]
+    return body + [{"type": "Return", "value": _none()}]
+
+
+def _rewrite_returns(body: list, replace) -> list | None:
+    """Replace the Return ending each path of a tail-form body."""
+    *init, last = body
+    if last["type"] == "Return":
+        replacement = replace(last.get("value") or _none())
+        return None if replacement is None else init + replacement
+    then_body = _rewrite_returns(last["then"], replace)
+    else_body = _rewrite_returns(last["else"], replace)
+    if then_body is None or else_body is None:
+        return None
+    return init + [{**last, "then": then_body, "else": else_body}]
+
+
+def _none() -> dict:
+    return {"type": "Literal", "value": None}
+
+
+def _contains_return(stmt: Any) -> bool:
+    return any(node["type"] == "Return" for node in iter_nodes(stmt))
+
+
+def _rename(value: Any, rename: dict[str, str], bindings: dict[str, Any]) -> Any:
+    """Copy value with variables renamed and bound parameters replaced."""
+    if isinstance(value, list):
+        return [_rename(item, rename, bindings) for item in value]
+    if not isinstance(value, dict):
+        return value
+    if is_coreil_node(value) and value["type"] == "Var" and value.get("name") in bindings:
+        return deepcopy(bindings[value["name"]])
+    result = {key: _rename(child, rename, bindings) for key, child in value.items()}
+    if is_coreil_node(value):
+        node_type = value["type"]
+        if node_type in ("Var", "Let", "Assign"):
+            keys: tuple[str, ...] = ("name",)
+        elif node_type in ("For", "ForEach"):
+            keys = ("var",)
+        elif node_type == "TryCatch":
+            keys = ("catch_var",)
+        elif node_type in ("PopFront", "PopBack", "HeapPop"):
+            keys = ("target",)
+        else:
+            keys = ()
+        for key in keys:
+            if result.get(key) in rename:
+                result[key] = rename[result[key]]
+    return result
+
+
+def _first_call(stmt: dict, accept) -> dict | None:
+    """The accepted call stmt makes before evaluating anything that could fail.
+
+    Its arguments may do anything: splicing evaluates them first, in order,
+    just as the call would.
+    """
+    blocked = False
+
+    def visit(value: Any) -> dict | None:
+        nonlocal blocked
+        if blocked:
+            return None
+        if isinstance(value, list):
+            for item in value:
+                found = visit(item)
+                if found is not None or blocked:
+                    return found
+            return None
+        if not isinstance(value, dict):
+            return None
+        if is_coreil_node(value) and value["type"] == "Call" and accept(value):
+            return value
+        conditional = _CONDITIONAL_KEYS.get(value.get("type"), ())
+        if value.get("type") == "Binary" and value.get("op") not in ("and", "or"):
+            conditional = ()
+        for key, child in value.items():
+            if key in conditional:
+                # Only evaluated depending on what came before
+                blocked = True
+                return None
+            found = visit(child)
+            if found is not None or blocked:
+                return found
+        if is_coreil_node(value) and not cannot_fail(value):
+            blocked = True
+        return None
+
+    return visit([stmt[key] for key in expression_keys(stmt)])
+
+
+def _replace_node(value: Any, target: dict, replacement: Any) -> bool:
+    """Replace the object target inside value; returns whether it was found."""
+    items = enumerate(value) if isinstance(value, list) else value.items() if isinstance(value, dict) else ()
+    for key, child in list(items):
+        if child is target:
+            value[key] = replacement
+            return True
+        if _replace_node(child, target, replacement):
+            return True
+    return False
+
+
+def _callees_first(graph: dict[str, set[str]]) -> list[str]:
+    order: list[str] = []
+    seen: set[str] = set()
+
+    def visit(name: str) -> None:
+        if name in seen:
+            return
+        seen.add(name)
+        for callee in sorted(graph[name]):
+            visit(callee)
+        order.append(name)
+
+    for name in graph:
+        visit(name)
+    return order
+
+
+def _reaches(graph: dict[str, set[str]], start: str, target: str) -> bool:
+    """Whether start calls target, directly or through other functions."""
+    stack, seen = list(graph[start]), set()
+    while stack:
+        name = stack.pop()
+        if name == target:
+            return True
+        if name not in seen:
+            seen.add(name)
+            stack.extend(graph.get(name, ()))
+    return False
+
+
+def _temp_prefixes(doc: dict) -> Iterator[str]:
+    """Fresh prefixes for the variables of each inlined call."""
+    used = {node.get("name") for node in iter_nodes(doc.get("body"))}
+    used |= {param for node in iter_nodes(doc.get("body")) for param in node.get("params") or []}
+    return (
+        f"__inl{i}_" for i in count()
+        if not any(isinstance(name, str) and name.startswith(f"__inl{i}") for name in used)
+    )
@@ -40,13 +40,19 @@ import heapq
 import json
 import math
 import re
+import sys
 from collections import deque
 from dataclasses import dataclass
 from pathlib import Path
-from typing import Any, Callable
+from types import GeneratorType
+from typing import Any, Callable, Iterator
 
 from .constants import BINARY_OPS, MAX_CALL_DEPTH
+from .diagnostics import format_diagnostics
 from .emit_utils import parse_regex_flags
+from .forall import DEFAULT_TRIALS, for_all
+from .node_nav import iter_tail_calls
+from .verify import verify_coreil
 from .versions import SUPPORTED_VERSIONS, get_version_error_message
 
 
@@ -67,6 +73,160 @@ class _ContinueSignal(Exception):
     pass
 
 
+@dataclass(frozen=True, repr=False)
+class _FunctionValue:
+    """A program function used as a value, as a Var naming it evaluates to."""
+
+    name: str
+
+    def __repr__(self) -> str:
+        # As the Go runtime prints a function value
+        return f"<function {self.name}>"
+
+
+# Call names handled by call_builtin rather than user functions
+_CALL_BUILTINS = frozenset({
+    "print", "input", "argv", "get_or_default", "entries", "append", "forAll", "readLines", "readChunks",
+    "intDiv", "heapFromArray", "heapPushPop", "heapReplace", "heapToSortedArray",
+    "dequeExtend", "dequeExtendLeft",
+    "mathAtan2", "mathHypot", "mathIsNaN", "mathIsInf", "mathIsFinite",
+})
+
+# The math calls that map straight onto Python's math module
+_MATH_CALLS = {
+    "mathAtan2": math.atan2,
+    "mathHypot": math.hypot,
+    "mathIsNaN": math.isnan,
+    "mathIsInf": math.isinf,
+    "mathIsFinite": math.isfinite,
+}
+
+# Exit codes of run_coreil besides 0; the Go runtime uses the same ones
+EXIT_ERROR = 1
+EXIT_LIMIT_EXCEEDED = 3
+
+
+def _open_stream(path: Any) -> tuple[Any, bool]:
+    """The binary file readLines or readChunks reads, and whether to close
+    it at the end; "-" is standard input."""
+    if not isinstance(path, str):
+        raise ValueError(f"expected a file path, got {type(path).__name__}")
+    if path == "-":
+        return sys.stdin.buffer, False
+    try:
+        return open(path, "rb"), True
+    except OSError as exc:
+        # The Go runtime's wording, e.g. "open x: no such file or directory"
+        raise ValueError(f"open {path}: {(exc.strerror or str(exc)).lower()}") from None
+
+
+def _read_lines(path: Any) -> Iterator[str]:
+    """readLines(path): the lines of a file without their line endings, read lazily."""
+    stream, owned = _open_stream(path)
+
+    def lines() -> Iterator[str]:
+        try:
+            for raw in stream:
+                line = raw.decode("utf-8", errors="replace")
+                yield line.removesuffix("\n").removesuffix("\r")
+        finally:
+            if owned:
+                stream.close()
+
+    return lines()
+
+
+def _read_chunks(reader: Any, size: Any) -> Iterator[str]:
+    """readChunks(reader, size): a file's contents in strings of size bytes, read lazily."""
+    if not isinstance(size, int) or isinstance(size, bool) or size <= 0:
+        raise ValueError(f"readChunks size must be positive, got {size}")
+    stream, owned = _open_stream(reader)
+
+    def chunks() -> Iterator[str]:
+        try:
+            while chunk := stream.read(size):
+                yield chunk.decode("utf-8", errors="replace")
+        finally:
+            if owned:
+                stream.close()
+
+    return chunks()
+
+
+def _modulo(a: Any, b: Any, truncate: bool) -> Any:
+    """a % b; with truncate, rounded toward zero as in C, so the result
+    takes a's sign instead of b's."""
+    if not truncate or not all(isinstance(v, (int, float)) for v in (a, b)):
+        return a % b
+    if isinstance(a, int) and isinstance(b, int):
+        r = abs(a) % abs(b)
+        return -r if a < 0 else r
+    if b == 0:
+        raise ZeroDivisionError("float modulo")
+    return math.fmod(a, b)
+
+
+def _int_div(a: Any, b: Any, truncate: bool) -> Any:
+    """intDiv(a, b): a // b, rounded toward zero with truncate."""
+    q = a // b
+    if truncate and a % b != 0 and (a < 0) != (b < 0):
+        q += 1
+    return q
+
+
+def _as_heap(value: Any, name: str) -> dict:
+    if not isinstance(value, dict) or "_heap_items" not in value:
+        raise ValueError(f"runtime error: {name} base must be a heap, got {type(value).__name__}")
+    return value
+
+
+def _heap_from_array(items: Any) -> dict:
+    """heapFromArray(items): a heap of [priority, value] pairs or numbers, heapified in O(n)."""
+    if not isinstance(items, list):
+        raise ValueError(f"runtime error: heapFromArray expects an array, got {type(items).__name__}")
+    entries = []
+    for i, item in enumerate(items):
+        if isinstance(item, (int, float)) and not isinstance(item, bool):
+            entries.append((item, i, item))
+        elif isinstance(item, (list, tuple)) and len(item) == 2:
+            entries.append((item[0], i, item[1]))
+        else:
+            raise ValueError(
+                f"runtime error: heapFromArray item {i} must be a number or a [priority, value] pair, "
+                f"got {type(item).__name__}"
+            )
+    heapq.heapify(entries)
+    return {"_heap_items": entries, "_heap_counter": len(entries)}
+
+
+def _heap_push_pop(heap: Any, priority: Any, value: Any, replace: bool) -> Any:
+    """heapPushPop and heapReplace: push and pop in one sift, in either order."""
+    heap = _as_heap(heap, "heapReplace" if replace else "heapPushPop")
+    if replace and not heap["_heap_items"]:
+        raise ValueError("runtime error: heap is empty")
+    counter = heap["_heap_counter"]
+    heap["_heap_counter"] = counter + 1
+    push_pop = heapq.heapreplace if replace else heapq.heappushpop
+    return push_pop(heap["_heap_items"], (priority, counter, value))[2]
+
+
+def _heap_to_sorted_array(heap: Any) -> list:
+    """heapToSortedArray(heap): the values by priority, leaving the heap as it is."""
+    return [value for _, _, value in sorted(_as_heap(heap, "heapToSortedArray")["_heap_items"])]
+
+
+@dataclass
+class _TailCallSignal(Exception):
+    """Signal to replace the current call with a call in tail position."""
+
+    name: str
+    args: list[Any]
+
+
+class _LimitExceeded(BaseException):
+    """Raised when a run exceeds max_steps; TryCatch cannot catch it."""
+
+
 @dataclass
 class _ThrowSignal(Exception):
     """Signal for explicit Throw statements."""
@@ -79,7 +239,25 @@ def run_coreil(
     error_callback: Callable[[str], None] | None = None,
     step_callback: Callable | None = None,
     base_dir: Path | None = None,
+    tail_calls: bool = True,
+    argv: list[str] | None = None,
+    max_steps: int | None = None,
 ) -> int:
+    """Run a Core IL document, returning the exit code.
+
+    With tail_calls=True (the default), a function that returns a call to a
+    user function reuses its frame, so accumulator-style recursion is not
+    bounded by MAX_CALL_DEPTH. Pass tail_calls=False to keep every frame,
+    e.g. while debugging.
+
+    The program is checked with verify_coreil() first; if it has any
+    violations, they are all reported and nothing runs.
+
+    argv is what the argv() builtin returns. max_steps bounds loop
+    iterations plus function calls, as Limits.MaxSteps does in the Go
+    runtime; exceeding it stops the run with EXIT_LIMIT_EXCEEDED. Any other
+    uncaught error returns EXIT_ERROR.
+    """
     # Note: For/ForEach are handled natively (no lowering needed)
     # This ensures Continue works correctly in for loops
 
@@ -91,8 +269,33 @@ def run_coreil(
 
         doc = resolve_imports(doc, base_dir=base_dir)
 
+    # Check the whole program first, so a malformed one reports every
+    # problem with its location before any statement runs
+    violations = verify_coreil(doc)
+    if violations:
+        error_msg = "invalid program:\n" + "\n".join(
+            f"  {line}" for line in format_diagnostics(doc, violations)
+        )
+        if error_callback:
+            error_callback(error_msg)
+        else:
+            print(error_msg)
+        return EXIT_ERROR
+
+    # Division semantics the document declares; see DIVISION_MODES
+    truncate = doc.get("division") == "truncate"
     global_env: dict[str, Any] = {}
     functions: dict[str, dict] = {}
+    # ids of Return nodes whose call may reuse the caller's frame
+    tail_returns: set[int] = set()
+    steps = 0
+
+    def step() -> None:
+        """Count one loop iteration or function call against max_steps."""
+        nonlocal steps
+        steps += 1
+        if steps > max_steps:
+            raise _LimitExceeded(f"limit exceeded: steps (max {max_steps})")
 
     def select_env(local_env: dict[str, Any] | None, in_func: bool) -> dict[str, Any]:
         """Return the active environment for writes."""
@@ -142,6 +345,8 @@ def run_coreil(
             name = node.get("name")
             if not isinstance(name, str) or not name:
                 raise ValueError("Var missing name")
+            if name in functions and name not in global_env and (local_env is None or name not in local_env):
+                return _FunctionValue(name)
             return lookup_var(name, local_env)
 
         if node_type == "Binary":
@@ -176,7 +381,7 @@ def run_coreil(
             if op == "/":
                 return left / right
             if op == "%":
-                return left % right
+                return _modulo(left, right, truncate)
             if op == "==":
                 return left == right
             if op == "!=":
@@ -202,9 +407,9 @@ def run_coreil(
             index = eval_expr(node.get("index"), local_env, call_depth)
             if not isinstance(index, int):
                 raise ValueError("Index must be an integer")
-            # Allow indexing into both arrays (lists) and tuples
-            if not isinstance(base, (list, tuple)):
-                raise ValueError("Index base must be an array or tuple")
+            # Allow indexing into arrays (lists), tuples and deques
+            if not isinstance(base, (list, tuple, deque)):
+                raise ValueError("Index base must be an array, tuple or deque")
             # Support negative indexing (Python-style: -1 = last element)
             if index < -len(base) or index >= len(base):
                 raise ValueError("Index out of range")
@@ -520,7 +725,12 @@ def run_coreil(
             return len(base)
 
         if node_type == "DequeNew":
-            return deque()
+            if node.get("maxlen") is None:
+                return deque()
+            maxlen = eval_expr(node["maxlen"], local_env, call_depth)
+            if not isinstance(maxlen, int) or isinstance(maxlen, bool) or maxlen < 0:
+                raise ValueError(f"runtime error: deque maxlen must be non-negative, got {maxlen}")
+            return deque(maxlen=maxlen)
 
         if node_type == "DequeSize":
             base = eval_expr(node["base"], local_env, call_depth)
@@ -629,15 +839,39 @@ def run_coreil(
                 "abs": abs,
                 "log": math.log,
                 "exp": math.exp,
+                "trunc": math.trunc,
+                "round": round,
+                "asin": math.asin,
+                "acos": math.acos,
+                "atan": math.atan,
+                "log2": math.log2,
+                "log10": math.log10,
+                "sinh": math.sinh,
+                "cosh": math.cosh,
+                "tanh": math.tanh,
             }
             if op not in ops:
                 raise ValueError(f"unknown math op '{op}'")
-            return ops[op](arg)
+            if node.get("ndigits") is not None:
+                return round(arg, eval_expr(node["ndigits"], local_env, call_depth))
+            try:
+                return ops[op](arg)
+            except ValueError:
+                # Same wording as the Go runtime's mathDomainCheck
+                raise ValueError(f"runtime error: math domain error in {op}") from None
+            except OverflowError:
+                # ... and its mathRangeCheck
+                raise OverflowError(f"runtime error: math range error in {op}") from None
 
         if node_type == "MathPow":
             base = eval_expr(node.get("base"), local_env, call_depth)
             exponent = eval_expr(node.get("exponent"), local_env, call_depth)
-            return math.pow(base, exponent)
+            try:
+                return math.pow(base, exponent)
+            except ValueError:
+                raise ValueError("runtime error: math domain error in pow") from None
+            except OverflowError:
+                raise OverflowError("runtime error: math range error in pow") from None
 
         if node_type == "MathConst":
             name = node.get("name")
@@ -645,6 +879,10 @@ def run_coreil(
                 return math.pi
             elif name == "e":
                 return math.e
+            elif name == "inf":
+                return math.inf
+            elif name == "nan":
+                return math.nan
             else:
                 raise ValueError(f"unknown math constant '{name}'")
 
@@ -788,6 +1026,33 @@ def run_coreil(
             if args:
                 prompt = str(args[0])
             return input(prompt)
+        if name == "argv":
+            return list(argv or [])
+        if name == "readLines":
+            return _read_lines(args[0])
+        if name == "readChunks":
+            return _read_chunks(args[0], args[1])
+        if name == "intDiv":
+            return _int_div(args[0], args[1], truncate)
+        if name == "heapFromArray":
+            return _heap_from_array(args[0])
+        if name in ("heapPushPop", "heapReplace"):
+            return _heap_push_pop(args[0], args[1], args[2], name == "heapReplace")
+        if name == "heapToSortedArray":
+            return _heap_to_sorted_array(args[0])
+        if name in _MATH_CALLS:
+            return _MATH_CALLS[name](*args)
+        if name in ("dequeExtend", "dequeExtendLeft"):
+            base, items = args
+            if not isinstance(base, deque):
+                raise ValueError(f"runtime error: {name} base must be a deque, got {type(base).__name__}")
+            # A copy, so a deque can be extended by itself
+            items = list(items)
+            if name == "dequeExtendLeft":
+                base.extendleft(items)
+            else:
+                base.extend(items)
+            return None
         # v0.4 backward compatibility: support helper functions as builtins
         if name == "get_or_default":
             if len(args) != 3:
@@ -819,15 +1084,38 @@ def run_coreil(
         func = functions.get(name)
         if func is None:
             raise ValueError(f"unknown function '{name}'")
-        params = func.get("params", [])
-        if len(args) != len(params):
-            raise ValueError("argument count mismatch")
-        local_env = dict(zip(params, args))
-        try:
-            exec_block(func.get("body", []), local_env, True, call_depth + 1)
-        except _ReturnSignal as signal:
-            return signal.value
-        return None
+        while True:
+            if max_steps is not None:
+                step()
+            params = func.get("params", [])
+            if len(args) != len(params):
+                raise ValueError("argument count mismatch")
+            local_env = dict(zip(params, args))
+            try:
+                exec_block(func.get("body", []), local_env, True, call_depth + 1)
+            except _TailCallSignal as signal:
+                func = functions[signal.name]
+                args = signal.args
+                continue
+            except _ReturnSignal as signal:
+                return signal.value
+            return None
+
+    def call_for_all(args: list[Any], call_depth: int) -> bool:
+        """forAll(generator, property, trials); see coreil.forall."""
+        name = args[1]
+        trials = args[2] if len(args) > 2 else DEFAULT_TRIALS
+
+        def check(values: list[Any]) -> str | None:
+            try:
+                return None if call_function(name, values, call_depth) else ""
+            except _ThrowSignal as exc:
+                return exc.message
+            except Exception as exc:
+                # What a TryCatch around the property would catch
+                return str(exc)
+
+        return for_all(args[0], name, trials, check)
 
     def call_any(node: dict, local_env: dict[str, Any] | None, call_depth: int) -> Any:
         name = node.get("name")
@@ -837,8 +1125,10 @@ def run_coreil(
         if not isinstance(args, list):
             raise ValueError("Call missing args")
         values = [eval_expr(arg, local_env, call_depth) for arg in args]
+        if name == "forAll":
+            return call_for_all(values, call_depth)
         # Check builtins (including v0.4 compatibility helpers)
-        if name in {"print", "input", "get_or_default", "entries", "append"}:
+        if name in _CALL_BUILTINS:
             return call_builtin(name, values)
         return call_function(name, values, call_depth)
 
@@ -898,6 +1188,8 @@ def run_coreil(
             if not isinstance(body, list):
                 raise ValueError("While body must be a list")
             while eval_expr(node.get("test"), local_env, call_depth):
+                if max_steps is not None:
+                    step()
                 try:
                     exec_block(body, local_env, in_func, call_depth)
                 except _ContinueSignal:
@@ -929,10 +1221,12 @@ def run_coreil(
                 iterator = range(from_val, end)
             else:
                 iterator = eval_expr(iter_expr, local_env, call_depth)
-                if not isinstance(iterator, (list, tuple)):
-                    raise ValueError("For iterator must be an array or tuple")
+                if not isinstance(iterator, (list, tuple, GeneratorType)):
+                    raise ValueError("For iterator must be an array, tuple or iterator")
 
             for val in iterator:
+                if max_steps is not None:
+                    step()
                 env[var] = val
                 try:
                     exec_block(body, local_env, in_func, call_depth)
@@ -956,10 +1250,12 @@ def run_coreil(
 
             # Evaluate iterator
             iterator = eval_expr(iter_expr, local_env, call_depth)
-            if not isinstance(iterator, (list, tuple)):
-                raise ValueError("ForEach iterator must be an array or tuple")
+            if not isinstance(iterator, (list, tuple, GeneratorType)):
+                raise ValueError("ForEach iterator must be an array, tuple or iterator")
 
             for val in iterator:
+                if max_steps is not None:
+                    step()
                 env[var] = val
                 try:
                     exec_block(body, local_env, in_func, call_depth)
@@ -1137,11 +1433,20 @@ def run_coreil(
             if not isinstance(name, str) or not name:
                 raise ValueError("FuncDef missing name")
             functions[name] = node
+            if tail_calls:
+                tail_returns.update(id(ret) for ret in iter_tail_calls(node.get("body") or []))
             return
 
         if node_type == "Return":
             if not in_func:
                 raise ValueError("Return outside function")
+            callee = node["value"].get("name") if id(node) in tail_returns else None
+            if callee in functions and callee not in _CALL_BUILTINS:
+                args = node["value"].get("args")
+                if not isinstance(args, list):
+                    raise ValueError("Call missing args")
+                values = [eval_expr(arg, local_env, call_depth) for arg in args]
+                raise _TailCallSignal(callee, values)
             if "value" in node:
                 value = eval_expr(node.get("value"), local_env, call_depth)
             else:
@@ -1202,6 +1507,12 @@ def run_coreil(
         if not isinstance(body, list):
             raise ValueError("body must be a list")
         exec_block(body, None, False, 0)
+    except _LimitExceeded as exc:
+        if error_callback:
+            error_callback(str(exc))
+        else:
+            print(exc)
+        return EXIT_LIMIT_EXCEEDED
     except ValueError as exc:
         # Re-raise Tier 2 (non-portable) errors so caller can handle them
         if (
@@ -1215,13 +1526,13 @@ def run_coreil(
             error_callback(error_msg)
         else:
             print(error_msg)
-        return 1
+        return EXIT_ERROR
     except Exception as exc:
         error_msg = f"runtime error: {exc}"
         if error_callback:
             error_callback(error_msg)
         else:
             print(error_msg)
-        return 1
+        return EXIT_ERROR
 
     return 0
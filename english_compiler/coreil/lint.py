@@ -9,6 +9,7 @@ Rules:
 - empty-body: If/While/For/ForEach/TryCatch with body: []
 - variable-shadowing: Let on an already-defined variable name (should be Assign)
 - unused-function: FuncDef with no Call reference to it in the remaining block
+  (a forAll naming it as its property counts as one)
 - infinite-loop: While(true) with no Break or Return in body
 - unreachable-branch: If with constant test (Literal true/false)
 
@@ -24,6 +25,7 @@ from __future__ import annotations
 
 from typing import Any
 
+from .builtins import property_literal
 from .node_nav import iter_nodes
 
 
@@ -232,6 +234,9 @@ def _check_block(
                 call_name = candidate.get("name")
                 if isinstance(call_name, str):
                     all_call_names.add(call_name)
+                prop = property_literal(candidate)
+                if prop is not None:
+                    all_call_names.add(prop["value"])
 
         for func_idx, func_name in func_decls:
             if func_name not in all_call_names:
@@ -1,9 +1,12 @@
 """Multi-file module system for Core IL (v1.10.5).
 
 This module implements import resolution for Core IL programs. The key design
-is *import flattening*: imported modules' FuncDef nodes are inlined into the
-importing document with prefixed names, and all dotted Call references
-(e.g., ``utils.add``) are rewritten to use ``__`` separators (``utils__add``).
+is *import flattening*: imported modules' functions (FuncDef) and constants
+(top-level Let) are inlined into the importing document with prefixed names,
+and all dotted Call and Var references (e.g., ``utils.add``, ``utils.PI``) are
+rewritten to use ``__`` separators (``utils__add``). A module may list the
+names it exports in an ``"exports"`` array; the others stay private.
+Import paths under ``std.`` name the standard library (see stdlib.py).
 
 The result is a flat, import-free Core IL document that existing interpreter
 and emitter logic can process without modification.
@@ -22,6 +25,9 @@ import json
 from pathlib import Path
 from typing import Any
 
+from .builtins import property_literal
+from .source_map import remap_replaced_statements
+from .stdlib import is_stdlib_import, stdlib_module_path, stdlib_modules
 from .validate import validate_coreil
 from .versions import SUPPORTED_VERSIONS
 
@@ -34,25 +40,32 @@ class ModuleNotFoundError(Exception):
     """Raised when an imported module file cannot be found."""
 
 
+class ImportNameError(ValueError):
+    """Raised when a program uses a name its imported module does not export."""
+
+
 class ModuleCache:
     """Tracks loaded modules and detects circular imports.
 
     Attributes:
         loaded: Mapping from resolved Path to parsed Core IL document.
         loading: Set of Paths currently being resolved (for cycle detection).
+        exports: Mapping from resolved Path to the module's exported names.
     """
 
     def __init__(self) -> None:
         self.loaded: dict[Path, dict] = {}
         self.loading: set[Path] = set()
+        self.exports: dict[Path, set[str]] = {}
 
 
-def resolve_module_path(import_path: str, base_dir: Path) -> Path:
+def resolve_module_path(import_path: str, base_dir: Path | None) -> Path:
     """Resolve an import path string to an absolute ``.coreil.json`` file path.
 
     The *import_path* is a dotted module name (e.g. ``"utils"`` or
     ``"lib.math_helpers"``).  Dots are converted to directory separators,
-    and ``.coreil.json`` is appended.
+    and ``.coreil.json`` is appended. ``std.`` paths name standard library
+    modules and do not need a base_dir.
 
     Args:
         import_path: Dotted module path from the Import node's ``path`` field.
@@ -64,6 +77,18 @@ def resolve_module_path(import_path: str, base_dir: Path) -> Path:
     Raises:
         ModuleNotFoundError: If the resolved path does not exist.
     """
+    if is_stdlib_import(import_path):
+        resolved = stdlib_module_path(import_path)
+        if not resolved.is_file():
+            raise ModuleNotFoundError(
+                f"module '{import_path}' is not in the standard library "
+                f"(available: {', '.join('std.' + name for name in stdlib_modules())})"
+            )
+        return resolved
+    if base_dir is None:
+        raise ValueError(
+            f"cannot resolve import '{import_path}': no base_dir provided for module resolution"
+        )
     # Convert dots to path separators and add .coreil.json suffix
     relative = import_path.replace(".", "/") + ".coreil.json"
     resolved = (base_dir / relative).resolve()
@@ -111,9 +136,8 @@ def load_module_doc(path: Path) -> dict:
 def extract_exports(doc: dict) -> dict[str, dict]:
     """Extract top-level FuncDef nodes from a Core IL document.
 
-    Only FuncDef statements at the top level of the body are considered
-    exports. Module-level initialization code (non-FuncDef statements) is
-    ignored in the MVP.
+    Constants are not included; see module_exports() for everything a
+    module offers importers.
 
     Args:
         doc: A validated Core IL document.
@@ -130,38 +154,136 @@ def extract_exports(doc: dict) -> dict[str, dict]:
     return exports
 
 
-def _rewrite_calls(node: Any, alias: str, func_names: set[str]) -> Any:
-    """Recursively rewrite dotted Call names to use ``__`` separator.
-
-    Transforms ``alias.func_name`` to ``alias__func_name`` in all Call nodes
-    throughout the AST.
+def module_exports(doc: dict) -> set[str]:
+    """The names a module offers importers: its functions and constants.
 
-    Args:
-        node: Any Core IL AST node (expression, statement, or primitive).
-        alias: The module alias (e.g. ``"utils"``).
-        func_names: Set of exported function names from that module.
+    Every top-level FuncDef and Let is exported unless the document lists
+    its exports in an ``"exports"`` array, in which case only those are;
+    the rest stay private to the module.
 
-    Returns:
-        The node (modified in place for dicts/lists, returned as-is for primitives).
+    Raises:
+        ValueError: If ``"exports"`` names something the module does not define.
+    """
+    defined = _top_level_names(doc)
+    exports = doc.get("exports")
+    if exports is None:
+        return defined
+    if not isinstance(exports, list) or not all(isinstance(name, str) for name in exports):
+        raise ValueError("module exports must be a list of names")
+    missing = [name for name in exports if name not in defined]
+    if missing:
+        raise ValueError(f"module exports undefined name(s): {', '.join(missing)}")
+    return set(exports)
+
+
+def _top_level_names(doc: dict) -> set[str]:
+    return {
+        stmt["name"]
+        for stmt in doc.get("body", [])
+        if isinstance(stmt, dict)
+        and stmt.get("type") in ("FuncDef", "Let")
+        and isinstance(stmt.get("name"), str)
+    }
+
+
+def _rename(node: Any, renames: dict[str, str], local: frozenset[str] = frozenset()) -> None:
+    """Rename references to top-level names throughout node, in place.
+
+    Call names (including the function a forAll tests), and Var and Assign
+    names not bound locally (function parameters and the names a function
+    body defines) are looked up in renames.
     """
-    if isinstance(node, dict):
-        # Rewrite Call nodes with dotted names
-        if node.get("type") == "Call":
-            name = node.get("name", "")
-            if "." in name:
-                parts = name.split(".", 1)
-                if parts[0] == alias and parts[1] in func_names:
-                    node["name"] = f"{alias}__{parts[1]}"
-
-        # Recurse into all dict values
-        for key, value in node.items():
-            _rewrite_calls(value, alias, func_names)
-
-    elif isinstance(node, list):
+    if isinstance(node, list):
         for item in node:
-            _rewrite_calls(item, alias, func_names)
+            _rename(item, renames, local)
+        return
+    if not isinstance(node, dict):
+        return
+    kind = node.get("type")
+    if kind == "FuncDef":
+        local = local | _bound_names(node)
+    name = node.get("name")
+    if isinstance(name, str) and name in renames:
+        if kind == "Call" or (kind in ("Var", "Assign") and name not in local):
+            node["name"] = renames[name]
+    prop = property_literal(node)
+    if prop is not None and prop["value"] in renames:
+        prop["value"] = renames[prop["value"]]
+    for value in node.values():
+        _rename(value, renames, local)
+
+
+def _bound_names(func_def: dict) -> frozenset[str]:
+    """The parameters of func_def and the names its body binds."""
+    names = {param for param in func_def.get("params", []) if isinstance(param, str)}
+
+    def visit(node: Any) -> None:
+        if isinstance(node, list):
+            for item in node:
+                visit(item)
+        elif isinstance(node, dict) and node.get("type") != "FuncDef":
+            if node.get("type") == "Let" and isinstance(node.get("name"), str):
+                names.add(node["name"])
+            for key in ("var", "catch_var"):
+                if isinstance(node.get(key), str):
+                    names.add(node[key])
+            for value in node.values():
+                visit(value)
+
+    visit(func_def.get("body", []))
+    return frozenset(names)
+
+
+def _link_module(module_doc: dict, alias: str) -> list[dict]:
+    """Module_doc's definitions, renamed into the importer under alias.
+
+    Every top-level FuncDef and Let (including those the module imported
+    itself) is prefixed with ``alias__``, along with the references to it
+    inside the module, keeping their order so constants are defined before
+    the functions that read them. Other module-level statements are not run.
+    """
+    renames = {name: f"{alias}__{name}" for name in _top_level_names(module_doc)}
+    definitions = []
+    for stmt in module_doc.get("body", []):
+        if not isinstance(stmt, dict) or stmt.get("type") not in ("FuncDef", "Let"):
+            continue
+        stmt = copy.deepcopy(stmt)
+        _rename(stmt, renames)
+        stmt["name"] = renames[stmt["name"]]
+        definitions.append(stmt)
+    return definitions
 
-    return node
+
+def _rename_qualified(node: Any, renames: dict[str, str], modules: dict[str, str]) -> None:
+    """Rewrite ``alias.name`` Call and Var references to the linked names.
+
+    So is a forAll property naming a module function as ``alias.name``.
+
+    Raises:
+        ImportNameError: If a reference names something its module does not export.
+    """
+    if isinstance(node, list):
+        for item in node:
+            _rename_qualified(item, renames, modules)
+        return
+    if not isinstance(node, dict):
+        return
+    name = node.get("name")
+    if node.get("type") in ("Call", "Var", "Assign") and isinstance(name, str) and "." in name:
+        alias, member = name.split(".", 1)
+        if name in renames:
+            node["name"] = renames[name]
+        elif alias in modules:
+            raise ImportNameError(f"module '{modules[alias]}' has no export '{member}'")
+    prop = property_literal(node)
+    if prop is not None and "." in prop["value"]:
+        alias, member = prop["value"].split(".", 1)
+        if prop["value"] in renames:
+            prop["value"] = renames[prop["value"]]
+        elif alias in modules:
+            raise ImportNameError(f"module '{modules[alias]}' has no export '{member}'")
+    for value in node.values():
+        _rename_qualified(value, renames, modules)
 
 
 def resolve_imports(
@@ -174,8 +296,10 @@ def resolve_imports(
     This is the main entry point for the module system. It:
     1. Finds all Import nodes in the document body.
     2. Loads each imported module (recursively resolving transitive imports).
-    3. Inlines imported FuncDef nodes with prefixed names.
-    4. Rewrites all dotted Call references to use ``__`` separators.
+    3. Inlines imported FuncDef and top-level Let nodes with prefixed names,
+       renaming the module's references to them.
+    4. Rewrites dotted Call and Var references to exported names to use
+       ``__`` separators.
     5. Returns a flat, import-free Core IL document.
 
     If the document has no Import nodes, it is returned unchanged (no copy).
@@ -183,7 +307,7 @@ def resolve_imports(
     Args:
         doc: The Core IL document to resolve.
         base_dir: Directory to resolve relative imports from.
-                  If None, imports are not supported and will raise.
+                  If None, only standard library imports are supported.
         cache: Optional ModuleCache for tracking loaded modules.
 
     Returns:
@@ -193,6 +317,7 @@ def resolve_imports(
     Raises:
         CircularImportError: If circular dependencies are detected.
         ModuleNotFoundError: If an imported module file is missing.
+        ImportNameError: If the document uses a name a module does not export.
         ValueError: If a module fails to load or validate.
     """
     body = doc.get("body", [])
@@ -205,11 +330,6 @@ def resolve_imports(
     if not import_nodes:
         return doc
 
-    if base_dir is None:
-        raise ValueError(
-            "Import nodes found but no base_dir provided for module resolution"
-        )
-
     if cache is None:
         cache = ModuleCache()
 
@@ -217,9 +337,9 @@ def resolve_imports(
     doc = copy.deepcopy(doc)
     body = doc["body"]
 
-    # Collect inlined FuncDefs and track rewrites needed
-    inlined_funcs: list[dict] = []
-    rewrites: list[tuple[str, set[str]]] = []  # (alias, func_names)
+    definitions: list[dict] = []
+    renames: dict[str, str] = {}  # "alias.name" -> linked name
+    modules: dict[str, str] = {}  # alias -> import path
 
     for stmt in body:
         if not isinstance(stmt, dict) or stmt.get("type") != "Import":
@@ -244,6 +364,10 @@ def resolve_imports(
             cache.loading.add(module_path)
             try:
                 module_doc = load_module_doc(module_path)
+                try:
+                    cache.exports[module_path] = module_exports(module_doc)
+                except ValueError as exc:
+                    raise ValueError(f"module {module_path}: {exc}") from exc
                 # Recursively resolve the module's own imports
                 module_doc = resolve_imports(
                     module_doc,
@@ -254,34 +378,35 @@ def resolve_imports(
             finally:
                 cache.loading.discard(module_path)
 
-        # Extract exported functions
-        exports = extract_exports(module_doc)
-        if not exports:
-            continue
-
-        # Prefix function names and inline
-        func_names = set(exports.keys())
-        for func_name, func_def in exports.items():
-            prefixed_name = f"{alias}__{func_name}"
-            func_def["name"] = prefixed_name
-            # Also rewrite any internal calls within the function body
-            # that reference other functions from the same module
-            _rewrite_calls(func_def, alias, func_names)
-            inlined_funcs.append(func_def)
-
-        rewrites.append((alias, func_names))
-
-    # Remove Import nodes from body
-    new_body = [
-        stmt for stmt in body
-        if not (isinstance(stmt, dict) and stmt.get("type") == "Import")
-    ]
-
-    # Prepend inlined functions (before the main program code)
-    doc["body"] = inlined_funcs + new_body
-
-    # Rewrite all dotted Call references in the entire document body
-    for alias, func_names in rewrites:
-        _rewrite_calls(doc["body"], alias, func_names)
+        if modules.get(alias) == import_path:
+            continue  # imported twice
+        if alias in modules:
+            raise ValueError(
+                f"alias '{alias}' is used for both '{modules[alias]}' and '{import_path}'"
+            )
+        modules[alias] = import_path
+        definitions.extend(_link_module(module_doc, alias))
+        for name in cache.exports[module_path]:
+            renames[f"{alias}.{name}"] = f"{alias}__{name}"
+
+    # Replace the Import nodes with the modules' definitions, which take
+    # the place of the first one (before the main program code)
+    new_body: list = []
+    counts: list[int] = []
+    linked = False
+    for stmt in body:
+        if not (isinstance(stmt, dict) and stmt.get("type") == "Import"):
+            _rename_qualified(stmt, renames, modules)
+            new_body.append(stmt)
+            counts.append(1)
+        elif not linked:
+            new_body.extend(definitions)
+            counts.append(len(definitions))
+            linked = True
+        else:
+            counts.append(0)
 
+    doc["body"] = new_body
+    if isinstance(doc.get("source_map"), dict):
+        doc["source_map"] = remap_replaced_statements(doc["source_map"], counts)
     return doc
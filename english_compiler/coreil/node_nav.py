@@ -10,6 +10,11 @@ from collections.abc import Iterator
 from typing import Any, TypeGuard
 
 
+# Statement keys holding nested statement lists (Switch cases hold theirs
+# under cases[i].body)
+BLOCK_KEYS = ("then", "else", "body", "catch_body", "finally_body", "default")
+
+
 def is_coreil_node(value: Any) -> TypeGuard[dict[str, Any]]:
     """Return True when value looks like a Core IL node object."""
     return isinstance(value, dict) and isinstance(value.get("type"), str)
@@ -38,3 +43,99 @@ def iter_nodes(value: Any, *, include_root: bool = True) -> Iterator[dict[str, A
         for item in value:
             yield from iter_nodes(item, include_root=True)
 
+
+def iter_node_paths(value: Any, path: str = "$.body") -> Iterator[tuple[str, dict[str, Any]]]:
+    """Yield (path, node) for every Core IL node in value, parents first.
+
+    Paths use the same JSONPath-like form as validation errors, e.g.
+    "$.body[2].value.left".
+    """
+    if is_coreil_node(value):
+        yield path, value
+    if isinstance(value, dict):
+        for key, child in value.items():
+            yield from iter_node_paths(child, f"{path}.{key}")
+    elif isinstance(value, list):
+        for i, child in enumerate(value):
+            yield from iter_node_paths(child, f"{path}[{i}]")
+
+
+def iter_tail_calls(body: list[Any]) -> Iterator[dict[str, Any]]:
+    """Yield the Return nodes of a function body that return a Call directly.
+
+    Such calls are in tail position: nothing in the caller runs after them.
+    Returns inside a TryCatch are skipped, since the catch and finally
+    blocks still apply while the callee runs.
+    """
+    for stmt in body:
+        if not is_coreil_node(stmt):
+            continue
+        node_type = stmt["type"]
+        if node_type == "Return":
+            value = stmt.get("value")
+            if is_coreil_node(value) and value["type"] == "Call":
+                yield stmt
+        elif node_type == "If":
+            yield from iter_tail_calls(stmt.get("then") or [])
+            yield from iter_tail_calls(stmt.get("else") or [])
+        elif node_type in ("While", "For", "ForEach"):
+            yield from iter_tail_calls(stmt.get("body") or [])
+        elif node_type == "Switch":
+            for case in stmt.get("cases") or []:
+                yield from iter_tail_calls(case.get("body") or [])
+            yield from iter_tail_calls(stmt.get("default") or [])
+
+
+def iter_statements(body: list[Any], path: str = "$.body") -> Iterator[tuple[str, dict[str, Any]]]:
+    """Yield (path, statement) for every statement in body, nested ones included.
+
+    Paths use the same JSONPath-like form as validation errors, e.g.
+    "$.body[2].then[0]". Statements are yielded before their children.
+    """
+    for i, stmt in enumerate(body):
+        if not is_coreil_node(stmt):
+            continue
+        stmt_path = f"{path}[{i}]"
+        yield stmt_path, stmt
+        for key in BLOCK_KEYS:
+            nested = stmt.get(key)
+            if isinstance(nested, list):
+                yield from iter_statements(nested, f"{stmt_path}.{key}")
+        for j, case in enumerate(stmt.get("cases") or []):
+            if isinstance(case, dict) and isinstance(case.get("body"), list):
+                yield from iter_statements(case["body"], f"{stmt_path}.cases[{j}].body")
+
+
+def expression_keys(stmt: dict[str, Any]) -> list[str]:
+    """Keys of the expressions a statement evaluates itself, in order.
+
+    These are evaluated before any nested block runs. FuncDef has none.
+    """
+    if stmt.get("type") == "FuncDef":
+        return []
+    return [
+        key for key, value in stmt.items()
+        if key not in BLOCK_KEYS and key != "cases"
+        and (is_coreil_node(value) or isinstance(value, list))
+    ]
+
+
+def referenced_names(value: Any) -> set[str]:
+    """Names of the variables read anywhere in value."""
+    return {node["name"] for node in iter_nodes(value) if node["type"] == "Var"}
+
+
+def assigned_names(value: Any) -> set[str]:
+    """Names of the variables bound or assigned anywhere in value."""
+    names: set[str] = set()
+    for node in iter_nodes(value):
+        node_type = node["type"]
+        if node_type in ("Let", "Assign"):
+            names.add(node.get("name"))
+        elif node_type in ("For", "ForEach"):
+            names.add(node.get("var"))
+        elif node_type == "TryCatch":
+            names.add(node.get("catch_var"))
+        elif node_type in ("PopFront", "PopBack", "HeapPop"):
+            names.add(node.get("target"))
+    return names
@@ -6,8 +6,10 @@ All optimizations guarantee identical output — same side effects, same errors.
 Optimization passes:
 1. Constant Folding - Evaluate constant expressions at compile time
 2. Dead Code Elimination - Remove unreachable code after Return/Break/Continue
-3. Identity Simplification - Simplify trivial operations (x+0, x*1, etc.)
+3. Identity Simplification - Simplify trivial operations (x+0, x*1, ""+s, etc.)
 4. Constant Propagation - Inline variables assigned once to a literal
+5. Builtin Evaluation - Pre-evaluate pure builtins (string methods,
+   conversions, exact math) whose arguments are literals
 
 Usage:
     from english_compiler.coreil.optimize import optimize
@@ -16,9 +18,14 @@ Usage:
 
 from __future__ import annotations
 
+import math
+import re
 from copy import deepcopy
 from typing import Any
 
+# Go ints are 64-bit; folding must not produce a literal it cannot hold
+_INT64_MIN, _INT64_MAX = -(2**63), 2**63 - 1
+
 
 def optimize(program: dict) -> dict:
     """Optimize a Core IL program. Returns a new program dict (does not mutate input)."""
@@ -32,6 +39,11 @@ def optimize(program: dict) -> dict:
     return result
 
 
+def fold_expr(expr: Any) -> Any:
+    """Fold and simplify a single expression. Returns a new expression."""
+    return _optimize_expr(expr)
+
+
 # ---------------------------------------------------------------------------
 # Statement-level optimizations
 # ---------------------------------------------------------------------------
@@ -95,10 +107,10 @@ def _optimize_stmt(stmt: dict) -> dict:
                 result.pop("else", None)
                 return result
             else:
-                # Always false — replace then with else body (or empty)
+                # Always false — the else body always runs instead
                 result = {
                     **stmt,
-                    "test": test,
+                    "test": {"type": "Literal", "value": True},
                     "then": else_body if else_body is not None else [],
                 }
                 result.pop("else", None)
@@ -276,13 +288,12 @@ def _optimize_expr(expr: Any) -> Any:
             result["default"] = _optimize_expr(expr["default"])
         return result
 
+    if node_type in ("StringLength", "StringTrim", "StringUpper", "StringLower"):
+        return _try_fold_builtin(_copy_with_optimized_expr_fields(expr, "base"))
+
     if node_type in (
         "GetField",
         "Keys",
-        "StringLength",
-        "StringTrim",
-        "StringUpper",
-        "StringLower",
         "DequeSize",
         "HeapSize",
         "HeapPeek",
@@ -303,16 +314,16 @@ def _optimize_expr(expr: Any) -> Any:
         return _copy_with_optimized_expr_fields(expr, "base", "delimiter")
 
     if node_type == "StringStartsWith":
-        return _copy_with_optimized_expr_fields(expr, "base", "prefix")
+        return _try_fold_builtin(_copy_with_optimized_expr_fields(expr, "base", "prefix"))
 
     if node_type == "StringEndsWith":
-        return _copy_with_optimized_expr_fields(expr, "base", "suffix")
+        return _try_fold_builtin(_copy_with_optimized_expr_fields(expr, "base", "suffix"))
 
     if node_type == "StringContains":
-        return _copy_with_optimized_expr_fields(expr, "base", "substring")
+        return _try_fold_builtin(_copy_with_optimized_expr_fields(expr, "base", "substring"))
 
     if node_type == "StringReplace":
-        return _copy_with_optimized_expr_fields(expr, "base", "old", "new")
+        return _try_fold_builtin(_copy_with_optimized_expr_fields(expr, "base", "old", "new"))
 
     if node_type == "SetHas":
         return _copy_with_optimized_expr_fields(expr, "base", "value")
@@ -326,7 +337,7 @@ def _optimize_expr(expr: Any) -> Any:
         return result
 
     if node_type in ("ToInt", "ToFloat", "ToString"):
-        return _copy_with_optimized_expr_fields(expr, "value")
+        return _try_fold_builtin(_copy_with_optimized_expr_fields(expr, "value"))
 
     if node_type == "Ternary":
         test = _optimize_expr(expr.get("test"))
@@ -345,10 +356,11 @@ def _optimize_expr(expr: Any) -> Any:
         return {**expr, "parts": parts}
 
     if node_type == "Math":
-        return _copy_with_optimized_expr_fields(expr, "arg")
+        fields = ("arg", "ndigits") if "ndigits" in expr else ("arg",)
+        return _try_fold_builtin(_copy_with_optimized_expr_fields(expr, *fields))
 
     if node_type == "MathPow":
-        return _copy_with_optimized_expr_fields(expr, "base", "exponent")
+        return _try_fold_builtin(_copy_with_optimized_expr_fields(expr, "base", "exponent"))
 
     if node_type == "JsonParse":
         return _copy_with_optimized_expr_fields(expr, "source")
@@ -438,7 +450,9 @@ def _try_fold_binary(op: str, left: Any, right: Any) -> dict | None:
                 return None  # Don't fold division by zero
             return {"type": "Literal", "value": lv / rv}
         if op == "%" and isinstance(lv, (int, float)) and isinstance(rv, (int, float)):
-            if rv == 0:
+            # Floored and truncated modulo (the document's division field)
+            # only agree when the operands share a sign
+            if rv == 0 or (lv < 0) != (rv < 0):
                 return None
             return {"type": "Literal", "value": lv % rv}
         # Comparisons
@@ -462,6 +476,14 @@ def _try_fold_binary(op: str, left: Any, right: Any) -> dict | None:
 
 def _try_simplify_identity(op: str, left: Any, right: Any) -> Any | None:
     """Try to simplify identity operations. Returns simplified node or None."""
+    # "" + s => s, s + "" => s, when s is known to be a string (otherwise
+    # the concatenation raises a type error that must be kept)
+    if op == "+":
+        if _is_literal(left) and left["value"] == "" and _is_string_expr(right):
+            return right
+        if _is_literal(right) and right["value"] == "" and _is_string_expr(left):
+            return left
+
     # x + 0 => x, 0 + x => x
     if op == "+":
         if _is_literal(right) and right["value"] == 0:
@@ -500,3 +522,155 @@ def _try_simplify_identity(op: str, left: Any, right: Any) -> Any | None:
             return right
 
     return None
+
+
+# Expressions that always evaluate to a string (or raise).
+_STRING_EXPRS = frozenset({
+    "StringFormat",
+    "StringTrim",
+    "StringUpper",
+    "StringLower",
+    "StringReplace",
+    "Substring",
+    "CharAt",
+    "Join",
+    "ToString",
+    "JsonStringify",
+    "RegexReplace",
+})
+
+
+def _is_string_expr(node: Any) -> bool:
+    if not isinstance(node, dict):
+        return False
+    if _is_literal(node):
+        return isinstance(node["value"], str)
+    if node.get("type") == "Binary" and node.get("op") == "+":
+        return _is_string_expr(node.get("left")) or _is_string_expr(node.get("right"))
+    return node.get("type") in _STRING_EXPRS
+
+
+def _is_ascii_literal(node: Any) -> bool:
+    """A string literal every backend measures and case-maps the same way."""
+    return _is_literal(node) and isinstance(node["value"], str) and node["value"].isascii()
+
+
+def _is_number_literal(node: Any) -> bool:
+    return (
+        _is_literal(node)
+        and isinstance(node["value"], (int, float))
+        and not isinstance(node["value"], bool)
+    )
+
+
+def _try_fold_builtin(node: dict) -> dict:
+    """Pre-evaluate a pure builtin whose arguments are literals.
+
+    Only results that every backend computes identically are folded: string
+    operations on ASCII text, exact conversions, and math that is correctly
+    rounded. Anything that would raise is left for the runtime to report,
+    including ints outside the 64-bit range and lossy float-to-int conversions.
+    """
+    node_type = node.get("type")
+    value: Any = None
+
+    if node_type in ("StringLength", "StringTrim", "StringUpper", "StringLower"):
+        if not _is_ascii_literal(node.get("base")):
+            return node
+        base = node["base"]["value"]
+        value = {
+            "StringLength": len,
+            "StringTrim": str.strip,
+            "StringUpper": str.upper,
+            "StringLower": str.lower,
+        }[node_type](base)
+
+    elif node_type in ("StringStartsWith", "StringEndsWith", "StringContains"):
+        arg_field = {
+            "StringStartsWith": "prefix",
+            "StringEndsWith": "suffix",
+            "StringContains": "substring",
+        }[node_type]
+        if not (_is_ascii_literal(node.get("base")) and _is_ascii_literal(node.get(arg_field))):
+            return node
+        base, arg = node["base"]["value"], node[arg_field]["value"]
+        if node_type == "StringStartsWith":
+            value = base.startswith(arg)
+        elif node_type == "StringEndsWith":
+            value = base.endswith(arg)
+        else:
+            value = arg in base
+
+    elif node_type == "StringReplace":
+        fields = (node.get("base"), node.get("old"), node.get("new"))
+        # Replacing "" inserts between characters, which backends disagree on
+        if not all(_is_ascii_literal(f) for f in fields) or fields[1]["value"] == "":
+            return node
+        value = fields[0]["value"].replace(fields[1]["value"], fields[2]["value"])
+
+    elif node_type == "ToString":
+        arg = node.get("value")
+        # Float formatting differs between backends, so floats are left alone
+        if not _is_literal(arg) or isinstance(arg["value"], float):
+            return node
+        v = arg["value"]
+        value = "True" if v is True else "False" if v is False else "None" if v is None else str(v)
+
+    elif node_type == "ToInt":
+        arg = node.get("value")
+        if not _is_literal(arg) or isinstance(arg["value"], bool):
+            return node
+        v = arg["value"]
+        if isinstance(v, int):
+            value = v
+        elif isinstance(v, float) and v.is_integer():
+            # Truncating 2.5 is a TypeError in strict mode
+            value = int(v)
+        elif isinstance(v, str) and re.fullmatch(r"-?[0-9]+", v):
+            value = int(v)
+        else:
+            return node
+
+    elif node_type == "ToFloat":
+        arg = node.get("value")
+        if not _is_number_literal(arg):
+            return node
+        value = float(arg["value"])
+
+    elif node_type == "Math":
+        arg = node.get("arg")
+        op = node.get("op")
+        if not _is_number_literal(arg) or not math.isfinite(arg["value"]):
+            return node
+        v = arg["value"]
+        if op == "abs":
+            value = abs(v)
+        elif op == "floor":
+            value = math.floor(v)
+        elif op == "ceil":
+            value = math.ceil(v)
+        elif op == "sqrt" and v >= 0:
+            value = math.sqrt(v)
+        else:
+            # sin, cos, log, ... are not correctly rounded everywhere
+            return node
+
+    elif node_type == "MathPow":
+        base, exponent = node.get("base"), node.get("exponent")
+        if not (_is_number_literal(base) and _is_number_literal(exponent)):
+            return node
+        b, e = base["value"], exponent["value"]
+        # Fold only exact integer powers
+        if not (isinstance(b, int) and isinstance(e, int) and 0 <= e <= 64):
+            return node
+        exact = b**e
+        if abs(exact) > 2**53:
+            return node
+        value = float(exact)
+
+    else:
+        return node
+
+    if isinstance(value, int) and not isinstance(value, bool) and not _INT64_MIN <= value <= _INT64_MAX:
+        return node
+    return {"type": "Literal", "value": value}
@@ -0,0 +1,182 @@
+"""Ordering and running of optimization passes.
+
+A PassManager runs a pipeline of passes over a Core IL document. Each pass
+works on one IR: "coreil", the document itself, or "ssa", a Module from
+build_ssa(). The manager converts between the two only where consecutive
+passes need different forms, so a run of SSA passes shares one conversion.
+
+Passes can name others they must run after. The pipeline is ordered to
+satisfy these, otherwise keeping the order given; a constraint on a pass
+that is not in the pipeline is ignored.
+
+After each pass the manager checks its result, with validate_coreil() or
+verify_ssa(), and raises PassError naming the pass if it is broken, rather
+than letting a bad transformation reach a backend.
+
+Usage:
+    from english_compiler.coreil.pass_manager import PassContext, PassManager, default_passes
+
+    context = PassContext()
+    doc = PassManager(default_passes()).run(doc, context)
+    removals = context.reports.get("dead-code", [])
+"""
+
+from __future__ import annotations
+
+from collections.abc import Callable
+from dataclasses import dataclass, field
+from typing import Any
+
+from .cse import eliminate_common_subexpressions, hoist_loop_invariants
+from .dead_code import eliminate_dead_code
+from .inline import inline_functions
+from .optimize import optimize
+from .ssa import Module, build_ssa, verify_ssa
+from .ssa_lower import lower_ssa
+from .ssa_opt import eliminate_dead_values, propagate_constants
+from .validate import validate_coreil
+
+IRS = ("coreil", "ssa")
+
+
+class PassError(Exception):
+    """A pass failed or left the program invalid."""
+
+
+@dataclass
+class PassContext:
+    """State shared by the passes of one run."""
+
+    # Pass name -> whatever the pass reports (see each pass)
+    reports: dict[str, Any] = field(default_factory=dict)
+    # Names of the passes run, in order
+    ran: list[str] = field(default_factory=list)
+
+
+@dataclass
+class Pass:
+    """An optimization pass.
+
+    run is called with the program in the pass's IR and the context. A
+    "coreil" pass returns the new document; an "ssa" pass changes the
+    Module in place and returns None.
+    """
+
+    name: str
+    run: Callable[[Any, PassContext], Any]
+    ir: str = "coreil"
+    after: tuple[str, ...] = ()
+    description: str = ""
+
+
+class PassManager:
+    def __init__(self, passes: list[Pass], *, verify: bool = True):
+        names = [p.name for p in passes]
+        duplicates = sorted({name for name in names if names.count(name) > 1})
+        if duplicates:
+            raise ValueError(f"duplicate pass names: {', '.join(duplicates)}")
+        for p in passes:
+            if p.ir not in IRS:
+                raise ValueError(f"pass '{p.name}' has unknown IR '{p.ir}'")
+        self.passes = _order(passes)
+        self.verify = verify
+
+    def run(self, doc: dict, context: PassContext | None = None) -> dict:
+        """Run the pipeline on doc; returns the optimized document.
+
+        The input document is not mutated.
+        """
+        if context is None:
+            context = PassContext()
+        program: Any = doc
+        ir = "coreil"
+        for p in self.passes:
+            if p.ir != ir:
+                program = self._convert(program, p.ir)
+                ir = p.ir
+            try:
+                result = p.run(program, context)
+            except PassError:
+                raise
+            except Exception as exc:
+                raise PassError(f"pass '{p.name}' failed: {exc}") from exc
+            if ir == "coreil":
+                program = result
+            context.ran.append(p.name)
+            self._check(program, f"pass '{p.name}'")
+        if ir == "ssa":
+            program = self._convert(program, "coreil")
+        return program
+
+    def _convert(self, program: Any, ir: str) -> Any:
+        try:
+            converted = build_ssa(program) if ir == "ssa" else lower_ssa(program)
+        except Exception as exc:
+            raise PassError(f"conversion to {ir} failed: {exc}") from exc
+        self._check(converted, f"conversion to {ir}")
+        return converted
+
+    def _check(self, program: Any, what: str) -> None:
+        if not self.verify:
+            return
+        if isinstance(program, Module):
+            errors = verify_ssa(program)
+        else:
+            errors = [f"{e['path']}: {e['message']}" for e in validate_coreil(program)]
+        if errors:
+            raise PassError(f"{what} produced invalid {_ir_name(program)}: {errors[0]}")
+
+
+def _ir_name(program: Any) -> str:
+    return "SSA" if isinstance(program, Module) else "Core IL"
+
+
+def _order(passes: list[Pass]) -> list[Pass]:
+    """Order passes so each runs after the ones it names, stably."""
+    names = {p.name for p in passes}
+    pending = list(passes)
+    ordered: list[Pass] = []
+    done: set[str] = set()
+    while pending:
+        for i, p in enumerate(pending):
+            if all(dep in done or dep not in names for dep in p.after):
+                ordered.append(pending.pop(i))
+                done.add(p.name)
+                break
+        else:
+            cycle = ", ".join(p.name for p in pending)
+            raise ValueError(f"pass ordering constraints form a cycle among: {cycle}")
+    return ordered
+
+
+# ---------------------------------------------------------------------------
+# The default pipeline
+# ---------------------------------------------------------------------------
+
+
+def default_passes() -> list[Pass]:
+    """The passes --optimize runs, in order.
+
+    "dead-code" reports its removals (see eliminate_dead_code()); their
+    paths refer to the document the pipeline started from, as it runs first.
+    """
+    def dead_code(doc: dict, context: PassContext) -> dict:
+        doc, context.reports["dead-code"] = eliminate_dead_code(doc)
+        return doc
+
+    return [
+        Pass("dead-code", dead_code,
+             description="remove unreachable code and unused variables"),
+        Pass("inline", lambda doc, _: inline_functions(doc), after=("dead-code",),
+             description="inline small functions"),
+        Pass("fold", lambda doc, _: optimize(doc), after=("inline",),
+             description="fold constants and simplify expressions"),
+        Pass("ssa-propagate", lambda module, _: propagate_constants(module), ir="ssa",
+             after=("fold",), description="propagate constants and copies"),
+        Pass("ssa-dce", lambda module, _: eliminate_dead_values(module), ir="ssa",
+             after=("ssa-propagate",), description="remove unused values"),
+        Pass("licm", lambda doc, _: hoist_loop_invariants(doc), after=("ssa-dce",),
+             description="hoist loop-invariant expressions"),
+        Pass("cse", lambda doc, _: eliminate_common_subexpressions(doc), after=("licm",),
+             description="eliminate common subexpressions"),
+    ]
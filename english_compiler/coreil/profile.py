@@ -0,0 +1,328 @@
+"""CPU and allocation profiles for compiled Core IL programs.
+
+A Go program built with emit_go(doc, profile=True) writes cpu.pprof and
+allocs.pprof to the directory named by COREIL_PROFILE when it exits. The
+files are standard pprof profiles (go tool pprof works on them); this
+module reads them and attributes time and allocations to IL functions and
+to categories of builtin operation (string concatenation, map operations,
+...), so users can see why a compiled program is slow.
+
+Usage:
+    report = build_report(doc, cpu_path, allocs_path)
+    print(format_summary(report))
+"""
+
+from __future__ import annotations
+
+import gzip
+from dataclasses import dataclass, field
+from pathlib import Path
+
+
+@dataclass
+class Sample:
+    """One pprof sample: its stack (innermost function first), values by
+    sample type, and string labels."""
+
+    frames: list[str]
+    values: dict[str, int]
+    labels: dict[str, str] = field(default_factory=dict)
+
+
+def _varint(data: bytes, pos: int) -> tuple[int, int]:
+    result = shift = 0
+    while True:
+        byte = data[pos]
+        pos += 1
+        result |= (byte & 0x7F) << shift
+        if byte < 0x80:
+            return result, pos
+        shift += 7
+
+
+def _fields(data: bytes):
+    """Yield (field number, value) for each field of a protobuf message.
+
+    Length-delimited values are returned as bytes, varints as ints; pprof
+    uses no other wire types.
+    """
+    pos = 0
+    while pos < len(data):
+        key, pos = _varint(data, pos)
+        number, wire = key >> 3, key & 7
+        if wire == 0:
+            value, pos = _varint(data, pos)
+        elif wire == 2:
+            length, pos = _varint(data, pos)
+            value, pos = data[pos:pos + length], pos + length
+        elif wire == 1:
+            value, pos = int.from_bytes(data[pos:pos + 8], "little"), pos + 8
+        elif wire == 5:
+            value, pos = int.from_bytes(data[pos:pos + 4], "little"), pos + 4
+        else:
+            raise ValueError(f"unsupported protobuf wire type {wire}")
+        yield number, value
+
+
+def _ints(value: int | bytes) -> list[int]:
+    """Decode a repeated integer field, packed or not."""
+    if isinstance(value, int):
+        return [value]
+    result, pos = [], 0
+    while pos < len(value):
+        n, pos = _varint(value, pos)
+        result.append(n)
+    return result
+
+
+def read_pprof(path: Path) -> list[Sample]:
+    """Read the samples of a pprof profile file."""
+    data = path.read_bytes()
+    if data[:2] == b"\x1f\x8b":
+        data = gzip.decompress(data)
+
+    strings: list[str] = []
+    sample_types: list[int] = []
+    raw_samples: list[tuple[list[int], list[int], list[tuple[int, int]]]] = []
+    locations: dict[int, list[int]] = {}
+    functions: dict[int, int] = {}
+    for number, value in _fields(data):
+        if number == 1:
+            sample_types.append(dict(_fields(value)).get(1, 0))
+        elif number == 2:
+            loc_ids, values, labels = [], [], []
+            for n, v in _fields(value):
+                if n == 1:
+                    loc_ids.extend(_ints(v))
+                elif n == 2:
+                    values.extend(_ints(v))
+                elif n == 3:
+                    label = dict(_fields(v))
+                    labels.append((label.get(1, 0), label.get(2, 0)))
+            raw_samples.append((loc_ids, values, labels))
+        elif number == 4:
+            loc_id, function_ids = 0, []
+            for n, v in _fields(value):
+                if n == 1:
+                    loc_id = v
+                elif n == 4:
+                    function_ids.append(dict(_fields(v)).get(1, 0))
+            locations[loc_id] = function_ids
+        elif number == 5:
+            fn = dict(_fields(value))
+            functions[fn.get(1, 0)] = fn.get(2, 0)
+        elif number == 6:
+            strings.append(value.decode("utf-8"))
+
+    types = [strings[t] for t in sample_types]
+    samples = []
+    for loc_ids, values, labels in raw_samples:
+        # A location lists inlined functions innermost first
+        frames = [
+            strings[functions.get(fid, 0)]
+            for loc_id in loc_ids
+            for fid in locations.get(loc_id, [])
+        ]
+        samples.append(Sample(
+            frames=frames,
+            values=dict(zip(types, values)),
+            labels={strings[k]: strings[v] for k, v in labels if v},
+        ))
+    return samples
+
+
+# Runtime functions by the operation category they implement, matched on
+# the function name with the "main." package prefix removed. Methods are
+# matched on their receiver type.
+_CATEGORY_PREFIXES = [
+    ("stringConcat", "string concat"),
+    ("concatStrings", "string concat"),
+    ("string", "string ops"),
+    ("joinValues", "string ops"),
+    ("coreilPrint", "printing/formatting"),
+    ("format", "printing/formatting"),
+    ("repr", "printing/formatting"),
+    ("valueToStringConvert", "printing/formatting"),
+    ("map", "map ops"),
+    ("OrderedMap", "map ops"),
+    ("ValueMap", "map ops"),
+    ("hashKey", "map ops"),
+    ("array", "array ops"),
+    ("ValueArray", "array ops"),
+    ("record", "record ops"),
+    ("Record", "record ops"),
+    ("ValueRecord", "record ops"),
+    ("set", "set/deque/heap ops"),
+    ("ValueSet", "set/deque/heap ops"),
+    ("deque", "set/deque/heap ops"),
+    ("Deque", "set/deque/heap ops"),
+    ("heap", "set/deque/heap ops"),
+    ("MinHeap", "set/deque/heap ops"),
+    ("valueCopy", "copying"),
+    ("valueDeepCopy", "copying"),
+    ("value", "arithmetic/comparison"),
+    ("logicalNot", "arithmetic/comparison"),
+    ("intModulo", "arithmetic/comparison"),
+    ("floatDivide", "arithmetic/comparison"),
+    ("floatModulo", "arithmetic/comparison"),
+    ("math", "math"),
+    ("json", "json"),
+    ("regex", "regex"),
+    ("deepCopy", "copying"),
+    ("external", "external calls"),
+    ("callValue", "function values"),
+    ("callMethod", "function values"),
+]
+
+_OTHER = "IL code"
+
+
+def _category(function: str) -> str | None:
+    """Return the operation category of a runtime function, or None."""
+    if not function.startswith("main."):
+        if function.startswith(("runtime.gc", "runtime.bgsweep", "runtime.bgscavenge")):
+            return "garbage collection"
+        return None
+    name = function[len("main."):]
+    if name.startswith("("):
+        # Method: main.(*OrderedMap).Set -> OrderedMap
+        name = name.strip("(*").split(")", 1)[0]
+    for prefix, category in _CATEGORY_PREFIXES:
+        if name.startswith(prefix):
+            return category
+    return None
+
+
+def _sample_category(sample: Sample) -> str:
+    """Categorize a sample by the innermost runtime operation on its stack."""
+    for frame in sample.frames:
+        category = _category(frame)
+        if category is not None:
+            return category
+    return _OTHER
+
+
+def _sample_function(sample: Sample, il_functions: set[str]) -> str:
+    """Name the IL function a sample belongs to.
+
+    CPU samples carry an il_function label, which the runtime keeps right
+    across tail calls; allocation samples are attributed to the innermost
+    IL function on the Go stack.
+    """
+    if "il_function" in sample.labels:
+        return sample.labels["il_function"]
+    for frame in sample.frames:
+        if not frame.startswith("main."):
+            continue
+        # Closures are main.fn.func1; IL functions are emitted as fn_<name>
+        name = frame[len("main."):].split(".", 1)[0]
+        if name == "main":
+            return name
+        if name.startswith("fn_") and name[len("fn_"):] in il_functions:
+            return name[len("fn_"):]
+    return "(runtime)"
+
+
+def _ranked(totals: dict[str, int], grand_total: int, key: str) -> list[dict]:
+    return [
+        {
+            "name": name,
+            key: value,
+            "percent": round(100 * value / grand_total, 1) if grand_total else 0.0,
+        }
+        for name, value in sorted(totals.items(), key=lambda kv: (-kv[1], kv[0]))
+    ]
+
+
+def _attribute(
+    samples: list[Sample], value_type: str, il_functions: set[str]
+) -> tuple[int, dict[str, int], dict[str, int]]:
+    total = 0
+    by_function: dict[str, int] = {}
+    by_category: dict[str, int] = {}
+    for sample in samples:
+        value = sample.values.get(value_type, 0)
+        if not value:
+            continue
+        total += value
+        function = _sample_function(sample, il_functions)
+        category = _sample_category(sample)
+        by_function[function] = by_function.get(function, 0) + value
+        by_category[category] = by_category.get(category, 0) + value
+    return total, by_function, by_category
+
+
+def build_report(doc: dict, cpu_path: Path, allocs_path: Path | None = None) -> dict:
+    """Attribute a program's CPU time and allocations to IL functions and
+    operation categories.
+
+    Time and allocations are charged to the innermost IL function active
+    when they happened ("main" for top-level statements) and to the
+    innermost builtin operation category on the stack ("IL code" when the
+    generated code itself was running).
+    """
+    il_functions = {
+        stmt.get("name") for stmt in doc.get("body", []) if stmt.get("type") == "FuncDef"
+    }
+    total_ns, fn_ns, cat_ns = _attribute(read_pprof(cpu_path), "cpu", il_functions)
+    report = {
+        "cpu": {
+            "total_ms": round(total_ns / 1e6, 1),
+            "functions": _ranked(
+                {k: round(v / 1e6, 1) for k, v in fn_ns.items()}, total_ns / 1e6, "ms"
+            ),
+            "categories": _ranked(
+                {k: round(v / 1e6, 1) for k, v in cat_ns.items()}, total_ns / 1e6, "ms"
+            ),
+        },
+    }
+    if allocs_path is not None:
+        samples = read_pprof(allocs_path)
+        total_bytes, fn_bytes, cat_bytes = _attribute(samples, "alloc_space", il_functions)
+        total_objects = sum(s.values.get("alloc_objects", 0) for s in samples)
+        report["allocs"] = {
+            "total_bytes": total_bytes,
+            "total_objects": total_objects,
+            "functions": _ranked(fn_bytes, total_bytes, "bytes"),
+            "categories": _ranked(cat_bytes, total_bytes, "bytes"),
+        }
+    return report
+
+
+def _format_bytes(n: float) -> str:
+    for unit in ("B", "KB", "MB"):
+        if n < 1024:
+            return f"{n:.0f} {unit}" if unit == "B" else f"{n:.1f} {unit}"
+        n /= 1024
+    return f"{n:.1f} GB"
+
+
+def format_summary(report: dict, limit: int = 8) -> str:
+    """Describe a report in a few lines: where time and allocations went."""
+    cpu = report["cpu"]
+    lines = []
+    if cpu["total_ms"]:
+        lines.append(f"CPU: {cpu['total_ms']:.0f} ms sampled")
+        lines.append("  by IL function:")
+        lines.extend(f"    {e['percent']:5.1f}%  {e['name']}" for e in cpu["functions"][:limit])
+        lines.append("  by operation:")
+        lines.extend(f"    {e['percent']:5.1f}%  {e['name']}" for e in cpu["categories"][:limit])
+    else:
+        lines.append("CPU: no samples (the program ran too briefly to profile)")
+    allocs = report.get("allocs")
+    if allocs and allocs["total_bytes"]:
+        lines.append(
+            f"Allocations: {_format_bytes(allocs['total_bytes'])} "
+            f"in {allocs['total_objects']} objects"
+        )
+        lines.append("  by IL function:")
+        lines.extend(
+            f"    {e['percent']:5.1f}%  {e['name']} ({_format_bytes(e['bytes'])})"
+            for e in allocs["functions"][:limit]
+        )
+        lines.append("  by operation:")
+        lines.extend(
+            f"    {e['percent']:5.1f}%  {e['name']} ({_format_bytes(e['bytes'])})"
+            for e in allocs["categories"][:limit]
+        )
+    return "\n".join(lines)
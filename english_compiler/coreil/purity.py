@@ -0,0 +1,188 @@
+"""Purity annotations for Core IL expressions.
+
+Optimization passes consult these before reusing or moving an expression.
+Every expression node type is annotated with one of:
+
+- PURE: no side effects; the result depends only on operand values
+- READS: no side effects, but the result depends on the contents of a
+  container operand, so it can change whenever any container is mutated
+- FRESH: no side effects, but each evaluation allocates a new container, so
+  two evaluations are never interchangeable even with equal operands
+- EFFECT: may mutate containers, perform I/O or run user code
+
+Any node other than Literal, Var and MathConst may raise at runtime.
+
+Usage:
+    from english_compiler.coreil.purity import expr_purity, has_effects
+
+    if not has_effects(expr):
+        ...
+"""
+
+from __future__ import annotations
+
+from typing import Any
+
+from .node_nav import is_coreil_node, iter_nodes
+
+PURE = "pure"
+READS = "reads"
+FRESH = "fresh"
+EFFECT = "effect"
+
+EXPR_PURITY: dict[str, str] = {
+    # Leaves
+    "Literal": PURE,
+    "Var": PURE,
+    "MathConst": PURE,
+    # Scalar and string operations
+    "Not": PURE,
+    "Ternary": PURE,
+    "Math": PURE,
+    "MathPow": PURE,
+    "ToInt": PURE,
+    "ToFloat": PURE,
+    "StringLength": PURE,
+    "Substring": PURE,
+    "CharAt": PURE,
+    "StringTrim": PURE,
+    "StringUpper": PURE,
+    "StringLower": PURE,
+    "StringStartsWith": PURE,
+    "StringEndsWith": PURE,
+    "StringContains": PURE,
+    "StringReplace": PURE,
+    "RegexMatch": PURE,
+    "RegexReplace": PURE,
+    # Reads of container contents
+    "Length": READS,
+    "Index": READS,
+    "Get": READS,
+    "GetDefault": READS,
+    "GetField": READS,
+    "SetHas": READS,
+    "SetSize": READS,
+    "DequeSize": READS,
+    "HeapSize": READS,
+    "HeapPeek": READS,
+    "Join": READS,
+    "JsonStringify": READS,
+    "ToString": READS,
+    "StringFormat": READS,
+    # Constructors
+    "Array": FRESH,
+    "Tuple": FRESH,
+    "Map": FRESH,
+    "Set": FRESH,
+    "Record": FRESH,
+    "Range": FRESH,
+    "Keys": FRESH,
+    "Slice": FRESH,
+    "StringSplit": FRESH,
+    "RegexFindAll": FRESH,
+    "RegexSplit": FRESH,
+    "JsonParse": FRESH,
+    "DequeNew": FRESH,
+    "HeapNew": FRESH,
+    # Calls out of the expression
+    "Call": EFFECT,
+    "MethodCall": EFFECT,
+    "PropertyGet": EFFECT,
+    "ExternalCall": EFFECT,
+}
+
+# "+" and "*" also concatenate and repeat arrays; comparisons look inside
+# containers. Arithmetic on numbers and and/or (which return an operand) do
+# neither.
+BINARY_PURITY: dict[str, str] = {
+    "+": FRESH,
+    "*": FRESH,
+    "-": PURE,
+    "/": PURE,
+    "%": PURE,
+    "and": PURE,
+    "or": PURE,
+    "==": READS,
+    "!=": READS,
+    "<": READS,
+    "<=": READS,
+    ">": READS,
+    ">=": READS,
+}
+
+# Statements that mutate a container in place
+MUTATING_STATEMENTS = frozenset({
+    "SetIndex",
+    "Set",
+    "SetField",
+    "Push",
+    "SetAdd",
+    "SetRemove",
+    "PushBack",
+    "PushFront",
+    "PopFront",
+    "PopBack",
+    "HeapPush",
+    "HeapPop",
+})
+
+_INFALLIBLE = frozenset({"Literal", "Var", "MathConst"})
+
+_STATEMENT_TYPES = frozenset({
+    "Let",
+    "Assign",
+    "If",
+    "While",
+    "Print",
+    "FuncDef",
+    "Return",
+    "For",
+    "ForEach",
+    "Break",
+    "Continue",
+    "Throw",
+    "TryCatch",
+    "Switch",
+    "Import",
+}) | (MUTATING_STATEMENTS - {"Set"})
+
+
+def expr_purity(node: dict) -> str:
+    """Purity of a single expression node, not counting its operands.
+
+    Unknown node types are treated as EFFECT.
+    """
+    node_type = node.get("type")
+    if node_type == "Binary":
+        return BINARY_PURITY.get(node.get("op"), EFFECT)
+    return EXPR_PURITY.get(node_type, EFFECT)
+
+
+def mutates(node: dict) -> bool:
+    """Whether a node can mutate a container or run user code."""
+    node_type = node.get("type")
+    if node_type in MUTATING_STATEMENTS:
+        # A Set literal shares its name with the map-update statement
+        return not (node_type == "Set" and "items" in node)
+    if node_type in _STATEMENT_TYPES:
+        return False
+    return expr_purity(node) == EFFECT
+
+
+def has_effects(value: Any) -> bool:
+    """Whether evaluating value (a node or list of nodes) can mutate anything."""
+    return any(mutates(node) for node in iter_nodes(value))
+
+
+def reads_containers(value: Any) -> bool:
+    """Whether value's result can depend on the contents of a container."""
+    return any(
+        node.get("type") not in _STATEMENT_TYPES and expr_purity(node) == READS
+        for node in iter_nodes(value)
+    )
+
+
+def cannot_fail(node: Any) -> bool:
+    """Whether evaluating node can never raise."""
+    return is_coreil_node(node) and node["type"] in _INFALLIBLE
+
@@ -5,10 +5,21 @@ Composes two-stage source maps:
 2. Core IL statement index → target language line numbers (from emitter)
 
 The composition gives: English line → target language line numbers.
+remap_replaced_statements() keeps stage 1 in step with optimization passes
+that remove or expand body statements.
+
+statement_sentences() attributes Core IL statements to the English sentence
+they were compiled from, so runtime errors can be reported in terms of the
+original source. Frontends also store the sentence spans in the document
+(see sentence_spans), so document_sentences() can do this without the
+source file.
 """
 
 from __future__ import annotations
 
+import re
+from dataclasses import dataclass
+
 
 def compose_source_maps(
     english_to_coreil: dict[str, list[int]],
@@ -33,3 +44,153 @@ def compose_source_maps(
         if target_lines:
             result[eng_line] = sorted(target_lines)
     return result
+
+
+def remap_replaced_statements(
+    english_to_coreil: dict[str, list[int]],
+    replacement_counts: list[int],
+) -> dict[str, list[int]]:
+    """Update an English→CoreIL map after body statements were replaced.
+
+    Args:
+        english_to_coreil: The map for the original body.
+        replacement_counts: For each original statement, how many statements
+            now stand in its place (0 if it was removed). All of them are
+            attributed to the original statement's lines.
+
+    Returns:
+        The map for the new body. Lines left without statements are dropped.
+    """
+    spans: list[range] = []
+    start = 0
+    for n in replacement_counts:
+        spans.append(range(start, start + n))
+        start += n
+    result: dict[str, list[int]] = {}
+    for eng_line, coreil_indices in english_to_coreil.items():
+        indices = [j for i in coreil_indices if 0 <= i < len(spans) for j in spans[i]]
+        if indices:
+            result[eng_line] = indices
+    return result
+
+
+@dataclass(frozen=True)
+class SentenceLocation:
+    """An English sentence that a Core IL statement was compiled from."""
+
+    sentence_id: int  # 1-indexed position of the sentence in the source
+    line: int  # 1-indexed line the sentence starts on
+    column: int  # 1-indexed column the sentence starts at
+    text: str
+    end_line: int  # 1-indexed line and column of its last character
+    end_column: int
+
+
+_SENTENCE_END = re.compile(r"[.!?](?=\s|$)|\n\s*\n")
+
+
+def split_sentences(source_text: str) -> list[SentenceLocation]:
+    """Split English source into sentences with their start positions.
+
+    A sentence ends at '.', '!' or '?' followed by whitespace, or at a
+    blank line. Whitespace inside a sentence is collapsed.
+    """
+    sentences: list[SentenceLocation] = []
+    pos = 0
+    for end in [m.end() for m in _SENTENCE_END.finditer(source_text)] + [len(source_text)]:
+        chunk = source_text[pos:end]
+        stripped = chunk.lstrip()
+        if stripped.strip():
+            start = pos + len(chunk) - len(stripped)
+            last = start + len(stripped.rstrip()) - 1
+            sentences.append(SentenceLocation(
+                sentence_id=len(sentences) + 1,
+                line=source_text.count("\n", 0, start) + 1,
+                column=start - source_text.rfind("\n", 0, start),
+                text=" ".join(stripped.split()),
+                end_line=source_text.count("\n", 0, last) + 1,
+                end_column=last - source_text.rfind("\n", 0, last),
+            ))
+        pos = end
+    return sentences
+
+
+def sentence_spans(source_text: str) -> list[dict]:
+    """The sentences of source_text as a document's "sentences" metadata.
+
+    Each is {"line", "column", "end_line", "end_column", "text"}; sentence
+    n is the n-th entry. Together with source_map this attributes every
+    statement to a sentence, and it stays valid as passes rewrite the body
+    because it refers to the source, not to statements.
+    """
+    return [
+        {
+            "line": s.line,
+            "column": s.column,
+            "end_line": s.end_line,
+            "end_column": s.end_column,
+            "text": s.text,
+        }
+        for s in split_sentences(source_text)
+    ]
+
+
+def document_sentences(doc: dict, source_text: str | None = None) -> dict[int, SentenceLocation]:
+    """statement_sentences() for doc, from source_text or its "sentences" metadata.
+
+    Empty when doc has no source_map, or neither is available.
+    """
+    source_map = doc.get("source_map")
+    if not isinstance(source_map, dict):
+        return {}
+    if source_text:
+        return statement_sentences(source_text, source_map)
+    spans = doc.get("sentences")
+    if not isinstance(spans, list):
+        return {}
+    try:
+        sentences = [
+            SentenceLocation(
+                sentence_id=i + 1,
+                line=int(span["line"]),
+                column=int(span["column"]),
+                text=str(span["text"]),
+                end_line=int(span["end_line"]),
+                end_column=int(span["end_column"]),
+            )
+            for i, span in enumerate(spans)
+        ]
+    except (KeyError, TypeError, ValueError):
+        return {}  # malformed metadata; validate_coreil reports it
+    return _attribute(sentences, source_map)
+
+
+def statement_sentences(
+    source_text: str,
+    english_to_coreil: dict[str, list[int]],
+) -> dict[int, SentenceLocation]:
+    """Map Core IL body statement indices to the English sentence they came from.
+
+    When several statements share an English line, the n-th of them is
+    attributed to the n-th sentence starting on that line. A statement
+    mapped from several lines uses its earliest line; a line on which no
+    sentence starts uses the sentence spanning it.
+    """
+    return _attribute(split_sentences(source_text), english_to_coreil)
+
+
+def _attribute(
+    sentences: list[SentenceLocation],
+    english_to_coreil: dict[str, list[int]],
+) -> dict[int, SentenceLocation]:
+    if not sentences:
+        return {}
+    result: dict[int, SentenceLocation] = {}
+    for eng_line in sorted(english_to_coreil, key=int):
+        line = int(eng_line)
+        starting = [s for s in sentences if s.line == line]
+        if not starting:
+            starting = [s for s in sentences if s.line < line][-1:] or sentences[:1]
+        for n, ci in enumerate(sorted(english_to_coreil[eng_line])):
+            result.setdefault(ci, starting[min(n, len(starting) - 1)])
+    return result
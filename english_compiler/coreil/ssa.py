@@ -0,0 +1,838 @@
+"""Static single assignment (SSA) form for Core IL.
+
+The SSA IR sits between Core IL and the backends: build_ssa() converts a
+document, the passes in ssa_opt.py transform it, and lower_ssa() in
+ssa_lower.py converts it back. pass_manager.py runs passes on either form.
+
+Control flow stays structured, as in WebAssembly: If, Switch and loops hold
+nested regions instead of jumping between basic blocks, and phis sit where
+control flow merges. Each phi has one incoming value per edge into the merge:
+- after an If or Switch: one edge per branch that falls through ("then",
+  "else", "case0", ..., "default")
+- at a loop header: "entry", the "end" of the body and each Continue
+- after a loop: "exit" (the test failed or the items ran out) and each Break
+
+Each Define gives a new Value for one variable. Expressions stay Core IL
+trees; a Var that reads a Value carries its id under "ssa". Some variables
+are left in memory, read and written by name as in Core IL:
+- globals that functions read
+- variables assigned inside a TryCatch, which can jump to its catch body
+  from anywhere
+- variables read where they may not be defined yet (such a read fails, or
+  in a function, reads the global of the same name)
+
+Usage:
+    from english_compiler.coreil.ssa import build_ssa, verify_ssa
+
+    module = build_ssa(doc)
+    assert not verify_ssa(module)
+"""
+
+from __future__ import annotations
+
+from collections.abc import Iterator
+from copy import deepcopy
+from dataclasses import dataclass, field
+from itertools import count
+from typing import Any
+
+from .node_nav import assigned_names, is_coreil_node, iter_nodes, referenced_names
+
+_POP_TYPES = ("PopFront", "PopBack", "HeapPop")
+
+
+@dataclass(eq=False)
+class Value:
+    id: int
+    name: str  # the variable this is a version of
+
+
+@dataclass(eq=False)
+class Phi:
+    dest: Value
+    # Edge -> the expression (a Value read or a literal) the edge brings in,
+    # or None where the variable is not defined
+    incoming: dict[str, Any]
+
+
+@dataclass(eq=False)
+class Op:
+    # Index of the top-level Core IL statement the op came from
+    origin: int | None = field(default=None, kw_only=True)
+
+
+@dataclass(eq=False)
+class Define(Op):
+    dest: Value
+    value: Any
+
+
+@dataclass(eq=False)
+class Effect(Op):
+    """Any other simple statement, including Let/Assign of memory variables.
+
+    dest is the Value a PopFront, PopBack or HeapPop defines, if promoted.
+    """
+
+    node: dict
+    dest: Value | None = None
+
+
+@dataclass(eq=False)
+class Jump(Op):
+    kind: str  # "Break" or "Continue"
+    edge: str  # the phi edge it feeds
+
+
+@dataclass(eq=False)
+class If(Op):
+    test: Any
+    then: list[Op]
+    orelse: list[Op] | None
+    phis: list[Phi] = field(default_factory=list)
+
+
+@dataclass(eq=False)
+class Switch(Op):
+    test: Any
+    cases: list[tuple[Any, list[Op]]]
+    default: list[Op] | None
+    phis: list[Phi] = field(default_factory=list)
+
+
+@dataclass(eq=False)
+class Loop(Op):
+    """A While, For or ForEach loop.
+
+    A While test is evaluated at the header on every iteration; For and
+    ForEach items are evaluated once, before the loop. The loop variable of
+    a For or ForEach is defined afresh (var_value) at the start of the body
+    when promoted.
+    """
+
+    kind: str
+    test: Any
+    items: Any
+    var: str | None
+    var_value: Value | None
+    header: list[Phi]
+    body: list[Op]
+    exit: list[Phi]
+
+
+@dataclass(eq=False)
+class Try(Op):
+    node: dict  # the TryCatch without its blocks
+    body: list[Op]
+    catch_body: list[Op]
+    finally_body: list[Op] | None
+
+
+@dataclass(eq=False)
+class FuncDef(Op):
+    node: dict  # the FuncDef without its body
+    function: Function
+
+
+@dataclass(eq=False)
+class Function:
+    name: str | None  # None for the top level
+    params: list[str]
+    param_values: dict[str, Value]
+    body: list[Op]
+    memory: set[str]
+    values: dict[int, Value]
+
+
+@dataclass(eq=False)
+class Module:
+    doc: dict  # the document without its body
+    main: Function
+    statement_count: int  # top-level statements in the document built from
+    names: set[str]  # every variable and function name the document uses
+
+    def functions(self) -> Iterator[Function]:
+        """The top level, then every function, outermost first."""
+        pending = [self.main]
+        while pending:
+            fn = pending.pop(0)
+            yield fn
+            pending.extend(op.function for op in iter_ops(fn.body) if isinstance(op, FuncDef))
+
+
+# ---------------------------------------------------------------------------
+# Traversal
+# ---------------------------------------------------------------------------
+
+
+def regions(op: Op) -> list[list[Op]]:
+    """The nested regions of an op, in order (a function's body is not one)."""
+    if isinstance(op, If):
+        return [op.then] + ([op.orelse] if op.orelse is not None else [])
+    if isinstance(op, Switch):
+        return [body for _, body in op.cases] + ([op.default] if op.default is not None else [])
+    if isinstance(op, Loop):
+        return [op.body]
+    if isinstance(op, Try):
+        return [op.body, op.catch_body] + ([op.finally_body] if op.finally_body is not None else [])
+    return []
+
+
+def branches(op: If | Switch) -> dict[str, list[Op] | None]:
+    """Edge -> region of each branch; None where an If has no else or a
+    Switch no default."""
+    if isinstance(op, If):
+        return {"then": op.then, "else": op.orelse}
+    result: dict[str, list[Op] | None] = {f"case{i}": body for i, (_, body) in enumerate(op.cases)}
+    result["default"] = op.default
+    return result
+
+
+def iter_ops(ops: list[Op]) -> Iterator[Op]:
+    """Yield ops and the ops of their regions, in order."""
+    for op in ops:
+        yield op
+        for region in regions(op):
+            yield from iter_ops(region)
+
+
+def phis(op: Op) -> list[Phi]:
+    if isinstance(op, (If, Switch)):
+        return op.phis
+    if isinstance(op, Loop):
+        return op.header + op.exit
+    return []
+
+
+def expressions(op: Op) -> list[Any]:
+    """The expressions an op evaluates itself, excluding phi operands."""
+    if isinstance(op, Define):
+        return [op.value]
+    if isinstance(op, Effect):
+        return [op.node]
+    if isinstance(op, (If, Switch)):
+        return [op.test] + ([value for value, _ in op.cases] if isinstance(op, Switch) else [])
+    if isinstance(op, Loop):
+        return [expr for expr in (op.test, op.items) if expr is not None]
+    return []
+
+
+def defined_values(op: Op) -> list[Value]:
+    """The Values an op defines, phis included."""
+    values = [phi.dest for phi in phis(op)]
+    if isinstance(op, (Define, Effect)) and op.dest is not None:
+        values.append(op.dest)
+    if isinstance(op, Loop) and op.var_value is not None:
+        values.append(op.var_value)
+    return values
+
+
+def value_reads(expr: Any) -> Iterator[dict]:
+    """The Var nodes in expr that read a Value."""
+    return (node for node in iter_nodes(expr) if node["type"] == "Var" and "ssa" in node)
+
+
+def read(value: Value) -> dict:
+    return {"type": "Var", "name": value.name, "ssa": value.id}
+
+
+def falls_through(ops: list[Op]) -> bool:
+    """Whether running ops can reach the end of the region."""
+    if not ops:
+        return True
+    last = ops[-1]
+    if isinstance(last, Jump):
+        return False
+    if isinstance(last, Effect):
+        return last.node.get("type") not in ("Return", "Throw")
+    if isinstance(last, If):
+        return falls_through(last.then) or last.orelse is None or falls_through(last.orelse)
+    if isinstance(last, Switch):
+        branches = [body for _, body in last.cases] + [last.default or []]
+        return any(falls_through(body) for body in branches)
+    return True
+
+
+def jumps(ops: list[Op], kind: str) -> Iterator[Jump]:
+    """Breaks or Continues in ops that leave the innermost enclosing loop."""
+    for op in ops:
+        if isinstance(op, Jump) and op.kind == kind:
+            yield op
+        if not isinstance(op, Loop):
+            for region in regions(op):
+                yield from jumps(region, kind)
+
+
+# ---------------------------------------------------------------------------
+# Construction
+# ---------------------------------------------------------------------------
+
+
+def build_ssa(doc: dict) -> Module:
+    """Convert a Core IL document to SSA form.
+
+    The document is not mutated.
+    """
+    doc = deepcopy(doc)
+    body = doc.pop("body", None)
+    if not isinstance(body, list):
+        body = []
+    func_defs = [node for node in iter_nodes(body) if node["type"] == "FuncDef"]
+    read_by_functions = set()
+    for func_def in func_defs:
+        local_names = set(func_def.get("params") or []) | _local_assignments(func_def.get("body"))
+        read_by_functions |= referenced_names(func_def.get("body")) - local_names
+    main = _build_function(None, [], body, read_by_functions, origins=True)
+    names = {
+        node.get(key) for node in iter_nodes(body) for key in ("name", "var", "target", "catch_var")
+    } | {param for func_def in func_defs for param in func_def.get("params") or []}
+    names.discard(None)
+    return Module(doc=doc, main=main, statement_count=len(body), names=names)
+
+
+def _build_function(
+    name: str | None,
+    params: list[str],
+    body: list,
+    memory: set[str],
+    *,
+    origins: bool = False,
+) -> Function:
+    memory = memory | _try_assignments(body)
+    # Demoting one variable can leave another undefined somewhere, so
+    # rebuild until every promoted variable is defined wherever it is read
+    while True:
+        builder = _Builder(set(params) | _local_assignments(body), memory)
+        fn = builder.function(name, params, body, origins)
+        demote = builder.undefined | _undefined_phis(fn)
+        if not demote:
+            prune_phis(fn)
+            return fn
+        memory = memory | demote
+
+
+def _local_assignments(body: Any) -> set[str]:
+    """Variables a function body assigns, not counting nested functions."""
+    names: set[str] = set()
+    for stmt in _statements(body):
+        names |= assigned_names({k: v for k, v in stmt.items() if not isinstance(v, list)})
+    return names
+
+
+def _try_assignments(body: Any) -> set[str]:
+    names: set[str] = set()
+    for stmt in _statements(body):
+        if stmt["type"] == "TryCatch":
+            names.add(stmt.get("catch_var"))
+            for key in ("body", "catch_body", "finally_body"):
+                names |= _local_assignments(stmt.get(key))
+    return names
+
+
+def _statements(body: Any) -> Iterator[dict]:
+    """Statements of a body at any depth, not entering nested functions."""
+    for stmt in body if isinstance(body, list) else []:
+        if not is_coreil_node(stmt):
+            continue
+        yield stmt
+        if stmt["type"] == "FuncDef":
+            continue
+        for key, child in stmt.items():
+            if key == "cases":
+                for case in child or []:
+                    if isinstance(case, dict):
+                        yield from _statements(case.get("body"))
+            elif isinstance(child, list):
+                yield from _statements(child)
+
+
+class _Builder:
+    def __init__(self, assignable: set[str], memory: set[str]):
+        self.promoted = assignable - memory
+        self.memory = memory
+        self.values: dict[int, Value] = {}
+        self.ids = count()
+        self.edges = count()
+        self.undefined: set[str] = set()  # promoted variables read while undefined
+        self.loops: list[dict[str, dict]] = []  # edge -> environment, per enclosing loop
+
+    def function(self, name: str | None, params: list[str], body: list, origins: bool) -> Function:
+        env = {param: self.new_value(param) for param in params if param in self.promoted}
+        param_values = dict(env)
+        ops: list[Op] = []
+        for i, stmt in enumerate(body):
+            if env is None:
+                break
+            start = len(ops)
+            env = self.statement(stmt, env, ops)
+            if origins:
+                for op in ops[start:]:
+                    op.origin = i
+        return Function(
+            name=name,
+            params=list(params),
+            param_values=param_values,
+            body=ops,
+            memory=set(self.memory),
+            values=self.values,
+        )
+
+    def new_value(self, name: str) -> Value:
+        value = Value(next(self.ids), name)
+        self.values[value.id] = value
+        return value
+
+    def rename(self, expr: Any, env: dict) -> Any:
+        """Copy expr with promoted variable reads pointing at their Values."""
+        if isinstance(expr, list):
+            return [self.rename(item, env) for item in expr]
+        if not isinstance(expr, dict):
+            return expr
+        if expr.get("type") == "Var" and expr.get("name") in self.promoted:
+            value = env.get(expr["name"])
+            if value is None:
+                self.undefined.add(expr["name"])
+                return dict(expr)
+            return read(value)
+        return {key: self.rename(child, env) for key, child in expr.items()}
+
+    def block(self, stmts: Any, env: dict) -> tuple[list[Op], dict | None]:
+        ops: list[Op] = []
+        current: dict | None = dict(env)
+        for stmt in stmts if isinstance(stmts, list) else []:
+            if current is None:
+                break  # unreachable
+            current = self.statement(stmt, current, ops)
+        return ops, current
+
+    def statement(self, stmt: Any, env: dict, ops: list[Op]) -> dict | None:
+        """Append the ops for stmt; returns the environment after it.
+
+        None means control never continues past the statement.
+        """
+        if not is_coreil_node(stmt):
+            ops.append(Effect(stmt))
+            return env
+        node_type = stmt["type"]
+
+        if node_type in ("Let", "Assign") and stmt.get("name") in self.promoted:
+            dest = self.new_value(stmt["name"])
+            ops.append(Define(dest, self.rename(stmt.get("value"), env)))
+            return {**env, dest.name: dest}
+
+        if node_type in _POP_TYPES and stmt.get("target") in self.promoted:
+            dest = self.new_value(stmt["target"])
+            ops.append(Effect(self.rename(stmt, env), dest))
+            return {**env, dest.name: dest}
+
+        if node_type == "If":
+            test = self.rename(stmt.get("test"), env)
+            then_ops, then_env = self.block(stmt.get("then"), env)
+            else_ops, else_env = (None, env) if stmt.get("else") is None else self.block(stmt["else"], env)
+            op = If(test, then_ops, else_ops)
+            op.phis, after = self.merge({"then": then_env, "else": else_env})
+            ops.append(op)
+            return after
+
+        if node_type == "Switch":
+            test = self.rename(stmt.get("test"), env)
+            cases, edges = [], {}
+            for i, case in enumerate(stmt.get("cases") or []):
+                case_ops, edges[f"case{i}"] = self.block(case.get("body"), env)
+                cases.append((self.rename(case.get("value"), env), case_ops))
+            default = None
+            edges["default"] = env
+            if stmt.get("default") is not None:
+                default, edges["default"] = self.block(stmt["default"], env)
+            op = Switch(test, cases, default)
+            op.phis, after = self.merge(edges)
+            ops.append(op)
+            return after
+
+        if node_type in ("While", "For", "ForEach"):
+            return self.loop(stmt, env, ops)
+
+        if node_type in ("Break", "Continue"):
+            edge = f"{node_type.lower()}{next(self.edges)}"
+            if self.loops:
+                self.loops[-1][edge] = env
+            ops.append(Jump(node_type, edge))
+            return None
+
+        if node_type == "TryCatch":
+            blocks = {
+                key: self.block(stmt.get(key), env)[0]
+                for key in ("body", "catch_body", "finally_body")
+            }
+            node = {k: v for k, v in stmt.items() if k not in blocks}
+            finally_body = blocks["finally_body"] if stmt.get("finally_body") is not None else None
+            ops.append(Try(node, blocks["body"], blocks["catch_body"], finally_body))
+            return env
+
+        if node_type == "FuncDef":
+            params = [p for p in stmt.get("params") or [] if isinstance(p, str)]
+            function = _build_function(stmt.get("name"), params, stmt.get("body") or [], set())
+            ops.append(FuncDef({k: v for k, v in stmt.items() if k != "body"}, function))
+            return env
+
+        ops.append(Effect(self.rename(stmt, env)))
+        return None if node_type in ("Return", "Throw") else env
+
+    def loop(self, stmt: dict, env: dict, ops: list[Op]) -> dict:
+        kind = stmt["type"]
+        body = stmt.get("body")
+        var = stmt.get("var") if kind != "While" else None
+        carried = sorted((_local_assignments(body) | {var}) & self.promoted)
+        items = self.rename(stmt.get("iter"), env) if kind != "While" else None
+
+        header = [Phi(self.new_value(name), {"entry": _read_or_none(env.get(name))}) for name in carried]
+        header_env = {**env, **{phi.dest.name: phi.dest for phi in header}}
+        test = self.rename(stmt.get("test"), header_env) if kind == "While" else None
+        body_env = header_env
+        var_value = None
+        if var in self.promoted:
+            var_value = self.new_value(var)
+            body_env = {**header_env, var: var_value}
+
+        self.loops.append({})
+        body_ops, end_env = self.block(body, body_env)
+        edges = self.loops.pop()
+        back = {"end": end_env} if end_env is not None else {}
+        back.update({edge: e for edge, e in edges.items() if edge.startswith("continue")})
+        for phi in header:
+            for edge, edge_env in back.items():
+                phi.incoming[edge] = _read_or_none(edge_env.get(phi.dest.name))
+
+        exits = {"exit": header_env}
+        exits.update({edge: e for edge, e in edges.items() if edge.startswith("break")})
+        exit_phis, after = self.merge(exits)
+        ops.append(Loop(kind, test, items, var, var_value, header, body_ops, exit_phis))
+        return after
+
+    def merge(self, edges: dict[str, dict | None]) -> tuple[list[Phi], dict | None]:
+        """Phis for where the environments of the edges reaching a merge differ."""
+        live = {edge: env for edge, env in edges.items() if env is not None}
+        if not live:
+            return [], None
+        names = sorted({name for env in live.values() for name in env})
+        merged, result = {}, []
+        for name in names:
+            values = [env.get(name) for env in live.values()]
+            if all(value is values[0] for value in values):
+                if values[0] is not None:
+                    merged[name] = values[0]
+                continue
+            phi = Phi(self.new_value(name), {
+                edge: _read_or_none(env.get(name)) for edge, env in live.items()
+            })
+            result.append(phi)
+            merged[name] = phi.dest
+        return result, merged
+
+
+def _read_or_none(value: Value | None) -> dict | None:
+    return None if value is None else read(value)
+
+
+# ---------------------------------------------------------------------------
+# Phi cleanup
+# ---------------------------------------------------------------------------
+
+
+def used_values(fn: Function) -> set[int]:
+    """Ids of the Values something reads, through phis that are themselves used."""
+    used: set[int] = set()
+    by_dest: dict[int, Phi] = {}
+    for op in iter_ops(fn.body):
+        for expr in expressions(op):
+            used.update(node["ssa"] for node in value_reads(expr))
+        for phi in phis(op):
+            by_dest[phi.dest.id] = phi
+    pending = [value_id for value_id in used if value_id in by_dest]
+    while pending:
+        phi = by_dest[pending.pop()]
+        for expr in phi.incoming.values():
+            for node in value_reads(expr):
+                if node["ssa"] not in used:
+                    used.add(node["ssa"])
+                    if node["ssa"] in by_dest:
+                        pending.append(node["ssa"])
+    return used
+
+
+def prune_phis(fn: Function) -> None:
+    """Remove phis whose values are never used."""
+    used = used_values(fn)
+    for op in iter_ops(fn.body):
+        if isinstance(op, (If, Switch)):
+            op.phis = [phi for phi in op.phis if phi.dest.id in used]
+        elif isinstance(op, Loop):
+            op.header = [phi for phi in op.header if phi.dest.id in used]
+            op.exit = [phi for phi in op.exit if phi.dest.id in used]
+    for value_id in set(fn.values) - used - _non_phi_definitions(fn):
+        del fn.values[value_id]
+
+
+def _non_phi_definitions(fn: Function) -> set[int]:
+    ids = {value.id for value in fn.param_values.values()}
+    for op in iter_ops(fn.body):
+        phi_ids = {phi.dest.id for phi in phis(op)}
+        ids.update(value.id for value in defined_values(op) if value.id not in phi_ids)
+    return ids
+
+
+def _undefined_phis(fn: Function) -> set[str]:
+    """Variables with a used phi that is undefined on some edge."""
+    used = used_values(fn)
+    return {
+        phi.dest.name
+        for op in iter_ops(fn.body)
+        for phi in phis(op)
+        if phi.dest.id in used and any(expr is None for expr in phi.incoming.values())
+    }
+
+
+# ---------------------------------------------------------------------------
+# Verification
+# ---------------------------------------------------------------------------
+
+
+def verify_ssa(module: Module) -> list[str]:
+    """Check the SSA invariants; returns a message for each violation.
+
+    Every Value must be defined exactly once, before it is read on every path
+    (so earlier in the same or an enclosing region, by the header of a loop
+    the read follows, or by a phi where regions merge), and every phi must
+    have exactly one incoming value per edge into it.
+    """
+    errors: list[str] = []
+    for fn in module.functions():
+        _Verifier(fn, errors).function()
+    return errors
+
+
+class _Verifier:
+    def __init__(self, fn: Function, errors: list[str]):
+        self.fn = fn
+        self.errors = errors
+        self.where = f"function '{fn.name}'" if fn.name is not None else "top level"
+        self.defined: set[int] = set()
+
+    def error(self, message: str) -> None:
+        self.errors.append(f"{self.where}: {message}")
+
+    def define(self, value: Value, visible: set[int]) -> None:
+        if value.id in self.defined:
+            self.error(f"value {value.name}#{value.id} is defined more than once")
+        self.defined.add(value.id)
+        if self.fn.values.get(value.id) is not value:
+            self.error(f"value {value.name}#{value.id} is not registered with its function")
+        if value.name in self.fn.memory:
+            self.error(f"value {value.name}#{value.id} versions memory variable '{value.name}'")
+        visible.add(value.id)
+
+    def check_reads(self, expr: Any, visible: set[int], context: str) -> None:
+        for node in value_reads(expr):
+            if node["ssa"] not in visible:
+                self.error(f"{context} reads {node.get('name')}#{node['ssa']} where it is not defined")
+
+    def check_phis(self, phi_list: list[Phi], edges: dict[str, set[int]], context: str) -> None:
+        for phi in phi_list:
+            if set(phi.incoming) != set(edges):
+                self.error(
+                    f"phi for {phi.dest.name}#{phi.dest.id} after {context} has edges "
+                    f"{sorted(phi.incoming)}, expected {sorted(edges)}"
+                )
+                continue
+            for edge, expr in phi.incoming.items():
+                self.check_reads(expr, edges[edge], f"phi for {phi.dest.name} ({edge} edge)")
+
+    def function(self) -> None:
+        visible: set[int] = set()
+        for value in self.fn.param_values.values():
+            self.define(value, visible)
+        self.region(self.fn.body, visible, loop=None)
+
+    def region(self, ops: list[Op], visible: set[int], loop: dict | None) -> set[int] | None:
+        """Check ops; returns the Values visible at the end, None if unreachable."""
+        visible = set(visible)
+        for i, op in enumerate(ops):
+            if isinstance(op, Jump):
+                if loop is None:
+                    self.error(f"{op.kind} outside a loop")
+                else:
+                    loop[op.edge] = visible
+                if i != len(ops) - 1:
+                    self.error(f"{op.kind} is followed by unreachable ops")
+                return None
+            result = self.op(op, visible, loop)
+            if result is None:
+                if i != len(ops) - 1:
+                    self.error("region continues after an op that never falls through")
+                return None
+            visible = result
+        return visible
+
+    def op(self, op: Op, visible: set[int], loop: dict | None) -> set[int] | None:
+        if not isinstance(op, Loop):
+            for expr in expressions(op):
+                self.check_reads(expr, visible, type(op).__name__)
+
+        if isinstance(op, (Define, Effect)):
+            if op.dest is not None:
+                self.define(op.dest, visible)
+            if isinstance(op, Effect) and op.node.get("type") in ("Return", "Throw"):
+                return None
+            return visible
+
+        if isinstance(op, (If, Switch)):
+            edges = {}
+            for edge, body in branches(op).items():
+                end = visible if body is None else self.region(body, visible, loop)
+                if end is not None:
+                    edges[edge] = end
+            self.check_phis(op.phis, edges, type(op).__name__)
+            if not edges:
+                if op.phis:
+                    self.error(f"{type(op).__name__} never falls through but has phis")
+                return None
+            # A value from the only branch that falls through is visible after it
+            after = set.intersection(*edges.values())
+            for phi in op.phis:
+                self.define(phi.dest, after)
+            return after
+
+        if isinstance(op, Loop):
+            if op.items is not None:
+                self.check_reads(op.items, visible, "loop items")
+            header_visible = set(visible)
+            for phi in op.header:
+                self.define(phi.dest, header_visible)
+            if op.test is not None:
+                self.check_reads(op.test, header_visible, "loop test")
+            body_visible = set(header_visible)
+            if op.var_value is not None:
+                self.define(op.var_value, body_visible)
+            jumps_to: dict[str, set[int]] = {}
+            end = self.region(op.body, body_visible, jumps_to)
+            back = {"entry": visible}
+            if end is not None:
+                back["end"] = end
+            back.update({e: v for e, v in jumps_to.items() if e.startswith("continue")})
+            self.check_phis(op.header, back, "loop header")
+            exits = {"exit": header_visible}
+            exits.update({e: v for e, v in jumps_to.items() if e.startswith("break")})
+            self.check_phis(op.exit, exits, "loop")
+            # The header is on every path out of the loop
+            after = set(header_visible)
+            for phi in op.exit:
+                self.define(phi.dest, after)
+            return after
+
+        if isinstance(op, Try):
+            for body in regions(op):
+                self.region(body, visible, loop)
+            return visible
+
+        if isinstance(op, FuncDef):
+            return visible
+
+        self.error(f"unknown op {type(op).__name__}")
+        return visible
+
+
+# ---------------------------------------------------------------------------
+# Printing
+# ---------------------------------------------------------------------------
+
+
+def format_ssa(module: Module) -> str:
+    """Render the SSA form as text, for debugging and tests."""
+    lines: list[str] = []
+    for fn in module.functions():
+        if fn.name is None:
+            lines.append("top level:")
+        else:
+            params = ", ".join(_format_value(v) for v in fn.param_values.values())
+            lines.append(f"function {fn.name}({params}):")
+        _format_region(fn.body, 1, lines)
+    return "\n".join(lines)
+
+
+def _format_value(value: Value) -> str:
+    return f"{value.name}#{value.id}"
+
+
+def _format_expr(expr: Any) -> str:
+    if expr is None:
+        return "undefined"
+    if is_coreil_node(expr):
+        if expr["type"] == "Var":
+            return f"{expr['name']}#{expr['ssa']}" if "ssa" in expr else expr["name"]
+        if expr["type"] == "Literal":
+            return repr(expr.get("value"))
+        if expr["type"] == "Binary":
+            return f"({_format_expr(expr.get('left'))} {expr.get('op')} {_format_expr(expr.get('right'))})"
+        args = [
+            _format_expr(child) for key, child in expr.items()
+            if key != "type" and (is_coreil_node(child) or isinstance(child, list))
+        ]
+        return f"{expr['type']}({', '.join(args)})"
+    if isinstance(expr, list):
+        return "[" + ", ".join(_format_expr(item) for item in expr) + "]"
+    return repr(expr)
+
+
+def _format_phis(phi_list: list[Phi], indent: str, lines: list[str]) -> None:
+    for phi in phi_list:
+        incoming = ", ".join(f"{edge}: {_format_expr(expr)}" for edge, expr in phi.incoming.items())
+        lines.append(f"{indent}{_format_value(phi.dest)} = phi({incoming})")
+
+
+def _format_region(ops: list[Op], depth: int, lines: list[str]) -> None:
+    indent = "  " * depth
+    for op in ops:
+        if isinstance(op, Define):
+            lines.append(f"{indent}{_format_value(op.dest)} = {_format_expr(op.value)}")
+        elif isinstance(op, Effect):
+            prefix = f"{_format_value(op.dest)} = " if op.dest is not None else ""
+            lines.append(f"{indent}{prefix}{_format_expr(op.node)}")
+        elif isinstance(op, Jump):
+            lines.append(f"{indent}{op.kind.lower()} ({op.edge})")
+        elif isinstance(op, If):
+            lines.append(f"{indent}if {_format_expr(op.test)}:")
+            _format_region(op.then, depth + 1, lines)
+            if op.orelse is not None:
+                lines.append(f"{indent}else:")
+                _format_region(op.orelse, depth + 1, lines)
+            _format_phis(op.phis, indent, lines)
+        elif isinstance(op, Switch):
+            lines.append(f"{indent}switch {_format_expr(op.test)}:")
+            for value, body in op.cases:
+                lines.append(f"{indent}case {_format_expr(value)}:")
+                _format_region(body, depth + 1, lines)
+            if op.default is not None:
+                lines.append(f"{indent}default:")
+                _format_region(op.default, depth + 1, lines)
+            _format_phis(op.phis, indent, lines)
+        elif isinstance(op, Loop):
+            if op.kind == "While":
+                head = f"while {_format_expr(op.test)}"
+            else:
+                var = _format_value(op.var_value) if op.var_value is not None else op.var
+                head = f"{op.kind.lower()} {var} in {_format_expr(op.items)}"
+            lines.append(f"{indent}loop:")
+            _format_phis(op.header, indent + "  ", lines)
+            lines.append(f"{indent}  {head}:")
+            _format_region(op.body, depth + 2, lines)
+            _format_phis(op.exit, indent, lines)
+        elif isinstance(op, Try):
+            lines.append(f"{indent}try:")
+            _format_region(op.body, depth + 1, lines)
+            lines.append(f"{indent}catch {op.node.get('catch_var')}:")
+            _format_region(op.catch_body, depth + 1, lines)
+            if op.finally_body is not None:
+                lines.append(f"{indent}finally:")
+                _format_region(op.finally_body, depth + 1, lines)
+        elif isinstance(op, FuncDef):
+            lines.append(f"{indent}def {op.function.name}")
@@ -0,0 +1,414 @@
+"""Conversion from SSA form back to Core IL.
+
+lower_ssa() turns each Value back into a variable. Values keep the name of
+the variable they version, so a program that no pass changed comes back as
+it was written. Where a pass left two versions of a variable alive at once
+(copy propagation, say), one of them is renamed apart first: the lowering
+tracks which Value each variable holds at every point, and renames any
+Value read while its variable holds another.
+
+Phis become copies on their incoming edges:
+- at the end of each If/Switch branch that falls through
+- before a loop, at the end of its body and before each Continue for a
+  header phi
+- before each Break for an exit phi, whose value on the "exit" edge is
+  either the header phi it shares a variable with or is copied in before
+  the loop
+
+Copies on one edge happen together, so they are ordered (through a
+temporary where they form a cycle) so that none overwrites a variable
+another still reads.
+
+Usage:
+    from english_compiler.coreil.ssa_lower import lower_ssa
+
+    doc = lower_ssa(module)
+"""
+
+from __future__ import annotations
+
+from copy import deepcopy
+from typing import Any
+
+from .node_nav import is_coreil_node
+from .ssa import (
+    Define,
+    Effect,
+    FuncDef,
+    Function,
+    If,
+    Jump,
+    Loop,
+    Module,
+    Op,
+    Phi,
+    Switch,
+    Try,
+    branches,
+    defined_values,
+    falls_through,
+    iter_ops,
+    regions,
+    value_reads,
+)
+
+_NONE = {"type": "Literal", "value": None}
+
+
+def lower_ssa(module: Module) -> dict:
+    """Convert a module back to a Core IL document."""
+    doc = deepcopy(module.doc)
+    taken = set(module.names)
+    body, origins = _Lowerer(module.main, taken).function()
+    doc["body"] = body
+    source_map = doc.get("source_map")
+    if isinstance(source_map, dict):
+        # Statements keep the sentence of the top-level statement they came from
+        doc["source_map"] = {}
+        for line, indices in source_map.items():
+            wanted = set(indices) if isinstance(indices, list) else set()
+            mapped = [j for j, origin in enumerate(origins) if origin in wanted]
+            if mapped:
+                doc["source_map"][line] = mapped
+    return doc
+
+
+class _Lowerer:
+    def __init__(self, fn: Function, taken: set[str]):
+        self.fn = fn
+        self.taken = taken
+        self.names = {value_id: value.name for value_id, value in fn.values.items()}
+        # Exit phis share the variable of the header phi they leave with
+        self.leader: dict[int, int] = {}
+        for op in iter_ops(fn.body):
+            if isinstance(op, Loop):
+                header = {phi.dest.id: phi.dest for phi in op.header}
+                for phi in op.exit:
+                    source = _single_read(phi.incoming.get("exit"))
+                    if source not in header:
+                        continue  # copied in before the loop
+                    if header[source].name != phi.dest.name:
+                        raise ValueError(
+                            f"exit phi for {phi.dest.name} leaves with header value "
+                            f"{header[source].name}#{source}"
+                        )
+                    self.leader[phi.dest.id] = source
+
+    def name(self, value_id: int) -> str:
+        return self.names[self.leader.get(value_id, value_id)]
+
+    def rename_apart(self, value_id: int) -> None:
+        value_id = self.leader.get(value_id, value_id)
+        base = self.names[value_id]
+        n = 1
+        while f"{base}_{n}" in self.taken:
+            n += 1
+        self.names[value_id] = f"{base}_{n}"
+        self.taken.add(f"{base}_{n}")
+
+    # -- naming ------------------------------------------------------------
+
+    def function(self) -> tuple[list, list[int | None]]:
+        """Lower the function body; returns it and each statement's origin."""
+        while True:
+            self.conflicts: set[int] = set()
+            occupant = {self.name(v.id): v.id for v in self.fn.param_values.values()}
+            self.simulate(self.fn.body, occupant, [])
+            if not self.conflicts:
+                break
+            for value_id in self.conflicts:
+                self.rename_apart(value_id)
+        scopes = [set(self.fn.params)]
+        body: list = []
+        origins: list[int | None] = []
+        for op in self.fn.body:
+            stmts = self.op(op, scopes, [])
+            body.extend(stmts)
+            origins.extend([op.origin] * len(stmts))
+        return body, origins
+
+    def check(self, expr: Any, occupant: dict[str, int | None]) -> None:
+        for node in value_reads(expr):
+            if occupant.get(self.name(node["ssa"])) != node["ssa"]:
+                self.conflicts.add(node["ssa"])
+
+    def edge(self, phi_list: list[Phi], edge: str, occupant: dict) -> None:
+        """Check and apply the copies for edge into phi_list."""
+        for phi in phi_list:
+            self.check(phi.incoming.get(edge), occupant)
+        for phi in phi_list:
+            occupant[self.name(phi.dest.id)] = phi.dest.id
+
+    def simulate(self, ops: list[Op], occupant: dict, loops: list[dict]) -> dict | None:
+        """Track which Value each variable holds through ops, noting conflicts.
+
+        loops collects the occupants at each Break/Continue, per enclosing
+        loop. Returns the occupants at the end, None if it is unreachable.
+        """
+        occupant = dict(occupant)
+        for op in ops:
+            if isinstance(op, Jump):
+                loops[-1][op.edge] = dict(occupant)
+                return None
+            if isinstance(op, (Define, Effect)):
+                self.check(op.value if isinstance(op, Define) else op.node, occupant)
+                if op.dest is not None:
+                    occupant[self.name(op.dest.id)] = op.dest.id
+                if isinstance(op, Effect) and op.node.get("type") in ("Return", "Throw"):
+                    return None
+            elif isinstance(op, (If, Switch)):
+                self.check(op.test, occupant)
+                ends = {}
+                for edge, body in branches(op).items():
+                    if isinstance(op, Switch) and edge.startswith("case"):
+                        self.check(op.cases[int(edge[4:])][0], occupant)
+                    end = self.simulate(body or [], occupant, loops)
+                    if end is not None:
+                        self.edge(op.phis, edge, end)
+                        ends[edge] = end
+                if not ends:
+                    return None
+                occupant = _meet(ends.values())
+            elif isinstance(op, Loop):
+                occupant = self.simulate_loop(op, occupant, loops)
+            elif isinstance(op, Try):
+                ends = [self.simulate(body, occupant, loops) or occupant for body in regions(op)]
+                occupant = _meet([occupant] + ends)
+        return occupant
+
+    def simulate_loop(self, op: Loop, occupant: dict, loops: list[dict]) -> dict:
+        if op.items is not None:
+            self.check(op.items, occupant)
+        occupant = dict(occupant)
+        self.edge(self.entry_phis(op), "entry", occupant)
+        early = [phi for phi in op.exit if phi.dest.id not in self.leader]
+        # Anything the loop writes may hold another Value on a later iteration
+        header = dict(occupant)
+        written = self.written(op)
+        for name in written:
+            header[name] = None
+        for phi in op.header:
+            header[self.name(phi.dest.id)] = phi.dest.id
+        for phi in early:
+            if self.name(phi.dest.id) in written:
+                self.conflicts.add(phi.dest.id)
+        if op.test is not None:
+            self.check(op.test, header)
+        body = dict(header)
+        if op.var_value is not None:
+            body[self.name(op.var_value.id)] = op.var_value.id
+        jumps: dict[str, dict] = {}
+        end = self.simulate(op.body, body, loops + [jumps])
+        if end is not None:
+            jumps["end"] = end
+        exits = {"exit": header}
+        for edge, at in jumps.items():
+            if edge.startswith("break"):
+                self.edge(op.exit, edge, at)
+                exits[edge] = at
+            else:
+                self.edge(op.header, edge, at)
+        for phi in op.exit:
+            header[self.name(phi.dest.id)] = phi.dest.id
+        return _meet(exits.values())
+
+    def written(self, loop: Loop) -> set[str]:
+        """Variables the loop's body or header copies write."""
+        values = [phi.dest for phi in loop.header]
+        if loop.var_value is not None:
+            values.append(loop.var_value)
+        for op in iter_ops(loop.body):
+            values.extend(defined_values(op))
+        return {self.name(value.id) for value in values}
+
+    # -- emission ----------------------------------------------------------
+
+    def expr(self, expr: Any) -> Any:
+        if isinstance(expr, list):
+            return [self.expr(item) for item in expr]
+        if not isinstance(expr, dict):
+            return expr
+        if expr.get("type") == "Var" and "ssa" in expr:
+            return {"type": "Var", "name": self.name(expr["ssa"])}
+        return {key: self.expr(child) for key, child in expr.items()}
+
+    def write(self, name: str, value: Any, scopes: list[set[str]]) -> dict:
+        """A Let, or an Assign where name is already declared in scope."""
+        if any(name in scope for scope in scopes):
+            return {"type": "Assign", "name": name, "value": value}
+        scopes[-1].add(name)
+        return {"type": "Let", "name": name, "value": value}
+
+    def declare(self, phi_list: list[Phi], scopes: list[set[str]]) -> list:
+        """Declare the variables of phis that are not yet in scope."""
+        return [
+            self.write(self.name(phi.dest.id), dict(_NONE), scopes)
+            for phi in phi_list
+            if not any(self.name(phi.dest.id) in scope for scope in scopes)
+        ]
+
+    def copies(self, phi_list: list[Phi], edge: str, scopes: list[set[str]]) -> list:
+        """Statements performing the copies of an edge into phi_list together."""
+        pending = []
+        for phi in phi_list:
+            source = phi.incoming.get(edge)
+            dest = self.name(phi.dest.id)
+            read_id = _single_read(source)
+            if source is None or (read_id is not None and self.name(read_id) == dest):
+                continue
+            pending.append((dest, self.expr(source)))
+        stmts = []
+        while pending:
+            for i, (dest, source) in enumerate(pending):
+                if not any(_reads_name(other, dest) for j, (_, other) in enumerate(pending) if j != i):
+                    stmts.append(self.write(dest, source, scopes))
+                    del pending[i]
+                    break
+            else:
+                # A cycle: save one variable so its copy can go first
+                dest = pending[0][0]
+                temp = dest
+                n = 1
+                while temp in self.taken:
+                    temp = f"{dest}_{n}"
+                    n += 1
+                self.taken.add(temp)
+                stmts.append(self.write(temp, {"type": "Var", "name": dest}, scopes))
+                pending = [(d, _replace_name(s, dest, temp)) for d, s in pending]
+        return stmts
+
+    def block(self, ops: list[Op], scopes: list[set[str]], loops: list[Loop]) -> list:
+        scopes = scopes + [set()]
+        return [stmt for op in ops for stmt in self.op(op, scopes, loops)]
+
+    def branch(self, ops: list[Op] | None, phi_list: list[Phi], edge: str,
+               scopes: list[set[str]], loops: list[Loop]) -> list:
+        inner = scopes + [set()]
+        stmts = [stmt for op in ops or [] for stmt in self.op(op, inner, loops)]
+        if falls_through(ops or []):
+            stmts.extend(self.copies(phi_list, edge, inner))
+        return stmts
+
+    def op(self, op: Op, scopes: list[set[str]], loops: list[Loop]) -> list:
+        if isinstance(op, Define):
+            return [self.write(self.name(op.dest.id), self.expr(op.value), scopes)]
+
+        if isinstance(op, Effect):
+            node = self.expr(op.node)
+            if op.dest is not None:
+                node["target"] = self.name(op.dest.id)
+                scopes[-1].add(node["target"])
+            return [node]
+
+        if isinstance(op, Jump):
+            loop = loops[-1]
+            target = loop.exit if op.kind == "Break" else loop.header
+            return self.copies(target, op.edge, scopes) + [{"type": op.kind}]
+
+        if isinstance(op, If):
+            stmts = self.declare(op.phis, scopes)
+            node = {
+                "type": "If",
+                "test": self.expr(op.test),
+                "then": self.branch(op.then, op.phis, "then", scopes, loops),
+            }
+            orelse = self.branch(op.orelse, op.phis, "else", scopes, loops)
+            if op.orelse is not None or orelse:
+                node["else"] = orelse
+            return stmts + [node]
+
+        if isinstance(op, Switch):
+            stmts = self.declare(op.phis, scopes)
+            node = {"type": "Switch", "test": self.expr(op.test), "cases": [
+                {"value": self.expr(value), "body": self.branch(body, op.phis, f"case{i}", scopes, loops)}
+                for i, (value, body) in enumerate(op.cases)
+            ]}
+            default = self.branch(op.default, op.phis, "default", scopes, loops)
+            if op.default is not None or default:
+                node["default"] = default
+            return stmts + [node]
+
+        if isinstance(op, Loop):
+            return self.loop(op, scopes, loops)
+
+        if isinstance(op, Try):
+            node = dict(op.node)
+            node["body"] = self.block(op.body, scopes, loops)
+            node["catch_body"] = self.block(op.catch_body, scopes, loops)
+            if op.finally_body is not None:
+                node["finally_body"] = self.block(op.finally_body, scopes, loops)
+            return [node]
+
+        if isinstance(op, FuncDef):
+            body, _ = _Lowerer(op.function, self.taken).function()
+            return [{**op.node, "body": body}]
+
+        raise ValueError(f"unknown SSA op {type(op).__name__}")
+
+    def loop(self, op: Loop, scopes: list[set[str]], loops: list[Loop]) -> list:
+        stmts = self.copies(self.entry_phis(op), "entry", scopes)
+        stmts += self.declare(op.exit, scopes)
+        inner = scopes + [set()]
+        node: dict[str, Any] = {"type": op.kind}
+        if op.kind == "While":
+            node["test"] = self.expr(op.test)
+        else:
+            var = self.name(op.var_value.id) if op.var_value is not None else op.var
+            node["var"] = var
+            node["iter"] = self.expr(op.items)
+            inner[-1].add(var)
+        body = [stmt for child in op.body for stmt in self.op(child, inner, loops + [op])]
+        if falls_through(op.body):
+            body.extend(self.copies(op.header, "end", inner))
+        node["body"] = body
+        return stmts + [node]
+
+    def entry_phis(self, op: Loop) -> list[Phi]:
+        """The phis copied into before the loop, all on an "entry" edge.
+
+        These are the header phis, and the exit phis whose value when the
+        loop ends normally is already known before it starts.
+        """
+        phi_list = [Phi(phi.dest, {"entry": phi.incoming.get("entry")}) for phi in op.header]
+        phi_list += [
+            Phi(phi.dest, {"entry": phi.incoming.get("exit")})
+            for phi in op.exit if phi.dest.id not in self.leader
+        ]
+        return phi_list
+
+
+def _meet(occupants) -> dict:
+    """Occupants agreed on by every one of several merging paths."""
+    occupants = list(occupants)
+    names = {name for occupant in occupants for name in occupant}
+    return {
+        name: occupants[0].get(name)
+        if all(occupant.get(name) == occupants[0].get(name) for occupant in occupants) else None
+        for name in names
+    }
+
+
+def _single_read(expr: Any) -> int | None:
+    if is_coreil_node(expr) and expr["type"] == "Var" and "ssa" in expr:
+        return expr["ssa"]
+    return None
+
+
+def _reads_name(expr: Any, name: str) -> bool:
+    if isinstance(expr, list):
+        return any(_reads_name(item, name) for item in expr)
+    if not isinstance(expr, dict):
+        return False
+    if expr.get("type") == "Var" and expr.get("name") == name:
+        return True
+    return any(_reads_name(child, name) for child in expr.values())
+
+
+def _replace_name(expr: Any, name: str, replacement: str) -> Any:
+    if isinstance(expr, list):
+        return [_replace_name(item, name, replacement) for item in expr]
+    if not isinstance(expr, dict):
+        return expr
+    if expr.get("type") == "Var" and expr.get("name") == name:
+        return {"type": "Var", "name": replacement}
+    return {key: _replace_name(child, name, replacement) for key, child in expr.items()}
@@ -0,0 +1,267 @@
+"""Optimization passes on the SSA form.
+
+Each pass transforms a Module from build_ssa() in place:
+
+- propagate_constants(): replaces each read of a Value defined as a literal,
+  or as a copy of another Value, with that literal or Value, and folds the
+  constant expressions this exposes (fold_expr() in optimize.py). A phi
+  whose incoming values all agree is replaced by that value. An If whose
+  test becomes a literal is replaced by the branch it takes, when that
+  branch falls through, and a While whose test becomes false never runs.
+- eliminate_dead_values(): removes Defines and phis whose Value nothing
+  reads, when computing it can neither fail nor have side effects.
+
+Unlike the Core IL passes, these see every assignment of a variable
+separately, so a variable reassigned in a branch or loop is still
+propagated wherever a single value reaches.
+
+Usage:
+    from english_compiler.coreil.ssa import build_ssa
+    from english_compiler.coreil.ssa_lower import lower_ssa
+    from english_compiler.coreil.ssa_opt import eliminate_dead_values, propagate_constants
+
+    module = build_ssa(doc)
+    propagate_constants(module)
+    eliminate_dead_values(module)
+    doc = lower_ssa(module)
+"""
+
+from __future__ import annotations
+
+from typing import Any
+
+from .node_nav import expression_keys, is_coreil_node, iter_nodes
+from .optimize import fold_expr
+from .ssa import (
+    Define,
+    Effect,
+    Function,
+    If,
+    Loop,
+    Module,
+    Op,
+    Phi,
+    Switch,
+    defined_values,
+    falls_through,
+    iter_ops,
+    jumps,
+    phis,
+    prune_phis,
+    regions,
+    used_values,
+)
+
+
+def propagate_constants(module: Module) -> None:
+    """Propagate literals and copies through every function of module."""
+    for fn in module.functions():
+        while _propagate(fn):
+            pass
+        _forget_removed(fn)
+
+
+def eliminate_dead_values(module: Module) -> None:
+    """Remove unused Values whose computation is safe to skip."""
+    for fn in module.functions():
+        # Removing one Define can leave the Values it read unused
+        while True:
+            used = used_values(fn)
+            removed = _remove_ops(fn.body, lambda op: (
+                isinstance(op, Define) and op.dest.id not in used and _is_safe(op.value)
+            ))
+            if not removed:
+                break
+        prune_phis(fn)
+
+
+# ---------------------------------------------------------------------------
+# Propagation
+# ---------------------------------------------------------------------------
+
+
+def _propagate(fn: Function) -> bool:
+    """One round of substitution, folding and branch removal; True on change."""
+    replacements: dict[int, Any] = {}
+    for op in iter_ops(fn.body):
+        if isinstance(op, Define) and _value_key(op.value) is not None:
+            replacements[op.dest.id] = op.value
+        for phi in phis(op):
+            agreed = _agreed_value(phi)
+            if agreed is not None:
+                replacements[phi.dest.id] = agreed
+    changed = False
+
+    def substitute(expr: Any) -> Any:
+        if isinstance(expr, list):
+            return [substitute(item) for item in expr]
+        if not isinstance(expr, dict):
+            return expr
+        if expr.get("type") == "Var" and expr.get("ssa") in replacements:
+            return substitute(replacements[expr["ssa"]])
+        return {key: substitute(child) for key, child in expr.items()}
+
+    def rewrite(expr: Any) -> Any:
+        nonlocal changed
+        result = fold_expr(substitute(expr))
+        changed = changed or result != expr
+        return result
+
+    for op in iter_ops(fn.body):
+        _rewrite(op, rewrite)
+        for phi in phis(op):
+            phi.incoming = {edge: substitute(expr) for edge, expr in phi.incoming.items()}
+        # Defines stay until eliminate_dead_values(); replaced phis go now
+        if isinstance(op, (If, Switch)):
+            changed = _drop_phis(op.phis, replacements) or changed
+        elif isinstance(op, Loop):
+            changed = _drop_phis(op.header, replacements) or changed
+            changed = _drop_phis(op.exit, replacements) or changed
+    return _fold_regions(fn.body, None) or changed
+
+
+def _drop_phis(phi_list: list[Phi], replacements: dict[int, Any]) -> bool:
+    kept = [phi for phi in phi_list if phi.dest.id not in replacements]
+    removed = len(kept) != len(phi_list)
+    phi_list[:] = kept
+    return removed
+
+
+def _value_key(expr: Any) -> tuple | None:
+    """A key equal for two expressions that always give the same value.
+
+    Only literals and Value reads have one.
+    """
+    if not is_coreil_node(expr):
+        return None
+    if expr["type"] == "Var" and "ssa" in expr:
+        return ("value", expr["ssa"])
+    if expr["type"] == "Literal":
+        value = expr.get("value")
+        if value is None or isinstance(value, (bool, int, float, str)):
+            return ("literal", type(value).__name__, value)
+    return None
+
+
+def _agreed_value(phi: Phi) -> Any:
+    """The value every edge brings into phi (ignoring loops back to itself)."""
+    incoming = [
+        expr for expr in phi.incoming.values()
+        if _value_key(expr) != ("value", phi.dest.id)
+    ]
+    if not incoming:
+        return None
+    keys = {_value_key(expr) for expr in incoming}
+    if len(keys) != 1 or None in keys:
+        return None
+    return incoming[0]
+
+
+def _rewrite(op: Op, rewrite) -> None:
+    """Apply rewrite to each expression op evaluates itself."""
+    if isinstance(op, Define):
+        op.value = rewrite(op.value)
+    elif isinstance(op, Effect):
+        for key in expression_keys(op.node):
+            value = op.node[key]
+            op.node[key] = [rewrite(item) for item in value] if isinstance(value, list) else rewrite(value)
+    elif isinstance(op, (If, Switch)):
+        op.test = rewrite(op.test)
+        if isinstance(op, Switch):
+            op.cases = [(rewrite(value), body) for value, body in op.cases]
+    elif isinstance(op, Loop):
+        if op.test is not None:
+            op.test = rewrite(op.test)
+        if op.items is not None:
+            op.items = rewrite(op.items)
+
+
+def _fold_regions(ops: list[Op], loop: Loop | None) -> bool:
+    """Replace Ifs and Whiles with literal tests; True on change."""
+    changed = False
+    i = 0
+    while i < len(ops):
+        op = ops[i]
+        replacement = _fold_branch(op, loop)
+        if replacement is not None:
+            ops[i:i + 1] = replacement
+            changed = True
+            continue
+        inner_loop = op if isinstance(op, Loop) else loop
+        for region in regions(op):
+            changed = _fold_regions(region, inner_loop) or changed
+        i += 1
+    return changed
+
+
+def _fold_branch(op: Op, loop: Loop | None) -> list[Op] | None:
+    """The ops replacing op when its test is a literal, else None."""
+    if isinstance(op, If) and _is_literal(op.test):
+        if op.test.get("value"):
+            edge, taken, dropped = "then", op.then, op.orelse
+        else:
+            edge, taken, dropped = "else", op.orelse or [], op.then
+        if not falls_through(taken) or any(phi.incoming.get(edge) is None for phi in op.phis):
+            return None
+        if loop is not None:
+            _drop_jumps(dropped or [], loop)
+        return taken + [Define(phi.dest, phi.incoming[edge], origin=op.origin) for phi in op.phis]
+
+    if isinstance(op, Loop) and op.kind == "While" and _is_literal(op.test) and not op.test.get("value"):
+        entry = {phi.dest.id: phi.incoming.get("entry") for phi in op.header}
+        if any(value is None for value in entry.values()):
+            return None
+        # The test fails on entry, so each header phi has its entry value
+        ops: list[Op] = [Define(phi.dest, entry[phi.dest.id], origin=op.origin) for phi in op.header]
+        ops += [Define(phi.dest, phi.incoming.get("exit"), origin=op.origin) for phi in op.exit]
+        return ops
+    return None
+
+
+def _drop_jumps(ops: list[Op], loop: Loop) -> None:
+    """Remove the phi edges of the Breaks and Continues in removed ops."""
+    edges = {jump.edge for kind in ("Break", "Continue") for jump in jumps(ops, kind)}
+    for phi in loop.header + loop.exit:
+        for edge in edges:
+            phi.incoming.pop(edge, None)
+
+
+def _forget_removed(fn: Function) -> None:
+    """Unregister Values whose definitions were removed."""
+    defined = {value.id for value in fn.param_values.values()}
+    for op in iter_ops(fn.body):
+        defined.update(value.id for value in defined_values(op))
+    for value_id in set(fn.values) - defined:
+        del fn.values[value_id]
+
+
+# ---------------------------------------------------------------------------
+# Dead values
+# ---------------------------------------------------------------------------
+
+
+def _remove_ops(ops: list[Op], remove) -> bool:
+    """Remove the ops (at any depth) remove accepts; True if any were."""
+    kept = [op for op in ops if not remove(op)]
+    removed = len(kept) != len(ops)
+    ops[:] = kept
+    for op in ops:
+        for region in regions(op):
+            removed = _remove_ops(region, remove) or removed
+    return removed
+
+
+def _is_safe(expr: Any) -> bool:
+    """Whether evaluating expr can neither fail nor have side effects.
+
+    Memory variables are read by name and can be undefined.
+    """
+    return all(
+        node["type"] in ("Literal", "MathConst", "Array", "Tuple")
+        or (node["type"] == "Var" and "ssa" in node)
+        for node in iter_nodes(expr)
+    )
+
+
+def _is_literal(expr: Any) -> bool:
+    return is_coreil_node(expr) and expr["type"] == "Literal"
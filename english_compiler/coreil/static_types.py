@@ -0,0 +1,309 @@
+"""Static type inference for Core IL.
+
+infer_types() finds the variables that always hold a value of one primitive
+type, so a backend can store them unboxed instead of as dynamic values. The
+types are:
+
+- INT, FLOAT, STR and BOOL: Python's int, float, str and bool
+- None: dynamic, any value
+
+The analysis is flow-based and runs on the SSA form (see ssa.py). Each
+Value gets the type of the expression that defines it, and a phi the type
+its incoming values agree on, iterating to a fixed point so a loop counter
+keeps its type around the loop. A variable is typed when all of its Values
+have the same type; parameters, memory variables, ForEach variables and
+the targets of PopFront, PopBack and HeapPop are always dynamic.
+
+expr_type() gives the type of an expression from the types of the
+variables it reads. Most operations on dynamic operands are dynamic, but
+some always give one type: comparisons, and/or and Not give a bool, and
+ToInt an int.
+
+check_types() uses the inferred types as a type checker: it reports every
+Binary whose operands have types the operator always rejects at runtime,
+such as a string minus a number. Dynamic operands are never reported.
+
+Usage:
+    from english_compiler.coreil.static_types import expr_type, infer_types
+
+    types = infer_types(doc)
+    var_types = types.get(None, {})  # the top level; functions by name
+    expr_type(expr, lambda var: var_types.get(var["name"]))
+
+    for diagnostic in check_types(doc):
+        print(diagnostic["path"], diagnostic["message"])
+"""
+
+from __future__ import annotations
+
+from collections.abc import Callable
+from typing import Any
+
+from .node_nav import is_coreil_node, iter_nodes
+from .ssa import Define, Effect, Function, Loop, build_ssa, iter_ops, phis
+
+INT = "int"
+FLOAT = "float"
+STR = "str"
+BOOL = "bool"
+
+NUMERIC = (INT, FLOAT)
+
+# Expression type -> the type of its result, wherever it does not depend on
+# the operands
+RESULT_TYPES: dict[str, str] = {
+    "Not": BOOL,
+    "Length": INT,
+    "StringLength": INT,
+    "SetSize": INT,
+    "ToInt": INT,
+    "ToFloat": FLOAT,
+    "ToString": STR,
+    "StringFormat": STR,
+    "Substring": STR,
+    "CharAt": STR,
+    "Join": STR,
+    "StringTrim": STR,
+    "StringUpper": STR,
+    "StringLower": STR,
+    "StringReplace": STR,
+    "StringStartsWith": BOOL,
+    "StringEndsWith": BOOL,
+    "StringContains": BOOL,
+    "SetHas": BOOL,
+    "MathPow": FLOAT,
+    "MathConst": FLOAT,
+}
+
+COMPARISONS = ("==", "!=", "<", "<=", ">", ">=")
+
+# Operand types a Binary op always rejects, as (left types, right types)
+# pairs; bool is a number here, as in the reference interpreter
+_NUMBER_LIKE = (INT, FLOAT, BOOL)
+_OPERAND_ERRORS: dict[str, list[tuple[tuple[str, ...], tuple[str, ...]]]] = {
+    "+": [((STR,), _NUMBER_LIKE), (_NUMBER_LIKE, (STR,))],
+    "-": [((STR,), (INT, FLOAT, BOOL, STR)), (_NUMBER_LIKE, (STR,))],
+    "/": [((STR,), (INT, FLOAT, BOOL, STR)), (_NUMBER_LIKE, (STR,))],
+    "*": [((STR,), (FLOAT, STR)), ((FLOAT,), (STR,))],
+    "%": [(_NUMBER_LIKE, (STR,))],
+    "<": [((STR,), _NUMBER_LIKE), (_NUMBER_LIKE, (STR,))],
+    "<=": [((STR,), _NUMBER_LIKE), (_NUMBER_LIKE, (STR,))],
+    ">": [((STR,), _NUMBER_LIKE), (_NUMBER_LIKE, (STR,))],
+    ">=": [((STR,), _NUMBER_LIKE), (_NUMBER_LIKE, (STR,))],
+}
+
+_MATH_FLOAT = ("sin", "cos", "tan", "sqrt", "log", "exp", "asin", "acos", "atan", "log2", "log10", "sinh", "cosh", "tanh")
+_MATH_INT = ("floor", "ceil", "trunc")
+
+VarType = Callable[[dict], "str | None"]
+
+
+def literal_type(value: Any) -> str | None:
+    if isinstance(value, bool):
+        return BOOL
+    if isinstance(value, int):
+        return INT
+    if isinstance(value, float):
+        return FLOAT
+    if isinstance(value, str):
+        return STR
+    return None
+
+
+def expr_type(expr: Any, var_type: VarType) -> str | None:
+    """The type of expr's value, or None if it is dynamic.
+
+    var_type gives the type of the variable a Var node reads.
+    """
+    if not is_coreil_node(expr):
+        return None
+    node_type = expr["type"]
+    if node_type == "Literal":
+        return literal_type(expr.get("value"))
+    if node_type == "Var":
+        return var_type(expr)
+    if node_type in RESULT_TYPES:
+        return RESULT_TYPES[node_type]
+    if node_type == "Binary":
+        op = expr.get("op")
+        if op in COMPARISONS or op in ("and", "or"):
+            return BOOL
+        left = expr_type(expr.get("left"), var_type)
+        right = expr_type(expr.get("right"), var_type)
+        return binary_type(op, left, right)
+    if node_type == "Ternary":
+        consequent = expr_type(expr.get("consequent"), var_type)
+        alternate = expr_type(expr.get("alternate"), var_type)
+        return consequent if consequent == alternate else None
+    if node_type == "Math":
+        op = expr.get("op")
+        if op in _MATH_FLOAT:
+            return FLOAT
+        if op in _MATH_INT:
+            return INT
+        if op == "round":
+            # round(x, ndigits) keeps the argument's type
+            return INT if expr.get("ndigits") is None else None
+        if op == "abs":
+            arg = expr_type(expr.get("arg"), var_type)
+            return arg if arg in NUMERIC else None
+    return None
+
+
+def binary_type(op: str, left: str | None, right: str | None) -> str | None:
+    """The type of an arithmetic Binary on operands of the given types."""
+    if op == "+" and left == STR and right == STR:
+        return STR
+    if left not in NUMERIC or right not in NUMERIC:
+        return None
+    if op == "/":
+        return FLOAT
+    if op in ("+", "-", "*", "%"):
+        return INT if left == right == INT else FLOAT
+    return None
+
+
+def check_types(doc: dict) -> list[dict]:
+    """Every Binary whose operand types always fail, as verifier violations.
+
+    Each is a dict with keys message and path (the JSON path to the
+    Binary), like verify_coreil()'s.
+    """
+    types = infer_types(doc)
+    defined: dict[str, int] = {}
+    for node in iter_nodes(doc.get("body")):
+        if node["type"] == "FuncDef":
+            defined[node.get("name")] = defined.get(node.get("name"), 0) + 1
+
+    violations: list[dict] = []
+
+    def check(value: Any, path: str, var_types: dict[str, str]) -> None:
+        if isinstance(value, list):
+            for i, child in enumerate(value):
+                check(child, f"{path}[{i}]", var_types)
+            return
+        if not isinstance(value, dict):
+            return
+        if is_coreil_node(value):
+            if value["type"] == "FuncDef":
+                # A function defined twice has the types of only one of them
+                name = value.get("name")
+                var_types = types.get(name, {}) if defined.get(name) == 1 else {}
+            elif value["type"] == "Binary":
+                message = _operand_error(value, lambda var: var_types.get(var.get("name")))
+                if message:
+                    violations.append({"message": message, "path": path})
+        for key, child in value.items():
+            check(child, f"{path}.{key}", var_types)
+
+    check(doc.get("body"), "$.body", types.get(None, {}))
+    return violations
+
+
+def infer_types(doc: dict) -> dict[str | None, dict[str, str]]:
+    """Function name (None for the top level) -> variable -> type.
+
+    Only the variables with a type are listed.
+    """
+    # Statements after a Break, Continue, Return or Throw have no SSA form,
+    # so leave out variables they could give a dynamic value
+    dynamic = _dynamic_targets(doc.get("body"))
+    return {
+        fn.name: {name: t for name, t in _function_types(fn).items() if name not in dynamic}
+        for fn in build_ssa(doc).functions()
+    }
+
+
+# ---------------------------------------------------------------------------
+# Inference
+# ---------------------------------------------------------------------------
+
+# A Value not reached yet: every Value starts here, and one still here at the
+# end is dynamic
+_UNKNOWN = "unknown"
+
+
+def _operand_error(expr: dict, var_type: VarType) -> str | None:
+    op = expr.get("op")
+    left = expr_type(expr.get("left"), var_type)
+    right = expr_type(expr.get("right"), var_type)
+    for lefts, rights in _OPERAND_ERRORS.get(op, []):
+        if left in lefts and right in rights:
+            return f"operator '{op}' cannot be applied to {left} and {right}"
+    return None
+
+
+def _dynamic_targets(body: Any) -> set[str]:
+    """Variables assigned anywhere other than by Let, Assign or a counted For."""
+    names: set[str] = set()
+    for node in iter_nodes(body):
+        if node["type"] in ("PopFront", "PopBack", "HeapPop"):
+            names.add(node.get("target"))
+        elif node["type"] == "ForEach" or (
+            node["type"] == "For" and not (is_coreil_node(node.get("iter")) and node["iter"]["type"] == "Range")
+        ):
+            names.add(node.get("var"))
+    return names
+
+
+def _function_types(fn: Function) -> dict[str, str]:
+    types = _value_types(fn)
+    by_name: dict[str, set[str | None]] = {}
+    for value in fn.values.values():
+        by_name.setdefault(value.name, set()).add(types.get(value.id))
+    excluded = set(fn.params) | fn.memory
+    return {
+        name: next(iter(found))
+        for name, found in by_name.items()
+        if name not in excluded and len(found) == 1 and None not in found
+    }
+
+
+def _value_types(fn: Function) -> dict[int, str | None]:
+    """Value id -> type, for the Values of fn (dynamic ones are None)."""
+    types: dict[int, str | None] = {value.id: _UNKNOWN for value in fn.values.values()}
+    for value in fn.param_values.values():
+        types[value.id] = None
+
+    def value_type(var: dict) -> str | None:
+        return types.get(var.get("ssa"))
+
+    def evaluate(expr: Any) -> str | None:
+        # A read of an unknown Value leaves the result unknown for now
+        if any(
+            node["type"] == "Var" and types.get(node.get("ssa")) == _UNKNOWN
+            for node in iter_nodes(expr)
+        ):
+            return _UNKNOWN
+        return expr_type(expr, value_type)
+
+    # Types only move up, from unknown to a type to dynamic, so this ends
+    changed = True
+    while changed:
+        changed = False
+        found: list[tuple[int, str | None]] = []
+        for op in iter_ops(fn.body):
+            if isinstance(op, Define):
+                found.append((op.dest.id, evaluate(op.value)))
+            elif isinstance(op, Effect) and op.dest is not None:
+                found.append((op.dest.id, None))  # a popped item
+            if isinstance(op, Loop) and op.var_value is not None:
+                ranged = op.kind == "For" and is_coreil_node(op.items) and op.items["type"] == "Range"
+                found.append((op.var_value.id, INT if ranged else None))
+            for phi in phis(op):
+                for expr in phi.incoming.values():
+                    found.append((phi.dest.id, evaluate(expr)))
+        for value_id, new_type in found:
+            joined = _join(types[value_id], new_type)
+            if joined != types[value_id]:
+                types[value_id] = joined
+                changed = True
+    return {value_id: None if t == _UNKNOWN else t for value_id, t in types.items()}
+
+
+def _join(a: str | None, b: str | None) -> str | None:
+    if a == _UNKNOWN:
+        return b
+    if b == _UNKNOWN or a == b:
+        return a
+    return None
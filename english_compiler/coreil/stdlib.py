@@ -0,0 +1,113 @@
+"""The Core IL standard library.
+
+The standard library is a set of Core IL modules shipped inside the package
+(in ``std/``) and imported like any other module, under the ``std.``
+namespace::
+
+    {"type": "Import", "path": "std.strings"}
+    {"type": "Call", "name": "strings.pad_left", "args": [...]}
+
+Each module document carries a ``"doc"`` string describing the module and a
+``"docs"`` map describing each export, from which stdlib_reference() builds
+the reference appended to the frontends' system prompt. The surface is
+versioned by STDLIB_VERSION: exports are only added within a major version.
+"""
+
+from __future__ import annotations
+
+import json
+from dataclasses import dataclass
+from pathlib import Path
+
+# Bump the minor version when adding exports, the major version when
+# changing or removing them.
+STDLIB_VERSION = "1.0"
+
+STDLIB_PREFIX = "std."
+
+
+def get_stdlib_dir() -> Path:
+    """Return the directory holding the standard library modules."""
+    return Path(__file__).parent / "std"
+
+
+def is_stdlib_import(import_path: str) -> bool:
+    """True if import_path names a standard library module (``std.<name>``)."""
+    return import_path.startswith(STDLIB_PREFIX)
+
+
+def stdlib_module_path(import_path: str) -> Path:
+    """The file a ``std.<name>`` import path names (which may not exist)."""
+    relative = import_path[len(STDLIB_PREFIX):].replace(".", "/")
+    return get_stdlib_dir() / f"{relative}.coreil.json"
+
+
+def stdlib_modules() -> list[str]:
+    """The names of the standard library modules, sorted."""
+    return sorted(path.name.removesuffix(".coreil.json") for path in get_stdlib_dir().glob("*.coreil.json"))
+
+
+@dataclass(frozen=True)
+class StdlibExport:
+    """A function or constant a standard library module exports."""
+
+    name: str
+    params: tuple[str, ...] | None  # None for a constant
+    doc: str
+
+    def signature(self, alias: str) -> str:
+        if self.params is None:
+            return f"{alias}.{self.name}"
+        return f"{alias}.{self.name}({', '.join(self.params)})"
+
+
+@dataclass(frozen=True)
+class StdlibModule:
+    """A standard library module's documentation."""
+
+    name: str
+    doc: str
+    exports: tuple[StdlibExport, ...]
+
+    @property
+    def import_path(self) -> str:
+        return STDLIB_PREFIX + self.name
+
+
+def load_stdlib_module(name: str) -> StdlibModule:
+    """The documentation of the standard library module name.
+
+    Raises:
+        KeyError: If there is no such module.
+    """
+    path = stdlib_module_path(STDLIB_PREFIX + name)
+    if not path.is_file():
+        raise KeyError(name)
+    doc = json.loads(path.read_text(encoding="utf-8"))
+    params = {
+        stmt["name"]: tuple(stmt.get("params", [])) if stmt["type"] == "FuncDef" else None
+        for stmt in doc["body"]
+        if stmt.get("type") in ("FuncDef", "Let")
+    }
+    docs = doc.get("docs", {})
+    return StdlibModule(
+        name=name,
+        doc=doc.get("doc", ""),
+        exports=tuple(StdlibExport(export, params[export], docs.get(export, "")) for export in doc["exports"]),
+    )
+
+
+def stdlib_reference() -> str:
+    """The standard library reference, as appended to the system prompt."""
+    lines = [
+        f"=== STANDARD LIBRARY (std {STDLIB_VERSION}) ===",
+        "",
+        "Prefer these modules over writing the same helper by hand. Import one with",
+        '  {"type": "Import", "path": "std.<module>"}',
+        'and use its exports by the alias, which defaults to the module name: "strings.pad_left".',
+    ]
+    for name in stdlib_modules():
+        module = load_stdlib_module(name)
+        lines += ["", f"{module.import_path}: {module.doc}"]
+        lines += [f"- {export.signature(name)}: {export.doc}" for export in module.exports]
+    return "\n".join(lines) + "\n"
@@ -21,7 +21,7 @@ from __future__ import annotations
 
 from typing import Any, Callable
 
-from .constants import BINARY_OPS, DISALLOWED_HELPER_CALLS, MATH_CONSTANTS, MATH_OPS
+from .constants import BINARY_OPS, DISALLOWED_HELPER_CALLS, DIVISION_MODES, MATH_CONSTANTS, MATH_OPS
 from .versions import SUPPORTED_VERSIONS, get_version_error_message, is_sealed_version
 
 _ALLOWED_NODE_TYPES = {
@@ -210,10 +210,20 @@ def _validate_var(node, path, defined, add_error, validate_expr):
     if not isinstance(name, str) or not name:
         add_error(f"{path}.name", "missing or invalid name")
         return
-    if name not in defined:
+    if name not in defined and not _is_module_member(name, defined):
         add_error(path, f"variable '{name}' used before definition")
 
 
+def _is_module_member(name: str, defined: set[str]) -> bool:
+    """Whether name is qualified by an imported module's alias (utils.PI).
+
+    Import adds "<alias>." to the defined names; whether the module exports
+    the name is checked when imports are resolved.
+    """
+    alias, dot, _ = name.partition(".")
+    return bool(dot) and f"{alias}." in defined
+
+
 def _validate_binary(node, path, defined, add_error, validate_expr):
     op = node.get("op")
     if op not in BINARY_OPS:
@@ -404,10 +414,16 @@ def _validate_set_has(node, path, defined, add_error, validate_expr):
 
 
 def _validate_no_args(node, path, defined, add_error, validate_expr):
-    """Validator for expressions with no required arguments (DequeNew, HeapNew)."""
+    """Validator for expressions with no arguments (HeapNew)."""
     pass
 
 
+def _validate_deque_new(node, path, defined, add_error, validate_expr):
+    """DequeNew has an optional maxlen."""
+    if node.get("maxlen") is not None:
+        validate_expr(node["maxlen"], f"{path}.maxlen", defined)
+
+
 def _validate_math(node, path, defined, add_error, validate_expr):
     op = node.get("op")
     if op not in MATH_OPS:
@@ -415,6 +431,11 @@ def _validate_math(node, path, defined, add_error, validate_expr):
             f"{path}.op", f"invalid math op '{op}', must be one of {set(MATH_OPS)}"
         )
     _require_expr(node, "arg", path, defined, add_error, validate_expr)
+    # round takes an optional ndigits, like Python's round()
+    if node.get("ndigits") is not None:
+        if op != "round":
+            add_error(f"{path}.ndigits", "ndigits is only allowed with op 'round'")
+        validate_expr(node["ndigits"], f"{path}.ndigits", defined)
 
 
 def _validate_math_pow(node, path, defined, add_error, validate_expr):
@@ -547,7 +568,7 @@ _EXPR_VALIDATORS: dict[str, ValidatorFunc] = {
     "Set": _validate_set_expr,
     "SetHas": _validate_set_has,
     "SetSize": _validate_base_only,
-    "DequeNew": _validate_no_args,
+    "DequeNew": _validate_deque_new,
     "DequeSize": _validate_base_only,
     "HeapNew": _validate_no_args,
     "HeapSize": _validate_base_only,
@@ -915,6 +936,8 @@ def _validate_import(
     alias = node.get("alias")
     if alias is not None and (not isinstance(alias, str) or not alias):
         add_error(f"{path}.alias", "alias must be a non-empty string if provided")
+    elif isinstance(import_path, str) and import_path:
+        defined.add(f"{alias or import_path.rsplit('.', 1)[-1]}.")
 
 
 def _validate_try_catch(
@@ -1105,12 +1128,20 @@ def validate_coreil(doc: dict) -> list[dict]:
                             "default must be a valid option index",
                         )
 
+    division = doc.get("division")
+    if division is not None and division not in DIVISION_MODES:
+        add_error("$.division", "division must be 'floor' or 'truncate'")
+
     body = doc.get("body")
     if not isinstance(body, list):
         add_error("$.body", "body must be a list")
         return errors
 
-    defined: set[str] = set()
+    # A Var may name a top-level function, which is then a function value
+    defined: set[str] = {
+        stmt["name"] for stmt in body
+        if isinstance(stmt, dict) and stmt.get("type") == "FuncDef" and isinstance(stmt.get("name"), str)
+    }
     for i, stmt in enumerate(body):
         validate_stmt(stmt, f"$.body[{i}]", defined, False)
 
@@ -1162,4 +1193,23 @@ def validate_coreil(doc: dict) -> list[dict]:
                     else:
                         seen_indices.add(idx)
 
+    # Validate optional sentences (spans of the English source; see
+    # source_map.sentence_spans)
+    sentences = doc.get("sentences")
+    if sentences is not None:
+        if not isinstance(sentences, list):
+            add_error("$.sentences", "sentences must be a list")
+        else:
+            for i, span in enumerate(sentences):
+                span_path = f"$.sentences[{i}]"
+                if not isinstance(span, dict):
+                    add_error(span_path, "sentence must be an object")
+                    continue
+                for key in ("line", "column", "end_line", "end_column"):
+                    value = span.get(key)
+                    if isinstance(value, bool) or not isinstance(value, int) or value < 1:
+                        add_error(f"{span_path}.{key}", f"{key} must be a positive integer")
+                if not isinstance(span.get("text"), str):
+                    add_error(f"{span_path}.text", "missing or invalid text")
+
     return errors
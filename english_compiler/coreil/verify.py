@@ -0,0 +1,211 @@
+"""Core IL verifier.
+
+verify_coreil() checks the structural invariants a program must satisfy
+before it runs, and reports every violation at once rather than stopping at
+the first one the interpreter reaches. It runs validate_coreil() and adds:
+
+- call-arity: a Call passes as many arguments as the function or builtin
+  it names takes, and names one that exists
+- literal-type: a Literal holds null, a boolean, a number or a string
+- operand-type: no Binary applies its operator to operand types it always
+  rejects, e.g. a string minus a number (see static_types.check_types);
+  only checked once the rest of the document is valid
+- target-builtin: given a target, no builtin the target lacks is used
+  (see builtins.check_builtins); also only checked once the rest is valid
+
+validate_coreil() already checks that variables are defined before use,
+that Break and Continue appear only inside loops, and that Return appears
+only inside functions.
+
+run_coreil() verifies each program before executing it, so malformed
+frontend output fails fast with the location of every problem instead of
+with a confusing error partway through the run.
+
+Usage:
+    from english_compiler.coreil.verify import format_violation, verify_coreil
+
+    for violation in verify_coreil(doc):
+        print(format_violation(violation))
+"""
+
+from __future__ import annotations
+
+import re
+from typing import Any
+
+from .builtins import call_builtins, check_builtins, property_literal
+from .node_nav import iter_node_paths
+from .static_types import check_types
+from .validate import validate_coreil
+
+# Builtin Call name -> (min args, max args), from the builtin registry
+BUILTIN_ARITY: dict[str, tuple[int, int | None]] = {
+    name: builtin.arity for name, builtin in call_builtins().items()
+}
+
+# The builtins the interpreter resolves before user functions of the same
+# name; a program's own function wins over the rest, as on the Go backend
+_RESERVED_CALLS = frozenset(name for name, builtin in call_builtins().items() if "coreil" in builtin.targets)
+
+_BODY_INDEX = re.compile(r"^\$\.body\[(\d+)\]")
+_PATH_PARTS = re.compile(r"(\d+)")
+
+
+def verify_coreil(doc: Any, *, target: str | None = None) -> list[dict]:
+    """Check doc's structural invariants, returning every violation.
+
+    Given a compile target, a program that is otherwise sound is also
+    checked for builtins the target does not support (see
+    coreil.builtins.check_builtins).
+
+    Each violation is a dict with keys:
+    - message: str (human-readable description)
+    - path: str (JSON path to the offending node)
+    - line: int, only when the document's source_map gives the English
+      line of the top-level statement containing the node
+    """
+    violations = validate_coreil(doc)
+    if isinstance(doc, dict) and isinstance(doc.get("body"), list):
+        nodes = list(iter_node_paths(doc["body"]))
+        violations.extend(_check_literals(nodes))
+        violations.extend(_check_calls(nodes))
+        if not violations:
+            violations.extend(check_types(doc))
+        if not violations and target is not None:
+            violations.extend(check_builtins(doc, target))
+        _add_lines(doc, violations)
+    # Report in document order, not grouped by check
+    violations.sort(key=lambda violation: _path_key(violation["path"]))
+    return violations
+
+
+def format_violation(violation: dict) -> str:
+    """Render a violation as 'path: message', with its English line if known."""
+    text = f"{violation['path']}: {violation['message']}"
+    if "line" in violation:
+        text += f" (line {violation['line']})"
+    return text
+
+
+# ---------------------------------------------------------------------------
+# Checks
+# ---------------------------------------------------------------------------
+
+def _check_literals(nodes: list[tuple[str, dict]]) -> list[dict]:
+    violations = []
+    for path, node in nodes:
+        if node["type"] != "Literal" or "value" not in node:
+            continue
+        value = node["value"]
+        if value is not None and not isinstance(value, (bool, int, float, str)):
+            violations.append({
+                "message": (
+                    "Literal value must be null, a boolean, a number or a string, "
+                    f"not {type(value).__name__}"
+                ),
+                "path": f"{path}.value",
+            })
+    return violations
+
+
+def _check_calls(nodes: list[tuple[str, dict]]) -> list[dict]:
+    # A function may be defined more than once, e.g. in both branches of an If
+    param_counts: dict[str, set[int]] = {}
+    for _, node in nodes:
+        if node["type"] == "FuncDef" and isinstance(node.get("params"), list):
+            param_counts.setdefault(node.get("name"), set()).add(len(node["params"]))
+
+    # Functions of imported modules are checked when imports are resolved
+    modules = {
+        f"{node.get('alias') or node['path'].rsplit('.', 1)[-1]}."
+        for _, node in nodes
+        if node["type"] == "Import" and isinstance(node.get("path"), str)
+    }
+
+    violations = []
+    for path, node in nodes:
+        name = node.get("name")
+        if node["type"] != "Call" or not isinstance(name, str) or not isinstance(node.get("args"), list):
+            continue
+        alias, dot, _ = name.partition(".")
+        if dot and f"{alias}." in modules:
+            continue
+        count = len(node["args"])
+        if name in BUILTIN_ARITY and (name in _RESERVED_CALLS or name not in param_counts):
+            low, high = BUILTIN_ARITY[name]
+            if count < low or (high is not None and count > high):
+                violations.append({
+                    "message": f"builtin '{name}' takes {_describe_arity(low, high)}, got {count}",
+                    "path": f"{path}.args",
+                })
+            elif name == "forAll":
+                violations.extend(_check_property(path, node, param_counts))
+        elif name not in param_counts:
+            violations.append({"message": f"call to undefined function '{name}'", "path": path})
+        elif count not in param_counts[name]:
+            expected = " or ".join(str(n) for n in sorted(param_counts[name]))
+            violations.append({
+                "message": f"function '{name}' takes {expected} argument(s), got {count}",
+                "path": f"{path}.args",
+            })
+    return violations
+
+
+def _check_property(path: str, node: dict, param_counts: dict[str, set[int]]) -> list[dict]:
+    """forAll's property names a function taking one argument per generator."""
+    literal = property_literal(node)
+    if literal is None:
+        return [{
+            "message": "forAll property must be a function name, as a string literal",
+            "path": f"{path}.args[1]",
+        }]
+    name = literal["value"]
+    if name not in param_counts:
+        return [{"message": f"forAll property '{name}' is not a defined function", "path": f"{path}.args[1]"}]
+    generator = node["args"][0]
+    if not isinstance(generator, dict):
+        return []
+    if generator.get("type") == "Literal":
+        count = 1
+    elif generator.get("type") == "Array" and isinstance(generator.get("items"), list):
+        count = len(generator["items"])
+    else:
+        return []
+    if count in param_counts[name]:
+        return []
+    expected = " or ".join(str(n) for n in sorted(param_counts[name]))
+    return [{
+        "message": f"forAll property '{name}' takes {expected} argument(s), but gets {count} generated value(s)",
+        "path": f"{path}.args[1]",
+    }]
+
+
+def _describe_arity(low: int, high: int | None) -> str:
+    if high is None:
+        return f"at least {low} argument(s)"
+    if low == high:
+        return f"{low} argument(s)"
+    return f"{low} to {high} arguments"
+
+
+def _path_key(path: str) -> list:
+    """Sort key that orders $.body[2] before $.body[10]."""
+    return [int(part) if part.isdigit() else part for part in _PATH_PARTS.split(path)]
+
+
+def _add_lines(doc: dict, violations: list[dict]) -> None:
+    """Attach the English line of each violation's top-level statement."""
+    source_map = doc.get("source_map")
+    if not isinstance(source_map, dict):
+        return
+    lines: dict[int, int] = {}
+    for key, indices in source_map.items():
+        if not isinstance(key, str) or not key.isdigit() or not isinstance(indices, list):
+            continue
+        for index in indices:
+            if isinstance(index, int):
+                lines[index] = min(lines.get(index, int(key)), int(key))
+    for violation in violations:
+        match = _BODY_INDEX.match(violation["path"])
+        if match and int(match.group(1)) in lines:
+            violation["line"] = lines[int(match.group(1))]
@@ -8,7 +8,11 @@ from abc import ABC, abstractmethod
 from pathlib import Path
 from typing import Any
 
-from english_compiler.coreil.validate import validate_coreil
+from english_compiler.coreil.builtins import target_constraints
+from english_compiler.coreil.diagnostics import explain_violations
+from english_compiler.coreil.source_map import sentence_spans
+from english_compiler.coreil.stdlib import stdlib_reference
+from english_compiler.coreil.verify import format_violation, verify_coreil
 from english_compiler.frontend.coreil_schema import COREIL_JSON_SCHEMA
 
 
@@ -59,10 +63,14 @@ def get_required_env(name: str) -> str:
     return value
 
 
-def _load_system_prompt() -> str:
-    """Load the shared system prompt from prompt.txt."""
-    prompt_path = Path(__file__).with_name("prompt.txt")
-    return prompt_path.read_text(encoding="utf-8")
+def get_prompt_path() -> Path:
+    """Return the path to prompt.txt, the system prompt every frontend uses."""
+    return Path(__file__).with_name("prompt.txt")
+
+
+def load_system_prompt() -> str:
+    """Load the shared system prompt: prompt.txt and the stdlib reference."""
+    return get_prompt_path().read_text(encoding="utf-8") + "\n" + stdlib_reference()
 
 
 def _build_user_message(
@@ -97,6 +105,44 @@ def _build_user_message(
     return "".join(parts)
 
 
+class RepairError(RuntimeError):
+    """Frontend output that still failed verification after every repair attempt.
+
+    Attributes:
+        violations: verify_coreil() violations of the last output.
+        coreil: The last output.
+        attempts: How many repair attempts were made.
+    """
+
+    def __init__(self, message: str, violations: list[dict], coreil: dict, attempts: int) -> None:
+        super().__init__(message)
+        self.violations = violations
+        self.coreil = coreil
+        self.attempts = attempts
+
+
+def annotate_violations(
+    violations: list[dict], source_text: str, coreil: dict | None = None
+) -> list[str]:
+    """Render violations one per line, quoting the English line of each when known.
+
+    Given the Core IL they were found in, each violation with an English
+    form (see coreil.diagnostics) is followed by it on an indented line.
+    """
+    source_lines = source_text.splitlines()
+    diagnostics = explain_violations(coreil or {}, violations, source_text=source_text)
+    annotated = []
+    for violation, diagnostic in zip(violations, diagnostics):
+        text = format_violation(violation)
+        line = violation.get("line")
+        if isinstance(line, int) and 0 < line <= len(source_lines):
+            text += f": {source_lines[line - 1].strip()}"
+        annotated.append(text)
+        if diagnostic is not None:
+            annotated.append(f"  {diagnostic.message}")
+    return annotated
+
+
 class BaseFrontend(ABC):
     """Abstract base class for LLM frontends.
 
@@ -108,7 +154,7 @@ class BaseFrontend(ABC):
     """
 
     def __init__(self) -> None:
-        self.system_prompt = _load_system_prompt()
+        self.system_prompt = load_system_prompt()
         self.schema = COREIL_JSON_SCHEMA
 
     def _parse_json_response(
@@ -243,42 +289,65 @@ class BaseFrontend(ABC):
             return "CODE"
 
     def generate_coreil_from_text(
-        self, source_text: str, *, max_retries: int = 3
+        self, source_text: str, *, max_retries: int = 3, target: str | None = None
     ) -> dict:
-        """Generate Core IL from source text with validation and retry.
+        """Generate Core IL from source text with verification and repair.
 
-        On validation failure, retries up to *max_retries* times, feeding back
-        the full previous Core IL output and the validation errors so the LLM
-        can see exactly what it generated and what went wrong.
+        Each output is checked with verify_coreil(), which covers schema
+        validation, call arity and operand types. On failure, retries up to
+        *max_retries* times, feeding back the full previous Core IL output
+        and the violations so the LLM can see exactly what it generated and
+        what went wrong.
+
+        Given a target, the prompt names the builtins the target lacks (see
+        coreil.builtins) and using one counts as a violation, so the program
+        is repaired rather than failing later in the target's compiler.
 
         Args:
             source_text: The English pseudocode to compile.
-            max_retries: Maximum number of retry attempts (default 3).
+            max_retries: Maximum number of repair attempts (default 3).
+            target: The compile target ("go", "rust", ...) the program must
+                run on, if any.
 
         Returns:
-            Validated Core IL program as a dict.
+            Verified Core IL program as a dict. If it has a source_map, its
+            "sentences" field holds the spans of the English sentences (see
+            coreil.source_map.sentence_spans).
 
         Raises:
-            RuntimeError: If validation fails after all retries.
+            RepairError: If verification still fails after all retries; its
+                message lists each violation with its English line.
         """
-        user_message = _build_user_message(source_text, None)
+        constraints = target_constraints(target) if target else ""
+        prompt_text = f"{source_text}\n\n{constraints}" if constraints else source_text
+
+        user_message = _build_user_message(prompt_text, None)
         data = self._call_api(user_message)
-        errors = validate_coreil(data)
+        errors = verify_coreil(data, target=target)
 
         attempt = 0
         while errors and attempt < max_retries:
             attempt += 1
             retry_message = _build_user_message(
-                source_text, errors, previous_output=data
+                prompt_text, errors, previous_output=data
             )
             data = self._call_api(retry_message)
-            errors = validate_coreil(data)
+            errors = verify_coreil(data, target=target)
 
         if errors:
-            raise RuntimeError(
+            details = "\n".join(
+                f"  {line}" for line in annotate_violations(errors, source_text, data)
+            )
+            raise RepairError(
                 f"Validation failed after {attempt} "
                 f"{'retry' if attempt == 1 else 'retries'}. "
-                f"model={self.get_model_name()} errors={errors}"
+                f"model={self.get_model_name()}\n{details}",
+                errors,
+                data,
+                attempt,
             )
 
+        # Sentence spans let tools name sentences without the source file
+        if isinstance(data.get("source_map"), dict):
+            data["sentences"] = sentence_spans(source_text)
         return data
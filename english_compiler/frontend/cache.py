@@ -0,0 +1,178 @@
+"""Cache of frontend output, keyed on the English source.
+
+The lock file next to each program only remembers the Core IL of that one
+file. This cache maps any English text to the Core IL a frontend generated
+for it, so identical programs (a copied file, a reverted edit, a teammate's
+checkout) never call the LLM twice.
+
+A key covers everything the output depends on: the normalized source text
+(see normalize_source), the frontend's model, the system prompt, and the
+compiler and Core IL versions. Changing any of them misses.
+
+Entries are stored under $COREIL_CACHE_DIR/frontend, or the user cache
+directory, and optionally in a shared remote store: an HTTP server where
+GET <url>/<key>.json returns an entry and PUT stores one (a bucket with
+static hosting, or any small key-value service). Set COREIL_REMOTE_CACHE to
+its URL, and COREIL_REMOTE_CACHE_TOKEN to send a bearer token. Remote
+entries are verified before use and copied to the local cache; an
+unreachable remote store only costs a miss.
+
+Usage:
+    from english_compiler.frontend.cache import CoreILCache
+
+    cache = CoreILCache.from_env()
+    key = cache.key(source_text, frontend.get_model_name())
+    hit = cache.get(key)
+    if hit is None:
+        doc = frontend.generate_coreil_from_text(source_text)
+        cache.put(key, doc)
+"""
+
+from __future__ import annotations
+
+import hashlib
+import json
+import os
+import unicodedata
+import urllib.error
+import urllib.request
+from dataclasses import dataclass
+from pathlib import Path
+
+from english_compiler.coreil.go_cache import default_cache_dir
+from english_compiler.coreil.verify import verify_coreil
+from english_compiler.coreil.versions import COREIL_VERSION, PACKAGE_VERSION
+from english_compiler.frontend.base import load_system_prompt
+
+
+def normalize_source(text: str) -> str:
+    """The form of text that keys are computed from.
+
+    Unicode is NFC-normalized, line endings become \\n, trailing whitespace
+    and leading and trailing blank lines are dropped. Nothing inside a line
+    changes, so quoted strings keep their exact spacing.
+    """
+    text = unicodedata.normalize("NFC", text)
+    lines = [line.rstrip() for line in text.replace("\r\n", "\n").replace("\r", "\n").split("\n")]
+    return "\n".join(lines).strip("\n")
+
+
+@dataclass
+class CacheHit:
+    """A cached Core IL document and where it was found."""
+    coreil: dict
+    origin: str  # "local" or "remote"
+
+
+class CoreILCache:
+    """Core IL generated by frontends, addressed by source and model."""
+
+    def __init__(
+        self,
+        root: Path | None = None,
+        remote_url: str | None = None,
+        token: str | None = None,
+        timeout: float = 10.0,
+    ):
+        self.root = Path(root) if root is not None else default_cache_dir("frontend")
+        self.remote_url = remote_url.rstrip("/") if remote_url else None
+        self.token = token
+        self.timeout = timeout
+        # The last remote store failure, for callers to report
+        self.remote_error: str | None = None
+
+    @classmethod
+    def from_env(cls) -> "CoreILCache":
+        """A cache using COREIL_REMOTE_CACHE and COREIL_REMOTE_CACHE_TOKEN."""
+        return cls(
+            remote_url=os.environ.get("COREIL_REMOTE_CACHE") or None,
+            token=os.environ.get("COREIL_REMOTE_CACHE_TOKEN") or None,
+        )
+
+    def key(self, source_text: str, model: str, target: str | None = None) -> str:
+        """The key of source_text compiled by model, for target if given."""
+        prompt = hashlib.sha256(load_system_prompt().encode("utf-8")).hexdigest()
+        parts = [
+            "frontend",
+            PACKAGE_VERSION,
+            COREIL_VERSION,
+            model,
+            prompt,
+            normalize_source(source_text),
+        ]
+        # Target-constrained output differs, but untargeted keys are unchanged
+        if target is not None:
+            parts.append(f"target={target}")
+        return hashlib.sha256("\0".join(parts).encode("utf-8")).hexdigest()
+
+    def path(self, key: str) -> Path:
+        """Where the local entry for key is (or would be) stored."""
+        return self.root / key[:2] / f"{key}.json"
+
+    def get(self, key: str) -> CacheHit | None:
+        """The cached Core IL for key, looking locally and then remotely."""
+        doc = self._read_local(key)
+        if doc is not None:
+            return CacheHit(coreil=doc, origin="local")
+        doc = self._read_remote(key)
+        if doc is not None:
+            self._write_local(key, doc)
+            return CacheHit(coreil=doc, origin="remote")
+        return None
+
+    def put(self, key: str, doc: dict) -> None:
+        """Store doc under key locally and, if configured, remotely."""
+        self._write_local(key, doc)
+        if self.remote_url is None:
+            return
+        data = json.dumps(doc, sort_keys=True).encode("utf-8")
+        request = self._request(key, method="PUT", data=data)
+        try:
+            with urllib.request.urlopen(request, timeout=self.timeout):
+                pass
+        except OSError as exc:
+            self.remote_error = f"{self.remote_url}: {exc}"
+
+    def _read_local(self, key: str) -> dict | None:
+        try:
+            doc = json.loads(self.path(key).read_text(encoding="utf-8"))
+        except (OSError, json.JSONDecodeError):
+            return None
+        return doc if isinstance(doc, dict) else None
+
+    def _write_local(self, key: str, doc: dict) -> None:
+        path = self.path(key)
+        try:
+            path.parent.mkdir(parents=True, exist_ok=True)
+            staged = path.with_name(f".{path.name}.{os.getpid()}")
+            staged.write_text(json.dumps(doc, indent=2, sort_keys=True) + "\n", encoding="utf-8")
+            os.replace(staged, path)
+        except OSError:
+            pass  # a cache that cannot be written only costs a miss
+
+    def _read_remote(self, key: str) -> dict | None:
+        if self.remote_url is None:
+            return None
+        try:
+            with urllib.request.urlopen(self._request(key), timeout=self.timeout) as response:
+                doc = json.loads(response.read().decode("utf-8"))
+        except urllib.error.HTTPError as exc:
+            if exc.code != 404:
+                self.remote_error = f"{self.remote_url}: {exc}"
+            return None
+        except (OSError, ValueError) as exc:
+            self.remote_error = f"{self.remote_url}: {exc}"
+            return None
+        # Entries from a shared store are only trusted once they verify
+        if not isinstance(doc, dict) or verify_coreil(doc):
+            self.remote_error = f"{self.remote_url}: ignored invalid entry {key}"
+            return None
+        return doc
+
+    def _request(self, key: str, *, method: str = "GET", data: bytes | None = None) -> urllib.request.Request:
+        request = urllib.request.Request(f"{self.remote_url}/{key}.json", data=data, method=method)
+        if data is not None:
+            request.add_header("Content-Type", "application/json")
+        if self.token:
+            request.add_header("Authorization", f"Bearer {self.token}")
+        return request
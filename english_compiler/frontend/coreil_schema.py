@@ -592,6 +592,7 @@ COREIL_JSON_SCHEMA = {
             "required": ["type"],
             "properties": {
                 "type": {"const": "DequeNew"},
+                "maxlen": {"$ref": "#/definitions/expr"},
             },
         },
         "dequesize_expr": {
@@ -745,8 +746,14 @@ COREIL_JSON_SCHEMA = {
             "required": ["type", "op", "arg"],
             "properties": {
                 "type": {"const": "Math"},
-                "op": {"enum": ["sin", "cos", "tan", "sqrt", "floor", "ceil", "abs", "log", "exp"]},
+                "op": {
+                    "enum": [
+                        "sin", "cos", "tan", "sqrt", "floor", "ceil", "abs", "log", "exp",
+                        "trunc", "round", "asin", "acos", "atan", "log2", "log10", "sinh", "cosh", "tanh",
+                    ]
+                },
                 "arg": {"$ref": "#/definitions/expr"},
+                "ndigits": {"$ref": "#/definitions/expr"},
             },
         },
         "mathpow_expr": {
@@ -765,7 +772,7 @@ COREIL_JSON_SCHEMA = {
             "required": ["type", "name"],
             "properties": {
                 "type": {"const": "MathConst"},
-                "name": {"enum": ["pi", "e"]},
+                "name": {"enum": ["pi", "e", "inf", "nan"]},
             },
         },
         # JSON operations (v1.3)
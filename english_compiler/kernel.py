@@ -0,0 +1,515 @@
+"""Jupyter kernel for English programs.
+
+`english-compiler kernel --install` registers the kernel with Jupyter;
+notebooks using it have cells written in English, which are compiled to
+Core IL by the frontend and run by the Go runtime.
+
+A cell is compiled together with the cells that ran successfully before
+it, as one English program, so the names they define carry over and the
+verifier sees every definition. The whole program then runs, with a
+marker printed before the cell's first statement; the output before the
+marker belongs to earlier cells and is hidden. Earlier cells are re-run
+each time, so their side effects repeat; random values stay the same
+because every run in a session uses the session's seed.
+
+When a cell ends by printing a single value, that value is the cell's
+result and gets rich display: arrays of records (or of maps) and single
+records are shown as tables, next to their plain printed form. The Go
+runtime reports the printed values through COREIL_DISPLAY (see
+coreil.display).
+
+The kernel speaks the Jupyter messaging protocol over ZeroMQ and needs
+pyzmq (pip install english-compiler[jupyter]); everything else, including
+message signing, is implemented here with the standard library.
+"""
+
+from __future__ import annotations
+
+import datetime
+import hashlib
+import hmac
+import html
+import json
+import os
+import random
+import subprocess
+import sys
+import tempfile
+import threading
+import uuid
+from dataclasses import dataclass, field
+from pathlib import Path
+from typing import Any
+
+from english_compiler.coreil.diagnostics import format_diagnostics
+from english_compiler.coreil.display import read_display, result_record
+from english_compiler.coreil.module import CircularImportError, ModuleNotFoundError, resolve_imports
+from english_compiler.coreil.source_map import remap_replaced_statements
+from english_compiler.coreil.versions import PACKAGE_VERSION
+
+PROTOCOL_VERSION = "5.3"
+KERNEL_NAME = "english"
+DELIMITER = b"<IDS|MSG>"
+
+
+def is_zmq_available() -> bool:
+    """Check if the pyzmq library is installed."""
+    try:
+        import zmq  # noqa: F401
+        return True
+    except ImportError:
+        return False
+
+
+# ---------------------------------------------------------------------------
+# Messages
+# ---------------------------------------------------------------------------
+
+def sign(key: bytes, parts: list[bytes]) -> bytes:
+    """The HMAC-SHA256 signature of a message's parts, or b"" without a key."""
+    if not key:
+        return b""
+    mac = hmac.new(key, digestmod=hashlib.sha256)
+    for part in parts:
+        mac.update(part)
+    return mac.hexdigest().encode("ascii")
+
+
+def new_message(
+    msg_type: str,
+    content: dict,
+    session: str,
+    parent: dict | None = None,
+) -> dict:
+    """A message of msg_type, in reply to parent if given."""
+    return {
+        "header": {
+            "msg_id": uuid.uuid4().hex,
+            "session": session,
+            "username": "kernel",
+            "date": datetime.datetime.now(datetime.timezone.utc).isoformat(),
+            "msg_type": msg_type,
+            "version": PROTOCOL_VERSION,
+        },
+        "parent_header": (parent or {}).get("header", {}),
+        "metadata": {},
+        "content": content,
+    }
+
+
+def serialize(message: dict, key: bytes, identities: list[bytes] | None = None) -> list[bytes]:
+    """The wire frames of a message: routing identities, delimiter, signature, parts."""
+    parts = [
+        json.dumps(message[name]).encode("utf-8")
+        for name in ("header", "parent_header", "metadata", "content")
+    ]
+    return [*(identities or []), DELIMITER, sign(key, parts), *parts]
+
+
+def deserialize(frames: list[bytes], key: bytes) -> tuple[list[bytes], dict]:
+    """Split wire frames into routing identities and the message.
+
+    Raises ValueError if the frames are malformed or the signature is wrong.
+    """
+    try:
+        split = frames.index(DELIMITER)
+    except ValueError:
+        raise ValueError("message has no delimiter") from None
+    signature, parts = frames[split + 1], frames[split + 2:split + 6]
+    if len(parts) < 4:
+        raise ValueError("message is missing parts")
+    if not hmac.compare_digest(signature, sign(key, parts)):
+        raise ValueError("message signature is invalid")
+    names = ("header", "parent_header", "metadata", "content")
+    message = {name: json.loads(part) for name, part in zip(names, parts)}
+    return frames[:split], message
+
+
+# ---------------------------------------------------------------------------
+# Rich display
+# ---------------------------------------------------------------------------
+
+def display_data(value: dict, text: str) -> dict[str, str]:
+    """The MIME bundle of a printed value: its printed text, and a table when it has rows.
+
+    value is the Go runtime's encoding of the value (see displayValue in
+    coreil_runtime.go).
+    """
+    bundle = {"text/plain": text}
+    rows = _rows(value)
+    if rows is not None:
+        bundle["text/html"] = _table(*rows, total=value.get("length", len(rows[1])))
+    return bundle
+
+
+def _rows(value: dict) -> tuple[list[str], list[dict[str, dict]]] | None:
+    """(columns, rows) for an array of records or maps, or a single record."""
+    if "fields" in value:
+        items = [value]
+    elif "items" in value and value.get("type") == "array":
+        items = value["items"]
+    else:
+        return None
+    if not items:
+        return None
+    columns: list[str] = []
+    rows = []
+    for item in items:
+        row = _fields(item)
+        if row is None:
+            return None
+        columns += [name for name in row if name not in columns]
+        rows.append(row)
+    return columns, rows
+
+
+def _fields(value: dict) -> dict[str, dict] | None:
+    if "fields" in value:
+        return dict(zip(value["fields"], value["values"]))
+    if "keys" in value and all(key.get("type") == "str" for key in value["keys"]):
+        return {key["text"]: item for key, item in zip(value["keys"], value["values"])}
+    return None
+
+
+def _table(columns: list[str], rows: list[dict[str, dict]], *, total: int) -> str:
+    lines = ["<table>", "<thead><tr>"]
+    lines += [f"<th>{html.escape(name)}</th>" for name in columns]
+    lines += ["</tr></thead>", "<tbody>"]
+    for row in rows:
+        cells = [html.escape(_cell_text(row[name])) if name in row else "" for name in columns]
+        lines.append("<tr>" + "".join(f"<td>{cell}</td>" for cell in cells) + "</tr>")
+    lines.append("</tbody>")
+    lines.append("</table>")
+    if total > len(rows):
+        lines.append(f"<p>... {total - len(rows)} more rows</p>")
+    return "\n".join(lines)
+
+
+def _cell_text(value: dict) -> str:
+    """How a value nested in a table reads: its printed form, or an elision for containers."""
+    if "text" in value:
+        return value["text"]
+    return f"<{value.get('type', 'value')} of {value.get('length', 0)}>"
+
+
+# ---------------------------------------------------------------------------
+# Executing cells
+# ---------------------------------------------------------------------------
+
+@dataclass
+class CellResult:
+    """What running one cell produced."""
+    stdout: str = ""
+    stderr: str = ""
+    data: dict[str, str] | None = None  # the MIME bundle of the cell's result
+    error: tuple[str, str, list[str]] | None = None  # (name, value, traceback lines)
+
+    @property
+    def ok(self) -> bool:
+        return self.error is None
+
+
+@dataclass
+class NotebookSession:
+    """The cells run so far in one kernel, and how to run the next."""
+    frontend: Any
+    seed: int = field(default_factory=lambda: random.randrange(2**31))
+    grants: list[str] = field(default_factory=list)  # capabilities cells may use (see emit_go)
+    cells: list[str] = field(default_factory=list)  # English of the cells that succeeded
+    marker: str = field(default_factory=lambda: f"--- cell {uuid.uuid4().hex} ---")
+
+    def execute(self, text: str) -> CellResult:
+        """Compile and run a cell after the earlier ones, keeping it if it succeeds."""
+        from english_compiler.coreil.go_cache import GoBuildCache
+        from english_compiler.lsp import compile_source
+
+        if not text.strip():
+            return CellResult()
+        prefix = "".join(cell.rstrip("\n") + "\n\n" for cell in self.cells)
+        source = prefix + text
+        compilation = compile_source(None, source, self.frontend)
+        if compilation.error is not None:
+            return CellResult(error=("CompileError", compilation.error, [compilation.error]))
+        doc = compilation.coreil
+        if compilation.violations:
+            lines = format_diagnostics(doc, compilation.violations, source_text=source)
+            return CellResult(error=("InvalidProgram", lines[0], ["invalid program:", *lines]))
+
+        try:
+            doc = resolve_imports(doc)
+        except (CircularImportError, ModuleNotFoundError, ValueError) as exc:
+            return CellResult(error=("ImportError", str(exc), [f"Import error: {exc}"]))
+
+        program, start = self._with_marker(doc, first_line=prefix.count("\n") + 1)
+        build = GoBuildCache().build(program, source_text=source, grants=self.grants)
+        if not build.success:
+            return CellResult(error=("GoBuildError", "Go compilation failed", (build.error or "").splitlines()))
+        with tempfile.TemporaryDirectory() as tmp_dir:
+            display_path = Path(tmp_dir) / "display.txt"
+            env = {**os.environ, "COREIL_DISPLAY": str(display_path), "COREIL_SEED": str(self.seed)}
+            try:
+                run = subprocess.run([str(build.binary_path)], capture_output=True, env=env)
+            except KeyboardInterrupt:
+                return CellResult(error=("KeyboardInterrupt", "", ["KeyboardInterrupt"]))
+            records = read_display(display_path)
+
+        stdout = run.stdout
+        begin = 0
+        if start is not None:
+            marks = [r for r in records if r.value.get("text") == self.marker]
+            if marks:
+                begin = marks[-1].end
+        result = CellResult(stderr=run.stderr.decode("utf-8", errors="replace"))
+        printed = result_record(doc, records, len(stdout), after=begin) if run.returncode == 0 else None
+        if printed is not None:
+            text_form = stdout[printed.start:].decode("utf-8", errors="replace").removesuffix("\n")
+            result.data = display_data(printed.value, text_form)
+            stdout = stdout[:printed.start]
+        result.stdout = stdout[begin:].decode("utf-8", errors="replace")
+        if run.returncode != 0:
+            lines = result.stderr.strip().splitlines() or [f"exit code {run.returncode}"]
+            result.error = ("RuntimeError", lines[-1], lines)
+            result.stderr = ""
+            return result
+        self.cells.append(text)
+        return result
+
+    def _with_marker(self, doc: dict, *, first_line: int) -> tuple[dict, int | None]:
+        """doc with a print of the marker before the new cell's first statement.
+
+        The cell's statements are those its source map attributes to lines
+        from first_line on; without a source map there is no marker and
+        the output of earlier cells is shown too.
+        """
+        body = list(doc.get("body", []))
+        source_map = doc.get("source_map")
+        if not isinstance(source_map, dict) or not self.cells:
+            return doc, None
+        indices = [
+            index
+            for line, statements in source_map.items()
+            if str(line).isdigit() and int(line) >= first_line
+            for index in statements
+            if isinstance(index, int)
+        ]
+        if not indices:
+            return doc, None
+        start = min(indices)
+        body.insert(start, {"type": "Print", "args": [{"type": "Literal", "value": self.marker}]})
+        # The marker shares the cell's first sentence, so runtime errors
+        # still name the right one
+        counts = [2 if i == start else 1 for i in range(len(body) - 1)]
+        return {**doc, "body": body, "source_map": remap_replaced_statements(source_map, counts)}, start
+
+
+# ---------------------------------------------------------------------------
+# Kernel
+# ---------------------------------------------------------------------------
+
+class Kernel:
+    """Handles the requests of one notebook.
+
+    sockets maps "shell", "control", "stdin" and "iopub" to objects with
+    send_multipart(); serve() passes ZeroMQ sockets.
+    """
+
+    def __init__(self, session: NotebookSession, sockets: dict[str, Any], key: bytes):
+        self.session = session
+        self.sockets = sockets
+        self.key = key
+        self.id = uuid.uuid4().hex
+        self.execution_count = 0
+        self.stopped = False
+        self._handlers = {
+            "kernel_info_request": self._kernel_info,
+            "execute_request": self._execute,
+            "is_complete_request": lambda message: {"status": "complete"},
+            "complete_request": self._complete,
+            "inspect_request": lambda message: {"status": "ok", "found": False, "data": {}, "metadata": {}},
+            "history_request": lambda message: {"status": "ok", "history": []},
+            "comm_info_request": lambda message: {"status": "ok", "comms": {}},
+            "interrupt_request": lambda message: {"status": "ok"},
+            "shutdown_request": self._shutdown,
+        }
+
+    def handle(self, channel: str, frames: list[bytes]) -> None:
+        """Handle one message received on the shell or control channel."""
+        try:
+            identities, message = deserialize(frames, self.key)
+        except ValueError as exc:
+            print(f"english-compiler kernel: dropped message: {exc}", file=sys.stderr)
+            return
+        msg_type = message["header"].get("msg_type", "")
+        handler = self._handlers.get(msg_type)
+        if handler is None:
+            return
+        self.publish("status", {"execution_state": "busy"}, message)
+        try:
+            reply = handler(message)
+            self.send(channel, msg_type.replace("_request", "_reply"), reply, message, identities)
+        finally:
+            self.publish("status", {"execution_state": "idle"}, message)
+
+    def publish(self, msg_type: str, content: dict, parent: dict) -> None:
+        topic = [f"kernel.{self.id}.{msg_type}".encode("ascii")]
+        self._write("iopub", new_message(msg_type, content, self.id, parent), topic)
+
+    def send(self, channel: str, msg_type: str, content: dict, parent: dict, identities: list[bytes]) -> None:
+        self._write(channel, new_message(msg_type, content, self.id, parent), identities)
+
+    def _write(self, channel: str, message: dict, identities: list[bytes]) -> None:
+        self.sockets[channel].send_multipart(serialize(message, self.key, identities))
+
+    # Requests
+
+    def _kernel_info(self, message: dict) -> dict:
+        return {
+            "status": "ok",
+            "protocol_version": PROTOCOL_VERSION,
+            "implementation": "english-compiler",
+            "implementation_version": PACKAGE_VERSION,
+            "language_info": {
+                "name": KERNEL_NAME,
+                "version": PACKAGE_VERSION,
+                "mimetype": "text/plain",
+                "file_extension": ".txt",
+            },
+            "banner": f"English Compiler {PACKAGE_VERSION}: cells are English, run by the Go runtime",
+            "help_links": [],
+        }
+
+    def _execute(self, parent: dict) -> dict:
+        content = parent["content"]
+        code = content.get("code", "")
+        silent = content.get("silent", False)
+        if content.get("store_history", True) and not silent:
+            self.execution_count += 1
+        count = self.execution_count
+        if not silent:
+            self.publish("execute_input", {"code": code, "execution_count": count}, parent)
+
+        result = self.session.execute(code)
+        if not silent:
+            for name in ("stdout", "stderr"):
+                if getattr(result, name):
+                    self.publish("stream", {"name": name, "text": getattr(result, name)}, parent)
+            if result.data is not None:
+                self.publish(
+                    "execute_result",
+                    {"execution_count": count, "data": result.data, "metadata": {}},
+                    parent,
+                )
+        if result.error is not None:
+            ename, evalue, traceback = result.error
+            error = {"ename": ename, "evalue": evalue, "traceback": traceback}
+            if not silent:
+                self.publish("error", error, parent)
+            return {"status": "error", "execution_count": count, **error}
+        return {"status": "ok", "execution_count": count, "user_expressions": {}, "payload": []}
+
+    def _complete(self, message: dict) -> dict:
+        cursor = message["content"].get("cursor_pos", 0)
+        return {"status": "ok", "matches": [], "cursor_start": cursor, "cursor_end": cursor, "metadata": {}}
+
+    def _shutdown(self, message: dict) -> dict:
+        self.stopped = True
+        return {"status": "ok", "restart": bool(message["content"].get("restart", False))}
+
+
+def serve(connection_file: Path, frontend: Any, grants: list[str] | None = None) -> int:
+    """Run the kernel described by a Jupyter connection file until it is shut down.
+
+    Cells may only use the capabilities in grants (see emit_go).
+    """
+    import zmq
+
+    config = json.loads(Path(connection_file).read_text(encoding="utf-8"))
+    if config.get("signature_scheme", "hmac-sha256") != "hmac-sha256":
+        print(f"unsupported signature scheme: {config['signature_scheme']}", file=sys.stderr)
+        return 1
+    key = config.get("key", "").encode("utf-8")
+    context = zmq.Context.instance()
+
+    def address(name: str) -> str:
+        return f"{config['transport']}://{config['ip']}:{config[f'{name}_port']}"
+
+    sockets = {}
+    for name, kind in (("shell", zmq.ROUTER), ("control", zmq.ROUTER), ("stdin", zmq.ROUTER), ("iopub", zmq.PUB)):
+        sockets[name] = context.socket(kind)
+        sockets[name].bind(address(name))
+    heartbeat = context.socket(zmq.REP)
+    heartbeat.bind(address("hb"))
+    # The heartbeat echoes pings on its own thread, so it keeps answering
+    # while a cell runs
+    threading.Thread(target=_echo, args=(heartbeat,), daemon=True).start()
+
+    kernel = Kernel(NotebookSession(frontend, grants=list(grants or [])), sockets, key)
+    poller = zmq.Poller()
+    for name in ("shell", "control"):
+        poller.register(sockets[name], zmq.POLLIN)
+    # Anything a frontend prints would otherwise go to the kernel's stdout,
+    # which Jupyter shows only in its log
+    sys.stdout = sys.stderr
+    while not kernel.stopped:
+        try:
+            ready = dict(poller.poll())
+        except KeyboardInterrupt:
+            continue  # an interrupt with no cell running
+        for name in ("control", "shell"):
+            if sockets[name] in ready:
+                kernel.handle(name, sockets[name].recv_multipart())
+    for sock in sockets.values():
+        sock.close(linger=1000)
+    return 0
+
+
+def _echo(sock: Any) -> None:
+    import zmq
+
+    try:
+        while True:
+            sock.send(sock.recv())
+    except zmq.ZMQError:
+        pass
+
+
+# ---------------------------------------------------------------------------
+# Kernel spec
+# ---------------------------------------------------------------------------
+
+def jupyter_data_dir() -> Path:
+    """Jupyter's per-user data directory, honoring JUPYTER_DATA_DIR."""
+    override = os.environ.get("JUPYTER_DATA_DIR")
+    if override:
+        return Path(override)
+    if sys.platform == "darwin":
+        return Path.home() / "Library" / "Jupyter"
+    if sys.platform == "win32":
+        return Path(os.environ.get("APPDATA", Path.home())) / "jupyter"
+    data_home = os.environ.get("XDG_DATA_HOME") or Path.home() / ".local" / "share"
+    return Path(data_home) / "jupyter"
+
+
+def install_kernel_spec(
+    frontend: str | None = None,
+    *,
+    prefix: Path | None = None,
+    grants: list[str] | None = None,
+) -> Path:
+    """Register the kernel with Jupyter, returning the kernel spec directory.
+
+    Installs for the current user, or under prefix (e.g. sys.prefix for a
+    virtual environment). The kernel runs with this Python interpreter, and
+    its cells may only use the capabilities in grants.
+    """
+    data_dir = Path(prefix) / "share" / "jupyter" if prefix is not None else jupyter_data_dir()
+    spec_dir = data_dir / "kernels" / KERNEL_NAME
+    spec_dir.mkdir(parents=True, exist_ok=True)
+    argv = [sys.executable, "-m", "english_compiler", "kernel", "-f", "{connection_file}"]
+    if frontend is not None:
+        argv += ["--frontend", frontend]
+    for grant in grants or []:
+        argv += ["--grant", grant]
+    spec = {"argv": argv, "display_name": "English", "language": KERNEL_NAME, "interrupt_mode": "signal"}
+    (spec_dir / "kernel.json").write_text(json.dumps(spec, indent=2) + "\n", encoding="utf-8")
+    return spec_dir
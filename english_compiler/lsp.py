@@ -0,0 +1,566 @@
+"""Language server for English source files.
+
+`english-compiler lsp` speaks the Language Server Protocol over stdin and
+stdout, so any LSP client (VS Code, Neovim, Helix, ...) gets tooling for
+English programs:
+
+- Diagnostics: a file is compiled whenever it is opened or saved, through
+  the frontend's verification and repair loop, and the violations left are
+  published on the sentences they came from, in English (see
+  coreil.diagnostics). Compiling reuses the Core IL `compile` locked for
+  the same source and the frontend cache, so an unchanged file costs no
+  LLM call, and it never writes files.
+- Hover: the Core IL compiled from the sentence under the cursor.
+- Go to definition and find references: from a sentence that uses a named
+  quantity (a variable or function) to the sentence that defines it, and
+  from either to every sentence that mentions it.
+
+Files are only compiled on open and save, not as you type, since changed
+text may need an LLM call; hover and navigation use the last compilation.
+Compilations run on a worker thread, so requests are answered meanwhile.
+
+Only the standard library is used: messages are JSON-RPC 2.0 with
+Content-Length framing. Columns are counted in characters, which matches
+the protocol's UTF-16 positions for all text outside the astral planes.
+"""
+
+from __future__ import annotations
+
+import json
+import re
+import sys
+import threading
+from concurrent.futures import Future, ThreadPoolExecutor
+from contextlib import redirect_stdout
+from dataclasses import dataclass, field
+from pathlib import Path
+from typing import Any, BinaryIO, Callable
+from urllib.parse import unquote, urlparse
+
+from english_compiler.coreil.canonical import canonicalize
+from english_compiler.coreil.diagnostics import explain_violations
+from english_compiler.coreil.node_nav import is_coreil_node, iter_nodes
+from english_compiler.coreil.source_map import SentenceLocation, document_sentences, split_sentences
+from english_compiler.coreil.verify import verify_coreil
+
+SERVER_NAME = "english-compiler"
+
+# JSON-RPC error codes
+_METHOD_NOT_FOUND = -32601
+_INTERNAL_ERROR = -32603
+_INVALID_REQUEST = -32600  # a request after shutdown
+
+_SEVERITY_ERROR = 1
+_TEXT_SYNC_FULL = 1
+
+_WORD = re.compile(r"[A-Za-z_][A-Za-z0-9_]*")
+_BODY_INDEX = re.compile(r"^\$\.body\[(\d+)\]")
+
+
+# ---------------------------------------------------------------------------
+# Transport
+# ---------------------------------------------------------------------------
+
+def read_message(stream: BinaryIO) -> dict | None:
+    """Read one message, or None at end of input."""
+    length = None
+    while True:
+        line = stream.readline()
+        if not line:
+            return None
+        line = line.strip()
+        if not line:
+            break
+        name, _, value = line.decode("ascii").partition(":")
+        if name.strip().lower() == "content-length":
+            length = int(value)
+    if length is None:
+        return None
+    return json.loads(stream.read(length).decode("utf-8"))
+
+
+def write_message(stream: BinaryIO, message: dict) -> None:
+    """Write one message with its Content-Length header."""
+    body = json.dumps(message, separators=(",", ":")).encode("utf-8")
+    stream.write(f"Content-Length: {len(body)}\r\n\r\n".encode("ascii") + body)
+    stream.flush()
+
+
+# ---------------------------------------------------------------------------
+# Compilation
+# ---------------------------------------------------------------------------
+
+@dataclass
+class Compilation:
+    """The result of compiling one version of a file."""
+    text: str
+    coreil: dict | None
+    violations: list[dict] = field(default_factory=list)
+    error: str | None = None  # why there is no Core IL at all
+
+
+def compile_source(source_path: Path | None, text: str, frontend: Any) -> Compilation:
+    """Compile English text the way `compile` does, without writing files.
+
+    Uses the Core IL locked next to source_path if it was generated from
+    this text, then the frontend cache, and only then the frontend. Output
+    that still fails verification after the repair loop is returned with
+    its violations.
+    """
+    from english_compiler.frontend.base import BaseFrontend, RepairError
+    from english_compiler.frontend.cache import CoreILCache
+
+    if source_path is not None:
+        doc = _locked_coreil(source_path, text)
+        if doc is not None:
+            return Compilation(text, doc, verify_coreil(doc))
+
+    cache = CoreILCache.from_env() if isinstance(frontend, BaseFrontend) else None
+    key = cache.key(text, frontend.get_model_name()) if cache else None
+    hit = cache.get(key) if cache else None
+    if hit is not None:
+        return Compilation(text, hit.coreil, verify_coreil(hit.coreil))
+    try:
+        doc = frontend.generate_coreil_from_text(text)
+    except RepairError as exc:
+        return Compilation(text, exc.coreil, exc.violations)
+    except RuntimeError as exc:
+        return Compilation(text, None, error=f"Frontend error: {exc}")
+    doc = canonicalize(doc)
+    if cache:
+        cache.put(key, doc)
+    return Compilation(text, doc, verify_coreil(doc))
+
+
+def _locked_coreil(source_path: Path, text: str) -> dict | None:
+    """The Core IL `compile` wrote for source_path, if its lock file matches text."""
+    from english_compiler.cli.io_utils import load_json, sha256_bytes, sha256_file
+
+    output_dir = source_path.parent / "output" / "coreil"
+    coreil_path = output_dir / f"{source_path.stem}.coreil.json"
+    lock = load_json(output_dir / f"{source_path.stem}.lock.json")
+    if lock is None or lock.get("source_sha256") != sha256_bytes(text.encode("utf-8")):
+        return None
+    try:
+        if lock.get("coreil_sha256") != sha256_file(coreil_path):
+            return None
+    except OSError:
+        return None
+    return load_json(coreil_path)
+
+
+# ---------------------------------------------------------------------------
+# Language features
+# ---------------------------------------------------------------------------
+
+def publish_diagnostics(uri: str, compilation: Compilation) -> list[dict]:
+    """LSP diagnostics for a compilation: one per violation, on its sentence."""
+    if compilation.error is not None:
+        return [_diagnostic(_line_range(0), compilation.error)]
+    doc = compilation.coreil or {}
+    sentences = document_sentences(doc, compilation.text) if isinstance(doc, dict) else {}
+    explained = explain_violations(doc, compilation.violations, source_text=compilation.text)
+    diagnostics = []
+    for violation, english in zip(compilation.violations, explained):
+        match = _BODY_INDEX.match(violation["path"])
+        sentence = sentences.get(int(match.group(1))) if match else None
+        if sentence is not None:
+            where = _sentence_range(sentence)
+        else:
+            where = _line_range(violation.get("line", 1) - 1)
+        if english is None:
+            diagnostics.append(_diagnostic(where, violation["message"], violation["path"]))
+            continue
+        diagnostic = _diagnostic(where, english.message, violation["path"])
+        related = [s for s in english.sentences if s != sentence]
+        if related:
+            diagnostic["relatedInformation"] = [
+                {
+                    "location": {"uri": uri, "range": _sentence_range(s)},
+                    "message": f"sentence {s.sentence_id}: {s.text}",
+                }
+                for s in related
+            ]
+        diagnostics.append(diagnostic)
+    return diagnostics
+
+
+def hover(compilation: Compilation, line: int, character: int) -> dict | None:
+    """The Core IL of the sentence at a 0-based position, as a hover result."""
+    sentence = _sentence_at(compilation.text, line, character)
+    if sentence is None or not isinstance(compilation.coreil, dict):
+        return None
+    attributed = document_sentences(compilation.coreil, compilation.text)
+    body = compilation.coreil.get("body", [])
+    indices = [i for i, s in sorted(attributed.items()) if s.sentence_id == sentence.sentence_id and i < len(body)]
+    if not indices:
+        return None
+    snippets = "\n".join(json.dumps(body[i], indent=2) for i in indices)
+    return {
+        "contents": {
+            "kind": "markdown",
+            "value": f"Sentence {sentence.sentence_id} compiles to:\n\n```json\n{snippets}\n```",
+        },
+        "range": _sentence_range(sentence),
+    }
+
+
+def definition(uri: str, compilation: Compilation, line: int, character: int) -> list[dict]:
+    """Where the quantity named at a 0-based position is defined."""
+    found = _quantity_at(compilation, line, character)
+    if found is None:
+        return []
+    word, name, index = found
+    defining = index.definitions.get(name, [])
+    return [_location(uri, compilation.text, s, word) for s in defining[:1]]
+
+
+def references(uri: str, compilation: Compilation, line: int, character: int) -> list[dict]:
+    """Every sentence that defines or uses the quantity named at a 0-based position."""
+    found = _quantity_at(compilation, line, character)
+    if found is None:
+        return []
+    word, name, index = found
+    mentions = {s.sentence_id: s for s in index.definitions.get(name, []) + index.uses.get(name, [])}
+    return [_location(uri, compilation.text, mentions[k], word) for k in sorted(mentions)]
+
+
+@dataclass
+class _QuantityIndex:
+    """The sentences defining and using each variable and function name."""
+    definitions: dict[str, list[SentenceLocation]] = field(default_factory=dict)
+    uses: dict[str, list[SentenceLocation]] = field(default_factory=dict)
+
+    def add(self, table: dict[str, list[SentenceLocation]], name: Any, sentence: SentenceLocation) -> None:
+        if isinstance(name, str) and sentence not in table.setdefault(name, []):
+            table[name].append(sentence)
+
+
+def _index_quantities(doc: dict, text: str) -> tuple[_QuantityIndex, dict[int, SentenceLocation]]:
+    sentences = document_sentences(doc, text)
+    index = _QuantityIndex()
+    body = doc.get("body", [])
+    assigned: dict[str, list[SentenceLocation]] = {}
+    for i, stmt in enumerate(body):
+        sentence = sentences.get(i)
+        if sentence is None or not is_coreil_node(stmt):
+            continue
+        for node in iter_nodes(stmt):
+            kind = node["type"]
+            if kind in ("Let", "FuncDef"):
+                index.add(index.definitions, node.get("name"), sentence)
+            elif kind == "Assign":
+                index.add(assigned, node.get("name"), sentence)
+            elif kind in ("For", "ForEach"):
+                index.add(index.definitions, node.get("var"), sentence)
+            elif kind in ("Var", "Call"):
+                index.add(index.uses, node.get("name"), sentence)
+            if kind == "FuncDef":
+                for param in node.get("params") or []:
+                    index.add(index.definitions, param, sentence)
+    # A name that is only ever assigned is defined by its first assignment
+    for name, where in assigned.items():
+        if name not in index.definitions:
+            index.definitions[name] = where[:1]
+        for sentence in where:
+            index.add(index.uses, name, sentence)
+    for table in (index.definitions, index.uses):
+        for where in table.values():
+            where.sort(key=lambda s: s.sentence_id)
+    return index, sentences
+
+
+def _quantity_at(
+    compilation: Compilation, line: int, character: int
+) -> tuple[str, str, _QuantityIndex] | None:
+    """(English word, Core IL name, index) for the word at a 0-based position.
+
+    The word matches a name used or defined in its sentence exactly or as
+    one of the words of a snake_case name ("total" in "total_price").
+    """
+    if not isinstance(compilation.coreil, dict):
+        return None
+    sentence = _sentence_at(compilation.text, line, character)
+    word = _word_at(compilation.text, line, character)
+    if sentence is None or word is None:
+        return None
+    index, _ = _index_quantities(compilation.coreil, compilation.text)
+    names = [
+        name
+        for table in (index.definitions, index.uses)
+        for name, where in table.items()
+        if any(s.sentence_id == sentence.sentence_id for s in where)
+    ]
+    lowered = word.lower()
+    for name in sorted(set(names), key=lambda n: (n.lower() != lowered, n)):
+        if name.lower() == lowered or lowered in name.lower().split("_"):
+            return word, name, index
+    return None
+
+
+def _word_at(text: str, line: int, character: int) -> str | None:
+    lines = text.split("\n")
+    if not 0 <= line < len(lines):
+        return None
+    for match in _WORD.finditer(lines[line]):
+        if match.start() <= character <= match.end():
+            return match.group()
+    return None
+
+
+def _sentence_at(text: str, line: int, character: int) -> SentenceLocation | None:
+    position = (line + 1, character + 1)
+    for sentence in split_sentences(text):
+        if (sentence.line, sentence.column) <= position <= (sentence.end_line, sentence.end_column + 1):
+            return sentence
+    return None
+
+
+def _location(uri: str, text: str, sentence: SentenceLocation, word: str) -> dict:
+    """The word's first whole-word occurrence in sentence, or the whole sentence."""
+    lines = text.split("\n")
+    for number in range(sentence.line, sentence.end_line + 1):
+        line_text = lines[number - 1]
+        start = sentence.column - 1 if number == sentence.line else 0
+        end = sentence.end_column if number == sentence.end_line else len(line_text)
+        match = re.search(rf"\b{re.escape(word)}\b", line_text[start:end], re.IGNORECASE)
+        if match:
+            return {
+                "uri": uri,
+                "range": {
+                    "start": {"line": number - 1, "character": start + match.start()},
+                    "end": {"line": number - 1, "character": start + match.end()},
+                },
+            }
+    return {"uri": uri, "range": _sentence_range(sentence)}
+
+
+def _sentence_range(sentence: SentenceLocation) -> dict:
+    return {
+        "start": {"line": sentence.line - 1, "character": sentence.column - 1},
+        "end": {"line": sentence.end_line - 1, "character": sentence.end_column},
+    }
+
+
+def _line_range(line: int) -> dict:
+    line = max(line, 0)
+    return {"start": {"line": line, "character": 0}, "end": {"line": line + 1, "character": 0}}
+
+
+def _diagnostic(where: dict, message: str, code: str | None = None) -> dict:
+    diagnostic = {"range": where, "severity": _SEVERITY_ERROR, "source": SERVER_NAME, "message": message}
+    if code is not None:
+        diagnostic["code"] = code
+    return diagnostic
+
+
+# ---------------------------------------------------------------------------
+# Server
+# ---------------------------------------------------------------------------
+
+class LanguageServer:
+    """Handles the messages of one client.
+
+    frontend_factory is called once, on the first compilation; if it
+    raises RuntimeError (say, no API key), the error is published as a
+    diagnostic and it is tried again on the next save. With
+    background=False compilations run before handle() returns, for tests.
+    """
+
+    def __init__(
+        self,
+        frontend_factory: Callable[[], Any],
+        out: BinaryIO,
+        *,
+        background: bool = True,
+    ):
+        self._frontend_factory = frontend_factory
+        self._frontend = None
+        self._out = out
+        self._write_lock = threading.Lock()
+        self._executor = ThreadPoolExecutor(max_workers=1) if background else None
+        self._pending: list[Future] = []
+        self.documents: dict[str, str] = {}
+        self.compilations: dict[str, Compilation] = {}
+        self.shutdown_requested = False
+        self.exited = False
+        self._requests = {
+            "initialize": self._initialize,
+            "shutdown": self._shutdown,
+            "textDocument/hover": self._hover,
+            "textDocument/definition": self._definition,
+            "textDocument/references": self._references,
+        }
+        self._notifications = {
+            "exit": self._exit,
+            "textDocument/didOpen": self._did_open,
+            "textDocument/didChange": self._did_change,
+            "textDocument/didSave": self._did_save,
+            "textDocument/didClose": self._did_close,
+        }
+
+    def handle(self, message: dict) -> None:
+        method = message.get("method")
+        params = message.get("params") or {}
+        if "id" not in message:
+            handler = self._notifications.get(method)
+            if handler is not None:
+                handler(params)
+            return
+        if method is None:
+            return  # a response to a request we never send
+        if self.shutdown_requested and method != "shutdown":
+            self._send_error(message["id"], _INVALID_REQUEST, "server is shutting down")
+            return
+        handler = self._requests.get(method)
+        if handler is None:
+            self._send_error(message["id"], _METHOD_NOT_FOUND, f"unsupported method: {method}")
+            return
+        try:
+            result = handler(params)
+        except Exception as exc:
+            self._send_error(message["id"], _INTERNAL_ERROR, str(exc))
+            return
+        self._send({"jsonrpc": "2.0", "id": message["id"], "result": result})
+
+    def wait(self) -> None:
+        """Wait for the compilations already started to finish."""
+        for future in self._pending:
+            future.result()
+        self._pending = [f for f in self._pending if not f.done()]
+
+    def close(self) -> None:
+        if self._executor is not None:
+            self._executor.shutdown(wait=False, cancel_futures=True)
+
+    # Lifecycle
+
+    def _initialize(self, params: dict) -> dict:
+        from english_compiler.coreil.versions import PACKAGE_VERSION
+
+        return {
+            "capabilities": {
+                "textDocumentSync": {"openClose": True, "change": _TEXT_SYNC_FULL, "save": {"includeText": True}},
+                "hoverProvider": True,
+                "definitionProvider": True,
+                "referencesProvider": True,
+            },
+            "serverInfo": {"name": SERVER_NAME, "version": PACKAGE_VERSION},
+        }
+
+    def _shutdown(self, params: dict) -> None:
+        # Let compilations in progress publish their diagnostics first
+        self.wait()
+        self.shutdown_requested = True
+
+    def _exit(self, params: dict) -> None:
+        self.exited = True
+
+    # Documents
+
+    def _did_open(self, params: dict) -> None:
+        document = params["textDocument"]
+        self.documents[document["uri"]] = document["text"]
+        self._compile(document["uri"])
+
+    def _did_change(self, params: dict) -> None:
+        changes = params.get("contentChanges") or []
+        if changes:
+            self.documents[params["textDocument"]["uri"]] = changes[-1]["text"]
+
+    def _did_save(self, params: dict) -> None:
+        uri = params["textDocument"]["uri"]
+        if "text" in params:
+            self.documents[uri] = params["text"]
+        if uri in self.documents:
+            self._compile(uri)
+
+    def _did_close(self, params: dict) -> None:
+        uri = params["textDocument"]["uri"]
+        self.documents.pop(uri, None)
+        self.compilations.pop(uri, None)
+        self._publish(uri, [])
+
+    def _compile(self, uri: str) -> None:
+        text = self.documents[uri]
+        if self._executor is None:
+            self._compile_now(uri, text)
+        else:
+            self._pending = [f for f in self._pending if not f.done()]
+            self._pending.append(self._executor.submit(self._compile_now, uri, text))
+
+    def _compile_now(self, uri: str, text: str) -> None:
+        if self._frontend is None:
+            try:
+                self._frontend = self._frontend_factory()
+            except RuntimeError as exc:
+                compilation = Compilation(text, None, error=str(exc))
+        if self._frontend is not None:
+            compilation = compile_source(_uri_path(uri), text, self._frontend)
+        if self.documents.get(uri) is None:
+            return  # closed meanwhile
+        self.compilations[uri] = compilation
+        self._publish(uri, publish_diagnostics(uri, compilation))
+
+    # Language features
+
+    def _hover(self, params: dict) -> dict | None:
+        compilation, line, character = self._at(params)
+        return hover(compilation, line, character) if compilation else None
+
+    def _definition(self, params: dict) -> list[dict]:
+        compilation, line, character = self._at(params)
+        return definition(params["textDocument"]["uri"], compilation, line, character) if compilation else []
+
+    def _references(self, params: dict) -> list[dict]:
+        compilation, line, character = self._at(params)
+        return references(params["textDocument"]["uri"], compilation, line, character) if compilation else []
+
+    def _at(self, params: dict) -> tuple[Compilation | None, int, int]:
+        position = params["position"]
+        return self.compilations.get(params["textDocument"]["uri"]), position["line"], position["character"]
+
+    # Output
+
+    def _publish(self, uri: str, diagnostics: list[dict]) -> None:
+        self._send({
+            "jsonrpc": "2.0",
+            "method": "textDocument/publishDiagnostics",
+            "params": {"uri": uri, "diagnostics": diagnostics},
+        })
+
+    def _send_error(self, request_id: Any, code: int, message: str) -> None:
+        self._send({"jsonrpc": "2.0", "id": request_id, "error": {"code": code, "message": message}})
+
+    def _send(self, message: dict) -> None:
+        with self._write_lock:
+            write_message(self._out, message)
+
+
+def _uri_path(uri: str) -> Path | None:
+    parsed = urlparse(uri)
+    if parsed.scheme != "file":
+        return None
+    return Path(unquote(parsed.path))
+
+
+def serve(
+    frontend_factory: Callable[[], Any],
+    stdin: BinaryIO | None = None,
+    stdout: BinaryIO | None = None,
+) -> int:
+    """Serve one client until it exits; returns 0 if it shut down first, as the protocol asks."""
+    stdin = stdin or sys.stdin.buffer
+    stdout = stdout or sys.stdout.buffer
+    server = LanguageServer(frontend_factory, stdout)
+    # Anything a frontend prints would corrupt the protocol stream
+    with redirect_stdout(sys.stderr):
+        try:
+            while not server.exited:
+                message = read_message(stdin)
+                if message is None:
+                    break
+                server.handle(message)
+        finally:
+            server.close()
+    return 0 if server.shutdown_requested else 1
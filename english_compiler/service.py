@@ -0,0 +1,582 @@
+"""Evaluation service: run English and Core IL programs for remote clients.
+
+`english-compiler serve --grpc ADDRESS` serves the englishcompiler.v1.
+Evaluator gRPC service described in evaluator.proto, with two streaming
+RPCs:
+
+- CompileAndRun compiles English source with the server's frontend (and
+  its cache), then runs the result.
+- Eval runs a Core IL document.
+
+Every request runs in its own process on the Go runtime, under the limits
+it asks for, capped by the server's (see ServerLimits): steps, memory and
+collection size are enforced by the runtime (COREIL_MAX_*), time by both
+the runtime and a wall-clock kill, and output here. Output streams back as
+it is printed. The final Result carries the value the program's last
+statement printed, if it printed one, in protobuf form (see coreil.display).
+
+The gRPC transport needs grpcio (pip install english-compiler[grpc]). The
+protobuf messages are encoded here with the standard library, from the
+schema in _SCHEMA, so no generated code or protobuf package is needed;
+messages are dicts keyed by field name, with enums by value name.
+"""
+
+from __future__ import annotations
+
+import json
+import os
+import queue
+import struct
+import subprocess
+import sys
+import tempfile
+import threading
+import time
+from dataclasses import dataclass, fields
+from pathlib import Path
+from typing import IO, Any, Callable, Iterator
+
+from english_compiler.coreil.diagnostics import format_diagnostics
+from english_compiler.coreil.display import read_display, result_record
+from english_compiler.coreil.module import CircularImportError, ModuleNotFoundError, resolve_imports
+from english_compiler.coreil.verify import verify_coreil
+
+SERVICE_NAME = "englishcompiler.v1.Evaluator"
+
+# How long past its timeout a program may take to stop by itself before it
+# is killed
+_TIMEOUT_GRACE = 1.0
+# The tail of stderr kept to explain a failure
+_STDERR_TAIL = 64 * 1024
+
+
+def is_grpc_available() -> bool:
+    """Check if the grpcio library is installed."""
+    try:
+        import grpc  # noqa: F401
+        return True
+    except ImportError:
+        return False
+
+
+# ---------------------------------------------------------------------------
+# Protobuf encoding
+# ---------------------------------------------------------------------------
+
+# Each message's fields: (number, name, type, label). Types are scalar
+# names, "enum:<Enum>" or message names; labels are "" (singular),
+# "repeated", "optional" or "oneof" (the last two are always encoded when
+# present, even if zero).
+_SCHEMA: dict[str, list[tuple[int, str, str, str]]] = {
+    "CompileAndRunRequest": [
+        (1, "source", "string", ""),
+        (2, "argv", "string", "repeated"),
+        (3, "limits", "Limits", ""),
+        (4, "seed", "int64", "optional"),
+    ],
+    "EvalRequest": [
+        (1, "coreil_json", "string", ""),
+        (2, "argv", "string", "repeated"),
+        (3, "limits", "Limits", ""),
+        (4, "seed", "int64", "optional"),
+    ],
+    "Limits": [
+        (1, "max_steps", "int64", ""),
+        (2, "max_memory_bytes", "int64", ""),
+        (3, "max_collection_size", "int64", ""),
+        (4, "timeout_seconds", "double", ""),
+        (5, "max_output_bytes", "int64", ""),
+    ],
+    "RunEvent": [
+        (1, "output", "Output", "oneof"),
+        (2, "compiled", "Compiled", "oneof"),
+        (3, "result", "Result", "oneof"),
+    ],
+    "Output": [
+        (1, "stream", "enum:Output.Stream", ""),
+        (2, "data", "bytes", ""),
+    ],
+    "Compiled": [
+        (1, "coreil_json", "string", ""),
+    ],
+    "Result": [
+        (1, "status", "enum:Result.Status", ""),
+        (2, "exit_code", "int32", ""),
+        (3, "error", "string", ""),
+        (4, "value", "Value", ""),
+        (5, "output_bytes", "int64", ""),
+        (6, "elapsed_seconds", "double", ""),
+    ],
+    "Value": [
+        (1, "none", "bool", "oneof"),
+        (2, "int", "int64", "oneof"),
+        (3, "float", "double", "oneof"),
+        (4, "bool", "bool", "oneof"),
+        (5, "str", "string", "oneof"),
+        (6, "array", "List", "oneof"),
+        (7, "tuple", "List", "oneof"),
+        (8, "map", "Map", "oneof"),
+        (9, "record", "Record", "oneof"),
+        (10, "other", "Other", "oneof"),
+    ],
+    "List": [
+        (1, "items", "Value", "repeated"),
+        (2, "length", "int64", ""),
+    ],
+    "Map": [
+        (1, "keys", "Value", "repeated"),
+        (2, "values", "Value", "repeated"),
+        (3, "length", "int64", ""),
+    ],
+    "Record": [
+        (1, "fields", "string", "repeated"),
+        (2, "values", "Value", "repeated"),
+    ],
+    "Other": [
+        (1, "type", "string", ""),
+        (2, "text", "string", ""),
+    ],
+}
+
+_ENUMS: dict[str, list[str]] = {
+    "Output.Stream": ["STDOUT", "STDERR"],
+    "Result.Status": [
+        "OK",
+        "COMPILE_ERROR",
+        "INVALID_PROGRAM",
+        "RUNTIME_ERROR",
+        "LIMIT_EXCEEDED",
+        "INTERNAL_ERROR",
+    ],
+}
+
+_VARINT_TYPES = {"int64", "int32", "bool"}
+_DEFAULTS = {"int64": 0, "int32": 0, "bool": False, "double": 0.0, "string": "", "bytes": b""}
+
+
+def encode_message(type_name: str, message: dict) -> bytes:
+    """The protobuf encoding of message, a dict of type_name's fields."""
+    out = bytearray()
+    for number, name, kind, label in _SCHEMA[type_name]:
+        if name not in message:
+            continue
+        values = message[name] if label == "repeated" else [message[name]]
+        for value in values:
+            if label == "" and kind in _DEFAULTS and value == _DEFAULTS[kind]:
+                continue  # proto3 leaves out default scalars
+            if label == "" and kind.startswith("enum:") and _enum_number(kind, value) == 0:
+                continue
+            out += _encode_field(number, kind, value)
+    return bytes(out)
+
+
+def _encode_field(number: int, kind: str, value: Any) -> bytes:
+    if kind in _VARINT_TYPES:
+        return _varint(number << 3) + _varint(int(value))
+    if kind.startswith("enum:"):
+        return _varint(number << 3) + _varint(_enum_number(kind, value))
+    if kind == "double":
+        return _varint(number << 3 | 1) + struct.pack("<d", float(value))
+    if kind == "string":
+        data = value.encode("utf-8")
+    elif kind == "bytes":
+        data = bytes(value)
+    else:
+        data = encode_message(kind, value)
+    return _varint(number << 3 | 2) + _varint(len(data)) + data
+
+
+def _enum_number(kind: str, value: str | int) -> int:
+    return value if isinstance(value, int) else _ENUMS[kind[len("enum:"):]].index(value)
+
+
+def _varint(value: int) -> bytes:
+    value &= (1 << 64) - 1  # negative numbers take ten bytes, as two's complement
+    out = bytearray()
+    while True:
+        byte = value & 0x7F
+        value >>= 7
+        if value:
+            out.append(byte | 0x80)
+        else:
+            out.append(byte)
+            return bytes(out)
+
+
+def decode_message(type_name: str, data: bytes) -> dict:
+    """Decode a type_name message; unset singular scalars get their defaults.
+
+    Unknown fields are skipped. Raises ValueError on malformed input.
+    """
+    schema = {number: (name, kind, label) for number, name, kind, label in _SCHEMA[type_name]}
+    message: dict[str, Any] = {}
+    for number, name, kind, label in _SCHEMA[type_name]:
+        if label == "repeated":
+            message[name] = []
+        elif label == "" and kind in _DEFAULTS:
+            message[name] = _DEFAULTS[kind]
+        elif label == "" and kind.startswith("enum:"):
+            message[name] = _ENUMS[kind[len("enum:"):]][0]
+    pos = 0
+    while pos < len(data):
+        key, pos = _read_varint(data, pos)
+        number, wire_type = key >> 3, key & 7
+        if wire_type == 0:
+            raw, pos = _read_varint(data, pos)
+        elif wire_type == 1:
+            raw, pos = data[pos:pos + 8], pos + 8
+        elif wire_type == 2:
+            length, pos = _read_varint(data, pos)
+            raw, pos = data[pos:pos + length], pos + length
+        elif wire_type == 5:
+            raw, pos = data[pos:pos + 4], pos + 4
+        else:
+            raise ValueError(f"unsupported wire type {wire_type}")
+        if pos > len(data):
+            raise ValueError("truncated message")
+        if number not in schema:
+            continue
+        name, kind, label = schema[number]
+        value = _decode_value(kind, raw)
+        if label == "oneof":
+            for other, (other_name, _, other_label) in schema.items():
+                if other_label == "oneof" and other != number:
+                    message.pop(other_name, None)
+        if label == "repeated":
+            message[name].append(value)
+        else:
+            message[name] = value
+    return message
+
+
+def _decode_value(kind: str, raw: int | bytes) -> Any:
+    if kind in ("int64", "int32"):
+        return raw - (1 << 64) if raw >= 1 << 63 else raw
+    if kind == "bool":
+        return bool(raw)
+    if kind.startswith("enum:"):
+        names = _ENUMS[kind[len("enum:"):]]
+        return names[raw] if raw < len(names) else raw
+    if kind == "double":
+        return struct.unpack("<d", raw)[0]
+    if kind == "string":
+        return bytes(raw).decode("utf-8")
+    if kind == "bytes":
+        return bytes(raw)
+    return decode_message(kind, bytes(raw))
+
+
+def _read_varint(data: bytes, pos: int) -> tuple[int, int]:
+    value = shift = 0
+    while True:
+        if pos >= len(data):
+            raise ValueError("truncated varint")
+        byte = data[pos]
+        pos += 1
+        value |= (byte & 0x7F) << shift
+        if not byte & 0x80:
+            return value, pos
+        shift += 7
+
+
+def value_message(display: dict) -> dict:
+    """A Value message for a value as the Go runtime encodes it for COREIL_DISPLAY."""
+    kind, text = display.get("type"), display.get("text")
+    if "items" in display and kind in ("array", "tuple"):
+        items = [value_message(item) for item in display["items"]]
+        return {kind: {"items": items, "length": display.get("length", len(items))}}
+    if "keys" in display:
+        return {"map": {
+            "keys": [value_message(key) for key in display["keys"]],
+            "values": [value_message(value) for value in display["values"]],
+            "length": display.get("length", len(display["keys"])),
+        }}
+    if "fields" in display:
+        return {"record": {
+            "fields": list(display["fields"]),
+            "values": [value_message(value) for value in display["values"]],
+        }}
+    try:
+        if kind == "None":
+            return {"none": True}
+        if kind == "int":
+            return {"int": int(text)}
+        if kind == "float":
+            return {"float": float(text)}
+        if kind == "bool":
+            return {"bool": text == "True"}
+    except (TypeError, ValueError):
+        pass
+    if kind == "str":
+        return {"str": text}
+    return {"other": {"type": kind or "", "text": text or ""}}
+
+
+# ---------------------------------------------------------------------------
+# Evaluation
+# ---------------------------------------------------------------------------
+
+@dataclass
+class ServerLimits:
+    """The most a request may use; zero means no limit."""
+    max_steps: int = 0
+    max_memory_bytes: int = 256 * 1024 * 1024
+    max_collection_size: int = 0
+    timeout_seconds: float = 30.0
+    max_output_bytes: int = 1024 * 1024
+
+    def clamp(self, requested: dict | None) -> "ServerLimits":
+        """The limits for a request: what it asked for, capped by these."""
+        requested = requested or {}
+        limits = {}
+        for f in fields(self):
+            cap, asked = getattr(self, f.name), requested.get(f.name) or 0
+            limits[f.name] = asked if not cap or 0 < asked < cap else cap
+        return ServerLimits(**limits)
+
+    def env(self) -> dict[str, str]:
+        """The COREIL_* variables enforcing these limits in the Go runtime."""
+        env = {}
+        for name, value in (
+            ("COREIL_MAX_STEPS", self.max_steps),
+            ("COREIL_MAX_MEMORY", self.max_memory_bytes),
+            ("COREIL_MAX_COLLECTION_SIZE", self.max_collection_size),
+            ("COREIL_TIMEOUT", self.timeout_seconds),
+        ):
+            if value:
+                env[name] = f"{value:g}" if isinstance(value, float) else str(value)
+        return env
+
+
+class Evaluator:
+    """The Evaluator service's RPCs, independent of the transport.
+
+    Each RPC is a generator of RunEvent dicts; cancelled, if given, is
+    polled while the program runs, and the program is killed once it
+    returns True. frontend_factory is called on the first CompileAndRun.
+    Programs may only use the capabilities in grants (see emit_go); by
+    default they cannot touch files, the network, subprocesses or the
+    environment.
+    """
+
+    def __init__(
+        self,
+        frontend_factory: Callable[[], Any],
+        limits: ServerLimits | None = None,
+        grants: list[str] | None = None,
+    ):
+        self.limits = limits or ServerLimits()
+        self.grants = sorted(grants or [])
+        self._frontend_factory = frontend_factory
+        self._frontend = None
+        self._frontend_lock = threading.Lock()
+
+    def compile_and_run(self, request: dict, cancelled: Callable[[], bool] | None = None) -> Iterator[dict]:
+        from english_compiler.lsp import compile_source
+
+        started = time.monotonic()
+        source = request.get("source", "")
+        try:
+            frontend = self._get_frontend()
+        except RuntimeError as exc:
+            yield _result("COMPILE_ERROR", started, error=str(exc))
+            return
+        compilation = compile_source(None, source, frontend)
+        if compilation.error is not None:
+            yield _result("COMPILE_ERROR", started, error=compilation.error)
+            return
+        doc = compilation.coreil
+        if compilation.violations:
+            lines = format_diagnostics(doc, compilation.violations, source_text=source)
+            yield _result("INVALID_PROGRAM", started, error="\n".join(lines))
+            return
+        yield {"compiled": {"coreil_json": json.dumps(doc)}}
+        yield from self._run(doc, request, started, cancelled, source_text=source)
+
+    def eval(self, request: dict, cancelled: Callable[[], bool] | None = None) -> Iterator[dict]:
+        started = time.monotonic()
+        try:
+            doc = json.loads(request.get("coreil_json", ""))
+        except json.JSONDecodeError as exc:
+            yield _result("INVALID_PROGRAM", started, error=f"invalid Core IL JSON: {exc}")
+            return
+        if not isinstance(doc, dict):
+            yield _result("INVALID_PROGRAM", started, error="Core IL must be a JSON object")
+            return
+        yield from self._run(doc, request, started, cancelled)
+
+    def _get_frontend(self) -> Any:
+        with self._frontend_lock:
+            if self._frontend is None:
+                self._frontend = self._frontend_factory()
+            return self._frontend
+
+    def _run(
+        self,
+        doc: dict,
+        request: dict,
+        started: float,
+        cancelled: Callable[[], bool] | None,
+        *,
+        source_text: str | None = None,
+    ) -> Iterator[dict]:
+        from english_compiler.coreil.go_cache import GoBuildCache
+
+        violations = verify_coreil(doc, target="go")
+        if violations:
+            lines = format_diagnostics(doc, violations, source_text=source_text)
+            yield _result("INVALID_PROGRAM", started, error="\n".join(lines))
+            return
+        # Only the standard library can be imported: there is no directory
+        # to find other modules in
+        try:
+            doc = resolve_imports(doc)
+        except (CircularImportError, ModuleNotFoundError, ValueError) as exc:
+            yield _result("INVALID_PROGRAM", started, error=f"Import error: {exc}")
+            return
+        options: dict[str, Any] = {"source_text": source_text} if source_text else {}
+        if self.grants:
+            options["grants"] = self.grants
+        build = GoBuildCache().build(doc, **options)
+        if not build.success:
+            yield _result("COMPILE_ERROR", started, error=build.error or "Go compilation failed")
+            return
+
+        limits = self.limits.clamp(request.get("limits"))
+        with tempfile.TemporaryDirectory() as tmp_dir:
+            display_path = Path(tmp_dir) / "display.txt"
+            env = {key: value for key, value in os.environ.items() if not key.startswith("COREIL_")}
+            env.update(limits.env())
+            env["COREIL_DISPLAY"] = str(display_path)
+            if request.get("seed") is not None:
+                env["COREIL_SEED"] = str(request["seed"])
+            run = _Process([str(build.binary_path), *request.get("argv", [])], env)
+            try:
+                yield from run.stream(limits, started, cancelled)
+            finally:
+                run.stop()
+            if run.cancelled:
+                return
+            records = read_display(display_path)
+
+        status = {0: "OK", 3: "LIMIT_EXCEEDED", 2: "INTERNAL_ERROR"}.get(run.exit_code, "RUNTIME_ERROR")
+        error = ""
+        if run.failure is not None:
+            status, error = "LIMIT_EXCEEDED", run.failure
+        elif status != "OK":
+            lines = run.stderr_tail.decode("utf-8", errors="replace").strip().splitlines()
+            error = lines[-1] if lines else f"exit code {run.exit_code}"
+        result = _result(status, started, error=error, exit_code=run.exit_code, output_bytes=run.output_bytes)
+        printed = result_record(doc, records, run.stdout_bytes) if status == "OK" else None
+        if printed is not None:
+            result["result"]["value"] = value_message(printed.value)
+        yield result
+
+
+class _Process:
+    """A running program whose output is read on two threads and relayed in order."""
+
+    def __init__(self, argv: list[str], env: dict[str, str]):
+        self.proc = subprocess.Popen(
+            argv, stdin=subprocess.DEVNULL, stdout=subprocess.PIPE, stderr=subprocess.PIPE, env=env
+        )
+        self.chunks: queue.Queue = queue.Queue()
+        for stream, pipe in (("STDOUT", self.proc.stdout), ("STDERR", self.proc.stderr)):
+            threading.Thread(target=self._pump, args=(stream, pipe), daemon=True).start()
+        self.exit_code = 0
+        self.failure: str | None = None  # a limit enforced here
+        self.cancelled = False
+        self.stdout_bytes = 0
+        self.output_bytes = 0
+        self.stderr_tail = b""
+
+    def _pump(self, stream: str, pipe: IO[bytes]) -> None:
+        for chunk in iter(lambda: pipe.read1(65536), b""):
+            self.chunks.put((stream, chunk))
+        self.chunks.put((stream, None))
+
+    def stream(self, limits: ServerLimits, started: float, cancelled: Callable[[], bool] | None) -> Iterator[dict]:
+        """Relay output events until the program ends, enforcing the output and time limits."""
+        open_streams = 2
+        deadline = started + limits.timeout_seconds + _TIMEOUT_GRACE if limits.timeout_seconds else None
+        while open_streams:
+            try:
+                stream, data = self.chunks.get(timeout=0.1)
+            except queue.Empty:
+                stream, data = None, b""
+            if stream is not None and data is None:
+                open_streams -= 1
+            elif stream is not None:
+                cap = limits.max_output_bytes
+                if cap and self.output_bytes + len(data) > cap:
+                    data = data[:cap - self.output_bytes]
+                    self.failure = f"limit exceeded: output (max {cap} bytes)"
+                self.output_bytes += len(data)
+                if stream == "STDOUT":
+                    self.stdout_bytes += len(data)
+                else:
+                    self.stderr_tail = (self.stderr_tail + data)[-_STDERR_TAIL:]
+                if data:
+                    yield {"output": {"stream": stream, "data": data}}
+                if self.failure:
+                    break
+            if cancelled is not None and cancelled():
+                self.cancelled = True
+                break
+            if deadline is not None and time.monotonic() > deadline:
+                self.failure = f"limit exceeded: timeout ({limits.timeout_seconds:g}s)"
+                break
+        self.stop()
+
+    def stop(self) -> None:
+        if self.proc.poll() is None:
+            self.proc.kill()
+        self.exit_code = self.proc.wait()
+
+
+def _result(status: str, started: float, **fields: Any) -> dict:
+    return {"result": {"status": status, "elapsed_seconds": time.monotonic() - started, **fields}}
+
+
+# ---------------------------------------------------------------------------
+# gRPC transport
+# ---------------------------------------------------------------------------
+
+def serve(address: str, evaluator: Evaluator, *, workers: int = 8) -> int:
+    """Serve the Evaluator service on address (e.g. "[::]:50051") until interrupted.
+
+    Requests beyond workers running at once wait for a free worker.
+    """
+    from concurrent import futures
+
+    import grpc
+
+    def handler(rpc: Callable[..., Iterator[dict]], request_type: str) -> Any:
+        def behavior(request: dict, context: Any) -> Iterator[dict]:
+            yield from rpc(request, cancelled=lambda: not context.is_active())
+
+        return grpc.unary_stream_rpc_method_handler(
+            behavior,
+            request_deserializer=lambda data: decode_message(request_type, data),
+            response_serializer=lambda event: encode_message("RunEvent", event),
+        )
+
+    server = grpc.server(futures.ThreadPoolExecutor(max_workers=workers))
+    server.add_generic_rpc_handlers((grpc.method_handlers_generic_handler(SERVICE_NAME, {
+        "CompileAndRun": handler(evaluator.compile_and_run, "CompileAndRunRequest"),
+        "Eval": handler(evaluator.eval, "EvalRequest"),
+    }),))
+    try:
+        port = server.add_insecure_port(address)
+    except RuntimeError:
+        port = 0
+    if not port:
+        print(f"serve: cannot listen on {address}", file=sys.stderr)
+        return 1
+    server.start()
+    print(f"Serving {SERVICE_NAME} on {address}", flush=True)
+    try:
+        server.wait_for_termination()
+    except KeyboardInterrupt:
+        server.stop(grace=5).wait()
+    return 0
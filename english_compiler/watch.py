@@ -3,6 +3,7 @@
 from __future__ import annotations
 
 import datetime
+import difflib
 from pathlib import Path
 from typing import Callable
 
@@ -16,6 +17,27 @@ def is_watchfiles_available() -> bool:
         return False
 
 
+def diff_output(previous: str | None, current: str) -> list[str]:
+    """Describe how a run's output differs from the previous run's.
+
+    Returns no lines for the first run, one line when nothing changed, and
+    otherwise a unified diff.
+    """
+    if previous is None:
+        return []
+    if previous == current:
+        return ["Output unchanged"]
+    return list(
+        difflib.unified_diff(
+            previous.splitlines(),
+            current.splitlines(),
+            "previous run",
+            "this run",
+            lineterm="",
+        )
+    )
+
+
 def watch_and_compile(
     path: Path,
     compile_func: Callable[[Path], int],
@@ -0,0 +1,66 @@
+"""Fuzz the Go runtime with Go's native fuzzing.
+
+Runs the fuzz targets in runtime_fuzz_test.go (int arithmetic, float
+formatting and slicing, each checked against a reference following
+Python's semantics) against the current coreil_runtime.go. Without
+-fuzz, only the seed corpus runs, as regular tests; with it, `go test
+-fuzz` explores one target for -fuzztime and leaves any failing input in
+testdata/fuzz/ next to this script, where later runs replay it.
+
+Usage:
+    python fuzz/go_runtime.py                                   # seed corpus only
+    python fuzz/go_runtime.py -fuzz FuzzIntArithmetic -fuzztime 60s
+"""
+
+from __future__ import annotations
+
+import argparse
+import shutil
+import subprocess
+import sys
+import tempfile
+from pathlib import Path
+
+from english_compiler.coreil.emit_go import get_runtime_path
+
+FUZZ_DIR = Path(__file__).parent
+FUZZ_FILE = FUZZ_DIR / "runtime_fuzz_test.go"
+TARGETS = ("FuzzIntArithmetic", "FuzzFloatFormat", "FuzzSlicing")
+
+
+def run(fuzz: str | None = None, fuzztime: str = "30s", **subprocess_options) -> subprocess.CompletedProcess:
+    """Run the seed corpus, or fuzz one target, returning go test's result."""
+    with tempfile.TemporaryDirectory() as tmpdir:
+        tmppath = Path(tmpdir)
+        shutil.copy(get_runtime_path(), tmppath / "coreil_runtime.go")
+        shutil.copy(FUZZ_FILE, tmppath / FUZZ_FILE.name)
+        corpus = FUZZ_DIR / "testdata"
+        if corpus.is_dir():
+            shutil.copytree(corpus, tmppath / "testdata")
+        # The runtime is package main and has no main of its own
+        (tmppath / "main.go").write_text("package main\n\nfunc main() {}\n", encoding="utf-8")
+        subprocess.run(["go", "mod", "init", "coreil_fuzz"], cwd=tmppath, capture_output=True, check=True)
+        if fuzz is None:
+            command = ["go", "test", "-run", "^Fuzz"]
+        else:
+            command = ["go", "test", "-run", "^$", "-fuzz", f"^{fuzz}$", "-fuzztime", fuzztime]
+        result = subprocess.run(command, cwd=tmppath, **subprocess_options)
+        # Keep new failing inputs so they are replayed from now on
+        if (tmppath / "testdata").is_dir():
+            shutil.copytree(tmppath / "testdata", corpus, dirs_exist_ok=True)
+    return result
+
+
+def main() -> int:
+    parser = argparse.ArgumentParser(description=__doc__.splitlines()[0])
+    parser.add_argument("-fuzz", choices=TARGETS, help="target to fuzz (default: run the seed corpus)")
+    parser.add_argument("-fuzztime", default="30s", help="how long to fuzz, e.g. 60s or 10000x")
+    args = parser.parse_args()
+    if shutil.which("go") is None:
+        print("go is not installed", file=sys.stderr)
+        return 1
+    return run(args.fuzz, args.fuzztime).returncode
+
+
+if __name__ == "__main__":
+    sys.exit(main())
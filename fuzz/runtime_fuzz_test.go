@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// Native fuzz targets for the runtime's arithmetic, float formatting and
+// slicing. Each compares the runtime against a reference written from
+// Python's semantics, which the interpreter follows: exact math/big
+// arithmetic for ints, the repr() rules for floats and rune slicing for
+// strings. Programs are fuzzed as the differential harness builds them,
+// with checked arithmetic, so an int result outside int64 must raise.
+
+// fuzzOutcome is a result's repr, or the kind of error it raised.
+type fuzzOutcome struct {
+	repr string
+	kind ErrorKind
+}
+
+// fuzzRun calls op, turning a *CoreILError panic into its kind. Any other
+// panic is a runtime bug and fails the test.
+func fuzzRun(t *testing.T, op func() Value) (out fuzzOutcome) {
+	defer func() {
+		if r := recover(); r != nil {
+			var err *CoreILError
+			if e, ok := r.(error); !ok || !errors.As(e, &err) {
+				t.Fatalf("panic outside the runtime's errors: %v", r)
+			}
+			out = fuzzOutcome{kind: err.Kind}
+		}
+	}()
+	return fuzzOutcome{repr: reprValue(op())}
+}
+
+// fuzzEngine configures DefaultEngine as a fuzz program is built and
+// restores it when the test ends.
+func fuzzEngine(t *testing.T, truncate bool) {
+	checked, division := DefaultEngine.checked, DefaultEngine.division
+	t.Cleanup(func() { DefaultEngine.checked, DefaultEngine.division = checked, division })
+	DefaultEngine.SetCheckedArithmetic(true)
+	if truncate {
+		DefaultEngine.SetDivision(DivisionTruncate)
+	} else {
+		DefaultEngine.SetDivision(DivisionFloor)
+	}
+}
+
+var fuzzIntOps = []string{"+", "-", "*", "%", "intDiv", "/", "<"}
+
+// intReference is a op b for Python ints, raising OverflowError for an int
+// result outside int64.
+func intReference(a, b int64, op string, truncate bool) fuzzOutcome {
+	x, y := big.NewInt(a), big.NewInt(b)
+	result := new(big.Int)
+	switch op {
+	case "+":
+		result.Add(x, y)
+	case "-":
+		result.Sub(x, y)
+	case "*":
+		result.Mul(x, y)
+	case "%", "intDiv":
+		if b == 0 {
+			return fuzzOutcome{kind: KindZeroDivisionError}
+		}
+		// Truncated quotient and remainder; flooring moves a remainder
+		// whose sign differs from the divisor's over by one divisor
+		q, r := new(big.Int).QuoRem(x, y, new(big.Int))
+		if !truncate && r.Sign() != 0 && r.Sign() != y.Sign() {
+			q.Sub(q, big.NewInt(1))
+			r.Add(r, y)
+		}
+		if op == "%" {
+			result = r
+		} else {
+			result = q
+		}
+	case "/":
+		if b == 0 {
+			return fuzzOutcome{kind: KindZeroDivisionError}
+		}
+		// Python rounds the exact quotient once; a zero one is signed as
+		// a float division's, so 0 / -1 is -0.0
+		f, _ := new(big.Rat).SetFrac(x, y).Float64()
+		if f == 0 && b < 0 {
+			f = math.Copysign(0, -1)
+		}
+		return fuzzOutcome{repr: formatFloat(f)}
+	case "<":
+		return fuzzOutcome{repr: reprValue(ValueBool(a < b))}
+	}
+	if !result.IsInt64() {
+		return fuzzOutcome{kind: KindOverflowError}
+	}
+	return fuzzOutcome{repr: result.String()}
+}
+
+func intRuntime(a, b Value, op string) Value {
+	switch op {
+	case "+":
+		return valueAdd(a, b)
+	case "-":
+		return valueSubtract(a, b)
+	case "*":
+		return valueMultiply(a, b)
+	case "%":
+		return valueModulo(a, b)
+	case "intDiv":
+		return intDiv(a, b)
+	case "/":
+		return valueDivide(a, b)
+	default:
+		return ValueBool(valueLessThan(a, b))
+	}
+}
+
+func FuzzIntArithmetic(f *testing.F) {
+	edges := []int64{0, 1, -1, 2, -7, 7, 1 << 53, -(1 << 53), math.MaxInt64, math.MinInt64}
+	for i, a := range edges {
+		for j, b := range edges {
+			f.Add(a, b, uint8(i*len(edges)+j), i%2 == 0)
+		}
+	}
+	f.Add(int64(0), int64(-1), uint8(5), false) // 0 / -1 is -0.0
+	f.Fuzz(func(t *testing.T, a, b int64, op uint8, truncate bool) {
+		name := fuzzIntOps[int(op)%len(fuzzIntOps)]
+		// An int converts to the nearest float before it is divided, so
+		// only ints floats hold exactly divide as Python's do
+		if name == "/" && (a > 1<<53 || a < -(1<<53) || b > 1<<53 || b < -(1<<53)) {
+			t.Skip()
+		}
+		fuzzEngine(t, truncate)
+		want := intReference(a, b, name, truncate)
+		got := fuzzRun(t, func() Value { return intRuntime(ValueInt(a), ValueInt(b), name) })
+		if got != want {
+			t.Fatalf("%d %s %d (truncate=%v): got %+v, want %+v", a, name, b, truncate, got, want)
+		}
+	})
+}
+
+var (
+	fixedFloat      = regexp.MustCompile(`^-?\d+\.\d+$`)
+	scientificFloat = regexp.MustCompile(`^-?\d(\.\d+)?e[+-]\d{2,3}$`)
+)
+
+func FuzzFloatFormat(f *testing.F) {
+	for _, x := range []float64{0, math.Copysign(0, -1), 0.1, 0.5, 2.5, 1e-5, 1e-4, 1e15, 1e16, 1e21, 123.456,
+		1 << 53, math.MaxFloat64, math.SmallestNonzeroFloat64} {
+		f.Add(x)
+	}
+	f.Fuzz(func(t *testing.T, x float64) {
+		got := formatFloat(x)
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			// "+Inf", "-Inf" and "NaN" in Go
+			if want := strings.ToLower(strings.TrimPrefix(strconv.FormatFloat(x, 'g', -1, 64), "+")); got != want {
+				t.Fatalf("%v formatted as %q, want %q", x, got, want)
+			}
+			return
+		}
+		// repr() is the shortest text that reads back as the same float
+		back, err := strconv.ParseFloat(got, 64)
+		if err != nil || math.Float64bits(back) != math.Float64bits(x) {
+			t.Fatalf("%v formatted as %q, which reads back as %v", x, got, back)
+		}
+		if shortest := strconv.FormatFloat(x, 'e', -1, 64); digits(got) != digits(shortest) {
+			t.Fatalf("%v formatted as %q, not with the digits of %q", x, got, shortest)
+		}
+		// Fixed notation from 1e-4 up to 1e16, with at least one decimal
+		if abs := math.Abs(x); abs == 0 || abs >= 1e-4 && abs < 1e16 {
+			if !fixedFloat.MatchString(got) {
+				t.Fatalf("%v formatted as %q, want fixed notation", x, got)
+			}
+		} else if !scientificFloat.MatchString(got) {
+			t.Fatalf("%v formatted as %q, want scientific notation", x, got)
+		}
+	})
+}
+
+// digits is the significant digits of a formatted float.
+func digits(s string) string {
+	mantissa, _, _ := strings.Cut(s, "e")
+	return strings.Trim(strings.Map(func(r rune) rune {
+		if r < '0' || r > '9' {
+			return -1
+		}
+		return r
+	}, mantissa), "0")
+}
+
+// fuzzBound maps n into the bounds the interpreter accepts, from lowest to
+// length; it rejects the rest, which the compiled backends clamp instead.
+func fuzzBound(n int64, lowest, length int) int {
+	span := int64(length - lowest + 1)
+	return int(((n%span)+span)%span) + lowest
+}
+
+// pySlice is items[start:end] for in-range bounds, negative ones counting
+// from the end.
+func pySlice[T any](items []T, start, end int) []T {
+	if start < 0 {
+		start += len(items)
+	}
+	if end < 0 {
+		end += len(items)
+	}
+	if start >= end {
+		return nil
+	}
+	return items[start:end]
+}
+
+func FuzzSlicing(f *testing.F) {
+	for _, s := range []string{"", "a", "hello world", "ünï", "tab\there", "\U0001F600!"} {
+		f.Add(s, int64(0), int64(-1))
+		f.Add(s, int64(2), int64(1))
+	}
+	f.Fuzz(func(t *testing.T, s string, start, end int64) {
+		if !utf8.ValidString(s) {
+			t.Skip() // Core IL strings are Unicode text
+		}
+		runes := []rune(s)
+		n := len(runes)
+
+		// Substring takes non-negative character offsets
+		from, to := fuzzBound(start, 0, n), fuzzBound(end, 0, n)
+		got := fuzzRun(t, func() Value { return stringSubstring(ValueStr(s), ValueInt(int64(from)), ValueInt(int64(to))) })
+		if want := reprString(string(pySlice(runes, from, to))); got.repr != want {
+			t.Fatalf("Substring(%q, %d, %d): got %+v, want %s", s, from, to, got, want)
+		}
+
+		// Slice also counts negative bounds from the end
+		items := make([]Value, n)
+		for i, r := range runes {
+			items[i] = ValueInt(int64(r))
+		}
+		from, to = fuzzBound(start, -n, n), fuzzBound(end, -n, n)
+		got = fuzzRun(t, func() Value { return arraySlice(ValueArray(items), ValueInt(int64(from)), ValueInt(int64(to))) })
+		if want := reprValue(ValueArray(pySlice(items, from, to))); got.repr != want {
+			t.Fatalf("Slice(%v, %d, %d): got %+v, want %s", runes, from, to, got, want)
+		}
+	})
+}
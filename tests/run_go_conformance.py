@@ -0,0 +1,194 @@
+"""Conformance tests: Compare Go runtime output with the reference interpreter.
+
+Runs every Core IL program in tests/conformance/ and examples/ through the
+Python interpreter and the Go backend, diffing stdout byte-for-byte.
+
+Programs may be annotated in tests/conformance/annotations.json:
+
+    {
+      "examples/foo.coreil.json": {"status": "skip", "reason": "..."},
+      "tests/conformance/bar.coreil.json": {"status": "xfail", "reason": "..."}
+    }
+
+"skip" programs are not run. "xfail" programs are expected to diverge; an
+xfail program that matches is reported as a failure so the annotation gets
+removed. A program with a sibling <name>.expected.txt golden file must also
+match it exactly. Pass --update to regenerate golden files for the
+conformance corpus from the reference interpreter.
+"""
+
+from __future__ import annotations
+
+import json
+import sys
+from pathlib import Path
+
+from tests.test_helpers import GO_AVAILABLE, run_go_backend, run_interpreter
+
+ROOT = Path(__file__).resolve().parents[1]
+CONFORMANCE_DIR = ROOT / "tests" / "conformance"
+ANNOTATIONS_PATH = CONFORMANCE_DIR / "annotations.json"
+
+VALID_STATUSES = frozenset({"skip", "xfail"})
+
+
+def load_annotations() -> dict[str, dict]:
+    """Load the skip/xfail annotations keyed by repo-relative path."""
+    if not ANNOTATIONS_PATH.exists():
+        return {}
+    annotations = json.loads(ANNOTATIONS_PATH.read_text(encoding="utf-8"))
+    for name, entry in annotations.items():
+        status = entry.get("status")
+        if status not in VALID_STATUSES:
+            raise ValueError(f"{name}: unknown annotation status {status!r}")
+        if not entry.get("reason"):
+            raise ValueError(f"{name}: annotation requires a reason")
+    return annotations
+
+
+def golden_path(coreil_path: Path) -> Path:
+    """Return the golden output path for a Core IL file."""
+    return coreil_path.with_name(coreil_path.name.replace(".coreil.json", ".expected.txt"))
+
+
+def collect_programs() -> list[Path]:
+    """Collect the conformance corpus followed by the examples."""
+    return sorted(CONFORMANCE_DIR.glob("*.coreil.json")) + sorted(
+        (ROOT / "examples").glob("*.coreil.json")
+    )
+
+
+def run_conformance_test(coreil_path: Path) -> tuple[bool, str]:
+    """Run one program through both runtimes.
+
+    Returns:
+        (passed, message) tuple
+    """
+    try:
+        doc = json.loads(coreil_path.read_text(encoding="utf-8"))
+    except (OSError, json.JSONDecodeError) as exc:
+        return False, f"Failed to load Core IL: {exc}"
+
+    reference = run_interpreter(doc)
+    if not reference.success:
+        return False, f"Interpreter failed: {reference.error}"
+
+    golden = golden_path(coreil_path)
+    if golden.exists():
+        expected = golden.read_text(encoding="utf-8")
+        if reference.output != expected:
+            return (
+                False,
+                f"Interpreter does not match {golden.name}:\n"
+                f"  Expected:    {expected!r}\n"
+                f"  Interpreter: {reference.output!r}",
+            )
+
+    go = run_go_backend(doc, timeout=60)
+    if go.output != reference.output:
+        detail = f"\n  Error:       {go.error}" if go.error else ""
+        return (
+            False,
+            f"Output mismatch:\n"
+            f"  Interpreter: {reference.output!r}\n"
+            f"  Go:          {go.output!r}{detail}",
+        )
+
+    if go.exit_code != reference.exit_code:
+        return (
+            False,
+            f"Exit code mismatch:\n"
+            f"  Interpreter: {reference.exit_code}\n"
+            f"  Go:          {go.exit_code}",
+        )
+
+    return True, "OK"
+
+
+def update_golden_files() -> None:
+    """Write golden files for the conformance corpus from the interpreter."""
+    for coreil_path in sorted(CONFORMANCE_DIR.glob("*.coreil.json")):
+        doc = json.loads(coreil_path.read_text(encoding="utf-8"))
+        reference = run_interpreter(doc)
+        if not reference.success:
+            print(f"✗ {coreil_path.name}: {reference.error}")
+            continue
+        golden_path(coreil_path).write_text(reference.output, encoding="utf-8")
+        print(f"✓ {coreil_path.name}")
+
+
+def main() -> None:
+    """Run the Go conformance suite."""
+    if "--update" in sys.argv[1:]:
+        update_golden_files()
+        return
+
+    if not GO_AVAILABLE:
+        print("Go not available, skipping conformance tests")
+        return
+
+    annotations = load_annotations()
+    coreil_files = collect_programs()
+    print(f"Running Go conformance tests on {len(coreil_files)} programs...\n")
+
+    passed = 0
+    failed = 0
+    skipped = 0
+    xfailed = 0
+    failures: list[tuple[Path, str]] = []
+
+    for coreil_path in coreil_files:
+        key = coreil_path.relative_to(ROOT).as_posix()
+        test_name = coreil_path.stem.removesuffix(".coreil")
+        annotation = annotations.get(key)
+
+        if annotation and annotation["status"] == "skip":
+            print(f"- {test_name}: skipped ({annotation['reason']})")
+            skipped += 1
+            continue
+
+        success, message = run_conformance_test(coreil_path)
+
+        if annotation and annotation["status"] == "xfail":
+            if success:
+                print(f"✗ {test_name}: unexpectedly passed")
+                failed += 1
+                failures.append(
+                    (coreil_path, "Marked xfail but passed; remove the annotation")
+                )
+            else:
+                print(f"~ {test_name}: expected failure ({annotation['reason']})")
+                xfailed += 1
+        elif success:
+            print(f"✓ {test_name}")
+            passed += 1
+        else:
+            print(f"✗ {test_name}: {message.split(chr(10))[0]}")
+            failed += 1
+            failures.append((coreil_path, message))
+
+    stale = sorted(set(annotations) - {p.relative_to(ROOT).as_posix() for p in coreil_files})
+    for key in stale:
+        print(f"✗ {key}: annotation refers to a missing program")
+        failed += 1
+        failures.append((ROOT / key, "Annotation refers to a missing program"))
+
+    print(f"\n{'=' * 60}")
+    print(
+        f"Go Conformance: {passed} passed, {failed} failed, "
+        f"{xfailed} expected failures, {skipped} skipped"
+    )
+    print(f"{'=' * 60}")
+
+    if failures:
+        print("\nFailure Details:")
+        for coreil_path, message in failures:
+            print(f"\n{coreil_path.name}:")
+            print(f"  {message}")
+
+    if failed > 0:
+        raise SystemExit(1)
+
+
+if __name__ == "__main__":
+    main()
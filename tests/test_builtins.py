@@ -0,0 +1,304 @@
+"""Tests for the builtin registry and per-target builtin checks."""
+
+from __future__ import annotations
+
+import io
+import json
+import re
+import tempfile
+from contextlib import redirect_stdout
+from pathlib import Path
+
+from english_compiler.__main__ import _check_target_builtins
+from english_compiler.__main__ import main as cli_main
+from english_compiler.coreil.builtins import (
+    BUILTINS,
+    REGISTRY_VERSION,
+    TARGETS,
+    builtins_for,
+    call_builtins,
+    check_builtins,
+    get_builtin,
+    registry_json,
+    target_constraints,
+)
+from english_compiler.coreil.emit_go import get_runtime_path
+from english_compiler.coreil.interp import _CALL_BUILTINS
+from english_compiler.coreil.validate import _ALLOWED_NODE_TYPES
+from english_compiler.coreil.verify import BUILTIN_ARITY, verify_coreil
+from english_compiler.frontend.base import BaseFrontend
+from english_compiler.frontend.cache import CoreILCache
+from tests.test_helpers import GO_AVAILABLE, run_go_backend, run_interpreter
+
+
+def _lit(value) -> dict:
+    return {"type": "Literal", "value": value}
+
+
+def _print(*args: dict) -> dict:
+    return {"type": "Print", "args": list(args)}
+
+
+def _call(name: str, *args: dict) -> dict:
+    return {"type": "Call", "name": name, "args": list(args)}
+
+
+def _external(module: str, function: str, *args: dict) -> dict:
+    return {"type": "ExternalCall", "module": module, "function": function, "args": list(args)}
+
+
+def _program(*body: dict) -> dict:
+    return {"version": "coreil-1.11", "body": list(body)}
+
+
+_FORMAT = {"type": "StringFormat", "parts": [_lit("n="), _lit(1)]}
+_CONCAT = {"type": "Binary", "op": "+", "left": _lit("n="), "right": {"type": "ToString", "value": _lit(1)}}
+
+
+def _go_signatures(runtime: str) -> dict[str, tuple[tuple[int, int | None], bool]]:
+    """Each runtime function taking only Values: its arity and whether it returns one."""
+    signatures = {}
+    for name, params, returns in re.findall(r"^func (\w+)\(([^)]*)\) ?([^{]*)\{", runtime, re.M):
+        parts = [part.strip() for part in params.split(",") if part.strip()]
+        if not all(re.fullmatch(r"\w+( (\.\.\.)?Value)?", part) for part in parts):
+            continue
+        names = [part.split()[0] for part in parts]
+        variadic = "..." in params
+        low = len(names) - variadic
+        signatures[name] = ((low, None if variadic else low), "Value" in returns)
+    return signatures
+
+
+def test_registry_matches_runtimes():
+    calls = call_builtins()
+    assert {name for name, builtin in calls.items() if "coreil" in builtin.targets} == set(_CALL_BUILTINS)
+    assert BUILTIN_ARITY == {name: builtin.arity for name, builtin in call_builtins().items()}
+    assert BUILTIN_ARITY["print"] == (0, None) and BUILTIN_ARITY["input"] == (0, 1)
+    for builtin in BUILTINS:
+        assert builtin.targets <= set(TARGETS), builtin.name
+        if builtin.kind == "operation":
+            assert builtin.name in _ALLOWED_NODE_TYPES, builtin.name
+
+    # Every ExternalCall the Go runtime implements, with its arity and capability
+    runtime = get_runtime_path().read_text(encoding="utf-8")
+    capabilities = dict(re.findall(r'(Cap\w+)\s+Capability = "([^"]+)"', runtime))
+    externals = {
+        name: (int(arity), capabilities.get(capability))
+        for name, capability, arity in re.findall(r'^\t"(\w+\.\w+)":\s*\{("?\w*"?), (\d+),', runtime, re.M)
+    }
+    registered = {b.name: (b.arity[0], b.capability) for b in BUILTINS if b.kind == "external"}
+    assert registered == externals
+
+    # Every call only Go provides is a runtime function of that name; a
+    # variadic Go parameter may stand for an optional one
+    signatures = _go_signatures(runtime)
+    for name, builtin in calls.items():
+        if builtin.targets != {"go"}:
+            continue
+        assert name in signatures, name
+        (low, high), returns = signatures[name]
+        assert builtin.arity[0] == low and high in (builtin.arity[1], None), name
+        assert returns or builtin.returns is None, name
+
+
+def test_versions_and_targets():
+    assert get_builtin("argv").signature() == "argv() -> array"
+    assert get_builtin("RegexMatch").signature() == (
+        "RegexMatch(string: string, pattern: string, flags?: string) -> bool"
+    )
+    assert get_builtin("HeapPush").pure is False and get_builtin("Keys").pure is True
+    assert get_builtin("RegexSplit").arity == (2, 4)
+
+    # The v0.4 helpers are sealed; later additions are not offered to older programs
+    helper = get_builtin("get_or_default")
+    assert helper.available("coreil-0.4") and not helper.available("coreil-0.5")
+    assert not get_builtin("StringFormat").available("coreil-1.10")
+    names = {b.name for b in builtins_for("go", "coreil-1.2")}
+    assert "Math" in names and "JsonParse" not in names and "MethodCall" not in names
+    assert "time.time" in names and "time.time" not in {b.name for b in builtins_for("rust")}
+
+    doc = registry_json("cpp")
+    assert doc["registry_version"] == REGISTRY_VERSION and doc["target"] == "cpp"
+    assert "StringFormat" not in {entry["name"] for entry in doc["builtins"]}
+    assert len(registry_json()["builtins"]) == len(BUILTINS)
+
+    assert target_constraints("python") == (
+        "The program will run on the python target. Do not use: argv(), forAll(), readLines(), readChunks(), "
+        "intDiv(), heapFromArray(), heapPushPop(), heapReplace(), heapToSortedArray(), dequeExtend(), "
+        "dequeExtendLeft(), mathAtan2(), mathHypot(), mathIsNaN(), mathIsInf(), mathIsFinite()."
+    )
+    assert "ExternalCall is not available" in target_constraints("cpp")
+    try:
+        builtins_for("java")
+    except ValueError as exc:
+        assert "java" in str(exc)
+    else:
+        raise AssertionError("expected an unknown target error")
+
+
+def test_check_builtins():
+    doc = _program(
+        _print(_call("argv")),
+        {"type": "Let", "name": "now", "value": _external("time", "time")},
+        _print(_FORMAT),
+    )
+    assert check_builtins(doc, "coreil") == [
+        {"message": "ExternalCall time.time is not supported by the coreil target", "path": "$.body[1].value"},
+    ]
+    assert check_builtins(doc, "go") == []
+    assert [v["path"] for v in check_builtins(doc, "rust")] == ["$.body[0].args[0]", "$.body[1].value",
+                                                              "$.body[2].args[0]"]
+    # Any module.function passes through on Python and JavaScript
+    assert check_builtins(_program({"type": "Let", "name": "pid", "value": _external("os", "getpid")}), "python") == []
+    assert check_builtins(_program({"type": "Let", "name": "pid", "value": _external("os", "getpid")}), "go")
+
+    # A function the program defines is not the builtin
+    own = _program(
+        {"type": "FuncDef", "name": "argv", "params": [], "body": [{"type": "Return", "value": _lit(1)}]},
+        _print(_call("argv")),
+    )
+    assert check_builtins(own, "cpp") == []
+
+    # Only the interpreter and Go honor truncated division
+    truncated = dict(_program(_print({"type": "Binary", "op": "%", "left": _lit(-7), "right": _lit(2)})),
+                     division="truncate")
+    assert check_builtins(truncated, "coreil") == check_builtins(truncated, "go") == []
+    assert verify_coreil(truncated, target="rust") == [
+        {"message": "division 'truncate' is not supported by the rust target", "path": "$.division"},
+    ]
+    assert check_builtins(dict(truncated, division="floor"), "rust") == []
+
+    # Calls of the Go runtime's own helpers verify on Go and are rejected elsewhere
+    tasks = _program(
+        {"type": "Let", "name": "ch", "value": _call("channelNew", _lit(1))},
+        _call("channelSend", {"type": "Var", "name": "ch"}, _lit("ready")),
+        _print(_call("channelReceive", {"type": "Var", "name": "ch"}), _call("uuid4")),
+    )
+    assert verify_coreil(tasks, target="go") == []
+    assert [v["message"] for v in verify_coreil(tasks, target="coreil")] == [
+        f"{name} is not supported by the coreil target" for name in ("channelNew", "channelSend", "channelReceive",
+                                                                     "uuid4")
+    ]
+    assert [v["message"] for v in verify_coreil(_program(_print(_call("uuid4", _lit(1)))), target="go")] == [
+        "builtin 'uuid4' takes 0 argument(s), got 1",
+    ]
+    # A function the program defines wins over them, but not over the interpreter's builtins
+    def shadow(name: str) -> dict:
+        return _program(
+            {"type": "FuncDef", "name": name, "params": ["a", "b", "c"],
+             "body": [{"type": "Return", "value": _lit(0)}]},
+            _print(_call(name, _lit(1), _lit(2), _lit(3))),
+        )
+
+    assert verify_coreil(shadow("tableSize"), target="go") == []
+    assert [v["message"] for v in verify_coreil(shadow("intDiv"))] == ["builtin 'intDiv' takes 2 argument(s), got 3"]
+
+    # verify_coreil() reports them, with the English line, once the rest is sound
+    doc["source_map"] = {"1": [0, 1], "2": [2]}
+    violations = verify_coreil(doc, target="cpp")
+    assert [v["line"] for v in violations] == [1, 1, 2]
+    assert verify_coreil(doc) == []
+    broken = _program(_print({"type": "Var", "name": "missing"}), _print(_FORMAT))
+    assert all("StringFormat" not in v["message"] for v in verify_coreil(broken, target="cpp"))
+
+
+class _TargetFrontend(BaseFrontend):
+    """Stub LLM frontend that uses StringFormat until told not to."""
+
+    def __init__(self) -> None:
+        super().__init__()
+        self.messages: list[str] = []
+
+    def _call_api(self, user_message: str) -> dict:
+        self.messages.append(user_message)
+        value = _CONCAT if "RETRY" in user_message else _FORMAT
+        return _program(_print(value))
+
+    def _call_api_text(self, user_message: str, system_prompt: str) -> str:
+        return ""
+
+    def get_model_name(self) -> str:
+        return "stub-1"
+
+
+def test_frontend_targets():
+    frontend = _TargetFrontend()
+    assert frontend.generate_coreil_from_text("Print n.") == _program(_print(_FORMAT))
+    assert frontend.messages == ["Print n."]
+
+    # For Rust the prompt says what to avoid, and StringFormat is repaired
+    frontend = _TargetFrontend()
+    doc = frontend.generate_coreil_from_text("Print n.", target="rust")
+    assert doc == _program(_print(_CONCAT))
+    assert frontend.messages[0] == f"Print n.\n\n{target_constraints('rust')}"
+    assert "StringFormat is not supported by the rust target" in frontend.messages[1]
+
+    cache = CoreILCache(root=Path(tempfile.gettempdir()))
+    assert cache.key("Print n.", "stub-1") != cache.key("Print n.", "stub-1", "rust")
+    assert cache.key("Print n.", "stub-1", "rust") != cache.key("Print n.", "stub-1", "go")
+
+
+def test_cli():
+    out = io.StringIO()
+    with redirect_stdout(out):
+        assert cli_main(["builtins", "--json", "--target", "go"]) == 0
+    registry = json.loads(out.getvalue())
+    assert registry["target"] == "go"
+    assert {entry["name"] for entry in registry["builtins"]} == {b.name for b in builtins_for("go")}
+    argv = next(entry for entry in registry["builtins"] if entry["name"] == "argv")
+    assert argv["arity"] == [0, 0] and argv["targets"] == ["coreil", "go"]
+
+    out = io.StringIO()
+    with redirect_stdout(out):
+        assert cli_main(["builtins"]) == 0
+    assert "input(prompt?: string) -> string  [call; effects; since coreil-0.1; targets: coreil, python]" in (
+        out.getvalue().splitlines()
+    )
+
+    # Compilation stops before code generation
+    out = io.StringIO()
+    with redirect_stdout(out):
+        assert _check_target_builtins(_program(_print(_FORMAT)), "cpp") is False
+        assert _check_target_builtins(_program(_print(_FORMAT)), "coreil") is True
+    assert "$.body[0].args[0]: StringFormat is not supported by the cpp target" in out.getvalue()
+
+    # And so does running on Go
+    with tempfile.TemporaryDirectory() as tmp_dir:
+        path = Path(tmp_dir) / "prompt.coreil.json"
+        path.write_text(json.dumps(_program(_print(_call("input")))), encoding="utf-8")
+        out = io.StringIO()
+        with redirect_stdout(out):
+            assert cli_main(["run", "--go", str(path)]) == 1
+    assert "input is not supported by the go target" in out.getvalue()
+
+
+def test_go_string_format():
+    if not GO_AVAILABLE:
+        return
+    doc = _program(_print({"type": "StringFormat", "parts": [_lit("n="), _lit(1), _lit(" "), _lit(2.5),
+                                                             _lit(True), {"type": "Array", "items": []}]}))
+    compiled = run_go_backend(doc, timeout=120)
+    assert compiled.success, compiled.error
+    assert compiled.output == run_interpreter(doc).output == "n=1 2.5True[]\n"
+
+
+def main() -> None:
+    tests = [
+        test_registry_matches_runtimes,
+        test_versions_and_targets,
+        test_check_builtins,
+        test_frontend_targets,
+        test_cli,
+        test_go_string_format,
+    ]
+
+    print("Running builtin registry tests...\n")
+    for test in tests:
+        test()
+        print(f"  {test.__name__}: ✓")
+
+    print(f"\nAll {len(tests)} builtin registry tests passed! ✓")
+
+
+if __name__ == "__main__":
+    main()
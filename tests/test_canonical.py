@@ -0,0 +1,167 @@
+"""Tests for the canonical Core IL form and the fmt command."""
+
+from __future__ import annotations
+
+import argparse
+import io
+import json
+import tempfile
+from contextlib import redirect_stdout
+from pathlib import Path
+
+from english_compiler.cli.fmt_flow import fmt_command
+from english_compiler.coreil.canonical import canonicalize, format_coreil
+from english_compiler.coreil.go_cache import GoBuildCache, emitter_sources
+from english_compiler.coreil.interp import run_coreil
+
+
+def _run_and_capture(doc: dict) -> str:
+    """Run a Core IL program and capture stdout."""
+    buf = io.StringIO()
+    with redirect_stdout(buf):
+        rc = run_coreil(doc)
+    assert rc == 0, f"Interpreter failed with exit code {rc}"
+    return buf.getvalue()
+
+
+def _make_program(body: list[dict], version: str = "coreil-1.9") -> dict:
+    return {"version": version, "body": body}
+
+
+def _lit(value) -> dict:
+    return {"type": "Literal", "value": value}
+
+
+def _print(*args: dict) -> dict:
+    return {"type": "Print", "args": list(args)}
+
+
+def _fmt(files: list[str], check: bool = False) -> tuple[int, str]:
+    buf = io.StringIO()
+    with redirect_stdout(buf):
+        rc = fmt_command(argparse.Namespace(files=files, check=check))
+    return rc, buf.getvalue()
+
+
+def test_drops_default_fields():
+    prog = _make_program([
+        {"type": "If", "test": _lit(True), "then": [_print(_lit(1))], "else": []},
+        {"type": "For", "var": "i",
+         "iter": {"type": "Range", "from": _lit(0), "to": _lit(2), "inclusive": False},
+         "body": [_print({"type": "Var", "name": "i"})]},
+        {"type": "TryCatch", "body": [], "catch_var": "e", "catch_body": [],
+         "finally_body": []},
+    ])
+    prog["ambiguities"] = []
+    canonical = canonicalize(prog)
+    assert "else" not in canonical["body"][0]
+    assert "inclusive" not in canonical["body"][1]["iter"]
+    assert "finally_body" not in canonical["body"][2]
+    assert "ambiguities" not in canonical
+    assert _run_and_capture(prog) == _run_and_capture(canonical)
+
+
+def test_keeps_non_default_fields():
+    prog = _make_program([
+        {"type": "If", "test": _lit(False), "then": [], "else": [_print(_lit(2))]},
+        {"type": "For", "var": "i",
+         "iter": {"type": "Range", "from": _lit(0), "to": _lit(1), "inclusive": True},
+         "body": []},
+    ])
+    canonical = canonicalize(prog)
+    assert canonical["body"][0]["else"] == [_print(_lit(2))]
+    assert canonical["body"][1]["iter"]["inclusive"] is True
+    # A Literal whose value is [] is not a default, whatever the field is called
+    assert canonicalize(_make_program([_print(_lit([]))])) == _make_program([_print(_lit([]))])
+
+
+def test_normalizes_literals_and_order():
+    first = '{"version": "coreil-1.9", "body": [{"args": [{"value": 1.50, "type": "Literal"}], "type": "Print"}]}'
+    second = '{"body": [{"type": "Print", "args": [{"type": "Literal", "value": 15e-1}]}], "version": "coreil-1.9"}'
+    text = format_coreil(json.loads(first))
+    assert text == format_coreil(json.loads(second))
+    assert '"value": 1.5' in text
+    assert text.endswith("}\n")
+
+
+def test_source_map_indices_sorted():
+    prog = _make_program([_print(_lit(1)), _print(_lit(2))])
+    prog["source_map"] = {"1": [1, 0, 1]}
+    assert canonicalize(prog)["source_map"] == {"1": [0, 1]}
+    assert prog["source_map"] == {"1": [1, 0, 1]}
+
+
+def test_idempotent():
+    prog = _make_program([
+        {"type": "If", "test": _lit(True), "then": [_print(_lit(1.0))], "else": []},
+    ])
+    once = format_coreil(prog)
+    assert format_coreil(json.loads(once)) == once
+
+
+def test_fmt_command_check_and_rewrite():
+    prog = _make_program([
+        {"type": "If", "test": _lit(True), "then": [_print(_lit(1))], "else": []},
+    ])
+    with tempfile.TemporaryDirectory() as tmp_dir:
+        path = Path(tmp_dir) / "prog.coreil.json"
+        path.write_text(json.dumps(prog), encoding="utf-8")
+
+        rc, output = _fmt([str(path)], check=True)
+        assert rc == 1
+        assert output == f"{path}: not canonical\n"
+        assert json.loads(path.read_text(encoding="utf-8")) == prog
+
+        rc, output = _fmt([str(path)])
+        assert rc == 0
+        assert output == f"Formatted {path}\n"
+        assert path.read_text(encoding="utf-8") == format_coreil(prog)
+
+        rc, output = _fmt([str(path)], check=True)
+        assert (rc, output) == (0, "")
+
+
+def test_cache_key_ignores_spelling():
+    cache = GoBuildCache(Path(tempfile.gettempdir()) / "unused")
+    plain = _make_program([
+        {"type": "If", "test": _lit(True), "then": [_print(_lit(1.5))]},
+    ])
+    spelled = json.loads(
+        '{"body": [{"else": [], "test": {"type": "Literal", "value": true},'
+        ' "then": [{"args": [{"type": "Literal", "value": 1.50}], "type": "Print"}],'
+        ' "type": "If"}], "version": "coreil-1.9", "ambiguities": []}'
+    )
+    assert cache.key(plain) == cache.key(spelled)
+    assert cache.key(plain) != cache.key(plain, deterministic=True)
+
+
+def test_fingerprint_covers_emitter_imports():
+    names = {path.name for path in emitter_sources()}
+    for name in ("emit_go.py", "emit_base.py", "static_types.py", "ssa.py",
+                 "node_nav.py", "source_map.py", "constants.py"):
+        assert name in names, (name, sorted(names))
+    assert "emit_javascript.py" not in names
+
+
+def main() -> None:
+    tests = [
+        test_drops_default_fields,
+        test_keeps_non_default_fields,
+        test_normalizes_literals_and_order,
+        test_source_map_indices_sorted,
+        test_idempotent,
+        test_fmt_command_check_and_rewrite,
+        test_cache_key_ignores_spelling,
+        test_fingerprint_covers_emitter_imports,
+    ]
+
+    print("Running canonical form tests...\n")
+    for test in tests:
+        test()
+        print(f"  {test.__name__}: ✓")
+
+    print(f"\nAll {len(tests)} canonical form tests passed! ✓")
+
+
+if __name__ == "__main__":
+    main()
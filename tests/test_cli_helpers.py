@@ -2,7 +2,14 @@
 
 from __future__ import annotations
 
+import io
+import json
+import os
+import shutil
+import subprocess
+import sys
 import tempfile
+from contextlib import redirect_stdout
 from pathlib import Path
 from unittest import mock
 
@@ -94,6 +101,110 @@ def test_sha256_bytes_matches_known_value() -> None:
     )
 
 
+
+def _run_cli(*args: str, stdin: str | None = None, env: dict[str, str] | None = None) -> subprocess.CompletedProcess:
+    return subprocess.run(
+        [sys.executable, "-m", "english_compiler", *args],
+        input=stdin,
+        capture_output=True,
+        text=True,
+        timeout=120,
+        env={**os.environ, **env} if env else None,
+    )
+
+
+def test_run_command_reads_stdin_and_passes_args() -> None:
+    doc = {"version": "coreil-1.9", "body": [
+        {"type": "Print", "args": [{"type": "Call", "name": "argv", "args": []}]},
+    ]}
+    result = _run_cli("run", "-", "a", "--b", stdin=json.dumps(doc))
+    assert result.returncode == 0, result.stdout + result.stderr
+    assert result.stdout == "['a', '--b']\n", result.stdout
+
+
+def test_run_command_step_limit() -> None:
+    # TryCatch cannot catch the limit
+    doc = {"version": "coreil-1.9", "body": [
+        {"type": "TryCatch", "catch_var": "e", "catch_body": [], "body": [
+            {"type": "While", "test": {"type": "Literal", "value": True}, "body": []},
+        ]},
+    ]}
+    with tempfile.TemporaryDirectory() as tmp_dir:
+        path = Path(tmp_dir) / "loop.coreil.json"
+        path.write_text(json.dumps(doc), encoding="utf-8")
+        result = _run_cli("run", "--limit", "50", "--trace", str(path))
+    assert result.returncode == 3, result.stdout + result.stderr
+    assert result.stdout == "limit exceeded: steps (max 50)\n", result.stdout
+    assert result.stderr.startswith("trace: TryCatch\ntrace: While\n"), result.stderr
+
+
+def test_run_command_deterministic() -> None:
+    def ext(module: str, function: str, *args: object) -> dict:
+        return {"type": "ExternalCall", "module": module, "function": function,
+                "args": [{"type": "Literal", "value": a} for a in args]}
+
+    # randint over the whole int64 range used to overflow
+    doc = {"version": "coreil-1.9", "body": [
+        {"type": "Print", "args": [
+            ext("random", "randint", -(2**63), 2**63 - 1), ext("random", "random"), ext("time", "time"),
+        ]},
+    ]}
+    with tempfile.TemporaryDirectory() as tmp_dir:
+        path = Path(tmp_dir) / "dice.coreil.json"
+        path.write_text(json.dumps(doc), encoding="utf-8")
+        result = _run_cli("run", "--deterministic", str(path))
+        assert result.returncode == 1, result.stdout + result.stderr
+        assert result.stdout.startswith("--deterministic requires --go"), result.stdout
+        if shutil.which("go") is None:
+            return
+        env = {"COREIL_CACHE_DIR": str(Path(tmp_dir) / "cache")}
+        first = _run_cli("run", "--go", "--deterministic", str(path), env=env)
+        second = _run_cli("run", "--go", "--deterministic", str(path), env=env)
+        reseeded = _run_cli("run", "--go", "--deterministic", "--seed", "7", str(path), env=env)
+    assert first.returncode == 0, first.stdout + first.stderr
+    assert first.stdout == second.stdout != reseeded.stdout, (first.stdout, reseeded.stdout)
+    assert first.stdout.endswith(" 0.0\n"), first.stdout
+
+
+def test_watch_command_reruns_and_diffs_output() -> None:
+    from english_compiler.__main__ import main
+
+    with tempfile.TemporaryDirectory() as tmp_dir:
+        path = Path(tmp_dir) / "greet.txt"
+
+        def fake_watch(watched: Path, compile_func) -> int:
+            # Three saves: the first run, a change, and a revert
+            for text in ("Say hello.", "Say goodbye.", "Say goodbye."):
+                path.write_text(text, encoding="utf-8")
+                compile_func(watched)
+            return 0
+
+        buf = io.StringIO()
+        with (
+            mock.patch("english_compiler.watch.is_watchfiles_available", return_value=True),
+            mock.patch("english_compiler.watch.watch_and_compile", side_effect=fake_watch),
+            redirect_stdout(buf),
+        ):
+            assert main(["watch", "--frontend", "mock", str(path)]) == 0
+
+    lines = [line for line in buf.getvalue().splitlines() if not line.startswith("(compiled in")]
+    assert lines == [
+        f"Regenerating Core IL for {path} using mock",
+        "hello",
+        f"Regenerating Core IL for {path} using mock",
+        "unimplemented",
+        "--- previous run",
+        "+++ this run",
+        "@@ -1 +1 @@",
+        "-hello",
+        "+unimplemented",
+        f"Using cached Core IL from {path.parent / 'output' / 'coreil' / 'greet.coreil.json'}",
+        "unimplemented",
+        "Output unchanged",
+    ], lines
+
+
+
 if __name__ == "__main__":
     test_parse_bool_setting_accepts_expected_values()
     test_load_and_write_json_roundtrip()
@@ -102,4 +213,8 @@ if __name__ == "__main__":
     test_run_tier2_fallback_dispatches_python_runner()
     test_run_tier2_fallback_respects_supported_targets()
     test_sha256_bytes_matches_known_value()
+    test_run_command_reads_stdin_and_passes_args()
+    test_run_command_step_limit()
+    test_run_command_deterministic()
+    test_watch_command_reruns_and_diffs_output()
     print("All CLI helper tests passed.")
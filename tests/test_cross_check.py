@@ -0,0 +1,160 @@
+"""Tests for cross-checking the Go runtime against the interpreter."""
+
+from __future__ import annotations
+
+import io
+import json
+import tempfile
+from contextlib import redirect_stdout
+from pathlib import Path
+
+from english_compiler.coreil.cross_check import (
+    RuntimeRun,
+    Step,
+    compare_runs,
+    cross_check,
+    format_result,
+    trace_interpreter,
+)
+from tests.test_helpers import GO_AVAILABLE
+
+
+def _make_program(body: list[dict]) -> dict:
+    return {"version": "coreil-1.9", "body": body}
+
+
+def _lit(value) -> dict:
+    return {"type": "Literal", "value": value}
+
+
+def _var(name: str) -> dict:
+    return {"type": "Var", "name": name}
+
+
+def _print(*args: dict) -> dict:
+    return {"type": "Print", "args": list(args)}
+
+
+def _halving_program() -> dict:
+    return _make_program([
+        {"type": "FuncDef", "name": "show", "params": ["x"], "body": [_print(_var("x"))]},
+        _print(_lit("start")),
+        {"type": "ForEach", "var": "v", "iter": {"type": "Array", "items": [_lit(1), _lit(3)]},
+         "body": [{"type": "Call", "name": "show",
+                   "args": [{"type": "Binary", "op": "/", "left": _var("v"), "right": _lit(2)}]}]},
+        _print(_lit("done")),
+    ])
+
+
+def _steps(*pairs: tuple[str, int]) -> list[Step]:
+    return [Step(path, offset, f"{offset:016x}") for path, offset in pairs]
+
+
+def test_interpreter_trace():
+    run = trace_interpreter(_halving_program())
+    assert run.exit_code == 0
+    assert run.output == b"start\n0.5\n1.5\ndone\n"
+    # FuncDef is skipped; calls trace the function body
+    assert [s.path for s in run.steps] == [
+        "$.body[1]",
+        "$.body[2]",
+        "$.body[2].body[0]",
+        "$.body[0].body[0]",
+        "$.body[2].body[0]",
+        "$.body[0].body[0]",
+        "$.body[3]",
+    ]
+    assert [s.offset for s in run.steps] == [0, 6, 6, 6, 10, 10, 14]
+
+
+def test_compare_runs():
+    same = _steps(("$.body[0]", 0), ("$.body[1]", 2))
+    run = RuntimeRun(exit_code=0, output=b"a\nb\n", steps=same)
+    assert compare_runs(run, RuntimeRun(0, b"a\nb\n", list(same))) == (2, None)
+
+    # Different output from $.body[0]
+    other = _steps(("$.body[0]", 0), ("$.body[1]", 3))
+    matched, div = compare_runs(run, RuntimeRun(0, b"aa\nb\n", other))
+    assert matched == 1 and div.path == "$.body[0]" and div.reason == "printed different output"
+
+    # A branch went the other way
+    branched = _steps(("$.body[0]", 0), ("$.body[0].else[0]", 2))
+    matched, div = compare_runs(run, RuntimeRun(0, b"a\nb\n", branched))
+    assert (div.path, div.interp_next, div.go_next) == ("$.body[0]", "$.body[1]", "$.body[0].else[0]")
+
+    # Go stopped early
+    matched, div = compare_runs(run, RuntimeRun(1, b"a\n", same[:1]))
+    assert matched == 1 and div.reason == "Go stopped here"
+
+    # Only the output of the last statement or the exit code differs
+    assert compare_runs(run, RuntimeRun(0, b"a\nc\n", list(same)))[1].path == "$.body[1]"
+    assert compare_runs(run, RuntimeRun(1, b"a\nb\n", list(same)))[1].reason == "exited differently"
+
+
+def test_cross_check_passes():
+    if not GO_AVAILABLE:
+        return
+    result = cross_check(_halving_program())
+    assert result.ok, format_result(result, _halving_program())
+    assert result.matched == 7
+    assert result.go.output == b"start\n0.5\n1.5\ndone\n"
+
+
+def test_reports_first_divergence():
+    if not GO_AVAILABLE:
+        return
+    # The interpreter rejects float map keys, which Go accepts
+    prog = _make_program([
+        _print(_lit("start")),
+        {"type": "Let", "name": "z",
+         "value": {"type": "Map", "items": [{"key": _lit(1.5), "value": _lit(1)}]}},
+        _print(_var("z")),
+    ])
+    prog["source_map"] = {"1": [0], "2": [1], "3": [2]}
+    source = "Print start.\nLet z be a map from 1.5 to 1.\nPrint z.\n"
+    result = cross_check(prog)
+    assert not result.ok
+    assert result.matched == 2
+    assert result.divergence.path == "$.body[1]"
+    report = format_result(result, prog, source_text=source)
+    assert 'first divergent statement: $.body[1] Let (line 2: "Let z be a map from 1.5 to 1.")' in report
+    assert "the interpreter stopped here" in report
+    assert "next statement in Go:              $.body[2] Print" in report
+
+
+def test_run_cross_check_command():
+    from english_compiler.__main__ import main
+
+    # ExternalCall is Tier 2: there is nothing to compare against
+    prog = _make_program([
+        _print({"type": "ExternalCall", "module": "time", "function": "time", "args": []}),
+    ])
+    with tempfile.TemporaryDirectory() as tmp_dir:
+        path = Path(tmp_dir) / "prog.coreil.json"
+        path.write_text(json.dumps(prog), encoding="utf-8")
+        buf = io.StringIO()
+        with redirect_stdout(buf):
+            rc = main(["run", "--cross-check", str(path)])
+    assert rc == 0
+    assert buf.getvalue().startswith("cross-check skipped: the interpreter cannot run this program")
+
+
+def main() -> None:
+    tests = [
+        test_interpreter_trace,
+        test_compare_runs,
+        test_cross_check_passes,
+        test_reports_first_divergence,
+        test_run_cross_check_command,
+    ]
+
+    print("Running cross-check tests...\n")
+    for test in tests:
+        test()
+        print(f"  {test.__name__}: ✓")
+
+    print(f"\nAll {len(tests)} cross-check tests passed! ✓")
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,269 @@
+"""Tests for common subexpression elimination and loop-invariant hoisting."""
+
+from __future__ import annotations
+
+import io
+import json
+from contextlib import redirect_stdout
+
+from english_compiler.coreil.cse import (
+    eliminate_common_subexpressions,
+    hoist_loop_invariants,
+)
+from english_compiler.coreil.interp import run_coreil
+from tests.test_helpers import GO_AVAILABLE, run_go_backend
+
+
+def _run_and_capture(doc: dict) -> str:
+    """Run a Core IL program and capture stdout."""
+    buf = io.StringIO()
+    with redirect_stdout(buf):
+        rc = run_coreil(doc)
+    assert rc == 0, f"Interpreter failed with exit code {rc}"
+    return buf.getvalue()
+
+
+def _make_program(body: list[dict], version: str = "coreil-1.9") -> dict:
+    return {"version": version, "body": body}
+
+
+def _lit(value) -> dict:
+    return {"type": "Literal", "value": value}
+
+
+def _var(name: str) -> dict:
+    return {"type": "Var", "name": name}
+
+
+def _binary(op: str, left: dict, right: dict) -> dict:
+    return {"type": "Binary", "op": op, "left": left, "right": right}
+
+
+def _length(base: dict) -> dict:
+    return {"type": "Length", "base": base}
+
+
+def _get(base: dict, key: dict) -> dict:
+    return {"type": "Get", "base": base, "key": key}
+
+
+def _setup() -> list[dict]:
+    return [
+        {"type": "Let", "name": "xs", "value": {"type": "Array", "items": [_lit(1), _lit(2), _lit(3)]}},
+        {"type": "Let", "name": "prices", "value": {
+            "type": "Map", "items": [{"key": _lit("tax"), "value": _lit(10)}],
+        }},
+        {"type": "Let", "name": "i", "value": _lit(0)},
+        {"type": "Let", "name": "total", "value": _lit(0)},
+    ]
+
+
+def _increment(name: str) -> dict:
+    return {"type": "Assign", "name": name, "value": _binary("+", _var(name), _lit(1))}
+
+
+def _summing_loop() -> dict:
+    """while i < len(xs): total = total + prices["tax"] * xs[i]; i = i + 1"""
+    return _make_program(_setup() + [
+        {"type": "While", "test": _binary("<", _var("i"), _length(_var("xs"))), "body": [
+            {"type": "Assign", "name": "total", "value": _binary(
+                "+", _var("total"), _binary(
+                    "*", _get(_var("prices"), _lit("tax")),
+                    {"type": "Index", "base": _var("xs"), "index": _var("i")},
+                ),
+            )},
+            _increment("i"),
+        ]},
+        {"type": "Print", "args": [_var("total")]},
+    ])
+
+
+def _names(value) -> list[str]:
+    return [stmt.get("name") for stmt in value if stmt["type"] == "Let"]
+
+
+def test_hoist_from_while():
+    prog = _summing_loop()
+    hoisted = hoist_loop_invariants(prog)
+    # Length comes from the test and goes right before the loop; the map
+    # lookup comes from the body, so it waits for an If on the loop test
+    assert hoisted["body"][4] == {"type": "Let", "name": "__licm_0", "value": _length(_var("xs"))}
+    guard = hoisted["body"][5]
+    assert guard["type"] == "If"
+    assert guard["test"] == _binary("<", _var("i"), _var("__licm_0"))
+    assert guard["then"][0] == {
+        "type": "Let", "name": "__licm_1", "value": _get(_var("prices"), _lit("tax")),
+    }
+    loop = guard["then"][1]
+    assert loop["test"] == _binary("<", _var("i"), _var("__licm_0"))
+    assert loop["body"][0]["value"]["right"]["left"] == _var("__licm_1")
+    assert _run_and_capture(prog) == _run_and_capture(hoisted) == "60\n"
+
+
+def test_hoist_skipped_for_zero_iterations():
+    """A failing lookup in a loop that never runs must not be evaluated."""
+    prog = _make_program(_setup() + [
+        {"type": "While", "test": _binary("<", _var("i"), _lit(0)), "body": [
+            {"type": "Let", "name": "rate", "value": {
+                "type": "Index", "base": _var("xs"), "index": _lit(10),
+            }},
+            _increment("i"),
+        ]},
+        {"type": "Print", "args": [_lit("done")]},
+    ])
+    hoisted = hoist_loop_invariants(prog)
+    assert hoisted["body"][4]["type"] == "If"
+    assert _run_and_capture(hoisted) == "done\n"
+
+
+def test_no_hoist_when_loop_mutates():
+    prog = _make_program(_setup() + [
+        {"type": "While", "test": _binary("<", _length(_var("xs")), _lit(5)), "body": [
+            {"type": "Push", "base": _var("xs"), "value": _lit(0)},
+        ]},
+        {"type": "Print", "args": [_length(_var("xs"))]},
+    ])
+    assert hoist_loop_invariants(prog) == prog
+
+
+def test_no_hoist_from_conditional():
+    """The right side of and/or may never be evaluated."""
+    prog = _make_program(_setup() + [
+        {"type": "While", "test": _binary(
+            "and",
+            _binary("<", _var("i"), _lit(3)),
+            _binary("<", _get(_var("prices"), _lit("tax")), _lit(100)),
+        ), "body": [_increment("i")]},
+    ])
+    assert hoist_loop_invariants(prog) == prog
+
+
+def test_hoist_from_for_range():
+    prog = _make_program(_setup() + [
+        {"type": "For", "var": "j", "iter": {
+            "type": "Range", "from": _lit(0), "to": _length(_var("xs")),
+        }, "body": [
+            {"type": "Assign", "name": "total", "value": _binary(
+                "+", _var("total"), _get(_var("prices"), _lit("tax")),
+            )},
+        ]},
+        {"type": "Print", "args": [_var("total"), _var("i")]},
+    ])
+    hoisted = hoist_loop_invariants(prog)
+    guard = hoisted["body"][4]
+    assert guard["test"] == _binary("<", _lit(0), _length(_var("xs")))
+    assert _names(guard["then"]) == ["__licm_0"]
+    assert _run_and_capture(prog) == _run_and_capture(hoisted)
+
+
+def test_cse_temporary():
+    prog = _make_program(_setup() + [
+        {"type": "Print", "args": [
+            _get(_var("prices"), _lit("tax")),
+            _binary("+", _get(_var("prices"), _lit("tax")), _lit(1)),
+        ]},
+        {"type": "If", "test": _binary(">", _get(_var("prices"), _lit("tax")), _lit(5)), "then": [
+            {"type": "Print", "args": [_lit("high")]},
+        ]},
+    ])
+    reduced = eliminate_common_subexpressions(prog)
+    assert reduced["body"][4] == {
+        "type": "Let", "name": "__cse_0", "value": _get(_var("prices"), _lit("tax")),
+    }
+    assert reduced["body"][5]["args"][0] == _var("__cse_0")
+    assert reduced["body"][6]["test"]["left"] == _var("__cse_0")
+    assert _run_and_capture(prog) == _run_and_capture(reduced)
+
+
+def test_cse_reuses_variable_until_mutation():
+    prog = _make_program(_setup() + [
+        {"type": "Let", "name": "n", "value": _length(_var("xs"))},
+        {"type": "Print", "args": [_length(_var("xs"))]},
+        {"type": "Push", "base": _var("xs"), "value": _lit(4)},
+        {"type": "Print", "args": [_length(_var("xs")), _var("n")]},
+    ])
+    reduced = eliminate_common_subexpressions(prog)
+    assert reduced["body"][5]["args"] == [_var("n")]
+    assert reduced["body"][7]["args"][0] == _length(_var("xs"))
+    assert _run_and_capture(prog) == _run_and_capture(reduced) == "3\n4 3\n"
+
+
+def test_cse_skips_fresh_and_conditional():
+    arr = {"type": "Slice", "base": _var("xs"), "start": _lit(0), "end": _lit(2)}
+    prog = _make_program(_setup() + [
+        # Slices are new arrays each time, so they must not be shared
+        {"type": "Let", "name": "a", "value": arr},
+        {"type": "Let", "name": "b", "value": arr},
+        {"type": "Push", "base": _var("a"), "value": _lit(9)},
+        # The lookup only happens when the left side is true
+        {"type": "Print", "args": [
+            _binary("and", _lit(False), _get(_var("prices"), _lit("x"))),
+            _var("b"),
+        ]},
+    ])
+    assert eliminate_common_subexpressions(prog) == prog
+    assert _run_and_capture(prog) == "False [1, 2]\n"
+
+
+def test_cse_keeps_first_error():
+    """A lookup is not moved ahead of an index that fails first."""
+    tax = _get(_var("prices"), _lit("tax"))
+    prog = _make_program(_setup() + [
+        {"type": "Print", "args": [
+            {"type": "Index", "base": _var("xs"), "index": _lit(10)}, tax, tax,
+        ]},
+    ])
+    assert eliminate_common_subexpressions(prog) == prog
+
+
+def test_does_not_mutate_input():
+    prog = _summing_loop()
+    original = json.dumps(prog, sort_keys=True)
+    eliminate_common_subexpressions(hoist_loop_invariants(prog))
+    assert json.dumps(prog, sort_keys=True) == original
+
+
+def test_source_map_follows_statements():
+    prog = _summing_loop()
+    prog["source_map"] = {str(i + 1): [i] for i in range(len(prog["body"]))}
+    hoisted = hoist_loop_invariants(prog)
+    assert hoisted["source_map"]["5"] == [4, 5]
+    assert hoisted["source_map"]["6"] == [6]
+
+
+def test_go_parity():
+    if not GO_AVAILABLE:
+        return
+    prog = _summing_loop()
+    reduced = eliminate_common_subexpressions(hoist_loop_invariants(prog))
+    result = run_go_backend(reduced)
+    assert result.success, result.error
+    assert result.output == _run_and_capture(prog)
+
+
+def main() -> None:
+    tests = [
+        test_hoist_from_while,
+        test_hoist_skipped_for_zero_iterations,
+        test_no_hoist_when_loop_mutates,
+        test_no_hoist_from_conditional,
+        test_hoist_from_for_range,
+        test_cse_temporary,
+        test_cse_reuses_variable_until_mutation,
+        test_cse_skips_fresh_and_conditional,
+        test_cse_keeps_first_error,
+        test_does_not_mutate_input,
+        test_source_map_follows_statements,
+        test_go_parity,
+    ]
+
+    print("Running CSE and loop-invariant hoisting tests...\n")
+    for test in tests:
+        test()
+        print(f"  {test.__name__}: ✓")
+
+    print(f"\nAll {len(tests)} CSE and loop-invariant hoisting tests passed! ✓")
+
+
+if __name__ == "__main__":
+    main()
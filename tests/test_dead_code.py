@@ -0,0 +1,173 @@
+"""Tests for the Core IL dead code elimination pass."""
+
+from __future__ import annotations
+
+import io
+import json
+from contextlib import redirect_stdout
+
+from english_compiler.coreil.dead_code import describe_removals, eliminate_dead_code
+from english_compiler.coreil.interp import run_coreil
+
+
+def _run_and_capture(doc: dict) -> str:
+    """Run a Core IL program and capture stdout."""
+    buf = io.StringIO()
+    with redirect_stdout(buf):
+        rc = run_coreil(doc)
+    assert rc == 0, f"Interpreter failed with exit code {rc}"
+    return buf.getvalue()
+
+
+def _make_program(body: list[dict], version: str = "coreil-1.9") -> dict:
+    return {"version": version, "body": body}
+
+
+def _lit(value) -> dict:
+    return {"type": "Literal", "value": value}
+
+
+def _var(name: str) -> dict:
+    return {"type": "Var", "name": name}
+
+
+def _print(*args: dict) -> dict:
+    return {"type": "Print", "args": list(args)}
+
+
+def test_unreachable_after_return():
+    prog = _make_program([
+        {"type": "FuncDef", "name": "f", "params": [], "body": [
+            {"type": "Return", "value": _lit(1)},
+            _print(_lit("never")),
+        ]},
+        _print({"type": "Call", "name": "f", "args": []}),
+    ])
+    cleaned, removals = eliminate_dead_code(prog)
+    assert len(cleaned["body"][0]["body"]) == 1
+    assert [r["kind"] for r in removals] == ["unreachable"]
+    assert removals[0]["path"] == "$.body[0].body[1]"
+    assert _run_and_capture(prog) == _run_and_capture(cleaned)
+
+
+def test_constant_branches():
+    prog = _make_program([
+        {"type": "If", "test": _lit(False),
+         "then": [_print(_lit(1))], "else": [_print(_lit(2))]},
+        {"type": "If", "test": _lit(True),
+         "then": [_print(_lit(3))], "else": [_print(_lit(4))]},
+        {"type": "If", "test": _lit(False), "then": [_print(_lit(5))]},
+        {"type": "While", "test": _lit(False), "body": [_print(_lit(6))]},
+    ])
+    cleaned, removals = eliminate_dead_code(prog)
+    assert len(cleaned["body"]) == 2
+    assert all("else" not in stmt for stmt in cleaned["body"])
+    assert [r["path"] for r in removals] == [
+        "$.body[0].then", "$.body[1].else", "$.body[2].then", "$.body[3]",
+    ]
+    assert _run_and_capture(cleaned) == "2\n3\n"
+    assert _run_and_capture(prog) == _run_and_capture(cleaned)
+
+
+def test_unused_variables_removed_transitively():
+    """Removing b leaves a unused, so it goes too."""
+    prog = _make_program([
+        {"type": "Let", "name": "a", "value": _lit(1)},
+        {"type": "Let", "name": "b", "value": {"type": "Array", "items": [_var("a")]}},
+        {"type": "Let", "name": "c", "value": _lit(3)},
+        _print(_var("c")),
+    ])
+    cleaned, removals = eliminate_dead_code(prog)
+    assert [stmt.get("name") for stmt in cleaned["body"]] == ["c", None]
+    assert [r["path"] for r in removals] == ["$.body[0]", "$.body[1]"]
+    assert all(r["kind"] == "unused-variable" for r in removals)
+    assert _run_and_capture(prog) == _run_and_capture(cleaned)
+
+
+def test_keeps_side_effects():
+    """Unused variables whose value may fail or have effects are kept."""
+    prog = _make_program([
+        {"type": "FuncDef", "name": "f", "params": [], "body": [
+            _print(_lit("called")),
+            {"type": "Return", "value": _lit(0)},
+        ]},
+        {"type": "Let", "name": "x", "value": {"type": "Call", "name": "f", "args": []}},
+        {"type": "Let", "name": "y", "value": {
+            "type": "Binary", "op": "/", "left": _lit(1), "right": _lit(2),
+        }},
+        {"type": "While", "test": _lit(True), "body": [{"type": "Break"}]},
+    ])
+    cleaned, removals = eliminate_dead_code(prog)
+    assert removals == []
+    assert cleaned == prog
+
+
+def test_no_effect_statements():
+    prog = _make_program([
+        {"type": "Let", "name": "x", "value": _lit(1)},
+        {"type": "Assign", "name": "x", "value": _var("x")},
+        {"type": "If", "test": _var("x"), "then": []},
+        {"type": "For", "var": "i",
+         "iter": {"type": "Range", "from": _lit(0), "to": _lit(10)}, "body": []},
+        {"type": "TryCatch", "body": [], "catch_var": "e", "catch_body": [_print(_var("e"))]},
+        _print(_var("x")),
+    ])
+    cleaned, removals = eliminate_dead_code(prog)
+    assert [stmt["type"] for stmt in cleaned["body"]] == ["Let", "Print"]
+    assert all(r["kind"] == "no-effect" for r in removals)
+    assert len(removals) == 4
+    assert _run_and_capture(prog) == _run_and_capture(cleaned)
+
+
+def test_does_not_mutate_input():
+    prog = _make_program([
+        {"type": "Let", "name": "x", "value": _lit(1)},
+        {"type": "If", "test": _lit(False), "then": [_print(_lit(1))]},
+    ])
+    original = json.dumps(prog, sort_keys=True)
+    eliminate_dead_code(prog)
+    assert json.dumps(prog, sort_keys=True) == original
+
+
+def test_source_map_and_report():
+    source = (
+        "Let temp be 5.\n"
+        "Let total be 10.\n"
+        "Print the total.\n"
+    )
+    prog = _make_program([
+        {"type": "Let", "name": "temp", "value": _lit(5)},
+        {"type": "Let", "name": "total", "value": _lit(10)},
+        _print(_var("total")),
+    ])
+    prog["source_map"] = {"1": [0], "2": [1], "3": [2]}
+    cleaned, removals = eliminate_dead_code(prog)
+    assert cleaned["source_map"] == {"2": [0], "3": [1]}
+    lines = describe_removals(removals, prog, source)
+    assert lines == ['line 1: "Let temp be 5." - the variable \'temp\' is never used']
+    assert describe_removals(removals, prog) == [
+        "$.body[0]: the variable 'temp' is never used",
+    ]
+
+
+def main() -> None:
+    tests = [
+        test_unreachable_after_return,
+        test_constant_branches,
+        test_unused_variables_removed_transitively,
+        test_keeps_side_effects,
+        test_no_effect_statements,
+        test_does_not_mutate_input,
+        test_source_map_and_report,
+    ]
+
+    print("Running dead code elimination tests...\n")
+    for test in tests:
+        test()
+        print(f"  {test.__name__}: ✓")
+
+    print(f"\nAll {len(tests)} dead code elimination tests passed! ✓")
+
+
+if __name__ == "__main__":
+    main()
@@ -427,6 +427,59 @@ def test_empty_pop_error():
     assert exit_code == 1  # Should fail at runtime
 
 
+def test_bounded_deque():
+    """Test DequeNew maxlen eviction and Index on a deque."""
+    q = {"type": "Var", "name": "q"}
+    doc = {
+        "version": "coreil-1.1",
+        "body": [
+            {
+                "type": "Let",
+                "name": "q",
+                "value": {"type": "DequeNew", "maxlen": {"type": "Literal", "value": 3}},
+            },
+            {
+                "type": "For",
+                "var": "i",
+                "iter": {"type": "Range", "from": {"type": "Literal", "value": 1}, "to": {"type": "Literal", "value": 6}},
+                "body": [{"type": "PushBack", "base": q, "value": {"type": "Var", "name": "i"}}],
+            },
+            {"type": "PushFront", "base": q, "value": {"type": "Literal", "value": 0}},
+            {
+                "type": "Print",
+                "args": [
+                    {"type": "DequeSize", "base": q},
+                    {"type": "Index", "base": q, "index": {"type": "Literal", "value": 0}},
+                    {"type": "Index", "base": q, "index": {"type": "Literal", "value": -1}},
+                ],
+            },
+        ],
+    }
+
+    errors = validate_coreil(doc)
+    assert not errors, f"Validation failed: {errors}"
+
+    buffer = io.StringIO()
+    with redirect_stdout(buffer):
+        exit_code = run_coreil(doc)
+    assert exit_code == 0
+    interp_output = buffer.getvalue()
+
+    code, _ = emit_python(doc)
+    assert "deque(maxlen=3)" in code
+    with tempfile.NamedTemporaryFile(mode="w", suffix=".py", delete=False) as f:
+        f.write(code)
+        f.flush()
+        result = subprocess.run(
+            ["python", f.name], capture_output=True, text=True, check=True
+        )
+        Path(f.name).unlink()
+
+    # PushBack 1..5 keeps [3, 4, 5]; PushFront 0 evicts 5 → [0, 3, 4]
+    assert interp_output == "3 0 4\n"
+    assert result.stdout == interp_output
+
+
 if __name__ == "__main__":
     test_deque_new()
     test_pushback()
@@ -435,4 +488,5 @@ if __name__ == "__main__":
     test_popback()
     test_mixed_pushpop()
     test_empty_pop_error()
+    test_bounded_deque()
     print("All deque tests passed!")
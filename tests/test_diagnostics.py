@@ -0,0 +1,155 @@
+"""Tests for English-level diagnostics of verifier violations."""
+
+from __future__ import annotations
+
+import io
+from contextlib import redirect_stdout
+
+from english_compiler.coreil.diagnostics import explain_violations, format_diagnostics
+from english_compiler.coreil.interp import run_coreil
+from english_compiler.coreil.source_map import sentence_spans
+from english_compiler.coreil.verify import verify_coreil
+
+
+def _lit(value) -> dict:
+    return {"type": "Literal", "value": value}
+
+
+def _var(name: str) -> dict:
+    return {"type": "Var", "name": name}
+
+
+def _print(*args: dict) -> dict:
+    return {"type": "Print", "args": list(args)}
+
+
+def _minus(left: dict, right: dict) -> dict:
+    return {"type": "Binary", "op": "-", "left": left, "right": right}
+
+
+def _program(source: str, body: list[dict]) -> dict:
+    """A document for source with one statement per line, as a frontend emits it."""
+    return {
+        "version": "coreil-1.9",
+        "body": body,
+        "source_map": {str(i + 1): [i] for i in range(len(body))},
+        "sentences": sentence_spans(source),
+    }
+
+
+def _messages(doc: dict, **kwargs) -> list[str | None]:
+    diagnostics = explain_violations(doc, verify_coreil(doc), **kwargs)
+    return [d.message if d is not None else None for d in diagnostics]
+
+
+def test_word_used_as_two_types():
+    doc = _program(
+        'Let total be "none".\nLet count be 3.\nPrint count.\nPrint total minus 1.\n',
+        [
+            {"type": "Let", "name": "total", "value": _lit("none")},
+            {"type": "Let", "name": "count", "value": _lit(3)},
+            _print(_var("count")),
+            _print(_minus(_var("total"), _var("count"))),
+        ],
+    )
+    [diagnostic] = explain_violations(doc, verify_coreil(doc))
+    assert diagnostic.message == "the word 'total' is used as both text and a number in sentence 1 and sentence 4"
+    assert [s.text for s in diagnostic.sentences] == ['Let total be "none".', "Print total minus 1."]
+
+
+def test_operands_without_a_word():
+    doc = _program('Print "a" minus 1.\n', [_print(_minus(_lit("a"), _lit(1)))])
+    assert _messages(doc) == ["sentence 1 applies '-' to text and a number, which always fails"]
+
+
+def test_function_variables_are_scoped():
+    # The top-level 'x' is a number; the function's is text
+    doc = _program(
+        "Let x be 1.\nDefine f: let x be \"a\", and return x minus 1.\n",
+        [
+            {"type": "Let", "name": "x", "value": _lit(1)},
+            {"type": "FuncDef", "name": "f", "params": [], "body": [
+                {"type": "Let", "name": "x", "value": _lit("a")},
+                {"type": "Return", "value": _minus(_var("x"), _lit(1))},
+            ]},
+        ],
+    )
+    assert _messages(doc) == ["the word 'x' is used as both text and a number in sentence 2"]
+
+
+def test_undefined_words_and_functions():
+    doc = _program(
+        "Print y.\nLet y be 2.\nPrint z.\nPrint twice of 2.\nCall half with 1 and 2.\nDefine half of n.\n",
+        [
+            _print(_var("y")),
+            {"type": "Let", "name": "y", "value": _lit(2)},
+            _print(_var("z")),
+            _print({"type": "Call", "name": "twice", "args": [_lit(2)]}),
+            _print({"type": "Call", "name": "half", "args": [_lit(1), _lit(2)]}),
+            {"type": "FuncDef", "name": "half", "params": ["n"], "body": [{"type": "Return", "value": _var("n")}]},
+        ],
+    )
+    assert _messages(doc) == [
+        "the word 'y' is used in sentence 1 before sentence 2 gives it a value",
+        "the word 'z' is used in sentence 3, but no sentence gives it a value",
+        "sentence 4 uses 'twice', but no sentence defines it",
+        "sentence 5 gives 'half' 2 values, but sentence 6 defines it with 1",
+    ]
+
+
+def test_needs_sentence_information():
+    doc = _program('Print "a" minus 1.\n', [_print(_minus(_lit("a"), _lit(1)))])
+    del doc["sentences"]
+    assert _messages(doc) == [None]
+    # The English source works in place of the spans
+    assert _messages(doc, source_text='Print "a" minus 1.\n') == [
+        "sentence 1 applies '-' to text and a number, which always fails"
+    ]
+    # Violations with no English form are left alone
+    broken = _program("Print.\n", [{"type": "Print"}])
+    assert _messages(broken) == [None]
+
+
+def test_run_reports_in_english():
+    doc = _program(
+        'Let total be "none".\nPrint total minus 1.\n',
+        [
+            {"type": "Let", "name": "total", "value": _lit("none")},
+            _print(_minus(_var("total"), _lit(1))),
+        ],
+    )
+    buf = io.StringIO()
+    with redirect_stdout(buf):
+        assert run_coreil(doc) == 1
+    assert buf.getvalue().splitlines() == [
+        "invalid program:",
+        "  $.body[1].args[0]: operator '-' cannot be applied to str and int (line 2)",
+        "    the word 'total' is used as both text and a number in sentence 1 and sentence 2",
+        '      sentence 1 (line 1): Let total be "none".',
+        "      sentence 2 (line 2): Print total minus 1.",
+    ]
+    assert format_diagnostics(doc, verify_coreil(doc))[0] == (
+        "$.body[1].args[0]: operator '-' cannot be applied to str and int (line 2)"
+    )
+
+
+def main() -> None:
+    tests = [
+        test_word_used_as_two_types,
+        test_operands_without_a_word,
+        test_function_variables_are_scoped,
+        test_undefined_words_and_functions,
+        test_needs_sentence_information,
+        test_run_reports_in_english,
+    ]
+
+    print("Running English diagnostics tests...\n")
+    for test in tests:
+        test()
+        print(f"  {test.__name__}: ✓")
+
+    print(f"\nAll {len(tests)} English diagnostics tests passed! ✓")
+
+
+if __name__ == "__main__":
+    main()
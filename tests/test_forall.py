@@ -0,0 +1,265 @@
+"""Tests for forAll property-based testing (coreil/forall.py)."""
+
+from __future__ import annotations
+
+import json
+import shutil
+import subprocess
+import tempfile
+from pathlib import Path
+
+from english_compiler.coreil.emit_go import emit_go, get_runtime_path
+from english_compiler.coreil.forall import (
+    PropertyFailed,
+    _SplitMix64,
+    for_all,
+    generate,
+    parse_generators,
+    shrink,
+)
+from english_compiler.coreil.lint import lint_coreil
+from english_compiler.coreil.module import resolve_imports
+from english_compiler.coreil.verify import verify_coreil
+from tests.test_helpers import GO_AVAILABLE, run_go_backend, run_interpreter
+
+
+def _lit(value) -> dict:
+    return {"type": "Literal", "value": value}
+
+
+def _var(name: str) -> dict:
+    return {"type": "Var", "name": name}
+
+
+def _for_all(generator: dict, prop: str, *trials: dict) -> dict:
+    return {"type": "Call", "name": "forAll", "args": [generator, _lit(prop), *trials]}
+
+
+def _func(name: str, params: list[str], value: dict) -> dict:
+    return {"type": "FuncDef", "name": name, "params": params, "body": [{"type": "Return", "value": value}]}
+
+
+def _program(*body: dict) -> dict:
+    return {"version": "coreil-1.11", "body": list(body)}
+
+
+# Lists shorter than 3 elements, and numbers that are at most 7
+_SHORT = _func("short", ["xs"], {"type": "Binary", "op": "<", "left": {"type": "Length", "base": _var("xs")},
+                                 "right": _lit(3)})
+_SMALL = _func("small", ["n", "s"], {"type": "Binary", "op": "<=", "left": _var("n"), "right": _lit(7)})
+_THROWS = {
+    "type": "FuncDef", "name": "no_big", "params": ["n"], "body": [
+        {"type": "If", "test": {"type": "Binary", "op": ">", "left": _var("n"), "right": _lit(7)},
+         "then": [{"type": "Throw", "message": {"type": "Binary", "op": "+", "left": _lit("big "),
+                                                "right": {"type": "ToString", "value": _var("n")}}}]},
+        {"type": "Return", "value": _lit(True)},
+    ],
+}
+
+
+def test_generation_and_shrinking():
+    # The splitmix64 reference sequence for seed 0
+    rng = _SplitMix64()
+    assert [rng.next() for _ in range(2)] == [0xE220A8397B1DCDAF, 0x6E789E6AA1B965F4]
+
+    assert parse_generators("array< int >") == ["array<int>"]
+    assert parse_generators(["int", "array<array<string>>"]) == ["int", "array<array<string>>"]
+    for bad in ("float", "array<>", 3, ["int", None]):
+        try:
+            parse_generators(bad)
+        except ValueError as exc:
+            assert "forAll: unknown generator" in str(exc)
+        else:
+            raise AssertionError(f"expected {bad!r} to be rejected")
+
+    values = [generate("array<string>", _SplitMix64(7), 10) for _ in range(2)]
+    assert values[0] == values[1] and all(len(s) <= 10 for s in values[0])
+    assert all(-5 <= generate("int", rng, 5) <= 5 for _ in range(50))
+
+    assert list(shrink("int", 10)) == [0, 5, 9]
+    assert list(shrink("int", -3)) == [0, -1, -2]
+    assert list(shrink("int", 1)) == [0]
+    assert list(shrink("bool", True)) == [False] and list(shrink("bool", False)) == []
+    assert list(shrink("string", "ab")) == ["", "a", "b", "a", "aa"]
+    assert list(shrink("array<int>", [2, 0])) == [[], [2], [0], [2], [0, 0], [1, 0]]
+
+
+def test_for_all():
+    assert for_all("int", "any", 50, lambda args: None) is True
+    assert for_all("int", "never", 0, lambda args: "") is True
+
+    # Shrinks to the smallest failing input, and says why it failed
+    def check(args):
+        return None if all(n < 10 for n in args[0]) else f"max {max(args[0])}"
+
+    try:
+        for_all("array<int>", "below_10", 100, check)
+    except PropertyFailed as exc:
+        message = str(exc)
+    else:
+        raise AssertionError("expected the property to fail")
+    assert message.startswith("forAll: property 'below_10' failed after ")
+    assert " for [10], shrunk from [" in message and message.endswith(": max 10")
+
+    # The property gets copies of the arguments
+    seen = []
+
+    def mutate(args):
+        seen.append(list(args[0]))
+        args[0].append(1)
+        return ""
+
+    try:
+        for_all("array<int>", "mutates", 5, mutate)
+    except PropertyFailed as exc:
+        assert str(exc) == "forAll: property 'mutates' failed after 1 trial(s) for [], shrunk from [-1]"
+    assert seen == [[-1], []]
+
+    for trials in (-1, 2.5, True):
+        try:
+            for_all("int", "p", trials, lambda args: None)
+        except ValueError as exc:
+            assert str(exc) == f"forAll: trials must be a non-negative integer, got {trials!r}"
+        else:
+            raise AssertionError(f"expected trials={trials!r} to be rejected")
+
+
+def test_interpreter():
+    doc = _program(
+        _SHORT, _SMALL, _THROWS,
+        {"type": "Print", "args": [_for_all(_lit("array<int>"), "short", _lit(2))]},
+        {"type": "TryCatch", "body": [_for_all(_lit("int"), "no_big")], "catch_var": "e",
+         "catch_body": [{"type": "Print", "args": [_var("e")]}]},
+        _for_all({"type": "Array", "items": [_lit("int"), _lit("string")]}, "small"),
+    )
+    result = run_interpreter(doc)
+    lines = result.output.splitlines()
+    assert result.exit_code == 1
+    assert lines[0] == "True"
+    assert lines[1].startswith("forAll: property 'no_big' failed after ")
+    assert " for 8, shrunk from " in lines[1] and lines[1].endswith(": big 8")
+    assert lines[2].startswith("runtime error: forAll: property 'small' failed after ")
+    assert " for (8, ''), shrunk from (" in lines[2]
+
+
+def test_verify_and_lint():
+    def messages(*body: dict) -> list[str]:
+        return [v["message"] for v in verify_coreil(_program(*body))]
+
+    assert messages(_SHORT, _for_all(_lit("array<int>"), "short")) == []
+    assert messages(_SMALL, _for_all(_lit("int"), "small")) == [
+        "forAll property 'small' takes 2 argument(s), but gets 1 generated value(s)",
+    ]
+    assert messages(_for_all(_lit("int"), "missing")) == ["forAll property 'missing' is not a defined function"]
+    assert "forAll property must be a function name, as a string literal" in messages(
+        _SHORT, {"type": "Call", "name": "forAll", "args": [_lit("int"), _lit(3)]},
+    )
+    assert messages(_SHORT, _for_all(_lit("int"), "short", _lit(1), _lit(2))) == [
+        "builtin 'forAll' takes 2 to 3 arguments, got 4",
+    ]
+    # A generator computed at run time is checked when it runs
+    assert messages(_SMALL, {"type": "Let", "name": "g", "value": _lit("int")}, _for_all(_var("g"), "small")) == []
+
+    # The property counts as a use of its function
+    unused = [d["message"] for d in lint_coreil(_program(_SHORT, _for_all(_lit("array<int>"), "short")))]
+    assert not any("'short'" in message for message in unused)
+
+
+def test_modules():
+    module = _program(
+        _SHORT,
+        {"type": "FuncDef", "name": "check", "params": [], "body": [
+            {"type": "Return", "value": _for_all(_lit("array<int>"), "short", _lit(5))},
+        ]},
+    )
+    with tempfile.TemporaryDirectory() as tmp_dir:
+        Path(tmp_dir, "props.coreil.json").write_text(json.dumps(module), encoding="utf-8")
+        doc = _program(
+            {"type": "Import", "path": "props"},
+            {"type": "Print", "args": [{"type": "Call", "name": "props.check", "args": []}]},
+            {"type": "Print", "args": [_for_all(_lit("array<int>"), "props.short", _lit(2))]},
+        )
+        resolved = resolve_imports(doc, base_dir=Path(tmp_dir))
+    calls = [stmt["body"][0]["value"] for stmt in resolved["body"] if stmt.get("name") == "props__check"]
+    assert calls[0]["args"][1] == _lit("props__short")
+    assert resolved["body"][-1]["args"][0]["args"][1] == _lit("props__short")
+    assert verify_coreil(resolved) == []
+    assert run_interpreter(resolved).output.startswith("runtime error: forAll: property 'props__short' failed")
+
+
+def test_go_parity():
+    if not GO_AVAILABLE:
+        return
+    doc = _program(
+        _SHORT, _SMALL, _THROWS,
+        {"type": "Print", "args": [_for_all(_lit("array<int>"), "short", _lit(2))]},
+        {"type": "Print", "args": [_for_all(_lit("array<array<bool>>"), "short", _lit(0))]},
+        _for_all(_lit("int"), "no_big", _lit(50)),
+        _for_all({"type": "Array", "items": [_lit("int"), _lit("string")]}, "small"),
+    )
+    interpreted = run_interpreter(doc)
+    compiled = run_go_backend(doc, timeout=120)
+    assert compiled.output == "True\nTrue\n"
+    assert interpreted.output.startswith(compiled.output)
+    # Same counterexample, from the same generators and shrinking
+    expected = interpreted.output[len(compiled.output):].strip()
+    assert expected.startswith("runtime error: forAll: property 'no_big' failed after ")
+    assert " for 8, shrunk from " in expected and expected.endswith(": big 8")
+    assert compiled.error.startswith(expected + " — at $.body[5]"), compiled.error
+
+    doc["body"][5] = _for_all({"type": "Array", "items": [_lit("int"), _lit("string")]}, "small")
+    expected = run_interpreter(doc).output[len(compiled.output):].strip()
+    assert " for (8, ''), shrunk from (" in expected
+    assert run_go_backend(doc, timeout=120).error.startswith(expected + " — at $.body[5]")
+
+
+def test_go_test_mode():
+    if not GO_AVAILABLE:
+        return
+    doc = _program(
+        _SHORT,
+        _func("same_length", ["xs"], {"type": "Binary", "op": "==", "left": {"type": "Length", "base": _var("xs")},
+                                      "right": {"type": "Length", "base": _var("xs")}}),
+        {"type": "FuncDef", "name": "test_same_length", "params": [], "body": [
+            _for_all(_lit("array<string>"), "same_length"),
+        ]},
+        {"type": "FuncDef", "name": "test_short", "params": [], "body": [
+            _for_all(_lit("array<string>"), "short"),
+        ]},
+    )
+    code, _ = emit_go(doc, test_mode=True)
+    with tempfile.TemporaryDirectory() as tmp_dir:
+        Path(tmp_dir, "main.go").write_text(code, encoding="utf-8")
+        shutil.copy(get_runtime_path(), Path(tmp_dir) / "coreil_runtime.go")
+        result = subprocess.run(
+            ["go", "run", "main.go", "coreil_runtime.go"],
+            cwd=tmp_dir, capture_output=True, text=True, timeout=120,
+        )
+    assert result.returncode == 1
+    lines = result.stdout.splitlines()
+    assert "PASS test_same_length" in lines and "FAIL test_short" in lines
+    assert ("    runtime error: forAll: property 'short' failed after 4 trial(s) for ['', '', ''], "
+            "shrunk from ['9', 'mh', '2z']") in lines
+
+
+def main() -> None:
+    tests = [
+        test_generation_and_shrinking,
+        test_for_all,
+        test_interpreter,
+        test_verify_and_lint,
+        test_modules,
+        test_go_parity,
+        test_go_test_mode,
+    ]
+
+    print("Running forAll tests...\n")
+    for test in tests:
+        test()
+        print(f"  {test.__name__}: ✓")
+
+    print(f"\nAll {len(tests)} forAll tests passed! ✓")
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,191 @@
+"""Tests for the frontend Core IL cache keyed on English source."""
+
+from __future__ import annotations
+
+import io
+import json
+import os
+import tempfile
+import threading
+from contextlib import redirect_stdout
+from http.server import BaseHTTPRequestHandler, HTTPServer
+from pathlib import Path
+from unittest import mock
+
+from english_compiler.frontend.base import BaseFrontend
+from english_compiler.frontend.cache import CoreILCache, normalize_source
+
+HELLO = {
+    "version": "coreil-1.9",
+    "body": [{"type": "Print", "args": [{"type": "Literal", "value": "hello"}]}],
+}
+
+
+class _CountingFrontend(BaseFrontend):
+    """Stub LLM frontend that always answers HELLO and counts its calls."""
+
+    def __init__(self) -> None:
+        super().__init__()
+        self.calls = 0
+
+    def _call_api(self, user_message: str) -> dict:
+        self.calls += 1
+        return json.loads(json.dumps(HELLO))
+
+    def _call_api_text(self, user_message: str, system_prompt: str) -> str:
+        return ""
+
+    def get_model_name(self) -> str:
+        return "stub-1"
+
+
+class _RemoteStore:
+    """In-memory remote cache served over HTTP on localhost."""
+
+    def __init__(self) -> None:
+        self.entries: dict[str, bytes] = {}
+        self.auth: list[str | None] = []
+        store = self
+
+        class Handler(BaseHTTPRequestHandler):
+            def do_GET(self) -> None:
+                store.auth.append(self.headers.get("Authorization"))
+                body = store.entries.get(self.path)
+                self.send_response(200 if body is not None else 404)
+                self.end_headers()
+                if body is not None:
+                    self.wfile.write(body)
+
+            def do_PUT(self) -> None:
+                store.auth.append(self.headers.get("Authorization"))
+                length = int(self.headers["Content-Length"])
+                store.entries[self.path] = self.rfile.read(length)
+                self.send_response(201)
+                self.end_headers()
+
+            def log_message(self, *args) -> None:
+                pass
+
+        self.server = HTTPServer(("127.0.0.1", 0), Handler)
+        self.url = f"http://127.0.0.1:{self.server.server_port}/cache"
+        threading.Thread(target=self.server.serve_forever, daemon=True).start()
+
+    def close(self) -> None:
+        self.server.shutdown()
+        self.server.server_close()
+
+
+def test_normalize_source():
+    assert normalize_source("\n\nPrint hi.  \r\nPrint bye.\t\n\n") == "Print hi.\nPrint bye."
+    # Spacing inside a line is kept
+    assert normalize_source('Print "a  b".') == 'Print "a  b".'
+    # NFC: a precomposed and a combining e-acute are the same text
+    assert normalize_source("caf\u00e9") == normalize_source("cafe\u0301")
+
+
+def test_key_inputs():
+    cache = CoreILCache(Path(tempfile.gettempdir()) / "unused")
+    key = cache.key("Print hi.", "model-a")
+    assert key == cache.key("Print hi.  \n\n", "model-a")
+    assert key != cache.key("Print hi!", "model-a")
+    assert key != cache.key("Print hi.", "model-b")
+    with mock.patch("english_compiler.frontend.cache.PACKAGE_VERSION", "0.0.0"):
+        assert key != cache.key("Print hi.", "model-a")
+
+
+def test_local_roundtrip():
+    with tempfile.TemporaryDirectory() as tmp_dir:
+        cache = CoreILCache(Path(tmp_dir))
+        key = cache.key("Print hello.", "stub-1")
+        assert cache.get(key) is None
+        cache.put(key, HELLO)
+        hit = cache.get(key)
+        assert hit is not None and hit.origin == "local" and hit.coreil == HELLO
+        # Another process sharing the directory sees the entry
+        assert CoreILCache(Path(tmp_dir)).get(key).coreil == HELLO
+
+
+def test_remote_store():
+    store = _RemoteStore()
+    try:
+        with tempfile.TemporaryDirectory() as first, tempfile.TemporaryDirectory() as second:
+            writer = CoreILCache(Path(first), remote_url=store.url, token="secret")
+            key = writer.key("Print hello.", "stub-1")
+            writer.put(key, HELLO)
+            assert writer.remote_error is None
+            assert json.loads(store.entries[f"/cache/{key}.json"]) == HELLO
+
+            # A teammate's empty local cache fills from the remote one
+            reader = CoreILCache(Path(second), remote_url=store.url + "/", token="secret")
+            hit = reader.get(key)
+            assert hit is not None and hit.origin == "remote" and hit.coreil == HELLO
+            assert reader.get(key).origin == "local"
+            assert set(store.auth) == {"Bearer secret"}
+
+            # Entries that fail verification are ignored
+            bad = reader.key("Print nothing.", "stub-1")
+            store.entries[f"/cache/{bad}.json"] = b'{"version": "coreil-1.9", "body": [{"type": "Print"}]}'
+            assert reader.get(bad) is None
+            assert reader.remote_error == f"{store.url}: ignored invalid entry {bad}"
+    finally:
+        store.close()
+
+    # An unreachable store only costs a miss
+    with tempfile.TemporaryDirectory() as tmp_dir:
+        cache = CoreILCache(Path(tmp_dir), remote_url=store.url, timeout=2)
+        assert cache.get(key) is None
+        assert cache.remote_error is not None
+
+
+def test_compile_uses_cache():
+    from english_compiler.__main__ import main
+
+    frontend = _CountingFrontend()
+    with tempfile.TemporaryDirectory() as tmp_dir:
+        tmp_path = Path(tmp_dir)
+        first, second = tmp_path / "a" / "hello.txt", tmp_path / "b" / "hello.txt"
+        for path in (first, second):
+            path.parent.mkdir()
+            path.write_text("Print hello.\n", encoding="utf-8")
+
+        buf = io.StringIO()
+        with (
+            mock.patch.dict(
+                os.environ, {"COREIL_CACHE_DIR": str(tmp_path / "cache"), "COREIL_REMOTE_CACHE": ""}
+            ),
+            mock.patch("english_compiler.frontend.get_frontend", return_value=frontend),
+            redirect_stdout(buf),
+        ):
+            assert main(["compile", str(first)]) == 0
+            # Same English in another checkout: no LLM call
+            assert main(["compile", str(second)]) == 0
+            # --regen always asks the LLM
+            assert main(["compile", "--regen", str(second)]) == 0
+
+        lines = buf.getvalue().splitlines()
+        assert frontend.calls == 2, lines
+        assert f"Regenerating Core IL for {first} using stub-1" in lines
+        assert f"Using cached Core IL for {second} from the local cache" in lines
+        coreil = second.parent / "output" / "coreil" / "hello.coreil.json"
+        assert json.loads(coreil.read_text(encoding="utf-8")) == HELLO
+
+
+def main() -> None:
+    tests = [
+        test_normalize_source,
+        test_key_inputs,
+        test_local_roundtrip,
+        test_remote_store,
+        test_compile_uses_cache,
+    ]
+
+    print("Running frontend cache tests...\n")
+    for test in tests:
+        test()
+        print(f"  {test.__name__}: ✓")
+
+    print(f"\nAll {len(tests)} frontend cache tests passed! ✓")
+
+
+if __name__ == "__main__":
+    main()
@@ -2,17 +2,33 @@
 
 from __future__ import annotations
 
+import email
+import email.policy
+import gzip
 import io
 import json
+import os
+import re
 import shutil
+import socket
 import subprocess
+import struct
 import sys
 import tempfile
+import threading
+import time
+import zipfile
 from contextlib import redirect_stdout
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
 from pathlib import Path
 
-from english_compiler.coreil.emit_go import emit_go, get_runtime_path
+from english_compiler.coreil.coverage import build_report, format_summary, load_counts, render_html
+from english_compiler.coreil.emit_go import emit_go, get_runtime_path, program_hash
 from english_compiler.coreil.interp import run_coreil
+from english_compiler.coreil.profile import build_report as build_profile_report
+from english_compiler.coreil.profile import format_summary as format_profile_summary
+from english_compiler.coreil.verify import verify_coreil
+from fuzz.go_runtime import run as run_native_fuzz
 
 
 def _has_go() -> bool:
@@ -29,133 +45,3996 @@ def _run_interp(doc: dict) -> str:
     return buf.getvalue()
 
 
-def _run_go(doc: dict) -> str:
+def _exec_go(
+    doc: dict, *, host_code: str = "", extra_files: tuple[Path, ...] = (), **emit_options
+) -> subprocess.CompletedProcess:
+    """Compile and run Go code from Core IL doc, returning the result.
+
+    host_code, if given, is compiled alongside as an extra file in package
+    main, standing in for an embedding host (e.g. an init func configuring
+    DefaultEngine). extra_files are copied next to the runtime.
+    """
+    with tempfile.TemporaryDirectory() as tmpdir:
+        binary = _build_go(
+            doc, Path(tmpdir), host_code=host_code, extra_files=extra_files, **emit_options
+        )
+        return subprocess.run(
+            [str(binary)],
+            capture_output=True,
+            text=True,
+            timeout=30,
+        )
+
+
+def _build_go(
+    doc: dict,
+    tmppath: Path,
+    *,
+    host_code: str = "",
+    extra_files: tuple[Path, ...] = (),
+    **emit_options,
+) -> Path:
+    """Compile Go code from Core IL doc in tmppath, returning the binary."""
+    if not host_code:
+        # Every runtime helper the program calls is a registered Go builtin
+        assert verify_coreil(doc, target="go") == [], verify_coreil(doc, target="go")
+    code, _ = emit_go(doc, **emit_options)
+    # Write generated code
+    go_file = tmppath / "main.go"
+    go_file.write_text(code, encoding="utf-8")
+    if host_code:
+        (tmppath / "host.go").write_text(host_code, encoding="utf-8")
+    # Copy runtime
+    runtime_src = get_runtime_path()
+    shutil.copy(runtime_src, tmppath / "coreil_runtime.go")
+    for path in extra_files:
+        shutil.copy(path, tmppath / path.name)
+    # Initialize Go module
+    subprocess.run(
+        ["go", "mod", "init", "coreil_test"],
+        cwd=str(tmppath),
+        capture_output=True,
+        timeout=30,
+    )
+    # Build
+    build_result = subprocess.run(
+        ["go", "build", "-o", "test_prog", "."],
+        cwd=str(tmppath),
+        capture_output=True,
+        text=True,
+        timeout=60,
+    )
+    assert build_result.returncode == 0, f"Go build failed:\n{build_result.stderr}"
+    return tmppath / "test_prog"
+
+
+def _run_go(doc: dict, **options) -> str:
     """Compile and run Go code from Core IL doc, return stdout."""
-    code, _ = emit_go(doc)
+    run_result = _exec_go(doc, **options)
+    assert run_result.returncode == 0, f"Go run failed:\n{run_result.stderr}"
+    return run_result.stdout
+
+
+def _run_go_tests(doc: dict) -> subprocess.CompletedProcess:
+    """Compile Core IL doc in Go test mode and run it, returning the result."""
+    code, _ = emit_go(doc, test_mode=True)
     with tempfile.TemporaryDirectory() as tmpdir:
         tmppath = Path(tmpdir)
-        # Write generated code
-        go_file = tmppath / "main.go"
-        go_file.write_text(code, encoding="utf-8")
-        # Copy runtime
-        runtime_src = get_runtime_path()
-        shutil.copy(runtime_src, tmppath / "coreil_runtime.go")
-        # Initialize Go module
-        subprocess.run(
-            ["go", "mod", "init", "coreil_test"],
+        (tmppath / "main.go").write_text(code, encoding="utf-8")
+        shutil.copy(get_runtime_path(), tmppath / "coreil_runtime.go")
+        return subprocess.run(
+            ["go", "run", "main.go", "coreil_runtime.go"],
             cwd=str(tmppath),
             capture_output=True,
+            text=True,
+            timeout=60,
+        )
+
+
+def _lit(v) -> dict:
+    return {"type": "Literal", "value": v}
+
+
+def _var(n: str) -> dict:
+    return {"type": "Var", "name": n}
+
+
+def _bin(op: str, l: dict, r: dict) -> dict:
+    return {"type": "Binary", "op": op, "left": l, "right": r}
+
+
+def _prog(body: list[dict]) -> dict:
+    return {"version": "coreil-1.9", "body": body}
+
+
+# --- Codegen-only tests (no Go compiler needed) ---
+
+def test_codegen_hello():
+    """Basic codegen produces valid Go code."""
+    doc = _prog([{"type": "Print", "args": [_lit("hello")]}])
+    code, _ = emit_go(doc)
+    assert "package main" in code
+    assert "coreilPrint" in code
+    assert "hello" in code
+
+
+def test_codegen_function():
+    """Function definition codegen."""
+    doc = _prog([
+        {"type": "FuncDef", "name": "add", "params": ["a", "b"], "body": [
+            {"type": "Return", "value": _bin("+", _var("a"), _var("b"))},
+        ]},
+        {"type": "Print", "args": [{"type": "Call", "name": "add", "args": [_lit(2), _lit(3)]}]},
+    ])
+    code, _ = emit_go(doc)
+    assert "func fn_add(" in code
+    assert "return" in code
+
+
+def test_codegen_if_else():
+    """If/else codegen."""
+    doc = _prog([
+        {"type": "If", "test": _lit(True),
+         "then": [{"type": "Print", "args": [_lit("yes")]}],
+         "else": [{"type": "Print", "args": [_lit("no")]}]},
+    ])
+    code, _ = emit_go(doc)
+    assert "if true {" in code
+    assert "} else {" in code
+
+
+def test_codegen_while():
+    """While loop codegen."""
+    doc = _prog([
+        {"type": "Let", "name": "i", "value": _lit(0)},
+        {"type": "While", "test": _bin("<", _var("i"), _lit(3)), "body": [
+            {"type": "Print", "args": [_var("i")]},
+            {"type": "Assign", "name": "i", "value": _bin("+", _var("i"), _lit(1))},
+        ]},
+    ])
+    code, _ = emit_go(doc)
+    assert "for {" in code
+
+
+def test_codegen_try_catch():
+    """TryCatch codegen."""
+    doc = _prog([
+        {"type": "TryCatch", "body": [
+            {"type": "Throw", "message": _lit("oops")},
+        ], "catch_var": "e", "catch_body": [
+            {"type": "Print", "args": [_var("e")]},
+        ]},
+    ])
+    code, _ = emit_go(doc)
+    assert "recover()" in code
+    assert "panic(" in code
+    assert "e := caughtError(__r)" in code
+
+
+def test_codegen_for_range():
+    """For loop with Range codegen."""
+    doc = _prog([
+        {"type": "For", "var": "i",
+         "iter": {"type": "Range", "from": _lit(0), "to": _lit(3), "inclusive": False},
+         "body": [{"type": "Print", "args": [_var("i")]}]},
+    ])
+    code, _ = emit_go(doc)
+    assert "__from" in code
+    assert "__to" in code
+
+
+def test_codegen_test_mode():
+    doc = _prog([
+        {"type": "FuncDef", "name": "test_ok", "params": [], "body": []},
+        {"type": "FuncDef", "name": "test_helper", "params": ["x"], "body": []},
+        {"type": "Print", "args": [_lit("not run")]},
+    ])
+    code, _ = emit_go(doc, test_mode=True)
+    assert '{"test_ok", fn_test_ok},' in code
+    assert '{"test_helper", ' not in code
+    assert "not run" not in code
+
+
+def _fill_loop(name: str, fill: dict, lo: dict, hi: dict) -> dict:
+    return {
+        "type": "For", "var": "i",
+        "iter": {"type": "Range", "from": lo, "to": hi, "inclusive": False},
+        "body": [fill],
+    }
+
+
+def test_codegen_capacity_hints():
+    push = {"type": "Push", "base": _var("xs"), "value": _lit(0)}
+    put = {"type": "Set", "base": _var("m"), "key": _var("i"), "value": _var("i")}
+    doc = _prog([
+        {"type": "Let", "name": "n", "value": _lit(5)},
+        {"type": "Let", "name": "xs", "value": {"type": "Array", "items": []}},
+        _fill_loop("xs", push, _lit(0), _lit(10000)),
+        {"type": "Let", "name": "m", "value": {"type": "Map", "items": []}},
+        _fill_loop("m", put, _lit(1), _var("n")),
+        {"type": "Let", "name": "ys", "value": {"type": "Array", "items": []}},
+        {"type": "Print", "args": [_var("ys")]},
+    ])
+    code, _ = emit_go(doc)
+    assert "xs := ValueArrayWithCapacity(10000)" in code
+    assert "m := ValueMapWithCapacity(n-int64(1)+0)" in code
+    assert "ys := ValueArray(nil)" in code
+
+
+def test_codegen_execution_limits():
+    doc = _prog([
+        {"type": "FuncDef", "name": "f", "params": [], "body": [
+            {"type": "Return", "value": _lit(1)},
+        ]},
+        {"type": "While", "test": _lit(False), "body": []},
+        {"type": "TryCatch", "body": [], "catch_var": "e", "catch_body": []},
+    ])
+    code, _ = emit_go(doc)
+    func_body = code.split("func fn_f() Value {")[1]
+    assert func_body.lstrip().startswith("coreilStep()")
+    assert "for {\n\t\tcoreilStep()" in code
+    assert "rethrowLimit(__r)" in code
+
+
+def test_run_recursion_depth():
+    if not _has_go():
+        return
+    doc = _prog([
+        {"type": "FuncDef", "name": "countdown", "params": ["n"], "body": [
+            {"type": "If", "test": _bin("==", _var("n"), _lit(0)), "then": [
+                {"type": "Return", "value": _lit(0)},
+            ]},
+            # Not a tail call, so every level keeps its frame
+            {"type": "Return", "value": _bin("+", _lit(1), {"type": "Call", "name": "countdown", "args": [
+                _bin("-", _var("n"), _lit(1)),
+            ]})},
+        ]},
+        {"type": "Print", "args": [{"type": "Call", "name": "countdown", "args": [_lit(999)]}]},
+        {"type": "TryCatch",
+         "body": [{"type": "Print", "args": [{"type": "Call", "name": "countdown", "args": [_lit(5000)]}]}],
+         "catch_var": "err",
+         "catch_body": [{"type": "Print", "args": [_var("err")]}]},
+        # The stack unwinds fully, so a deep call succeeds again afterwards
+        {"type": "Print", "args": [{"type": "Call", "name": "countdown", "args": [_lit(999)]}]},
+    ])
+    code, _ = emit_go(doc)
+    assert 'coreilEnter("countdown")\n\tdefer coreilLeave()' in code
+    assert _run_go(doc) == (
+        "999\n"
+        "runtime error: maximum recursion depth exceeded in countdown (depth 1000)\n"
+        "999\n"
+    )
+
+
+def test_run_error_source_location():
+    if not _has_go():
+        return
+    source = (
+        "To average a total over a count, return the total divided by the count.\n"
+        "Let n be 0.\n"
+        "Print hello. Print the average of 10 over n.\n"
+    )
+    doc = _prog([
+        {"type": "FuncDef", "name": "average", "params": ["total", "count"], "body": [
+            {"type": "Return", "value": _bin("/", _var("total"), _var("count"))},
+        ]},
+        {"type": "Let", "name": "n", "value": _lit(0)},
+        {"type": "Print", "args": [_lit("hello")]},
+        {"type": "Print", "args": [_call("average", _lit(10), _var("n"))]},
+    ])
+    doc["source_map"] = {"1": [0], "2": [1], "3": [2, 3]}
+    code, _ = emit_go(doc, source_text=source)
+    assert 'SentenceID: 4, Line: 3, Column: 14},' in code, code
+    assert 'coreilEnter("average")\n\tdefer coreilLeave()\n\tcoreilLoc(0)' in code
+    result = _exec_go(doc, source_text=source)
+    assert result.returncode == 1, result.stderr
+    assert result.stdout == "hello\n", result.stdout
+    assert result.stderr == (
+        "runtime error: division by zero — while running "
+        "'To average a total over a count, return the total divided by the count.' (sentence 1, line 1:1)\n"
+        "  in average, called while running 'Print the average of 10 over n.' (sentence 4, line 3:14)\n"
+    ), result.stderr
+
+
+def test_run_watch_variable():
+    if not _has_go():
+        return
+    doc = _prog([
+        {"type": "FuncDef", "name": "add_score", "params": ["items", "v"], "body": [
+            {"type": "Push", "base": _var("items"), "value": _var("v")},
+        ]},
+        {"type": "Let", "name": "scores", "value": {"type": "Array", "items": []}},
+        _call("add_score", _var("scores"), _lit(3)),
+        {"type": "SetIndex", "base": _var("scores"), "index": _lit(0), "value": _lit(9)},
+        # Mutations through an alias are still reported against scores
+        {"type": "Let", "name": "alias", "value": _var("scores")},
+        {"type": "Push", "base": _var("alias"), "value": _lit(1)},
+        {"type": "Assign", "name": "scores", "value": {"type": "Array", "items": [_lit(7)]}},
+        {"type": "Print", "args": [_var("scores"), _var("alias")]},
+    ])
+    code, _ = emit_go(doc, watch=["scores"])
+    assert 'DefaultEngine.WatchVariable("scores", nil)' in code
+    assert 'coreilDebugVar("alias"' not in code
+    result = _exec_go(doc, watch=["scores"])
+    assert result.returncode == 0, result.stderr
+    assert result.stdout == "[7] [9, 1]\n", result.stdout
+    assert result.stderr.splitlines() == [
+        "watch: Assign scores: None -> [] at $.body[1]",
+        "watch: Push scores[0]: None -> 3 at $.body[0] in add_score",
+        "watch: SetIndex scores[0]: 3 -> 9 at $.body[3]",
+        "watch: Push scores[1]: None -> 1 at $.body[5]",
+        "watch: Assign scores: [9, 1] -> [7] at $.body[6]",
+    ], result.stderr
+
+
+def test_run_statement_coverage():
+    if not _has_go():
+        return
+    doc = _prog([
+        {"type": "FuncDef", "name": "classify", "params": ["n"], "body": [
+            {"type": "If", "test": _bin("<", _var("n"), _lit(0)), "then": [
+                {"type": "Return", "value": _lit("negative")},
+            ], "else": [
+                {"type": "Return", "value": _lit("positive")},
+            ]},
+        ]},
+        {"type": "FuncDef", "name": "test_positive", "params": [], "body": [
+            _call("classify", _lit(1)),
+        ]},
+    ])
+    doc["source_map"] = {"1": [0], "3": [1]}
+    source = "Classify a number n as negative or positive.\n\nTest that 1 is positive.\n"
+    code, _ = emit_go(doc, test_mode=True, coverage=True)
+    assert "DefaultEngine.EnableCoverage()" in code
+    assert "coreilCover(" in code
+    with tempfile.TemporaryDirectory() as tmpdir:
+        tmppath = Path(tmpdir)
+        binary = _build_go(doc, tmppath, test_mode=True, coverage=True)
+        counts_path = tmppath / "coverage.json"
+        result = subprocess.run(
+            [str(binary)],
+            capture_output=True,
+            text=True,
             timeout=30,
+            env={**os.environ, "COREIL_COVERAGE": str(counts_path)},
         )
-        # Build
-        build_result = subprocess.run(
-            ["go", "build", "-o", "test_prog", "."],
-            cwd=str(tmppath),
+        assert result.returncode == 0, result.stdout + result.stderr
+        counts = load_counts(counts_path)
+    assert counts["$.body[0].body[0]"] == 1, counts
+    assert counts["$.body[0].body[0].then[0]"] == 0, counts
+    assert counts["$.body[0].body[0].else[0]"] == 1, counts
+
+    report = build_report(doc, counts, source_text=source, test_mode=True)
+    assert report["summary"] == {"statements": 4, "covered": 3, "percent": 75.0}, report
+    first = report["sentences"][0]
+    assert (first["text"], first["statements"], first["covered"]) == (
+        "Classify a number n as negative or positive.", 3, 2,
+    ), first
+    summary = format_summary(report)
+    assert "Coverage: 3/4 statements (75.0%)" in summary, summary
+    assert "sentence 1 (line 1)" in summary, summary
+    page = render_html(report, "classify")
+    assert "Classify a number n as negative or positive." in page
+    assert '<tr class="partial">' in page
+
+
+def test_run_profile():
+    if not _has_go():
+        return
+    doc = _prog([
+        {"type": "FuncDef", "name": "build", "params": ["n"], "body": [
+            {"type": "Let", "name": "s", "value": _lit("")},
+            {"type": "For", "var": "i",
+             "iter": {"type": "Range", "from": _lit(0), "to": _var("n"), "inclusive": False},
+             "body": [
+                 {"type": "Assign", "name": "s", "value": _bin("+", _var("s"), _lit("x"))},
+             ]},
+            {"type": "Return", "value": _var("s")},
+        ]},
+        {"type": "Print", "args": [
+            {"type": "StringLength", "base": _call("build", _lit(30000))},
+        ]},
+    ])
+    code, _ = emit_go(doc, profile=True)
+    assert "coreilStartProfile()" in code
+    with tempfile.TemporaryDirectory() as tmpdir:
+        tmppath = Path(tmpdir)
+        binary = _build_go(doc, tmppath, profile=True)
+        result = subprocess.run(
+            [str(binary)],
             capture_output=True,
             text=True,
             timeout=60,
+            env={**os.environ, "COREIL_PROFILE": str(tmppath)},
         )
-        assert build_result.returncode == 0, f"Go build failed:\n{build_result.stderr}"
-        # Run
-        run_result = subprocess.run(
-            [str(tmppath / "test_prog")],
+        assert result.returncode == 0, result.stderr
+        assert result.stdout == "30000\n", result.stdout
+        report = build_profile_report(doc, tmppath / "cpu.pprof", tmppath / "allocs.pprof")
+    # Allocation sampling is fine-grained enough to be reliable; CPU samples
+    # of a short run may be few, but none can be outside build and main
+    allocs = report["allocs"]
+    assert allocs["functions"][0]["name"] == "build", allocs
+    assert allocs["categories"][0]["name"] == "string concat", allocs
+    assert {f["name"] for f in report["cpu"]["functions"]} <= {"build", "main", "(runtime)"}, report
+    summary = format_profile_summary(report)
+    assert "Allocations:" in summary, summary
+    assert "string concat" in summary, summary
+
+
+def test_run_heap_snapshot():
+    if not _has_go():
+        return
+    doc = _prog([
+        {"type": "FuncDef", "name": "fill", "params": ["rows", "n"], "body": [
+            {"type": "Let", "name": "row", "value": {"type": "Array", "items": []}},
+            {"type": "For", "var": "i",
+             "iter": {"type": "Range", "from": _lit(0), "to": _var("n"), "inclusive": False},
+             "body": [
+                 {"type": "Push", "base": _var("row"), "value": _lit("cell")},
+             ]},
+            {"type": "Push", "base": _var("rows"), "value": _var("row")},
+            {"type": "Throw", "message": _lit("out of memory")},
+        ]},
+        {"type": "Let", "name": "rows", "value": {"type": "Array", "items": []}},
+        {"type": "Let", "name": "index", "value": {"type": "Map", "items": [
+            {"key": _lit("rows"), "value": _var("rows")},
+        ]}},
+        _call("fill", _var("rows"), _lit(50)),
+    ])
+    code, _ = emit_go(doc, debug=True)
+    assert "coreilSnapshotOnError()" in code
+    with tempfile.TemporaryDirectory() as tmpdir:
+        tmppath = Path(tmpdir)
+        binary = _build_go(doc, tmppath, debug=True)
+        snapshot_path = tmppath / "snapshot.json"
+        result = subprocess.run(
+            [str(binary)],
             capture_output=True,
             text=True,
             timeout=30,
+            env={**os.environ, "COREIL_SNAPSHOT": str(snapshot_path)},
+        )
+        assert result.returncode == 1, result.stderr
+        assert "out of memory" in result.stderr, result.stderr
+        snapshot = json.loads(snapshot_path.read_text(encoding="utf-8"))
+    roots = [(r["function"], r["variable"]) for r in snapshot["roots"]]
+    assert roots == [("", "rows"), ("", "index"), ("fill", "rows"), ("fill", "n"), ("fill", "row"), ("fill", "i")], roots
+    # rows is shared by index and fill's parameter, so only the first root counts it
+    assert [r["values"] for r in snapshot["roots"][:3]] == [52, 1, 0], snapshot["roots"]
+    assert snapshot["types"]["str"]["count"] == 50, snapshot["types"]
+    assert snapshot["largest"][0] == {
+        "path": "rows[0]", "type": "array", "len": 50, "bytes": 51 * 32,
+    }, snapshot["largest"]
+    assert snapshot["values"] == 52 + 1 + 2, snapshot
+
+
+class _DAPClient:
+    """Minimal Debug Adapter Protocol client for test_run_debugger_dap."""
+
+    def __init__(self, address: str) -> None:
+        host, port = address.rsplit(":", 1)
+        self.sock = socket.create_connection((host, int(port)), timeout=30)
+        self.reader = self.sock.makefile("rb")
+        self.seq = 0
+
+    def read(self) -> dict:
+        length = 0
+        while True:
+            line = self.reader.readline().decode().strip()
+            if not line:
+                break
+            name, value = line.split(":", 1)
+            if name.lower() == "content-length":
+                length = int(value)
+        return json.loads(self.reader.read(length))
+
+    def request(self, command: str, **arguments) -> dict:
+        """Send a request and return its response body, skipping events."""
+        self.seq += 1
+        body = json.dumps({"seq": self.seq, "type": "request", "command": command, "arguments": arguments})
+        self.sock.sendall(f"Content-Length: {len(body)}\r\n\r\n{body}".encode())
+        while True:
+            msg = self.read()
+            if msg["type"] == "response" and msg["request_seq"] == self.seq:
+                assert msg["success"], msg
+                return msg.get("body", {})
+
+    def wait_event(self, event: str) -> dict:
+        while True:
+            msg = self.read()
+            if msg["type"] == "event" and msg["event"] == event:
+                return msg.get("body", {})
+
+    def top_frames(self) -> list[tuple[str, int]]:
+        frames = self.request("stackTrace", threadId=1)["stackFrames"]
+        return [(f["name"], f["line"]) for f in frames]
+
+    def variables(self, frame: int = 0) -> dict[str, str]:
+        ref = self.request("scopes", frameId=frame)["scopes"][0]["variablesReference"]
+        return {v["name"]: v["value"] for v in self.request("variables", variablesReference=ref)["variables"]}
+
+
+def test_run_debugger_dap():
+    if not _has_go():
+        return
+    source = (
+        "To total a list of numbers, start at 0, add each number, and return the sum.\n"
+        "Let scores be 3, 4 and 5.\n"
+        "Print the total of the scores.\n"
+    )
+    doc = _prog([
+        {"type": "FuncDef", "name": "total", "params": ["items"], "body": [
+            {"type": "Let", "name": "acc", "value": _lit(0)},
+            {"type": "ForEach", "var": "x", "iter": _var("items"), "body": [
+                {"type": "Assign", "name": "acc", "value": _bin("+", _var("acc"), _var("x"))},
+            ]},
+            {"type": "Return", "value": _var("acc")},
+        ]},
+        {"type": "Let", "name": "scores", "value": {"type": "Array", "items": [_lit(3), _lit(4), _lit(5)]}},
+        {"type": "Print", "args": [_call("total", _var("scores"))]},
+    ])
+    doc["source_map"] = {"1": [0], "2": [1], "3": [2]}
+    code, _ = emit_go(doc, source_text=source, debug=True)
+    assert '{IL: "$.body[0].body[1].body[0]", Sentence: ' in code, code
+    assert 'coreilDebug(1)\n\tscores := ' in code
+    assert 'coreilDebugVar("x", x)' in code
+    # Without a debugger attached, a debug build runs normally
+    assert _run_go(doc, debug=True) == "12\n"
+
+    with tempfile.TemporaryDirectory() as tmpdir:
+        binary = _build_go(doc, Path(tmpdir), source_text=source, debug=True)
+        proc = subprocess.Popen(
+            [str(binary)],
+            env={**os.environ, "COREIL_DAP": "127.0.0.1:0"},
+            stdout=subprocess.PIPE,
+            stderr=subprocess.PIPE,
+            text=True,
         )
-        assert run_result.returncode == 0, f"Go run failed:\n{run_result.stderr}"
-        return run_result.stdout
+        try:
+            address = proc.stderr.readline().split("listening on ")[1].strip()
+            client = _DAPClient(address)
+            assert client.request("initialize", adapterID="coreil")["supportsFunctionBreakpoints"]
+            client.request("launch", program="prog.txt")
+            lines = client.request("setBreakpoints", source={"path": "prog.txt"}, breakpoints=[{"line": 3}, {"line": 9}])
+            assert [b["verified"] for b in lines["breakpoints"]] == [True, False]
+            client.request("setFunctionBreakpoints", breakpoints=[{"name": "total"}])
+            client.request("configurationDone")
+
+            assert client.wait_event("stopped")["reason"] == "breakpoint"
+            assert client.top_frames() == [("main ($.body[2])", 3)]
+            assert client.variables() == {"scores": "[3, 4, 5]"}
+
+            client.request("continue", threadId=1)
+            assert client.wait_event("stopped")["reason"] == "breakpoint"
+            assert client.top_frames() == [("total ($.body[0].body[0])", 1), ("main ($.body[2])", 3)]
+            assert client.variables() == {"items": "[3, 4, 5]"}
+
+            client.request("next", threadId=1)
+            assert client.wait_event("stopped")["reason"] == "step"
+            assert client.top_frames()[0] == ("total ($.body[0].body[1])", 1)
+            assert client.request("evaluate", expression="acc", frameId=0)["result"] == "0"
+
+            client.request("stepIn", threadId=1)
+            client.wait_event("stopped")
+            assert client.top_frames()[0][0] == "total ($.body[0].body[1].body[0])"
+            assert client.variables() == {"items": "[3, 4, 5]", "acc": "0", "x": "3"}
+
+            client.request("stepOut", threadId=1)
+            assert client.wait_event("exited")["exitCode"] == 0
+            client.wait_event("terminated")
+            out, _ = proc.communicate(timeout=30)
+        finally:
+            proc.kill()
+            proc.wait()
+    assert out == "12\n", out
+
+
+def _call(name: str, *args: dict) -> dict:
+    return {"type": "Call", "name": name, "args": list(args)}
+
+
+def _func(name: str, params: list[str], *body: dict) -> dict:
+    return {"type": "FuncDef", "name": name, "params": params, "body": list(body)}
+
+
+def _ret(value: dict) -> dict:
+    return {"type": "Return", "value": value}
+
+
+def _sleep(seconds: dict, name: str = "slept") -> dict:
+    """Sleep for seconds; ExternalCall is an expression, so bind its result to name."""
+    return {"type": "Let", "name": name, "value": _ext("time", "sleep", seconds)}
+
+
+def _report_error(stmt: dict) -> dict:
+    """Run stmt, printing the kind and message of any error it raises."""
+    return {"type": "TryCatch",
+            "body": [stmt],
+            "catch_var": "err",
+            "catch_body": [{"type": "Print", "args": [_call("errorKind", _var("err")), _var("err")]}]}
+
+
+def _tail_recursive_prog() -> dict:
+    """sum_to recurses on itself and is_even/is_odd on each other, in tail position."""
+    def countdown(name: str, base: dict, step: dict) -> dict:
+        return {"type": "FuncDef", "name": name, "params": ["n", "acc"][:len(step["args"])], "body": [
+            {"type": "If", "test": _bin("==", _var("n"), _lit(0)), "then": [
+                {"type": "Return", "value": base},
+            ]},
+            {"type": "Return", "value": step},
+        ]}
+    return _prog([
+        countdown("sum_to", _var("acc"),
+                  _call("sum_to", _bin("-", _var("n"), _lit(1)), _bin("+", _var("acc"), _var("n")))),
+        countdown("is_even", _lit(True), _call("is_odd", _bin("-", _var("n"), _lit(1)))),
+        countdown("is_odd", _lit(False), _call("is_even", _bin("-", _var("n"), _lit(1)))),
+        {"type": "Print", "args": [_call("sum_to", _lit(100000), _lit(0))]},
+        {"type": "Print", "args": [_call("is_even", _lit(10001))]},
+    ])
+
+
+def test_codegen_tail_calls():
+    doc = _tail_recursive_prog()
+    code, _ = emit_go(doc)
+    assert "n, acc = valueSubtract(n, ValueInt(1)), valueAdd(acc, n)\n\t\tcontinue __tail" in code
+    assert "func __tail_is_even_is_odd(__fn int, __args []Value) Value {" in code
+    assert "return __tail_is_even_is_odd(1, []Value{n})" in code
+    plain, _ = emit_go(doc, tail_calls=False)
+    assert "__tail" not in plain
+    assert "return fn_sum_to(valueSubtract(n, ValueInt(1)), valueAdd(acc, n))" in plain
+
+
+def test_parity_tail_calls():
+    _check_parity(_tail_recursive_prog())
+
+
+def _ext(module: str, function: str, *args: dict) -> dict:
+    return {"type": "ExternalCall", "module": module, "function": function, "args": list(args)}
+
+
+def test_codegen_external_call():
+    doc = _prog([{"type": "Print", "args": [_ext("os", "getenv", _lit("HOME"))]}])
+    code, _ = emit_go(doc)
+    assert 'externalCall("os", "getenv", ValueStr("HOME"))' in code
+
+
+def test_run_external_call():
+    if not _has_go():
+        return
+    with tempfile.TemporaryDirectory() as tmpdir:
+        path = _lit(str(Path(tmpdir) / "note.txt"))
+        doc = _prog([
+            {"type": "Print", "args": [_ext("fs", "exists", path)]},
+            {"type": "Print", "args": [_ext("fs", "writeFile", path, _lit("hello"))]},
+            {"type": "Print", "args": [_ext("fs", "readFile", path), _ext("fs", "exists", path)]},
+            {"type": "Print", "args": [_ext("crypto", "hash", _lit("hello"))]},
+        ])
+        out = _run_go(doc, grants=["io.read", "io.write"])
+    assert out == (
+        "False\n"
+        "None\n"
+        "hello True\n"
+        "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824\n"
+    ), out
+
+
+_NO_SANDBOX_HOST = """package main
+
+func init() {
+\tDefaultEngine.DisableSandbox()
+}
+"""
+
+
+def test_run_sandbox_denies_by_default():
+    if not _has_go():
+        return
+    with tempfile.TemporaryDirectory() as tmpdir:
+        path = Path(tmpdir) / "note.txt"
+        path.write_text("hello", encoding="utf-8")
+        doc = _prog([
+            {"type": "TryCatch",
+             "body": [{"type": "Print", "args": [call]}],
+             "catch_var": "err",
+             "catch_body": [{"type": "Print", "args": [_var("err")]}]}
+            for call in (
+                _ext("fs", "readFile", _lit(str(path))),
+                _ext("fs", "writeFile", _lit(str(path)), _lit("bye")),
+                _ext("http", "get", _lit("http://127.0.0.1:9/")),
+            )
+        ])
+        assert "SetSandbox" not in emit_go(doc)[0]
+        assert 'DefaultEngine.SetSandbox(Capability("io.read"))' in emit_go(doc, grants=["io.read"])[0]
+        denied = _run_go(doc).splitlines()
+        granted = _run_go(doc, grants=["io.read"]).splitlines()
+        trusted = _run_go(doc, host_code=_NO_SANDBOX_HOST).splitlines()
+        assert path.read_text(encoding="utf-8") == "bye"
+    assert denied == [
+        "runtime error: sandbox denies io.read for fs.readFile",
+        "runtime error: sandbox denies io.write for fs.writeFile",
+        "runtime error: sandbox denies net for http.get",
+    ], denied
+    assert granted == ["hello"] + denied[1:], granted
+    assert trusted[:2] == ["hello", "None"], trusted
+    try:
+        emit_go(doc, grants=["disk"])
+    except ValueError as exc:
+        assert "unknown capability: 'disk'" in str(exc)
+    else:
+        raise AssertionError("expected an unknown capability error")
+
+
+def test_run_record_replay():
+    if not _has_go():
+        return
+    with tempfile.TemporaryDirectory() as tmpdir:
+        tmppath = Path(tmpdir)
+        note = tmppath / "note.txt"
+        note.write_text("from production", encoding="utf-8")
+        trace = tmppath / "trace.jsonl"
+
+        def program(path: Path) -> dict:
+            return _prog([
+                {"type": "Let", "name": "start", "value": _ext("time", "time")},
+                {"type": "Print", "args": [_ext("fs", "readFile", _lit(str(path)))]},
+                {"type": "Print", "args": [_ext("os", "getenv", _lit("COREIL_TEST_REGION"))]},
+                {"type": "Print", "args": [_ext("random", "randint", _lit(1), _lit(1000000))]},
+                {"type": "Print", "args": [_ext("os", "system", _lit("echo shell"))]},
+                {"type": "Print", "args": [_ext("time", "time"), _var("start")]},
+            ])
+
+        binary = _build_go(program(note), tmppath, grants=["env", "exec", "io.read"])
+
+        def run(**env: str) -> subprocess.CompletedProcess:
+            return subprocess.run(
+                [str(binary)], capture_output=True, text=True, timeout=30,
+                env={**os.environ, **env},
+            )
+
+        recorded = run(COREIL_RECORD=str(trace), COREIL_TEST_REGION="eu")
+        assert recorded.returncode == 0, recorded.stderr
+        lines = recorded.stdout.splitlines()
+        assert lines[:2] == ["from production", "eu"], lines
+        assert lines[3:5] == ["shell", "0"], lines
+
+        # The developer machine has neither the file nor the environment
+        note.unlink()
+        replayed = run(COREIL_REPLAY=str(trace))
+        assert replayed.returncode == 0, replayed.stderr
+        assert replayed.stdout == recorded.stdout, (replayed.stdout, recorded.stdout)
+        assert replayed.stderr == "", replayed.stderr
+
+        # A program that reads a different file no longer matches the trace
+        binary = _build_go(program(tmppath / "other.txt"), tmppath, grants=["env", "exec", "io.read"])
+        diverged = run(COREIL_REPLAY=str(trace))
+    assert diverged.returncode == 1, diverged.stderr
+    assert "replay diverged at call 2: the program called fs.readFile(" in diverged.stderr, diverged.stderr
+    assert "other.txt') but the trace has fs.readFile(" in diverged.stderr, diverged.stderr
+    assert "replay: 4 of 5 recorded calls were not made" in diverged.stderr, diverged.stderr
+
+
+def test_run_checkpoint_restore():
+    if not _has_go():
+        return
+    doc = _prog([
+        {"type": "Let", "name": "seen", "value": {"type": "Array", "items": []}},
+        {"type": "FuncDef", "name": "add", "params": ["n"], "body": [
+            {"type": "Push", "base": _var("seen"), "value": _var("n")},
+            {"type": "Return", "value": {"type": "Length", "base": _var("seen")}},
+        ]},
+        {"type": "Let", "name": "start", "value": _ext("time", "time")},
+        {"type": "For", "var": "i", "iter": {"type": "Range", "from": _lit(0), "to": _lit(10)}, "body": [
+            {"type": "Print", "args": [
+                _var("i"),
+                {"type": "Call", "name": "add", "args": [_var("i")]},
+                _ext("random", "randint", _lit(1), _lit(1000000)),
+                _bin("==", _ext("time", "time"), _var("start")),
+            ]},
+        ]},
+    ])
+    with tempfile.TemporaryDirectory() as tmpdir:
+        tmppath = Path(tmpdir)
+        binary = _build_go(doc, tmppath)
+        checkpoint = tmppath / "job.checkpoint"
+
+        def run(**env: str) -> subprocess.CompletedProcess:
+            return subprocess.run(
+                [str(binary)], capture_output=True, text=True, timeout=30,
+                env={**os.environ, "COREIL_CHECKPOINT": str(checkpoint), **env},
+            )
+
+        # Stopped partway, e.g. by a restart, after checkpointing at step 4
+        stopped = run(COREIL_CHECKPOINT_STEPS="4", COREIL_MAX_STEPS="7")
+        assert stopped.returncode == 3, stopped.stderr
+        state = json.loads(checkpoint.read_text(encoding="utf-8"))
+        assert state["steps"] == 4 and state["globals"] == {"seen": "[0]"}, state
+        assert [call["call"] for call in state["calls"]] == ["time.time", "time.time"], state
+        first = stopped.stdout.splitlines()
+        assert len(first) == 3 and first[0].startswith("0 1 "), first
+
+        # The next run continues from the checkpoint, with the same random draws
+        resumed = run()
+        assert resumed.returncode == 0, resumed.stderr
+        rest = resumed.stdout.splitlines()
+        assert rest[:2] == first[1:], (first, rest)
+        assert [line.split()[:2] for line in rest] == [[str(i), str(i + 1)] for i in range(1, 10)], rest
+        assert all(line.endswith(" False") for line in rest), rest
+        assert not checkpoint.exists()
+
+        # A checkpoint the program does not arrive at is refused
+        run(COREIL_CHECKPOINT_STEPS="4", COREIL_MAX_STEPS="7")
+        checkpoint.write_text(checkpoint.read_text(encoding="utf-8").replace('"[0]"', '"[5]"'), encoding="utf-8")
+        diverged = run()
+        assert diverged.returncode == 1
+        assert "runtime error: restore diverged at step 4: seen is [0], not [5]" in diverged.stderr, diverged.stderr
+        assert diverged.stdout == "" and checkpoint.exists()
+
+
+_CHECKPOINT_HOST = """package main
+
+import (
+\t"fmt"
+\t"os"
+)
+
+func init() {
+\tif _, err := NewEngine().Checkpoint(); err != nil {
+\t\tfmt.Fprintln(os.Stderr, "disabled:", err)
+\t}
+\tDefaultEngine.SetCheckpointing(&CheckpointConfig{})
+\tgen := generator(ValueFunc("count", func(args []Value) Value {
+\t\tfor i := int64(0); ; i++ {
+\t\t\tcallValue(args[0], ValueInt(i))
+\t\t}
+\t}))
+\tgeneratorNext(gen)
+\tgeneratorNext(gen)
+\tgo func() {
+\t\tdata, err := DefaultEngine.Checkpoint()
+\t\tfmt.Fprintf(os.Stderr, "checkpoint: %s %v\\n", data, err)
+\t\tos.Exit(0)
+\t}()
+}
+"""
+
+
+def test_checkpoint_api():
+    if not _has_go():
+        return
+    # Runs until the host has its checkpoint
+    doc = _prog([
+        {"type": "FuncDef", "name": "work", "params": [], "body": [
+            {"type": "Return", "value": _ext("time", "time")},
+        ]},
+        {"type": "While", "test": _lit(True), "body": [
+            {"type": "Let", "name": "now", "value": {"type": "Call", "name": "work", "args": []}},
+        ]},
+    ])
+    result = _exec_go(doc, host_code=_CHECKPOINT_HOST)
+    assert "disabled: checkpoints are not enabled; see SetCheckpointing" in result.stderr, result.stderr
+    line = next(line for line in result.stderr.splitlines() if line.startswith("checkpoint: "))
+    data, _, err = line.removeprefix("checkpoint: ").rpartition(" ")
+    assert err == "<nil>", line
+    state = json.loads(data)
+    assert state["iterators"] == [{"function": "count", "yielded": 2}], state
+    assert state["stack"] in ([], ["work"]) and state["globals"] == {}, state
+    assert {call["call"] for call in state["calls"]} <= {"time.time"}, state
+
+
+def test_codegen_audit_location():
+    doc = _prog([
+        {"type": "Print", "args": [_lit("start")]},
+        {"type": "If", "test": _lit(True), "then": [
+            {"type": "Print", "args": [_ext("os", "getenv", _lit("HOME"))]},
+        ]},
+    ])
+    code, _ = emit_go(doc)
+    assert '{IL: "$.body[1]"},' in code, code
+    assert "coreilLoc(1)\n\tif true {" in code
+
+
+_AUDIT_HOST = """package main
+
+import (
+\t"fmt"
+\t"strings"
+)
+
+func init() {
+\tDefaultEngine.SetAuditSink(func(ev AuditEvent) {
+\t\tfmt.Fprintf(DefaultEngine.out, "audit %s %s [%s] at %s\\n",
+\t\t\tev.Op, ev.Capability, strings.Join(ev.Args, ", "), ev.Loc)
+\t})
+}
+"""
+
+
+def test_run_audit_sink():
+    if not _has_go():
+        return
+    with tempfile.TemporaryDirectory() as tmpdir:
+        path = str(Path(tmpdir) / "note.txt")
+        doc = _prog([
+            {"type": "Print", "args": [_ext("crypto", "hash", _lit("x"))]},
+            {"type": "Print", "args": [_ext("fs", "writeFile", _lit(path), _lit("hi"))]},
+            # Audited calls in nested statements name those statements,
+            # without a debug or coverage build
+            {"type": "FuncDef", "name": "save", "params": ["text"], "body": [
+                {"type": "If", "test": _lit(True), "then": [
+                    {"type": "Let", "name": "r", "value": _ext("fs", "writeFile", _lit(path), _var("text"))},
+                ]},
+                {"type": "Return", "value": _ext("fs", "readFile", _lit(path))},
+            ]},
+            {"type": "Print", "args": [_call("save", _lit("bye"))]},
+        ])
+        out = _run_go(doc, host_code=_AUDIT_HOST, grants=["io.read", "io.write"])
+    lines = out.splitlines()
+    assert len(lines) == 6, out
+    assert lines[1] == f"audit fs.writeFile io.write ['{path}', 'hi'] at $.body[1]", out
+    assert lines[2] == "None", out
+    assert lines[3] == f"audit fs.writeFile io.write ['{path}', 'bye'] at $.body[2].body[0].then[0]", out
+    assert lines[4] == f"audit fs.readFile io.read ['{path}'] at $.body[2].body[1]", out
+    assert lines[5] == "bye", out
+
+
+_TRACE_HOST = """package main
+
+import (
+\t"context"
+\t"fmt"
+\t"os"
+\t"time"
+)
+
+type testTracer struct{}
+
+type testSpan struct {
+\tname  string
+\tattrs []SpanAttribute
+\terr   error
+}
+
+func (testTracer) Start(ctx context.Context, name string, start time.Time, attrs []SpanAttribute) (context.Context, Span) {
+\treturn ctx, &testSpan{name: name, attrs: attrs}
+}
+
+func (s *testSpan) RecordError(err error) { s.err = err }
+
+func (s *testSpan) End(end time.Time) {
+\tfmt.Fprintf(os.Stderr, "span %s", s.name)
+\tfor _, a := range s.attrs {
+\t\tfmt.Fprintf(os.Stderr, " %s=%v", a.Key, a.Value)
+\t}
+\tif s.err != nil {
+\t\tfmt.Fprintf(os.Stderr, " error=%q", s.err)
+\t}
+\tfmt.Fprintln(os.Stderr)
+}
+
+func init() {
+\tDefaultEngine.SetTracing(&TraceConfig{Tracer: testTracer{}, MinCallDuration: 50 * time.Millisecond, Entrypoint: "report.txt"})
+}
+"""
+
+
+def test_run_tracing():
+    if not _has_go():
+        return
+    doc = _prog([
+        {"type": "FuncDef", "name": "fast", "params": [], "body": [
+            {"type": "Return", "value": _lit(1)},
+        ]},
+        {"type": "FuncDef", "name": "slow", "params": [], "body": [
+            {"type": "Print", "args": [_ext("time", "sleep", _lit(0.1))]},
+            {"type": "Return", "value": _ext("fs", "readFile", _lit("/nonexistent/coreil"))},
+        ]},
+        {"type": "Print", "args": [_call("fast")]},
+        {"type": "Print", "args": [_call("slow")]},
+    ])
+    result = _exec_go(doc, host_code=_TRACE_HOST, grants=["io.read"])
+    assert result.returncode == 1
+    assert result.stdout == "1\nNone\n", result.stdout
+    spans = [line for line in result.stderr.splitlines() if line.startswith("span ")]
+    assert spans == [
+        'span coreil.external fs.readFile coreil.builtin=fs.readFile coreil.capability=io.read '
+        'coreil.location=$.body[1].body[1] error="runtime error: open /nonexistent/coreil: no such file or directory"',
+        'span coreil.call slow coreil.function=slow coreil.call.depth=1 coreil.location=$.body[3] '
+        'error="runtime error: open /nonexistent/coreil: no such file or directory"',
+        f"span coreil.run coreil.program.hash={program_hash(doc)} coreil.entrypoint=report.txt "
+        'error="runtime error: open /nonexistent/coreil: no such file or directory"',
+    ], result.stderr
+
+
+_METRICS_HOST = """package main
+
+import (
+\t"fmt"
+\t"os"
+\t"sort"
+)
+
+type testSink struct{}
+
+func formatLabels(labels map[string]string) string {
+\tkeys := make([]string, 0, len(labels))
+\tfor k := range labels {
+\t\tkeys = append(keys, k)
+\t}
+\tsort.Strings(keys)
+\tout := ""
+\tfor _, k := range keys {
+\t\tout += " " + k + "=" + labels[k]
+\t}
+\treturn out
+}
+
+func (testSink) AddCounter(name string, delta int64, labels map[string]string) {
+\tfmt.Fprintf(os.Stderr, "counter %s %d%s\\n", name, delta, formatLabels(labels))
+}
+
+func (testSink) RecordHistogram(name string, value float64, labels map[string]string) {
+\tif name == "coreil.run.seconds" {
+\t\tvalue = 0
+\t}
+\tfmt.Fprintf(os.Stderr, "histogram %s %g%s\\n", name, value, formatLabels(labels))
+}
+
+func init() {
+\tDefaultEngine.SetMetrics(testSink{}, map[string]string{"tenant": "acme"})
+}
+"""
+
+
+def test_run_metrics():
+    if not _has_go():
+        return
+    doc = _prog([
+        {"type": "For", "var": "i", "iter": {"type": "Range", "from": _lit(0), "to": _lit(3)}, "body": [
+            {"type": "Print", "args": [_ext("crypto", "hash", _lit("x"))]},
+        ]},
+        {"type": "TryCatch",
+         "body": [{"type": "Print", "args": [_ext("fs", "readFile", _lit("/nonexistent/coreil"))]}],
+         "catch_var": "err",
+         "catch_body": [{"type": "Print", "args": [_lit("caught")]}]},
+    ])
+    result = _exec_go(doc, host_code=_METRICS_HOST, grants=["io.read"])
+    assert result.returncode == 0, result.stderr
+    assert result.stderr.splitlines() == [
+        "counter coreil.builtin.calls 1 builtin=crypto.hash tenant=acme",
+        "counter coreil.builtin.calls 1 builtin=crypto.hash tenant=acme",
+        "counter coreil.builtin.calls 1 builtin=crypto.hash tenant=acme",
+        "counter coreil.builtin.calls 1 builtin=fs.readFile tenant=acme",
+        "counter coreil.errors 1 caught=true kind=IOError tenant=acme",
+        "counter coreil.steps 3 tenant=acme",
+        "histogram coreil.run.seconds 0 tenant=acme",
+        f"histogram coreil.output.bytes {65 * 3 + 7} tenant=acme",
+    ], result.stderr
+
+
+def test_codegen_isolated():
+    doc = _prog([{"type": "Print", "args": [_lit("hi")]}])
+    code, _ = emit_go(doc, isolated=True)
+    assert "coreilRunIsolated(func() {" in code
+    assert "coreilRunIsolated" not in emit_go(doc)[0]
+
+
+def test_run_isolated():
+    if not _has_go():
+        return
+    with tempfile.TemporaryDirectory() as tmpdir:
+        path = str(Path(tmpdir) / "note.txt")
+        doc = _prog([
+            {"type": "Print", "args": [_lit("child")]},
+            {"type": "Print", "args": [_ext("fs", "writeFile", _lit(path), _lit("hi"))]},
+            {"type": "Print", "args": [_ext("fs", "readFile", _lit(path))]},
+        ])
+        out = _run_go(doc, host_code=_AUDIT_HOST, isolated=True, grants=["io.read", "io.write"])
+    # The child's calls are audited by the parent, whose buffered output
+    # is flushed after the child has exited.
+    assert out.splitlines() == [
+        "child",
+        "None",
+        "hi",
+        f"audit fs.writeFile io.write ['{path}', 'hi'] at $.body[1]",
+        f"audit fs.readFile io.read ['{path}'] at $.body[2]",
+    ], out
+
+
+_CHILD_CANCEL_HOST = """package main
+
+import (
+\t"context"
+\t"os"
+\t"time"
+)
+
+// Only the isolated child's context is cancelled; the parent's stays live.
+func init() {
+\tif os.Getenv("COREIL_ISOLATED_LIMITS") == "" {
+\t\treturn
+\t}
+\tctx, cancel := context.WithCancel(context.Background())
+\tDefaultEngine.SetContext(ctx)
+\ttime.AfterFunc(200*time.Millisecond, cancel)
+}
+"""
+
+
+def test_run_isolated_cancelled():
+    if not _has_go():
+        return
+    doc = _prog([
+        {"type": "Print", "args": [_lit("before")]},
+        {"type": "While", "test": _lit(True), "body": []},
+        {"type": "Print", "args": [_lit("after")]},
+    ])
+    start = time.monotonic()
+    result = _exec_go(doc, host_code=_CHILD_CANCEL_HOST, isolated=True)
+    assert time.monotonic() - start < 15, "loop was not interrupted"
+    # The child reports its cancellation to the parent instead of crashing
+    assert result.returncode == 1, result.stderr
+    assert result.stdout == "before\n", result.stdout
+    assert result.stderr == (
+        "cancelled (context canceled) after 7 bytes (1 lines) of output, ending 'before\\n' at $.body[1]\n"
+    ), result.stderr
+
+
+_CANCEL_HOST = """package main
+
+import (
+\t"context"
+\t"time"
+)
+
+func init() {
+\tctx, cancel := context.WithCancel(context.Background())
+\tDefaultEngine.SetContext(ctx)
+\ttime.AfterFunc(200*time.Millisecond, cancel)
+}
+"""
+
+
+def test_run_cancelled_sleep():
+    if not _has_go():
+        return
+    doc = _prog([
+        {"type": "Print", "args": [_lit("before")]},
+        {"type": "TryCatch",
+         "body": [{"type": "Print", "args": [_ext("time", "sleep", _lit(20))]}],
+         "catch_var": "err",
+         "catch_body": [{"type": "Print", "args": [_lit("caught")]}]},
+        {"type": "Print", "args": [_lit("after")]},
+    ])
+    start = time.monotonic()
+    result = _exec_go(doc, host_code=_CANCEL_HOST)
+    assert time.monotonic() - start < 15, "sleep was not interrupted"
+    assert result.returncode != 0
+    assert result.stdout == "before\n", result.stdout
+    assert "cancelled (context canceled) after 7 bytes (1 lines) of output, ending 'before\\n'" in result.stderr, result.stderr
+
+
+def test_run_error_kinds():
+    if not _has_go():
+        return
+
+    def classify(expr: dict) -> dict:
+        return {"type": "TryCatch",
+                "body": [{"type": "Print", "args": [expr]}],
+                "catch_var": "err",
+                "catch_body": [{"type": "Print", "args": [
+                    _call("errorKind", _var("err")), _call("errorCode", _var("err")), _var("err"),
+                ]}]}
+
+    doc = _prog([
+        {"type": "Let", "name": "xs", "value": {"type": "Array", "items": [_lit(1)]}},
+        {"type": "Let", "name": "m", "value": {"type": "Map", "items": []}},
+        classify({"type": "Index", "base": _var("xs"), "index": _lit(5)}),
+        classify({"type": "Get", "base": _var("m"), "key": _lit("a")}),
+        classify(_bin("/", _lit(1), _lit(0))),
+        # Parsed, so the type checker cannot reject the program before it runs
+        classify(_bin("+", {"type": "JsonParse", "source": _lit("1")}, _lit("a"))),
+        {"type": "TryCatch",
+         "body": [{"type": "Throw", "message": _lit("custom")}],
+         "catch_var": "err",
+         "catch_body": [{"type": "Print", "args": [_call("errorKind", _var("err")), _call("errorCode", _var("err"))]}]},
+        # The kind travels with the caught message: the same text thrown keeps
+        # its own kind, and catching many later errors loses neither
+        {"type": "Let", "name": "first", "value": _lit(None)},
+        {"type": "Let", "name": "second", "value": _lit(None)},
+        {"type": "TryCatch", "body": [{"type": "Print", "args": [_bin("/", _lit(1), _lit(0))]}],
+         "catch_var": "err", "catch_body": [{"type": "Assign", "name": "first", "value": _var("err")}]},
+        {"type": "TryCatch", "body": [{"type": "Throw", "message": _lit("runtime error: division by zero")}],
+         "catch_var": "err", "catch_body": [{"type": "Assign", "name": "second", "value": _var("err")}]},
+        {"type": "For", "var": "i", "iter": {"type": "Range", "from": _lit(0), "to": _lit(100), "inclusive": False},
+         "body": [{"type": "TryCatch", "body": [{"type": "Print", "args": [_call("valueNegate", _lit("x"))]}],
+                   "catch_var": "err", "catch_body": []}]},
+        {"type": "Print", "args": [_call("errorKind", _var("first")), _call("errorKind", _var("second")),
+                                   _bin("==", _var("first"), _var("second"))]},
+        {"type": "Print", "args": [_call("errorKind", {"type": "Index", "base": {"type": "Array", "items": [
+            _var("first")]}, "index": _lit(0)}), _call("errorKind", _lit("runtime error: division by zero"))]},
+    ])
+    out = _run_go(doc)
+    assert out.splitlines() == [
+        "IndexError E102 runtime error: index 5 out of range for array of length 1",
+        "KeyError E103 runtime error: key 'a' not found",
+        "ZeroDivisionError E110 runtime error: division by zero",
+        "TypeError E100 runtime error: cannot add int and str",
+        "Error E000",
+        "ZeroDivisionError Error True",
+        "ZeroDivisionError Error",
+    ], out
+
+
+def test_run_spawn_channels():
+    if not _has_go():
+        return
+    n = _var("n")
+    doc = _prog([
+        # Three tasks sleep at the same time and send their squares back
+        _func("worker", ["results", "n"],
+              _sleep(_lit(0.3)),
+              _call("channelSend", _var("results"), _bin("*", n, n))),
+        _func("failing", [], _ret({"type": "Index", "base": {"type": "Array", "items": []}, "index": _lit(0)})),
+        _func("answer", [], _ret(_lit(42))),
+        {"type": "Let", "name": "start", "value": _ext("time", "time")},
+        {"type": "Let", "name": "results", "value": _call("channelNew", _lit(0))},
+        {"type": "For", "var": "i", "iter": {"type": "Range", "from": _lit(1), "to": _lit(4), "inclusive": False},
+         "body": [_call("spawn", _var("worker"), _var("results"), _var("i"))]},
+        {"type": "Let", "name": "total", "value": _lit(0)},
+        {"type": "For", "var": "i", "iter": {"type": "Range", "from": _lit(0), "to": _lit(3), "inclusive": False},
+         "body": [{"type": "Assign", "name": "total",
+                   "value": _bin("+", _var("total"), _call("channelReceive", _var("results")))}]},
+        {"type": "Print", "args": [_var("total"), _bin("<", _bin("-", _ext("time", "time"), _var("start")), _lit(0.9))]},
+        {"type": "Let", "name": "task", "value": _call("spawn", _var("answer"))},
+        {"type": "Print", "args": [_call("channelReceive", _var("task")), _call("channelReceive", _var("task"))]},
+        {"type": "Let", "name": "failed", "value": _call("spawn", _var("failing"))},
+        _report_error({"type": "Print", "args": [_call("channelReceive", _var("failed"))]}),
+    ])
+    out = _run_go(doc)
+    assert out.splitlines() == [
+        "14 True",
+        "42 None",
+        "IndexError runtime error: index 0 out of range for array of length 0",
+    ], out
+
+
+def test_run_select():
+    if not _has_go():
+        return
+    cases = {"type": "Tuple", "items": [_var("fast"), _var("slow")]}
+    doc = _prog([
+        _func("produce", ["ch", "delay"],
+              {"type": "For", "var": "i", "iter": {"type": "Range", "from": _lit(0), "to": _lit(2), "inclusive": False},
+               "body": [_sleep(_var("delay")), _call("channelSend", _var("ch"), _var("i"))]}),
+        # Collect from two producers as their values arrive, send on a
+        # buffered channel, and then time out once both producers are done
+        {"type": "Let", "name": "fast", "value": _call("channelNew", _lit(0))},
+        {"type": "Let", "name": "slow", "value": _call("channelNew", _lit(0))},
+        _call("spawn", _var("produce"), _var("fast"), _lit(0.05)),
+        _call("spawn", _var("produce"), _var("slow"), _lit(0.3)),
+        {"type": "Let", "name": "got", "value": {"type": "Array", "items": []}},
+        {"type": "For", "var": "i", "iter": {"type": "Range", "from": _lit(0), "to": _lit(4), "inclusive": False},
+         "body": [{"type": "Push", "base": _var("got"), "value": _call("selectValue", cases, _lit(None))}]},
+        {"type": "Let", "name": "out", "value": _call("channelNew", _lit(1))},
+        {"type": "Push", "base": _var("got"), "value": _call("selectValue", {"type": "Tuple", "items": [
+            {"type": "Tuple", "items": [_var("out"), _lit("x")]},
+        ]}, _lit(None))},
+        {"type": "Push", "base": _var("got"), "value": _call("selectValue", cases, _lit(0.1))},
+        {"type": "Push", "base": _var("got"), "value": _call("channelReceive", _var("out"))},
+        {"type": "Print", "args": [_var("got")]},
+    ])
+    out = _run_go(doc)
+    assert out == "[(0, 0), (0, 1), (1, 0), (1, 1), (0, None), (-1, None), 'x']\n", out
+
+
+def test_run_futures():
+    if not _has_go():
+        return
+    futures = _var("futures")
+    doc = _prog([
+        _func("delayed", ["seconds", "result"], _sleep(_var("seconds")), _ret(_var("result"))),
+        _func("boom", [], _ret({"type": "Get", "base": {"type": "Map", "items": []}, "key": _lit("missing")})),
+        # Three things run in parallel and their results are combined
+        {"type": "Let", "name": "start", "value": _ext("time", "time")},
+        {"type": "Let", "name": "futures", "value": {"type": "Array", "items": [
+            _call("taskStart", _var("delayed"), _lit(0.3), _lit(1)),
+            _call("taskStart", _var("delayed"), _lit(0.1), _lit(2)),
+            _call("taskStart", _var("delayed"), _lit(0.2), _lit(3)),
+        ]}},
+        {"type": "Let", "name": "first", "value": _call("awaitAny", futures)},
+        {"type": "Let", "name": "all", "value": _call("awaitAll", futures)},
+        {"type": "Print", "args": [{"type": "Tuple", "items": [
+            _var("first"), _var("all"), _call("await", {"type": "Index", "base": futures, "index": _lit(0)}),
+        ]}]},
+        {"type": "Print", "args": [_bin("<", _bin("-", _ext("time", "time"), _var("start")), _lit(0.6))]},
+        _report_error({"type": "Print", "args": [_call("awaitAll", {"type": "Array", "items": [
+            _call("taskStart", _var("delayed"), _lit(5), _lit(None)), _call("taskStart", _var("boom")),
+        ]})]}),
+    ])
+    out = _run_go(doc)
+    assert out.splitlines() == [
+        "((1, 2), [1, 2, 3], 1)",
+        "True",
+        "KeyError runtime error: key 'missing' not found",
+    ], out
+
+
+def test_run_pool():
+    if not _has_go():
+        return
+
+    def get(key):
+        return {"type": "Get", "base": _var("stats"), "key": _lit(key)}
+
+    def put(key, value):
+        return {"type": "Set", "base": _var("stats"), "key": _lit(key), "value": value}
+
+    doc = _prog([
+        # Each job records how many jobs run at once; job 3 overruns its timeout
+        _func("job", ["stats", "n"],
+              put("running", _bin("+", get("running"), _lit(1))),
+              {"type": "If", "test": _bin(">", get("running"), get("peak")), "then": [put("peak", get("running"))]},
+              _sleep(_lit(0.05)),
+              put("running", _bin("-", get("running"), _lit(1))),
+              {"type": "If", "test": _bin("==", _var("n"), _lit(3)), "then": [_sleep(_lit(5))]},
+              _ret(_bin("*", _var("n"), _lit(10)))),
+        {"type": "Let", "name": "stats", "value": {"type": "Map", "items": [
+            {"key": _lit("running"), "value": _lit(0)}, {"key": _lit("peak"), "value": _lit(0)},
+        ]}},
+        # Five jobs on two workers
+        {"type": "Let", "name": "pool", "value": _call("poolNew", _lit(2), _lit(1), _lit(0.5))},
+        {"type": "For", "var": "n", "iter": {"type": "Range", "from": _lit(1), "to": _lit(5), "inclusive": True},
+         "body": [_call("poolSubmit", _var("pool"), _var("job"), _var("stats"), _var("n"))]},
+        {"type": "Let", "name": "results", "value": _call("poolResults", _var("pool"))},
+        {"type": "Print", "args": [{"type": "Tuple", "items": [get("peak"), _var("results")]}]},
+    ])
+    out = _run_go(doc)
+    assert out.strip() == (
+        "(2, [Ok(10), Ok(20), Err('runtime error: task timed out'), Ok(40), Ok(50)])"
+    ), out
+
+def test_run_locks():
+    if not _has_go():
+        return
+    total_n = {"type": "Get", "base": _var("total"), "key": _lit("n")}
+    doc = _prog([
+        # update reads a shared total, waits, then writes it back: a lost
+        # update unless the read and write happen under the lock
+        _func("update", ["total", "hits"],
+              {"type": "Let", "name": "n", "value": total_n},
+              _sleep(_lit(0.01)),
+              {"type": "Set", "base": _var("total"), "key": _lit("n"), "value": _bin("+", _var("n"), _lit(1))},
+              _call("counterAdd", _var("hits"), _lit(1))),
+        _func("bump", ["total", "lock", "hits"],
+              _ret(_call("withLock", _var("lock"), _var("update"), _var("total"), _var("hits")))),
+        {"type": "Let", "name": "total", "value": {"type": "Map", "items": [{"key": _lit("n"), "value": _lit(0)}]}},
+        {"type": "Let", "name": "lock", "value": _call("lockNew")},
+        {"type": "Let", "name": "hits", "value": _call("counterNew", _lit(100))},
+        {"type": "Let", "name": "futures", "value": {"type": "Array", "items": []}},
+        {"type": "For", "var": "i", "iter": {"type": "Range", "from": _lit(0), "to": _lit(5), "inclusive": False},
+         "body": [{"type": "Push", "base": _var("futures"), "value": _call(
+             "taskStart", _var("bump"), _var("total"), _var("lock"), _var("hits"))}]},
+        _call("awaitAll", _var("futures")),
+        {"type": "Print", "args": [{"type": "Tuple", "items": [total_n, _call("counterGet", _var("hits"))]}]},
+        _report_error({"type": "Print", "args": [_call("lockRelease", _call("lockNew"))]}),
+    ])
+    out = _run_go(doc)
+    assert out.splitlines() == [
+        "(5, 105)",
+        "RuntimeError runtime error: release of unlocked lock",
+    ], out
+
+
+def test_run_actors():
+    if not _has_go():
+        return
+    msg, balance = _var("msg"), {"type": "Get", "base": _var("state"), "key": _lit("balance")}
+    doc = _prog([
+        # An account actor: an int message deposits it, "balance" reports
+        # the balance, "slow" takes a while and "bad" fails
+        _func("account", ["state", "msg"],
+              {"type": "If", "test": _bin("==", msg, _lit("bad")), "then": [
+                  _ret({"type": "Get", "base": {"type": "Map", "items": []}, "key": _lit("bad")}),
+              ]},
+              {"type": "If", "test": _bin("==", msg, _lit("slow")), "then": [_sleep(_lit(0.3)), _ret(balance)]},
+              {"type": "If", "test": _bin("==", msg, _lit("balance")), "then": [_ret(balance)]},
+              {"type": "Let", "name": "current", "value": balance},
+              _sleep(_lit(0.001)),
+              {"type": "Set", "base": _var("state"), "key": _lit("balance"), "value": _bin("+", _var("current"), msg)}),
+        _func("depositor", ["acct"],
+              {"type": "For", "var": "i", "iter": {"type": "Range", "from": _lit(0), "to": _lit(10), "inclusive": False},
+               "body": [_call("actorSend", _var("acct"), _lit(1))]}),
+        {"type": "Let", "name": "acct", "value": _call("spawnActor", _call(
+            "partial", _var("account"), {"type": "Map", "items": [{"key": _lit("balance"), "value": _lit(0)}]}))},
+        _call("awaitAll", {"type": "Array", "items": [_call("taskStart", _var("depositor"), _var("acct"))] * 3}),
+        {"type": "Print", "args": [_call("actorAsk", _var("acct"), _lit("balance"), _lit(0))]},
+        _report_error({"type": "Print", "args": [_call("actorAsk", _var("acct"), _lit("bad"), _lit(0))]}),
+        _report_error({"type": "Print", "args": [_call("actorAsk", _var("acct"), _lit("slow"), _lit(0.1))]}),
+        {"type": "Print", "args": [_call("actorSend", _var("acct"), _lit("bad"))]},
+        _report_error({"type": "Print", "args": [_call("actorAsk", _var("acct"), _lit("balance"), _lit(5))]}),
+    ])
+    out = _run_go(doc)
+    assert out.splitlines() == [
+        "30",
+        "KeyError runtime error: key 'bad' not found",
+        "TimeoutError runtime error: ask timed out after 0.1s",
+        "None",
+        "KeyError runtime error: key 'bad' not found",
+    ], out
+
+
+_EVENTS_HOST = """package main
+
+import "time"
+
+// hostEvents publishes into the running program from another goroutine.
+func hostEvents() Value {
+\tgo func() {
+\t\tfor _, p := range []string{"1", "2", "3"} {
+\t\t\ttime.Sleep(20 * time.Millisecond)
+\t\t\tDefaultEngine.Publish("tick", ValueStr(p))
+\t\t}
+\t}()
+\treturn ValueNone
+}
+"""
+
+
+def test_run_event_bus():
+    if not _has_go():
+        return
+
+    def recorder(tag):
+        return _call("partial", _var("record"), _var("seen"), _lit(tag))
+
+    saved = _lit("saved")
+    doc = _prog([
+        _func("record", ["seen", "tag", "payload"],
+              {"type": "Push", "base": _var("seen"), "value": _bin("+", _bin("+", _var("tag"), _lit(":")), _var("payload"))}),
+        # Emit on a program-owned bus, before and after removing a handler
+        {"type": "Let", "name": "seen", "value": {"type": "Array", "items": []}},
+        {"type": "Let", "name": "bus", "value": _call("eventBusNew")},
+        {"type": "Let", "name": "a", "value": recorder("a")},
+        _call("eventOn", _var("bus"), saved, _var("a")),
+        _call("eventOn", _var("bus"), saved, recorder("b")),
+        _call("eventEmit", _var("bus"), saved, _lit("x")),
+        _call("eventOff", _var("bus"), saved, _var("a")),
+        {"type": "Print", "args": [{"type": "Tuple", "items": [
+            _call("eventEmit", _var("bus"), saved, _lit("y")), _var("seen"),
+        ]}]},
+        {"type": "Let", "name": "ticks", "value": {"type": "Array", "items": []}},
+        _call("eventOn", _call("eventBus"), _lit("tick"),
+              _call("partial", _var("record"), _var("ticks"), _lit("tick"))),
+        {"type": "Print", "args": [_call("hostEvents")]},
+        {"type": "Let", "name": "got", "value": _lit(0)},
+        {"type": "While", "test": _bin("<", _var("got"), _lit(3)), "body": [
+            {"type": "Assign", "name": "got", "value": _bin(
+                "+", _var("got"), _call("eventDispatch", _call("eventBus"), _lit(-1)))},
+        ]},
+        {"type": "Print", "args": [_call("eventDispatch", _call("eventBus"), _lit(0.05)), _var("ticks")]},
+    ])
+    out = _run_go(doc, host_code=_EVENTS_HOST)
+    assert out.splitlines() == [
+        "(1, ['a:x', 'b:x', 'b:y'])",
+        "None",
+        "0 ['tick:1', 'tick:2', 'tick:3']",
+    ], out
+
+_TIMERS_HOST = """package main
+
+import "time"
+
+// cronTimes lists the next run after a fixed time for some schedules.
+func cronTimes() Value {
+\tsat := time.Date(2026, 3, 7, 12, 3, 30, 0, time.UTC)
+\tvar out []Value
+\tfor _, spec := range []string{"*/10 * * * *", "0 9 * * 1-5", "30 6 1,15 * *", "0 0 29 2 *"} {
+\t\tt, _ := parseCron(spec).next(sat)
+\t\tout = append(out, ValueStr(t.Format("Mon 2006-01-02 15:04")))
+\t}
+\treturn ValueArray(out)
+}
+"""
+
+
+def test_run_timers():
+    if not _has_go():
+        return
+
+    def ticks(delta):
+        return {"type": "Set", "base": _var("state"), "key": _lit("ticks"),
+                "value": _bin("+", {"type": "Get", "base": _var("state"), "key": _lit("ticks")}, _lit(delta))}
+
+    timer = {"type": "Get", "base": _var("state"), "key": _lit("timer")}
+    failing_timeout = _call("setTimeout", _var("fail"), _lit(0))
+    doc = _prog([
+        # The interval ticks every 20ms and cancels its own timer on the
+        # third tick; a cancelled timeout never fires
+        _func("tick", ["state"],
+              ticks(1),
+              {"type": "If", "test": _bin("==", {"type": "Get", "base": _var("state"), "key": _lit("ticks")}, _lit(3)),
+               "then": [_call("timerCancel", timer)]}),
+        _func("never", ["state"], ticks(100)),
+        _func("fail", [], _ret({"type": "Get", "base": {"type": "Map", "items": []}, "key": _lit("feed")})),
+        {"type": "Let", "name": "state", "value": {"type": "Map", "items": [{"key": _lit("ticks"), "value": _lit(0)}]}},
+        {"type": "Set", "base": _var("state"), "key": _lit("timer"),
+         "value": _call("setInterval", _var("tick"), _lit(0.02), _var("state"))},
+        {"type": "Let", "name": "timeout", "value": _call("setTimeout", _var("never"), _lit(0.03), _var("state"))},
+        _call("timerCancel", _var("timeout")),
+        _call("timerWait", timer),
+        _call("timerWait", _var("timeout")),
+        {"type": "Print", "args": [{"type": "Get", "base": _var("state"), "key": _lit("ticks")}]},
+        _report_error({"type": "Print", "args": [_call("timerWait", failing_timeout)]}),
+        {"type": "Print", "args": [_call("cronTimes")]},
+        _report_error({"type": "Print", "args": [_call("scheduleEvery", _lit("0 0 30 2 *"), failing_timeout)]}),
+        _report_error({"type": "Print", "args": [_call("scheduleEvery", _lit("often"), failing_timeout)]}),
+    ])
+    out = _run_go(doc, host_code=_TIMERS_HOST)
+    assert out.splitlines() == [
+        "3",
+        "KeyError runtime error: key 'feed' not found",
+        "['Sat 2026-03-07 12:10', 'Mon 2026-03-09 09:00', 'Sun 2026-03-15 06:30', 'Tue 2028-02-29 00:00']",
+        "ValueError runtime error: cron schedule \"0 0 30 2 *\" never fires",
+        "ValueError runtime error: invalid schedule \"often\"",
+    ], out
+
+
+def test_run_generators():
+    if not _has_go():
+        return
+
+    def words(text, fail):
+        return [
+            {"type": "Let", "name": "words", "value": {"type": "Array", "items": []}},
+            {"type": "ForEach", "var": "w", "iter": _call("generator", _var("lines"), _lit(text), _lit(fail)),
+             "body": [{"type": "Push", "base": _var("words"), "value": _var("w")}]},
+            {"type": "Print", "args": [_var("words")]},
+        ]
+
+    doc = _prog([
+        # fib yields the Fibonacci numbers forever
+        _func("fib", ["yield"],
+              {"type": "Let", "name": "a", "value": _lit(0)},
+              {"type": "Let", "name": "b", "value": _lit(1)},
+              {"type": "While", "test": _lit(True), "body": [
+                  _call("callValue", _var("yield"), _var("a")),
+                  {"type": "Let", "name": "sum", "value": _bin("+", _var("a"), _var("b"))},
+                  {"type": "Assign", "name": "a", "value": _var("b")},
+                  {"type": "Assign", "name": "b", "value": _var("sum")},
+              ]}),
+        # lines yields the words of a sentence, then fails if asked to
+        _func("lines", ["yield", "text", "fail"],
+              {"type": "ForEach", "var": "w", "iter": {"type": "StringSplit", "base": _var("text"), "delimiter": _lit(" ")},
+               "body": [_call("callValue", _var("yield"), _var("w"))]},
+              {"type": "If", "test": _var("fail"), "then": [
+                  _ret({"type": "Get", "base": {"type": "Map", "items": []}, "key": _lit("eof")}),
+              ]}),
+        {"type": "Let", "name": "gen", "value": _call("generator", _var("fib"))},
+        {"type": "Let", "name": "first", "value": {"type": "Array", "items": []}},
+        {"type": "For", "var": "i", "iter": {"type": "Range", "from": _lit(0), "to": _lit(6), "inclusive": False},
+         "body": [{"type": "Push", "base": _var("first"), "value": _call("generatorNext", _var("gen"))}]},
+        _call("generatorClose", _var("gen")),
+        {"type": "Print", "args": [{"type": "Tuple", "items": [_var("first"), _call("generatorNext", _var("gen"))]}]},
+        *words("produce then consume", False),
+        {"type": "TryCatch", "body": words("a b", True), "catch_var": "err",
+         "catch_body": [{"type": "Print", "args": [_call("errorKind", _var("err")), _var("err")]}]},
+    ])
+    out = _run_go(doc)
+    assert out.splitlines() == [
+        "([Some(0), Some(1), Some(1), Some(2), Some(3), Some(5)], None)",
+        "['produce', 'then', 'consume']",
+        "KeyError runtime error: key 'eof' not found",
+    ], out
+
+_SANDBOX_HOST = """package main
+
+func init() {
+\tDefaultEngine.SetSandbox(CapIOWrite)
+}
+"""
+
+
+def test_run_read_lines():
+    if not _has_go():
+        return
+    with tempfile.TemporaryDirectory() as tmpdir:
+        path = Path(tmpdir) / "app.log"
+        path.write_bytes(b"start\r\nwarn disk\n\nstop")
+        lines = _call("readLines", _lit(str(path)))
+        doc = _prog([
+            {"type": "Let", "name": "lines", "value": lines},
+            {"type": "ForEach", "var": "line", "iter": _var("lines"), "body": [
+                {"type": "Print", "args": [_var("line")]},
+                {"type": "Break"},
+            ]},
+            # The loop left off after the first line
+            {"type": "ForEach", "var": "line", "iter": _var("lines"), "body": [
+                {"type": "Print", "args": [{"type": "StringLength", "base": _var("line")}]},
+            ]},
+            {"type": "For", "var": "chunk", "iter": _call("readChunks", _lit(str(path)), _lit(8)), "body": [
+                {"type": "Print", "args": [{"type": "StringLength", "base": _var("chunk")}]},
+            ]},
+            {"type": "TryCatch",
+             "body": [{"type": "ForEach", "var": "line", "iter": _call("readLines", _lit(tmpdir + "/missing")),
+                       "body": []}],
+             "catch_var": "err",
+             "catch_body": [{"type": "Print", "args": [_call("errorKind", _var("err"))]}]},
+        ])
+        doc["version"] = "coreil-1.11"
+        out = _run_go(doc, grants=["io.read"])
+        denied = _exec_go(doc, host_code=_SANDBOX_HOST)
+        # The interpreter reads the same lines and chunks
+        interp_doc = dict(doc, body=doc["body"][:-1])
+        interp_out = _run_interp(interp_doc)
+    assert out.splitlines() == ["start", "9", "0", "4", "8", "8", "6", "IOError"], out
+    assert out.startswith(interp_out), interp_out
+    assert denied.returncode == 1
+    assert "sandbox denies io.read for readLines" in denied.stderr, denied.stderr
+
+
+def test_run_memoize():
+    if not _has_go():
+        return
+    fib_call = lambda n: _call("fib", n)
+    doc = _prog([
+        {"type": "FuncDef", "name": "fib", "params": ["n"], "body": [
+            {"type": "If", "test": _bin("<", _var("n"), _lit(2)),
+             "then": [{"type": "Return", "value": _var("n")}]},
+            {"type": "Return", "value": _bin(
+                "+", fib_call(_bin("-", _var("n"), _lit(1))), fib_call(_bin("-", _var("n"), _lit(2))))},
+        ]},
+        _func("slowSquare", ["n"], _ret(_bin("*", _var("n"), _var("n")))),
+        {"type": "Print", "args": [fib_call(_lit(90))]},
+        {"type": "Print", "args": [_call("memoStats", _lit("fib"))]},
+        # A memoized function value, called with repeated arguments
+        {"type": "Let", "name": "sq", "value": _call("memoize", _var("slowSquare"), _lit(2))},
+        {"type": "ForEach", "var": "n", "iter": {"type": "Array", "items": [_lit(n) for n in (1, 2, 1, 3, 1, 2)]},
+         "body": [_call("callValue", _var("sq"), _var("n"))]},
+        {"type": "Print", "args": [_call("memoStats", _var("sq"))]},
+    ])
+    code, _ = emit_go(doc, memoize=["fib"])
+    assert 'var __memo_fib = newMemo("fib", 0)' in code
+    out = _run_go(doc, memoize={"fib": 0})
+    assert out.splitlines() == [
+        "2880067194370816120",
+        "{'hits': 88, 'misses': 91, 'size': 91, 'maxsize': 0}",
+        "{'hits': 2, 'misses': 4, 'size': 2, 'maxsize': 2}",
+    ], out
+
+_MEMO_POOL_HOST = """package main
+
+import "testing"
+
+// memoHitAllocs counts allocations per cache hit of a two-argument memo,
+// with buffer pooling set to on.
+func memoHitAllocs(on Value) Value {
+\tDefaultEngine.SetBufferPooling(asBool(on))
+\tdefer DefaultEngine.SetBufferPooling(false)
+\tm := makeMemo(0)
+\targs := []Value{ValueInt(3), ValueStr("x")}
+\tcompute := func() Value { return ValueInt(1) }
+\tm.call(args, compute)
+\tallocs := testing.AllocsPerRun(100, func() {
+\t\tm.call(args, compute)
+\t})
+\treturn ValueInt(int64(allocs))
+}
+"""
+
+
+def test_run_memo_key_pooling():
+    if not _has_go():
+        return
+    doc = _prog([
+        {"type": "Let", "name": "plain", "value": _call("memoHitAllocs", _lit(False))},
+        {"type": "Let", "name": "pooled", "value": _call("memoHitAllocs", _lit(True))},
+        {"type": "Print", "args": [_var("plain"), _var("pooled"), _bin("<", _var("pooled"), _var("plain"))]},
+    ])
+    out = _run_go(doc, host_code=_MEMO_POOL_HOST)
+    assert out.split()[2] == "True", out
+
+
+def test_run_user_function_names():
+    if not _has_go():
+        return
+    # Functions named like runtime helpers must not clash with them
+    doc = _prog([
+        {"type": "FuncDef", "name": "compose", "params": ["a", "b"], "body": [
+            {"type": "Return", "value": _bin("+", _var("a"), _var("b"))},
+        ]},
+        {"type": "FuncDef", "name": "partial", "params": ["x"], "body": [
+            {"type": "Return", "value": _call("compose", _var("x"), _lit(1))},
+        ]},
+        {"type": "FuncDef", "name": "freeze", "params": [], "body": [
+            {"type": "Return", "value": _lit("frozen")},
+        ]},
+        {"type": "FuncDef", "name": "spawn", "params": ["n", "total"], "body": [
+            {"type": "If", "test": _bin("==", _var("n"), _lit(0)),
+             "then": [{"type": "Return", "value": _var("total")}]},
+            {"type": "Return", "value": _call("spawn", _bin("-", _var("n"), _lit(1)),
+                                              _bin("+", _var("total"), _var("n")))},
+        ]},
+        {"type": "FuncDef", "name": "memoize", "params": ["n"], "body": [
+            {"type": "Return", "value": _bin("*", _var("n"), _lit(2))},
+        ]},
+        {"type": "Print", "args": [_call("compose", _lit(2), _lit(3)), _call("partial", _lit(4)),
+                                   _call("freeze")]},
+        {"type": "Print", "args": [_call("spawn", _lit(10000), _lit(0))]},
+        {"type": "Call", "name": "freeze", "args": []},
+        {"type": "Print", "args": [_call("memoize", _lit(21)), _call("memoize", _lit(21))]},
+    ])
+    code, _ = emit_go(doc, memoize=["memoize"])
+    assert "func fn_compose(a Value, b Value) Value {" in code
+    expected = ["5 5 frozen", "50005000", "42 42"]
+    assert _run_interp(doc).splitlines() == expected
+    assert _run_go(doc).splitlines() == expected
+    assert _run_go(doc, memoize={"memoize": 0}).splitlines() == expected
+
+
+_COUNTER_HOST = """package main
+
+// counter returns an instance of a class whose add method adds to a base.
+func counter(base Value) Value {
+\tcls := Value{Type: TypeClass, data: NewClass("Counter", nil, []struct {
+\t\tName string
+\t\tVal  Value
+\t}{{"add", ValueFunc("add", func(args []Value) Value {
+\t\treturn ValueInt(asInt(recordGetField(args[0], "base")) + asInt(args[1]))
+\t})}})}
+\tobj := classNew(cls)
+\trecordSetField(obj, "base", base)
+\treturn obj
+}
+"""
+
+# Pricing steps for the combinator tests
+_PRICING_FUNCS = [
+    _func("discount", ["x"], _ret(_bin("-", _var("x"), _lit(10)))),
+    _func("tax", ["x"], _ret(_bin("*", _var("x"), _lit(2)))),
+    _func("scale", ["x", "k"], _ret(_bin("*", _var("x"), _var("k")))),
+]
+
+
+def test_run_compose():
+    if not _has_go():
+        return
+    doc = _prog(_PRICING_FUNCS + [
+        {"type": "Let", "name": "price", "value": _call("compose", _var("tax"), _var("discount"))},
+        {"type": "Print", "args": [_call("callValue", _var("price"), _lit(100))]},
+        {"type": "Let", "name": "triple", "value": _call("partial", _var("scale"), _lit(3))},
+        {"type": "Print", "args": [_call("callValue", _var("triple"), _lit(7))]},
+        {"type": "Let", "name": "add", "value": _call("bindMethod", _call("counter", _lit(40)), _lit("add"))},
+        {"type": "Print", "args": [_call("callValue", _call("compose", _var("add"), _var("triple")), _lit(1))]},
+        _report_error({"type": "Print", "args": [_call("bindMethod", _call("counter", _lit(0)), _lit("sub"))]}),
+    ])
+    out = _run_go(doc, host_code=_COUNTER_HOST)
+    assert out.splitlines() == [
+        "180",
+        "21",
+        "43",
+        "AttributeError runtime error: 'Counter' object has no method 'sub'",
+    ], out
+
+_KEEPER_HOST = """package main
+
+func init() {
+\tDefaultEngine.SetBufferPooling(true)
+}
+
+var kept []Value
+
+// keeper returns an instance whose keep method holds on to its first args.
+func keeper() Value {
+\tcls := Value{Type: TypeClass, data: NewClass("Keeper", nil, []struct {
+\t\tName string
+\t\tVal  Value
+\t}{{"keep", ValueFunc("keep", func(args []Value) Value {
+\t\tif kept == nil {
+\t\t\tkept = args
+\t\t}
+\t\treturn ValueNone
+\t})}})}
+\treturn classNew(cls)
+}
+
+func keptArg() Value {
+\treturn kept[1]
+}
+"""
+
+
+def test_run_method_args_not_pooled():
+    if not _has_go():
+        return
+    keep = _call("bindMethod", _var("k"), _lit("keep"))
+    doc = _prog([
+        {"type": "Let", "name": "k", "value": _call("keeper")},
+        _call("callValue", keep, _lit("first")),
+        _call("callValue", keep, _lit("second")),
+        {"type": "Print", "args": [_call("keptArg")]},
+    ])
+    # The args a method kept must not be reused for the next call
+    assert _run_go(doc, host_code=_KEEPER_HOST) == "first\n"
+
+def test_run_method_shadowing():
+    if not _has_go():
+        return
+    host = _COUNTER_HOST + """
+func callAdd(obj, x Value) Value {
+\treturn callMethod(obj, ValueStr("add"), x)
+}
+"""
+    add = _call("bindMethod", _var("c"), _lit("add"))
+    doc = _prog(_PRICING_FUNCS + [
+        {"type": "Let", "name": "c", "value": _call("counter", _lit(40))},
+        {"type": "Print", "args": [_call("callValue", add, _lit(2)), _call("callAdd", _var("c"), _lit(2))]},
+        # An instance field shadows the class method, as in Python
+        {"type": "SetField", "base": _var("c"), "name": "add", "value": _var("tax")},
+        {"type": "Print", "args": [_call("callValue", add, _lit(2)), _call("callAdd", _var("c"), _lit(2))]},
+    ])
+    out = _run_go(doc, host_code=host)
+    assert out.splitlines() == ["42 42", "4 4"], out
+
+
+_SCHEMA_HOST = """package main
+
+var pointSchema = NewRecordSchema("Point", []SchemaField{
+\t{Name: "x", Type: "int", Required: true},
+\t{Name: "y", Type: "float", Required: true},
+\t{Name: "label", Type: "str"},
+})
+
+// point builds a Point record from a map of its fields.
+func point(fields Value) Value {
+\tvar pairs []struct{ Name string; Val Value }
+\tfor _, k := range *asArray(mapKeys(fields)) {
+\t\tpairs = append(pairs, struct{ Name string; Val Value }{asString(k), mapGet(fields, k)})
+\t}
+\treturn ValueRecordNewWithSchema(pointSchema, pairs)
+}
+
+func duplicateSchema() Value {
+\tNewRecordSchema("Bad", []SchemaField{{Name: "x", Type: "int"}, {Name: "x", Type: "str"}})
+\treturn ValueNone
+}
+"""
+
+
+def test_run_record_schema():
+    if not _has_go():
+        return
+
+    def point(**fields):
+        return _call("point", {"type": "Map", "items": [{"key": _lit(k), "value": v} for k, v in fields.items()]})
+
+    def attempt(node):
+        return {"type": "TryCatch", "body": [node], "catch_var": "err", "catch_body": [
+            {"type": "Print", "args": [_call("errorKind", _var("err")), _var("err")]},
+        ]}
+
+    doc = _prog([
+        # An int is accepted for a float field, and optional fields may be None
+        {"type": "Print", "args": [point(x=_lit(1), y=_lit(2))]},
+        {"type": "Let", "name": "p", "value": point(x=_lit(1), y=_lit(2.5), label=_lit(None))},
+        {"type": "SetField", "base": _var("p"), "name": "label", "value": _lit("origin")},
+        {"type": "Print", "args": [_var("p")]},
+        attempt({"type": "Print", "args": [point(x=_lit(1))]}),
+        attempt({"type": "Print", "args": [point(x=_lit("1"), y=_lit(2))]}),
+        attempt({"type": "Print", "args": [point(x=_lit(1), y=_lit(2), z=_lit(3))]}),
+        # Assignments are checked too
+        attempt({"type": "SetField", "base": _var("p"), "name": "y", "value": _lit(True)}),
+        attempt({"type": "SetField", "base": _var("p"), "name": "z", "value": _lit(0)}),
+        attempt({"type": "Print", "args": [_call("duplicateSchema")]}),
+        {"type": "Print", "args": [_var("p")]},
+    ])
+    out = _run_go(doc, host_code=_SCHEMA_HOST)
+    assert out.splitlines() == [
+        "Record(x=1, y=2)",
+        "Record(x=1, y=2.5, label='origin')",
+        "TypeError runtime error: Point: missing required field 'y'",
+        "TypeError runtime error: Point: field 'x' expected int, got str",
+        "TypeError runtime error: Point: unexpected field 'z'",
+        "TypeError runtime error: Point: field 'y' expected float, got bool",
+        "TypeError runtime error: Point: unexpected field 'z'",
+        "ValueError runtime error: Bad: duplicate schema field 'x'",
+        "Record(x=1, y=2.5, label='origin')",
+    ], out
+
+
+def test_run_record_fields():
+    if not _has_go():
+        return
+    r = _var("r")
+
+    def attempt(node):
+        return {"type": "TryCatch", "body": [node], "catch_var": "err", "catch_body": [
+            {"type": "Print", "args": [_call("errorKind", _var("err")), _var("err")]},
+        ]}
+
+    doc = _prog([
+        {"type": "Let", "name": "r", "value": {"type": "Record", "fields": [
+            {"name": "name", "value": _lit("Ada")}, {"name": "age", "value": _lit(36)},
+        ]}},
+        {"type": "Print", "args": [_call("recordFields", r), _call("recordHas", r, _lit("age")),
+                                   _call("recordHas", r, _lit("email"))]},
+        # Print every field and its value
+        {"type": "Let", "name": "m", "value": _call("recordToMap", r)},
+        {"type": "ForEach", "var": "f", "iter": _call("recordFields", r), "body": [
+            {"type": "Print", "args": [_var("f"), {"type": "Get", "base": _var("m"), "key": _var("f")}]},
+        ]},
+        # The map is a copy
+        {"type": "Set", "base": _var("m"), "key": _lit("age"), "value": _lit(0)},
+        _call("recordDelete", r, _lit("age")),
+        {"type": "Print", "args": [r, _var("m")]},
+        attempt(_call("recordDelete", r, _lit("age"))),
+        {"type": "Let", "name": "q", "value": _call("mapToRecord", {"type": "Map", "items": [
+            {"key": _lit("y"), "value": _lit(2)}, {"key": _lit("x"), "value": _lit(1)},
+        ]})},
+        {"type": "Print", "args": [_var("q"), {"type": "GetField", "base": _var("q"), "name": "x"}]},
+        attempt({"type": "Print", "args": [_call("mapToRecord", {"type": "Map", "items": [
+            {"key": _lit(1), "value": _lit("one")},
+        ]})]}),
+        # Required schema fields cannot be deleted
+        {"type": "Let", "name": "p", "value": _call("point", {"type": "Map", "items": [
+            {"key": _lit("x"), "value": _lit(1)}, {"key": _lit("y"), "value": _lit(2)},
+            {"key": _lit("label"), "value": _lit("a")},
+        ]})},
+        _call("recordDelete", _var("p"), _lit("label")),
+        attempt(_call("recordDelete", _var("p"), _lit("x"))),
+        {"type": "Print", "args": [_call("recordFields", _var("p"))]},
+    ])
+    out = _run_go(doc, host_code=_SCHEMA_HOST)
+    assert out.splitlines() == [
+        "['name', 'age'] True False",
+        "name Ada",
+        "age 36",
+        "Record(name='Ada') {'name': 'Ada', 'age': 0}",
+        "AttributeError runtime error: field 'age' not found",
+        "Record(y=2, x=1) 1",
+        "TypeError runtime error: expected string, got int",
+        "TypeError runtime error: Point: cannot delete required field 'x'",
+        "['x', 'y']",
+    ], out
+
+
+# Money prints through __str__ and __repr__ and orders through __eq__ and
+# __lt__; Tag has no hooks; Bad's __str__ returns a non-string
+_HOOKS_HOST = """package main
+
+import "fmt"
+
+type methods = []struct{ Name string; Val Value }
+
+func method(name string, fn func(args []Value) Value) struct{ Name string; Val Value } {
+\treturn struct{ Name string; Val Value }{name, ValueFunc(name, fn)}
+}
+
+func cents(v Value) int64 { return asInt(recordGetField(v, "cents")) }
+
+var moneyClass = ValueClass(NewClass("Money", nil, methods{
+\tmethod("__str__", func(args []Value) Value { return ValueStr(fmt.Sprintf("$%.2f", float64(cents(args[0]))/100)) }),
+\tmethod("__repr__", func(args []Value) Value { return ValueStr(fmt.Sprintf("Money(%d)", cents(args[0]))) }),
+\tmethod("__eq__", func(args []Value) Value { return ValueBool(cents(args[0]) == cents(args[1])) }),
+\tmethod("__lt__", func(args []Value) Value { return ValueBool(cents(args[0]) < cents(args[1])) }),
+}))
+
+var tagClass = ValueClass(NewClass("Tag", nil, nil))
+
+var badClass = ValueClass(NewClass("Bad", nil, methods{
+\tmethod("__str__", func(args []Value) Value { return ValueInt(1) }),
+}))
+
+func money(c Value) Value {
+\tobj := classNew(moneyClass)
+\trecordSetField(obj, "cents", c)
+\treturn obj
+}
+
+func tag() Value { return classNew(tagClass) }
+func bad() Value { return classNew(badClass) }
+func repr(v Value) Value { return ValueStr(reprValue(v)) }
+"""
+
+
+def test_run_display_hooks():
+    if not _has_go():
+        return
+    a, b, c = _call("money", _lit(250)), _call("money", _lit(1999)), _call("money", _lit(250))
+    doc = _prog([
+        {"type": "Print", "args": [a, {"type": "Array", "items": [a, b]}, _call("repr", b)]},
+        {"type": "Print", "args": [_bin("==", a, c), _bin("!=", a, b), _bin("<", a, b), _bin(">", a, b),
+                                   _bin("<=", a, c), _bin(">=", b, a)]},
+        {"type": "Print", "args": [_call("coreilMin", b, a, c), _call("coreilMax", a, b, c)]},
+        # Without __eq__, instances are equal only to themselves
+        {"type": "Let", "name": "t", "value": _call("tag")},
+        {"type": "Print", "args": [_bin("==", _var("t"), _var("t")), _bin("==", _var("t"), _call("tag")),
+                                   _call("repr", _var("t"))]},
+        {"type": "TryCatch",
+         "body": [{"type": "Print", "args": [_call("bad")]}],
+         "catch_var": "err",
+         "catch_body": [{"type": "Print", "args": [_call("errorKind", _var("err")), _var("err")]}]},
+        {"type": "TryCatch",
+         "body": [{"type": "Print", "args": [_bin("<", _var("t"), _var("t"))]}],
+         "catch_var": "err",
+         "catch_body": [{"type": "Print", "args": [_call("errorKind", _var("err")), _var("err")]}]},
+    ])
+    out = _run_go(doc, host_code=_HOOKS_HOST)
+    assert out.splitlines() == [
+        "$2.50 [Money(250), Money(1999)] Money(1999)",
+        "True True True False True True",
+        "$2.50 $19.99",
+        "True False <Tag object>",
+        "TypeError runtime error: __str__ returned non-string (type int)",
+        "TypeError runtime error: cannot compare record and record",
+    ], out
+
+
+# An order is either pending, shipped (with a carrier) or cancelled;
+# describe matches every tag, describeShipped only one and a default
+_VARIANT_HOST = """package main
+
+var orderEnum = NewEnum("Order", []string{"pending", "shipped", "cancelled"})
+
+type cases = []struct{ Name string; Val Value }
+
+func order(tag, payload Value) Value { return ValueVariant(orderEnum, asString(tag), payload) }
+
+func fn(name string, f func(args []Value) Value) Value { return ValueFunc(name, f) }
+
+func describe(v Value) Value {
+\treturn matchVariant(v, cases{
+\t\t{"pending", fn("pending", func(args []Value) Value { return ValueStr("waiting") })},
+\t\t{"shipped", fn("shipped", func(args []Value) Value { return valueAdd(ValueStr("via "), args[0]) })},
+\t\t{"cancelled", fn("cancelled", func(args []Value) Value { return ValueStr("refunded") })},
+\t}, ValueNone)
+}
+
+func describeShipped(v Value) Value {
+\treturn matchVariant(v, cases{
+\t\t{"shipped", fn("shipped", func(args []Value) Value { return args[0] })},
+\t}, fn("other", func(args []Value) Value { return valueAdd(ValueStr("not shipped: "), variantTag(args[0])) }))
+}
+
+func describeSome(v Value) Value {
+\treturn matchVariant(v, cases{
+\t\t{"pending", fn("pending", func(args []Value) Value { return ValueNone })},
+\t}, ValueNone)
+}
+
+func describeTypo(v Value) Value {
+\treturn matchVariant(v, cases{
+\t\t{"shiped", fn("shiped", func(args []Value) Value { return ValueNone })},
+\t}, fn("other", func(args []Value) Value { return ValueNone }))
+}
+"""
+
+
+def test_run_variants():
+    if not _has_go():
+        return
+    shipped = _call("order", _lit("shipped"), _lit("UPS"))
+    pending = _call("order", _lit("pending"), _lit(None))
+
+    def attempt(node):
+        return {"type": "TryCatch", "body": [{"type": "Print", "args": [node]}], "catch_var": "err",
+                "catch_body": [{"type": "Print", "args": [_call("errorKind", _var("err")), _var("err")]}]}
+
+    doc = _prog([
+        {"type": "Print", "args": [shipped, pending, {"type": "Array", "items": [shipped]}]},
+        {"type": "Print", "args": [_call("variantTag", shipped), _call("variantPayload", shipped),
+                                   _call("variantIs", pending, _lit("pending")),
+                                   _call("variantIs", pending, _lit("shipped"))]},
+        {"type": "Print", "args": [_bin("==", shipped, _call("order", _lit("shipped"), _lit("UPS"))),
+                                   _bin("==", shipped, _call("order", _lit("shipped"), _lit("DHL"))),
+                                   _bin("==", pending, _lit("pending"))]},
+        {"type": "Print", "args": [_call("describe", shipped), _call("describe", pending),
+                                   _call("describe", _call("order", _lit("cancelled"), _lit(None)))]},
+        {"type": "Print", "args": [_call("describeShipped", shipped), _call("describeShipped", pending)]},
+        attempt(_call("order", _lit("lost"), _lit(None))),
+        attempt(_call("describeSome", pending)),
+        attempt(_call("describeTypo", pending)),
+        attempt(_call("variantTag", _lit("shipped"))),
+    ])
+    out = _run_go(doc, host_code=_VARIANT_HOST)
+    assert out.splitlines() == [
+        "Order.shipped('UPS') Order.pending [Order.shipped('UPS')]",
+        "shipped UPS True False",
+        "True False False",
+        "via UPS waiting refunded",
+        "UPS not shipped: pending",
+        "ValueError runtime error: Order has no variant 'lost'",
+        "ValueError runtime error: non-exhaustive match on Order: missing shipped, cancelled",
+        "ValueError runtime error: Order has no variant 'shiped'",
+        "TypeError runtime error: expected variant, got str",
+    ], out
+
+
+def test_run_option_result():
+    if not _has_go():
+        return
+    some, none = _call("optionSome", _lit(3)), _call("optionNoneOf")
+    ok, err = _call("resultOk", _lit(1.5)), _call("resultErr", _lit("boom"))
+    scores = {"type": "Map", "items": [{"key": _lit("ada"), "value": _lit(90)}]}
+    items = {"type": "Array", "items": [_lit("a"), _lit("b")]}
+
+    def attempt(node):
+        return {"type": "TryCatch", "body": [{"type": "Print", "args": [node]}], "catch_var": "e",
+                "catch_body": [{"type": "Print", "args": [_call("errorKind", _var("e")), _var("e")]}]}
+
+    doc = _prog([
+        _func("double", ["x"], _ret(_bin("*", _var("x"), _lit(2)))),
+        _func("reciprocal", ["x"], _ret(_bin("/", _lit(1), _var("x")))),
+        {"type": "Print", "args": [some, none, ok, err]},
+        {"type": "Print", "args": [_call("isSome", some), _call("isSome", none), _call("isOk", ok), _call("isOk", err)]},
+        {"type": "Print", "args": [_call("unwrap", some), _call("unwrap", ok), _call("unwrapOr", none, _lit(0)),
+                                   _call("unwrapOr", err, _lit(0))]},
+        {"type": "Print", "args": [_call("optionMap", some, _var("double")), _call("optionMap", none, _var("double")),
+                                   _call("optionMap", ok, _var("double")), _call("optionMap", err, _var("double"))]},
+        {"type": "Print", "args": [_call("tryCall", _var("reciprocal"), _lit(4)),
+                                   _call("tryCall", _var("reciprocal"), _lit(0))]},
+        {"type": "Print", "args": [_call("mapGetOption", scores, _lit("ada")), _call("mapGetOption", scores, _lit("bob")),
+                                   _call("arrayIndexOption", items, _lit(-1)), _call("arrayIndexOption", items, _lit(2))]},
+        {"type": "Print", "args": [_call("parseIntResult", _lit(" 42 ")), _call("parseIntResult", _lit("4x")),
+                                   _call("parseFloatResult", _lit("2.5")), _call("parseFloatResult", _lit("x"))]},
+        attempt(_call("unwrap", none)),
+        attempt(_call("unwrap", err)),
+        attempt(_call("unwrap", _lit(3))),
+    ])
+    out = _run_go(doc)
+    assert out.splitlines() == [
+        "Some(3) None Ok(1.5) Err('boom')",
+        "True False True False",
+        "3 1.5 0 0",
+        "Some(6) None Ok(3.0) Err('boom')",
+        "Ok(0.25) Err('runtime error: division by zero')",
+        "Some(90) None Some('b') None",
+        "Ok(42) Err(\"invalid literal for int() with base 10: '4x'\") Ok(2.5) Err(\"could not convert string to float: 'x'\")",
+        "ValueError runtime error: called unwrap on None",
+        "ValueError runtime error: called unwrap on Err: boom",
+        "TypeError runtime error: expected Option or Result, got int",
+    ], out
+
+
+def test_run_contains():
+    if not _has_go():
+        return
+
+    def node(value):
+        if isinstance(value, list):
+            return {"type": "Array", "items": [node(v) for v in value]}
+        if isinstance(value, tuple):
+            return {"type": "Tuple", "items": [node(v) for v in value]}
+        if isinstance(value, set):
+            return {"type": "Set", "items": [node(v) for v in sorted(value, key=repr)]}
+        if isinstance(value, dict):
+            return {"type": "Map", "items": [{"key": node(k), "value": node(v)} for k, v in value.items()]}
+        return _lit(value)
+
+    cases = [
+        ("ell", "hello"), ("", "hello"), ("Hello", "hello"),
+        (2, [1, 2.0, 3]), (True, [1]), ([1], [[1], [2]]), ("b", ["a", "bc"]),
+        (2, (1, 2)), ((1, 2), [(1, 2)]),
+        ("ada", {"ada": 1}), (1, {"ada": 1}), (1.0, {1: "one"}),
+        (3, {1, 2, 3}), (3.0, {3}), ("x", {"y"}),
+        # Items compare with ==, which is structural for every container
+        ({"a": 1, "b": 2}, [{"b": 2, "a": 1}]), ({"a": 1}, [{"a": 2}]), ({1, 2}, [{2, 1}]),
+        ([1, (2, 3)], [[1, (2, 3)]]), ((1, 2), [(1, 2, 3)]),
+        # Errors match Python's
+        (3, "abc"), (1, 5),
+    ]
+    doc = _prog([
+        {"type": "TryCatch",
+         "body": [{"type": "Print", "args": [_call("valueContains", node(container), node(item))]}],
+         "catch_var": "err",
+         "catch_body": [{"type": "Print", "args": [_call("errorKind", _var("err")), _var("err")]}]}
+        for item, container in cases
+    ] + [
+        # Records contain their field names
+        {"type": "Let", "name": "r", "value": {"type": "Record", "fields": [{"name": "x", "value": _lit(1)}]}},
+        {"type": "Print", "args": [_call("valueContains", _var("r"), _lit("x")),
+                                   _call("valueContains", _var("r"), _lit("y")),
+                                   _call("valueContains", _var("r"), _lit(1))]},
+    ])
+    expected = []
+    for item, container in cases:
+        try:
+            expected.append(str(item in container))
+        except TypeError as e:
+            expected.append(f"TypeError runtime error: {e}")
+    out = _run_go(doc)
+    assert out.splitlines() == expected + ["True False False"], out
+
+
+# The lazy forms only evaluate (and here, fail on) the right operand when
+# the left one does not decide the result
+_AND_OR_HOST = """package main
+
+func evaluated() Value { panic(runtimeError(KindValueError, "right operand evaluated")) }
+
+func orElseFail(a Value) Value  { return valueOrLazy(a, evaluated) }
+func andThenFail(a Value) Value { return valueAndLazy(a, evaluated) }
+"""
+
+
+def test_run_and_or_operands():
+    if not _has_go():
+        return
+
+    def node(value):
+        if isinstance(value, list):
+            return {"type": "Array", "items": [node(v) for v in value]}
+        if isinstance(value, dict):
+            return {"type": "Map", "items": [{"key": _lit(k), "value": node(v)} for k, v in value.items()]}
+        return _lit(value)
+
+    operands = ["", "anonymous", 0, 7, 0.0, -1.5, None, True, False, [], [0], {}, {"a": 1}]
+    pairs = [(a, b) for a in operands for b in ("anonymous", 0, None)]
+
+    def attempt(node):
+        return {"type": "TryCatch", "body": [{"type": "Print", "args": [node]}], "catch_var": "err",
+                "catch_body": [{"type": "Print", "args": [_var("err")]}]}
+
+    doc = _prog([
+        {"type": "Print", "args": [{"type": "Array", "items": [
+            _call("valueAnd", node(a), node(b)), _call("valueOr", node(a), node(b)),
+        ]}]}
+        for a, b in pairs
+    ] + [
+        {"type": "Print", "args": [_call("orElseFail", _lit("name")), _call("andThenFail", _lit(0))]},
+        attempt(_call("orElseFail", _lit(""))),
+        attempt(_call("andThenFail", _lit(1))),
+    ])
+    out = _run_go(doc, host_code=_AND_OR_HOST)
+    assert out.splitlines() == [repr([a and b, a or b]) for a, b in pairs] + [
+        "name 0",
+        "runtime error: right operand evaluated",
+        "runtime error: right operand evaluated",
+    ], out
+
+
+def test_run_unary_operators():
+    if not _has_go():
+        return
+    operands = [0, 5, -7, 9223372036854775807, 0.0, -0.0, 2.5, float("inf"), True, False]
+
+    def attempt(node):
+        return {"type": "TryCatch", "body": [{"type": "Print", "args": [node]}], "catch_var": "err",
+                "catch_body": [{"type": "Print", "args": [_call("errorKind", _var("err")), _var("err")]}]}
+
+    doc = _prog([
+        {"type": "Print", "args": [{"type": "Array", "items": [
+            _call("valueNegate", _lit(x)), _call("valuePositive", _lit(x)),
+        ]}]}
+        for x in operands
+    ] + [
+        attempt(_call("valueNegate", _lit("x"))),
+        attempt(_call("valuePositive", {"type": "Array", "items": []})),
+        # Python would give a big int; Go cannot represent it
+        attempt(_call("valueNegate", _bin("-", _lit(-9223372036854775807), _lit(1)))),
+    ])
+    out = _run_go(doc)
+    assert out.splitlines() == [repr([-x, +x]) for x in operands] + [
+        "TypeError runtime error: bad operand type for unary -: 'str'",
+        "TypeError runtime error: bad operand type for unary +: 'array'",
+        "OverflowError runtime error: integer overflow in negation",
+    ], out
+
+
+def test_run_copies():
+    if not _has_go():
+        return
+    xs, d = _var("xs"), _var("d")
+
+    def idx(base, i):
+        return {"type": "Index", "base": base, "index": _lit(i)}
+
+    doc = _prog([
+        # Like copy.copy and copy.deepcopy: a shallow copy shares nested containers
+        {"type": "Let", "name": "xs", "value": {"type": "Array", "items": [
+            {"type": "Array", "items": [_lit(1)]}, _lit(2)]}},
+        {"type": "Let", "name": "s", "value": _call("valueCopy", xs)},
+        {"type": "Let", "name": "d", "value": _call("valueDeepCopy", xs)},
+        {"type": "Push", "base": idx(xs, 0), "value": _lit(9)},
+        {"type": "Push", "base": xs, "value": _lit(3)},
+        {"type": "Print", "args": [xs, _var("s"), d]},
+        {"type": "Let", "name": "m", "value": {"type": "Map", "items": [
+            {"key": _lit("tags"), "value": {"type": "Set", "items": [_lit("a")]}}]}},
+        {"type": "Let", "name": "ms", "value": _call("valueCopy", _var("m"))},
+        {"type": "Let", "name": "md", "value": _call("valueDeepCopy", _var("m"))},
+        {"type": "SetAdd", "base": {"type": "Get", "base": _var("m"), "key": _lit("tags")}, "value": _lit("b")},
+        {"type": "Set", "base": _var("m"), "key": _lit("n"), "value": _lit(1)},
+        {"type": "Print", "args": [_var("m"), _var("ms"), _var("md")]},
+        {"type": "Let", "name": "r", "value": {"type": "Record", "fields": [
+            {"name": "items", "value": {"type": "Array", "items": []}}]}},
+        {"type": "Let", "name": "rd", "value": _call("valueDeepCopy", _var("r"))},
+        {"type": "Push", "base": {"type": "GetField", "base": _var("r"), "name": "items"}, "value": _lit(1)},
+        {"type": "Print", "args": [_var("r"), _var("rd")]},
+        # Shared references stay shared in a deep copy, and cycles terminate
+        {"type": "Let", "name": "a", "value": {"type": "Array", "items": [_lit(1)]}},
+        {"type": "Assign", "name": "d", "value": _call("valueDeepCopy", {"type": "Array", "items": [_var("a"), _var("a")]})},
+        {"type": "Push", "base": idx(d, 0), "value": _lit(5)},
+        {"type": "Print", "args": [d, _var("a")]},
+        {"type": "Let", "name": "loop", "value": {"type": "Array", "items": []}},
+        {"type": "Push", "base": _var("loop"), "value": _var("loop")},
+        {"type": "Assign", "name": "d", "value": _call("valueDeepCopy", _var("loop"))},
+        {"type": "Push", "base": d, "value": _lit(0)},
+        {"type": "Print", "args": [{"type": "Length", "base": idx(d, 0)}, {"type": "Length", "base": _var("loop")}]},
+        {"type": "Print", "args": [_call("valueCopy", _lit("text")), _call("valueDeepCopy", _lit(4))]},
+    ])
+    out = _run_go(doc)
+    assert out.splitlines() == [
+        "[[1, 9], 2, 3] [[1, 9], 2] [[1], 2]",
+        "{'tags': {'a', 'b'}, 'n': 1} {'tags': {'a', 'b'}} {'tags': {'a'}}",
+        "Record(items=[1]) Record(items=[])",
+        "[[1, 5], [1, 5]] [1]",
+        "2 1",
+        "text 4",
+    ], out
+
+
+_ENGINE_HOST = """package main
+
+import (
+\t"bytes"
+\t"strings"
+)
+
+// nestedCapture prints inside two nested captures and returns what each collected.
+func nestedCapture() Value {
+\te := DefaultEngine
+\te.StartCapture()
+\tcoreilPrint([]Value{ValueStr("outer")})
+\te.StartCapture()
+\tcoreilPrint([]Value{ValueStr("inner")})
+\tinner := e.StopCapture()
+\tcoreilPrint([]Value{ValueStr("after")})
+\touter := e.StopCapture()
+\treturn ValueArray([]Value{ValueStr(inner), ValueStr(outer)})
+}
+
+// redirected prints to a buffer through SetOutput, then restores the old writer.
+func redirected() Value {
+\te := DefaultEngine
+\tprev := e.Output()
+\tvar buf bytes.Buffer
+\te.SetOutput(&buf)
+\tcoreilPrint([]Value{ValueStr("hidden")})
+\te.Flush()
+\te.SetOutput(prev)
+\treturn ValueStr(buf.String())
+}
+
+// manyLines prints more than the output buffer holds before returning.
+func manyLines() Value {
+\tfor i := 0; i < 500; i++ {
+\t\tcoreilPrint([]Value{ValueStr(strings.Repeat("x", 20))})
+\t}
+\treturn ValueNone
+}
+
+func stopCapture() Value {
+\treturn ValueStr(DefaultEngine.StopCapture())
+}
+"""
+
+
+def test_run_engine_output():
+    if not _has_go():
+        return
+    doc = _prog([
+        {"type": "Print", "args": [_lit("start")]},
+        {"type": "Print", "args": [_call("nestedCapture")]},
+        {"type": "Print", "args": [_call("redirected")]},
+        {"type": "TryCatch", "body": [{"type": "Print", "args": [_call("stopCapture")]}], "catch_var": "err",
+         "catch_body": [{"type": "Print", "args": [_call("errorKind", _var("err")), _var("err")]}]},
+        _call("manyLines"),
+        {"type": "Print", "args": [_lit("before")]},
+        {"type": "Throw", "message": _lit("oops")},
+    ])
+    result = _exec_go(doc, host_code=_ENGINE_HOST)
+    # Output buffered before an uncaught error is still flushed, in order
+    assert result.returncode == 1, result.stderr
+    assert result.stdout.splitlines() == [
+        "start",
+        "['inner\\n', 'outer\\nafter\\n']",
+        "hidden",
+        "",
+        "RuntimeError runtime error: StopCapture without StartCapture",
+    ] + ["x" * 20] * 500 + ["before"], result.stdout
+    assert "oops" in result.stderr, result.stderr
+
+
+_PRINT_HOST = """package main
+
+func printWith(args, sep, end, stream Value) Value {
+\tcoreilPrintOpts(*asArray(args), sep, end, stream)
+\treturn ValueNone
+}
+"""
+
+
+def test_run_print_options():
+    if not _has_go():
+        return
+    cases = [
+        ([1, "a", 2.5, None], {"sep": ", "}),
+        (["x"], {"end": ""}),
+        (["y", True], {"sep": None, "end": None}),
+        (["a", "b"], {"sep": "", "end": "!\n"}),
+        ([], {}),
+        ([[1, "two"]], {"sep": "-"}),
+    ]
+
+    def print_with(args, sep=None, end=None, stream=None):
+        def value(a):
+            return {"type": "Array", "items": [value(x) for x in a]} if isinstance(a, list) else _lit(a)
+
+        items = value(args)
+        return _call("printWith", items, _lit(sep), _lit(end), _lit(stream))
+
+    def attempt(node):
+        return {"type": "TryCatch", "body": [node], "catch_var": "err",
+                "catch_body": [{"type": "Print", "args": [_call("errorKind", _var("err")), _var("err")]}]}
+
+    doc = _prog([print_with(args, **opts) for args, opts in cases] + [
+        print_with(["warning"], stream="stderr"),
+        print_with(["done"], stream="stdout"),
+        attempt(print_with(["a"], sep=1)),
+        attempt(print_with(["a"], stream="nowhere")),
+    ])
+    result = _exec_go(doc, host_code=_PRINT_HOST)
+    assert result.returncode == 0, result.stderr
+    expected = io.StringIO()
+    for args, opts in cases:
+        print(*args, **opts, file=expected)
+    assert result.stdout == expected.getvalue() + "done\n" + (
+        "TypeError runtime error: sep must be None or a string, not int\n"
+        "ValueError runtime error: unknown print target 'nowhere'\n"
+    ), result.stdout
+    assert result.stderr == "warning\n", result.stderr
+
+
+_LOG_HOST = """package main
+
+import (
+\t"log/slog"
+\t"os"
+)
+
+func init() {
+\topts := &slog.HandlerOptions{
+\t\tLevel: slog.LevelDebug,
+\t\tReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+\t\t\tif a.Key == slog.TimeKey {
+\t\t\t\treturn slog.Attr{}
+\t\t\t}
+\t\t\treturn a
+\t\t},
+\t}
+\tDefaultEngine.SetLogger(slog.New(slog.NewJSONHandler(os.Stderr, opts)))
+}
+"""
+
+
+def test_run_logging():
+    if not _has_go():
+        return
+    fields = {"type": "Map", "items": [
+        {"key": _lit("count"), "value": _lit(3)},
+        {"key": _lit("ratio"), "value": _lit(0.5)},
+        {"key": _lit("ok"), "value": _lit(True)},
+        {"key": _lit("name"), "value": _lit("ada")},
+        {"key": _lit("tags"), "value": {"type": "Array", "items": [_lit(1), _lit(2)]}},
+    ]}
+    doc = _prog([
+        _call("logDebug", _lit("starting"), _lit(None)),
+        {"type": "Print", "args": [_lit("working")]},
+        _call("logInfo", _lit("loaded"), fields),
+        _call("logWarn", _lit(42), _lit(None)),
+        _call("logError", _lit("failed"), {"type": "Map", "items": [{"key": _lit(1), "value": _lit("x")}]}),
+        {"type": "TryCatch", "body": [_call("logInfo", _lit("bad"), _lit("fields"))], "catch_var": "err",
+         "catch_body": [{"type": "Print", "args": [_call("errorKind", _var("err")), _var("err")]}]},
+    ])
+    # Records go to the host's logger, typed and separate from program output
+    result = _exec_go(doc, host_code=_LOG_HOST)
+    assert result.returncode == 0, result.stderr
+    assert result.stdout.splitlines() == [
+        "working",
+        "TypeError runtime error: expected map, got str",
+    ], result.stdout
+    assert [json.loads(line) for line in result.stderr.splitlines()] == [
+        {"level": "DEBUG", "msg": "starting"},
+        {"level": "INFO", "msg": "loaded", "count": 3, "ratio": 0.5, "ok": True, "name": "ada", "tags": "[1, 2]"},
+        {"level": "WARN", "msg": "42"},
+        {"level": "ERROR", "msg": "failed", "1": "x"},
+    ], result.stderr
+    # The default logger writes text to stderr at info level
+    result = _exec_go(doc)
+    assert result.returncode == 0, result.stderr
+    lines = result.stderr.splitlines()
+    assert len(lines) == 3, result.stderr
+    assert "level=INFO msg=loaded count=3 ratio=0.5 ok=true name=ada" in lines[0], lines
+    assert "level=WARN msg=42" in lines[1], lines
+
+
+_ASSERT_HOST = """package main
+
+func at(il, sentence Value) Value {
+\tcoreilAt(asString(il), asString(sentence))
+\treturn ValueNone
+}
+"""
+
+
+def test_run_assertions():
+    if not _has_go():
+        return
+    total = {"type": "Array", "items": [_lit(1), _lit("two")]}
+
+    def attempt(*body):
+        return {"type": "TryCatch", "body": list(body), "catch_var": "err",
+                "catch_body": [{"type": "Print", "args": [_call("errorKind", _var("err")), _var("err")]}]}
+
+    doc = _prog([
+        # Passing assertions do nothing; True == 1 and equal containers pass
+        _call("coreilAssert", _lit(True), _lit("unused"), _lit(None), _lit(None)),
+        _call("coreilAssertEqual", _lit(1), _lit(True), _lit(None)),
+        _call("coreilAssertEqual", total, total, _lit(None)),
+        {"type": "Print", "args": [_lit("passed")]},
+        attempt(_call("coreilAssert", _lit(0), _lit(None), _lit(None), _lit(None))),
+        attempt(_call("coreilAssert", _lit(""), _lit("empty name"), _lit(""), _lit("ada"))),
+        attempt(_call("coreilAssertEqual", total, {"type": "Array", "items": [_lit(1), _lit(2)]}, _lit("totals"))),
+        attempt(
+            _call("at", _lit("$.body[7].body[1]"), _lit("Check that the count is 3.")),
+            _call("coreilAssertEqual", _lit(2), _lit(3), _lit(None)),
+        ),
+    ])
+    out = _run_go(doc, host_code=_ASSERT_HOST)
+    assert out.splitlines() == [
+        "passed",
+        "AssertionError AssertionError",
+        "  at $.body[4]",
+        "AssertionError AssertionError: empty name",
+        "  actual:   ''",
+        "  expected: 'ada'",
+        "  at $.body[5]",
+        "AssertionError AssertionError: totals",
+        "  actual:   [1, 'two']",
+        "  expected: [1, 2]",
+        "  at $.body[6]",
+        "AssertionError AssertionError",
+        "  actual:   2",
+        "  expected: 3",
+        "  at $.body[7].body[1] ('Check that the count is 3.')",
+    ], out
+
+
+_INTERN_HOST = """package main
+
+import (
+\t"testing"
+\t"unsafe"
+)
+
+// sharesStorage reports whether two strings point at the same bytes.
+func sharesStorage(a, b Value) Value {
+\treturn ValueBool(unsafe.StringData(asString(a)) == unsafe.StringData(asString(b)))
+}
+
+// charAllocs counts allocations made reading every character of s.
+func charAllocs(s Value) Value {
+\tn := stringLength(s).intData()
+\tallocs := testing.AllocsPerRun(100, func() {
+\t\tfor i := int64(0); i < n; i++ {
+\t\t\tstringCharAt(s, ValueInt(i))
+\t\t}
+\t})
+\treturn ValueInt(int64(allocs))
+}
+"""
+
+
+def test_run_string_interning():
+    if not _has_go():
+        return
+    ascii_text = "the quick brown fox jumps over the lazy dog"
+    # Longer than 32 bytes, so their rune decodings are cached; alternating
+    # between them checks the cache is keyed by string
+    accents = "café déjà vu — naïve façade, señor! " * 2
+    greek = "αβγδε ζηθικ λμνξο πρστυ φχψω " * 2
+    texts = [ascii_text, accents, greek, "", "ü"]
+    reads = []
+    for text in [ascii_text, accents, greek, accents]:
+        for start, end in [(0, 1), (3, 9), (len(text) - 4, len(text)), (5, 2), (0, len(text) + 5)]:
+            reads.append((text, start, end))
+
+    def char_at(text, i):
+        return {"type": "CharAt", "base": _lit(text), "index": _lit(i)}
+
+    doc = _prog([
+        {"type": "Print", "args": [char_at(text, i) for i in range(0, len(text), 7)]} for text in texts if text
+    ] + [
+        {"type": "Print", "args": [{"type": "Substring", "base": _lit(text), "start": _lit(start), "end": _lit(end)}]}
+        for text, start, end in reads
+    ] + [
+        {"type": "Print", "args": [
+            _call("sharesStorage", char_at("abc", 1), char_at("xbz", 1)),
+            _call("sharesStorage", {"type": "Substring", "base": _lit("abc"), "start": _lit(2), "end": _lit(2)},
+                  _lit("")),
+            _call("charAllocs", _lit(ascii_text)),
+        ]},
+    ])
+    out = _run_go(doc, host_code=_INTERN_HOST)
+    assert out.splitlines() == [
+        " ".join(text[i] for i in range(0, len(text), 7)) for text in texts if text
+    ] + [text[start:end] for text, start, end in reads] + [
+        "True True 0",
+    ], out
+
+
+_SLICE_HOST = """package main
+
+// sharesItems reports whether two arrays currently use the same backing items.
+func sharesItems(a, b Value) Value {
+\tx, y := *asArray(a), *asArray(b)
+\treturn ValueBool(cap(x) > 0 && cap(y) > 0 && &x[:cap(x)][cap(x)-1] == &y[:cap(y)][cap(y)-1])
+}
+
+func numbers(n Value) Value {
+\titems := make([]Value, asInt(n))
+\tfor i := range items {
+\t\titems[i] = ValueInt(int64(i))
+\t}
+\treturn ValueArray(items)
+}
+"""
+
+
+def test_run_array_slices():
+    if not _has_go():
+        return
+    xs, ys, zs = _var("xs"), _var("ys"), _var("zs")
+
+    def slice_of(base, start, end):
+        return {"type": "Slice", "base": base, "start": _lit(start), "end": _lit(end)}
+
+    def set_index(base, i, v):
+        return {"type": "SetIndex", "base": base, "index": _lit(i), "value": _lit(v)}
+
+    big = _call("numbers", _lit(2000))
+    doc = _prog([
+        # Writes and pushes on either side never show through, as with Python's list slices
+        {"type": "Let", "name": "xs", "value": {"type": "Array", "items": [_lit(i) for i in range(8)]}},
+        {"type": "Let", "name": "ys", "value": slice_of(xs, 2, 6)},
+        {"type": "Let", "name": "zs", "value": slice_of(xs, -3, 8)},
+        set_index(ys, 0, 20),
+        set_index(xs, 3, 30),
+        {"type": "Push", "base": ys, "value": _lit(60)},
+        {"type": "Push", "base": xs, "value": _lit(80)},
+        set_index(zs, -1, 70),
+        {"type": "Print", "args": [xs, ys, zs]},
+        {"type": "Let", "name": "ws", "value": slice_of(ys, 1, 3)},
+        set_index(ys, 1, 31),
+        {"type": "Print", "args": [ys, _var("ws"), slice_of(xs, 5, 2), slice_of(xs, 0, 0)]},
+        # Large slices share until written; small windows of large arrays are copied
+        {"type": "Let", "name": "big", "value": big},
+        {"type": "Let", "name": "rest", "value": slice_of(_var("big"), 1, 2000)},
+        {"type": "Let", "name": "window", "value": slice_of(_var("big"), 1990, 2000)},
+        {"type": "Print", "args": [_call("sharesItems", _var("big"), _var("rest")),
+                                   _call("sharesItems", _var("big"), _var("window"))]},
+        set_index(_var("rest"), -1, -1),
+        {"type": "Print", "args": [_call("sharesItems", _var("big"), _var("rest")),
+                                   {"type": "Index", "base": _var("big"), "index": _lit(-1)},
+                                   {"type": "Index", "base": _var("rest"), "index": _lit(-1)}]},
+    ])
+    out = _run_go(doc, host_code=_SLICE_HOST)
+    xs_ = list(range(8))
+    ys_, zs_ = xs_[2:6], xs_[-3:8]
+    ys_[0], xs_[3] = 20, 30
+    ys_.append(60)
+    xs_.append(80)
+    zs_[-1] = 70
+    ws_ = ys_[1:3]
+    lines = [f"{xs_} {ys_} {zs_}"]
+    ys_[1] = 31
+    lines.append(f"{ys_} {ws_} {xs_[5:2]} {xs_[0:0]}")
+    assert out.splitlines() == lines + ["True False", "False 1999 -1"], out
+
+
+def test_run_match_value():
+    if not _has_go():
+        return
+
+    def tup(*items):
+        return {"type": "Tuple", "items": list(items)}
+
+    def mapping(**fields):
+        return {"type": "Map", "items": [{"key": _lit(k), "value": v} for k, v in fields.items()]}
+
+    def array(*items):
+        return {"type": "Array", "items": list(items)}
+
+    cases = array(
+        tup(mapping(type=_lit("order"), items=array(_call("patBind", _lit("first")), _call("patRest", _lit("rest"))))),
+        tup(mapping(type=_lit("ping"))),
+        tup(_call("patType", _lit("int"), _call("patBind", _lit("n"))), _var("positive")),
+        tup(_call("patOr", _lit("yes"), _lit("y"))),
+        tup(_call("patBind", _lit("other"))),
+    )
+    subjects = array(
+        mapping(type=_lit("order"), items=array(_lit(1), _lit(2), _lit(3))),
+        mapping(type=_lit("ping"), sent=_lit(12)),
+        _lit(42),
+        _lit(-5),
+        _lit("y"),
+    )
+    doc = _prog([
+        # A guard: the bound n must be greater than zero
+        _func("positive", ["bound"], _ret(_bin(">", {"type": "Get", "base": _var("bound"), "key": _lit("n")}, _lit(0)))),
+        {"type": "Let", "name": "cases", "value": cases},
+        {"type": "For", "var": "msg", "iter": subjects, "body": [
+            {"type": "Print", "args": [_call("matchValue", _var("msg"), _var("cases"))]},
+        ]},
+        {"type": "Print", "args": [_call("matchValue", _lit(3.5), array(tup(_call("patType", _lit("str")))))]},
+    ])
+    out = _run_go(doc)
+    assert out.splitlines() == [
+        "(0, {'first': 1, 'rest': [2, 3]})",
+        "(1, {})",
+        "(2, {'n': 42})",
+        "(4, {'other': -5})",
+        "(3, {})",
+        "(-1, None)",
+    ], out
+
+
+def test_run_tables():
+    if not _has_go():
+        return
+
+    def mapping(**fields):
+        return {"type": "Map", "items": [{"key": _lit(k), "value": v} for k, v in fields.items()]}
+
+    def array(*items):
+        return {"type": "Array", "items": [_lit(item) for item in items]}
+
+    sales = "region,rep,sales\nNorth,ann,10\nSouth,bob,5\nNorth,cy,7.5\nEast,dee,\n"
+    amount = {"type": "Get", "base": _var("row"), "key": _lit("sales")}
+    doc = _prog([
+        # A filterRows predicate: the row's sales are above 6
+        _func("large", ["row"], _ret(_bin("and", _bin("!=", amount, _lit(None)), _bin(">", amount, _lit(6))))),
+        {"type": "Let", "name": "t", "value": _call("tableFromCSV", _lit(sales))},
+        {"type": "Print", "args": [_var("t")]},
+        {"type": "Print", "args": [_call("aggregate", _call("groupBy", _var("t"), _lit("region")), mapping(
+            total=array("sum", "sales"), reps=array("count"), best=array("max", "sales"),
+        ))]},
+        {"type": "Print", "args": [_call("tableToCSV", _call(
+            "selectColumns", _call("sortBy", _var("t"), _lit("sales"), _lit(True)), array("rep", "sales"),
+        ))]},
+        {"type": "Print", "args": [_call("tableToJSON", _call("filterRows", _var("t"), _var("large")))]},
+        {"type": "Let", "name": "managers", "value": _call("tableNew", mapping(
+            region=array("North", "South"), manager=array("max", "sue"),
+        ))},
+        {"type": "Print", "args": [_call("joinTables", _var("t"), _var("managers"), _lit("region"), _lit("left"))]},
+        {"type": "ForEach", "var": "row", "iter": _var("managers"), "body": [
+            {"type": "Print", "args": [_var("row")]},
+        ]},
+        {"type": "Print", "args": [
+            _call("tableSize", _call("joinTables", _var("t"), _var("managers"), _lit("region"))),
+            _call("tableColumn", _call("tableFromJSON", _lit('[{"a": 1}, {"a": 2.5, "b": true}]')), _lit("b")),
+        ]},
+        {"type": "TryCatch",
+         "body": [{"type": "Print", "args": [_call("sortBy", _var("t"), _lit("price"))]}],
+         "catch_var": "err",
+         "catch_body": [{"type": "Print", "args": [_call("errorKind", _var("err")), _var("err")]}]},
+    ])
+    out = _run_go(doc)
+    assert out.splitlines() == [
+        "region  rep  sales",
+        "North   ann   10.0",
+        "South   bob    5.0",
+        "North   cy     7.5",
+        "East    dee   None",
+        "region  total  reps  best",
+        "North    17.5     2  10.0",
+        "South     5.0     1   5.0",
+        "East      0.0     1  None",
+        "rep,sales",
+        "ann,10.0",
+        "cy,7.5",
+        "bob,5.0",
+        "dee,",
+        "",
+        '[{"region": "North", "rep": "ann", "sales": 10}, {"region": "North", "rep": "cy", "sales": 7.5}]',
+        "region  rep  sales  manager",
+        "North   ann   10.0  max",
+        "South   bob    5.0  sue",
+        "North   cy     7.5  max",
+        "East    dee   None  None",
+        "{'region': 'North', 'manager': 'max'}",
+        "{'region': 'South', 'manager': 'sue'}",
+        "3 [None, True]",
+        "KeyError runtime error: table has no column 'price'",
+    ], out
+
+
+def test_run_arrow():
+    if not _has_go():
+        return
+    sales = "region,sales\nNorth,10\nSouth,\nEast,7\n"
+    with tempfile.TemporaryDirectory() as tmpdir:
+        path = Path(tmpdir) / "sales.arrow"
+        doc = _prog([
+            {"type": "Let", "name": "t", "value": _call("tableFromCSV", _lit(sales))},
+            {"type": "Let", "name": "data", "value": _call("tableToArrow", _var("t"))},
+            {"type": "Print", "args": [_ext("fs", "writeFile", _lit(str(path)), _var("data"))]},
+            {"type": "Print", "args": [_bin("==", _call("tableFromArrow", _var("data")), _var("t"))]},
+            {"type": "Print", "args": [_call("arrayFromArrow", _call("arrayToArrow", {"type": "Array", "items": [
+                _lit("a"), _lit(None), _lit(True),
+            ]}))]},
+        ])
+        out = _exec_go(doc, grants=["io.write"])
+        assert out.stdout.splitlines()[:2] == ["None", "True"], out.stdout
+        assert "column 'values' mixes str with other types" in out.stderr, out.stderr
+
+        # Framing: continuation marker, 8-byte aligned metadata, body, end
+        data = path.read_bytes()
+        messages, pos = [], 0
+        while True:
+            marker, size = struct.unpack_from("<Ii", data, pos)
+            assert marker == 0xFFFFFFFF and size % 8 == 0
+            if size == 0:
+                break
+            metadata = data[pos + 8:pos + 8 + size]
+            (body_length,) = struct.unpack_from("<q", metadata, _fb_field(metadata, 3))
+            messages.append((data[pos + 4:pos + 8], metadata, data[pos + 8 + size:pos + 8 + size + body_length]))
+            pos += 8 + size + body_length
+        assert pos + 8 == len(data) and len(messages) == 2
+        body = messages[1][2]
+        assert struct.pack("<qxxxxxxxxq", 10, 7) in body  # the int64 sales, with the null slot zeroed
+
+        # An Arrow file, and the stream framing from before Arrow 0.15, read the same
+        (Path(tmpdir) / "sales.file.arrow").write_bytes(b"ARROW1\0\0" + data + b"footer")
+        (Path(tmpdir) / "sales.legacy.arrow").write_bytes(b"".join(
+            size + metadata + body for size, metadata, body in messages
+        ) + b"\0\0\0\0")
+        doc = _prog([
+            {"type": "Print", "args": [_call("tableFromArrow", _ext("fs", "readFile", _lit(str(variant))))]}
+            for variant in (Path(tmpdir) / "sales.file.arrow", Path(tmpdir) / "sales.legacy.arrow")
+        ])
+        out = _run_go(doc, grants=["io.read"])
+    assert out.splitlines() == [
+        "region  sales",
+        "North      10",
+        "South    None",
+        "East        7",
+    ] * 2, out
+
+
+def _fb_field(buf: bytes, index: int) -> int:
+    """The position of field index of a FlatBuffer's root table."""
+    (table,) = struct.unpack_from("<I", buf, 0)
+    (vtable_offset,) = struct.unpack_from("<i", buf, table)
+    (offset,) = struct.unpack_from("<H", buf, table - vtable_offset + 4 + 2 * index)
+    return table + offset
+
+
+def test_run_html_xml():
+    if not _has_go():
+        return
+    page = (
+        "<!DOCTYPE html><html><head><title>Links &amp; more</title>"
+        "<script>if (a < b) { x = '</p>'; }</script></head>"
+        "<body><div id=main><p class='intro lead'>Hello<br>world"
+        "<ul><li><a href='/docs'>Docs</a><li><a href=\"https://example.com/\" class=external>Ex</a></ul>"
+        "<!-- <a href='/hidden'>no</a> --><p>Bye</div></body></html>"
+    )
+    feed = (
+        "<?xml version='1.0'?><feed xmlns:m='urn:m'><entry id='1'><title>One</title></entry>"
+        "<entry id='2'><m:title>Two</m:title></entry></feed>"
+    )
+
+    def each(name, items, body):
+        return {"type": "ForEach", "var": name, "iter": items, "body": body}
+
+    doc = _prog([
+        {"type": "Let", "name": "page", "value": _call("htmlParse", _lit(page))},
+        each("a", _call("querySelectorAll", _var("page"), _lit("a[href]")), [
+            {"type": "Print", "args": [_call("nodeAttr", _var("a"), _lit("href")), _call("nodeText", _var("a"))]},
+        ]),
+        {"type": "Print", "args": [_call("nodeText", _call("querySelector", _var("page"), _lit("title")))]},
+        {"type": "Print", "args": [_call("nodeText", _call("querySelector", _var("page"), _lit("div#main > p.lead")))]},
+        {"type": "Print", "args": [{"type": "Length", "base": _call("querySelectorAll", _var("page"), _lit("div > p, ul li"))}]},
+        {"type": "Print", "args": [_call("querySelector", _var("page"), _lit("a[href^='https']"))]},
+        {"type": "Print", "args": [_call("querySelector", _var("page"), _lit("table"))]},
+        {"type": "Print", "args": [_call("xpath", _var("page"), _lit("//li[2]/a/@href"))]},
+        {"type": "Print", "args": [_call("nodeTag", _call("nodeParent", _call("querySelector", _var("page"), _lit("li"))))]},
+        {"type": "Let", "name": "feed", "value": _call("xmlParse", _lit(feed))},
+        {"type": "Print", "args": [_call("xpath", _var("feed"), _lit("/feed/entry/title/text()"))]},
+        {"type": "Print", "args": [_call("xpath", _var("feed"), _lit("//entry[@id='2']/*"))]},
+        {"type": "Print", "args": [_call("nodeAttrs", {"type": "Index", "base": _call("nodeChildren", _call("querySelector", _var("feed"), _lit("feed"))), "index": _lit(0)})]},
+        {"type": "Print", "args": [_call("xmlParse", _lit("<a><b></a>"))]},
+    ])
+    out = _exec_go(doc)
+    assert out.stdout.splitlines() == [
+        "/docs Docs",
+        "https://example.com/ Ex",
+        "Links & more",
+        "Helloworld",
+        "4",
+        '<a href="https://example.com/" class="external">Ex</a>',
+        "None",
+        "['https://example.com/']",
+        "ul",
+        "['One', 'Two']",
+        "[<title>Two</title>]",
+        "{'id': '1'}",
+    ], out.stdout
+    assert "invalid XML" in out.stderr, out.stderr
+
+
+def test_run_urls():
+    if not _has_go():
+        return
+
+    def field(base, name):
+        return {"type": "GetField", "base": base, "name": name}
+
+    doc = _prog([
+        {"type": "Let", "name": "u", "value": _call("urlParse", _lit(
+            "https://ann@example.com:8443/search/a%20b?q=go+lang&tag=x&tag=y&empty=#top"
+        ))},
+        {"type": "Print", "args": [_var("u")]},
+        {"type": "Print", "args": [field(_var("u"), "path"),
+                                   {"type": "Get", "base": field(_var("u"), "query"), "key": _lit("q")}]},
+        {"type": "Print", "args": [_call("urlBuild", _var("u"))]},
+        {"type": "Print", "args": [_call("urlBuild", {"type": "Map", "items": [
+            {"key": _lit("scheme"), "value": _lit("http")},
+            {"key": _lit("host"), "value": _lit("::1")},
+            {"key": _lit("path"), "value": _lit("api/items")},
+            {"key": _lit("query"), "value": {"type": "Map", "items": [
+                {"key": _lit("page"), "value": _lit(2)},
+                {"key": _lit("q"), "value": _lit("a&b = c")},
+                {"key": _lit("skip"), "value": _lit(None)},
+            ]}},
+        ]})]},
+        {"type": "Print", "args": [_call("queryDecode", _lit("?a=1&b=%C3%A9&a=2&flag"))]},
+        {"type": "Print", "args": [_call("urlParse", _lit("/local/path"))]},
+        {"type": "Print", "args": [_call("queryDecode", _lit("bad=%zz"))]},
+    ])
+    out = _exec_go(doc)
+    assert out.stdout.splitlines() == [
+        "Record(scheme='https', user='ann', host='example.com', port=8443, path='/search/a b', "
+        "query={'q': 'go lang', 'tag': ['x', 'y'], 'empty': ''}, fragment='top')",
+        "/search/a b go lang",
+        "https://ann@example.com:8443/search/a%20b?q=go+lang&tag=x&tag=y&empty=#top",
+        "http://[::1]/api/items?page=2&q=a%26b+%3D+c",
+        "{'a': ['1', '2'], 'b': 'é', 'flag': ''}",
+        "Record(scheme='', user=None, host='', port=None, path='/local/path', query={}, fragment='')",
+    ], out.stdout
+    assert "invalid query string" in out.stderr, out.stderr
+
+
+def test_run_compression():
+    if not _has_go():
+        return
+    log = "GET /index.html 200\n" * 200
+    with tempfile.TemporaryDirectory() as tmpdir:
+        tmp = Path(tmpdir)
+        (tmp / "py.gz").write_bytes(gzip.compress(log.encode()))
+        with zipfile.ZipFile(tmp / "py.zip", "w") as zf:
+            zf.writestr("logs/", "")
+            zf.writestr("logs/a.log", log)
+            zf.writestr("notes.txt", "hi")
 
+        def read(name):
+            return _ext("fs", "readFile", _lit(str(tmp / name)))
 
-def _lit(v) -> dict:
-    return {"type": "Literal", "value": v}
+        doc = _prog([
+            {"type": "Let", "name": "log", "value": _lit(log)},
+            {"type": "Let", "name": "gz", "value": _call("gzipCompress", _var("log"))},
+            {"type": "Print", "args": [
+                _bin("!=", _var("gz"), _var("log")),
+                _bin("==", _call("gzipDecompress", _var("gz")), _var("log")),
+                _bin("==", _call("zlibDecompress", _call("zlibCompress", _var("log"))), _var("log")),
+                _bin("==", _call("gzipDecompress", read("py.gz")), _var("log")),
+            ]},
+            {"type": "Let", "name": "archive", "value": read("py.zip")},
+            {"type": "ForEach", "var": "entry", "iter": _call("zipList", _var("archive")), "body": [
+                {"type": "Print", "args": [
+                    {"type": "GetField", "base": _var("entry"), "name": "name"},
+                    {"type": "GetField", "base": _var("entry"), "name": "size"},
+                    {"type": "GetField", "base": _var("entry"), "name": "dir"},
+                ]},
+            ]},
+            {"type": "Print", "args": [_call("zipRead", _var("archive"), _lit("notes.txt"))]},
+            {"type": "Print", "args": [_call("zipRead", _call("zipWrite", {"type": "Map", "items": [
+                {"key": _lit("b.txt"), "value": _lit("bee")},
+                {"key": _lit("a.txt"), "value": _lit("ay")},
+            ]}))]},
+            {"type": "Print", "args": [_ext("fs", "writeFile", _lit(str(tmp / "go.zip")), _call("zipWrite", {"type": "Map", "items": [
+                {"key": _lit("out/"), "value": _lit("")},
+                {"key": _lit("out/log.txt"), "value": _var("log")},
+            ]}))]},
+            {"type": "Print", "args": [_call("zipRead", _var("archive"), _lit("missing.txt"))]},
+        ])
+        out = _exec_go(doc, grants=["io.read", "io.write"])
+        with zipfile.ZipFile(tmp / "go.zip") as zf:
+            assert zf.namelist() == ["out/", "out/log.txt"]
+            assert zf.read("out/log.txt").decode() == log
+            assert zf.getinfo("out/log.txt").compress_type == zipfile.ZIP_DEFLATED
+    assert out.stdout.splitlines() == [
+        "True True True True",
+        "logs/ 0 True",
+        "logs/a.log 4000 False",
+        "notes.txt 2 False",
+        "hi",
+        "{'b.txt': 'bee', 'a.txt': 'ay'}",
+        "None",
+    ], out.stdout
+    assert "no entry 'missing.txt' in zip archive" in out.stderr, out.stderr
 
 
-def _var(n: str) -> dict:
-    return {"type": "Var", "name": n}
+class _FakeSMTP(threading.Thread):
+    """An SMTP server on localhost that accepts one message."""
 
+    def __init__(self):
+        super().__init__(daemon=True)
+        self.server = socket.create_server(("127.0.0.1", 0))
+        self.addr = "127.0.0.1:%d" % self.server.getsockname()[1]
+        self.commands: list[str] = []
+        self.data = ""
 
-def _bin(op: str, l: dict, r: dict) -> dict:
-    return {"type": "Binary", "op": op, "left": l, "right": r}
+    def run(self):
+        conn, _ = self.server.accept()
+        with conn, conn.makefile("rb") as f:
+            conn.sendall(b"220 fake ESMTP\r\n")
+            for line in f:
+                command = line.decode().rstrip("\r\n")
+                self.commands.append(command)
+                verb = command.split(" ")[0].upper()
+                if verb == "DATA":
+                    conn.sendall(b"354 go ahead\r\n")
+                    lines = []
+                    for data_line in f:
+                        if data_line == b".\r\n":
+                            break
+                        lines.append(data_line.decode())
+                    self.data = "".join(lines)
+                    conn.sendall(b"250 queued\r\n")
+                elif verb == "QUIT":
+                    conn.sendall(b"221 bye\r\n")
+                    return
+                else:
+                    conn.sendall(b"250 ok\r\n")
 
 
-def _prog(body: list[dict]) -> dict:
-    return {"version": "coreil-1.9", "body": body}
+def test_run_notifications():
+    if not _has_go():
+        return
+    posts = []
 
+    class Hook(BaseHTTPRequestHandler):
+        def do_POST(self):
+            body = self.rfile.read(int(self.headers["Content-Length"]))
+            posts.append((self.path, self.headers["Content-Type"], json.loads(body)))
+            self.send_response(204 if self.path == "/ok" else 500)
+            self.end_headers()
 
-# --- Codegen-only tests (no Go compiler needed) ---
+        def log_message(self, *args):
+            pass
 
-def test_codegen_hello():
-    """Basic codegen produces valid Go code."""
-    doc = _prog([{"type": "Print", "args": [_lit("hello")]}])
-    code, _ = emit_go(doc)
-    assert "package main" in code
-    assert "coreilPrint" in code
-    assert "hello" in code
+    smtp = _FakeSMTP()
+    smtp.start()
+    hooks = ThreadingHTTPServer(("127.0.0.1", 0), Hook)
+    threading.Thread(target=hooks.serve_forever, daemon=True).start()
+    base = "http://127.0.0.1:%d" % hooks.server_address[1]
+    doc = _prog([
+        {"type": "Print", "args": [_ext(
+            "email", "send",
+            {"type": "Array", "items": [_lit("ann@example.com"), _lit("Bob <bob@example.com>")]},
+            _lit("Daily summary: 3 new orders"),
+            _lit("Orders: 3\nRevenue: 42 \u20ac\n"),
+        )]},
+        {"type": "Print", "args": [_ext("notify", "webhook", _lit(base + "/ok"), _lit("backup finished"))]},
+        {"type": "Print", "args": [_ext("notify", "webhook", _lit(base + "/ok"), {"type": "Map", "items": [
+            {"key": _lit("event"), "value": _lit("backup")}, {"key": _lit("files"), "value": _lit(12)},
+        ]})]},
+        {"type": "Print", "args": [_ext("notify", "webhook", _lit(base + "/broken"), _lit("x"))]},
+    ])
+    try:
+        with tempfile.TemporaryDirectory() as tmpdir:
+            binary = _build_go(doc, Path(tmpdir), grants=["notify"])
+            out = subprocess.run(
+                [str(binary)], capture_output=True, text=True, timeout=30,
+                env={**os.environ, "COREIL_SMTP_ADDR": smtp.addr, "COREIL_SMTP_FROM": "Reports <reports@example.com>"},
+            )
+        smtp.join(10)
+    finally:
+        hooks.shutdown()
+    assert out.stdout.splitlines() == ["None", "204", "204"], out.stdout + out.stderr
+    assert "notify.webhook: " + base + "/broken returned 500 Internal Server Error" in out.stderr, out.stderr
+    assert "MAIL FROM:<reports@example.com>" in smtp.commands[1], smtp.commands
+    assert smtp.commands[2:4] == ["RCPT TO:<ann@example.com>", "RCPT TO:<bob@example.com>"], smtp.commands
+    message = email.message_from_string(smtp.data, policy=email.policy.default)
+    assert message["To"] == "ann@example.com, Bob <bob@example.com>", message["To"]
+    assert message["Subject"] == "Daily summary: 3 new orders"
+    assert message.get_content() == "Orders: 3\r\nRevenue: 42 \u20ac\r\n", repr(message.get_content())
+    assert posts == [
+        ("/ok", "application/json", {"text": "backup finished"}),
+        ("/ok", "application/json", {"event": "backup", "files": 12}),
+        ("/broken", "application/json", {"text": "x"}),
+    ], posts
 
+    # Without an SMTP server, or with the notify capability withheld, nothing is sent
+    out = _exec_go(_prog(doc["body"][:1]), grants=["notify"])
+    assert "email.send: no SMTP server configured" in out.stderr, out.stderr
+    out = _exec_go(_prog(doc["body"][1:2]), host_code=_SANDBOX_NET_HOST)
+    assert "sandbox denies notify for notify.webhook" in out.stderr, out.stderr
 
-def test_codegen_function():
-    """Function definition codegen."""
+
+_SANDBOX_NET_HOST = """package main
+
+func init() {
+\tDefaultEngine.SetSandbox(CapNet)
+}
+"""
+
+
+_REDACT_HOST = """package main
+
+func init() {
+\tDefaultEngine.Redact("hunter2")
+}
+"""
+
+
+def test_run_secrets():
+    if not _has_go():
+        return
+
+    class Echo(BaseHTTPRequestHandler):
+        def do_GET(self):
+            body = ("%s auth=%s" % (self.path, self.headers["Authorization"])).encode()
+            self.send_response(200)
+            self.end_headers()
+            self.wfile.write(body)
+
+        def log_message(self, *args):
+            pass
+
+    server = ThreadingHTTPServer(("127.0.0.1", 0), Echo)
+    threading.Thread(target=server.serve_forever, daemon=True).start()
+    base = "http://127.0.0.1:%d" % server.server_address[1]
     doc = _prog([
-        {"type": "FuncDef", "name": "add", "params": ["a", "b"], "body": [
-            {"type": "Return", "value": _bin("+", _var("a"), _var("b"))},
-        ]},
-        {"type": "Print", "args": [{"type": "Call", "name": "add", "args": [_lit(2), _lit(3)]}]},
+        {"type": "Let", "name": "key", "value": _call("secretFromEnv", _lit("COREIL_TEST_API_KEY"))},
+        {"type": "Print", "args": [_var("key"), {"type": "Array", "items": [_var("key")]}]},
+        {"type": "Print", "args": [_ext("http", "getAuth", _lit(base + "/me"), _var("key"))]},
+        {"type": "Print", "args": [_ext("http", "get", _call("secret", _lit(base + "/hook/T0K3N")))]},
+        {"type": "Print", "args": [_bin("==", _call("secret", _lit("a")), _call("secret", _lit("a")))]},
+        {"type": "Print", "args": [_lit("password: hunter2")]},
+        _call("logInfo", _lit("calling api"), {"type": "Map", "items": [{"key": _lit("key"), "value": _var("key")}]}),
+        {"type": "Print", "args": [_ext("fs", "readFile", _var("key"))]},
     ])
-    code, _ = emit_go(doc)
-    assert "func add(" in code
-    assert "return" in code
+    try:
+        with tempfile.TemporaryDirectory() as tmpdir:
+            trace = Path(tmpdir) / "trace.jsonl"
+            binary = _build_go(doc, Path(tmpdir), host_code=_REDACT_HOST, grants=["env", "io.read", "net"])
+            out = subprocess.run(
+                [str(binary)], capture_output=True, text=True, timeout=30,
+                env={**os.environ, "COREIL_TEST_API_KEY": "s3cr3t-key", "COREIL_RECORD": str(trace)},
+            )
+            recorded = trace.read_text()
+    finally:
+        server.shutdown()
+    assert out.stdout.splitlines() == [
+        "**** [****]",
+        "/me auth=Bearer ****",
+        "/hook/T0K3N auth=None",
+        "False",
+        "password: ****",
+    ], out.stdout
+    assert "key=****" in out.stderr, out.stderr
+    assert "expected string, got secret" in out.stderr, out.stderr
+    assert '{"secret": "****"}' in recorded.replace('":"', '": "'), recorded
+    for text in (out.stdout, out.stderr, recorded):
+        assert "s3cr3t" not in text and "hunter2" not in text and base + "/hook" not in text, text
 
 
-def test_codegen_if_else():
-    """If/else codegen."""
+def test_run_deterministic():
+    if not _has_go():
+        return
     doc = _prog([
-        {"type": "If", "test": _lit(True),
-         "then": [{"type": "Print", "args": [_lit("yes")]}],
-         "else": [{"type": "Print", "args": [_lit("no")]}]},
+        {"type": "Print", "args": [_ext("random", "randint", _lit(1), _lit(1000000))]},
+        {"type": "Print", "args": [_ext("random", "random")]},
+        {"type": "Print", "args": [_ext("time", "time")]},
+        {"type": "TryCatch",
+         "body": [{"type": "Print", "args": [_ext("os", "getenv", _lit("HOME"))]}],
+         "catch_var": "err",
+         "catch_body": [{"type": "Print", "args": [_var("err")]}]},
     ])
-    code, _ = emit_go(doc)
-    assert "if isTruthy(" in code
-    assert "} else {" in code
+    code, _ = emit_go(doc, deterministic=True)
+    assert "DefaultEngine.SetDeterministic(&DeterministicConfig{})" in code
+    first = _run_go(doc, deterministic=True, grants=["env"])
+    assert first == _run_go(doc, deterministic=True, grants=["env"]), first
+    lines = first.splitlines()
+    assert lines[2] == "0.0", first
+    assert lines[3] == "runtime error: deterministic mode refuses os.getenv", first
 
 
-def test_codegen_while():
-    """While loop codegen."""
+def test_run_randint_ranges():
+    if not _has_go():
+        return
+    lo, hi = -(2**63), 2**63 - 1
+    # Spans of 2**64 and 2**64 - 1 overflow int64; each is drawn many times
+    ranges = [(lo, hi), (lo + 1, hi), (-1, hi), (hi - 1, hi), (lo, lo + 2), (5, 5)]
     doc = _prog([
-        {"type": "Let", "name": "i", "value": _lit(0)},
-        {"type": "While", "test": _bin("<", _var("i"), _lit(3)), "body": [
-            {"type": "Print", "args": [_var("i")]},
-            {"type": "Assign", "name": "i", "value": _bin("+", _var("i"), _lit(1))},
+        {"type": "Print", "args": [{"type": "Array", "items": [
+            _ext("random", "randint", _lit(a), _lit(b)) for _ in range(50)
+        ]}]}
+        for a, b in ranges
+    ] + [
+        {"type": "TryCatch",
+         "body": [{"type": "Print", "args": [_ext("random", "randint", _lit(hi), _lit(lo))]}],
+         "catch_var": "err",
+         "catch_body": [{"type": "Print", "args": [_call("errorKind", _var("err")), _var("err")]}]},
+    ])
+    out = _run_go(doc, deterministic=True)
+    lines = out.splitlines()
+    for (a, b), line in zip(ranges, lines):
+        values = json.loads(line)
+        assert all(a <= v <= b for v in values), (a, b, values)
+    # Wide ranges reach both halves of the int64 range
+    assert min(json.loads(lines[0])) < 0 < max(json.loads(lines[0])), lines[0]
+    assert min(json.loads(lines[1])) < 0 < max(json.loads(lines[1])), lines[1]
+    assert lines[len(ranges):] == [
+        f"ValueError runtime error: empty range for randint({hi}, {lo})",
+    ], out
+
+
+_SEEDED_IDS_HOST = """package main
+
+import "time"
+
+func init() {
+\tDefaultEngine.SetDeterministic(&DeterministicConfig{Seed: 7, Clock: time.UnixMilli(1700000000123), SeededIDs: true})
+}
+"""
+
+
+def test_run_ids():
+    if not _has_go():
+        return
+    body = [
+        {"type": "Print", "args": [_call("uuid4")]},
+        {"type": "Print", "args": [_call("uuid7")]},
+        {"type": "Print", "args": [_call("randomToken", _lit(16))]},
+        {"type": "Print", "args": [_call("randomToken", _lit(24), _lit("base64url"))]},
+    ]
+    doc = _prog(body + [{"type": "Print", "args": [_call("randomToken", _lit(0))]}])
+    uuid = r"[0-9a-f]{8}-[0-9a-f]{4}-%s[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}"
+    before = int(time.time() * 1000)
+    first = _exec_go(doc, deterministic=True)
+    lines = first.stdout.splitlines()
+    assert len(lines) == 4, first.stdout
+    assert re.fullmatch(uuid % "4", lines[0]), lines
+    assert re.fullmatch(uuid % "7", lines[1]), lines
+    assert before <= int(lines[1].replace("-", "")[:12], 16) <= int(time.time() * 1000), lines
+    assert re.fullmatch(r"[0-9a-f]{32}", lines[2]) and re.fullmatch(r"[A-Za-z0-9_-]{32}", lines[3]), lines
+    assert "randomToken size must be between 1 and 65536 bytes, got 0" in first.stderr, first.stderr
+    # Deterministic mode leaves identifiers random...
+    assert _exec_go(doc, deterministic=True).stdout.splitlines()[:3] != lines[:3]
+
+    # ...unless SeededIDs asks for them to repeat
+    seeded = _run_go(_prog(body), host_code=_SEEDED_IDS_HOST)
+    assert seeded == _run_go(_prog(body), host_code=_SEEDED_IDS_HOST), seeded
+    assert seeded.splitlines()[1].startswith("018bcfe5-687b-7"), seeded
+
+
+def test_run_strict():
+    if not _has_go():
+        return
+
+    def attempt(expr):
+        return {"type": "TryCatch",
+                "body": [{"type": "Print", "args": [expr]}],
+                "catch_var": "err",
+                "catch_body": [{"type": "Print", "args": [_var("err")]}]}
+
+    doc = _prog([
+        {"type": "Let", "name": "xs", "value": {"type": "Array", "items": [_lit(10), _lit(20), _lit(30)]}},
+        {"type": "Let", "name": "i", "value": _bin("/", _lit(8), _lit(3))},
+        attempt({"type": "Index", "base": _var("xs"), "index": _var("i")}),
+        attempt({"type": "Index", "base": _var("xs"), "index": _lit(True)}),
+        attempt({"type": "Index", "base": _var("xs"), "index": {"type": "ToInt", "value": _var("i")}}),
+    ])
+    code, _ = emit_go(doc, strict=True)
+    assert "DefaultEngine.SetStrict(true)" in code
+    assert "SetStrict" not in emit_go(doc)[0]
+    assert _run_go(doc).splitlines() == ["30", "20", "30"]
+    assert _run_go(doc, strict=True).splitlines() == [
+        "runtime error: expected int, got float 2.6666666666666665 (strict mode does not truncate floats)",
+        "runtime error: expected int, got bool (strict mode does not count bools as ints)",
+        "30",
+    ]
+
+
+def test_run_checked_arithmetic():
+    if not _has_go():
+        return
+
+    def attempt(expr):
+        return {"type": "TryCatch",
+                "body": [{"type": "Print", "args": [expr]}],
+                "catch_var": "err",
+                "catch_body": [{"type": "Print", "args": [_var("err")]}]}
+
+    boxed = {"type": "Get", "base": _var("m"), "key": _lit("big")}
+    doc = _prog([
+        {"type": "Let", "name": "big", "value": _lit(9223372036854775807)},
+        {"type": "Let", "name": "small", "value": _lit(-9223372036854775807)},
+        {"type": "Let", "name": "m", "value": {"type": "Map", "items": [{"key": _lit("big"), "value": _var("big")}]}},
+        attempt(_bin("+", _var("big"), _lit(1))),
+        attempt(_bin("-", _var("small"), _lit(2))),
+        attempt(_bin("*", _var("big"), _lit(2))),
+        attempt(_bin("+", boxed, _lit(1))),
+        attempt(_bin("*", boxed, _lit(-1))),
+    ])
+    code, _ = emit_go(doc, checked=True)
+    assert "DefaultEngine.SetCheckedArithmetic(true)" in code
+    assert "SetCheckedArithmetic" not in emit_go(doc)[0]
+    assert _run_go(doc).splitlines() == [
+        "-9223372036854775808",
+        "9223372036854775807",
+        "-2",
+        "-9223372036854775808",
+        "-9223372036854775807",
+    ]
+    assert _run_go(doc, checked=True).splitlines() == [
+        "runtime error: integer overflow: 9223372036854775807 + 1 does not fit in 64 bits",
+        "runtime error: integer overflow: -9223372036854775807 - 2 does not fit in 64 bits",
+        "runtime error: integer overflow: 9223372036854775807 * 2 does not fit in 64 bits",
+        "runtime error: integer overflow: 9223372036854775807 + 1 does not fit in 64 bits",
+        "-9223372036854775807",
+    ]
+
+
+def test_run_division():
+    if not _has_go():
+        return
+    body = [
+        {"type": "Let", "name": "a", "value": _lit(-7)},
+        {"type": "Let", "name": "f", "value": _lit(-7.5)},
+        {"type": "Print", "args": [_bin("%", _var("a"), _lit(2)), _call("intDiv", _var("a"), _lit(2))]},
+        {"type": "Print", "args": [_bin("%", _lit(7), _lit(-2)), _call("intDiv", _lit(7), _lit(-2))]},
+        {"type": "Print", "args": [_bin("%", _var("f"), _lit(2)), _call("intDiv", _var("f"), _lit(2))]},
+    ]
+    floor = _prog(body)
+    truncate = {**floor, "division": "truncate"}
+    assert "SetDivision" not in emit_go(floor)[0]
+    assert "DefaultEngine.SetDivision(DivisionTruncate)" in emit_go(truncate)[0]
+    assert "SetDivision" not in emit_go(truncate, division="floor")[0]
+    assert _run_go(floor).splitlines() == ["1 -4", "-1 -4", "0.5 -4.0"]
+    assert _run_go(truncate).splitlines() == ["-1 -3", "1 -3", "-1.5 -3.0"]
+    assert _run_go(floor, division="truncate") == _run_go(truncate)
+    assert _run_interp(floor) == _run_go(floor)
+    assert _run_interp(truncate) == _run_go(truncate)
+    try:
+        emit_go(floor, division="round")
+    except ValueError as exc:
+        assert "unknown division mode: 'round'" in str(exc)
+    else:
+        raise AssertionError("expected an unknown division mode error")
+
+
+def _math(op, arg, ndigits=None):
+    node = {"type": "Math", "op": op, "arg": arg}
+    if ndigits is not None:
+        node["ndigits"] = ndigits
+    return node
+
+
+def test_run_rounding():
+    if not _has_go():
+        return
+    doc = _prog([
+        {"type": "Print", "args": [_math("round", _lit(v)) for v in (2.5, -2.5, 3.5, 0.49999999999999994)]},
+        {"type": "Print", "args": [_math("trunc", _lit(-7.9)), _math("floor", _lit(-7.9)), _math("ceil", _lit(7.1)),
+                                   {"type": "ToInt", "value": _lit(-7.9)}]},
+        {"type": "Print", "args": [_math("round", _lit(2.675), _lit(2)), _math("round", _lit(1.5), _lit(0)),
+                                   _math("round", _lit(1250), _lit(-2)), _math("round", _lit(1350), _lit(-2))]},
+    ])
+    out = _run_go(doc)
+    assert out.splitlines() == ["2 -2 4 0", "-7 -8 8 -7", "2.67 2.0 1200 1400"], out
+    assert _run_interp(doc) == out
+    # Results outside int64 raise instead of wrapping around
+    for node in (_math("floor", _lit(1e21)), _math("round", _lit(-1e19)), {"type": "ToInt", "value": _lit(2.0**63)}):
+        out = _run_go(_prog([
+            {"type": "TryCatch",
+             "body": [{"type": "Print", "args": [node]}],
+             "catch_var": "err",
+             "catch_body": [{"type": "Print", "args": [_var("err")]}]},
+        ]))
+        assert out.startswith("runtime error: integer overflow:"), out
+        assert "does not fit in 64 bits" in out, out
+
+
+# Checks the inline representation from the host side: extremes round-trip
+# exactly and arithmetic on ints, floats and bools does not allocate
+_UNBOXED_HOST = """package main
+
+import (
+\t"fmt"
+\t"math"
+\t"testing"
+)
+
+func init() {
+\tfor _, n := range []int64{math.MinInt64, -1, 0, 1, math.MaxInt64} {
+\t\tif got := ValueInt(n).intData(); got != n {
+\t\t\tfmt.Println("int round trip", n, got)
+\t\t}
+\t}
+\tfor _, f := range []float64{math.Copysign(0, -1), math.SmallestNonzeroFloat64, math.MaxFloat64, math.Inf(-1)} {
+\t\tif got := ValueFloat(f).floatData(); math.Float64bits(got) != math.Float64bits(f) {
+\t\t\tfmt.Println("float round trip", f, got)
+\t\t}
+\t}
+\tif !math.IsNaN(ValueFloat(math.NaN()).floatData()) || !ValueBool(true).boolData() || ValueBool(false).boolData() {
+\t\tfmt.Println("NaN or bool round trip")
+\t}
+\tallocs := testing.AllocsPerRun(100, func() {
+\t\tsum, flag := ValueInt(0), ValueBool(false)
+\t\tfor i := int64(0); i < 100; i++ {
+\t\t\tsum = valueAdd(sum, valueMultiply(ValueInt(i), ValueFloat(0.5)))
+\t\t\tif valueLessThan(sum, ValueInt(i)) {
+\t\t\t\tflag = logicalNot(flag)
+\t\t\t}
+\t\t}
+\t})
+\tfmt.Println("allocs", allocs)
+}
+"""
+
+
+def test_run_unboxed_values():
+    if not _has_go():
+        return
+    doc = _prog([
+        {"type": "Let", "name": "big", "value": _lit(9223372036854775807)},
+        {"type": "Print", "args": [_var("big"), _bin("-", _bin("-", _lit(0), _var("big")), _lit(1)),
+                                   _bin("*", _lit(-0.0), _lit(1)), _lit(5e-324), _lit(1.7976931348623157e308)]},
+        {"type": "Print", "args": [_bin("==", _lit(1), _lit(1.0)), _bin("==", _lit(True), _lit(1)),
+                                   _bin("==", _lit(0.1), _lit(0.1)), _bin("<", _lit(-0.0), _lit(0))]},
+        # Equal ints and bools are the same map key, as in Python
+        {"type": "Let", "name": "m", "value": {"type": "Map", "items": []}},
+        {"type": "Set", "base": _var("m"), "key": _lit(1), "value": _lit("int")},
+        {"type": "Set", "base": _var("m"), "key": _lit(True), "value": _lit("bool")},
+        {"type": "Print", "args": [_var("m"), {"type": "Get", "base": _var("m"), "key": _lit(1)}]},
+    ])
+    out = _run_go(doc)
+    assert out.splitlines() == [
+        "9223372036854775807 -9223372036854775808 -0.0 5e-324 1.7976931348623157e+308",
+        "True True True False",
+        "{1: 'bool'} bool",
+    ], out
+    assert _run_interp(doc) == out
+    out = _run_go(_prog([]), host_code=_UNBOXED_HOST)
+    assert out == "allocs 0\n", out
+
+
+def test_run_extended_math():
+    if not _has_go():
+        return
+    inf = {"type": "MathConst", "name": "inf"}
+    nan = {"type": "MathConst", "name": "nan"}
+    doc = _prog([
+        {"type": "Print", "args": [_math(op, _lit(arg)) for op, arg in [
+            ("asin", 1), ("acos", 1), ("atan", 0), ("log2", 1024), ("log10", 0.001),
+            ("sinh", 0), ("cosh", 0), ("tanh", 0)]]},
+        {"type": "Print", "args": [_call("mathAtan2", _lit(0), _lit(-1)), _call("mathHypot", _lit(3), _lit(4))]},
+        {"type": "Print", "args": [inf, _bin("-", _lit(0), inf), nan]},
+        {"type": "Print", "args": [_call("mathIsNaN", nan), _call("mathIsInf", inf), _call("mathIsFinite", inf),
+                                   _call("mathIsFinite", _lit(1))]},
+        *[{"type": "TryCatch",
+           "body": [{"type": "Print", "args": [node]}],
+           "catch_var": "err",
+           "catch_body": [{"type": "Print", "args": [_var("err")]}]}
+          for node in (_math("asin", _lit(2)), _math("log", _lit(0)), _math("exp", _lit(1000)),
+                       _math("cosh", _lit(1000)),
+                       {"type": "MathPow", "base": _lit(0), "exponent": _lit(-2)},
+                       {"type": "MathPow", "base": _lit(-8), "exponent": _lit(0.5)},
+                       {"type": "MathPow", "base": _lit(10), "exponent": _lit(400)})],
+        # Infinite arguments are not errors
+        {"type": "Print", "args": [{"type": "MathPow", "base": _lit(-8), "exponent": inf},
+                                   {"type": "MathPow", "base": _lit(0), "exponent": _bin("-", _lit(0), inf)}]},
+    ])
+    out = _run_go(doc)
+    assert out.splitlines() == [
+        "1.5707963267948966 0.0 0.0 10.0 -3.0 0.0 1.0 0.0",
+        "3.141592653589793 5.0",
+        "inf -inf nan",
+        "True True False True",
+        "runtime error: math domain error in asin",
+        "runtime error: math domain error in log",
+        "runtime error: math range error in exp",
+        "runtime error: math range error in cosh",
+        "runtime error: math domain error in pow",
+        "runtime error: math domain error in pow",
+        "runtime error: math range error in pow",
+        "inf inf",
+    ], out
+    assert _run_interp(doc) == out
+
+
+def test_run_heap_bulk():
+    if not _has_go():
+        return
+    pairs = [{"type": "Array", "items": [_lit(p), _lit(v)]} for p, v in [(5, "e"), (2, "b"), (4, "d"), (1, "a")]]
+    doc = _prog([
+        {"type": "Let", "name": "h", "value": _call("heapFromArray", {"type": "Array", "items": pairs})},
+        {"type": "Print", "args": [{"type": "HeapPeek", "base": _var("h")}, {"type": "HeapSize", "base": _var("h")}]},
+        {"type": "Print", "args": [_call("heapPushPop", _var("h"), _lit(0), _lit("z"))]},
+        {"type": "Print", "args": [_call("heapPushPop", _var("h"), _lit(3), _lit("c"))]},
+        {"type": "Print", "args": [_call("heapReplace", _var("h"), _lit(9), _lit("i"))]},
+        {"type": "Print", "args": [_call("heapToSortedArray", _var("h"))]},
+        {"type": "Print", "args": [{"type": "HeapSize", "base": _var("h")}]},
+        {"type": "Print", "args": [_call("heapToSortedArray", _call("heapFromArray", {
+            "type": "Array", "items": [_lit(3), _lit(1.5), _lit(2)]}))]},
+        {"type": "TryCatch",
+         "body": [{"type": "Print", "args": [_call("heapReplace", {"type": "HeapNew"}, _lit(1), _lit("x"))]}],
+         "catch_var": "err",
+         "catch_body": [{"type": "Print", "args": [_var("err")]}]},
+    ])
+    out = _run_go(doc)
+    assert out.splitlines() == [
+        "a 4",
+        "z",
+        "a",
+        "b",
+        "['c', 'd', 'e', 'i']",
+        "4",
+        "[1.5, 2, 3]",
+        "runtime error: heap is empty",
+    ], out
+    assert _run_interp(doc) == out
+
+
+def test_run_deque_bulk():
+    if not _has_go():
+        return
+    q = _var("q")
+    readings = {"type": "Array", "items": [_lit(n) for n in range(1, 8)]}
+    doc = _prog([
+        {"type": "Let", "name": "q", "value": {"type": "DequeNew", "maxlen": _lit(4)}},
+        _call("dequeExtend", q, readings),
+        {"type": "Print", "args": [{"type": "DequeSize", "base": q},
+                                   {"type": "Index", "base": q, "index": _lit(0)},
+                                   {"type": "Index", "base": q, "index": _lit(-1)}]},
+        _call("dequeExtendLeft", q, {"type": "Array", "items": [_lit("a"), _lit("b")]}),
+        {"type": "Print", "args": [{"type": "Index", "base": q, "index": _lit(i)} for i in range(4)]},
+        {"type": "Let", "name": "open", "value": {"type": "DequeNew"}},
+        _call("dequeExtend", _var("open"), readings),
+        {"type": "Print", "args": [{"type": "DequeSize", "base": _var("open")}]},
+        {"type": "TryCatch",
+         "body": [{"type": "Print", "args": [{"type": "Index", "base": q, "index": _lit(4)}]}],
+         "catch_var": "err",
+         "catch_body": [{"type": "Print", "args": [_lit("out of range")]}]},
+    ])
+    out = _run_go(doc)
+    assert out.splitlines() == ["4 4 7", "b a 4 5", "7", "out of range"], out
+    assert _run_interp(doc) == out
+
+
+def test_run_min_max():
+    if not _has_go():
+        return
+    numbers = {"type": "Array", "items": [_lit(3), _lit(-7), _lit(5)]}
+    doc = _prog([
+        _func("negate", ["n"], _ret(_bin("-", _lit(0), _var("n")))),
+        _func("odd", ["n"], _ret(_bin("%", _var("n"), _lit(2)))),
+        {"type": "Print", "args": [_call("coreilMin", _lit(3), _lit(-7), _lit(5)),
+                                   _call("coreilMax", _lit(3), _lit(-7), _lit(5))]},
+        {"type": "Print", "args": [_call("coreilMin", numbers), _call("coreilMax", numbers)]},
+        # A string is a sequence of characters, not of bytes
+        {"type": "Print", "args": [_call("coreilMin", _lit("h\u00e9llo")), _call("coreilMax", _lit("h\u00e9llo"))]},
+        {"type": "Print", "args": [_call("coreilMinBy", _var("negate"), _lit(3), _lit(-7), _lit(5)),
+                                   _call("coreilMaxBy", _var("negate"), numbers)]},
+        # Ties keep the first candidate
+        {"type": "Print", "args": [_call("coreilMaxBy", _var("odd"), _lit(4), _lit(3), _lit(5))]},
+        {"type": "TryCatch",
+         "body": [{"type": "Print", "args": [_call("coreilMin", {"type": "Array", "items": []})]}],
+         "catch_var": "err",
+         "catch_body": [{"type": "Print", "args": [_call("errorKind", _var("err")), _var("err")]}]},
+    ])
+    out = _run_go(doc)
+    assert out.splitlines() == [
+        "-7 5", "-7 5", "h \u00e9", "5 -7", "3",
+        "ValueError runtime error: min() arg is an empty sequence",
+    ], out
+
+
+def test_native_fuzz_seeds():
+    if not _has_go():
+        return
+    # The seed corpus of the native fuzz targets runs as regular Go tests
+    result = run_native_fuzz(capture_output=True, text=True, timeout=300)
+    assert result.returncode == 0, result.stdout + result.stderr
+
+
+def test_run_test_mode():
+    if not _has_go():
+        return
+    doc = _prog([
+        {"type": "FuncDef", "name": "test_passes", "params": [], "body": [
+            {"type": "Print", "args": [_lit("quiet")]},
+        ]},
+        {"type": "FuncDef", "name": "test_fails", "params": [], "body": [
+            {"type": "Print", "args": [_lit("shown on failure")]},
+            {"type": "Throw", "message": _lit("expected 3, got 4")},
         ]},
     ])
-    code, _ = emit_go(doc)
-    assert "for {" in code
+    result = _run_go_tests(doc)
+    assert result.returncode == 1, result.stderr
+    assert "PASS test_passes" in result.stdout
+    assert "FAIL test_fails" in result.stdout
+    assert "expected 3, got 4" in result.stdout
+    assert "shown on failure" in result.stdout
+    assert "quiet" not in result.stdout
+    assert "1 passed, 1 failed" in result.stdout
 
 
-def test_codegen_try_catch():
-    """TryCatch codegen."""
+_SHARED_PROGRAM = _prog([
+    {"type": "Let", "name": "greeting", "value": _lit("ready")},
+    {"type": "Print", "args": [{"type": "Var", "name": "greeting"}]},
+    {"type": "FuncDef", "name": "summary", "params": ["xs"], "body": [
+        {"type": "Print", "args": [_lit("summarising")]},
+        {"type": "Return", "value": {"type": "Map", "items": [
+            {"key": _lit("count"), "value": {"type": "Length", "base": {"type": "Var", "name": "xs"}}},
+            {"key": _lit("first"), "value": {"type": "Index", "base": {"type": "Var", "name": "xs"}, "index": _lit(0)}},
+        ]}},
+    ]},
+])
+
+
+def test_codegen_shared():
+    code, _ = emit_go(_SHARED_PROGRAM, shared=True)
+    assert '"summary": {1, func(args []Value) Value { return fn_summary(args[0]) }},' in code, code
+    assert "func coreilProgram() {" in code
+    assert "func main() {}" in code
+    assert "coreilStartRun()" not in code
+
+
+def test_run_shared_library():
+    if not _has_go():
+        return
+    import ctypes
+
+    from english_compiler.coreil.go_shared import SharedError, SharedLibrary, build_go_shared
+
+    with tempfile.TemporaryDirectory() as tmpdir:
+        result = build_go_shared(_SHARED_PROGRAM, Path(tmpdir), name="summary")
+        assert result.success, result.error
+        assert "extern uintptr_t engine_eval(uintptr_t engine, char* name, char* args);" in (
+            result.header_path.read_text()
+        )
+        lib = SharedLibrary(result.library_path)
+        with lib.create() as engine:
+            assert engine.output() == "ready\n"
+            assert engine.eval("summary", [[7, 8]]) == {"count": 2, "first": 7}
+            assert engine.output() == "summarising\n"
+            for args, message in [
+                ([[]], "runtime error: index 0 out of range for array of length 0 — at $.body[2]\n  in summary"),
+                ([], "runtime error: function 'summary' takes 1 argument(s), got 0"),
+            ]:
+                try:
+                    engine.eval("summary", args)
+                    raise AssertionError("expected an error")
+                except SharedError as exc:
+                    assert str(exc) == message, exc
+            try:
+                engine.eval("missing")
+                raise AssertionError("expected an error")
+            except SharedError as exc:
+                assert str(exc) == "runtime error: unknown function 'missing'", exc
+
+        # The value accessors, as a C host would use them
+        raw = ctypes.CDLL(str(result.library_path))
+        raw.engine_create.restype = raw.engine_eval.restype = raw.value_get.restype = ctypes.c_size_t
+        raw.value_int.restype = ctypes.c_int64
+        raw.value_type.argtypes = raw.value_int.argtypes = raw.value_free.argtypes = [ctypes.c_size_t]
+        raw.value_get.argtypes = [ctypes.c_size_t, ctypes.c_char_p]
+        raw.engine_eval.argtypes = [ctypes.c_size_t, ctypes.c_char_p, ctypes.c_char_p]
+        engine = raw.engine_create()
+        summary = raw.engine_eval(engine, b"summary", b"[[5]]")
+        first = raw.value_get(summary, b"first")
+        assert raw.value_type(summary) == 6 and raw.value_type(first) == 1  # TypeMap, TypeInt
+        assert raw.value_int(first) == 5
+        assert raw.value_get(summary, b"missing") == 0
+        raw.value_free(first)
+        raw.value_free(summary)
+        # 0 and freed handles fail instead of crashing the host
+        raw.value_json.restype = raw.engine_error.restype = ctypes.c_void_p
+        raw.value_json.argtypes = raw.engine_error.argtypes = raw.engine_destroy.argtypes = [ctypes.c_size_t]
+        assert raw.value_type(summary) == raw.value_type(0) == -1
+        assert raw.value_int(first) == 0 and raw.value_json(0) is None and raw.value_get(0, b"first") == 0
+        raw.value_free(summary)
+        raw.engine_destroy(engine)
+        assert raw.engine_eval(engine, b"summary", b"[[5]]") == 0 and raw.engine_error(engine) is None
+        raw.engine_destroy(engine)
+        raw.engine_destroy(0)
+
+
+def test_build_cache():
+    if not _has_go():
+        return
+    from english_compiler.coreil import go_cache
+    from english_compiler.coreil.go_cache import GoBuildCache
+
+    doc = _prog([{"type": "Print", "args": [_lit("cached")]}])
+    with tempfile.TemporaryDirectory() as tmpdir:
+        cache = GoBuildCache(Path(tmpdir))
+        first = cache.build(doc, deterministic=True)
+        assert first.success and not first.hit, first.error
+        again = cache.build(doc, deterministic=True)
+        assert again.hit and again.binary_path == first.binary_path
+        run = subprocess.run([str(again.binary_path)], capture_output=True, text=True, timeout=30)
+        assert run.stdout == "cached\n", run.stdout
+        # Different options are a different binary
+        assert not cache.build(doc).hit
+
+        code, _ = emit_go(doc)
+        assert not cache.build_source(code).hit
+        assert cache.build_source(code).hit
+
+        # A runtime change misses, and its first build drops the old entries
+        real_fingerprint = go_cache.runtime_fingerprint
+        go_cache.runtime_fingerprint = lambda: "0" * 16
+        try:
+            bumped = cache.build(doc, deterministic=True)
+            assert bumped.success and not bumped.hit
+            assert not first.binary_path.exists()
+        finally:
+            go_cache.runtime_fingerprint = real_fingerprint
+        assert not cache.build(doc, deterministic=True).hit
+        assert cache.clear() == 1
+        assert not Path(tmpdir).exists()
+
+
+def test_run_packaged_executable():
+    if not _has_go():
+        return
+    from english_compiler.coreil.go_package import build_go_executable
+
+    doc = _prog([{"type": "Print", "args": [_lit("shipped")]}])
+    with tempfile.TemporaryDirectory() as tmpdir:
+        result = build_go_executable(doc, Path(tmpdir) / "bin" / "shipped")
+        assert result.success, result.error
+        # Runs with nothing but the executable
+        env = {"PATH": ""}
+        run = subprocess.run([str(result.executable_path)], capture_output=True, text=True, timeout=30, env=env)
+        assert run.returncode == 0 and run.stdout == "shipped\n", run
+        dump = subprocess.run(
+            [str(result.executable_path)],
+            capture_output=True,
+            text=True,
+            timeout=30,
+            env={**env, "COREIL_PRINT_IL": "1"},
+        )
+        assert json.loads(dump.stdout) == doc, dump.stdout
+
+
+def test_run_command_line_options():
+    if not _has_go():
+        return
+    from english_compiler.coreil.go_cache import GoBuildCache
+
     doc = _prog([
-        {"type": "TryCatch", "body": [
-            {"type": "Throw", "message": _lit("oops")},
-        ], "catch_var": "e", "catch_body": [
-            {"type": "Print", "args": [_var("e")]},
+        {"type": "FuncDef", "name": "roll", "params": [], "body": [
+            {"type": "Return", "value": _ext("random", "randint", _lit(1), _lit(1000000))},
         ]},
+        {"type": "Print", "args": [{"type": "Call", "name": "argv", "args": []}]},
+        {"type": "Print", "args": [_call("roll"), _call("roll")]},
+        {"type": "While", "test": _lit(True), "body": []},
     ])
-    code, _ = emit_go(doc)
-    assert "recover()" in code
-    assert "panic(" in code
+    with tempfile.TemporaryDirectory() as tmpdir:
+        build = GoBuildCache(Path(tmpdir)).build(doc)
+        assert build.success, build.error
 
+        def run(**env: str) -> subprocess.CompletedProcess:
+            return subprocess.run(
+                [str(build.binary_path), "x", "y z"],
+                capture_output=True,
+                text=True,
+                timeout=30,
+                env={**os.environ, "COREIL_MAX_STEPS": "100", **env},
+            )
 
-def test_codegen_for_range():
-    """For loop with Range codegen."""
+        first, second = run(COREIL_SEED="7"), run(COREIL_SEED="7", COREIL_TRACE="1")
+        assert first.returncode == 3, first.stderr
+        assert first.stdout.startswith("['x', 'y z']\n") and first.stdout == second.stdout, (first, second)
+        assert "limit exceeded: steps (max 100)" in first.stderr, first.stderr
+        assert second.stderr.count("trace: call roll (") == 2, second.stderr
+        assert "trace: external random.randint" not in second.stderr  # not side-effecting
+        assert run(COREIL_MAX_STEPS="lots").returncode == 2
+
+
+def _run_limited(body: list[dict], **env: str) -> subprocess.CompletedProcess:
+    """Build a program that runs body inside a TryCatch and run it with env."""
     doc = _prog([
-        {"type": "For", "var": "i",
-         "iter": {"type": "Range", "from": _lit(0), "to": _lit(3), "inclusive": False},
-         "body": [{"type": "Print", "args": [_var("i")]}]},
+        {"type": "TryCatch", "body": body, "catch_var": "err", "catch_body": [
+            {"type": "Print", "args": [_lit("caught"), _var("err")]},
+        ]},
+        {"type": "Print", "args": [_lit("after")]},
     ])
-    code, _ = emit_go(doc)
-    assert "__from" in code
-    assert "__to" in code
+    with tempfile.TemporaryDirectory() as tmpdir:
+        binary = _build_go(doc, Path(tmpdir))
+        return subprocess.run(
+            [str(binary)],
+            capture_output=True,
+            text=True,
+            timeout=30,
+            env={**os.environ, **env},
+        )
+
+
+def test_run_step_limit():
+    if not _has_go():
+        return
+    result = _run_limited([
+        {"type": "Print", "args": [_lit("start")]},
+        {"type": "While", "test": _lit(True), "body": []},
+    ], COREIL_MAX_STEPS="1000")
+    assert result.returncode == 3, result
+    assert result.stdout == "start\n", result.stdout
+    assert "limit exceeded: steps (max 1000)" in result.stderr, result.stderr
+
+
+def test_run_memory_limit():
+    if not _has_go():
+        return
+    result = _run_limited([
+        {"type": "Let", "name": "s", "value": _lit("")},
+        {"type": "While", "test": _lit(True), "body": [
+            {"type": "Assign", "name": "s", "value": _bin("+", _var("s"), _lit("x" * 64))},
+        ]},
+    ], COREIL_MAX_MEMORY="100000")
+    assert result.returncode == 3, result
+    assert result.stdout == "", result.stdout
+    assert "limit exceeded: memory (max 100000)" in result.stderr, result.stderr
+
+
+def test_run_collection_size_limit():
+    if not _has_go():
+        return
+    result = _run_limited([
+        {"type": "Let", "name": "xs", "value": {"type": "Array", "items": []}},
+        {"type": "While", "test": _lit(True), "body": [
+            {"type": "Push", "base": _var("xs"), "value": _lit(0)},
+        ]},
+    ], COREIL_MAX_COLLECTION_SIZE="50")
+    assert result.returncode == 3, result
+    assert result.stdout == "", result.stdout
+    assert "limit exceeded: collection size (max 50)" in result.stderr, result.stderr
+
+
+def test_run_timeout_limit():
+    if not _has_go():
+        return
+    result = _run_limited([
+        {"type": "While", "test": _lit(True), "body": []},
+    ], COREIL_TIMEOUT="0.2")
+    assert result.returncode == 3, result
+    assert result.stdout == "", result.stdout
+    assert "limit exceeded: timeout (context deadline exceeded)" in result.stderr, result.stderr
+
+
+# An embedding host translating with each provider against a fake API server
+# that checks the request and answers with a Markdown-wrapped program
+_FRONTEND_HOST = """package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+)
+
+const reply = "```json\\n{\\"version\\": \\"coreil-1.9\\", \\"body\\": []}\\n```"
+
+func init() {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model    string              `json:"model"`
+			System   string              `json:"system"`
+			Messages []map[string]string `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		last := req.Messages[len(req.Messages)-1]["content"]
+		switch r.URL.Path {
+		case "/v1/messages":
+			if r.Header.Get("x-api-key") != "k" || req.System == "" {
+				http.Error(w, "bad request", 400)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"content": []map[string]string{{"type": "text", "text": reply}},
+			})
+		case "/v1/chat/completions":
+			if req.Messages[0]["role"] != "system" {
+				http.Error(w, "bad request", 400)
+				return
+			}
+			text := reply
+			if last == "Say hi." {
+				text = "hi"
+			}
+			fmt.Println("model:", req.Model, "auth:", r.Header.Get("Authorization"))
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"choices": []map[string]interface{}{{"message": map[string]string{"content": text}}},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	os.Unsetenv("ANTHROPIC_API_KEY")
+	os.Unsetenv("OPENAI_API_KEY")
+	os.Setenv("LLAMACPP_BASE_URL", srv.URL+"/v1")
+
+	configs := []FrontendConfig{
+		{Provider: "anthropic", APIKey: "k", BaseURL: srv.URL},
+		{Provider: "openai", APIKey: "k", BaseURL: srv.URL + "/v1/"},
+		{},
+	}
+	for _, cfg := range configs {
+		f, err := NewFrontend(cfg)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		DefaultEngine.SetFrontend(f)
+		prog, err := DefaultEngine.Translate("Print 5.")
+		fmt.Println(prog["version"], err)
+	}
+	_, err := DefaultEngine.Translate("Say hi.")
+	fmt.Println(err)
+	_, err = NewFrontend(FrontendConfig{Provider: "openai"})
+	fmt.Println(err)
+	_, err = NewFrontend(FrontendConfig{Provider: "gemini"})
+	fmt.Println(err)
+	DefaultEngine.SetFrontend(nil)
+	_, err = DefaultEngine.Translate("Print 5.")
+	fmt.Println(err)
+}
+"""
+
+
+def test_run_frontend_translate():
+    if not _has_go():
+        return
+    from english_compiler.coreil.emit_go import get_frontend_path
+    from english_compiler.frontend.base import get_prompt_path
+
+    result = _exec_go(
+        _prog([]),
+        host_code=_FRONTEND_HOST,
+        extra_files=(get_frontend_path(), get_prompt_path()),
+    )
+    assert result.returncode == 0, result.stderr
+    assert result.stdout.splitlines() == [
+        "coreil-1.9 <nil>",
+        "model: gpt-4o auth: Bearer k",
+        "coreil-1.9 <nil>",
+        "model:  auth: ",
+        "coreil-1.9 <nil>",
+        "model:  auth: ",
+        "llama.cpp returned invalid JSON. Response snippet: hi",
+        "OPENAI_API_KEY is not set",
+        "unknown frontend: gemini",
+        "no frontend configured; see SetFrontend",
+    ], result.stdout
 
 
 # --- Parity tests (require Go compiler) ---
@@ -189,6 +4068,44 @@ def test_parity_function():
     ]))
 
 
+def test_parity_function_values():
+    # A Var naming a function is the function as a value, unless a
+    # parameter or variable of the same name shadows it
+    doc = _prog([
+        _func("double", ["x"], _ret(_bin("*", _var("x"), _lit(2)))),
+        _func("shadowed", ["double"], _ret(_var("double"))),
+        _func("same", [], _ret(_bin("==", _var("double"), _var("double")))),
+        {"type": "Print", "args": [_var("double"), {"type": "Array", "items": [_var("double"), _var("same")]}]},
+        {"type": "Print", "args": [_call("same"), _bin("==", _var("double"), _var("same")),
+                                   _call("shadowed", _lit(3))]},
+    ])
+    # Every use shares one Value, so a function equals itself
+    code, _ = emit_go(doc)
+    assert code.count('__func_double = ValueFunc("double", ') == 1, code
+    _check_parity(doc)
+
+
+def test_parity_function_reads_top_level():
+    # Top-level variables functions read become package-level Go vars;
+    # a local of the same name still shadows them
+    _check_parity(_prog([
+        {"type": "Let", "name": "rate", "value": _lit(3)},
+        {"type": "FuncDef", "name": "scale", "params": ["x"], "body": [
+            {"type": "Return", "value": _bin("*", _var("x"), _var("rate"))},
+        ]},
+        {"type": "FuncDef", "name": "shadow", "params": [], "body": [
+            {"type": "Let", "name": "rate", "value": _lit(10)},
+            {"type": "Return", "value": _var("rate")},
+        ]},
+        {"type": "Assign", "name": "rate", "value": _lit(4)},
+        {"type": "Print", "args": [
+            {"type": "Call", "name": "scale", "args": [_lit(2)]},
+            {"type": "Call", "name": "shadow", "args": []},
+            _var("rate"),
+        ]},
+    ]))
+
+
 def test_parity_if_else():
     _check_parity(_prog([
         {"type": "Let", "name": "x", "value": _lit(10)},
@@ -273,6 +4190,18 @@ def test_parity_break_continue():
     ]))
 
 
+def test_parity_unread_variable():
+    """A variable that is assigned but never read still compiles."""
+    _check_parity(_prog([
+        {"type": "FuncDef", "name": "f", "params": ["x"], "body": [
+            {"type": "Let", "name": "half", "value": _bin("/", _var("x"), _lit(2))},
+            {"type": "Return", "value": _var("x")},
+        ]},
+        {"type": "Let", "name": "unused", "value": {"type": "Call", "name": "f", "args": [_lit(4)]}},
+        {"type": "Print", "args": [_lit("done")]},
+    ]))
+
+
 def test_parity_type_convert():
     _check_parity(_prog([
         {"type": "Print", "args": [{"type": "ToInt", "value": _lit(3.7)}]},
@@ -281,6 +4210,75 @@ def test_parity_type_convert():
     ]))
 
 
+def test_parity_container_truthiness():
+    _check_parity(_prog([
+        {"type": "Let", "name": "s", "value": {"type": "Set", "items": []}},
+        {"type": "Let", "name": "d", "value": {"type": "DequeNew"}},
+        {"type": "If", "test": _var("s"), "then": [{"type": "Print", "args": [_lit("set truthy")]}],
+         "else": [{"type": "Print", "args": [_lit("set falsy")]}]},
+        {"type": "If", "test": _var("d"), "then": [{"type": "Print", "args": [_lit("deque truthy")]}],
+         "else": [{"type": "Print", "args": [_lit("deque falsy")]}]},
+        {"type": "PushBack", "base": _var("d"), "value": _lit(1)},
+        {"type": "If", "test": _var("d"), "then": [{"type": "Print", "args": [_lit("deque truthy")]}],
+         "else": [{"type": "Print", "args": [_lit("deque falsy")]}]},
+    ]))
+
+
+def test_parity_bool_equality():
+    # A bool equals the number 0 or 1, as in Python
+    pairs = [(True, 1), (False, 0), (True, 1.0), (False, -0.0), (1, True), (0.0, False),
+             (True, 2), (False, 1), (True, 0.5), (True, "True"), (True, True), (False, None)]
+    _check_parity(_prog([
+        {"type": "Print", "args": [_bin(op, _lit(a), _lit(b)) for a, b in pairs]}
+        for op in ("==", "!=")
+    ]))
+
+
+def test_parity_deque_repr():
+    d, b = _var("d"), _var("b")
+    _check_parity(_prog([
+        {"type": "Let", "name": "d", "value": {"type": "DequeNew"}},
+        {"type": "Let", "name": "b", "value": {"type": "DequeNew", "maxlen": _lit(3)}},
+        {"type": "Print", "args": [d, b]},
+        {"type": "PushBack", "base": d, "value": _lit("a")},
+        {"type": "PushFront", "base": d, "value": _lit(1.5)},
+    ] + [{"type": "PushBack", "base": b, "value": _lit(i)} for i in range(1, 6)] + [
+        {"type": "Print", "args": [d, b, {"type": "Array", "items": [b]}]},
+    ]))
+
+
+def test_parity_string_repr():
+    _check_parity(_prog([
+        {"type": "Print", "args": [{"type": "Array", "items": [
+            _lit("it's"), _lit('say "hi"'), _lit("tab\there\nnext"), _lit("back\\slash"),
+        ]}]},
+        {"type": "Print", "args": [{"type": "Map", "items": [
+            {"key": _lit("it's"), "value": _lit("v")},
+        ]}]},
+    ]))
+
+
+def test_parity_float_format():
+    _check_parity(_prog([
+        {"type": "Print", "args": [_lit(1e16), _lit(1e15), _lit(0.0001), _lit(0.00001)]},
+        {"type": "Print", "args": [_lit(-0.0), _lit(1.5e-7), _bin("/", _lit(1.0), _lit(3.0))]},
+        {"type": "Print", "args": [_bin("+", _lit(0.1), _lit(0.2))]},
+    ]))
+
+
+def test_parity_map_non_string_keys():
+    _check_parity(_prog([
+        {"type": "Let", "name": "m", "value": {"type": "Map", "items": [
+            {"key": _lit(1), "value": _lit("one")},
+            {"key": _lit("1"), "value": _lit("str one")},
+        ]}},
+        {"type": "Set", "base": _var("m"), "key": _lit(2), "value": _lit("two")},
+        {"type": "Print", "args": [_var("m")]},
+        {"type": "Print", "args": [{"type": "Get", "base": _var("m"), "key": _lit(2)}]},
+        {"type": "Print", "args": [{"type": "Keys", "base": _var("m")}]},
+    ]))
+
+
 # --- JSON tests ---
 
 def test_codegen_json_parse():
@@ -385,10 +4383,103 @@ def main() -> None:
         test_codegen_json_parse,
         test_codegen_json_stringify,
         test_codegen_regex_match,
+        test_codegen_test_mode,
+        test_codegen_capacity_hints,
+        test_codegen_execution_limits,
+        test_run_recursion_depth,
+        test_run_error_source_location,
+        test_run_debugger_dap,
+        test_run_watch_variable,
+        test_run_statement_coverage,
+        test_run_profile,
+        test_run_heap_snapshot,
+        test_codegen_tail_calls,
+        test_parity_tail_calls,
+        test_codegen_external_call,
+        # Test mode
+        test_codegen_audit_location,
+        test_run_audit_sink,
+        test_codegen_isolated,
+        test_run_isolated,
+        test_run_isolated_cancelled,
+        test_run_cancelled_sleep,
+        test_run_error_kinds,
+        test_run_spawn_channels,
+        test_run_select,
+        test_run_futures,
+        test_run_pool,
+        test_run_locks,
+        test_run_actors,
+        test_run_event_bus,
+        test_run_timers,
+        test_run_generators,
+        test_run_read_lines,
+        test_run_memoize,
+        test_run_memo_key_pooling,
+        test_run_user_function_names,
+        test_run_compose,
+        test_run_method_shadowing,
+        test_run_record_schema,
+        test_run_record_fields,
+        test_run_display_hooks,
+        test_run_variants,
+        test_run_option_result,
+        test_run_contains,
+        test_run_and_or_operands,
+        test_run_unary_operators,
+        test_run_copies,
+        test_run_engine_output,
+        test_run_print_options,
+        test_run_logging,
+        test_run_assertions,
+        test_run_string_interning,
+        test_run_array_slices,
+        test_run_match_value,
+        test_run_tables,
+        test_run_arrow,
+        test_run_html_xml,
+        test_run_urls,
+        test_run_compression,
+        test_run_ids,
+        test_run_notifications,
+        test_run_secrets,
+        test_run_deterministic,
+        test_run_randint_ranges,
+        test_run_strict,
+        test_run_checked_arithmetic,
+        test_run_division,
+        test_run_rounding,
+        test_run_unboxed_values,
+        test_run_extended_math,
+        test_run_heap_bulk,
+        test_run_deque_bulk,
+        test_run_min_max,
+        test_native_fuzz_seeds,
+        test_run_method_args_not_pooled,
+        test_run_test_mode,
+        test_codegen_shared,
+        test_run_shared_library,
+        test_build_cache,
+        test_run_packaged_executable,
+        test_run_command_line_options,
+        test_run_step_limit,
+        test_run_memory_limit,
+        test_run_collection_size_limit,
+        test_run_timeout_limit,
+        test_run_frontend_translate,
+        test_run_external_call,
+        test_run_sandbox_denies_by_default,
+        test_run_record_replay,
+        test_run_checkpoint_restore,
+        test_checkpoint_api,
+        test_run_tracing,
+        test_run_metrics,
         # Parity
         test_parity_hello,
         test_parity_arithmetic,
         test_parity_function,
+        test_parity_function_values,
+        test_parity_function_reads_top_level,
         test_parity_if_else,
         test_parity_while,
         test_parity_array,
@@ -397,7 +4488,14 @@ def main() -> None:
         test_parity_string_ops,
         test_parity_try_catch,
         test_parity_break_continue,
+        test_parity_unread_variable,
         test_parity_type_convert,
+        test_parity_container_truthiness,
+        test_parity_bool_equality,
+        test_parity_deque_repr,
+        test_parity_string_repr,
+        test_parity_float_format,
+        test_parity_map_non_string_keys,
         test_parity_json_parse,
         test_parity_json_parse_array,
         test_parity_json_stringify,
@@ -0,0 +1,326 @@
+"""Differential fuzzing of Go runtime operations.
+
+Generates random values and operation sequences, runs them on the reference
+interpreter and the Go runtime, and flags any operation whose printed result
+differs or which fails on one side only. Generation is weighted towards
+arithmetic edge cases, slicing, and formatting.
+
+Each operation is compiled into its own TryCatch so a runtime error prints a
+marker instead of aborting the batch, and is preceded by a separator line so
+results containing newlines stay aligned; many operations share a single Go
+build to keep the run fast.
+
+Go ints are 64-bit where the interpreter's are unbounded, so programs are
+built with checked arithmetic and an int result outside int64 must raise in
+Go rather than wrap. MathPow results may differ in the last place, since
+the C library's pow and Go's math.Pow round differently. Operations that
+once diverged are replayed as a fixed regression batch before the random
+ones.
+
+Usage:
+    python -m tests.test_go_fuzz                # 5 batches of 60 operations
+    python -m tests.test_go_fuzz --batches 20   # More batches
+    python -m tests.test_go_fuzz --seed 42      # Reproducible run
+"""
+
+from __future__ import annotations
+
+import argparse
+import json
+import math
+import random
+import sys
+from pathlib import Path
+
+sys.path.insert(0, str(Path(__file__).resolve().parents[1]))
+
+from english_compiler.coreil.validate import validate_coreil
+from tests.test_helpers import GO_AVAILABLE, run_go_backend, run_interpreter
+
+ERROR_MARKER = "<error>"
+SEPARATOR = "<op>"
+INT64_MIN, INT64_MAX = -(2**63), 2**63 - 1
+
+EDGE_INTS = [0, 1, -1, 2, -2, 3, 7, -7, 10, 100, -128, 255, 1024, 2**31 - 1, -(2**31), 2**53, -(2**53)]
+EDGE_FLOATS = [0.0, -0.0, 0.1, 0.2, 0.5, -0.5, 1.5, 2.5, -2.5, 3.0, 1e-05, 0.0001, 1e15, 1e16, 1e21, -1e16, 123.456, 2.0**53]
+EDGE_STRINGS = ["", "a", "abc", "hello world", "it's", 'say "hi"', "tab\there", "line\nbreak", "back\\slash", "ünï", "42", " pad "]
+
+
+# Operations that diverged in earlier runs, with the seed that found them
+REGRESSIONS = [
+    # seed 3956706827: floor(1e21) wrapped to -9223372036854775808
+    {"type": "Math", "op": "floor", "arg": {"type": "Literal", "value": 1e21}},
+    {"type": "ToInt", "value": {"type": "Literal", "value": 1e21}},
+    {"type": "Binary", "op": "*", "left": {"type": "Literal", "value": 2**53},
+     "right": {"type": "Literal", "value": 2**53}},
+    # seed 3: libm's pow gives 9109555799784050.0, Go's 9109555799784048.0
+    {"type": "MathPow", "base": {"type": "Literal", "value": -457}, "exponent": {"type": "Literal", "value": 6}},
+    # seed 2061456075: 0 ** -2 was inf in Go, a domain error in Python
+    {"type": "MathPow", "base": {"type": "Literal", "value": 0}, "exponent": {"type": "Literal", "value": -2}},
+]
+
+# ---------------------------------------------------------------------------
+# Operation generator
+# ---------------------------------------------------------------------------
+
+def _lit(value: object) -> dict:
+    return {"type": "Literal", "value": value}
+
+
+class OperationGenerator:
+    """Generates random values and single-expression operations."""
+
+    def __init__(self, rng: random.Random) -> None:
+        self.rng = rng
+
+    def gen_int(self) -> dict:
+        if self.rng.random() < 0.7:
+            return _lit(self.rng.choice(EDGE_INTS))
+        return _lit(self.rng.randint(-1000, 1000))
+
+    def gen_float(self) -> dict:
+        if self.rng.random() < 0.7:
+            return _lit(self.rng.choice(EDGE_FLOATS))
+        return _lit(round(self.rng.uniform(-1000, 1000), self.rng.randint(0, 6)))
+
+    def gen_number(self) -> dict:
+        return self.gen_int() if self.rng.random() < 0.5 else self.gen_float()
+
+    def gen_string(self) -> dict:
+        return _lit(self.rng.choice(EDGE_STRINGS))
+
+    def gen_scalar(self) -> dict:
+        kind = self.rng.choice(["int", "float", "string", "bool", "null"])
+        if kind == "int":
+            return self.gen_int()
+        if kind == "float":
+            return self.gen_float()
+        if kind == "string":
+            return self.gen_string()
+        if kind == "bool":
+            return _lit(self.rng.choice([True, False]))
+        return _lit(None)
+
+    def gen_value(self, depth: int = 0) -> dict:
+        """Generate a random value, possibly a nested container."""
+        if depth >= 2 or self.rng.random() < 0.5:
+            return self.gen_scalar()
+        kind = self.rng.choice(["array", "tuple", "map", "set"])
+        n = self.rng.randint(0, 3)
+        if kind == "array":
+            return {"type": "Array", "items": [self.gen_value(depth + 1) for _ in range(n)]}
+        if kind == "tuple":
+            return {"type": "Tuple", "items": [self.gen_value(depth + 1) for _ in range(n)]}
+        if kind == "map":
+            items = [{"key": self.gen_string(), "value": self.gen_value(depth + 1)} for _ in range(n)]
+            return {"type": "Map", "items": items}
+        # Python orders multi-element sets by hash, so keep them to one member
+        return {"type": "Set", "items": [self.gen_int() for _ in range(min(n, 1))]}
+
+    def gen_bounds(self, length: int, allow_negative: bool) -> tuple[dict, dict]:
+        """Generate an in-range start/end pair.
+
+        The interpreter rejects out-of-range bounds while the compiled
+        backends clamp them, so only in-range bounds are compared.
+        """
+        start = self.rng.randint(0, length)
+        end = self.rng.randint(start, length)
+        if allow_negative and start < length and self.rng.random() < 0.3:
+            start -= length
+        if allow_negative and end > 0 and self.rng.random() < 0.3:
+            end -= length
+        return _lit(start), _lit(end)
+
+    # -- Operations --
+
+    def gen_arithmetic(self) -> dict:
+        op = self.rng.choice(["+", "-", "*", "/", "%"])
+        return {"type": "Binary", "op": op, "left": self.gen_number(), "right": self.gen_number()}
+
+    def gen_comparison(self) -> dict:
+        op = self.rng.choice(["==", "!=", "<", "<=", ">", ">="])
+        if self.rng.random() < 0.7:
+            left, right = self.gen_number(), self.gen_number()
+        else:
+            left, right = self.gen_string(), self.gen_string()
+        return {"type": "Binary", "op": op, "left": left, "right": right}
+
+    def gen_math(self) -> dict:
+        kind = self.rng.choice(["floor", "ceil", "abs", "sqrt", "pow", "to_int", "to_float", "to_string"])
+        if kind == "pow":
+            return {"type": "MathPow", "base": self.gen_number(), "exponent": _lit(self.rng.randint(-3, 8))}
+        if kind == "to_int":
+            return {"type": "ToInt", "value": self.gen_number()}
+        if kind == "to_float":
+            return {"type": "ToFloat", "value": self.gen_number()}
+        if kind == "to_string":
+            return {"type": "ToString", "value": self.gen_scalar()}
+        return {"type": "Math", "op": kind, "arg": self.gen_number()}
+
+    def gen_slicing(self) -> dict:
+        if self.rng.random() < 0.5:
+            items = [self.gen_int() for _ in range(self.rng.randint(1, 5))]
+            n = len(items)
+            base = {"type": "Array", "items": items}
+            if self.rng.random() < 0.5:
+                return {"type": "Index", "base": base, "index": _lit(self.rng.randint(-n, n - 1))}
+            start, end = self.gen_bounds(n, allow_negative=True)
+            return {"type": "Slice", "base": base, "start": start, "end": end}
+        text = self.rng.choice(EDGE_STRINGS)
+        n = len(text)
+        base = _lit(text)
+        if n and self.rng.random() < 0.5:
+            return {"type": "CharAt", "base": base, "index": _lit(self.rng.randint(0, n - 1))}
+        start, end = self.gen_bounds(n, allow_negative=False)
+        return {"type": "Substring", "base": base, "start": start, "end": end}
+
+    def gen_formatting(self) -> dict:
+        return self.gen_value()
+
+    def gen_operation(self) -> dict:
+        kind = self.rng.choices(
+            ["arithmetic", "comparison", "math", "slicing", "formatting"],
+            weights=[3, 1, 2, 2, 2],
+        )[0]
+        return getattr(self, f"gen_{kind}")()
+
+    def gen_batch(self, size: int) -> tuple[dict, list[dict]]:
+        """Generate a program that prints a separated result per operation."""
+        ops = [self.gen_operation() for _ in range(size)]
+        return build_batch(ops), ops
+
+
+def build_batch(ops: list[dict]) -> dict:
+    """Build a program that prints a separated result per operation."""
+    body: list[dict] = []
+    for op in ops:
+        body.append({"type": "Print", "args": [_lit(SEPARATOR)]})
+        body.append({
+            "type": "TryCatch",
+            "body": [{"type": "Print", "args": [op]}],
+            "catch_var": "err",
+            "catch_body": [{"type": "Print", "args": [_lit(ERROR_MARKER)]}],
+        })
+    return {"version": "coreil-1.10", "body": body}
+
+
+# ---------------------------------------------------------------------------
+# Test runner
+# ---------------------------------------------------------------------------
+
+def split_results(output: str) -> list[str]:
+    """Split batch output into one result per operation."""
+    return output.split(SEPARATOR + "\n")[1:]
+
+
+def is_int64_overflow(result: str) -> bool:
+    """Whether an interpreter result is an int that does not fit in int64."""
+    try:
+        value = int(result)
+    except ValueError:
+        return False
+    return not INT64_MIN <= value <= INT64_MAX
+
+
+def is_last_place_difference(want: str, got: str) -> bool:
+    """Whether two printed floats are distinct but one unit in the last place apart."""
+    try:
+        a, b = float(want), float(got)
+    except ValueError:
+        return False
+    return 0 < abs(a - b) <= math.ulp(max(abs(a), abs(b)))
+
+
+def compare_batch(doc: dict, ops: list[dict]) -> list[str]:
+    """Run one batch on both runtimes and describe each divergence."""
+    reference = run_interpreter(doc)
+    if not reference.success:
+        return [f"interpreter crashed: {reference.error}"]
+
+    go = run_go_backend(doc, timeout=120, checked=True)
+    if not go.success and not go.output:
+        return [f"Go backend failed: {go.error}"]
+
+    expected = split_results(reference.output)
+    actual = split_results(go.output)
+    problems: list[str] = []
+    for i, op in enumerate(ops):
+        want = expected[i].rstrip("\n") if i < len(expected) else "<missing>"
+        got = actual[i].rstrip("\n") if i < len(actual) else "<missing>"
+        if want == got or (got == ERROR_MARKER and is_int64_overflow(want)):
+            continue
+        if op["type"] == "MathPow" and is_last_place_difference(want, got):
+            continue
+        problems.append(
+            f"{json.dumps(op)}\n"
+            f"      interpreter: {want}\n"
+            f"      go:          {got}"
+        )
+    if len(actual) < len(ops):
+        problems.append(f"Go stopped after {len(actual)}/{len(ops)} operations: {go.error}")
+    return problems
+
+
+def run_fuzz(batches: int, size: int, seed: int | None = None) -> int:
+    """Run differential fuzzing. Returns number of divergent operations."""
+    if seed is None:
+        seed = random.randint(0, 2**32 - 1)
+    print(f"Fuzzing {batches} batches of {size} Go runtime operations (seed={seed})...\n")
+
+    rng = random.Random(seed)
+    gen = OperationGenerator(rng)
+    failures = 0
+
+    problems = compare_batch(build_batch(REGRESSIONS), REGRESSIONS)
+    if problems:
+        failures += len(problems)
+        print(f"  [regressions] FAIL: {len(problems)} divergent operation(s)")
+        for problem in problems:
+            print(f"    {problem}")
+    else:
+        print("  [regressions] PASS")
+
+    for i in range(batches):
+        doc, ops = gen.gen_batch(size)
+
+        errors = validate_coreil(doc)
+        if errors:
+            failures += 1
+            print(f"  [{i+1}/{batches}] INVALID (generator bug): {errors[:3]}")
+            continue
+
+        problems = compare_batch(doc, ops)
+        if problems:
+            failures += len(problems)
+            print(f"  [{i+1}/{batches}] FAIL: {len(problems)} divergent operation(s)")
+            for problem in problems:
+                print(f"    {problem}")
+        else:
+            print(f"  [{i+1}/{batches}] PASS")
+
+    print()
+    if failures:
+        print(f"{failures} divergence(s) found (seed={seed})")
+    else:
+        print(f"All {batches * size} operations matched! (seed={seed})")
+
+    return failures
+
+
+def main() -> int:
+    parser = argparse.ArgumentParser(description="Differential fuzzing of Go runtime operations")
+    parser.add_argument("--batches", type=int, default=5, help="Number of programs to build")
+    parser.add_argument("--size", type=int, default=60, help="Operations per program")
+    parser.add_argument("--seed", type=int, default=None, help="Random seed for reproducibility")
+    args = parser.parse_args()
+
+    if not GO_AVAILABLE:
+        print("Go not available, skipping Go runtime fuzzing")
+        return 0
+
+    failures = run_fuzz(args.batches, args.size, args.seed)
+    return 1 if failures else 0
+
+
+if __name__ == "__main__":
+    sys.exit(main())
@@ -392,12 +392,13 @@ def run_rust_backend(doc: dict, timeout: int = 10) -> BackendResult:
         )
 
 
-def run_go_backend(doc: dict, timeout: int = 10) -> BackendResult:
+def run_go_backend(doc: dict, timeout: int = 10, **emit_options) -> BackendResult:
     """Generate Go code, compile, and execute it.
 
     Args:
         doc: The Core IL document to transpile and run.
         timeout: Maximum execution time in seconds.
+        **emit_options: Options passed on to emit_go, e.g. checked=True.
 
     Returns:
         BackendResult with output, exit code, and success status.
@@ -414,7 +415,7 @@ def run_go_backend(doc: dict, timeout: int = 10) -> BackendResult:
     from english_compiler.coreil.emit_go import emit_go, get_runtime_path
 
     try:
-        go_code, _ = emit_go(doc)
+        go_code, _ = emit_go(doc, **emit_options)
     except Exception as exc:
         return BackendResult(
             output="",
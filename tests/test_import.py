@@ -7,6 +7,8 @@ Tests cover:
 - Transitive imports: A imports B which imports C
 - Circular import detection
 - Interpreter E2E: two fixture files, verify output
+- Exports and linking: constants, private names, module-internal references
+- Separate compilation: `compile` compiles imported English modules
 - Python parity: interpreter output == Python backend output
 """
 
@@ -14,22 +16,29 @@ from __future__ import annotations
 
 import io
 import json
+import os
 import sys
 import tempfile
 import unittest
 from contextlib import redirect_stdout
 from pathlib import Path
+from unittest import mock
 
 from english_compiler.coreil.interp import run_coreil
 from english_compiler.coreil.module import (
     CircularImportError,
+    ImportNameError,
     ModuleCache,
     ModuleNotFoundError,
     extract_exports,
+    module_exports,
     resolve_imports,
     resolve_module_path,
 )
 from english_compiler.coreil.validate import validate_coreil
+from english_compiler.coreil.verify import verify_coreil
+from english_compiler.frontend.base import BaseFrontend
+from tests.test_helpers import GO_AVAILABLE
 from english_compiler.coreil.versions import COREIL_VERSION
 
 
@@ -394,6 +403,210 @@ class TestInterpreterE2E(unittest.TestCase):
             self.assertEqual(buf.getvalue(), "Hello World\n21\n")
 
 
+def _var(name: str) -> dict:
+    return {"type": "Var", "name": name}
+
+
+def _lit(value) -> dict:
+    return {"type": "Literal", "value": value}
+
+
+def _call(name: str, *args: dict) -> dict:
+    return {"type": "Call", "name": name, "args": list(args)}
+
+
+def _return(value: dict) -> dict:
+    return {"type": "Return", "value": value}
+
+
+def _times(left: dict, right: dict) -> dict:
+    return {"type": "Binary", "op": "*", "left": left, "right": right}
+
+
+# A module with a constant, a private helper and two exported functions
+# that use them
+SHAPES = [
+    {"type": "Let", "name": "PI", "value": _lit(3)},
+    {"type": "FuncDef", "name": "square", "params": ["x"], "body": [_return(_times(_var("x"), _var("x")))]},
+    {"type": "FuncDef", "name": "circle_area", "params": ["r"], "body": [
+        _return(_times(_var("PI"), _call("square", _var("r")))),
+    ]},
+    {"type": "FuncDef", "name": "scaled_pi", "params": ["PI"], "body": [_return(_times(_var("PI"), _lit(2)))]},
+    {"type": "Print", "args": [_lit("not run on import")]},
+]
+
+
+def _run(doc: dict, base_dir: Path) -> str:
+    buf = io.StringIO()
+    with redirect_stdout(buf):
+        rc = run_coreil(doc, base_dir=base_dir)
+    assert rc == 0, buf.getvalue()
+    return buf.getvalue()
+
+
+class TestExportsAndLinking(unittest.TestCase):
+    """Constants, export lists, and references inside modules."""
+
+    def test_module_exports(self):
+        self.assertEqual(module_exports(_make_doc(SHAPES)), {"PI", "square", "circle_area", "scaled_pi"})
+        doc = {**_make_doc(SHAPES), "exports": ["circle_area", "PI"]}
+        self.assertEqual(module_exports(doc), {"circle_area", "PI"})
+        with self.assertRaises(ValueError) as cm:
+            module_exports({**doc, "exports": ["volume"]})
+        self.assertIn("volume", str(cm.exception))
+
+    def test_constants_and_internal_references(self):
+        with tempfile.TemporaryDirectory() as tmpdir:
+            tmp = Path(tmpdir)
+            _write_module(tmp, "shapes", SHAPES)
+            doc = _make_doc([
+                {"type": "Import", "path": "shapes", "alias": "geo"},
+                {"type": "Let", "name": "PI", "value": _lit("main's own")},
+                {"type": "Print", "args": [_var("geo.PI"), _var("PI")]},
+                {"type": "Print", "args": [_call("geo.circle_area", _lit(2))]},
+                # A parameter named like a module constant is the parameter
+                {"type": "Print", "args": [_call("geo.scaled_pi", _lit(5))]},
+            ])
+            # Dotted names are accepted before the imports are resolved
+            self.assertEqual(verify_coreil(doc), [])
+            self.assertEqual(_run(doc, tmp), "3 main's own\n12\n10\n")
+
+            resolved = resolve_imports(doc, base_dir=tmp)
+            names = [stmt.get("name") for stmt in resolved["body"][:4]]
+            self.assertEqual(names, ["geo__PI", "geo__square", "geo__circle_area", "geo__scaled_pi"])
+
+    def test_private_names(self):
+        with tempfile.TemporaryDirectory() as tmpdir:
+            tmp = Path(tmpdir)
+            path = _write_module(tmp, "shapes", SHAPES)
+            module = json.loads(path.read_text(encoding="utf-8"))
+            module["exports"] = ["circle_area"]
+            path.write_text(json.dumps(module), encoding="utf-8")
+
+            doc = _make_doc([
+                {"type": "Import", "path": "shapes"},
+                {"type": "Print", "args": [_call("shapes.circle_area", _lit(1))]},
+            ])
+            # Exported functions still use the private ones
+            self.assertEqual(_run(doc, tmp), "3\n")
+
+            doc["body"].append({"type": "Print", "args": [_call("shapes.square", _lit(1))]})
+            with self.assertRaises(ImportNameError) as cm:
+                resolve_imports(doc, base_dir=tmp)
+            self.assertEqual(str(cm.exception), "module 'shapes' has no export 'square'")
+
+    def test_transitive_and_shared_imports(self):
+        with tempfile.TemporaryDirectory() as tmpdir:
+            tmp = Path(tmpdir)
+            (tmp / "lib").mkdir()
+            _write_module(tmp / "lib", "base", [
+                {"type": "Let", "name": "TEN", "value": _lit(10)},
+                {"type": "FuncDef", "name": "ten", "params": [], "body": [_return(_var("TEN"))]},
+            ])
+            # Both modules import lib.base; each keeps its own copy
+            _write_module(tmp, "doubler", [
+                {"type": "Import", "path": "lib.base"},
+                {"type": "FuncDef", "name": "twenty", "params": [], "body": [
+                    _return(_times(_call("base.ten"), _lit(2))),
+                ]},
+            ])
+            _write_module(tmp, "tripler", [
+                {"type": "Import", "path": "lib.base", "alias": "b"},
+                {"type": "FuncDef", "name": "thirty", "params": [], "body": [
+                    _return(_times(_var("b.TEN"), _lit(3))),
+                ]},
+            ])
+            doc = _make_doc([
+                {"type": "Import", "path": "doubler"},
+                {"type": "Import", "path": "tripler"},
+                {"type": "Import", "path": "lib.base"},
+                {"type": "Print", "args": [
+                    _call("doubler.twenty"), _call("tripler.thirty"), _call("base.ten"),
+                ]},
+            ])
+            self.assertEqual(_run(doc, tmp), "20 30 10\n")
+            # Modules only export their own definitions
+            doc["body"].append({"type": "Print", "args": [_call("doubler.base__ten")]})
+            with self.assertRaises(ImportNameError):
+                resolve_imports(doc, base_dir=tmp)
+
+
+# English sources and the Core IL the stub frontend compiles them to
+MAIN_SOURCE = "Import lib.shapes.\nPrint the area of a circle of radius 2.\n"
+SHAPES_SOURCE = "Pi is 3. To square x, multiply it by itself. A circle's area is pi times its radius squared.\n"
+PROGRAMS = {
+    MAIN_SOURCE: _make_doc([
+        {"type": "Import", "path": "lib.shapes"},
+        {"type": "Print", "args": [_call("shapes.circle_area", _lit(2))]},
+    ]),
+    SHAPES_SOURCE: _make_doc(SHAPES[:3]),
+}
+
+
+class _ModuleFrontend(BaseFrontend):
+    """Stub LLM frontend answering PROGRAMS."""
+
+    def __init__(self) -> None:
+        super().__init__()
+        self.compiled: list[str] = []
+
+    def generate_coreil_from_text(self, source_text: str, **kwargs) -> dict:
+        self.source_text = source_text
+        self.compiled.append(source_text)
+        return super().generate_coreil_from_text(source_text, **kwargs)
+
+    def _call_api(self, user_message: str) -> dict:
+        return json.loads(json.dumps(PROGRAMS[self.source_text]))
+
+    def _call_api_text(self, user_message: str, system_prompt: str) -> str:
+        return ""
+
+    def get_model_name(self) -> str:
+        return "stub-1"
+
+
+class TestSeparateCompilation(unittest.TestCase):
+    """`compile` compiles imported English modules, each on its own."""
+
+    def test_compile_imports_english_modules(self):
+        from english_compiler.__main__ import main
+
+        frontend = _ModuleFrontend()
+        with tempfile.TemporaryDirectory() as tmpdir:
+            tmp = Path(tmpdir)
+            main_path, shapes_path = tmp / "main.txt", tmp / "lib" / "shapes.txt"
+            shapes_path.parent.mkdir()
+            main_path.write_text(MAIN_SOURCE, encoding="utf-8")
+            shapes_path.write_text(SHAPES_SOURCE, encoding="utf-8")
+
+            def compile_main(*flags: str) -> str:
+                buf = io.StringIO()
+                with (
+                    mock.patch.dict(
+                        os.environ, {"COREIL_CACHE_DIR": str(tmp / "cache"), "COREIL_REMOTE_CACHE": ""}
+                    ),
+                    mock.patch("english_compiler.frontend.get_frontend", return_value=frontend),
+                    redirect_stdout(buf),
+                ):
+                    self.assertEqual(main(["compile", *flags, str(main_path)]), 0, buf.getvalue())
+                return buf.getvalue()
+
+            output = compile_main("--frontend", "claude")
+            self.assertEqual(frontend.compiled, [MAIN_SOURCE, SHAPES_SOURCE])
+            self.assertTrue(output.endswith("12\n"), output)
+            module_coreil = tmp / "output" / "coreil" / "lib" / "shapes.coreil.json"
+            self.assertEqual(json.loads(module_coreil.read_text(encoding="utf-8"))["body"][0]["name"], "PI")
+            self.assertTrue((module_coreil.parent / "shapes.lock.json").is_file())
+
+            # Unchanged files are not compiled again
+            output = compile_main("--frontend", "claude", "--target", "python")
+            self.assertEqual(len(frontend.compiled), 2)
+            self.assertIn(f"Using cached Core IL from {module_coreil}", output)
+            # Other targets get one program with the module inlined
+            python_code = (tmp / "output" / "py" / "main.py").read_text(encoding="utf-8")
+            self.assertIn("shapes__circle_area", python_code)
+
+
 class TestPythonParity(unittest.TestCase):
     """Verify interpreter and Python backend produce identical output."""
 
@@ -459,5 +672,28 @@ class TestPythonParity(unittest.TestCase):
                 f"Parity mismatch!\nInterpreter: {interp_output!r}\nPython: {python_output!r}")
 
 
+@unittest.skipUnless(GO_AVAILABLE, "Go toolchain not available")
+class TestGoParity(unittest.TestCase):
+    """Linked programs run the same on the Go backend."""
+
+    def test_module_constants_on_go(self):
+        import subprocess
+
+        from english_compiler.coreil.go_cache import GoBuildCache
+
+        with tempfile.TemporaryDirectory() as tmpdir:
+            tmp = Path(tmpdir)
+            _write_module(tmp, "shapes", SHAPES)
+            doc = _make_doc([
+                {"type": "Import", "path": "shapes"},
+                {"type": "Print", "args": [_call("shapes.circle_area", _lit(2)), _var("shapes.PI")]},
+            ])
+            with mock.patch.dict(os.environ, {"COREIL_CACHE_DIR": str(tmp / "cache")}):
+                build = GoBuildCache().build(resolve_imports(doc, base_dir=tmp))
+            self.assertTrue(build.success, build.error)
+            result = subprocess.run([str(build.binary_path)], capture_output=True, text=True, timeout=30)
+            self.assertEqual(result.stdout, _run(doc, tmp))
+
+
 if __name__ == "__main__":
     unittest.main()
@@ -0,0 +1,243 @@
+"""Tests for function inlining."""
+
+from __future__ import annotations
+
+import io
+import json
+from contextlib import redirect_stdout
+
+from english_compiler.coreil.inline import inline_functions
+from english_compiler.coreil.interp import run_coreil
+from tests.test_helpers import GO_AVAILABLE, run_go_backend
+
+
+def _run_and_capture(doc: dict) -> str:
+    """Run a Core IL program and capture stdout."""
+    buf = io.StringIO()
+    with redirect_stdout(buf):
+        rc = run_coreil(doc)
+    assert rc == 0, f"Interpreter failed with exit code {rc}"
+    return buf.getvalue()
+
+
+def _make_program(body: list[dict], version: str = "coreil-1.9") -> dict:
+    return {"version": version, "body": body}
+
+
+def _lit(value) -> dict:
+    return {"type": "Literal", "value": value}
+
+
+def _var(name: str) -> dict:
+    return {"type": "Var", "name": name}
+
+
+def _binary(op: str, left: dict, right: dict) -> dict:
+    return {"type": "Binary", "op": op, "left": left, "right": right}
+
+
+def _call(name: str, *args: dict) -> dict:
+    return {"type": "Call", "name": name, "args": list(args)}
+
+
+def _ret(value: dict) -> dict:
+    return {"type": "Return", "value": value}
+
+
+SQUARE = {"type": "FuncDef", "name": "square", "params": ["x"], "body": [
+    _ret(_binary("*", _var("x"), _var("x"))),
+]}
+
+# if x < lo: return lo
+# if x > hi: return hi
+# return x
+CLAMP = {"type": "FuncDef", "name": "clamp", "params": ["x", "lo", "hi"], "body": [
+    {"type": "If", "test": _binary("<", _var("x"), _var("lo")), "then": [_ret(_var("lo"))]},
+    {"type": "If", "test": _binary(">", _var("x"), _var("hi")), "then": [_ret(_var("hi"))]},
+    _ret(_var("x")),
+]}
+
+FACT = {"type": "FuncDef", "name": "fact", "params": ["n"], "body": [
+    {"type": "If", "test": _binary("<=", _var("n"), _lit(1)), "then": [_ret(_lit(1))]},
+    _ret(_binary("*", _var("n"), _call("fact", _binary("-", _var("n"), _lit(1))))),
+]}
+
+
+def _range_loop(body: list[dict]) -> dict:
+    return {"type": "For", "var": "i", "iter": {
+        "type": "Range", "from": _lit(0), "to": _lit(5),
+    }, "body": body}
+
+
+def _clamp_program() -> dict:
+    return _make_program([
+        CLAMP,
+        {"type": "Let", "name": "total", "value": _lit(0)},
+        _range_loop([
+            {"type": "Assign", "name": "total", "value": _binary(
+                "+", _var("total"),
+                _call("clamp", _binary("*", _var("i"), _lit(3)), _lit(2), _lit(9)),
+            )},
+        ]),
+        {"type": "Print", "args": [_var("total")]},
+    ])
+
+
+def test_inline_single_return():
+    prog = _make_program([
+        SQUARE,
+        {"type": "Let", "name": "total", "value": _lit(0)},
+        _range_loop([
+            {"type": "Assign", "name": "total", "value": _binary(
+                "+", _var("total"), _call("square", _var("i")),
+            )},
+        ]),
+        {"type": "Print", "args": [_var("total")]},
+    ])
+    inlined = inline_functions(prog)
+    assign = inlined["body"][2]["body"][0]
+    assert assign["value"]["right"] == _binary("*", _var("i"), _var("i"))
+    # The definition stays for any callers outside the program
+    assert inlined["body"][0] == SQUARE
+    assert _run_and_capture(prog) == _run_and_capture(inlined) == "30\n"
+
+
+def test_inline_early_returns():
+    prog = _clamp_program()
+    inlined = inline_functions(prog)
+    loop_body = inlined["body"][2]["body"]
+    assert loop_body[0] == {
+        "type": "Let", "name": "__inl0_x", "value": _binary("*", _var("i"), _lit(3)),
+    }
+    assert loop_body[1] == {"type": "Let", "name": "__inl0", "value": _lit(None)}
+    # Statements after an If that returns move into its else branch
+    assert loop_body[2]["type"] == "If"
+    assert loop_body[2]["then"] == [{"type": "Assign", "name": "__inl0", "value": _lit(2)}]
+    assert loop_body[3]["value"]["right"] == _var("__inl0")
+    assert "Call" not in json.dumps(loop_body)
+    assert _run_and_capture(prog) == _run_and_capture(inlined) == "29\n"
+
+
+def test_inline_call_statement():
+    log = {"type": "FuncDef", "name": "log", "params": ["label", "value"], "body": [
+        {"type": "Print", "args": [_var("label"), _var("value")]},
+    ]}
+    prog = _make_program([
+        log,
+        {"type": "Let", "name": "xs", "value": {"type": "Array", "items": [_lit(1), _lit(2)]}},
+        {"type": "Call", "name": "log", "args": [_lit("size"), {"type": "Length", "base": _var("xs")}]},
+    ])
+    inlined = inline_functions(prog)
+    assert inlined["body"][2:] == [
+        {"type": "Let", "name": "__inl0_value", "value": {"type": "Length", "base": _var("xs")}},
+        {"type": "Print", "args": [_lit("size"), _var("__inl0_value")]},
+    ]
+    assert _run_and_capture(prog) == _run_and_capture(inlined) == "size 2\n"
+
+
+def test_recursive_function_not_inlined():
+    prog = _make_program([
+        FACT,
+        {"type": "Print", "args": [_call("fact", _lit(5))]},
+    ])
+    assert inline_functions(prog) == prog
+
+
+def test_large_function_only_inlined_when_hot():
+    """clamp is too big to inline everywhere, but fine inside a loop."""
+    prog = _make_program([
+        CLAMP,
+        {"type": "Print", "args": [_call("clamp", _lit(1), _lit(2), _lit(9))]},
+        {"type": "Print", "args": [_call("clamp", _lit(12), _lit(2), _lit(9))]},
+    ])
+    assert inline_functions(prog) == prog
+    hot = _clamp_program()
+    assert inline_functions(hot)["body"][2] != hot["body"][2]
+    assert inline_functions(hot, max_size=10) == hot
+
+
+def test_keeps_first_error():
+    """A call is not spliced ahead of an index that fails first."""
+    prog = _make_program([
+        CLAMP,
+        {"type": "Let", "name": "xs", "value": {"type": "Array", "items": [_lit(1)]}},
+        {"type": "Print", "args": [
+            {"type": "Index", "base": _var("xs"), "index": _lit(10)},
+            _call("clamp", _lit(1), _lit(2), _lit(9)),
+        ]},
+    ])
+    assert inline_functions(prog) == prog
+
+
+def test_keep_and_shadowed_globals():
+    scaled = {"type": "FuncDef", "name": "scaled", "params": ["x"], "body": [
+        _ret(_binary("*", _var("x"), _var("factor"))),
+    ]}
+    # Inside apply, "factor" is a local, so scaled's global would be shadowed
+    apply = {"type": "FuncDef", "name": "apply", "params": ["factor"], "body": [
+        _ret(_call("scaled", _var("factor"))),
+    ]}
+    prog = _make_program([
+        {"type": "Let", "name": "factor", "value": _lit(10)},
+        scaled,
+        apply,
+        {"type": "Print", "args": [_call("apply", _lit(3))]},
+    ])
+    inlined = inline_functions(prog, keep=["apply"])
+    assert inlined["body"][2] == apply
+    assert inlined["body"][3] == prog["body"][3]
+    assert _run_and_capture(prog) == _run_and_capture(inlined) == "30\n"
+
+
+def test_does_not_mutate_input():
+    prog = _clamp_program()
+    original = json.dumps(prog, sort_keys=True)
+    inline_functions(prog)
+    assert json.dumps(prog, sort_keys=True) == original
+
+
+def test_source_map_follows_statements():
+    prog = _make_program([
+        CLAMP,
+        {"type": "Print", "args": [_call("clamp", _lit(12), _lit(2), _lit(9))]},
+        {"type": "Print", "args": [_lit("done")]},
+    ])
+    prog["source_map"] = {"1": [0], "2": [1], "3": [2]}
+    inlined = inline_functions(prog)
+    assert len(inlined["body"]) == 5
+    assert inlined["source_map"] == {"1": [0], "2": [1, 2, 3], "3": [4]}
+
+
+def test_go_parity():
+    if not GO_AVAILABLE:
+        return
+    prog = _clamp_program()
+    result = run_go_backend(inline_functions(prog))
+    assert result.success, result.error
+    assert result.output == _run_and_capture(prog)
+
+
+def main() -> None:
+    tests = [
+        test_inline_single_return,
+        test_inline_early_returns,
+        test_inline_call_statement,
+        test_recursive_function_not_inlined,
+        test_large_function_only_inlined_when_hot,
+        test_keeps_first_error,
+        test_keep_and_shadowed_globals,
+        test_does_not_mutate_input,
+        test_source_map_follows_statements,
+        test_go_parity,
+    ]
+
+    print("Running function inlining tests...\n")
+    for test in tests:
+        test()
+        print(f"  {test.__name__}: ✓")
+
+    print(f"\nAll {len(tests)} function inlining tests passed! ✓")
+
+
+if __name__ == "__main__":
+    main()
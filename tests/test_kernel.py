@@ -0,0 +1,253 @@
+"""Tests for the Jupyter kernel."""
+
+from __future__ import annotations
+
+import io
+import json
+import os
+import tempfile
+from contextlib import redirect_stderr
+from pathlib import Path
+from unittest import mock
+
+from english_compiler.frontend.base import BaseFrontend
+from english_compiler.kernel import (
+    CellResult,
+    Kernel,
+    NotebookSession,
+    deserialize,
+    display_data,
+    install_kernel_spec,
+    new_message,
+    serialize,
+)
+from tests.test_helpers import GO_AVAILABLE
+
+KEY = b"secret"
+
+
+def _lit(value) -> dict:
+    return {"type": "Literal", "value": value}
+
+
+def _print(arg: dict) -> dict:
+    return {"type": "Print", "args": [arg]}
+
+
+def _person(name: str, age) -> dict:
+    return {"type": "Record", "fields": [{"name": "name", "value": _lit(name)}, {"name": "age", "value": _lit(age)}]}
+
+
+LOAD = 'Let people be ann aged 3 and bo aged 4.\nPrint "loaded".\n'
+SHOW = "Show the people."
+FAIL = "Print how many people there are, then the sixth person."
+
+_LET_PEOPLE = {"type": "Let", "name": "people", "value": {"type": "Array", "items": [_person("ann", 3), _person("bo", 4.5)]}}
+
+# What the stub frontend compiles each notebook so far to
+PROGRAMS = {
+    LOAD: {
+        "version": "coreil-1.9",
+        "body": [_LET_PEOPLE, _print(_lit("loaded"))],
+        "source_map": {"1": [0], "2": [1]},
+    },
+    LOAD + "\n" + SHOW: {
+        "version": "coreil-1.9",
+        "body": [_LET_PEOPLE, _print(_lit("loaded")), _print({"type": "Var", "name": "people"})],
+        "source_map": {"1": [0], "2": [1], "4": [2]},
+    },
+    LOAD + "\n" + SHOW + "\n\n" + FAIL: {
+        "version": "coreil-1.9",
+        "body": [
+            _LET_PEOPLE,
+            _print(_lit("loaded")),
+            _print({"type": "Var", "name": "people"}),
+            _print({"type": "Length", "base": {"type": "Var", "name": "people"}}),
+            _print({"type": "Index", "base": {"type": "Var", "name": "people"}, "index": _lit(5)}),
+        ],
+        "source_map": {"1": [0], "2": [1], "4": [2], "6": [3, 4]},
+    },
+}
+
+
+class _NotebookFrontend(BaseFrontend):
+    """Stub LLM frontend answering PROGRAMS."""
+
+    def generate_coreil_from_text(self, source_text: str) -> dict:
+        self.source_text = source_text
+        return super().generate_coreil_from_text(source_text)
+
+    def _call_api(self, user_message: str) -> dict:
+        return json.loads(json.dumps(PROGRAMS[self.source_text]))
+
+    def _call_api_text(self, user_message: str, system_prompt: str) -> str:
+        return ""
+
+    def get_model_name(self) -> str:
+        return "stub-1"
+
+
+class _Socket:
+    """Records the frames sent on a channel."""
+
+    def __init__(self) -> None:
+        self.sent: list[list[bytes]] = []
+
+    def send_multipart(self, frames: list[bytes]) -> None:
+        self.sent.append(frames)
+
+    def messages(self) -> list[dict]:
+        return [deserialize(frames, KEY)[1] for frames in self.sent]
+
+
+class _FixedSession:
+    """A notebook session whose every cell prints 'hi' and results in 3."""
+
+    def __init__(self) -> None:
+        self.cells: list[str] = []
+
+    def execute(self, text: str) -> CellResult:
+        self.cells.append(text)
+        return CellResult(stdout="hi\n", data={"text/plain": "3"})
+
+
+def test_message_signing():
+    message = new_message("kernel_info_request", {}, "client")
+    frames = serialize(message, KEY, [b"peer"])
+    assert frames[:2] == [b"peer", b"<IDS|MSG>"]
+    identities, decoded = deserialize(frames, KEY)
+    assert identities == [b"peer"] and decoded == message
+
+    tampered = frames[:-1] + [b'{"code": "rm"}']
+    for bad, key in ((tampered, KEY), (frames, b"other")):
+        try:
+            deserialize(bad, key)
+        except ValueError as exc:
+            assert "signature" in str(exc)
+        else:
+            raise AssertionError("expected a signature error")
+    # Without a key, messages are not signed
+    assert serialize(message, b"")[1] == b""
+
+
+def test_display_tables():
+    def text(value) -> dict:
+        return {"type": type(value).__name__, "text": str(value)}
+
+    records = {
+        "type": "array",
+        "length": 3,
+        "items": [
+            {"type": "record", "fields": ["name", "age"], "values": [text("<ann>"), text(3)], "length": 2},
+            {"type": "map", "keys": [text("name"), text("city")], "values": [text("bo"), text("Cork")], "length": 2},
+        ],
+    }
+    html = display_data(records, "[...]")["text/html"]
+    assert "<th>name</th>\n<th>age</th>\n<th>city</th>" in html
+    assert "<tr><td>&lt;ann&gt;</td><td>3</td><td></td></tr>" in html
+    assert "<tr><td>bo</td><td></td><td>Cork</td></tr>" in html
+    assert "<p>... 1 more rows</p>" in html
+
+    # Plain values and arrays of other things have only their printed form
+    assert display_data(text(3), "3") == {"text/plain": "3"}
+    numbers = {"type": "array", "length": 1, "items": [text(1)]}
+    assert display_data(numbers, "[1]") == {"text/plain": "[1]"}
+
+
+def test_cells_share_state():
+    if not GO_AVAILABLE:
+        return
+    with tempfile.TemporaryDirectory() as tmp_dir, mock.patch.dict(
+        os.environ, {"COREIL_CACHE_DIR": tmp_dir, "COREIL_REMOTE_CACHE": ""}
+    ):
+        session = NotebookSession(_NotebookFrontend())
+        first = session.execute(LOAD)
+        assert first.ok and first.stdout == "" and first.data == {"text/plain": "loaded"}
+
+        # 'people' carries over; the earlier cell's output is hidden
+        second = session.execute(SHOW)
+        assert second.ok and second.stdout == ""
+        assert second.data["text/plain"] == "[Record(name='ann', age=3), Record(name='bo', age=4.5)]"
+        assert "<tr><td>bo</td><td>4.5</td></tr>" in second.data["text/html"]
+
+        third = session.execute(FAIL)
+        assert third.stdout == "2\n"
+        assert third.error[0] == "RuntimeError" and "index" in third.error[1].lower()
+        # Failed cells are not kept
+        assert session.cells == [LOAD, SHOW]
+
+
+def test_kernel_messages():
+    sockets = {name: _Socket() for name in ("shell", "control", "stdin", "iopub")}
+    session = _FixedSession()
+    kernel = Kernel(session, sockets, KEY)
+
+    request = new_message("execute_request", {"code": "Print hi.", "silent": False}, "client")
+    kernel.handle("shell", serialize(request, KEY, [b"peer"]))
+    assert session.cells == ["Print hi."]
+    [frames] = sockets["shell"].sent
+    identities, reply = deserialize(frames, KEY)
+    assert identities == [b"peer"]
+    assert reply["header"]["msg_type"] == "execute_reply"
+    assert reply["parent_header"]["msg_id"] == request["header"]["msg_id"]
+    assert reply["content"] == {"status": "ok", "execution_count": 1, "user_expressions": {}, "payload": []}
+    published = sockets["iopub"].messages()
+    assert [m["header"]["msg_type"] for m in published] == [
+        "status", "execute_input", "stream", "execute_result", "status",
+    ]
+    assert published[2]["content"] == {"name": "stdout", "text": "hi\n"}
+    assert published[3]["content"]["data"] == {"text/plain": "3"}
+    assert sockets["iopub"].sent[0][0].endswith(b".status")
+
+    # Messages with a bad signature are dropped
+    forged = serialize(request, b"wrong", [b"peer"])
+    with redirect_stderr(io.StringIO()) as err:
+        kernel.handle("shell", forged)
+    assert "signature is invalid" in err.getvalue()
+    assert len(sockets["shell"].sent) == 1
+
+    info = new_message("kernel_info_request", {}, "client")
+    kernel.handle("shell", serialize(info, KEY, [b"peer"]))
+    content = sockets["shell"].messages()[-1]["content"]
+    assert content["protocol_version"] == "5.3"
+    assert content["language_info"]["name"] == "english"
+
+    kernel.handle("control", serialize(new_message("shutdown_request", {"restart": False}, "client"), KEY))
+    assert kernel.stopped
+    assert sockets["control"].messages()[-1]["content"] == {"status": "ok", "restart": False}
+
+
+def test_install_kernel_spec():
+    with tempfile.TemporaryDirectory() as tmp_dir:
+        with mock.patch.dict(os.environ, {"JUPYTER_DATA_DIR": tmp_dir}):
+            spec_dir = install_kernel_spec("claude")
+        assert spec_dir == Path(tmp_dir) / "kernels" / "english"
+        spec = json.loads((spec_dir / "kernel.json").read_text(encoding="utf-8"))
+        assert spec["argv"][1:] == [
+            "-m", "english_compiler", "kernel", "-f", "{connection_file}", "--frontend", "claude",
+        ]
+        assert spec["language"] == "english"
+
+        prefixed = install_kernel_spec(prefix=Path(tmp_dir) / "venv")
+        assert prefixed == Path(tmp_dir) / "venv" / "share" / "jupyter" / "kernels" / "english"
+
+
+def main() -> None:
+    tests = [
+        test_message_signing,
+        test_display_tables,
+        test_cells_share_state,
+        test_kernel_messages,
+        test_install_kernel_spec,
+    ]
+
+    print("Running Jupyter kernel tests...\n")
+    for test in tests:
+        test()
+        print(f"  {test.__name__}: ✓")
+
+    print(f"\nAll {len(tests)} Jupyter kernel tests passed! ✓")
+
+
+if __name__ == "__main__":
+    main()
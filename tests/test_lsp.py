@@ -0,0 +1,245 @@
+"""Tests for the language server."""
+
+from __future__ import annotations
+
+import io
+import json
+import os
+import tempfile
+from pathlib import Path
+from unittest import mock
+
+from english_compiler.cli.io_utils import sha256_bytes, sha256_file
+from english_compiler.frontend.base import BaseFrontend
+from english_compiler.lsp import LanguageServer, compile_source, read_message, serve, write_message
+
+URI = "file:///tmp/prog.txt"
+
+SOURCE = "Let total be 5.\nLet doubled be total times 2.\nPrint doubled.\n"
+
+PROGRAM = {
+    "version": "coreil-1.9",
+    "body": [
+        {"type": "Let", "name": "total", "value": {"type": "Literal", "value": 5}},
+        {"type": "Let", "name": "doubled", "value": {
+            "type": "Binary", "op": "*",
+            "left": {"type": "Var", "name": "total"},
+            "right": {"type": "Literal", "value": 2},
+        }},
+        {"type": "Print", "args": [{"type": "Var", "name": "doubled"}]},
+    ],
+    "source_map": {"1": [0], "2": [1], "3": [2]},
+}
+
+BROKEN_SOURCE = 'Let total be "none".\nPrint total minus 1.\n'
+
+BROKEN = {
+    "version": "coreil-1.9",
+    "body": [
+        {"type": "Let", "name": "total", "value": {"type": "Literal", "value": "none"}},
+        {"type": "Print", "args": [{
+            "type": "Binary", "op": "-",
+            "left": {"type": "Var", "name": "total"},
+            "right": {"type": "Literal", "value": 1},
+        }]},
+    ],
+    "source_map": {"1": [0], "2": [1]},
+}
+
+
+class _StubFrontend(BaseFrontend):
+    """Stub LLM frontend answering a fixed program per source and counting its calls."""
+
+    def __init__(self, programs: dict[str, dict]) -> None:
+        super().__init__()
+        self.programs = programs
+        self.calls = 0
+
+    def generate_coreil_from_text(self, source_text: str) -> dict:
+        self.source_text = source_text
+        return super().generate_coreil_from_text(source_text)
+
+    def _call_api(self, user_message: str) -> dict:
+        self.calls += 1
+        return json.loads(json.dumps(self.programs[self.source_text]))
+
+    def _call_api_text(self, user_message: str, system_prompt: str) -> str:
+        return ""
+
+    def get_model_name(self) -> str:
+        return "stub-1"
+
+
+def _isolated_cache(tmp_dir: str):
+    return mock.patch.dict(os.environ, {"COREIL_CACHE_DIR": tmp_dir, "COREIL_REMOTE_CACHE": ""})
+
+
+def _messages(out: io.BytesIO) -> list[dict]:
+    stream = io.BytesIO(out.getvalue())
+    messages = []
+    while (message := read_message(stream)) is not None:
+        messages.append(message)
+    return messages
+
+
+def _open(server: LanguageServer, text: str) -> None:
+    server.handle({
+        "jsonrpc": "2.0",
+        "method": "textDocument/didOpen",
+        "params": {"textDocument": {"uri": URI, "languageId": "english", "version": 1, "text": text}},
+    })
+
+
+def _request(server: LanguageServer, out: io.BytesIO, method: str, line: int, character: int):
+    """Send a request at a position and return its result."""
+    request_id = len(_messages(out))
+    server.handle({
+        "jsonrpc": "2.0",
+        "id": request_id,
+        "method": method,
+        "params": {
+            "textDocument": {"uri": URI},
+            "position": {"line": line, "character": character},
+            "context": {"includeDeclaration": True},
+        },
+    })
+    return next(m for m in _messages(out) if m.get("id") == request_id)["result"]
+
+
+def _span(line: int, start: int, end: int) -> dict:
+    return {"start": {"line": line, "character": start}, "end": {"line": line, "character": end}}
+
+
+def test_framing():
+    out = io.BytesIO()
+    write_message(out, {"jsonrpc": "2.0", "id": 1, "result": "é"})
+    raw = out.getvalue()
+    header, body = raw.split(b"\r\n\r\n")
+    assert header == f"Content-Length: {len(body)}".encode("ascii")
+    stream = io.BytesIO(raw)
+    assert read_message(stream) == {"jsonrpc": "2.0", "id": 1, "result": "é"}
+    assert read_message(stream) is None
+
+
+def test_diagnostics_on_sentences():
+    with tempfile.TemporaryDirectory() as tmp_dir, _isolated_cache(tmp_dir):
+        out = io.BytesIO()
+        server = LanguageServer(lambda: _StubFrontend({BROKEN_SOURCE: BROKEN}), out, background=False)
+        _open(server, BROKEN_SOURCE)
+    [published] = _messages(out)
+    assert published["method"] == "textDocument/publishDiagnostics"
+    [diagnostic] = published["params"]["diagnostics"]
+    assert diagnostic["message"] == "the word 'total' is used as both text and a number in sentence 1 and sentence 2"
+    # On the sentence of the failing statement, pointing back at the other
+    assert diagnostic["range"] == _span(1, 0, 20)
+    assert diagnostic["code"] == "$.body[1].args[0]"
+    [related] = diagnostic["relatedInformation"]
+    assert related["location"] == {"uri": URI, "range": _span(0, 0, 20)}
+
+    # Closing the file clears its diagnostics
+    server.handle({"jsonrpc": "2.0", "method": "textDocument/didClose", "params": {"textDocument": {"uri": URI}}})
+    assert _messages(out)[-1]["params"] == {"uri": URI, "diagnostics": []}
+
+
+def test_hover_and_navigation():
+    with tempfile.TemporaryDirectory() as tmp_dir, _isolated_cache(tmp_dir):
+        out = io.BytesIO()
+        server = LanguageServer(lambda: _StubFrontend({SOURCE: PROGRAM}), out, background=False)
+        _open(server, SOURCE)
+    assert _messages(out)[0]["params"]["diagnostics"] == []
+
+    hover = _request(server, out, "textDocument/hover", 1, 3)
+    assert hover["contents"]["value"].startswith("Sentence 2 compiles to:\n\n```json\n")
+    assert '"name": "doubled"' in hover["contents"]["value"]
+    assert hover["range"] == _span(1, 0, 29)
+
+    # From the use of 'total' in sentence 2 to its definition in sentence 1
+    assert _request(server, out, "textDocument/definition", 1, 17) == [{"uri": URI, "range": _span(0, 4, 9)}]
+
+    assert _request(server, out, "textDocument/references", 0, 6) == [
+        {"uri": URI, "range": _span(0, 4, 9)},
+        {"uri": URI, "range": _span(1, 15, 20)},
+    ]
+
+    # Words that name no quantity, and positions between sentences
+    assert _request(server, out, "textDocument/definition", 1, 22) == []
+    assert _request(server, out, "textDocument/hover", 3, 0) is None
+
+
+def test_compile_reuses_lock_and_cache():
+    with tempfile.TemporaryDirectory() as tmp_dir, _isolated_cache(tmp_dir):
+        source = Path(tmp_dir) / "prog.txt"
+        frontend = _StubFrontend({SOURCE: PROGRAM})
+        assert compile_source(source, SOURCE, frontend).coreil["body"] == PROGRAM["body"]
+        assert frontend.calls == 1
+        # Second time from the frontend cache
+        assert compile_source(source, SOURCE, frontend).violations == []
+        assert frontend.calls == 1
+
+        # The Core IL `compile` locked for this source wins over the frontend
+        output = Path(tmp_dir) / "output" / "coreil"
+        output.mkdir(parents=True)
+        locked = {**PROGRAM, "body": PROGRAM["body"][:1]}
+        (output / "prog.coreil.json").write_text(json.dumps(locked), encoding="utf-8")
+        lock = {
+            "source_sha256": sha256_bytes(SOURCE.encode("utf-8")),
+            "coreil_sha256": sha256_file(output / "prog.coreil.json"),
+        }
+        (output / "prog.lock.json").write_text(json.dumps(lock), encoding="utf-8")
+        assert compile_source(source, SOURCE, frontend).coreil == locked
+        # ... but not for other text
+        assert compile_source(source, SOURCE + "\n", frontend).coreil != locked
+
+
+def test_serve_session():
+    def message(body: dict) -> bytes:
+        buf = io.BytesIO()
+        write_message(buf, {"jsonrpc": "2.0", **body})
+        return buf.getvalue()
+
+    def no_frontend():
+        raise RuntimeError("No API key found")
+
+    session = b"".join([
+        message({"id": 1, "method": "initialize", "params": {"capabilities": {}}}),
+        message({"method": "initialized", "params": {}}),
+        message({"method": "textDocument/didOpen", "params": {
+            "textDocument": {"uri": URI, "languageId": "english", "version": 1, "text": SOURCE},
+        }}),
+        message({"id": 2, "method": "workspace/symbol", "params": {"query": ""}}),
+        message({"id": 3, "method": "shutdown"}),
+        message({"method": "exit"}),
+    ])
+    out = io.BytesIO()
+    assert serve(no_frontend, io.BytesIO(session), out) == 0
+    messages = {m.get("id", m.get("method")): m for m in _messages(out)}
+    capabilities = messages[1]["result"]["capabilities"]
+    assert capabilities["hoverProvider"] and capabilities["definitionProvider"]
+    assert messages[2]["error"]["code"] == -32601
+    assert messages[3]["result"] is None
+    [diagnostic] = messages["textDocument/publishDiagnostics"]["params"]["diagnostics"]
+    assert diagnostic["message"] == "No API key found"
+
+    # Exiting without shutting down first
+    assert serve(no_frontend, io.BytesIO(message({"method": "exit"})), io.BytesIO()) == 1
+
+
+def main() -> None:
+    tests = [
+        test_framing,
+        test_diagnostics_on_sentences,
+        test_hover_and_navigation,
+        test_compile_reuses_lock_and_cache,
+        test_serve_session,
+    ]
+
+    print("Running language server tests...\n")
+    for test in tests:
+        test()
+        print(f"  {test.__name__}: ✓")
+
+    print(f"\nAll {len(tests)} language server tests passed! ✓")
+
+
+if __name__ == "__main__":
+    main()
@@ -154,6 +154,65 @@ def test_identity_and_true():
     assert _run_and_capture(prog) == _run_and_capture(optimized)
 
 
+def test_identity_empty_string_concat():
+    """"" + s should simplify to s only when s is known to be a string."""
+    upper = {"type": "StringUpper", "base": _var("name")}
+    prog = _make_program([
+        {"type": "Let", "name": "name", "value": _lit("ada")},
+        {"type": "Let", "name": "a", "value": _binary("+", _lit(""), upper)},
+        {"type": "Let", "name": "b", "value": _binary("+", _var("name"), _lit(""))},
+        {"type": "Print", "args": [_var("a"), _var("b")]},
+    ])
+    optimized = optimize(prog)
+    assert optimized["body"][1]["value"] == upper
+    # name could be a number at runtime, where the concatenation must raise
+    assert optimized["body"][2]["value"]["type"] == "Binary"
+    assert _run_and_capture(prog) == _run_and_capture(optimized)
+
+
+def test_fold_pure_builtins():
+    """Pure builtins on literal arguments are evaluated at compile time."""
+    exprs = [
+        {"type": "StringUpper", "base": _lit("total")},
+        {"type": "StringLength", "base": {"type": "StringTrim", "base": _lit("  ab  ")}},
+        {"type": "StringContains", "base": _lit("hello"), "substring": _lit("ell")},
+        {"type": "StringReplace", "base": _lit("a-b-c"), "old": _lit("-"), "new": _lit("+")},
+        {"type": "ToInt", "value": _lit("-42")},
+        {"type": "ToString", "value": _binary("*", _lit(6), _lit(7))},
+        {"type": "Math", "op": "floor", "arg": _lit(2.5)},
+        {"type": "Math", "op": "sqrt", "arg": _lit(16)},
+        {"type": "MathPow", "base": _lit(2), "exponent": _lit(10)},
+    ]
+    prog = _make_program([{"type": "Print", "args": exprs}])
+    optimized = optimize(prog)
+    assert optimized["body"][0]["args"] == [
+        _lit("TOTAL"), _lit(2), _lit(True), _lit("a+b+c"), _lit(-42), _lit("42"),
+        _lit(2), _lit(4.0), _lit(1024.0),
+    ]
+    assert _run_and_capture(prog) == _run_and_capture(optimized)
+
+
+def test_no_fold_unsafe_builtins():
+    """Builtins that raise, or that backends evaluate differently, are kept."""
+    exprs = [
+        {"type": "ToInt", "value": _lit("12abc")},
+        {"type": "StringUpper", "base": _lit("straße")},
+        {"type": "StringUpper", "base": _lit(5)},
+        {"type": "ToString", "value": _lit(0.1)},
+        {"type": "Math", "op": "sqrt", "arg": _lit(-1)},
+        {"type": "Math", "op": "sin", "arg": _lit(1)},
+        {"type": "MathPow", "base": _lit(2), "exponent": _lit(0.5)},
+        # Lossy, or outside the 64-bit range Go raises OverflowError for
+        {"type": "ToInt", "value": _lit(2.5)},
+        {"type": "ToInt", "value": _lit(1e21)},
+        {"type": "ToInt", "value": _lit("99999999999999999999")},
+        {"type": "Math", "op": "floor", "arg": _lit(1e21)},
+        {"type": "Math", "op": "ceil", "arg": _lit(-1e19)},
+    ]
+    optimized = optimize(_make_program([{"type": "Print", "args": exprs}]))
+    assert optimized["body"][0]["args"] == exprs
+
+
 def test_not_folding():
     """not true should fold to false."""
     prog = _make_program([
@@ -166,6 +225,23 @@ def test_not_folding():
     assert _run_and_capture(prog) == _run_and_capture(optimized)
 
 
+def test_constant_false_if_runs_else():
+    """if false ... else ... should always run the else branch."""
+    prog = _make_program([
+        {
+            "type": "If",
+            "test": _lit(False),
+            "then": [{"type": "Print", "args": [_lit(1)]}],
+            "else": [{"type": "Print", "args": [_lit(2)]}],
+        },
+    ])
+    optimized = optimize(prog)
+    if_stmt = optimized["body"][0]
+    assert if_stmt["test"] == {"type": "Literal", "value": True}
+    assert "else" not in if_stmt
+    assert _run_and_capture(optimized) == "2\n"
+
+
 def test_does_not_mutate_input():
     """Optimizer should not mutate the input program."""
     prog = _make_program([
@@ -317,7 +393,11 @@ def main() -> None:
         test_identity_add_zero,
         test_identity_multiply_one,
         test_identity_and_true,
+        test_identity_empty_string_concat,
+        test_fold_pure_builtins,
+        test_no_fold_unsafe_builtins,
         test_not_folding,
+        test_constant_false_if_runs_else,
         test_does_not_mutate_input,
         test_nested_folding,
         test_complex_program_parity,
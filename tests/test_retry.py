@@ -9,7 +9,7 @@ from unittest.mock import MagicMock, patch
 # Ensure project root is importable
 sys.path.insert(0, str(__import__("pathlib").Path(__file__).resolve().parents[1]))
 
-from english_compiler.frontend.base import BaseFrontend, _build_user_message
+from english_compiler.frontend.base import BaseFrontend, RepairError, _build_user_message
 
 
 # ---------------------------------------------------------------------------
@@ -30,6 +30,20 @@ INVALID_COREIL = {
     ],
 }
 
+# Schema-valid, but subtracts a number from a string
+MISTYPED_COREIL = {
+    "version": "coreil-1.9",
+    "body": [
+        {"type": "Let", "name": "x", "value": {"type": "Literal", "value": "5"}},
+        {"type": "Print", "args": [{
+            "type": "Binary", "op": "-",
+            "left": {"type": "Var", "name": "x"},
+            "right": {"type": "Literal", "value": 1},
+        }]},
+    ],
+    "source_map": {"1": [0], "2": [1]},
+}
+
 
 class _StubFrontend(BaseFrontend):
     """Stub frontend that returns canned responses in sequence."""
@@ -38,8 +52,10 @@ class _StubFrontend(BaseFrontend):
         super().__init__()
         self._responses = list(responses)
         self._call_count = 0
+        self.messages: list[str] = []
 
     def _call_api(self, user_message: str) -> dict:
+        self.messages.append(user_message)
         resp = self._responses[min(self._call_count, len(self._responses) - 1)]
         self._call_count += 1
         return resp
@@ -103,6 +119,47 @@ def test_max_retries_one():
     print("  PASS test_max_retries_one")
 
 
+def test_repairs_verifier_violations():
+    """Output that validates but fails type checking is sent back for repair."""
+    fe = _StubFrontend([MISTYPED_COREIL, VALID_COREIL])
+    result = fe.generate_coreil_from_text("Let x be \"5\".\nPrint x minus 1.")
+    assert result == VALID_COREIL
+    assert fe._call_count == 2
+    assert "operator '-' cannot be applied to str and int" in fe.messages[1]
+    print("  PASS test_repairs_verifier_violations")
+
+
+def test_final_failure_is_annotated():
+    """The error after the last attempt names each violation, its English line and its English form."""
+    fe = _StubFrontend([MISTYPED_COREIL])
+    try:
+        fe.generate_coreil_from_text("Let x be \"5\".\nPrint x minus 1.", max_retries=1)
+        assert False, "Expected RepairError"
+    except RepairError as exc:
+        assert exc.attempts == 1
+        assert exc.coreil == MISTYPED_COREIL
+        assert [v["path"] for v in exc.violations] == ["$.body[1].args[0]"]
+        assert str(exc).splitlines()[1:] == [
+            "  $.body[1].args[0]: operator '-' cannot be applied to str and int (line 2): Print x minus 1.",
+            "    the word 'x' is used as both text and a number in sentence 1 and sentence 2",
+        ]
+    print("  PASS test_final_failure_is_annotated")
+
+
+def test_attaches_sentence_spans():
+    """Output with a source_map gets the spans of the English sentences."""
+    mapped = {**VALID_COREIL, "source_map": {"2": [0]}}
+    fe = _StubFrontend([json.loads(json.dumps(mapped))])
+    result = fe.generate_coreil_from_text("\nPrint hello.")
+    assert result["body"] == VALID_COREIL["body"]
+    assert result["sentences"] == [
+        {"line": 2, "column": 1, "end_line": 2, "end_column": 12, "text": "Print hello."}
+    ]
+    # Without a source_map there is nothing to attribute sentences to
+    assert "sentences" not in _StubFrontend([VALID_COREIL]).generate_coreil_from_text("Print hello.")
+    print("  PASS test_attaches_sentence_spans")
+
+
 def test_retry_message_includes_previous_output():
     """Retry message should include the failed Core IL and errors."""
     errors = [{"message": "missing args", "path": "$.body[0]"}]
@@ -142,6 +199,9 @@ if __name__ == "__main__":
     test_retry_succeeds_on_third_try()
     test_exhausts_all_retries()
     test_max_retries_one()
+    test_repairs_verifier_violations()
+    test_final_failure_is_annotated()
+    test_attaches_sentence_spans()
     test_retry_message_includes_previous_output()
     test_retry_message_no_previous_output()
     test_retry_message_truncates_large_coreil()
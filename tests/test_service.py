@@ -0,0 +1,234 @@
+"""Tests for the evaluation service."""
+
+from __future__ import annotations
+
+import json
+import os
+import tempfile
+from unittest import mock
+
+from english_compiler.frontend.base import BaseFrontend
+from english_compiler.service import (
+    Evaluator,
+    ServerLimits,
+    decode_message,
+    encode_message,
+    value_message,
+)
+from tests.test_helpers import GO_AVAILABLE
+
+
+def _lit(value) -> dict:
+    return {"type": "Literal", "value": value}
+
+
+def _print(*args: dict) -> dict:
+    return {"type": "Print", "args": list(args)}
+
+
+def _program(*body: dict) -> str:
+    return json.dumps({"version": "coreil-1.9", "body": list(body)})
+
+
+SOURCE = 'Print "counting".\nPrint the numbers one to three.\nPrint the fifth number.\n'
+
+_NUMBERS = {"type": "Array", "items": [_lit(1), _lit(2), _lit(3)]}
+
+
+class _StubFrontend(BaseFrontend):
+    """Stub LLM frontend compiling SOURCE."""
+
+    def _call_api(self, user_message: str) -> dict:
+        return {
+            "version": "coreil-1.9",
+            "body": [
+                _print(_lit("counting")),
+                _print(_NUMBERS),
+                _print({"type": "Index", "base": _NUMBERS, "index": _lit(4)}),
+            ],
+            "source_map": {"1": [0], "2": [1], "3": [2]},
+        }
+
+    def _call_api_text(self, user_message: str, system_prompt: str) -> str:
+        return ""
+
+    def get_model_name(self) -> str:
+        return "stub-1"
+
+
+def _events(rpc, request: dict) -> tuple[bytes, dict, list[dict]]:
+    """Run an RPC through the wire encoding: (stdout, result, events)."""
+    wire = [decode_message("RunEvent", encode_message("RunEvent", event)) for event in rpc(request)]
+    assert "result" in wire[-1] and not any("result" in event for event in wire[:-1])
+    stdout = b"".join(e["output"]["data"] for e in wire if "output" in e and e["output"]["stream"] == "STDOUT")
+    return stdout, wire[-1]["result"], wire
+
+
+def _isolated_cache():
+    tmp_dir = tempfile.TemporaryDirectory()
+    patch = mock.patch.dict(os.environ, {"COREIL_CACHE_DIR": tmp_dir.name, "COREIL_REMOTE_CACHE": ""})
+    return tmp_dir, patch
+
+
+def test_protobuf_encoding():
+    # The wire format matches protoc's
+    assert encode_message("Limits", {"max_steps": 150}) == b"\x08\x96\x01"
+    assert encode_message("Limits", {"max_steps": 0, "timeout_seconds": 0.0}) == b""
+    assert encode_message("Value", {"int": -1}) == b"\x10" + b"\xff" * 9 + b"\x01"
+
+    event = {"result": {
+        "status": "LIMIT_EXCEEDED",
+        "exit_code": 3,
+        "error": "limit exceeded: steps",
+        "value": {"array": {"items": [{"int": -7}, {"str": "é"}, {"none": True}, {"float": 0.5}], "length": 9}},
+        "output_bytes": 12,
+        "elapsed_seconds": 0.25,
+    }}
+    assert decode_message("RunEvent", encode_message("RunEvent", event)) == event
+
+    # Oneof members and optional fields are sent even when zero
+    assert decode_message("Value", encode_message("Value", {"int": 0})) == {"int": 0}
+    request = decode_message("EvalRequest", encode_message("EvalRequest", {"coreil_json": "{}", "seed": 0}))
+    assert request == {"coreil_json": "{}", "argv": [], "seed": 0}
+    assert "seed" not in decode_message("EvalRequest", b"")
+    output = decode_message("RunEvent", encode_message("RunEvent", {"output": {"stream": "STDOUT", "data": b""}}))
+    assert output == {"output": {"stream": "STDOUT", "data": b""}}
+
+    # Unknown fields are skipped
+    assert decode_message("Other", b"\x98\x06\x01" + encode_message("Other", {"text": "x"}))["text"] == "x"
+    try:
+        decode_message("Other", b"\x12\x05ab")
+    except ValueError:
+        pass
+    else:
+        raise AssertionError("expected a truncated message error")
+
+
+def test_values_and_limits():
+    def text(value) -> dict:
+        return {"type": type(value).__name__, "text": str(value)}
+
+    display = {"type": "array", "length": 2, "items": [
+        {"type": "record", "fields": ["name"], "values": [text("ann")], "length": 1},
+        {"type": "map", "keys": [text(1)], "values": [text(True)], "length": 5},
+    ]}
+    assert value_message(display) == {"array": {"length": 2, "items": [
+        {"record": {"fields": ["name"], "values": [{"str": "ann"}]}},
+        {"map": {"keys": [{"int": 1}], "values": [{"bool": True}], "length": 5}},
+    ]}}
+    assert value_message({"type": "None", "text": "None"}) == {"none": True}
+    assert value_message({"type": "float", "text": "2.5"}) == {"float": 2.5}
+    assert value_message({"type": "set", "text": "{1}"}) == {"other": {"type": "set", "text": "{1}"}}
+
+    server = ServerLimits(max_steps=1000, max_memory_bytes=0, timeout_seconds=5.0, max_output_bytes=0)
+    limits = server.clamp({"max_steps": 10, "max_memory_bytes": 4096, "timeout_seconds": 60.0})
+    assert (limits.max_steps, limits.max_memory_bytes, limits.timeout_seconds) == (10, 4096, 5.0)
+    assert server.clamp(None).max_steps == 1000
+    assert limits.env() == {"COREIL_MAX_STEPS": "10", "COREIL_MAX_MEMORY": "4096", "COREIL_TIMEOUT": "5"}
+
+
+def test_eval_streams_output():
+    if not GO_AVAILABLE:
+        return
+    tmp_dir, patch = _isolated_cache()
+    with tmp_dir, patch:
+        evaluator = Evaluator(_StubFrontend)
+        program = _program(
+            _print(_lit("sum"), _lit(1)),
+            _print({"type": "Call", "name": "argv", "args": []}),
+            {"type": "Let", "name": "r", "value": {"type": "Record", "fields": [{"name": "a", "value": _lit(2)}]}},
+            _print({"type": "Var", "name": "r"}),
+        )
+        stdout, result, _ = _events(evaluator.eval, {"coreil_json": program, "argv": ["x", "y"]})
+        assert stdout == b"sum 1\n['x', 'y']\nRecord(a=2)\n"
+        assert result["status"] == "OK" and result["exit_code"] == 0
+        assert result["value"] == {"record": {"fields": ["a"], "values": [{"int": 2}]}}
+        assert result["output_bytes"] == len(stdout)
+
+        # Only a single-value print ending the program is a result
+        _, result, _ = _events(evaluator.eval, {"coreil_json": _program(_print(_lit(1), _lit(2)))})
+        assert result["status"] == "OK" and "value" not in result
+
+        # The standard library links in
+        padded = _program({"type": "Import", "path": "std.strings"},
+                          _print({"type": "Call", "name": "strings.pad_left", "args": [_lit("7"), _lit(3), _lit("0")]}))
+        stdout, result, _ = _events(evaluator.eval, {"coreil_json": padded})
+        assert stdout == b"007\n" and result["value"] == {"str": "007"}
+
+        # Seeded programs repeat
+        roll = _program(_print({"type": "ExternalCall", "module": "random", "function": "randint", "args": [_lit(1), _lit(1000000)]}))
+        first, _, _ = _events(evaluator.eval, {"coreil_json": roll, "seed": 7})
+        second, _, _ = _events(evaluator.eval, {"coreil_json": roll, "seed": 7})
+        assert first and first == second
+
+
+def test_eval_limits():
+    if not GO_AVAILABLE:
+        return
+    tmp_dir, patch = _isolated_cache()
+    with tmp_dir, patch:
+        evaluator = Evaluator(_StubFrontend, ServerLimits(timeout_seconds=10.0))
+        forever = _program({"type": "While", "test": _lit(True), "body": [_print(_lit("tick"))]})
+
+        _, result, _ = _events(evaluator.eval, {"coreil_json": forever, "limits": {"max_steps": 50}})
+        assert result["status"] == "LIMIT_EXCEEDED" and result["exit_code"] == 3
+        assert result["error"].startswith("limit exceeded")
+
+        stdout, result, _ = _events(evaluator.eval, {"coreil_json": forever, "limits": {"max_output_bytes": 100}})
+        assert result["status"] == "LIMIT_EXCEEDED" and "output" in result["error"]
+        assert len(stdout) == 100 and result["output_bytes"] == 100
+
+        quiet = _program({"type": "While", "test": _lit(True), "body": []})
+        _, result, _ = _events(evaluator.eval, {"coreil_json": quiet, "limits": {"timeout_seconds": 0.5}})
+        assert result["status"] == "LIMIT_EXCEEDED" and result["elapsed_seconds"] < 5
+
+        _, result, _ = _events(evaluator.eval, {"coreil_json": "{not json"})
+        assert result["status"] == "INVALID_PROGRAM" and "invalid Core IL JSON" in result["error"]
+        _, result, _ = _events(evaluator.eval, {"coreil_json": _program(_print({"type": "Var", "name": "ghost"}))})
+        assert result["status"] == "INVALID_PROGRAM" and "ghost" in result["error"]
+        _, result, _ = _events(evaluator.eval, {"coreil_json": _program({"type": "Import", "path": "helpers"})})
+        assert result["status"] == "INVALID_PROGRAM" and result["error"].startswith("Import error")
+
+
+def test_compile_and_run():
+    if not GO_AVAILABLE:
+        return
+    tmp_dir, patch = _isolated_cache()
+    with tmp_dir, patch:
+        evaluator = Evaluator(_StubFrontend)
+        stdout, result, events = _events(evaluator.compile_and_run, {"source": SOURCE})
+        compiled = json.loads(events[0]["compiled"]["coreil_json"])
+        assert [stmt["type"] for stmt in compiled["body"]] == ["Print", "Print", "Print"]
+        assert stdout == b"counting\n[1, 2, 3]\n"
+        assert result["status"] == "RUNTIME_ERROR" and result["exit_code"] == 1
+        # Errors name the English sentence that failed
+        stderr = b"".join(e["output"]["data"] for e in events if e.get("output", {}).get("stream") == "STDERR")
+        assert b"Print the fifth number." in stderr
+        assert result["error"]
+
+        # A frontend that cannot start is a compile error
+        def broken():
+            raise RuntimeError("no API key")
+        _, result, _ = _events(Evaluator(broken).compile_and_run, {"source": SOURCE})
+        assert result["status"] == "COMPILE_ERROR" and result["error"] == "no API key"
+
+
+def main() -> None:
+    tests = [
+        test_protobuf_encoding,
+        test_values_and_limits,
+        test_eval_streams_output,
+        test_eval_limits,
+        test_compile_and_run,
+    ]
+
+    print("Running evaluation service tests...\n")
+    for test in tests:
+        test()
+        print(f"  {test.__name__}: ✓")
+
+    print(f"\nAll {len(tests)} evaluation service tests passed! ✓")
+
+
+if __name__ == "__main__":
+    main()
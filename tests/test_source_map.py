@@ -6,6 +6,7 @@ Tests cover:
 - compose_source_maps function
 - Python emitter coreil_line_map tracking
 - Round-trip composition
+- Sentence attribution and the "sentences" span metadata
 """
 
 from __future__ import annotations
@@ -18,7 +19,13 @@ sys.path.insert(0, str(Path(__file__).resolve().parent.parent))
 
 from english_compiler.coreil.validate import validate_coreil
 from english_compiler.coreil.emit import emit_python
-from english_compiler.coreil.source_map import compose_source_maps
+from english_compiler.coreil.source_map import (
+    compose_source_maps,
+    document_sentences,
+    remap_replaced_statements,
+    sentence_spans,
+    statement_sentences,
+)
 from english_compiler.frontend.mock_llm import MockFrontend
 
 
@@ -183,6 +190,14 @@ def test_compose_sorts_output():
     print("  test_compose_sorts_output: passed")
 
 
+def test_remap_replaced_statements():
+    """Replacements take over their statement's lines; removed lines are dropped."""
+    english_to_coreil = {"1": [0], "2": [1, 2], "3": [3]}
+    result = remap_replaced_statements(english_to_coreil, [1, 3, 0, 0])
+    assert result == {"1": [0], "2": [1, 2, 3]}, f"Got {result}"
+    print("  test_remap_replaced_statements: passed")
+
+
 # ========== Python Emitter Line Map Tests ==========
 
 def test_python_emitter_line_map():
@@ -250,6 +265,45 @@ def test_round_trip_composition():
     print("  test_round_trip_composition: passed")
 
 
+def test_statement_sentences():
+    """Statements sharing a line map to successive sentences on it."""
+    text = "Set total to 0.\nSet count to 3. Print the average\nof total and count.\n\nPrint done"
+    sentences = statement_sentences(text, {"1": [0], "2": [1, 2], "3": [2], "5": [3]})
+    assert [sentences[i].sentence_id for i in range(4)] == [1, 2, 3, 4], sentences
+    third = sentences[2]
+    assert (third.line, third.column) == (2, 17), third
+    assert third.text == "Print the average of total and count.", third
+    assert sentences[3].text == "Print done"
+    assert statement_sentences("", {"1": [0]}) == {}
+
+    print("  test_statement_sentences: passed")
+
+
+def test_sentence_spans():
+    """Spans give where each sentence starts and ends, and validate."""
+    text = "Set total to 0.\nSet count to 3. Print the average\nof total and count.\n"
+    spans = sentence_spans(text)
+    assert spans[2] == {
+        "line": 2, "column": 17, "end_line": 3, "end_column": 19,
+        "text": "Print the average of total and count.",
+    }, spans
+    doc = _prog(
+        [{"type": "Print", "args": [_lit(1)]}, {"type": "Print", "args": [_lit(2)]}],
+        source_map={"1": [0], "2": [1]},
+    )
+    doc["sentences"] = spans
+    assert validate_coreil(doc) == []
+    # Without the source text, statements are attributed from the spans
+    assert document_sentences(doc) == statement_sentences(text, doc["source_map"])
+    assert document_sentences(doc)[1].text == "Set count to 3."
+    assert document_sentences({"body": [], "sentences": spans}) == {}
+
+    doc["sentences"] = [{"line": 0, "column": 1, "end_line": 1, "end_column": 2}]
+    messages = [e["message"] for e in validate_coreil(doc)]
+    assert messages == ["line must be a positive integer", "missing or invalid text"], messages
+    print("  test_sentence_spans: passed")
+
+
 def main():
     print("Running source map tests...\n")
 
@@ -271,6 +325,7 @@ def main():
     test_compose_missing_coreil_index()
     test_compose_empty()
     test_compose_sorts_output()
+    test_remap_replaced_statements()
 
     # Emitter line map
     test_python_emitter_line_map()
@@ -279,7 +334,11 @@ def main():
     # Round-trip
     test_round_trip_composition()
 
-    print(f"\nAll 16 source map tests passed!")
+    # Sentence attribution
+    test_statement_sentences()
+    test_sentence_spans()
+
+    print(f"\nAll 19 source map tests passed!")
 
 
 if __name__ == "__main__":
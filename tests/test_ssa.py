@@ -0,0 +1,365 @@
+"""Tests for the SSA middle-end and the pass manager."""
+
+from __future__ import annotations
+
+import io
+import json
+from contextlib import redirect_stdout
+
+from english_compiler.coreil.interp import run_coreil
+from english_compiler.coreil.pass_manager import (
+    Pass,
+    PassContext,
+    PassError,
+    PassManager,
+    default_passes,
+)
+from english_compiler.coreil.ssa import Define, build_ssa, format_ssa, verify_ssa
+from english_compiler.coreil.ssa_lower import lower_ssa
+from english_compiler.coreil.ssa_opt import eliminate_dead_values, propagate_constants
+from tests.test_helpers import GO_AVAILABLE, run_go_backend
+
+
+def _run_and_capture(doc: dict) -> str:
+    """Run a Core IL program and capture stdout."""
+    buf = io.StringIO()
+    with redirect_stdout(buf):
+        rc = run_coreil(doc)
+    assert rc == 0, f"Interpreter failed with exit code {rc}"
+    return buf.getvalue()
+
+
+def _make_program(body: list[dict], version: str = "coreil-1.9") -> dict:
+    return {"version": version, "body": body}
+
+
+def _lit(value) -> dict:
+    return {"type": "Literal", "value": value}
+
+
+def _var(name: str) -> dict:
+    return {"type": "Var", "name": name}
+
+
+def _binary(op: str, left: dict, right: dict) -> dict:
+    return {"type": "Binary", "op": op, "left": left, "right": right}
+
+
+def _let(name: str, value: dict) -> dict:
+    return {"type": "Let", "name": name, "value": value}
+
+
+def _assign(name: str, value: dict) -> dict:
+    return {"type": "Assign", "name": name, "value": value}
+
+
+def _print(*args: dict) -> dict:
+    return {"type": "Print", "args": list(args)}
+
+
+def _range_loop(var: str, stop: int, body: list[dict]) -> dict:
+    return {"type": "For", "var": var, "iter": {
+        "type": "Range", "from": _lit(0), "to": _lit(stop),
+    }, "body": body}
+
+
+def _optimize_ssa(doc: dict) -> dict:
+    module = build_ssa(doc)
+    propagate_constants(module)
+    eliminate_dead_values(module)
+    assert verify_ssa(module) == []
+    return lower_ssa(module)
+
+
+# total = 0; n = 0
+# while n < 4: n = n + 1; if n == 2: continue; total = total + n
+# print total
+COUNTDOWN = _make_program([
+    _let("total", _lit(0)),
+    _let("n", _lit(0)),
+    {"type": "While", "test": _binary("<", _var("n"), _lit(4)), "body": [
+        _assign("n", _binary("+", _var("n"), _lit(1))),
+        {"type": "If", "test": _binary("==", _var("n"), _lit(2)), "then": [{"type": "Continue"}]},
+        _assign("total", _binary("+", _var("total"), _var("n"))),
+    ]},
+    _print(_var("total")),
+])
+
+
+def test_build_phis():
+    prog = _make_program([
+        _let("x", _lit(1)),
+        {"type": "If", "test": _var("x"), "then": [_assign("x", _lit(2))]},
+        _print(_var("x")),
+    ])
+    module = build_ssa(prog)
+    assert verify_ssa(module) == []
+    assert format_ssa(module).splitlines() == [
+        "top level:",
+        "  x#0 = 1",
+        "  if x#0:",
+        "    x#1 = 2",
+        "  x#2 = phi(then: x#1, else: x#0)",
+        "  Print([x#2])",
+    ]
+
+
+def test_build_loop_phis():
+    module = build_ssa(COUNTDOWN)
+    assert verify_ssa(module) == []
+    text = format_ssa(module)
+    assert "n#2 = phi(entry: n#1, end: n#4, continue0: n#4)" in text
+    assert "total#3 = phi(entry: total#0, end: total#5, continue0: total#3)" in text
+    assert "while (n#2 < 4):" in text
+    # The header is the only way out, so no exit phis are needed
+    assert text.endswith("Print([total#3])")
+
+
+def test_memory_variables():
+    """Globals read by functions and variables assigned in a TryCatch stay named."""
+    prog = _make_program([
+        _let("scale", _lit(3)),
+        {"type": "FuncDef", "name": "scaled", "params": ["x"], "body": [
+            {"type": "Return", "value": _binary("*", _var("x"), _var("scale"))},
+        ]},
+        _let("status", _lit("ok")),
+        {"type": "TryCatch", "body": [_assign("status", _lit("tried"))],
+         "catch_var": "e", "catch_body": []},
+        _print(_var("status"), {"type": "Call", "name": "scaled", "args": [_lit(2)]}),
+    ])
+    module = build_ssa(prog)
+    assert module.main.memory >= {"scale", "status", "e"}
+    assert verify_ssa(module) == []
+    assert lower_ssa(module)["body"] == prog["body"]
+
+
+def test_verify_reports_violations():
+    module = build_ssa(COUNTDOWN)
+    first = module.main.body[0]
+    assert isinstance(first, Define)
+    # Read the value before it is defined, then define it twice
+    module.main.body.insert(0, Define(first.dest, {"type": "Var", "name": "total", "ssa": first.dest.id}))
+    errors = verify_ssa(module)
+    assert any("where it is not defined" in error for error in errors)
+    assert any("defined more than once" in error for error in errors)
+
+
+def test_round_trip():
+    assert lower_ssa(build_ssa(COUNTDOWN)) == COUNTDOWN
+    assert _run_and_capture(lower_ssa(build_ssa(COUNTDOWN))) == "8\n"
+
+
+def test_propagate_through_branches_and_loops():
+    prog = _make_program([
+        _let("limit", _lit(3)),
+        {"type": "If", "test": _var("limit"), "then": [_assign("limit", _lit(3))]},
+        _let("total", _lit(0)),
+        _range_loop("i", 4, [
+            _assign("total", _binary("+", _var("total"), _var("limit"))),
+        ]),
+        _print(_var("limit"), _var("total")),
+    ])
+    optimized = _optimize_ssa(prog)
+    # Both paths give limit 3, so the If goes and limit is folded everywhere
+    assert optimized["body"] == [
+        _let("total", _lit(0)),
+        _range_loop("i", 4, [_assign("total", _binary("+", _var("total"), _lit(3)))]),
+        _print(_lit(3), _var("total")),
+    ]
+    assert _run_and_capture(optimized) == _run_and_capture(prog) == "3 12\n"
+
+
+def test_fold_literal_tests():
+    prog = _make_program([
+        _let("debug", _lit(False)),
+        _let("x", _lit(1)),
+        {"type": "If", "test": _var("debug"), "then": [_assign("x", _lit(2))],
+         "else": [_assign("x", _binary("+", _var("x"), _lit(10)))]},
+        {"type": "While", "test": _var("debug"), "body": [_print(_lit("never"))]},
+        _print(_var("x")),
+    ])
+    optimized = _optimize_ssa(prog)
+    assert optimized["body"] == [_print(_lit(11))]
+    assert _run_and_capture(optimized) == "11\n"
+
+
+def test_copies_renamed_apart():
+    """A propagated copy that outlives its variable's next value gets a new name."""
+    prog = _make_program([
+        _let("x", _lit(5)),
+        {"type": "While", "test": _binary("<", _var("x"), _lit(8)), "body": [
+            _let("before", _var("x")),
+            _assign("x", _binary("+", _var("x"), _lit(1))),
+            _print(_var("before"), _var("x")),
+        ]},
+    ])
+    optimized = _optimize_ssa(prog)
+    assert "x_1" in json.dumps(optimized)
+    assert "before" not in json.dumps(optimized)
+    assert _run_and_capture(optimized) == _run_and_capture(prog) == "5 6\n6 7\n7 8\n"
+
+
+def test_parallel_copies():
+    """Swapping two variables in a loop needs a temporary once copies are propagated."""
+    prog = _make_program([
+        _let("a", _lit(1)),
+        _let("b", _lit(2)),
+        _range_loop("i", 3, [
+            _let("t", _var("a")),
+            _assign("a", _var("b")),
+            _assign("b", _var("t")),
+        ]),
+        _print(_var("a"), _var("b")),
+    ])
+    optimized = _optimize_ssa(prog)
+    loop_body = optimized["body"][2]["body"]
+    assert loop_body == [
+        _let("a_1", _var("a")),
+        _assign("a", _var("b")),
+        _assign("b", _var("a_1")),
+    ]
+    assert _run_and_capture(optimized) == _run_and_capture(prog) == "2 1\n"
+
+
+def test_dead_values_keep_errors():
+    prog = _make_program([
+        _let("xs", {"type": "Array", "items": [_lit(1)]}),
+        _let("unused", _lit(4)),
+        _let("risky", {"type": "Index", "base": _var("xs"), "index": _lit(3)}),
+        _print(_lit("done")),
+    ])
+    optimized = _optimize_ssa(prog)
+    assert [stmt["name"] for stmt in optimized["body"] if stmt["type"] == "Let"] == ["xs", "risky"]
+
+
+def test_source_map_follows_statements():
+    prog = _make_program([
+        _let("x", _lit(2)),
+        _let("y", _binary("*", _var("x"), _lit(3))),
+        _print(_var("y")),
+    ])
+    prog["source_map"] = {"1": [0], "2": [1], "3": [2]}
+    optimized = _optimize_ssa(prog)
+    assert optimized["body"] == [_print(_lit(6))]
+    assert optimized["source_map"] == {"3": [0]}
+
+
+def test_pass_manager_orders_passes():
+    seen = []
+
+    def record(name: str) -> Pass:
+        return Pass(name, lambda doc, context: seen.append(name) or doc, after=order[name])
+
+    order = {"a": ("c",), "b": (), "c": ("b",), "d": ("missing",)}
+    manager = PassManager([record(name) for name in order])
+    context = PassContext()
+    manager.run(COUNTDOWN, context)
+    assert seen == context.ran == ["b", "c", "a", "d"]
+
+    order = {"a": ("b",), "b": ("a",)}
+    try:
+        PassManager([record(name) for name in order])
+    except ValueError as exc:
+        assert "cycle" in str(exc)
+    else:
+        raise AssertionError("expected a cycle error")
+
+
+def test_pass_manager_converts_lazily():
+    forms = []
+
+    def note(form: str):
+        return lambda program, context: forms.append((form, type(program).__name__)) or (
+            program if form == "coreil" else None
+        )
+
+    manager = PassManager([
+        Pass("one", note("ssa"), ir="ssa"),
+        Pass("two", note("ssa"), ir="ssa"),
+        Pass("three", note("coreil")),
+    ])
+    assert manager.run(COUNTDOWN) == COUNTDOWN
+    assert forms == [("ssa", "Module"), ("ssa", "Module"), ("coreil", "dict")]
+
+
+def test_pass_manager_rejects_broken_pass():
+    def break_ssa(module, context):
+        module.main.body.insert(0, module.main.body[0])
+
+    def break_coreil(doc, context):
+        return {**doc, "body": [_print(_var("nowhere"))]}
+
+    for broken in (Pass("breaks-ssa", break_ssa, ir="ssa"), Pass("breaks-coreil", break_coreil)):
+        try:
+            PassManager([broken]).run(COUNTDOWN)
+        except PassError as exc:
+            assert broken.name in str(exc)
+        else:
+            raise AssertionError(f"{broken.name} was not caught")
+    # Without verification the broken document goes through
+    assert PassManager([Pass("breaks-coreil", break_coreil)], verify=False).run(COUNTDOWN)
+
+
+def test_default_pipeline():
+    prog = _make_program([_let("never", _lit(0))] + COUNTDOWN["body"] + [
+        {"type": "While", "test": _lit(True), "body": [
+            {"type": "Break"},
+            _print(_lit("unreachable")),
+        ]},
+    ])
+    context = PassContext()
+    optimized = PassManager(default_passes()).run(prog, context)
+    assert context.ran == ["dead-code", "inline", "fold", "ssa-propagate", "ssa-dce", "licm", "cse"]
+    assert [r["kind"] for r in context.reports["dead-code"]] == ["unused-variable", "unreachable"]
+    assert _run_and_capture(optimized) == _run_and_capture(prog) == "8\n"
+
+
+def test_go_parity():
+    if not GO_AVAILABLE:
+        return
+    prog = _make_program([
+        _let("a", _lit(1)),
+        _let("b", _lit(2)),
+        _range_loop("i", 3, [
+            _let("t", _var("a")),
+            _assign("a", _var("b")),
+            _assign("b", _var("t")),
+            _print(_var("a"), _var("b")),
+        ]),
+    ])
+    for doc in (prog, COUNTDOWN):
+        result = run_go_backend(PassManager(default_passes()).run(doc))
+        assert result.success, result.error
+        assert result.output == _run_and_capture(doc)
+
+
+def main() -> None:
+    tests = [
+        test_build_phis,
+        test_build_loop_phis,
+        test_memory_variables,
+        test_verify_reports_violations,
+        test_round_trip,
+        test_propagate_through_branches_and_loops,
+        test_fold_literal_tests,
+        test_copies_renamed_apart,
+        test_parallel_copies,
+        test_dead_values_keep_errors,
+        test_source_map_follows_statements,
+        test_pass_manager_orders_passes,
+        test_pass_manager_converts_lazily,
+        test_pass_manager_rejects_broken_pass,
+        test_default_pipeline,
+        test_go_parity,
+    ]
+
+    print("Running SSA and pass manager tests...\n")
+    for test in tests:
+        test()
+        print(f"  {test.__name__}: ✓")
+
+    print(f"\nAll {len(tests)} SSA and pass manager tests passed! ✓")
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,291 @@
+"""Tests for static type inference and typed Go code generation."""
+
+from __future__ import annotations
+
+import io
+from contextlib import redirect_stdout
+
+from english_compiler.coreil.emit_go import emit_go
+from english_compiler.coreil.interp import run_coreil
+from english_compiler.coreil.static_types import (
+    BOOL,
+    FLOAT,
+    INT,
+    STR,
+    check_types,
+    expr_type,
+    infer_types,
+)
+from tests.test_helpers import GO_AVAILABLE, run_go_backend
+
+
+def _run_and_capture(doc: dict) -> str:
+    """Run a Core IL program and capture stdout."""
+    buf = io.StringIO()
+    with redirect_stdout(buf):
+        rc = run_coreil(doc)
+    assert rc == 0, f"Interpreter failed with exit code {rc}"
+    return buf.getvalue()
+
+
+def _make_program(body: list[dict], version: str = "coreil-1.9") -> dict:
+    return {"version": version, "body": body}
+
+
+def _lit(value) -> dict:
+    return {"type": "Literal", "value": value}
+
+
+def _var(name: str) -> dict:
+    return {"type": "Var", "name": name}
+
+
+def _binary(op: str, left: dict, right: dict) -> dict:
+    return {"type": "Binary", "op": op, "left": left, "right": right}
+
+
+def _let(name: str, value: dict) -> dict:
+    return {"type": "Let", "name": name, "value": value}
+
+
+def _assign(name: str, value: dict) -> dict:
+    return {"type": "Assign", "name": name, "value": value}
+
+
+def _print(*args: dict) -> dict:
+    return {"type": "Print", "args": list(args)}
+
+
+def _if(test: dict, then: list[dict], orelse: list[dict] | None = None) -> dict:
+    node = {"type": "If", "test": test, "then": then}
+    if orelse is not None:
+        node["else"] = orelse
+    return node
+
+
+def _range_loop(var: str, stop: dict, body: list[dict]) -> dict:
+    return {"type": "For", "var": var, "iter": {
+        "type": "Range", "from": _lit(0), "to": stop,
+    }, "body": body}
+
+
+# total = 0; n = 0
+# while n < 4: n = n + 1; total = total + n * n
+# print total
+SQUARES = _make_program([
+    _let("total", _lit(0)),
+    _let("n", _lit(0)),
+    {"type": "While", "test": _binary("<", _var("n"), _lit(4)), "body": [
+        _assign("n", _binary("+", _var("n"), _lit(1))),
+        _assign("total", _binary("+", _var("total"), _binary("*", _var("n"), _var("n")))),
+    ]},
+    _print(_var("total")),
+])
+
+
+def test_infer_loop_variables():
+    assert infer_types(SQUARES) == {None: {"total": INT, "n": INT}}
+
+    prog = _make_program([
+        _let("mean", _lit(0.0)),
+        _let("label", _lit("")),
+        _range_loop("i", _lit(3), [
+            _assign("mean", _binary("+", _var("mean"), _binary("/", _var("i"), _lit(3)))),
+            _assign("label", _binary("+", _var("label"), {"type": "ToString", "value": _var("i")})),
+        ]),
+        _let("done", _binary(">", _var("mean"), _lit(1))),
+        _print(_var("mean"), _var("label"), _var("done")),
+    ])
+    assert infer_types(prog)[None] == {"mean": FLOAT, "label": STR, "i": INT, "done": BOOL}
+
+
+def test_mixed_assignments_are_dynamic():
+    prog = _make_program([
+        _let("c", {"type": "Index", "base": {"type": "Array", "items": [_lit(1)]}, "index": _lit(0)}),
+        _let("x", _lit(1)),
+        _let("y", _lit(1)),
+        _let("ratio", _lit(1)),
+        _if(_var("c"), [_assign("x", _lit("one")), _assign("y", _lit(2))], [_assign("y", _lit(3))]),
+        _assign("ratio", _binary("/", _var("ratio"), _lit(2))),
+        _print(_var("x"), _var("y"), _var("ratio")),
+    ])
+    # c is an array element; an int becomes a float or a string
+    assert infer_types(prog)[None] == {"y": INT}
+
+
+def test_inference_is_flow_based():
+    # z holds a string, then an int: z is dynamic, but w only ever sees the int
+    prog = _make_program([
+        _let("z", _lit("a")),
+        _print(_var("z")),
+        _assign("z", _lit(5)),
+        _let("w", _binary("+", _var("z"), _lit(1))),
+        _print(_var("w")),
+    ])
+    assert infer_types(prog)[None] == {"w": INT}
+    code, _ = emit_go(prog)
+    assert "w := valueAdd(z, ValueInt(1)).intData()" in code, code
+
+
+def test_dynamic_sources():
+    deque = {"type": "DequeNew"}
+    prog = _make_program([
+        {"type": "FuncDef", "name": "scale", "params": ["p"], "body": [
+            _let("k", _binary("*", _var("p"), _lit(3))),
+            _let("s", _lit("")),
+            _assign("s", _binary("+", _var("s"), {"type": "ToString", "value": _var("k")})),
+            {"type": "Return", "value": _var("s")},
+        ]},
+        _let("q", deque),
+        {"type": "PushBack", "base": _var("q"), "value": _lit(1)},
+        _let("item", _lit(0)),
+        {"type": "PopFront", "base": _var("q"), "target": "item"},
+        {"type": "ForEach", "var": "each", "iter": {"type": "Array", "items": [_lit(1)]}, "body": [
+            _print(_var("each")),
+        ]},
+        _let("caught", _lit(0)),
+        {"type": "TryCatch", "body": [_assign("caught", _lit(1))], "catch_var": "e", "catch_body": []},
+        _print({"type": "Call", "name": "scale", "args": [_lit(1)]}, _var("item"), _var("caught")),
+    ])
+    # A parameter, a popped item, a ForEach variable and a variable assigned
+    # in a TryCatch are dynamic, and so is an int times a dynamic value
+    assert infer_types(prog) == {None: {}, "scale": {"s": STR}}
+
+
+def test_expr_type():
+    var_types = {"i": INT, "f": FLOAT, "s": STR}
+
+    def typed(expr):
+        return expr_type(expr, lambda var: var_types.get(var["name"]))
+
+    assert typed(_binary("+", _var("i"), _lit(1))) == INT
+    assert typed(_binary("*", _var("i"), _var("f"))) == FLOAT
+    assert typed(_binary("/", _var("i"), _lit(2))) == FLOAT
+    assert typed(_binary("%", _var("i"), _lit(2))) == INT
+    assert typed(_binary("+", _var("s"), _var("s"))) == STR
+    assert typed(_binary("*", _var("s"), _lit(2))) is None
+    assert typed(_binary("+", _var("i"), _lit(True))) is None
+    assert typed(_binary("+", _var("d"), _lit(1))) is None
+    # Comparisons and and/or give a bool whatever they compare
+    assert typed(_binary("<", _var("d"), _var("s"))) == BOOL
+    assert typed(_binary("or", _var("d"), _lit(0))) == BOOL
+    assert typed({"type": "Ternary", "test": _var("d"), "consequent": _lit(1), "alternate": _var("i")}) == INT
+    assert typed({"type": "Ternary", "test": _var("d"), "consequent": _lit(1), "alternate": _var("f")}) is None
+    assert typed({"type": "Math", "op": "floor", "arg": _var("f")}) == INT
+    assert typed({"type": "Math", "op": "abs", "arg": _var("f")}) == FLOAT
+    assert typed({"type": "StringLength", "base": _var("d")}) == INT
+    assert typed({"type": "Index", "base": _var("d"), "index": _var("i")}) is None
+
+
+def test_check_types():
+    prog = _make_program([
+        _let("name", _lit("Ada")),
+        _let("n", _lit(2)),
+        _print(_binary("-", _var("name"), _var("n"))),
+        _print(_binary("*", _var("name"), _var("n"))),  # repetition is fine
+        _print(_binary("+", _var("name"), _lit(1.5))),
+        _print(_binary("<", _var("n"), _var("name"))),
+        {"type": "FuncDef", "name": "f", "params": ["x"], "body": [
+            _let("label", _lit("x")),
+            {"type": "Return", "value": _binary("+", _var("label"), _var("x"))},  # x is dynamic
+            _print(_binary("/", _var("label"), _lit(2))),
+        ]},
+    ])
+    assert check_types(prog) == [
+        {"message": "operator '-' cannot be applied to str and int", "path": "$.body[2].args[0]"},
+        {"message": "operator '+' cannot be applied to str and float", "path": "$.body[4].args[0]"},
+        {"message": "operator '<' cannot be applied to int and str", "path": "$.body[5].args[0]"},
+        {"message": "operator '/' cannot be applied to str and int", "path": "$.body[6].body[2].args[0]"},
+    ]
+    assert check_types(SQUARES) == []
+
+
+def test_codegen_native_variables():
+    code, _ = emit_go(SQUARES)
+    assert "total := int64(0)" in code, code
+    assert "if !(n < int64(4)) { break }" in code, code
+    assert "total = (total + (n * n))" in code, code
+    assert "coreilPrint([]Value{ValueInt(total)})" in code, code
+
+    untyped, _ = emit_go(SQUARES, typed=False)
+    assert "total := ValueInt(0)" in untyped, untyped
+    assert "total = valueAdd(total, valueMultiply(n, n))" in untyped, untyped
+
+
+def test_codegen_counted_loop():
+    prog = _make_program([
+        _let("n", _lit(5)),
+        _let("total", _lit(0.0)),
+        _range_loop("i", _var("n"), [
+            _assign("total", _binary("+", _var("total"), _binary("*", _var("i"), _lit(0.5)))),
+        ]),
+        _print(_var("total")),
+    ])
+    code, _ = emit_go(prog)
+    assert "__to := n\n" in code, code
+    assert "i := __i\n" in code, code
+    # The product is rounded before the addition, as with Values
+    assert "total = (total + float64(float64(i) * 0.5))" in code, code
+
+
+def test_go_parity():
+    if not GO_AVAILABLE:
+        return
+    prog = _make_program([
+        _let("n", _lit(0)),
+        _let("x", _lit(0.1)),
+        _let("s", _lit("")),
+        _let("flag", _lit(False)),
+        _range_loop("i", _lit(7), [
+            _assign("n", _binary("+", _var("n"), _binary("%", _binary("-", _lit(0), _var("i")), _lit(3)))),
+            _assign("x", _binary("+", _binary("*", _var("x"), _lit(3.0)), _binary("/", _var("i"), _lit(4)))),
+            _assign("s", _binary("+", _var("s"), {"type": "ToString", "value": _var("i")})),
+            _assign("flag", {"type": "Not", "arg": _var("flag")}),
+            _if(_binary("and", _var("flag"), _binary(">=", _var("x"), _var("i"))), [
+                _print(_var("i"), _var("x")),
+            ]),
+        ]),
+        _let("m", _binary("%", _lit(-5.5), _binary("+", _var("x"), _lit(2)))),
+        _print(_var("n"), _var("x"), _var("s"), {"type": "StringLength", "base": _var("s")}, _var("flag"), _var("m")),
+        _let("zero", _lit(0)),
+        {"type": "TryCatch", "body": [
+            _print(_binary("/", _var("x"), _var("zero"))),
+        ], "catch_var": "e", "catch_body": [_print(_lit("caught"))]},
+        # The Assign is unreachable, so n stays an int; it must still compile
+        _range_loop("j", _lit(2), [
+            _assign("n", _binary("+", _var("n"), _var("j"))),
+            {"type": "Break"},
+            _assign("n", _lit("never")),
+        ]),
+        _print(_var("n")),
+    ])
+    for doc in (prog, SQUARES):
+        assert infer_types(doc)[None].get("n") == INT
+        result = run_go_backend(doc)
+        assert result.success, result.error
+        assert result.output == _run_and_capture(doc)
+
+
+def main() -> None:
+    tests = [
+        test_infer_loop_variables,
+        test_mixed_assignments_are_dynamic,
+        test_inference_is_flow_based,
+        test_dynamic_sources,
+        test_expr_type,
+        test_check_types,
+        test_codegen_native_variables,
+        test_codegen_counted_loop,
+        test_go_parity,
+    ]
+
+    print("Running static type inference tests...\n")
+    for test in tests:
+        test()
+        print(f"  {test.__name__}: ✓")
+
+    print(f"\nAll {len(tests)} static type inference tests passed! ✓")
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,198 @@
+"""Tests for the Core IL standard library."""
+
+from __future__ import annotations
+
+import json
+import tempfile
+from pathlib import Path
+
+from english_compiler.coreil.module import (
+    ImportNameError,
+    ModuleNotFoundError,
+    load_module_doc,
+    module_exports,
+    resolve_imports,
+)
+from english_compiler.coreil.stdlib import (
+    STDLIB_VERSION,
+    load_stdlib_module,
+    stdlib_module_path,
+    stdlib_modules,
+    stdlib_reference,
+)
+from english_compiler.coreil.verify import verify_coreil
+from english_compiler.frontend.base import load_system_prompt
+from tests.test_helpers import GO_AVAILABLE, run_go_backend, run_interpreter
+
+
+def _lit(value) -> dict:
+    return {"type": "Literal", "value": value}
+
+
+def _call(name: str, *args: dict) -> dict:
+    return {"type": "Call", "name": name, "args": list(args)}
+
+
+def _print(*args: dict) -> dict:
+    return {"type": "Print", "args": list(args)}
+
+
+def _array(*values) -> dict:
+    return {"type": "Array", "items": [_lit(value) for value in values]}
+
+
+def _program(modules: list[str], *body: dict) -> dict:
+    imports = [{"type": "Import", "path": f"std.{name}"} for name in modules]
+    return {"version": "coreil-1.11", "body": imports + list(body)}
+
+
+def _run(doc: dict) -> str:
+    result = run_interpreter(resolve_imports(doc))
+    assert result.success, result.error
+    return result.output
+
+
+_MARCH_1 = _call("datetime.date", _lit(2024), _lit(3), _lit(1))
+
+# (expression, interpreter output) for each module
+CASES = {
+    "strings": [
+        (_call("strings.pad_left", _lit("7"), _lit(3), _lit("0")), "007"),
+        (_call("strings.title", _lit("the quick fox")), "The Quick Fox"),
+        (_call("strings.words", _lit("  a  b\tc ")), "['a', 'b', 'c']"),
+        (_call("strings.count", _lit("banana"), _lit("an")), "2"),
+        (_call("strings.truncate", _lit("hello world"), _lit(8)), "hello..."),
+    ],
+    "math": [
+        ({"type": "Var", "name": "math.PI"}, "3.141592653589793"),
+        (_call("math.gcd", _lit(12), _lit(-18)), "6"),
+        (_call("math.mean", _array(1, 2)), "1.5"),
+        (_call("math.is_prime", _lit(97)), "True"),
+        (_call("math.round_to", _lit(3.14159), _lit(2)), "3.14"),
+    ],
+    "collections": [
+        (_call("collections.unique", _array(3, 1, 3, 2, 1)), "[3, 1, 2]"),
+        (_call("collections.chunk", _array(1, 2, 3, 4, 5), _lit(2)), "[[1, 2], [3, 4], [5]]"),
+        (_call("collections.sorted", _array(5, 2, 9, 1)), "[1, 2, 5, 9]"),
+        (_call("collections.counts", _array("a", "b", "a")), "{'a': 2, 'b': 1}"),
+    ],
+    "json": [
+        (_call("json.stringify", _call("json.parse", _lit('{"a": [1, 2]}'))), '{"a": [1, 2]}'),
+        (_call("json.is_valid", _lit("{")), "False"),
+        (_call("json.parse_or", _lit("nope"), _lit(0)), "0"),
+    ],
+    "datetime": [
+        (_call("datetime.format_date", _call("datetime.add_days", _MARCH_1, _lit(-1))), "2024-02-29"),
+        (_call("datetime.weekday_name", _MARCH_1), "Friday"),
+        (_call("datetime.days_between", _call("datetime.parse_date", _lit("2000-01-01")), _MARCH_1), "8826"),
+        (_call("datetime.format_datetime", _call("datetime.from_timestamp", _lit(1700000000))), "2023-11-14 22:13:20"),
+        (_call("datetime.to_timestamp", _call("datetime.from_timestamp", _lit(1700000000))), "1700000000"),
+    ],
+}
+
+
+def test_modules_are_documented():
+    assert stdlib_modules() == sorted(CASES)
+    reference = stdlib_reference()
+    assert f"std {STDLIB_VERSION}" in reference
+    for name in stdlib_modules():
+        doc = load_module_doc(stdlib_module_path(f"std.{name}"))
+        module = load_stdlib_module(name)
+        assert module.doc
+        assert {export.name for export in module.exports} == module_exports(doc) == set(doc["docs"])
+        for export in module.exports:
+            assert export.doc, f"std.{name}.{export.name} is undocumented"
+            assert f"- {export.signature(name)}: {export.doc}" in reference
+    assert load_stdlib_module("strings").exports[0].signature("strings") == "strings.repeat(text, times)"
+
+    # Frontends are told about it, and cached compilations follow its changes
+    assert load_system_prompt().endswith(reference)
+
+
+def test_modules_run():
+    for name, cases in CASES.items():
+        doc = _program([name], *(_print(expr) for expr, _ in cases))
+        assert verify_coreil(doc) == []
+        assert _run(doc).splitlines() == [expected for _, expected in cases], name
+
+
+def test_resolution():
+    # Standard library imports need no base directory, and may be aliased
+    doc = _program([], {"type": "Import", "path": "std.strings", "alias": "s"},
+                   _print(_call("s.repeat", _lit("ab"), _lit(2))))
+    assert _run(doc) == "abab\n"
+
+    try:
+        resolve_imports(_program(["regex"]))
+    except ModuleNotFoundError as exc:
+        assert "std.regex" in str(exc) and "std.strings" in str(exc)
+    else:
+        raise AssertionError("expected an unknown module error")
+
+    # Private helpers stay private
+    try:
+        resolve_imports(_program(["datetime"], _print(_call("datetime.floor_div", _lit(7), _lit(2)))))
+    except ImportNameError as exc:
+        assert "floor_div" in str(exc)
+    else:
+        raise AssertionError("expected an unexported name error")
+
+    # Other imports still need a base directory, and modules can use the stdlib
+    try:
+        resolve_imports(_program([], {"type": "Import", "path": "helpers"}))
+    except ValueError as exc:
+        assert "base_dir" in str(exc)
+    else:
+        raise AssertionError("expected a missing base_dir error")
+    with tempfile.TemporaryDirectory() as tmp_dir:
+        helpers = _program(["strings"], {"type": "FuncDef", "name": "shout", "params": ["text"], "body": [
+            {"type": "Return", "value": _call("strings.repeat", {"type": "Var", "name": "text"}, _lit(3))},
+        ]})
+        (Path(tmp_dir) / "helpers.coreil.json").write_text(json.dumps(helpers), encoding="utf-8")
+        doc = _program([], {"type": "Import", "path": "helpers"}, _print(_call("helpers.shout", _lit("hey"))))
+        result = run_interpreter(resolve_imports(doc, base_dir=Path(tmp_dir)))
+        assert result.output == "heyheyhey\n", result.error
+
+
+def test_source_map_follows_linking():
+    doc = _program(["math", "strings"], _print(_lit(1)), _print(_call("math.sign", _lit(-2))))
+    doc["source_map"] = {"1": [0, 1], "2": [2], "3": [3]}
+    linked = resolve_imports(doc)
+    body = linked["body"]
+    # The definitions take the imports' place and sentence
+    assert body[-2] == _print(_lit(1)) and linked["source_map"]["2"] == [len(body) - 2]
+    assert linked["source_map"]["3"] == [len(body) - 1]
+    assert linked["source_map"]["1"] == list(range(len(body) - 2))
+
+
+def test_go_parity():
+    if not GO_AVAILABLE:
+        return
+    # Records print differently in the interpreter, so compare formatted values
+    modules = sorted(CASES)
+    doc = resolve_imports(_program(modules, *(_print(expr) for cases in CASES.values() for expr, _ in cases)))
+    interpreted = run_interpreter(doc)
+    compiled = run_go_backend(doc, timeout=120)
+    assert compiled.success, compiled.error
+    assert compiled.output == interpreted.output
+
+
+def main() -> None:
+    tests = [
+        test_modules_are_documented,
+        test_modules_run,
+        test_resolution,
+        test_source_map_follows_linking,
+        test_go_parity,
+    ]
+
+    print("Running standard library tests...\n")
+    for test in tests:
+        test()
+        print(f"  {test.__name__}: ✓")
+
+    print(f"\nAll {len(tests)} standard library tests passed! ✓")
+
+
+if __name__ == "__main__":
+    main()
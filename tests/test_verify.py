@@ -0,0 +1,175 @@
+"""Tests for the Core IL verifier.
+
+Run with: python -m tests.test_verify
+"""
+
+from __future__ import annotations
+
+import io
+from contextlib import redirect_stdout
+
+from english_compiler.coreil.interp import run_coreil
+from english_compiler.coreil.verify import format_violation, verify_coreil
+
+
+def _make_doc(body: list, **extra) -> dict:
+    return {"version": "coreil-1.9", "body": body, **extra}
+
+
+def _lit(value) -> dict:
+    return {"type": "Literal", "value": value}
+
+
+def _call(name: str, *args: dict) -> dict:
+    return {"type": "Call", "name": name, "args": list(args)}
+
+
+def _print(*args: dict) -> dict:
+    return {"type": "Print", "args": list(args)}
+
+
+def _messages(doc: dict) -> list[str]:
+    return [format_violation(v) for v in verify_coreil(doc)]
+
+
+def test_valid_program():
+    doc = _make_doc([
+        {"type": "FuncDef", "name": "add", "params": ["a", "b"], "body": [
+            {"type": "Return", "value": {
+                "type": "Binary", "op": "+",
+                "left": {"type": "Var", "name": "a"}, "right": {"type": "Var", "name": "b"},
+            }},
+        ]},
+        {"type": "Let", "name": "xs", "value": {"type": "Array", "items": [_lit(None), _lit(1.5)]}},
+        {"type": "Push", "base": {"type": "Var", "name": "xs"}, "value": _lit("s")},
+        _print(_call("add", _lit(1), _lit(2)), {"type": "Var", "name": "xs"}),
+    ])
+    assert verify_coreil(doc) == []
+
+
+def test_call_arity():
+    # Before v0.5, append was a builtin
+    doc = _make_doc(version="coreil-0.4", body=[
+        {"type": "FuncDef", "name": "f", "params": ["x"], "body": []},
+        _print(_call("f")),
+        _call("append", _lit(1)),
+        _print(_call("input", _lit("a"), _lit("b"))),
+        _print(_call("g", _lit(1))),
+    ])
+    assert _messages(doc) == [
+        "$.body[1].args[0].args: function 'f' takes 1 argument(s), got 0",
+        "$.body[2].args: builtin 'append' takes 2 argument(s), got 1",
+        "$.body[3].args[0].args: builtin 'input' takes 0 to 1 arguments, got 2",
+        "$.body[4].args[0]: call to undefined function 'g'",
+    ]
+
+
+def test_function_defined_in_branches():
+    # Either definition's arity is accepted; the call comes before the
+    # definition textually but runs after it
+    doc = _make_doc([
+        {"type": "If", "test": _lit(True), "then": [
+            {"type": "FuncDef", "name": "h", "params": [], "body": [
+                {"type": "Return", "value": _call("h2", _lit(1))},
+            ]},
+        ], "else": [
+            {"type": "FuncDef", "name": "h", "params": ["x"], "body": []},
+        ]},
+        {"type": "FuncDef", "name": "h2", "params": ["x"], "body": []},
+        _print(_call("h"), _call("h", _lit(1))),
+    ])
+    assert verify_coreil(doc) == []
+
+
+def test_literal_types():
+    doc = _make_doc([
+        _print(_lit([1, 2]), _lit({"a": 1}), _lit(True), _lit(None)),
+    ])
+    assert _messages(doc) == [
+        "$.body[0].args[0].value: Literal value must be null, a boolean, a number or a string, not list",
+        "$.body[0].args[1].value: Literal value must be null, a boolean, a number or a string, not dict",
+    ]
+
+
+def test_includes_validation():
+    doc = _make_doc([
+        _print({"type": "Var", "name": "missing"}),
+        {"type": "Break"},
+        {"type": "While", "test": _lit(False), "body": [{"type": "Continue"}]},
+    ])
+    assert _messages(doc) == [
+        "$.body[0].args[0]: variable 'missing' used before definition",
+        "$.body[1]: Break is only allowed inside a loop (While, For, ForEach)",
+    ]
+
+
+def test_source_lines():
+    doc = _make_doc(
+        [
+            {"type": "Let", "name": "x", "value": _lit(1)},
+            _print(_call("nope")),
+        ],
+        source_map={"1": [0], "3": [1]},
+    )
+    violations = verify_coreil(doc)
+    assert violations == [
+        {"message": "call to undefined function 'nope'", "path": "$.body[1].args[0]", "line": 3},
+    ]
+    assert format_violation(violations[0]) == "$.body[1].args[0]: call to undefined function 'nope' (line 3)"
+
+
+def test_operand_types():
+    doc = _make_doc([
+        {"type": "Let", "name": "x", "value": _lit("5")},
+        _print({"type": "Binary", "op": "-", "left": {"type": "Var", "name": "x"}, "right": _lit(1)}),
+    ])
+    assert _messages(doc) == ["$.body[1].args[0]: operator '-' cannot be applied to str and int"]
+    # Only checked once everything else is valid
+    doc["body"].append(_print(_call("nope")))
+    assert _messages(doc) == ["$.body[2].args[0]: call to undefined function 'nope'"]
+
+
+def test_run_fails_fast():
+    # Nothing is printed before the error, and every violation is reported
+    doc = _make_doc([
+        _print(_lit("before")),
+        _print(_call("f", _lit(1))),
+        _print(_lit([1])),
+    ])
+    buf = io.StringIO()
+    with redirect_stdout(buf):
+        rc = run_coreil(doc)
+    assert rc == 1
+    assert buf.getvalue() == (
+        "invalid program:\n"
+        "  $.body[1].args[0]: call to undefined function 'f'\n"
+        "  $.body[2].args[0].value: Literal value must be null, a boolean, a number or a string, not list\n"
+    ), buf.getvalue()
+
+    errors = []
+    assert run_coreil(doc, error_callback=errors.append) == 1
+    assert len(errors) == 1 and errors[0].startswith("invalid program:\n"), errors
+
+
+def main() -> None:
+    tests = [
+        test_valid_program,
+        test_call_arity,
+        test_function_defined_in_branches,
+        test_literal_types,
+        test_includes_validation,
+        test_source_lines,
+        test_operand_types,
+        test_run_fails_fast,
+    ]
+
+    print("Running verifier tests...\n")
+    for test in tests:
+        test()
+        print(f"  {test.__name__}: ✓")
+
+    print(f"\nAll {len(tests)} verifier tests passed! ✓")
+
+
+if __name__ == "__main__":
+    main()